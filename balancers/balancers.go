@@ -3,8 +3,10 @@ package balancers
 import (
 	"container/list"
 	"fmt"
+	"maps"
 	"math/rand/v2"
 	"slices"
+	"sync"
 
 	"github.com/samber/lo"
 )
@@ -15,6 +17,11 @@ type Balancer interface {
 	Reduce(key uint)
 }
 
+// Reweighter 可在保留选择状态的同时调整权重的负载均衡器 items 中没有的项保持不变
+type Reweighter interface {
+	Reweight(items map[uint]int)
+}
+
 // 按权重概率抽取，类似抽签。
 type Lottery map[uint]int
 
@@ -23,6 +30,11 @@ func NewLottery(items map[uint]int) Balancer {
 }
 
 func (w Lottery) Pop() (uint, error) {
+	return w.pick(rand.IntN)
+}
+
+// pick 按权重抽取 按 id 顺序累加权重 随机源固定时结果可复现
+func (w Lottery) pick(intN func(n int) int) (uint, error) {
 	if len(w) == 0 {
 		return 0, fmt.Errorf("no provide items or all items are disabled")
 	}
@@ -33,12 +45,12 @@ func (w Lottery) Pop() (uint, error) {
 	if total <= 0 {
 		return 0, fmt.Errorf("total provide weight must be greater than 0")
 	}
-	r := rand.IntN(total)
-	for k, v := range w {
-		if r < v {
+	r := intN(total)
+	for _, k := range slices.Sorted(maps.Keys(w)) {
+		if r < w[k] {
 			return k, nil
 		}
-		r -= v
+		r -= w[k]
 	}
 	return 0, fmt.Errorf("unexpected error")
 }
@@ -47,10 +59,60 @@ func (w Lottery) Delete(key uint) {
 	delete(w, key)
 }
 
+func (w Lottery) Reweight(items map[uint]int) {
+	for k := range w {
+		if v, ok := items[k]; ok {
+			w[k] = v
+		}
+	}
+}
+
 func (w Lottery) Reduce(key uint) {
 	w[key] -= w[key] / 3
 }
 
+// seededLottery 使用指定随机源抽取的 Lottery
+type seededLottery struct {
+	Lottery
+	rand *rand.Rand
+}
+
+func (w seededLottery) Pop() (uint, error) {
+	return w.pick(w.rand.IntN)
+}
+
+// NewLotteryWithSource 使用指定随机源创建 Lottery 固定种子时抽取序列可复现 便于测试与排查问题
+// src 为空时与 NewLottery 相同 使用全局随机源
+func NewLotteryWithSource(items map[uint]int, src rand.Source) Balancer {
+	if src == nil {
+		return NewLottery(items)
+	}
+	return seededLottery{Lottery: Lottery(items), rand: rand.New(src)}
+}
+
+// LotteryFactory 返回共享同一随机源的 Lottery 构造函数 随机源加锁后可在多个请求间并发使用
+func LotteryFactory(src rand.Source) Factory {
+	if src == nil {
+		return NewLottery
+	}
+	r := rand.New(&lockedSource{src: src})
+	return func(items map[uint]int) Balancer {
+		return seededLottery{Lottery: Lottery(items), rand: r}
+	}
+}
+
+// lockedSource 并发安全的随机源
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
+
 // 按顺序循环轮转，每次降低权重后移到队尾
 type Rotor struct{ *list.List }
 
@@ -83,6 +145,9 @@ func (w Rotor) Delete(key uint) {
 	}
 }
 
+// Reweight 轮转顺序只在创建时参考权重 调整权重不改变当前位置
+func (w Rotor) Reweight(items map[uint]int) {}
+
 func (w Rotor) Reduce(key uint) {
 	for e := w.Front(); e != nil; e = e.Next() {
 		if e.Value.(uint) == key {
@@ -116,11 +181,9 @@ func (rr *SmoothWeightedRR) recompute(resetCurrent bool) {
 
 func NewSmoothWeightedRR(items map[uint]int) Balancer {
 	rr := &SmoothWeightedRR{}
+	// 权重为 0 的项保留在列表中 便于之后调整权重后恢复
 	for id, w := range items {
-		if w <= 0 {
-			continue
-		}
-		rr.items = append(rr.items, &smoothWeightItem{id: id, weight: w})
+		rr.items = append(rr.items, &smoothWeightItem{id: id, weight: max(w, 0)})
 	}
 	rr.recompute(true)
 	return rr
@@ -132,6 +195,9 @@ func (rr *SmoothWeightedRR) Pop() (uint, error) {
 	}
 	var picked *smoothWeightItem
 	for _, item := range rr.items {
+		if item.weight == 0 {
+			continue
+		}
 		item.current += item.weight
 		if picked == nil || item.current > picked.current {
 			picked = item
@@ -153,6 +219,20 @@ func (rr *SmoothWeightedRR) Delete(key uint) {
 	rr.recompute(true)
 }
 
+// Reweight 调整权重但保留各项的当前值 轮询顺序平滑过渡
+// 权重降为 0 的项不再被选中
+func (rr *SmoothWeightedRR) Reweight(items map[uint]int) {
+	for _, item := range rr.items {
+		if w, ok := items[item.id]; ok {
+			item.weight = max(w, 0)
+			if item.weight == 0 {
+				item.current = 0
+			}
+		}
+	}
+	rr.recompute(false)
+}
+
 func (rr *SmoothWeightedRR) Reduce(key uint) {
 	for _, item := range rr.items {
 		if item.id == key {
@@ -164,3 +244,161 @@ func (rr *SmoothWeightedRR) Reduce(key uint) {
 	}
 	rr.recompute(true)
 }
+
+// Tiered 分层严格降级 当前层级的提供商全部剔除后才选择下一层级 层级内使用原有策略
+type Tiered struct {
+	tiers   []*tier
+	factory Factory
+}
+
+type tier struct {
+	balancer Balancer
+	keys     map[uint]struct{}
+}
+
+// NewTiered 按 levels 将权重项分层 数值越小越优先 未指定层级的视为 0
+func NewTiered(items map[uint]int, levels map[uint]int, factory Factory) *Tiered {
+	grouped := make(map[int]map[uint]int)
+	for k, v := range items {
+		level := levels[k]
+		if grouped[level] == nil {
+			grouped[level] = make(map[uint]int)
+		}
+		grouped[level][k] = v
+	}
+	t := &Tiered{factory: factory}
+	for _, level := range slices.Sorted(maps.Keys(grouped)) {
+		keys := make(map[uint]struct{}, len(grouped[level]))
+		for k := range grouped[level] {
+			keys[k] = struct{}{}
+		}
+		t.tiers = append(t.tiers, &tier{balancer: factory(grouped[level]), keys: keys})
+	}
+	return t
+}
+
+func (t *Tiered) Pop() (uint, error) {
+	for _, tier := range t.tiers {
+		if len(tier.keys) == 0 {
+			continue
+		}
+		if id, err := tier.balancer.Pop(); err == nil {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("no provide items or all items are disabled")
+}
+
+func (t *Tiered) Delete(key uint) {
+	if tier := t.find(key); tier != nil {
+		tier.balancer.Delete(key)
+		delete(tier.keys, key)
+	}
+}
+
+func (t *Tiered) Reduce(key uint) {
+	if tier := t.find(key); tier != nil {
+		tier.balancer.Reduce(key)
+	}
+}
+
+// Reweight 调整各层级内的权重 层级内的负载均衡器不支持调整时按新权重重建
+func (t *Tiered) Reweight(items map[uint]int) {
+	for _, tier := range t.tiers {
+		if r, ok := tier.balancer.(Reweighter); ok {
+			r.Reweight(items)
+			continue
+		}
+		weights := make(map[uint]int, len(tier.keys))
+		for k := range tier.keys {
+			weights[k] = items[k]
+		}
+		tier.balancer = t.factory(weights)
+	}
+}
+
+func (t *Tiered) find(key uint) *tier {
+	for _, tier := range t.tiers {
+		if _, ok := tier.keys[key]; ok {
+			return tier
+		}
+	}
+	return nil
+}
+
+// Pinned 优先返回固定的 key 该 key 被剔除或降权后回退到内部的负载均衡器
+type Pinned struct {
+	Balancer
+	key    uint
+	pinned bool
+}
+
+func NewPinned(inner Balancer, key uint) *Pinned {
+	return &Pinned{Balancer: inner, key: key, pinned: true}
+}
+
+func (p *Pinned) Pop() (uint, error) {
+	if p.pinned {
+		return p.key, nil
+	}
+	return p.Balancer.Pop()
+}
+
+func (p *Pinned) Delete(key uint) {
+	if key == p.key {
+		p.pinned = false
+	}
+	p.Balancer.Delete(key)
+}
+
+func (p *Pinned) Reduce(key uint) {
+	if key == p.key {
+		p.pinned = false
+	}
+	p.Balancer.Reduce(key)
+}
+
+// Deferred 暂缓选择指定的 key 内部负载均衡器的其余 key 全部剔除后才按弹出顺序返回这些 key
+type Deferred struct {
+	Balancer
+	deferred map[uint]struct{}
+	backlog  []uint
+}
+
+func NewDeferred(inner Balancer, keys []uint) *Deferred {
+	deferred := make(map[uint]struct{}, len(keys))
+	for _, key := range keys {
+		deferred[key] = struct{}{}
+	}
+	return &Deferred{Balancer: inner, deferred: deferred}
+}
+
+func (d *Deferred) Pop() (uint, error) {
+	for {
+		key, err := d.Balancer.Pop()
+		if err != nil {
+			if len(d.backlog) > 0 {
+				return d.backlog[0], nil
+			}
+			return 0, err
+		}
+		if _, ok := d.deferred[key]; !ok {
+			return key, nil
+		}
+		d.Balancer.Delete(key)
+		d.backlog = append(d.backlog, key)
+	}
+}
+
+func (d *Deferred) Delete(key uint) {
+	d.backlog = slices.DeleteFunc(d.backlog, func(k uint) bool { return k == key })
+	d.Balancer.Delete(key)
+}
+
+func (d *Deferred) Reduce(key uint) {
+	if i := slices.Index(d.backlog, key); i >= 0 {
+		d.backlog = append(slices.Delete(d.backlog, i, i+1), key)
+		return
+	}
+	d.Balancer.Reduce(key)
+}
@@ -1,6 +1,9 @@
 package balancers
 
 import (
+	"maps"
+	"math/rand/v2"
+	"slices"
 	"testing"
 )
 
@@ -39,6 +42,46 @@ func TestLotteryDelete(t *testing.T) {
 	}
 }
 
+func TestLotterySeeded(t *testing.T) {
+	items := map[uint]int{1: 1, 2: 2, 3: 7}
+	want := []uint{3, 3, 3, 3, 2, 1, 3, 3, 2, 2, 3, 3}
+
+	pops := func(w Balancer) []uint {
+		var got []uint
+		for range len(want) {
+			id, err := w.Pop()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got = append(got, id)
+		}
+		return got
+	}
+
+	// 固定种子的抽取序列与 map 遍历顺序无关
+	for range 3 {
+		if got := pops(NewLotteryWithSource(maps.Clone(items), rand.NewPCG(1, 2))); !slices.Equal(got, want) {
+			t.Fatalf("seeded sequence = %v, want %v", got, want)
+		}
+	}
+	// 同一 Factory 创建的实例共享随机源 序列接续而不是重复
+	factory := LotteryFactory(rand.NewPCG(1, 2))
+	first, second := pops(factory(maps.Clone(items))), pops(factory(maps.Clone(items)))
+	if !slices.Equal(first, want) || slices.Equal(second, want) {
+		t.Errorf("factory sequences = %v, %v", first, second)
+	}
+
+	// 剔除与降权作用于被抽取的权重项
+	w := NewLotteryWithSource(map[uint]int{1: 1, 2: 2, 3: 7}, rand.NewPCG(1, 2))
+	w.Delete(3)
+	w.Reduce(2)
+	for _, id := range pops(w) {
+		if id == 3 {
+			t.Fatal("deleted item was picked")
+		}
+	}
+}
+
 func TestRotor(t *testing.T) {
 	t.Run("NewRotor", func(t *testing.T) {
 		items := map[uint]int{
@@ -287,3 +330,90 @@ func BenchmarkRotor(b *testing.B) {
 		}
 	})
 }
+
+func TestTiered(t *testing.T) {
+	items := map[uint]int{1: 1, 2: 5, 3: 1, 4: 10}
+	levels := map[uint]int{1: 0, 2: 0, 3: 1, 4: 2}
+	factories := map[string]Factory{
+		"lottery": NewLottery,
+		"rotor":   func(items map[uint]int) Balancer { return NewRotor(items) },
+		"swrr":    NewSmoothWeightedRR,
+	}
+	for name, factory := range factories {
+		t.Run(name, func(t *testing.T) {
+			tiered := NewTiered(items, levels, factory)
+			// 第一层可用时不会选到低层级 即使低层级权重更高
+			for range 50 {
+				id, err := tiered.Pop()
+				if err != nil {
+					t.Fatalf("Pop() error = %v", err)
+				}
+				if id != 1 && id != 2 {
+					t.Fatalf("Pop() = %d, want tier 0 item", id)
+				}
+				tiered.Reduce(id)
+			}
+
+			// 第一层全部剔除后降级到第二层
+			tiered.Delete(1)
+			tiered.Delete(2)
+			if id, err := tiered.Pop(); err != nil || id != 3 {
+				t.Fatalf("Pop() = %d, %v, want 3", id, err)
+			}
+			tiered.Delete(3)
+			if id, err := tiered.Pop(); err != nil || id != 4 {
+				t.Fatalf("Pop() = %d, %v, want 4", id, err)
+			}
+			tiered.Delete(4)
+			if _, err := tiered.Pop(); err == nil {
+				t.Fatal("expected error when all tiers are exhausted")
+			}
+		})
+	}
+}
+
+func TestPinned(t *testing.T) {
+	for name, drop := range map[string]func(b Balancer, key uint){
+		"delete": func(b Balancer, key uint) { b.Delete(key) },
+		"reduce": func(b Balancer, key uint) { b.Reduce(key) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			pinned := NewPinned(NewLottery(map[uint]int{1: 100, 2: 1}), 2)
+			// 固定的 key 优先于权重
+			for range 5 {
+				if id, err := pinned.Pop(); err != nil || id != 2 {
+					t.Fatalf("Pop() = %d, %v, want pinned 2", id, err)
+				}
+			}
+			// 剔除或降权后回退到内部的负载均衡器
+			drop(pinned, 2)
+			pinned.Delete(2)
+			if id, err := pinned.Pop(); err != nil || id != 1 {
+				t.Fatalf("Pop() = %d, %v, want 1 after unpin", id, err)
+			}
+		})
+	}
+}
+
+func TestDeferred(t *testing.T) {
+	deferred := NewDeferred(NewRotor(map[uint]int{1: 1, 2: 1, 3: 1}), []uint{1, 2})
+	// 未暂缓的 key 优先
+	if id, err := deferred.Pop(); err != nil || id != 3 {
+		t.Fatalf("Pop() = %d, %v, want 3", id, err)
+	}
+	deferred.Delete(3)
+	// 其余 key 剔除后返回暂缓的 key 降权的移到最后
+	first, err := deferred.Pop()
+	if err != nil || (first != 1 && first != 2) {
+		t.Fatalf("Pop() = %d, %v, want deferred key", first, err)
+	}
+	deferred.Reduce(first)
+	if id, err := deferred.Pop(); err != nil || id != 3-first {
+		t.Fatalf("Pop() = %d, %v, want %d after reducing %d", id, err, 3-first, first)
+	}
+	deferred.Delete(2)
+	deferred.Delete(1)
+	if _, err := deferred.Pop(); err == nil {
+		t.Fatal("expected error when all keys are removed")
+	}
+}
@@ -0,0 +1,44 @@
+package balancers
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/atopos31/llmio/consts"
+)
+
+// registry 按策略名注册的负载均衡器构造函数 可在 init 中并发安全地注册
+var registry = struct {
+	sync.RWMutex
+	factories map[string]Factory
+}{factories: make(map[string]Factory)}
+
+func init() {
+	Register(consts.BalancerLottery, NewLottery)
+	Register(consts.BalancerRotor, func(items map[uint]int) Balancer {
+		return NewRotor(items)
+	})
+	Register(consts.BalancerSmoothWeightedRR, NewSmoothWeightedRR)
+}
+
+// Register 注册负载均衡策略 模型的 Strategy 为该名称时使用 factory 创建负载均衡器
+// 名称为空、factory 为 nil 或重复注册时 panic
+func Register(name string, factory Factory) {
+	if name == "" || factory == nil {
+		panic("balancers: Register with empty name or nil factory")
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.factories[name]; ok {
+		panic(fmt.Sprintf("balancers: Register called twice for strategy %s", name))
+	}
+	registry.factories[name] = factory
+}
+
+// Lookup 返回策略对应的构造函数 未注册时返回 false
+func Lookup(name string) (Factory, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	factory, ok := registry.factories[name]
+	return factory, ok
+}
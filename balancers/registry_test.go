@@ -0,0 +1,50 @@
+package balancers
+
+import (
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+)
+
+func TestRegistry(t *testing.T) {
+	for _, name := range []string{consts.BalancerLottery, consts.BalancerRotor, consts.BalancerSmoothWeightedRR} {
+		if _, ok := Lookup(name); !ok {
+			t.Errorf("built-in strategy %s not registered", name)
+		}
+	}
+	if _, ok := Lookup("missing"); ok {
+		t.Error("Lookup(missing) should fail")
+	}
+
+	Register("registry_test", NewLottery)
+	t.Cleanup(func() {
+		registry.Lock()
+		delete(registry.factories, "registry_test")
+		registry.Unlock()
+	})
+	factory, ok := Lookup("registry_test")
+	if !ok {
+		t.Fatal("registered strategy not found")
+	}
+	if id, err := factory(map[uint]int{7: 1}).Pop(); err != nil || id != 7 {
+		t.Errorf("Pop() = %d, %v", id, err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		factory Factory
+	}{
+		{"registry_test", NewLottery},
+		{"", NewLottery},
+		{"nil_factory", nil},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Register(%q) should panic", tt.name)
+				}
+			}()
+			Register(tt.name, tt.factory)
+		}()
+	}
+}
@@ -0,0 +1,135 @@
+package balancers
+
+import (
+	"container/list"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// maxStoreEntries 状态条目上限，超出后整体重置，避免权重配置频繁变更导致无限增长
+const maxStoreEntries = 1024
+
+// Factory 根据权重项创建负载均衡器
+type Factory func(items map[uint]int) Balancer
+
+// sharedState 跨请求共享的负载均衡状态
+type sharedState struct {
+	mu       sync.Mutex
+	balancer Balancer
+	items    map[uint]int
+	factory  Factory
+}
+
+// Store 并发安全的负载均衡状态存储，按 key 跨请求复用轮转状态
+type Store struct {
+	mu     sync.Mutex
+	states map[string]*sharedState
+}
+
+func NewStore() *Store {
+	return &Store{states: make(map[string]*sharedState)}
+}
+
+// Session 获取单次请求使用的负载均衡器
+// key 相同且关联集合一致的请求共享同一份状态，关联集合变化时使用新的状态
+// 权重随额度等频繁变化 只在共享状态上调整 不产生新的状态
+func (s *Store) Session(key string, items map[uint]int, factory Factory) Balancer {
+	stateKey := key + "|" + signature(items)
+
+	s.mu.Lock()
+	state, ok := s.states[stateKey]
+	if !ok {
+		if len(s.states) >= maxStoreEntries {
+			clear(s.states)
+		}
+		state = &sharedState{
+			balancer: factory(maps.Clone(items)),
+			items:    maps.Clone(items),
+			factory:  factory,
+		}
+		s.states[stateKey] = state
+	}
+	s.mu.Unlock()
+
+	state.reweight(items)
+	return &session{state: state}
+}
+
+// reweight 权重变化时调整共享状态 保留轮转位置 不支持调整的负载均衡器按新权重重建
+func (st *sharedState) reweight(items map[uint]int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if maps.Equal(st.items, items) {
+		return
+	}
+	st.items = maps.Clone(items)
+	if r, ok := st.balancer.(Reweighter); ok {
+		r.Reweight(st.items)
+		return
+	}
+	st.balancer = st.factory(maps.Clone(items))
+}
+
+// signature 生成关联集合的稳定签名 不包含权重
+func signature(items map[uint]int) string {
+	keys := slices.Sorted(maps.Keys(items))
+	var sb strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&sb, "%d,", k)
+	}
+	return sb.String()
+}
+
+// session 单次请求的负载均衡视图
+// 选择在共享状态上进行以跨请求保持轮转；请求内的剔除与降权只作用于本地副本，不影响其他请求
+type session struct {
+	state *sharedState
+	local Balancer
+}
+
+func (s *session) Pop() (uint, error) {
+	if s.local != nil {
+		return s.local.Pop()
+	}
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+	return s.state.balancer.Pop()
+}
+
+func (s *session) Delete(key uint) {
+	s.ensureLocal()
+	s.local.Delete(key)
+}
+
+func (s *session) Reduce(key uint) {
+	s.ensureLocal()
+	s.local.Reduce(key)
+	// Rotor 的降权即轮转位置变化，需要跨请求保留
+	if rotor, ok := s.state.balancer.(Rotor); ok {
+		s.state.mu.Lock()
+		rotor.Reduce(key)
+		s.state.mu.Unlock()
+	}
+}
+
+// ensureLocal 首次修改时从共享状态派生本地副本
+func (s *session) ensureLocal() {
+	if s.local != nil {
+		return
+	}
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+	if rotor, ok := s.state.balancer.(Rotor); ok {
+		// 保留共享的轮转顺序
+		l := list.New()
+		for e := rotor.Front(); e != nil; e = e.Next() {
+			l.PushBack(e.Value)
+		}
+		s.local = Rotor{l}
+		return
+	}
+	s.local = s.state.factory(maps.Clone(s.state.items))
+}
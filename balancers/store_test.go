@@ -0,0 +1,151 @@
+package balancers
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestStoreSmoothWeightedRRDistribution(t *testing.T) {
+	store := NewStore()
+	items := map[uint]int{1: 5, 2: 3, 3: 2}
+
+	counts := make(map[uint]int)
+	const rounds = 100
+	for i := 0; i < rounds; i++ {
+		// 每次请求获取新的 session，轮转状态应在请求间保留
+		id, err := store.Session("model", items, NewSmoothWeightedRR).Pop()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		counts[id]++
+	}
+
+	for id, weight := range items {
+		want := rounds * weight / 10
+		if counts[id] != want {
+			t.Errorf("id %d: expected %d picks, got %d", id, want, counts[id])
+		}
+	}
+}
+
+func TestStoreSmoothWeightedRRConcurrent(t *testing.T) {
+	store := NewStore()
+	items := map[uint]int{1: 3, 2: 1}
+
+	var mu sync.Mutex
+	counts := make(map[uint]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 400; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, err := store.Session("model", items, NewSmoothWeightedRR).Pop()
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			mu.Lock()
+			counts[id]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if counts[1] != 300 || counts[2] != 100 {
+		t.Errorf("expected 300/100 split, got %d/%d", counts[1], counts[2])
+	}
+}
+
+func TestStoreSessionDeleteIsLocal(t *testing.T) {
+	store := NewStore()
+	items := map[uint]int{1: 1, 2: 1}
+
+	s := store.Session("model", items, NewSmoothWeightedRR)
+	first, _ := s.Pop()
+	s.Delete(first)
+	second, err := s.Pop()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if second == first {
+		t.Fatalf("expected deleted id %d to be skipped", first)
+	}
+
+	// 其他请求仍能选择被剔除的项
+	seen := make(map[uint]bool)
+	for i := 0; i < 4; i++ {
+		id, _ := store.Session("model", items, NewSmoothWeightedRR).Pop()
+		seen[id] = true
+	}
+	if !seen[first] {
+		t.Errorf("expected id %d to remain available for other sessions", first)
+	}
+	if len(items) != 2 {
+		t.Errorf("expected caller items to be untouched, got %v", items)
+	}
+}
+
+func TestStoreRotorReducePersists(t *testing.T) {
+	store := NewStore()
+	items := map[uint]int{1: 10, 2: 20, 3: 30}
+	factory := func(items map[uint]int) Balancer { return NewRotor(items) }
+
+	s := store.Session("model", items, factory)
+	id, _ := s.Pop()
+	if id != 3 {
+		t.Fatalf("expected 3, got %d", id)
+	}
+	s.Reduce(id)
+	if next, _ := s.Pop(); next != 2 {
+		t.Errorf("expected 2 after local reduce, got %d", next)
+	}
+
+	if next, _ := store.Session("model", items, factory).Pop(); next != 2 {
+		t.Errorf("expected rotation to persist to next session, got %d", next)
+	}
+}
+
+func TestStoreWeightChangeUsesNewState(t *testing.T) {
+	store := NewStore()
+	store.Session("model", map[uint]int{1: 1, 2: 1}, NewSmoothWeightedRR).Pop()
+
+	id, err := store.Session("model", map[uint]int{3: 1}, NewSmoothWeightedRR).Pop()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id != 3 {
+		t.Errorf("expected 3, got %d", id)
+	}
+}
+
+func TestStoreScaledWeightsShareState(t *testing.T) {
+	store := NewStore()
+
+	counts := make(map[uint]int)
+	for i := 0; i < 100; i++ {
+		// 权重每次变化但关联集合不变，应复用同一份状态
+		items := map[uint]int{1: 5, 2: 5 - i%5}
+		id, err := store.Session("model", items, NewSmoothWeightedRR).Pop()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		counts[id]++
+	}
+	if len(store.states) != 1 {
+		t.Fatalf("expected 1 shared state, got %d", len(store.states))
+	}
+	if counts[1] <= counts[2] || counts[2] == 0 {
+		t.Errorf("expected scaled weights to apply, got %v", counts)
+	}
+
+	// 权重降为 0 的项不再被选中
+	for i := 0; i < 10; i++ {
+		id, err := store.Session("model", map[uint]int{1: 0, 2: 1}, NewSmoothWeightedRR).Pop()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if id != 2 {
+			t.Fatalf("expected 2, got %d", id)
+		}
+	}
+}
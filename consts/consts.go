@@ -6,6 +6,12 @@ const (
 	StyleOpenAI    Style = "openai"
 	StyleOpenAIRes Style = "openai-res"
 	StyleAnthropic Style = "anthropic"
+	// 通过 AWS Bedrock 提供 Anthropic 格式接口的提供商类型
+	StyleBedrock Style = "bedrock"
+	// legacy completions(含 FIM suffix)请求 由 openai 类型提供商提供
+	StyleOpenAICompletion Style = "openai-completion"
+	// 按配置返回固定响应的提供商类型 仅用于测试 可服务任意接口风格
+	StyleMock Style = "mock"
 )
 
 const (
@@ -21,7 +27,26 @@ const (
 	BalancerDefault = BalancerLottery
 )
 
+const (
+	// 不缓存
+	CachePolicyNever = "never"
+	// 仅缓存非流式请求
+	CachePolicyNonStream = "non_stream"
+	// 流式与非流式均缓存 流式命中时重放完整的 SSE 响应
+	CachePolicyAlways = "always"
+	// 默认策略
+	CachePolicyDefault = CachePolicyNonStream
+)
+
 const (
 	KeyPrefix = "sk-llmio-"
 	KeyLength = 32
 )
+
+// Version 构建版本 发布时通过 -ldflags "-X github.com/atopos31/llmio/consts.Version=..." 注入
+var Version = "dev"
+
+// UserAgent 未配置时发往上游的默认 User-Agent
+func UserAgent() string {
+	return "llmio/" + Version
+}
@@ -6,4 +6,12 @@ const (
 	ContextKeyAllowAllModel ContextKey = "allow_all_model"
 	ContextKeyAllowModels   ContextKey = "allow_models"
 	ContextKeyAuthKeyID     ContextKey = "auth_key_id"
+	ContextKeyModeration    ContextKey = "moderation"
+	ContextKeyNoCache       ContextKey = "no_cache"
+	// 是否允许通过请求头排除提供商
+	ContextKeyExcludeProviders ContextKey = "exclude_providers"
+	// auth key 配置的模型别名
+	ContextKeyModelAliases ContextKey = "model_aliases"
+	// auth key 配置的费用预算
+	ContextKeyBudget ContextKey = "budget"
 )
@@ -1,6 +1,9 @@
 package handler
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math"
@@ -11,6 +14,7 @@ import (
 	"github.com/atopos31/llmio/consts"
 	"github.com/atopos31/llmio/models"
 	"github.com/atopos31/llmio/providers"
+	"github.com/atopos31/llmio/service"
 	"github.com/atopos31/llmio/service/keypool"
 	"github.com/gin-gonic/gin"
 	"github.com/samber/lo"
@@ -27,12 +31,37 @@ type ProviderRequest struct {
 
 // ModelRequest represents the request body for creating/updating a model
 type ModelRequest struct {
-	Name     string `json:"name"`
-	Remark   string `json:"remark"`
-	MaxRetry int    `json:"max_retry"`
-	TimeOut  int    `json:"time_out"`
-	IOLog    *bool  `json:"io_log"`
-	Strategy string `json:"strategy"`
+	Name      string `json:"name"`
+	Remark    string `json:"remark"`
+	MaxRetry  int    `json:"max_retry"`
+	TimeOut   int    `json:"time_out"`
+	IOLog     *bool  `json:"io_log"`
+	Strategy  string `json:"strategy"`
+	MaxBuffer int    `json:"max_buffer"`
+
+	StreamFailover    *bool `json:"stream_failover"`
+	StreamIdleTimeout int   `json:"stream_idle_timeout"`
+	FirstChunkTimeout int   `json:"first_chunk_timeout"`
+	GracefulTimeout   *bool `json:"graceful_timeout"`
+	StreamResume      *bool `json:"stream_resume"`
+	StickyTTL         int   `json:"sticky_ttl"`
+	Passthrough       *bool `json:"passthrough"`
+
+	CachePolicy   string               `json:"cache_policy"`
+	CacheSampling models.CacheSampling `json:"cache_sampling"`
+	ParamPolicy   models.ParamPolicy   `json:"param_policy"`
+	Metadata      models.ModelMetadata `json:"metadata"`
+
+	MinHealthyProviders int   `json:"min_healthy_providers"`
+	RefuseDegraded      *bool `json:"refuse_degraded"`
+	StreamStallTimeout  int   `json:"stream_stall_timeout"`
+
+	Fallback models.ModelFallback `json:"fallback"`
+
+	MaxConcurrency int `json:"max_concurrency"`
+	QueueTimeout   int `json:"queue_timeout"`
+
+	ContextPolicy models.ContextPolicy `json:"context_policy"`
 }
 
 // ModelWithProviderRequest represents the request body for creating/updating a model-provider association
@@ -43,9 +72,28 @@ type ModelWithProviderRequest struct {
 	ToolCall         bool              `json:"tool_call"`
 	StructuredOutput bool              `json:"structured_output"`
 	Image            bool              `json:"image"`
+	Completion       bool              `json:"completion"`
+	Audio            bool              `json:"audio"`
 	WithHeader       bool              `json:"with_header"`
+	NonStream        bool              `json:"non_stream"`
 	CustomerHeaders  map[string]string `json:"customer_headers"`
-	Weight           int               `json:"weight"`
+	ForwardHeaders   []string          `json:"forward_headers"`
+	Weight           *int              `json:"weight"` // 为空时创建默认 1 更新保持原值 0 表示备用
+	TimeOut          int               `json:"time_out"`
+	Tier             int               `json:"tier"`
+
+	ResponseRules   []models.ResponseRule `json:"response_rules"`
+	TransformStream bool                  `json:"transform_stream"`
+	Shadow          bool                  `json:"shadow"`
+
+	// 每百万 token 的价格
+	InputPrice  float64 `json:"input_price"`
+	OutputPrice float64 `json:"output_price"`
+}
+
+// ModelStatusRequest represents the request body for pausing or resuming a model
+type ModelStatusRequest struct {
+	Status bool `json:"status"`
 }
 
 // ModelProviderStatusRequest represents the request body for updating provider status
@@ -92,8 +140,17 @@ func GetProviders(c *gin.Context) {
 	common.Success(c, providers)
 }
 
+// GetProviderModels 获取提供商的模型列表 refresh=true 时跳过缓存
 func GetProviderModels(c *gin.Context) {
 	id := c.Param("id")
+	refresh := false
+	if value := c.Query("refresh"); value != "" {
+		var err error
+		if refresh, err = strconv.ParseBool(value); err != nil {
+			common.BadRequest(c, "Invalid refresh option: "+value)
+			return
+		}
+	}
 	provider, err := gorm.G[models.Provider](models.DB).Where("id = ?", id).First(c.Request.Context())
 	if err != nil {
 		common.InternalServerError(c, err.Error())
@@ -104,11 +161,24 @@ func GetProviderModels(c *gin.Context) {
 		common.InternalServerError(c, "Failed to get models: "+err.Error())
 		return
 	}
-	models, err := chatModel.Models(c.Request.Context())
+	client, err := providers.GetProviderClient(0, provider.Config)
+	if err != nil {
+		common.InternalServerError(c, "Failed to get models: "+err.Error())
+		return
+	}
+	// 模型列表按提供商缓存 refresh=true 时强制重新获取
+	models, cached, err := service.CachedProviderModels(c.Request.Context(), provider, refresh, func(ctx context.Context) ([]providers.Model, error) {
+		return chatModel.Models(providers.WithClient(ctx, client))
+	})
 	if err != nil {
 		common.NotFound(c, "Failed to get models: "+err.Error())
 		return
 	}
+	if cached {
+		c.Header("X-Cache", "HIT")
+	} else {
+		c.Header("X-Cache", "MISS")
+	}
 	common.Success(c, models)
 }
 
@@ -120,6 +190,16 @@ func CreateProvider(c *gin.Context) {
 		return
 	}
 
+	// 校验配置字段并确认能被对应类型解析
+	if err := validateProviderConfig(req.Type, req.Config); err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+	if _, err := providers.New(req.Type, req.Config); err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+
 	// Check if provider exists
 	count, err := gorm.G[models.Provider](models.DB).Where("name = ?", req.Name).Count(c.Request.Context(), "id")
 	if err != nil {
@@ -149,6 +229,7 @@ func CreateProvider(c *gin.Context) {
 		slog.Warn("Failed to sync provider keys", "error", err, "provider_id", provider.ID)
 	}
 
+	recordAudit(c, models.AuditActionCreate, models.AuditTargetProvider, provider.ID, nil, provider)
 	common.Success(c, provider)
 }
 
@@ -168,7 +249,8 @@ func UpdateProvider(c *gin.Context) {
 	}
 
 	// Check if provider exists
-	if _, err := gorm.G[models.Provider](models.DB).Where("id = ?", id).First(c.Request.Context()); err != nil {
+	existing, err := gorm.G[models.Provider](models.DB).Where("id = ?", id).First(c.Request.Context())
+	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			common.NotFound(c, "Provider not found")
 			return
@@ -177,6 +259,19 @@ func UpdateProvider(c *gin.Context) {
 		return
 	}
 
+	// 类型或配置变更时 按更新后的类型校验更新后的配置
+	if req.Type != "" || req.Config != "" {
+		providerType, config := cmp.Or(req.Type, existing.Type), cmp.Or(req.Config, existing.Config)
+		if err := validateProviderConfig(providerType, config); err != nil {
+			common.BadRequest(c, err.Error())
+			return
+		}
+		if _, err := providers.New(providerType, config); err != nil {
+			common.BadRequest(c, err.Error())
+			return
+		}
+	}
+
 	// Update fields
 	updates := models.Provider{
 		Name:    req.Name,
@@ -202,6 +297,7 @@ func UpdateProvider(c *gin.Context) {
 		slog.Warn("Failed to sync provider keys", "error", err, "provider_id", id)
 	}
 
+	recordAudit(c, models.AuditActionUpdate, models.AuditTargetProvider, id, existing, updatedProvider)
 	common.Success(c, updatedProvider)
 }
 
@@ -214,6 +310,16 @@ func DeleteProvider(c *gin.Context) {
 		return
 	}
 
+	existing, err := gorm.G[models.Provider](models.DB).Where("id = ?", id).First(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.NotFound(c, "Provider not found")
+			return
+		}
+		common.InternalServerError(c, "Database error: "+err.Error())
+		return
+	}
+
 	result, err := gorm.G[models.Provider](models.DB).Where("id = ?", id).Delete(c.Request.Context())
 	if err != nil {
 		common.InternalServerError(c, "Failed to delete provider: "+err.Error())
@@ -231,12 +337,21 @@ func DeleteProvider(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, models.AuditActionDelete, models.AuditTargetProvider, id, existing, nil)
 	common.Success(c, nil)
 }
 
 // GetModels 获取所有模型列表
 func GetModels(c *gin.Context) {
-	modelsList, err := gorm.G[models.Model](models.DB).Find(c.Request.Context())
+	query := gorm.G[models.Model](models.DB).Where("1 = 1")
+	// 按启用状态筛选 enabled 或 disabled
+	switch c.Query("status") {
+	case "enabled":
+		query = query.Where("status IS NULL OR status = ?", true)
+	case "disabled":
+		query = query.Where("status = ?", false)
+	}
+	modelsList, err := query.Find(c.Request.Context())
 	if err != nil {
 		common.InternalServerError(c, err.Error())
 		return
@@ -267,18 +382,77 @@ func CreateModel(c *gin.Context) {
 	if strategy == "" {
 		strategy = consts.BalancerDefault
 	}
+	if !validCachePolicy(req.CachePolicy) {
+		common.BadRequest(c, "Invalid cache policy: "+req.CachePolicy)
+		return
+	}
+	if err := service.ValidateCacheSampling(req.CacheSampling); err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+	if err := service.ValidateParamPolicy(req.ParamPolicy); err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+	if err := service.ValidateContextPolicy(req.ContextPolicy); err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+	if err := service.ValidateFallback(req.Fallback); err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+	if req.MinHealthyProviders < 0 {
+		common.BadRequest(c, "min_healthy_providers must not be negative")
+		return
+	}
+	if req.StickyTTL < 0 {
+		common.BadRequest(c, "sticky_ttl must not be negative")
+		return
+	}
+	if req.MaxConcurrency < 0 || req.QueueTimeout < 0 {
+		common.BadRequest(c, "max_concurrency and queue_timeout must not be negative")
+		return
+	}
+	if req.Metadata.ContextLength < 0 || req.Metadata.MaxOutput < 0 {
+		common.BadRequest(c, "metadata context_length and max_output must not be negative")
+		return
+	}
 	ioLog := req.IOLog
 	if ioLog == nil {
 		ioLog = new(bool) // 默认为 false
 	}
 
+	status := true
 	model := models.Model{
-		Name:     req.Name,
-		Remark:   req.Remark,
-		MaxRetry: req.MaxRetry,
-		TimeOut:  req.TimeOut,
-		IOLog:    ioLog,
-		Strategy: strategy,
+		Name:      req.Name,
+		Remark:    req.Remark,
+		MaxRetry:  req.MaxRetry,
+		TimeOut:   req.TimeOut,
+		IOLog:     ioLog,
+		Strategy:  strategy,
+		MaxBuffer: req.MaxBuffer,
+
+		StreamFailover:    req.StreamFailover,
+		StreamIdleTimeout: req.StreamIdleTimeout,
+		FirstChunkTimeout: req.FirstChunkTimeout,
+		GracefulTimeout:   req.GracefulTimeout,
+		StreamResume:      req.StreamResume,
+		StickyTTL:         req.StickyTTL,
+		Passthrough:       req.Passthrough,
+		CachePolicy:       req.CachePolicy,
+		CacheSampling:     req.CacheSampling,
+		ParamPolicy:       req.ParamPolicy,
+		ContextPolicy:     req.ContextPolicy,
+		Metadata:          req.Metadata,
+		Status:            &status,
+
+		MinHealthyProviders: req.MinHealthyProviders,
+		RefuseDegraded:      req.RefuseDegraded,
+		StreamStallTimeout:  req.StreamStallTimeout,
+		Fallback:            req.Fallback,
+		MaxConcurrency:      req.MaxConcurrency,
+		QueueTimeout:        req.QueueTimeout,
 	}
 
 	if err := gorm.G[models.Model](models.DB).Create(c.Request.Context(), &model); err != nil {
@@ -286,9 +460,19 @@ func CreateModel(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, models.AuditActionCreate, models.AuditTargetModel, model.ID, nil, model)
 	common.Success(c, model)
 }
 
+// validCachePolicy 缓存策略为空时使用默认策略
+func validCachePolicy(policy string) bool {
+	switch policy {
+	case "", consts.CachePolicyNever, consts.CachePolicyNonStream, consts.CachePolicyAlways:
+		return true
+	}
+	return false
+}
+
 // UpdateModel 更新模型
 func UpdateModel(c *gin.Context) {
 	idStr := c.Param("id")
@@ -319,6 +503,42 @@ func UpdateModel(c *gin.Context) {
 	if strategy == "" {
 		strategy = consts.BalancerDefault
 	}
+	if !validCachePolicy(req.CachePolicy) {
+		common.BadRequest(c, "Invalid cache policy: "+req.CachePolicy)
+		return
+	}
+	if err := service.ValidateCacheSampling(req.CacheSampling); err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+	if err := service.ValidateParamPolicy(req.ParamPolicy); err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+	if err := service.ValidateContextPolicy(req.ContextPolicy); err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+	if err := service.ValidateFallback(req.Fallback); err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+	if req.MinHealthyProviders < 0 {
+		common.BadRequest(c, "min_healthy_providers must not be negative")
+		return
+	}
+	if req.StickyTTL < 0 {
+		common.BadRequest(c, "sticky_ttl must not be negative")
+		return
+	}
+	if req.MaxConcurrency < 0 || req.QueueTimeout < 0 {
+		common.BadRequest(c, "max_concurrency and queue_timeout must not be negative")
+		return
+	}
+	if req.Metadata.ContextLength < 0 || req.Metadata.MaxOutput < 0 {
+		common.BadRequest(c, "metadata context_length and max_output must not be negative")
+		return
+	}
 	ioLog := existing.IOLog
 	if req.IOLog != nil {
 		ioLog = req.IOLog
@@ -326,18 +546,45 @@ func UpdateModel(c *gin.Context) {
 
 	// Update fields
 	updates := models.Model{
-		Name:     req.Name,
-		Remark:   req.Remark,
-		MaxRetry: req.MaxRetry,
-		TimeOut:  req.TimeOut,
-		IOLog:    ioLog,
-		Strategy: strategy,
+		Name:      req.Name,
+		Remark:    req.Remark,
+		MaxRetry:  req.MaxRetry,
+		TimeOut:   req.TimeOut,
+		IOLog:     ioLog,
+		Strategy:  strategy,
+		MaxBuffer: req.MaxBuffer,
+
+		StreamFailover:    req.StreamFailover,
+		StreamIdleTimeout: req.StreamIdleTimeout,
+		FirstChunkTimeout: req.FirstChunkTimeout,
+		GracefulTimeout:   req.GracefulTimeout,
+		StreamResume:      req.StreamResume,
+		Passthrough:       req.Passthrough,
+		CachePolicy:       req.CachePolicy,
+
+		RefuseDegraded: req.RefuseDegraded,
 	}
 
 	if _, err := gorm.G[models.Model](models.DB).Where("id = ?", id).Updates(c.Request.Context(), updates); err != nil {
 		common.InternalServerError(c, "Failed to update model: "+err.Error())
 		return
 	}
+	// 采样缓存限制、参数策略、上下文策略、能力信息与降级响应整体替换 允许清空 最低可用数、粘性会话时间、停滞间隔与并发限制允许改回 0
+	if _, err := gorm.G[models.Model](models.DB).Where("id = ?", id).Select("cache_sampling", "param_policy", "context_policy", "metadata", "min_healthy_providers", "sticky_ttl", "stream_stall_timeout", "fallback", "max_concurrency", "queue_timeout").Updates(c.Request.Context(), models.Model{
+		CacheSampling:       req.CacheSampling,
+		ParamPolicy:         req.ParamPolicy,
+		ContextPolicy:       req.ContextPolicy,
+		Metadata:            req.Metadata,
+		MinHealthyProviders: req.MinHealthyProviders,
+		StickyTTL:           req.StickyTTL,
+		StreamStallTimeout:  req.StreamStallTimeout,
+		Fallback:            req.Fallback,
+		MaxConcurrency:      req.MaxConcurrency,
+		QueueTimeout:        req.QueueTimeout,
+	}); err != nil {
+		common.InternalServerError(c, "Failed to update model: "+err.Error())
+		return
+	}
 
 	// Get updated model
 	updatedModel, err := gorm.G[models.Model](models.DB).Where("id = ?", id).First(c.Request.Context())
@@ -346,9 +593,47 @@ func UpdateModel(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, models.AuditActionUpdate, models.AuditTargetModel, id, existing, updatedModel)
 	common.Success(c, updatedModel)
 }
 
+// UpdateModelStatus 暂停或恢复模型 不改变各提供商关联的状态
+func UpdateModelStatus(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+
+	var req ModelStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+
+	existing, err := gorm.G[models.Model](models.DB).Where("id = ?", id).First(c.Request.Context())
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			common.NotFound(c, "Model not found")
+			return
+		}
+		common.InternalServerError(c, "Database error: "+err.Error())
+		return
+	}
+
+	status := req.Status
+	if _, err := gorm.G[models.Model](models.DB).Where("id = ?", id).Update(c.Request.Context(), "status", status); err != nil {
+		common.InternalServerError(c, "Failed to update status: "+err.Error())
+		return
+	}
+
+	before := existing
+	existing.Status = &status
+	recordAudit(c, models.AuditActionUpdate, models.AuditTargetModel, id, before, existing)
+	common.Success(c, existing)
+}
+
 // DeleteModel 删除模型
 func DeleteModel(c *gin.Context) {
 	idStr := c.Param("id")
@@ -358,6 +643,16 @@ func DeleteModel(c *gin.Context) {
 		return
 	}
 
+	existing, err := gorm.G[models.Model](models.DB).Where("id = ?", id).First(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.NotFound(c, "Model not found")
+			return
+		}
+		common.InternalServerError(c, "Database error: "+err.Error())
+		return
+	}
+
 	result, err := gorm.G[models.Model](models.DB).Where("id = ?", id).Delete(c.Request.Context())
 	if err != nil {
 		common.InternalServerError(c, "Failed to delete model: "+err.Error())
@@ -369,12 +664,25 @@ func DeleteModel(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, models.AuditActionDelete, models.AuditTargetModel, id, existing, nil)
 	common.Success(c, nil)
 }
 
 type ProviderTemplate struct {
-	Type     string `json:"type"`
-	Template string `json:"template"`
+	Type     string        `json:"type"`
+	Template string        `json:"template"`
+	Schema   []ConfigField `json:"schema"`
+}
+
+// openaiSchema openai 与 openai-res 共用的配置约束
+var openaiSchema = []ConfigField{
+	{Name: "base_url", Type: ConfigFieldString, Required: true, Format: ConfigFormatURL},
+	{Name: "api_key", Type: ConfigFieldString, Group: "api_key"},
+	{Name: "keys", Type: ConfigFieldArray, Group: "api_key"},
+	{Name: "strip_params", Type: ConfigFieldArray},
+	{Name: "user_agent", Type: ConfigFieldString},
+	{Name: "max_messages", Type: ConfigFieldNumber},
+	{Name: "max_prompt_chars", Type: ConfigFieldNumber},
 }
 
 var template = []ProviderTemplate{
@@ -382,23 +690,82 @@ var template = []ProviderTemplate{
 		Type: "openai",
 		Template: `{
 			"base_url": "https://api.openai.com/v1",
-			"api_key": "YOUR_API_KEY"
+			"api_key": "YOUR_API_KEY",
+			"strip_params": []
 		}`,
+		Schema: openaiSchema,
 	},
 	{
 		Type: "openai-res",
 		Template: `{
 			"base_url": "https://api.openai.com/v1",
-			"api_key": "YOUR_API_KEY"
+			"api_key": "YOUR_API_KEY",
+			"strip_params": []
 		}`,
+		Schema: openaiSchema,
 	},
 	{
 		Type: "anthropic",
 		Template: `{
 			"base_url": "https://api.anthropic.com/v1",
 			"api_key": "YOUR_API_KEY",
-			"version": "2023-06-01"
+			"version": "2023-06-01",
+			"betas": []
 		}`,
+		Schema: []ConfigField{
+			{Name: "base_url", Type: ConfigFieldString, Required: true, Format: ConfigFormatURL},
+			{Name: "api_key", Type: ConfigFieldString, Group: "api_key"},
+			{Name: "keys", Type: ConfigFieldArray, Group: "api_key"},
+			{Name: "version", Type: ConfigFieldString, Required: true},
+			{Name: "betas", Type: ConfigFieldArray},
+			{Name: "user_agent", Type: ConfigFieldString},
+			{Name: "max_messages", Type: ConfigFieldNumber},
+			{Name: "max_prompt_chars", Type: ConfigFieldNumber},
+		},
+	},
+	{
+		Type: "bedrock",
+		Template: `{
+			"region": "us-east-1",
+			"access_key_id": "YOUR_ACCESS_KEY_ID",
+			"secret_access_key": "YOUR_SECRET_ACCESS_KEY",
+			"session_token": ""
+		}`,
+		Schema: []ConfigField{
+			{Name: "region", Type: ConfigFieldString, Required: true},
+			{Name: "access_key_id", Type: ConfigFieldString, Required: true},
+			{Name: "secret_access_key", Type: ConfigFieldString, Required: true},
+			{Name: "session_token", Type: ConfigFieldString},
+			{Name: "base_url", Type: ConfigFieldString, Format: ConfigFormatURL},
+			{Name: "user_agent", Type: ConfigFieldString},
+			{Name: "max_messages", Type: ConfigFieldNumber},
+			{Name: "max_prompt_chars", Type: ConfigFieldNumber},
+		},
+	},
+	{
+		// 仅用于测试 按配置返回固定响应 不访问上游
+		Type: "mock",
+		Template: `{
+			"status": 200,
+			"body": {"choices":[{"message":{"role":"assistant","content":"mock"}}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}},
+			"chunks": [],
+			"latency": 0,
+			"failure_rate": 0,
+			"models": ["mock"]
+		}`,
+		Schema: []ConfigField{
+			{Name: "status", Type: ConfigFieldNumber},
+			{Name: "header", Type: ConfigFieldObject},
+			{Name: "chunks", Type: ConfigFieldArray},
+			{Name: "latency", Type: ConfigFieldNumber},
+			{Name: "chunk_interval", Type: ConfigFieldNumber},
+			{Name: "failure_rate", Type: ConfigFieldNumber},
+			{Name: "failure_status", Type: ConfigFieldNumber},
+			{Name: "seed", Type: ConfigFieldNumber},
+			{Name: "models", Type: ConfigFieldArray},
+			{Name: "max_messages", Type: ConfigFieldNumber},
+			{Name: "max_prompt_chars", Type: ConfigFieldNumber},
+		},
 	},
 }
 
@@ -481,6 +848,30 @@ func CreateModelProvider(c *gin.Context) {
 		common.BadRequest(c, "Invalid request body: "+err.Error())
 		return
 	}
+	if req.Tier < 0 {
+		common.BadRequest(c, "Tier must not be negative")
+		return
+	}
+	if req.Weight != nil && *req.Weight < 0 {
+		common.BadRequest(c, "Weight must not be negative")
+		return
+	}
+	if req.InputPrice < 0 || req.OutputPrice < 0 {
+		common.BadRequest(c, "Price must not be negative")
+		return
+	}
+	if err := service.ValidateResponseRules(req.ResponseRules); err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+	if err := service.ValidateForwardHeaders(req.ForwardHeaders); err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+
+	if rejectDuplicateModelProvider(c, req.ModelID, req.ProviderID, req.ProviderModel, 0) {
+		return
+	}
 
 	customerHeaders := req.CustomerHeaders
 	if customerHeaders == nil {
@@ -494,23 +885,70 @@ func CreateModelProvider(c *gin.Context) {
 		ToolCall:         &req.ToolCall,
 		StructuredOutput: &req.StructuredOutput,
 		Image:            &req.Image,
+		Completion:       &req.Completion,
+		Audio:            &req.Audio,
 		WithHeader:       &req.WithHeader,
+		NonStream:        &req.NonStream,
 		CustomerHeaders:  customerHeaders,
-		Weight:           req.Weight,
+		ForwardHeaders:   req.ForwardHeaders,
+		Weight:           1,
+		TimeOut:          req.TimeOut,
+		Tier:             req.Tier,
+		ResponseRules:    req.ResponseRules,
+		TransformStream:  &req.TransformStream,
+		Shadow:           &req.Shadow,
+		InputPrice:       req.InputPrice,
+		OutputPrice:      req.OutputPrice,
 	}
 
 	defaultStatus := true
 	modelProvider.Status = &defaultStatus
 
+	if req.Weight != nil {
+		modelProvider.Weight = *req.Weight
+	}
+
 	err := gorm.G[models.ModelWithProvider](models.DB).Create(c.Request.Context(), &modelProvider)
 	if err != nil {
 		common.InternalServerError(c, "Failed to create model-provider association: "+err.Error())
 		return
 	}
+	if req.Weight != nil && *req.Weight == 0 {
+		if err := saveStandbyWeight(c.Request.Context(), models.DB, &modelProvider); err != nil {
+			common.InternalServerError(c, "Failed to create model-provider association: "+err.Error())
+			return
+		}
+	}
 
+	recordAudit(c, models.AuditActionCreate, models.AuditTargetModelProvider, modelProvider.ID, nil, modelProvider)
 	common.Success(c, modelProvider)
 }
 
+// saveStandbyWeight weight 带数据库默认值 创建时零值会被忽略 备用关联需单独写入 0
+func saveStandbyWeight(ctx context.Context, db *gorm.DB, mp *models.ModelWithProvider) error {
+	if _, err := gorm.G[models.ModelWithProvider](db).Where("id = ?", mp.ID).Select("weight").Updates(ctx, models.ModelWithProvider{}); err != nil {
+		return err
+	}
+	mp.Weight = 0
+	return nil
+}
+
+// rejectDuplicateModelProvider 已存在相同模型 提供商与提供商模型的关联时返回错误 excludeID 为正在更新的关联
+func rejectDuplicateModelProvider(c *gin.Context, modelID, providerID uint, providerModel string, excludeID uint) bool {
+	duplicate, err := gorm.G[models.ModelWithProvider](models.DB).
+		Where("model_id = ? AND provider_id = ? AND provider_model = ? AND id <> ?", modelID, providerID, providerModel, excludeID).
+		First(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false
+		}
+		common.InternalServerError(c, "Database error: "+err.Error())
+		return true
+	}
+	common.BadRequest(c, fmt.Sprintf("Association for provider model %s already exists (id %d)", providerModel, duplicate.ID))
+	return true
+}
+
 // UpdateModelProvider 更新模型提供商关联
 func UpdateModelProvider(c *gin.Context) {
 	idStr := c.Param("id")
@@ -526,6 +964,26 @@ func UpdateModelProvider(c *gin.Context) {
 		return
 	}
 	slog.Info("UpdateModelProvider", "req", req)
+	if req.Tier < 0 {
+		common.BadRequest(c, "Tier must not be negative")
+		return
+	}
+	if req.Weight != nil && *req.Weight < 0 {
+		common.BadRequest(c, "Weight must not be negative")
+		return
+	}
+	if req.InputPrice < 0 || req.OutputPrice < 0 {
+		common.BadRequest(c, "Price must not be negative")
+		return
+	}
+	if err := service.ValidateResponseRules(req.ResponseRules); err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+	if err := service.ValidateForwardHeaders(req.ForwardHeaders); err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
 
 	customerHeaders := req.CustomerHeaders
 	if customerHeaders == nil {
@@ -543,6 +1001,11 @@ func UpdateModelProvider(c *gin.Context) {
 		return
 	}
 
+	// 未传入的字段保持原值
+	if rejectDuplicateModelProvider(c, cmp.Or(req.ModelID, existing.ModelID), cmp.Or(req.ProviderID, existing.ProviderID), cmp.Or(req.ProviderModel, existing.ProviderModel), existing.ID) {
+		return
+	}
+
 	// Update fields
 	updates := models.ModelWithProvider{
 		ModelID:          req.ModelID,
@@ -551,16 +1014,38 @@ func UpdateModelProvider(c *gin.Context) {
 		ToolCall:         &req.ToolCall,
 		StructuredOutput: &req.StructuredOutput,
 		Image:            &req.Image,
+		Completion:       &req.Completion,
+		Audio:            &req.Audio,
 		WithHeader:       &req.WithHeader,
+		NonStream:        &req.NonStream,
 		CustomerHeaders:  customerHeaders,
-		Weight:           req.Weight,
+		Weight:           existing.Weight,
+		TimeOut:          req.TimeOut,
+		Tier:             req.Tier,
 		Status:           existing.Status,
+		Shadow:           &req.Shadow,
+	}
+	if req.Weight != nil {
+		updates.Weight = *req.Weight
 	}
 
 	if _, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", id).Updates(c.Request.Context(), updates); err != nil {
 		common.InternalServerError(c, "Failed to update model-provider association: "+err.Error())
 		return
 	}
+	// 结构体更新会忽略零值 层级、改写规则、透传白名单、权重与价格需要能够清空
+	if _, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", id).Select("tier", "response_rules", "forward_headers", "transform_stream", "weight", "input_price", "output_price").Updates(c.Request.Context(), models.ModelWithProvider{
+		Tier:            req.Tier,
+		ResponseRules:   req.ResponseRules,
+		ForwardHeaders:  req.ForwardHeaders,
+		TransformStream: &req.TransformStream,
+		Weight:          updates.Weight,
+		InputPrice:      req.InputPrice,
+		OutputPrice:     req.OutputPrice,
+	}); err != nil {
+		common.InternalServerError(c, "Failed to update model-provider association: "+err.Error())
+		return
+	}
 
 	// Get updated model-provider association
 	updatedModelProvider, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", id).First(c.Request.Context())
@@ -569,6 +1054,7 @@ func UpdateModelProvider(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, models.AuditActionUpdate, models.AuditTargetModelProvider, id, existing, updatedModelProvider)
 	common.Success(c, updatedModelProvider)
 }
 
@@ -607,7 +1093,9 @@ func UpdateModelProviderStatus(c *gin.Context) {
 		return
 	}
 
+	before := existing
 	existing.Status = &status
+	recordAudit(c, models.AuditActionUpdate, models.AuditTargetModelProvider, id, before, existing)
 	common.Success(c, existing)
 }
 
@@ -620,6 +1108,16 @@ func DeleteModelProvider(c *gin.Context) {
 		return
 	}
 
+	existing, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", id).First(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.NotFound(c, "Model-provider association not found")
+			return
+		}
+		common.InternalServerError(c, "Database error: "+err.Error())
+		return
+	}
+
 	result, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", id).Delete(c.Request.Context())
 	if err != nil {
 		common.InternalServerError(c, "Failed to delete model-provider association: "+err.Error())
@@ -631,6 +1129,7 @@ func DeleteModelProvider(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, models.AuditActionDelete, models.AuditTargetModelProvider, id, existing, nil)
 	common.Success(c, nil)
 }
 
@@ -655,6 +1154,11 @@ func GetRequestLogs(c *gin.Context) {
 	status := c.Query("status")
 	style := c.Query("style")
 	authKeyID := c.Query("auth_key_id")
+	endUser := c.Query("end_user")
+	anomaly := c.Query("anomaly")
+	shadow := c.Query("shadow")
+	drift := c.Query("model_drift")
+	failureReason := c.Query("failure_reason")
 
 	// 构建查询条件
 	query := models.DB.Model(&models.ChatLog{})
@@ -679,6 +1183,48 @@ func GetRequestLogs(c *gin.Context) {
 		query = query.Where("auth_key_id = ?", authKeyID)
 	}
 
+	if endUser != "" {
+		query = query.Where("end_user = ?", endUser)
+	}
+
+	if failureReason != "" {
+		query = query.Where("failure_reason = ?", failureReason)
+	}
+
+	// anomaly=true 仅返回被标记异常的请求 false 仅返回正常请求
+	if anomaly != "" {
+		flagged, err := strconv.ParseBool(anomaly)
+		if err != nil {
+			common.BadRequest(c, "Invalid anomaly filter: "+anomaly)
+			return
+		}
+		if flagged {
+			query = query.Where("anomaly <> ''")
+		} else {
+			query = query.Where("anomaly = '' OR anomaly IS NULL")
+		}
+	}
+
+	// shadow=true 仅返回影子请求 false 仅返回实际响应客户端的请求
+	if shadow != "" {
+		isShadow, err := strconv.ParseBool(shadow)
+		if err != nil {
+			common.BadRequest(c, "Invalid shadow filter: "+shadow)
+			return
+		}
+		query = query.Where("COALESCE(shadow, 0) = ?", isShadow)
+	}
+
+	// model_drift=true 仅返回上游实际服务的模型疑似被替换的请求
+	if drift != "" {
+		isDrift, err := strconv.ParseBool(drift)
+		if err != nil {
+			common.BadRequest(c, "Invalid model_drift filter: "+drift)
+			return
+		}
+		query = query.Where("COALESCE(model_drift, 0) = ?", isDrift)
+	}
+
 	// 执行分页查询
 	var logs []models.ChatLog
 	total, err := common.PaginateQuery(
@@ -723,7 +1269,7 @@ func GetRequestLogs(c *gin.Context) {
 			if providerKey.Remark != "" {
 				providerKeyName = providerKey.Remark
 			} else if len(providerKey.Key) >= 8 {
-				providerKeyName = providerKey.Key[:4] + "..." + providerKey.Key[len(providerKey.Key)-4:]
+				providerKeyName = maskKey(providerKey.Key)
 			}
 		}
 
@@ -756,6 +1302,35 @@ func GetChatIO(c *gin.Context) {
 	common.Success(c, chatIO)
 }
 
+// RenderedOutputRes 重组后的输出及原始分片
+type RenderedOutputRes struct {
+	*service.RenderedOutput
+	Raw models.OutputUnion `json:"raw"`
+}
+
+// GetRenderedOutput 将指定日志记录的输出重组为最终的助手消息
+func GetRenderedOutput(c *gin.Context) {
+	id := c.Param("id")
+	ctx := c.Request.Context()
+
+	log, err := gorm.G[models.ChatLog](models.DB).Where("id = ?", id).First(ctx)
+	if err != nil {
+		common.NotFound(c, "Log not found")
+		return
+	}
+
+	chatIO, err := gorm.G[models.ChatIO](models.DB).Where("log_id = ?", id).First(ctx)
+	if err != nil {
+		common.NotFound(c, "ChatIO not found")
+		return
+	}
+
+	common.Success(c, RenderedOutputRes{
+		RenderedOutput: service.RenderOutput(log.Style, chatIO.OutputUnion),
+		Raw:            chatIO.OutputUnion,
+	})
+}
+
 // GetUserAgents 获取所有不重复的用户代理种类
 func GetUserAgents(c *gin.Context) {
 	var userAgents []string
@@ -806,8 +1381,13 @@ func UpdateConfigByKey(c *gin.Context) {
 		common.BadRequest(c, "Invalid request: "+err.Error())
 		return
 	}
+	if err := service.ValidateConfig(key, req.Value); err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
 
 	// 获取或创建配置记录
+	var before any
 	config, err := gorm.G[models.Config](models.DB).Where("key = ?", key).First(c.Request.Context())
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
@@ -826,6 +1406,7 @@ func UpdateConfigByKey(c *gin.Context) {
 		}
 	} else {
 		// 更新配置值
+		before = config
 		config.Value = req.Value
 		if _, err := gorm.G[models.Config](models.DB).Where("key = ?", key).Updates(c.Request.Context(), config); err != nil {
 			common.InternalServerError(c, "Failed to update config: "+err.Error())
@@ -833,6 +1414,7 @@ func UpdateConfigByKey(c *gin.Context) {
 		}
 	}
 
+	recordAudit(c, models.AuditActionUpdate, models.AuditTargetConfig, key, before, config)
 	common.Success(c, map[string]string{
 		"key":   config.Key,
 		"value": config.Value,
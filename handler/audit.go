@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// headerAdmin 管理员标识 共用管理 token 时用于区分操作者
+const headerAdmin = "X-LLMIO-Admin"
+
+// auditIgnoredFields 每次变更都会变化或与对象本身无关的字段 不计入差异
+var auditIgnoredFields = []string{"ID", "CreatedAt", "UpdatedAt", "DeletedAt"}
+
+// recordAudit 记录管理接口的变更 创建时 before 为 nil 删除时 after 为 nil 没有字段变化时不记录
+// 写入失败只输出日志 不影响接口结果
+func recordAudit(c *gin.Context, action, targetType string, targetID any, before, after any) {
+	diff, err := auditDiff(before, after)
+	if err != nil {
+		slog.Error("audit diff error", "target_type", targetType, "target_id", targetID, "error", err)
+		return
+	}
+	if len(diff) == 0 {
+		return
+	}
+	actor := strings.TrimSpace(c.GetHeader(headerAdmin))
+	if actor == "" {
+		actor = "admin"
+	}
+	log := models.AuditLog{
+		Actor:      actor,
+		IP:         c.ClientIP(),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   fmt.Sprint(targetID),
+		Diff:       diff,
+	}
+	if err := gorm.G[models.AuditLog](models.DB).Create(c.Request.Context(), &log); err != nil {
+		slog.Error("write audit log error", "target_type", targetType, "target_id", targetID, "error", err)
+	}
+}
+
+// auditDiff 比较变更前后的记录 只返回变化的字段 展示的值中密钥已脱敏
+// 创建与删除时省略零值字段
+func auditDiff(before, after any) (map[string]models.AuditChange, error) {
+	rawBefore, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+	rawAfter, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+	// 密钥变化时脱敏后的值可能相同 按原值判断是否变化
+	shownBefore, err := auditFields(redactAudit(before))
+	if err != nil {
+		return nil, err
+	}
+	shownAfter, err := auditFields(redactAudit(after))
+	if err != nil {
+		return nil, err
+	}
+
+	diff := make(map[string]models.AuditChange)
+	for _, fields := range []map[string]any{rawBefore, rawAfter} {
+		for k := range fields {
+			b, hasBefore := rawBefore[k]
+			a, hasAfter := rawAfter[k]
+			if reflect.DeepEqual(a, b) {
+				continue
+			}
+			if (!hasBefore && emptyJSON(a)) || (!hasAfter && emptyJSON(b)) {
+				continue
+			}
+			diff[k] = models.AuditChange{Before: shownBefore[k], After: shownAfter[k]}
+		}
+	}
+	return diff, nil
+}
+
+// auditFields 按 JSON 序列化后的字段展开记录
+func auditFields(v any) (map[string]any, error) {
+	fields := map[string]any{}
+	if v == nil {
+		return fields, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for _, k := range auditIgnoredFields {
+		delete(fields, k)
+	}
+	return fields, nil
+}
+
+// redactAudit 脱敏记录中的密钥 提供商与配置中的密钥字段替换为占位值 Key 只保留首尾
+func redactAudit(v any) any {
+	switch v := v.(type) {
+	case models.Provider:
+		config, err := redactProviderConfig(v.Config)
+		if err != nil {
+			config = redactedSecret
+		}
+		v.Config = config
+		return v
+	case models.Config:
+		value, err := redactProviderConfig(v.Value)
+		if err != nil {
+			value = redactedSecret
+		}
+		v.Value = value
+		return v
+	case models.AuthKey:
+		v.Key = maskKey(v.Key)
+		return v
+	case models.ProviderKey:
+		v.Key = maskKey(v.Key)
+		return v
+	}
+	return v
+}
+
+// emptyJSON JSON 值是否为零值 各字段均为零值的对象也视为零值
+func emptyJSON(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case float64:
+		return v == 0
+	case bool:
+		return !v
+	case []any:
+		return len(v) == 0
+	case map[string]any:
+		for _, field := range v {
+			if !emptyJSON(field) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// GetAuditLogs 查询变更记录 支持按对象、操作、操作者与时间范围筛选 start 与 end 为 RFC3339 时间
+func GetAuditLogs(c *gin.Context) {
+	params, err := common.ParsePagination(c)
+	if err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+
+	query := models.DB.Model(&models.AuditLog{})
+	for _, filter := range []string{"target_type", "target_id", "action", "actor"} {
+		if value := c.Query(filter); value != "" {
+			query = query.Where(filter+" = ?", value)
+		}
+	}
+	for _, bound := range []struct {
+		param string
+		cond  string
+	}{
+		{"start", "created_at >= ?"},
+		{"end", "created_at < ?"},
+	} {
+		value := c.Query(bound.param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			common.BadRequest(c, fmt.Sprintf("Invalid %s, must be RFC3339", bound.param))
+			return
+		}
+		query = query.Where(bound.cond, t)
+	}
+
+	var logs []models.AuditLog
+	total, err := common.PaginateQuery(query.Order("id DESC"), params, &logs)
+	if err != nil {
+		common.InternalServerError(c, "Failed to query audit logs: "+err.Error())
+		return
+	}
+	common.Success(c, common.NewPaginationResponse(logs, total, params))
+}
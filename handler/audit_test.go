@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func auditRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/providers", CreateProvider)
+	r.PUT("/providers/:id", UpdateProvider)
+	r.DELETE("/providers/:id", DeleteProvider)
+	r.POST("/providers/:id/keys", CreateProviderKey)
+	r.PUT("/providers/:id/keys/:keyId", UpdateProviderKey)
+	r.PATCH("/providers/:id/keys/:keyId/status", ToggleProviderKeyStatus)
+	r.DELETE("/providers/:id/keys/:keyId", DeleteProviderKey)
+	r.POST("/models", CreateModel)
+	r.PUT("/models/:id", UpdateModel)
+	r.PATCH("/models/:id/status", UpdateModelStatus)
+	r.DELETE("/models/:id", DeleteModel)
+	r.POST("/model-providers", CreateModelProvider)
+	r.PUT("/model-providers/:id", UpdateModelProvider)
+	r.POST("/model-providers/:id/clone", CloneModelProvider)
+	r.PATCH("/model-providers/:id/status", UpdateModelProviderStatus)
+	r.DELETE("/model-providers/:id", DeleteModelProvider)
+	r.POST("/auth-keys", CreateAuthKey)
+	r.PUT("/auth-keys/:id", UpdateAuthKey)
+	r.PATCH("/auth-keys/:id/status", ToggleAuthKeyStatus)
+	r.DELETE("/auth-keys/:id", DeleteAuthKey)
+	r.PUT("/config/:key", UpdateConfigByKey)
+	r.GET("/audit-logs", GetAuditLogs)
+	return r
+}
+
+func TestAuditMutations(t *testing.T) {
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{}, &models.ProviderKey{},
+		&models.AuthKey{}, &models.Config{}, &models.AuditLog{})
+	r := auditRouter()
+
+	tests := []struct {
+		method, path, body string
+		action, targetType string
+		targetID           string
+		field              string // diff 中应包含的字段
+	}{
+		{http.MethodPost, "/providers", `{"name":"p","type":"openai","config":"{\"base_url\":\"https://a\",\"api_key\":\"sk-secret-1\"}"}`, models.AuditActionCreate, models.AuditTargetProvider, "1", "Name"},
+		{http.MethodPut, "/providers/1", `{"console":"https://console"}`, models.AuditActionUpdate, models.AuditTargetProvider, "1", "Console"},
+		{http.MethodPost, "/providers/1/keys", `{"key":"sk-pool-abcdefgh"}`, models.AuditActionCreate, models.AuditTargetProviderKey, "1", "Key"},
+		{http.MethodPut, "/providers/1/keys/1", `{"remark":"backup"}`, models.AuditActionUpdate, models.AuditTargetProviderKey, "1", "Remark"},
+		{http.MethodPatch, "/providers/1/keys/1/status", ``, models.AuditActionUpdate, models.AuditTargetProviderKey, "1", "Status"},
+		{http.MethodDelete, "/providers/1/keys/1", ``, models.AuditActionDelete, models.AuditTargetProviderKey, "1", "Remark"},
+		{http.MethodPost, "/models", `{"name":"gpt-4o","max_retry":1,"time_out":10}`, models.AuditActionCreate, models.AuditTargetModel, "1", "Name"},
+		{http.MethodPut, "/models/1", `{"remark":"main"}`, models.AuditActionUpdate, models.AuditTargetModel, "1", "Remark"},
+		{http.MethodPatch, "/models/1/status", `{"status":false}`, models.AuditActionUpdate, models.AuditTargetModel, "1", "Status"},
+		{http.MethodPost, "/model-providers", `{"model_id":1,"provider_id":1,"provider_name":"gpt-4o"}`, models.AuditActionCreate, models.AuditTargetModelProvider, "1", "ProviderModel"},
+		{http.MethodPut, "/model-providers/1", `{"provider_name":"gpt-4o-2024","weight":3}`, models.AuditActionUpdate, models.AuditTargetModelProvider, "1", "Weight"},
+		{http.MethodPost, "/model-providers/1/clone", `{"provider_name":"gpt-4o-mini"}`, models.AuditActionCreate, models.AuditTargetModelProvider, "2", "ProviderModel"},
+		{http.MethodPatch, "/model-providers/1/status", `{"status":false}`, models.AuditActionUpdate, models.AuditTargetModelProvider, "1", "Status"},
+		{http.MethodDelete, "/model-providers/2", ``, models.AuditActionDelete, models.AuditTargetModelProvider, "2", "ProviderModel"},
+		{http.MethodPost, "/auth-keys", `{"name":"team","status":true}`, models.AuditActionCreate, models.AuditTargetAuthKey, "1", "Key"},
+		{http.MethodPut, "/auth-keys/1", `{"name":"team-a"}`, models.AuditActionUpdate, models.AuditTargetAuthKey, "1", "Name"},
+		{http.MethodPatch, "/auth-keys/1/status", ``, models.AuditActionUpdate, models.AuditTargetAuthKey, "1", "Status"},
+		{http.MethodDelete, "/auth-keys/1", ``, models.AuditActionDelete, models.AuditTargetAuthKey, "1", "Name"},
+		{http.MethodPut, "/config/" + models.KeyCache, `{"value":"{\"ttl\":60}"}`, models.AuditActionUpdate, models.AuditTargetConfig, models.KeyCache, "Value"},
+		{http.MethodPut, "/config/" + models.KeyCache, `{"value":"{\"ttl\":120}"}`, models.AuditActionUpdate, models.AuditTargetConfig, models.KeyCache, "Value"},
+		{http.MethodDelete, "/models/1", ``, models.AuditActionDelete, models.AuditTargetModel, "1", "Name"},
+		{http.MethodDelete, "/providers/1", ``, models.AuditActionDelete, models.AuditTargetProvider, "1", "Config"},
+	}
+	for i, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(headerAdmin, "alice")
+		r.ServeHTTP(w, req)
+		if code := gjson.Get(w.Body.String(), "code").Int(); code != 200 {
+			t.Fatalf("%s %s: %s", tt.method, tt.path, w.Body.String())
+		}
+
+		var logs []models.AuditLog
+		if err := db.Order("id").Find(&logs).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(logs) != i+1 {
+			t.Fatalf("%s %s: %d audit entries, want %d", tt.method, tt.path, len(logs), i+1)
+		}
+		log := logs[i]
+		if log.Actor != "alice" || log.Action != tt.action || log.TargetType != tt.targetType || log.TargetID != tt.targetID {
+			t.Errorf("%s %s: got %s %s %s/%s", tt.method, tt.path, log.Actor, log.Action, log.TargetType, log.TargetID)
+		}
+		if _, ok := log.Diff[tt.field]; !ok {
+			t.Errorf("%s %s: diff %v missing %s", tt.method, tt.path, log.Diff, tt.field)
+		}
+	}
+
+	// 查询接口按对象与操作筛选
+	res := doJSON(r, http.MethodGet, "/audit-logs?target_type=provider&target_id=1&action=update", "")
+	if res.Get("data.total").Int() != 1 || res.Get("data.data.0.Diff.Console.after").String() != "https://console" {
+		t.Errorf("unexpected audit query result %s", res.Raw)
+	}
+	if res := doJSON(r, http.MethodGet, "/audit-logs?start=2000-01-01T00:00:00Z&end=2000-01-02T00:00:00Z", ""); res.Get("data.total").Int() != 0 {
+		t.Errorf("time range filter returned %s", res.Raw)
+	}
+	if res := doJSON(r, http.MethodGet, "/audit-logs?start=yesterday", ""); res.Get("code").Int() != 400 {
+		t.Errorf("expected 400 for invalid start, got %s", res.Raw)
+	}
+}
+
+func TestAuditDiff(t *testing.T) {
+	enabled := true
+	tests := []struct {
+		name        string
+		before      any
+		after       any
+		wantFields  []string
+		wantMissing []string
+	}{
+		{
+			name:        "update keeps changed fields only",
+			before:      models.Model{Name: "gpt-4o", Remark: "a", MaxRetry: 3},
+			after:       models.Model{Name: "gpt-4o", Remark: "b", MaxRetry: 3},
+			wantFields:  []string{"Remark"},
+			wantMissing: []string{"Name", "MaxRetry", "UpdatedAt"},
+		},
+		{
+			name:        "create omits zero values",
+			after:       models.Model{Name: "gpt-4o", Status: &enabled},
+			wantFields:  []string{"Name", "Status"},
+			wantMissing: []string{"Remark", "MaxRetry", "ID"},
+		},
+		{
+			name:       "secret change is recorded redacted",
+			before:     models.Provider{Config: `{"api_key":"sk-old"}`},
+			after:      models.Provider{Config: `{"api_key":"sk-new"}`},
+			wantFields: []string{"Config"},
+		},
+		{
+			name:   "no change",
+			before: models.Model{Name: "gpt-4o"},
+			after:  models.Model{Name: "gpt-4o"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, err := auditDiff(tt.before, tt.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(diff) != len(tt.wantFields) {
+				t.Errorf("diff = %v, want fields %v", diff, tt.wantFields)
+			}
+			for _, k := range tt.wantFields {
+				if _, ok := diff[k]; !ok {
+					t.Errorf("diff missing %s", k)
+				}
+			}
+			for _, k := range tt.wantMissing {
+				if _, ok := diff[k]; ok {
+					t.Errorf("diff should not contain %s", k)
+				}
+			}
+			for _, change := range diff {
+				for _, v := range []any{change.Before, change.After} {
+					if s, ok := v.(string); ok && strings.Contains(s, "sk-") {
+						t.Errorf("secret leaked in diff: %s", s)
+					}
+				}
+			}
+		})
+	}
+}
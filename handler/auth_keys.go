@@ -11,16 +11,26 @@ import (
 	"github.com/atopos31/llmio/consts"
 	"github.com/atopos31/llmio/models"
 	"github.com/atopos31/llmio/pkg"
+	"github.com/atopos31/llmio/service"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type AuthKeyRequest struct {
-	Name      string   `json:"name" binding:"required"`
-	Status    *bool    `json:"status"`
-	AllowAll  *bool    `json:"allow_all"`
-	Models    []string `json:"models"`
-	ExpiresAt *string  `json:"expires_at"`
+	Name       string   `json:"name" binding:"required"`
+	Status     *bool    `json:"status"`
+	AllowAll   *bool    `json:"allow_all"`
+	Models     []string `json:"models"`
+	ExpiresAt  *string  `json:"expires_at"`
+	Moderation *bool    `json:"moderation"`
+
+	ReplayProtection *bool `json:"replay_protection"`
+	NoCache          *bool `json:"no_cache"`
+	ExcludeProviders *bool `json:"exclude_providers"`
+
+	ModelAliases map[string]string `json:"model_aliases"` // 别名到模型的映射 传入空对象清除
+	// 费用预算 更新时未传入保持原值
+	Budget *models.BudgetPolicy `json:"budget"`
 }
 
 func GetAuthKeys(c *gin.Context) {
@@ -90,7 +100,7 @@ func CreateAuthKey(c *gin.Context) {
 		return
 	}
 
-	if err := validateAuthKeyRequest(req); err != nil {
+	if err := validateAuthKeyRequest(&req); err != nil {
 		common.BadRequest(c, err.Error())
 		return
 	}
@@ -113,12 +123,21 @@ func CreateAuthKey(c *gin.Context) {
 	ctx := c.Request.Context()
 
 	authKey := models.AuthKey{
-		Name:      req.Name,
-		Key:       fmt.Sprintf("%s%s", consts.KeyPrefix, key),
-		Status:    req.Status,
-		AllowAll:  req.AllowAll,
-		Models:    sanitizeModels(req.Models),
-		ExpiresAt: expiresAt,
+		Name:       req.Name,
+		Key:        fmt.Sprintf("%s%s", consts.KeyPrefix, key),
+		Status:     req.Status,
+		AllowAll:   req.AllowAll,
+		Models:     sanitizeModels(req.Models),
+		ExpiresAt:  expiresAt,
+		Moderation: req.Moderation,
+
+		ReplayProtection: req.ReplayProtection,
+		NoCache:          req.NoCache,
+		ExcludeProviders: req.ExcludeProviders,
+		ModelAliases:     req.ModelAliases,
+	}
+	if req.Budget != nil {
+		authKey.Budget = *req.Budget
 	}
 
 	if err := gorm.G[models.AuthKey](models.DB).Create(ctx, &authKey); err != nil {
@@ -126,6 +145,7 @@ func CreateAuthKey(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, models.AuditActionCreate, models.AuditTargetAuthKey, authKey.ID, nil, authKey)
 	common.Success(c, authKey)
 }
 
@@ -143,14 +163,15 @@ func UpdateAuthKey(c *gin.Context) {
 		return
 	}
 
-	if err := validateAuthKeyRequest(req); err != nil {
+	if err := validateAuthKeyRequest(&req); err != nil {
 		common.BadRequest(c, err.Error())
 		return
 	}
 
 	ctx := c.Request.Context()
 
-	if _, err := gorm.G[models.AuthKey](models.DB).Where("id = ?", id).First(ctx); err != nil {
+	existing, err := gorm.G[models.AuthKey](models.DB).Where("id = ?", id).First(ctx)
+	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			common.NotFound(c, "Auth key not found")
 			return
@@ -170,17 +191,30 @@ func UpdateAuthKey(c *gin.Context) {
 	}
 
 	update := models.AuthKey{
-		Name:      req.Name,
-		Status:    req.Status,
-		AllowAll:  req.AllowAll,
-		Models:    sanitizeModels(req.Models),
-		ExpiresAt: expiresAt,
+		Name:       req.Name,
+		Status:     req.Status,
+		AllowAll:   req.AllowAll,
+		Models:     sanitizeModels(req.Models),
+		ExpiresAt:  expiresAt,
+		Moderation: req.Moderation,
+
+		ReplayProtection: req.ReplayProtection,
+		NoCache:          req.NoCache,
+		ExcludeProviders: req.ExcludeProviders,
+		ModelAliases:     req.ModelAliases,
 	}
 
 	if _, err := gorm.G[models.AuthKey](models.DB).Where("id = ?", id).Updates(ctx, update); err != nil {
 		common.InternalServerError(c, "Failed to update auth key: "+err.Error())
 		return
 	}
+	// 预算为零值时也需要写入 以便关闭预算
+	if req.Budget != nil {
+		if _, err := gorm.G[models.AuthKey](models.DB).Where("id = ?", id).Select("budget").Updates(ctx, models.AuthKey{Budget: *req.Budget}); err != nil {
+			common.InternalServerError(c, "Failed to update auth key: "+err.Error())
+			return
+		}
+	}
 
 	updated, err := gorm.G[models.AuthKey](models.DB).Where("id = ?", id).First(ctx)
 	if err != nil {
@@ -188,6 +222,7 @@ func UpdateAuthKey(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, models.AuditActionUpdate, models.AuditTargetAuthKey, id, existing, updated)
 	common.Success(c, updated)
 }
 
@@ -199,10 +234,19 @@ func DeleteAuthKey(c *gin.Context) {
 		return
 	}
 	ctx := c.Request.Context()
-	if _, err := gorm.G[models.AuthKey](models.DB).Where("id = ?", id).Delete(ctx); err != nil {
+	existing, err := gorm.G[models.AuthKey](models.DB).Where("id = ?", id).First(ctx)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		common.InternalServerError(c, "Failed to load auth key: "+err.Error())
+		return
+	}
+	rows, err := gorm.G[models.AuthKey](models.DB).Where("id = ?", id).Delete(ctx)
+	if err != nil {
 		common.InternalServerError(c, "Failed to delete auth key: "+err.Error())
 		return
 	}
+	if rows > 0 {
+		recordAudit(c, models.AuditActionDelete, models.AuditTargetAuthKey, id, existing, nil)
+	}
 	common.SuccessWithMessage(c, "Deleted", gin.H{"id": id})
 }
 
@@ -240,7 +284,9 @@ func ToggleAuthKeyStatus(c *gin.Context) {
 	}
 
 	// 返回更新后的记录
+	before := authKey
 	authKey.Status = &newStatus
+	recordAudit(c, models.AuditActionUpdate, models.AuditTargetAuthKey, id, before, authKey)
 	common.Success(c, authKey)
 }
 
@@ -272,10 +318,19 @@ func GetAuthKeysList(c *gin.Context) {
 	common.Success(c, result)
 }
 
-func validateAuthKeyRequest(req AuthKeyRequest) error {
+// validateAuthKeyRequest 校验请求 同时规范化模型别名
+func validateAuthKeyRequest(req *AuthKeyRequest) error {
 	if req.AllowAll != nil && !*req.AllowAll && len(req.Models) == 0 {
 		return errors.New("请至少选择一个允许的模型或启用允许全部模型")
 	}
+	aliases, err := service.SanitizeModelAliases(req.ModelAliases)
+	if err != nil {
+		return err
+	}
+	req.ModelAliases = aliases
+	if req.Budget != nil {
+		return service.ValidateBudgetPolicy(*req.Budget)
+	}
 	return nil
 }
 
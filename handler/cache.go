@@ -5,7 +5,9 @@ import (
 	"strconv"
 
 	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/service/cache"
 	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
 )
 
 // GetCacheStats 获取缓存统计信息
@@ -62,4 +64,43 @@ func ClearCacheByStyle(c *gin.Context) {
 	}
 
 	common.Success(c, gin.H{"message": "cache cleared successfully"})
-}
\ No newline at end of file
+}
+
+// ListCacheEntries 分页列出当前的缓存条目与剩余有效期 可按 auth_key_id、style 与 model 筛选
+func ListCacheEntries(c *gin.Context) {
+	if chatCache == nil {
+		common.ErrorWithHttpStatus(c, http.StatusServiceUnavailable, http.StatusServiceUnavailable, "cache not enabled")
+		return
+	}
+	params, err := common.ParsePagination(c)
+	if err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+	var authKeyID *uint
+	if value := c.Query("auth_key_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			common.BadRequest(c, "invalid auth key id")
+			return
+		}
+		authKeyID = lo.ToPtr(uint(id))
+	}
+	style, model := c.Query("style"), c.Query("model")
+
+	entries, err := chatCache.List(c.Request.Context())
+	if err != nil {
+		common.InternalServerError(c, err.Error())
+		return
+	}
+	entries = lo.Filter(entries, func(e cache.EntryInfo, _ int) bool {
+		return (authKeyID == nil || e.Scope.AuthKeyID == *authKeyID) &&
+			(style == "" || e.Scope.Style == style) &&
+			(model == "" || e.Scope.Model == model)
+	})
+
+	total := len(entries)
+	start := min((params.Page-1)*params.PageSize, total)
+	end := min(start+params.PageSize, total)
+	common.Success(c, common.NewPaginationResponse(entries[start:end], int64(total), params))
+}
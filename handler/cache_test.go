@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/service/cache"
+	"github.com/gin-gonic/gin"
+)
+
+func TestListCacheEntries(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	orig := chatCache
+	memory := cache.NewMemoryCache(16)
+	chatCache = memory
+	t.Cleanup(func() { chatCache = orig })
+
+	ctx := context.Background()
+	for i, scope := range []cache.Scope{
+		{AuthKeyID: 1, Style: "openai", Model: "gpt-4o"},
+		{AuthKeyID: 1, Style: "openai", Model: "gpt-4o-mini"},
+		{AuthKeyID: 2, Style: "anthropic", Model: "claude"},
+	} {
+		key := cache.Key{Scope: scope, BodyHash: string(rune('a'+i)) + "0123456789abcdef"}
+		value := &cache.Value{StatusCode: http.StatusOK, Body: []byte("secret response"), CreatedAt: time.Now().Add(time.Duration(i) * time.Second)}
+		if err := memory.Set(ctx, key, value, time.Minute, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := gin.New()
+	r.GET("/cache/entries", ListCacheEntries)
+
+	tests := []struct {
+		query      string
+		wantTotal  int64
+		wantLen    int
+		wantFirst  string
+		wantStatus int64
+	}{
+		{"", 3, 3, "claude", 200},
+		{"?page=2&page_size=2", 3, 1, "gpt-4o", 200},
+		{"?auth_key_id=1", 2, 2, "gpt-4o-mini", 200},
+		{"?style=anthropic&model=claude", 1, 1, "claude", 200},
+		{"?auth_key_id=x", 0, 0, "", 400},
+	}
+	for _, tt := range tests {
+		res := doJSON(r, http.MethodGet, "/cache/entries"+tt.query, "")
+		if res.Get("code").Int() != tt.wantStatus {
+			t.Errorf("%s: code = %d, want %d", tt.query, res.Get("code").Int(), tt.wantStatus)
+			continue
+		}
+		if tt.wantStatus != 200 {
+			continue
+		}
+		data := res.Get("data.data").Array()
+		if res.Get("data.total").Int() != tt.wantTotal || len(data) != tt.wantLen || data[0].Get("scope.model").String() != tt.wantFirst {
+			t.Errorf("%s: unexpected result %s", tt.query, res.Raw)
+			continue
+		}
+		// 列表中只有概要信息 不返回响应体
+		if data[0].Get("body").Exists() || data[0].Get("ttl").Int() != 60 || len(data[0].Get("body_hash").String()) != 12 {
+			t.Errorf("%s: unexpected entry %s", tt.query, data[0].Raw)
+		}
+	}
+}
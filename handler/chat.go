@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"slices"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/atopos31/llmio/common"
@@ -17,17 +21,59 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// 流式响应结束后以 HTTP trailer 返回用量 客户端通过请求头开启
+const (
+	headerUsageTrailers          = "X-Usage-Trailers"
+	trailerUsagePromptTokens     = "X-Usage-Prompt-Tokens"
+	trailerUsageCompletionTokens = "X-Usage-Completion-Tokens"
+	// usageTrailerWait 等待用量统计完成的最长时间
+	usageTrailerWait = 5 * time.Second
+)
+
+// headerRefresh 客户端要求重新请求上游 不使用已缓存的结果
+const headerRefresh = "X-LLMIO-Refresh"
+
+// headerFallback 响应为模型配置的降级响应 而非提供商的结果
+const headerFallback = "X-LLMIO-Fallback"
+
+// 管理员 token 的请求返回实际选中的提供商 便于排查路由 缓存命中时不返回
+const (
+	headerProvider      = "X-LLMIO-Provider"
+	headerUpstreamModel = "X-LLMIO-Upstream-Model"
+)
+
 var (
 	// chatCache 全局缓存实例，按AuthKeyID和模型隔离
 	chatCache cache.Cache = cache.NewMemoryCache(1024)
-	// chatCacheTTL 默认缓存有效期
-	chatCacheTTL = time.Minute * 5
+	// cacheRevalidations 正在后台刷新的缓存键 同一个键只刷新一次
+	cacheRevalidations sync.Map
+	// revalidating 进行中的后台缓存刷新 退出前等待其完成
+	revalidating sync.WaitGroup
 )
 
+// DrainRevalidations 等待进行中的后台缓存刷新完成 刷新会产生新的日志记录 需在等待日志写入之前调用
+func DrainRevalidations(ctx context.Context) {
+	waited := make(chan struct{})
+	go func() {
+		revalidating.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-ctx.Done():
+		slog.Warn("wait for cache revalidation timeout", "error", ctx.Err())
+	}
+}
+
 func ChatCompletionsHandler(c *gin.Context) {
 	chatHandler(c, service.BeforerOpenAI, service.ProcesserOpenAI, consts.StyleOpenAI)
 }
 
+// CompletionsHandler legacy completions 接口 用于代码补全(FIM) 用量格式与 chat 一致
+func CompletionsHandler(c *gin.Context) {
+	chatHandler(c, service.BeforerOpenAICompletion, service.ProcesserOpenAI, consts.StyleOpenAICompletion)
+}
+
 func ResponsesHandler(c *gin.Context) {
 	chatHandler(c, service.BeforerOpenAIRes, service.ProcesserOpenAiRes, consts.StyleOpenAIRes)
 }
@@ -37,6 +83,13 @@ func Messages(c *gin.Context) {
 }
 
 func chatHandler(c *gin.Context, preProcessor service.Beforer, postProcessor service.Processer, style string) {
+	// 识别上游实际返回的响应格式 发现关联配置错误
+	postProcessor = service.WithFormatDetection(style, postProcessor)
+	requestID := echoRequestID(c)
+	// 维护模式 管理接口不受影响
+	if checkMaintenance(c, style) {
+		return
+	}
 	// 读取原始请求体
 	reqBody, err := io.ReadAll(c.Request.Body)
 	if err != nil {
@@ -44,6 +97,12 @@ func chatHandler(c *gin.Context, preProcessor service.Beforer, postProcessor ser
 		return
 	}
 	c.Request.Body.Close()
+	// 客户端以同一请求 id 重试时复用原请求的结果
+	handled, finishDedup := dedupRequest(c, requestID, reqBody)
+	if handled {
+		return
+	}
+	defer finishDedup()
 	// 预处理、提取模型参数
 	before, err := preProcessor(reqBody)
 	if err != nil {
@@ -52,6 +111,12 @@ func chatHandler(c *gin.Context, preProcessor service.Beforer, postProcessor ser
 	}
 
 	ctx := c.Request.Context()
+	// 标记形态异常的请求 仅记录不拦截
+	service.FlagAnomaly(ctx, before)
+
+	// 按 auth key 的模型别名改写模型 权限与路由均以改写后的模型为准
+	service.ApplyModelAlias(ctx, before)
+
 	// 校验 authKey 是否有权限使用该模型
 	valid, err := validateAuthKey(ctx, before.Model)
 	if err != nil {
@@ -63,94 +128,379 @@ func chatHandler(c *gin.Context, preProcessor service.Beforer, postProcessor ser
 		return
 	}
 
-	// 尝试从缓存获取响应（仅对非流式请求）
-	cacheKey, cacheEnabled := service.BuildCacheKey(ctx, style, *before)
-	if cacheEnabled && chatCache != nil {
+	reqMeta := models.ReqMeta{
+		Header:    c.Request.Header,
+		RemoteIP:  c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+
+	// 转发前钩子（内容审核等）
+	if err := service.RunPreDispatchHooks(ctx, style, *before, reqMeta); err != nil {
+		var policyErr service.PolicyError
+		if errors.As(err, &policyErr) {
+			common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, policyErr.Error())
+			return
+		}
+		if errors.Is(err, service.ErrModerationUnavailable) {
+			common.ErrorWithHttpStatus(c, http.StatusServiceUnavailable, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		common.InternalServerError(c, err.Error())
+		return
+	}
+
+	// 客户端按请求排除提供商 需要 auth key 开启对应权限
+	if value := c.GetHeader(service.HeaderExcludeProviders); value != "" {
+		if allowed, _ := ctx.Value(consts.ContextKeyExcludeProviders).(bool); !allowed {
+			common.ErrorWithHttpStatus(c, http.StatusForbidden, http.StatusForbidden, "auth key has no permission to exclude providers")
+			return
+		}
+		ctx = service.WithExcludedProviders(ctx, service.ParseExcludedProviders(value))
+	}
+
+	// 按模型获取可用 provider
+	providersWithMeta, err := service.ProvidersWithMetaBymodelsName(ctx, style, *before)
+	if err != nil {
+		if errors.Is(err, service.ErrProvidersExcluded) {
+			common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, service.ErrModelDisabled) || errors.Is(err, service.ErrInsufficientProviders) {
+			common.ErrorWithHttpStatus(c, http.StatusServiceUnavailable, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		if errors.Is(err, service.ErrRequestTooLarge) {
+			common.ErrorWithHttpStatus(c, http.StatusRequestEntityTooLarge, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		common.InternalServerError(c, err.Error())
+		return
+	}
+
+	// 按模型的上下文策略截断或拒绝过长的对话历史
+	if err := service.ApplyContextPolicy(ctx, style, before, providersWithMeta.ContextPolicy, reqMeta); err != nil {
+		var policyErr service.PolicyError
+		if errors.As(err, &policyErr) {
+			common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, policyErr.Error())
+			return
+		}
+		common.InternalServerError(c, err.Error())
+		return
+	}
+
+	// 按模型的参数策略检查客户端传入的参数
+	if err := service.ApplyParamPolicy(ctx, style, before, providersWithMeta.ParamPolicy, reqMeta); err != nil {
+		var policyErr service.PolicyError
+		if errors.As(err, &policyErr) {
+			common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, policyErr.Error())
+			return
+		}
+		common.InternalServerError(c, err.Error())
+		return
+	}
+
+	// 断线重连时从续传缓冲重放 同一 auth key 与模型才能续传
+	authKeyID, _ := ctx.Value(consts.ContextKeyAuthKeyID).(uint)
+	resumeScope := fmt.Sprintf("%d|%s", authKeyID, before.Model)
+	resumable := before.Stream && providersWithMeta.StreamResume && !providersWithMeta.Passthrough
+	if lastEventID := c.GetHeader(headerLastEventID); resumable && lastEventID != "" {
+		if resumeFromLastEvent(c, lastEventID, resumeScope) {
+			return
+		}
+	}
+
+	// 按模型缓存策略尝试从缓存获取响应 客户端可通过 Cache-Control 跳过或刷新缓存
+	cacheKey, cacheEnabled := service.BuildCacheKey(ctx, style, *before, providersWithMeta.CachePolicy, providersWithMeta.CacheSampling, providersWithMeta.ConfigVersion())
+	cacheControl := service.ParseCacheControl(c.GetHeader("Cache-Control"))
+	// 强制刷新 等同 no-cache 跳过缓存读取 结果覆盖已缓存的响应
+	if c.GetHeader(headerRefresh) != "" {
+		cacheControl.NoCache = true
+	}
+	cacheEnabled = cacheEnabled && chatCache != nil && !cacheControl.NoStore && !providersWithMeta.Passthrough
+	if cacheEnabled && !cacheControl.NoCache {
 		if cached, hit, err := chatCache.Get(ctx, cacheKey); err == nil && hit {
 			// 缓存命中，记录审计日志
-			reqMeta := models.ReqMeta{
-				Header:    c.Request.Header,
-				RemoteIP:  c.ClientIP(),
-				UserAgent: c.Request.UserAgent(),
+			service.RecordCacheHit(ctx, cacheKey, cached, reqMeta, *before)
+			// 宽限期内的旧结果照常返回 同时在后台请求上游刷新
+			if cached.Stale {
+				revalidateCache(ctx, style, *before, *providersWithMeta, reqMeta, postProcessor, cacheKey)
 			}
-			service.RecordCacheHit(ctx, cacheKey, cached, reqMeta)
 
 			// 直接返回已缓存的响应
-			writeCachedResponse(c, cached)
+			writeCachedResponse(c, cached, before.Stream)
 			return
 		}
 	}
-	// 按模型获取可用 provider
-	providersWithMeta, err := service.ProvidersWithMetaBymodelsName(ctx, style, *before)
-	if err != nil {
+
+	// auth key 费用接近预算上限时优先选择更便宜的提供商 达到上限时按配置拒绝或只用最便宜的
+	if err := service.ApplyBudget(ctx, providersWithMeta); err != nil {
+		if errors.Is(err, service.ErrBudgetExceeded) {
+			common.ErrorWithHttpStatus(c, http.StatusTooManyRequests, http.StatusTooManyRequests, err.Error())
+			return
+		}
 		common.InternalServerError(c, err.Error())
 		return
 	}
 
+	// 模型并发达到上限时排队 各 auth key 轮流获得空出的名额
+	ctx, releaseSlot, err := service.AcquireModelSlot(ctx, *providersWithMeta, authKeyID)
+	if err != nil {
+		if errors.Is(err, service.ErrQueueTimeout) {
+			common.ErrorWithHttpStatus(c, http.StatusTooManyRequests, http.StatusTooManyRequests, err.Error())
+			return
+		}
+		common.ErrorWithHttpStatus(c, http.StatusServiceUnavailable, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	defer releaseSlot()
+
 	startReq := time.Now()
+	// 影子关联异步接收请求副本 不影响客户端响应
+	service.ShadowChat(ctx, style, *before, *providersWithMeta, reqMeta, postProcessor)
+	// 可续传的流在客户端断开后继续生成 供重连时重放
+	balanceCtx := ctx
+	if resumable {
+		balanceCtx = context.WithoutCancel(ctx)
+	}
 	// 调用负载均衡后的 provider 并转发
-	res, logId, err := service.BalanceChat(ctx, startReq, style, *before, *providersWithMeta, models.ReqMeta{
-		Header:    c.Request.Header,
-		RemoteIP:  c.ClientIP(),
-		UserAgent: c.Request.UserAgent(),
-	})
+	res, logId, err := service.BalanceChat(balanceCtx, startReq, style, *before, *providersWithMeta, reqMeta)
 	if err != nil {
+		// 所有提供商均失败时返回模型配置的降级响应
+		if fallback, ok := providersWithMeta.Fallback[style]; ok && errors.Is(err, service.ErrProvidersExhausted) {
+			writeFallback(c, style, *before, reqMeta, fallback, err)
+			return
+		}
 		common.InternalServerError(c, err.Error())
 		return
 	}
 	defer res.Body.Close()
 
+	if providersWithMeta.Passthrough {
+		writePassthrough(c, before.Stream, res, logId)
+		return
+	}
+
 	// 处理响应流，同时支持缓存写入
 	pr, pw := io.Pipe()
 	var reader io.Reader = res.Body
 	var buf bytes.Buffer
 
-	if !before.Stream && cacheEnabled && chatCache != nil {
-		// 非流式请求：同时写入管道和缓存缓冲区
-		reader = io.TeeReader(reader, pw)
+	// 原始响应写入管道用于统计用量 改写规则只影响返回客户端与缓存的内容
+	reader = io.TeeReader(reader, pw)
+	reader = service.TransformResponse(res, reader, before.Stream)
+	if cacheEnabled {
+		// 参与缓存：同时写入缓存缓冲区
 		reader = io.TeeReader(reader, &buf)
-	} else {
-		// 流式请求或缓存未启用：仅写入管道
-		reader = io.TeeReader(reader, pw)
 	}
 
 	// 异步处理输出并记录 tokens
-	go service.RecordLog(service.CopyStreamContext(res.Request.Context()), startReq, pr, postProcessor, logId, *before, providersWithMeta.IOLog)
+	recordCtx := service.CopyStreamContext(res.Request.Context())
+	var usageReport <-chan models.Usage
+	usageTrailers := before.Stream && c.GetHeader(headerUsageTrailers) != ""
+	if usageTrailers {
+		recordCtx, usageReport = service.WithUsageReport(recordCtx)
+	}
+	service.RecordLogAsync(recordCtx, startReq, pr, postProcessor, logId, *before, providersWithMeta.IOLog)
 
+	if usageTrailers {
+		// trailer 需要在写入响应体之前声明 并以 chunked 编码发送
+		c.Writer.Header().Set("Trailer", trailerUsagePromptTokens+", "+trailerUsageCompletionTokens)
+	}
+	// 普通 auth key 不暴露后端提供商 需在 writeHeader 刷新响应头之前设置
+	if authKeyID == 0 {
+		if providerName, providerModel, ok := service.SelectedProvider(res); ok {
+			c.Header(headerProvider, providerName)
+			c.Header(headerUpstreamModel, providerModel)
+		}
+	}
 	writeHeader(c, before.Stream, res.Header)
 	c.Status(res.StatusCode)
-	if _, err := io.Copy(c.Writer, reader); err != nil {
+	// 开启续传时为每个事件添加 id 并缓冲 写完后标记结束
+	// 其余流式响应按事件刷新 非流式响应直接复制
+	var out io.Writer = c.Writer
+	finishResume, finishFlush := func() {}, func() {}
+	if resumable {
+		resumeWriter := newResumeWriter(c.Writer, streamResumes.register(resumeScope))
+		out = resumeWriter
+		finishResume = resumeWriter.Close
+	} else if before.Stream {
+		flushWriter := newFlushWriter(c.Writer, loadStreamFlushInterval(ctx))
+		out = flushWriter
+		finishFlush = flushWriter.Close
+	}
+	_, err = io.Copy(out, reader)
+	finishFlush()
+	if err != nil {
+		// 以结束事件、错误事件或中断结束的响应不完整 不供重复请求复用
+		markDedupFailed(c)
+		// 空闲超时时补发结束事件，客户端保留已收到的内容
+		if before.Stream && providersWithMeta.GracefulTimeout && errors.Is(err, service.ErrStreamIdleTimeout) {
+			pw.Close()
+			if _, writeErr := out.Write(service.StreamTerminator(style)); writeErr == nil {
+				c.Writer.Flush()
+			}
+			finishResume()
+			if markErr := service.MarkTruncated(context.Background(), logId, err); markErr != nil {
+				slog.Error("mark truncated error", "error", markErr)
+			}
+			return
+		}
 		pw.CloseWithError(err)
+		// 响应头已发送 以协议格式的错误事件结束流 便于 SDK 解析
+		if before.Stream {
+			if _, writeErr := out.Write(service.StreamErrorFrame(style, err)); writeErr == nil {
+				c.Writer.Flush()
+			}
+			finishResume()
+			return
+		}
+		finishResume()
 		common.InternalServerError(c, err.Error())
 		return
 	}
+	finishResume()
 
 	pw.Close()
 
-	// 非流式请求完成后写入缓存
-	if !before.Stream && cacheEnabled && chatCache != nil && buf.Len() > 0 {
-		cacheValue := &cache.Value{
-			StatusCode:    res.StatusCode,
-			Header:        res.Header.Clone(),
-			Body:          buf.Bytes(),
-			CreatedAt:     time.Now(),
-			SourceLogID:   logId,
-			ProviderName:  getProviderName(providersWithMeta),
-			ProviderModel: before.Model,
-		}
+	if usageTrailers {
+		writeUsageTrailers(c, usageReport)
+	}
+
+	// 响应完整结束后写入缓存 no-cache 请求会覆盖已有结果
+	if cacheEnabled && buf.Len() > 0 {
+		cacheValue := newCacheValue(res, buf.Bytes(), logId, providersWithMeta, *before)
 		// 异步写入缓存，避免阻塞响应
 		go func() {
-			_ = chatCache.Set(context.Background(), cacheKey, cacheValue, chatCacheTTL)
+			ctx := context.Background()
+			ttl := service.SamplingCacheTTL(*before, providersWithMeta.CacheSampling, service.LoadCacheTTL(ctx))
+			_ = chatCache.Set(ctx, cacheKey, cacheValue, ttl.TTL, ttl.Grace)
 		}()
 	}
 }
 
-func writeHeader(c *gin.Context, stream bool, header http.Header) {
-	for k, values := range header {
-		for _, value := range values {
-			c.Writer.Header().Add(k, value)
+// newCacheValue 构造写入缓存的完整响应
+func newCacheValue(res *http.Response, body []byte, logId uint, providersWithMeta *service.ProvidersWithMeta, before service.Before) *cache.Value {
+	return &cache.Value{
+		StatusCode:    res.StatusCode,
+		Header:        res.Header.Clone(),
+		Body:          body,
+		CreatedAt:     time.Now(),
+		SourceLogID:   logId,
+		ProviderName:  getProviderName(providersWithMeta),
+		ProviderModel: before.Model,
+	}
+}
+
+// revalidateCache 在后台重新请求上游并覆盖已过时的缓存 同一个键同时只有一个刷新
+// 刷新失败时保留旧结果 直到硬过期
+func revalidateCache(ctx context.Context, style string, before service.Before, providersWithMeta service.ProvidersWithMeta, reqMeta models.ReqMeta, postProcessor service.Processer, cacheKey cache.Key) {
+	if _, loaded := cacheRevalidations.LoadOrStore(cacheKey, struct{}{}); loaded {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	revalidating.Add(1)
+	go func() {
+		defer revalidating.Done()
+		defer cacheRevalidations.Delete(cacheKey)
+		// 后台刷新同样受预算与模型并发上限约束 超出预算时不请求上游
+		if err := service.ApplyBudget(ctx, &providersWithMeta); err != nil {
+			slog.Info("skip cache revalidation", "model", before.Model, "error", err)
+			return
+		}
+		authKeyID, _ := ctx.Value(consts.ContextKeyAuthKeyID).(uint)
+		ctx, releaseSlot, err := service.AcquireModelSlot(ctx, providersWithMeta, authKeyID)
+		if err != nil {
+			slog.Warn("skip cache revalidation", "model", before.Model, "error", err)
+			return
+		}
+		defer releaseSlot()
+		startReq := time.Now()
+		res, logId, err := service.BalanceChat(ctx, startReq, style, before, providersWithMeta, reqMeta)
+		if err != nil {
+			slog.Warn("revalidate cache error", "model", before.Model, "error", err)
+			return
+		}
+		defer res.Body.Close()
+
+		pr, pw := io.Pipe()
+		var buf bytes.Buffer
+		reader := service.TransformResponse(res, io.TeeReader(res.Body, pw), before.Stream)
+		service.RecordLogAsync(service.CopyStreamContext(res.Request.Context()), startReq, pr, postProcessor, logId, before, providersWithMeta.IOLog)
+		if _, err := io.Copy(&buf, reader); err != nil {
+			pw.CloseWithError(err)
+			slog.Warn("revalidate cache error", "model", before.Model, "error", err)
+			return
+		}
+		pw.Close()
+		if buf.Len() == 0 {
+			return
+		}
+		ttl := service.SamplingCacheTTL(before, providersWithMeta.CacheSampling, service.LoadCacheTTL(ctx))
+		if err := chatCache.Set(ctx, cacheKey, newCacheValue(res, buf.Bytes(), logId, &providersWithMeta, before), ttl.TTL, ttl.Grace); err != nil {
+			slog.Error("revalidate cache error", "model", before.Model, "error", err)
+		}
+	}()
+}
+
+// writeFallback 返回降级响应 流式请求转为最小 SSE 流 响应头标记降级
+func writeFallback(c *gin.Context, style string, before service.Before, reqMeta models.ReqMeta, fallback []byte, cause error) {
+	ctx := c.Request.Context()
+	if _, err := service.RecordFallback(ctx, style, before, reqMeta, cause); err != nil {
+		slog.Error("record fallback error", "error", err)
+	}
+	c.Header(headerFallback, "true")
+	if !before.Stream {
+		c.Data(http.StatusOK, "application/json", fallback)
+		return
+	}
+	stream, err := service.FallbackStream(style, fallback)
+	if err != nil {
+		common.InternalServerError(c, cause.Error())
+		return
+	}
+	writeHeader(c, true, http.Header{})
+	c.Status(http.StatusOK)
+	c.Writer.Write(stream)
+	c.Writer.Flush()
+}
+
+// writePassthrough 直通模式 响应体直接复制给客户端 不经过改写规则、用量统计与缓存
+func writePassthrough(c *gin.Context, stream bool, res *http.Response, logId uint) {
+	writeHeader(c, stream, res.Header)
+	c.Status(res.StatusCode)
+	var out io.Writer = c.Writer
+	finishFlush := func() {}
+	if stream {
+		flushWriter := newFlushWriter(c.Writer, loadStreamFlushInterval(c.Request.Context()))
+		out = flushWriter
+		finishFlush = flushWriter.Close
+	}
+	_, err := io.Copy(out, res.Body)
+	finishFlush()
+	service.FinishPassthrough(res, logId, err)
+	if err != nil {
+		markDedupFailed(c)
+		common.InternalServerError(c, err.Error())
+	}
+}
+
+// writeUsageTrailers 等待日志处理得到用量后写入 trailer 统计失败或超时时不写入
+func writeUsageTrailers(c *gin.Context, usageReport <-chan models.Usage) {
+	select {
+	case usage, ok := <-usageReport:
+		if !ok {
+			return
 		}
+		c.Writer.Header().Set(trailerUsagePromptTokens, strconv.FormatInt(usage.PromptTokens, 10))
+		c.Writer.Header().Set(trailerUsageCompletionTokens, strconv.FormatInt(usage.CompletionTokens, 10))
+	case <-time.After(usageTrailerWait):
+		slog.Warn("wait usage for trailers timed out")
 	}
+}
+
+// writeHeader 写入转发的响应头 响应体边读边写 不设置 Content-Length
+func writeHeader(c *gin.Context, stream bool, header http.Header) {
+	copyResponseHeader(c.Writer.Header(), header)
 
 	if stream {
 		c.Header("Content-Type", "text/event-stream")
@@ -179,20 +529,31 @@ func validateAuthKey(ctx context.Context, model string) (bool, error) {
 	return slices.Contains(allowedModels, model), nil
 }
 
-// writeCachedResponse 写入缓存的响应数据
-func writeCachedResponse(c *gin.Context, cached *cache.Value) {
+// writeCachedResponse 写入缓存的响应数据 流式请求重放完整的 SSE 响应
+// 非流式响应按缓存的响应体设置 Content-Length 流式响应分块发送
+func writeCachedResponse(c *gin.Context, cached *cache.Value, stream bool) {
 	// 复制必要的响应头
-	for k, values := range cached.Header {
-		for _, value := range values {
-			c.Writer.Header().Add(k, value)
-		}
+	copyResponseHeader(c.Writer.Header(), cached.Header)
+	if stream {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
 	}
+	setContentLength(c.Writer.Header(), cached.Body, stream)
 
 	// 添加缓存标识头
-	c.Header("X-Cache", "HIT")
+	// 宽限期内的旧结果标记为 STALE
+	if cached.Stale {
+		c.Header("X-Cache", "STALE")
+	} else {
+		c.Header("X-Cache", "HIT")
+	}
 	c.Header("X-Cache-Created", cached.CreatedAt.Format(time.RFC3339))
 
 	c.Status(cached.StatusCode)
+	// 先发送响应头 避免服务器按完整响应体补上 Content-Length
+	if stream {
+		c.Writer.Flush()
+	}
 	if _, err := c.Writer.Write(cached.Body); err != nil {
 		common.InternalServerError(c, err.Error())
 	}
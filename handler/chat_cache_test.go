@@ -0,0 +1,350 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/atopos31/llmio/service/cache"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// waitFor 等待异步的日志与缓存写入完成
+func waitFor(t testing.TB, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestChatCachePolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// 上游每次返回不同内容 便于区分是否命中缓存
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		if gjson.GetBytes(body, "stream").Bool() {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":\"reply-%d\"}}]}\n\n", n)
+			fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":1,\"completion_tokens\":1,\"total_tokens\":2}}\n\ndata: [DONE]\n\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"choices":[{"message":{"role":"assistant","content":"reply-%d"}}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`, n)
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name     string
+		policy   string
+		stream   bool
+		headers  []string // 依次发送的请求的 Cache-Control refresh 表示携带强制刷新请求头
+		replies  []string // 依次期望的响应内容
+		upstream int32
+		noCache  bool // auth key 禁用缓存
+	}{
+		{"default caches non-stream", "", false, []string{"", ""}, []string{"reply-1", "reply-1"}, 1, false},
+		{"default skips stream", "", true, []string{"", ""}, []string{"reply-1", "reply-2"}, 2, false},
+		{"never", consts.CachePolicyNever, false, []string{"", ""}, []string{"reply-1", "reply-2"}, 2, false},
+		{"always replays stream", consts.CachePolicyAlways, true, []string{"", ""}, []string{"reply-1", "reply-1"}, 1, false},
+		{"no-store bypasses cache", "", false, []string{"no-store", "", ""}, []string{"reply-1", "reply-2", "reply-2"}, 2, false},
+		{"no-cache refreshes cache", "", false, []string{"", "no-cache", ""}, []string{"reply-1", "reply-2", "reply-2"}, 2, false},
+		{"refresh header overwrites cache", "", false, []string{"", "refresh", ""}, []string{"reply-1", "reply-2", "reply-2"}, 2, false},
+		{"no-cache auth key never caches", consts.CachePolicyAlways, false, []string{"", "", ""}, []string{"reply-1", "reply-2", "reply-3"}, 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{}, &models.ChatLog{},
+				&models.ChatIO{}, &models.Config{}, &models.ProviderKey{})
+			prevCache := chatCache
+			chatCache = cache.NewMemoryCache(16)
+			t.Cleanup(func() { chatCache = prevCache })
+			hits.Store(0)
+
+			provider := models.Provider{Name: "mock", Type: consts.StyleOpenAI, Config: `{"base_url":"` + upstream.URL + `","api_key":"sk-test"}`}
+			model := models.Model{Name: "gpt-4o", MaxRetry: 1, TimeOut: 10, CachePolicy: tt.policy}
+			if err := db.Create(&provider).Error; err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Create(&model).Error; err != nil {
+				t.Fatal(err)
+			}
+			enabled := true
+			if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: &enabled, Weight: 1}).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			authCtx := context.WithValue(context.Background(), consts.ContextKeyAuthKeyID, uint(1))
+			if tt.noCache {
+				authCtx = context.WithValue(authCtx, consts.ContextKeyNoCache, true)
+			}
+			r := gin.New()
+			r.POST("/v1/chat/completions", func(c *gin.Context) {
+				ctx := context.WithValue(c.Request.Context(), consts.ContextKeyAllowAllModel, true)
+				ctx = context.WithValue(ctx, consts.ContextKeyAuthKeyID, uint(1))
+				if tt.noCache {
+					ctx = context.WithValue(ctx, consts.ContextKeyNoCache, true)
+				}
+				c.Request = c.Request.WithContext(ctx)
+			}, ChatCompletionsHandler)
+
+			body := fmt.Sprintf(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"temperature":0,"stream":%t}`, tt.stream)
+			before, err := service.BeforerOpenAI([]byte(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta, err := service.ProvidersWithMetaBymodelsName(authCtx, consts.StyleOpenAI, *before)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, header := range tt.headers {
+				req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+				switch header {
+				case "":
+				case "refresh":
+					req.Header.Set(headerRefresh, "1")
+				default:
+					req.Header.Set("Cache-Control", header)
+				}
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				if w.Code != http.StatusOK {
+					t.Fatalf("request %d status = %d, body %s", i+1, w.Code, w.Body.String())
+				}
+				if !strings.Contains(w.Body.String(), tt.replies[i]) {
+					t.Errorf("request %d body = %s, want %s", i+1, w.Body.String(), tt.replies[i])
+				}
+				if tt.stream && !strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+					t.Errorf("request %d Content-Type = %q", i+1, w.Header().Get("Content-Type"))
+				}
+
+				// 等待本次请求的日志处理完成
+				waitFor(t, func() bool {
+					var count int64
+					db.Model(&models.ChatLog{}).Where("size > 0").Count(&count)
+					return count == int64(i+1)
+				})
+				// 等待异步写入缓存 以便下一次请求能够命中
+				if w.Header().Get("X-Cache") == "" && header != "no-store" {
+					if key, ok := service.BuildCacheKey(authCtx, consts.StyleOpenAI, *before, tt.policy, meta.CacheSampling, meta.ConfigVersion()); ok {
+						waitFor(t, func() bool {
+							cached, hit, _ := chatCache.Get(authCtx, key)
+							return hit && strings.Contains(string(cached.Body), tt.replies[i])
+						})
+					}
+				}
+			}
+			if got := hits.Load(); got != tt.upstream {
+				t.Errorf("upstream hits = %d, want %d", got, tt.upstream)
+			}
+			if stats := chatCache.Stats(); tt.noCache && stats.Entries != 0 {
+				t.Errorf("no-cache key wrote %d cache entries", stats.Entries)
+			}
+		})
+	}
+}
+
+// staleCache 读取时按需把命中的结果标记为过时 模拟进入宽限期
+type staleCache struct {
+	*cache.MemoryCache
+	stale atomic.Bool
+}
+
+func (c *staleCache) Get(ctx context.Context, key cache.Key) (*cache.Value, bool, error) {
+	value, hit, err := c.MemoryCache.Get(ctx, key)
+	if hit && c.stale.Load() {
+		value.Stale = true
+	}
+	return value, hit, err
+}
+
+func TestChatCacheStaleWhileRevalidate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// 首次请求立即返回 刷新请求等待放行 以便在刷新期间继续命中旧结果
+	var hits atomic.Int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		if n > 1 {
+			<-release
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"choices":[{"message":{"role":"assistant","content":"reply-%d"}}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`, n)
+	}))
+	defer upstream.Close()
+
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{}, &models.ChatLog{},
+		&models.ChatIO{}, &models.Config{}, &models.ProviderKey{})
+	prevCache := chatCache
+	swrCache := &staleCache{MemoryCache: cache.NewMemoryCache(16)}
+	chatCache = swrCache
+	// 先等待后台刷新与缓存命中日志结束 再还原缓存与数据库
+	t.Cleanup(func() {
+		DrainRevalidations(context.Background())
+		service.StartLogWriter(nil)(context.Background())
+		chatCache = prevCache
+	})
+
+	provider := models.Provider{Name: "mock", Type: consts.StyleOpenAI, Config: `{"base_url":"` + upstream.URL + `","api_key":"sk-test"}`}
+	model := models.Model{Name: "gpt-4o", MaxRetry: 1, TimeOut: 10}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: &enabled, Weight: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		ctx := context.WithValue(c.Request.Context(), consts.ContextKeyAllowAllModel, true)
+		ctx = context.WithValue(ctx, consts.ContextKeyAuthKeyID, uint(1))
+		c.Request = c.Request.WithContext(ctx)
+	}, ChatCompletionsHandler)
+
+	body := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"temperature":0}`
+	send := func(wantReply, wantCache string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), wantReply) {
+			t.Fatalf("status = %d body = %s, want %s", w.Code, w.Body.String(), wantReply)
+		}
+		if got := w.Header().Get("X-Cache"); got != wantCache {
+			t.Errorf("X-Cache = %q, want %q", got, wantCache)
+		}
+	}
+	cachedReply := func(reply string) func() bool {
+		return func() bool {
+			before, _ := service.BeforerOpenAI([]byte(body))
+			meta, err := service.ProvidersWithMetaBymodelsName(context.Background(), consts.StyleOpenAI, *before)
+			if err != nil {
+				return false
+			}
+			authCtx := context.WithValue(context.Background(), consts.ContextKeyAuthKeyID, uint(1))
+			key, _ := service.BuildCacheKey(authCtx, consts.StyleOpenAI, *before, "", meta.CacheSampling, meta.ConfigVersion())
+			cached, hit, _ := swrCache.MemoryCache.Get(authCtx, key)
+			return hit && strings.Contains(string(cached.Body), reply)
+		}
+	}
+
+	send("reply-1", "")
+	waitFor(t, cachedReply("reply-1"))
+
+	// 宽限期内的多次命中都立即返回旧结果 只触发一次刷新
+	swrCache.stale.Store(true)
+	for range 3 {
+		send("reply-1", "STALE")
+	}
+	waitFor(t, func() bool { return hits.Load() == 2 })
+	close(release)
+
+	// 刷新完成后覆盖缓存 之后命中新结果
+	waitFor(t, cachedReply("reply-2"))
+	swrCache.stale.Store(false)
+	send("reply-2", "HIT")
+	if got := hits.Load(); got != 2 {
+		t.Errorf("upstream hits = %d, want 2", got)
+	}
+	// 等待刷新请求的日志写入完成
+	waitFor(t, func() bool {
+		var count int64
+		db.Model(&models.ChatLog{}).Where("cached = ? AND size > 0", false).Count(&count)
+		return count == 2
+	})
+}
+
+func TestChatCacheStaleOverBudget(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"choices":[{"message":{"role":"assistant","content":"reply-%d"}}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`, n)
+	}))
+	defer upstream.Close()
+
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{}, &models.ChatLog{},
+		&models.ChatIO{}, &models.Config{}, &models.ProviderKey{})
+	prevCache := chatCache
+	swrCache := &staleCache{MemoryCache: cache.NewMemoryCache(16)}
+	chatCache = swrCache
+	t.Cleanup(func() {
+		DrainRevalidations(context.Background())
+		service.StartLogWriter(nil)(context.Background())
+		chatCache = prevCache
+	})
+
+	provider := models.Provider{Name: "mock", Type: consts.StyleOpenAI, Config: `{"base_url":"` + upstream.URL + `","api_key":"sk-test"}`}
+	model := models.Model{Name: "gpt-4o", MaxRetry: 1, TimeOut: 10}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: &enabled, Weight: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		ctx := context.WithValue(c.Request.Context(), consts.ContextKeyAllowAllModel, true)
+		ctx = context.WithValue(ctx, consts.ContextKeyAuthKeyID, uint(1))
+		ctx = context.WithValue(ctx, consts.ContextKeyBudget, models.BudgetPolicy{Limit: 1})
+		c.Request = c.Request.WithContext(ctx)
+	}, ChatCompletionsHandler)
+
+	body := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"temperature":0}`
+	send := func(wantCache string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "reply-1") {
+			t.Fatalf("status = %d body = %s, want reply-1", w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("X-Cache"); got != wantCache {
+			t.Errorf("X-Cache = %q, want %q", got, wantCache)
+		}
+	}
+
+	send("")
+	waitFor(t, func() bool {
+		var count int64
+		db.Model(&models.ChatLog{}).Where("size > 0").Count(&count)
+		return count == 1
+	})
+
+	// 费用达到上限后 宽限期内的命中仍返回旧结果 但不在后台请求上游
+	if err := db.Create(&models.ChatLog{Name: "gpt-4o", Status: "success", AuthKeyID: 1, Cost: 2}).Error; err != nil {
+		t.Fatal(err)
+	}
+	swrCache.stale.Store(true)
+	send("STALE")
+	DrainRevalidations(context.Background())
+	if got := hits.Load(); got != 1 {
+		t.Errorf("upstream hits = %d, want 1", got)
+	}
+}
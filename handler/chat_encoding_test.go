@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestChatGzipStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{}, &models.ChatLog{},
+		&models.ChatIO{}, &models.Config{}, &models.ProviderKey{})
+
+	events := []string{
+		`data: {"choices":[{"index":0,"delta":{"content":"Hello"}}]}` + "\n\n",
+		`data: {"choices":[],"usage":{"prompt_tokens":7,"completion_tokens":3,"total_tokens":10}}` + "\n\n",
+		"data: [DONE]\n\n",
+	}
+	// 客户端的 Accept-Encoding 被透传 上游逐个事件压缩并刷新
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			t.Errorf("Accept-Encoding = %q, want forwarded gzip", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		for _, event := range events {
+			gz.Write([]byte(event))
+			gz.Flush()
+			w.(http.Flusher).Flush()
+		}
+		gz.Close()
+	}))
+	t.Cleanup(upstream.Close)
+
+	model := models.Model{Name: "gpt-4o", MaxRetry: 1, TimeOut: 10}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	provider := models.Provider{Name: "gzip", Type: consts.StyleOpenAI, Config: `{"base_url":"` + upstream.URL + `","api_key":"sk-test"}`}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: &enabled, WithHeader: &enabled, Weight: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), consts.ContextKeyAllowAllModel, true))
+	}, ChatCompletionsHandler)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("Cache-Control", "no-store")
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	// 等待异步的日志记录完成
+	var log models.ChatLog
+	waitFor(t, func() bool {
+		return db.Where("total_tokens > 0").First(&log).Error == nil
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if log.PromptTokens != 7 || log.CompletionTokens != 3 || log.TotalTokens != 10 {
+		t.Errorf("usage = %d/%d/%d, want 7/3/10", log.PromptTokens, log.CompletionTokens, log.TotalTokens)
+	}
+	// 客户端收到解压后的内容 不再声明压缩编码
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q, want none", got)
+	}
+	if got, want := w.Body.String(), strings.Join(events, ""); got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+}
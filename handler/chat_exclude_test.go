@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+)
+
+func TestChatExcludeProviders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{}, &models.ChatLog{},
+		&models.ChatIO{}, &models.Config{}, &models.ProviderKey{})
+
+	model := models.Model{Name: "gpt-4o", MaxRetry: 1, TimeOut: 10}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"hi"}}]}`)
+	}))
+	t.Cleanup(upstream.Close)
+	provider := models.Provider{Name: "alpha", Type: consts.StyleOpenAI, Config: `{"base_url":"` + upstream.URL + `","api_key":"sk-test"}`}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: &enabled, Weight: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		ctx := context.WithValue(c.Request.Context(), consts.ContextKeyAllowAllModel, true)
+		if c.GetHeader("X-Test-Allow-Exclude") != "" {
+			ctx = context.WithValue(ctx, consts.ContextKeyExcludeProviders, true)
+		}
+		c.Request = c.Request.WithContext(ctx)
+	}, ChatCompletionsHandler)
+
+	tests := []struct {
+		name    string
+		allowed bool
+		exclude string
+		want    int
+	}{
+		{"not permitted", false, "alpha", http.StatusForbidden},
+		{"excludes every provider", true, "alpha", http.StatusBadRequest},
+		{"unknown provider leaves candidates", true, "beta", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+			req.Header.Set("Cache-Control", "no-store")
+			req.Header.Set(service.HeaderExcludeProviders, tt.exclude)
+			if tt.allowed {
+				req.Header.Set("X-Test-Allow-Exclude", "1")
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+	// 等待异步的日志记录完成 日志创建时即为 success 以响应大小判断记录已更新
+	waitFor(t, func() bool {
+		var count int64
+		db.Model(&models.ChatLog{}).Where("excluded_providers = ? AND status = ? AND size > 0", "beta", "success").Count(&count)
+		return count == 1
+	})
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestChatFallback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{}, &models.ChatLog{},
+		&models.ChatIO{}, &models.Config{}, &models.ProviderKey{})
+
+	var healthy atomic.Bool
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if !healthy.Load() {
+			http.Error(w, `{"error":{"message":"overloaded"}}`, http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"real"}}]}`)
+	}))
+	t.Cleanup(upstream.Close)
+
+	fallback := `{"object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":""},"finish_reason":"stop"}]}`
+	model := models.Model{Name: "autocomplete", MaxRetry: 2, TimeOut: 10,
+		Fallback: models.ModelFallback{consts.StyleOpenAI: json.RawMessage(fallback)}}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	for _, name := range []string{"alpha", "beta"} {
+		provider := models.Provider{Name: name, Type: consts.StyleOpenAI, Config: `{"base_url":"` + upstream.URL + `","api_key":"sk-test"}`}
+		if err := db.Create(&provider).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt", Status: &enabled, Completion: &enabled, Weight: 1}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), consts.ContextKeyAllowAllModel, true))
+	}, ChatCompletionsHandler)
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("Cache-Control", "no-store")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	resetCooldown := func() {
+		db.Model(&models.ModelWithProvider{}).Where("1 = 1").Updates(map[string]any{
+			"provider_cooldown_until": nil, "provider_cooldown_step": 0,
+		})
+	}
+
+	// 提供商正常时不使用降级响应
+	healthy.Store(true)
+	w := send(`{"model":"autocomplete","messages":[{"role":"user","content":"hi"}]}`)
+	if w.Code != http.StatusOK || w.Header().Get(headerFallback) != "" || !strings.Contains(w.Body.String(), "real") {
+		t.Fatalf("healthy response = %d %q fallback %q", w.Code, w.Body.String(), w.Header().Get(headerFallback))
+	}
+
+	// 所有提供商重试失败后返回降级响应
+	healthy.Store(false)
+	calls.Store(0)
+	w = send(`{"model":"autocomplete","messages":[{"role":"user","content":"hi"}]}`)
+	if w.Code != http.StatusOK || w.Header().Get(headerFallback) != "true" || w.Body.String() != fallback {
+		t.Fatalf("fallback response = %d %q fallback %q", w.Code, w.Body.String(), w.Header().Get(headerFallback))
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("upstream calls = %d, want every retry attempted before falling back", got)
+	}
+	var log models.ChatLog
+	if err := db.Where("fallback = ?", true).First(&log).Error; err != nil {
+		t.Fatalf("fallback log not found: %v", err)
+	}
+	if log.Status != "error" || !strings.Contains(log.Error, "maximum retry attempts reached") {
+		t.Errorf("fallback log = status %q error %q", log.Status, log.Error)
+	}
+
+	// 流式请求得到合成的 SSE 流
+	resetCooldown()
+	w = send(`{"model":"autocomplete","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	body := w.Body.String()
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" ||
+		!strings.HasPrefix(body, `data: {"object":"chat.completion.chunk"`) || !strings.HasSuffix(body, "data: [DONE]\n\n") {
+		t.Fatalf("stream fallback = %d %q %q", w.Code, w.Header().Get("Content-Type"), body)
+	}
+
+	// 未配置降级响应的接口风格仍返回错误
+	resetCooldown()
+	r.POST("/v1/completions", func(c *gin.Context) {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), consts.ContextKeyAllowAllModel, true))
+	}, CompletionsHandler)
+	req := httptest.NewRequest(http.MethodPost, "/v1/completions", strings.NewReader(`{"model":"autocomplete","prompt":"hi"}`))
+	req.Header.Set("Cache-Control", "no-store")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError || w.Header().Get(headerFallback) != "" || !strings.Contains(w.Body.String(), "maximum retry attempts reached") {
+		t.Fatalf("completion without fallback = %d %q", w.Code, w.Body.String())
+	}
+
+	// 失败尝试的日志异步写入 等待写完再结束 避免测试数据库关闭后写入
+	waitFor(t, func() bool {
+		var attempts int64
+		db.Model(&models.ChatLog{}).Where("status = ? AND error NOT LIKE ?", "error", "%maximum retry attempts reached%").Count(&attempts)
+		return attempts >= int64(calls.Load())
+	})
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/middleware"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+)
+
+func TestChatModelAliasPerAuthKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{}, &models.ChatLog{},
+		&models.ChatIO{}, &models.Config{}, &models.ProviderKey{}, &models.AuthKey{})
+
+	model := models.Model{Name: "gpt-4o", MaxRetry: 1, TimeOut: 10}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"hi"}}]}`)
+	}))
+	t.Cleanup(upstream.Close)
+	provider := models.Provider{Name: "alpha", Type: consts.StyleOpenAI, Config: `{"base_url":"` + upstream.URL + `","api_key":"sk-test"}`}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: &enabled, Weight: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 等待异步的日志记录完成后再关闭数据库
+	t.Cleanup(func() { service.StartLogWriter(nil)(context.Background()) })
+
+	r := gin.New()
+	r.POST("/api/auth-keys", CreateAuthKey)
+	r.POST("/v1/chat/completions", middleware.AuthOpenAI("admin"), ChatCompletionsHandler)
+
+	// 只有 aliased 配置了别名
+	aliased := doJSON(r, http.MethodPost, "/api/auth-keys", `{"name":"aliased","status":true,"allow_all":true,"model_aliases":{" fast ":"gpt-4o"}}`)
+	plain := doJSON(r, http.MethodPost, "/api/auth-keys", `{"name":"plain","status":true,"allow_all":true}`)
+	if code := aliased.Get("code").Int(); code != 200 {
+		t.Fatalf("create aliased key: %s", aliased.Raw)
+	}
+	if got := aliased.Get("data.ModelAliases.fast").String(); got != "gpt-4o" {
+		t.Fatalf("aliases not sanitized: %s", aliased.Get("data.ModelAliases").Raw)
+	}
+
+	tests := []struct {
+		name  string
+		key   string
+		model string
+		want  int
+	}{
+		{"alias resolves for owning key", aliased.Get("data.Key").String(), "fast", http.StatusOK},
+		{"real model still works", aliased.Get("data.Key").String(), "gpt-4o", http.StatusOK},
+		{"alias ignored for other key", plain.Get("data.Key").String(), "fast", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"`+tt.model+`","messages":[{"role":"user","content":"hi"}]}`))
+			req.Header.Set("Authorization", "Bearer "+tt.key)
+			req.Header.Set("Cache-Control", "no-store")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+
+	// 等待两次成功请求的异步日志记录完成 日志创建时即为 success 以响应大小判断记录已更新
+	waitFor(t, func() bool {
+		var count int64
+		db.Model(&models.ChatLog{}).Where("name = ? AND status = ? AND size > 0", "gpt-4o", "success").Count(&count)
+		return count == 2
+	})
+	// 日志同时记录请求的别名与改写后的模型
+	var aliasedLogs int64
+	db.Model(&models.ChatLog{}).Where("name = ? AND requested_model = ?", "gpt-4o", "fast").Count(&aliasedLogs)
+	if aliasedLogs != 1 {
+		t.Errorf("aliased logs = %d, want 1", aliasedLogs)
+	}
+	var unresolved int64
+	db.Model(&models.ChatLog{}).Where("name = ? AND requested_model = ?", "fast", "").Count(&unresolved)
+	if unresolved != 1 {
+		t.Errorf("unresolved alias logs = %d, want 1", unresolved)
+	}
+}
+
+func TestValidateAuthKeyModelAliases(t *testing.T) {
+	tests := []struct {
+		name    string
+		aliases map[string]string
+		wantErr bool
+	}{
+		{"nil keeps existing", nil, false},
+		{"empty clears", map[string]string{}, false},
+		{"valid", map[string]string{"fast": "gpt-4o"}, false},
+		{"empty target", map[string]string{"fast": " "}, true},
+		{"empty alias", map[string]string{"": "gpt-4o"}, true},
+		{"self alias", map[string]string{"gpt-4o": "gpt-4o"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := AuthKeyRequest{Name: "k", ModelAliases: tt.aliases}
+			err := validateAuthKeyRequest(&req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (req.ModelAliases == nil) != (tt.aliases == nil) {
+				t.Errorf("aliases = %v, want nil-ness of %v", req.ModelAliases, tt.aliases)
+			}
+		})
+	}
+}
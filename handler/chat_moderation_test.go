@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+)
+
+func TestChatModerationFailClosed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{}, &models.ChatLog{},
+		&models.ChatIO{}, &models.Config{}, &models.ProviderKey{})
+
+	model := models.Model{Name: "gpt-4o", MaxRetry: 1, TimeOut: 10}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"hi"}}]}`)
+	}))
+	t.Cleanup(upstream.Close)
+	provider := models.Provider{Name: "alpha", Type: consts.StyleOpenAI, Config: `{"base_url":"` + upstream.URL + `","api_key":"sk-test"}`}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: &enabled, Weight: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 审核服务不可达
+	moderation := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	moderation.Close()
+	if err := service.SaveConfig(t.Context(), models.KeyModeration, models.Moderation{
+		Enabled:  true,
+		Endpoint: moderation.URL,
+		Keywords: []string{"forbidden"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), consts.ContextKeyAllowAllModel, true))
+	}, ChatCompletionsHandler)
+
+	tests := []struct {
+		name   string
+		prompt string
+		want   int
+		status string
+	}{
+		// 关键词策略先于审核服务执行
+		{"flagged content", "a forbidden topic", http.StatusBadRequest, "blocked"},
+		{"moderation unavailable", "hello", http.StatusServiceUnavailable, "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"`+tt.prompt+`"}]}`))
+			req.Header.Set("Cache-Control", "no-store")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.want, w.Body.String())
+			}
+			var log models.ChatLog
+			if err := db.Order("id DESC").First(&log).Error; err != nil {
+				t.Fatal(err)
+			}
+			if log.Status != tt.status {
+				t.Errorf("log status = %q, want %q", log.Status, tt.status)
+			}
+		})
+	}
+	if hits.Load() != 0 {
+		t.Errorf("expected no upstream calls, got %d", hits.Load())
+	}
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestChatParamPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{}, &models.ChatLog{},
+		&models.ChatIO{}, &models.Config{}, &models.ProviderKey{})
+
+	// 上游返回实际收到的 temperature
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"choices":[{"message":{"role":"assistant","content":"t=%s"}}],"usage":{"total_tokens":1}}`, gjson.GetBytes(body, "temperature").Raw)
+	}))
+	defer upstream.Close()
+
+	provider := models.Provider{Name: "mock", Type: consts.StyleOpenAI, Config: `{"base_url":"` + upstream.URL + `","api_key":"sk-test"}`}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.POST("/models", CreateModel)
+	r.PUT("/models/:id", UpdateModel)
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		ctx := context.WithValue(c.Request.Context(), consts.ContextKeyAllowAllModel, true)
+		c.Request = c.Request.WithContext(ctx)
+	}, ChatCompletionsHandler)
+
+	policy := `{"mode":"%s","bounds":{"temperature":{"max":1}}}`
+	res := doJSON(r, http.MethodPost, "/models", `{"name":"gpt-4o","max_retry":1,"time_out":10,"param_policy":`+fmt.Sprintf(policy, "clamp")+`}`)
+	if res.Get("code").Int() != 200 {
+		t.Fatalf("create model failed: %s", res.Raw)
+	}
+	enabled := true
+	if err := db.Create(&models.ModelWithProvider{ModelID: uint(res.Get("data.ID").Int()), ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: &enabled, Weight: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if res := doJSON(r, http.MethodPut, "/models/1", `{"name":"gpt-4o","param_policy":{"bounds":{"temperature":{"min":2,"max":1}}}}`); res.Get("code").Int() != 400 {
+		t.Errorf("expected 400 for invalid bounds, got %s", res.Raw)
+	}
+
+	chat := func(temperature string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"temperature":` + temperature + `}`
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+		return w
+	}
+	lastLog := func() models.ChatLog {
+		var log models.ChatLog
+		db.Order("id desc").First(&log)
+		return log
+	}
+
+	// clamp 模式修正后转发并在日志中标记
+	if w := chat("1.8"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "t=1") {
+		t.Fatalf("clamp: status %d body %s", w.Code, w.Body.String())
+	}
+	if got := lastLog().ClampedParams; got != "temperature" {
+		t.Errorf("ClampedParams = %q, want temperature", got)
+	}
+	if w := chat("1"); !strings.Contains(w.Body.String(), "t=1") {
+		t.Fatalf("boundary: body %s", w.Body.String())
+	}
+	if got := lastLog().ClampedParams; got != "" {
+		t.Errorf("boundary value flagged as clamped: %q", got)
+	}
+
+	// reject 模式返回 400 且不转发
+	if res := doJSON(r, http.MethodPut, "/models/1", `{"name":"gpt-4o","max_retry":1,"time_out":10,"param_policy":`+fmt.Sprintf(policy, "reject")+`}`); res.Get("code").Int() != 200 {
+		t.Fatalf("update model failed: %s", res.Raw)
+	}
+	if w := chat("1.8"); w.Code != http.StatusBadRequest {
+		t.Errorf("reject: status %d body %s", w.Code, w.Body.String())
+	}
+	if log := lastLog(); log.Status != "blocked" {
+		t.Errorf("rejected request logged as %q, want blocked", log.Status)
+	}
+
+	// 清空策略后不再限制
+	if res := doJSON(r, http.MethodPut, "/models/1", `{"name":"gpt-4o","max_retry":1,"time_out":10}`); res.Get("code").Int() != 200 {
+		t.Fatalf("clear policy failed: %s", res.Raw)
+	}
+	if w := chat("1.8"); !strings.Contains(w.Body.String(), "t=1.8") {
+		t.Errorf("cleared policy: body %s", w.Body.String())
+	}
+
+	// 等待异步的日志处理完成
+	waitFor(t, func() bool {
+		var count int64
+		db.Model(&models.ChatLog{}).Where("size > 0").Count(&count)
+		return count == 3
+	})
+}
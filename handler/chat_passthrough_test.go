@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const passthroughReply = `{"choices":[{"message":{"role":"assistant","content":"hi"}}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`
+
+// setupPassthrough 创建带响应改写规则的模型 改写规则只在常规路径生效
+func setupPassthrough(tb testing.TB, passthrough bool) (*gorm.DB, *gin.Engine) {
+	tb.Helper()
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(tb, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{}, &models.ChatLog{},
+		&models.ChatIO{}, &models.Config{}, &models.ProviderKey{})
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, passthroughReply)
+	}))
+	tb.Cleanup(upstream.Close)
+
+	provider := models.Provider{Name: "mock", Type: consts.StyleOpenAI, Config: `{"base_url":"` + upstream.URL + `","api_key":"sk-test"}`}
+	if err := db.Create(&provider).Error; err != nil {
+		tb.Fatal(err)
+	}
+	model := models.Model{Name: "gpt-4o", MaxRetry: 1, TimeOut: 10, CachePolicy: consts.CachePolicyNever, Passthrough: &passthrough}
+	if err := db.Create(&model).Error; err != nil {
+		tb.Fatal(err)
+	}
+	enabled := true
+	rules := []models.ResponseRule{{Type: models.ResponseRuleDelete, Path: "usage"}}
+	if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: &enabled, Weight: 1, ResponseRules: rules}).Error; err != nil {
+		tb.Fatal(err)
+	}
+
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		ctx := context.WithValue(c.Request.Context(), consts.ContextKeyAllowAllModel, true)
+		c.Request = c.Request.WithContext(ctx)
+	}, ChatCompletionsHandler)
+	return db, r
+}
+
+func servePassthrough(r *gin.Engine) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestChatPassthrough(t *testing.T) {
+	db, r := setupPassthrough(t, true)
+
+	w := servePassthrough(r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	// 响应原样返回 不经过改写规则
+	if w.Body.String() != passthroughReply {
+		t.Errorf("body = %s, want upstream body unchanged", w.Body.String())
+	}
+
+	var log models.ChatLog
+	if err := db.First(&log).Error; err != nil {
+		t.Fatal(err)
+	}
+	if log.Status != "success" || log.ProviderName != "mock" {
+		t.Errorf("log status %q provider %q, want success mock", log.Status, log.ProviderName)
+	}
+	if log.TotalTokens != 0 {
+		t.Errorf("log total tokens = %d, want 0 without usage parsing", log.TotalTokens)
+	}
+}
+
+// BenchmarkChatPassthrough 对比常规路径与直通模式的单次请求耗时
+// go test ./handler -run '^$' -bench ChatPassthrough
+func BenchmarkChatPassthrough(b *testing.B) {
+	for _, bm := range []struct {
+		name        string
+		passthrough bool
+	}{{"processed", false}, {"passthrough", true}} {
+		b.Run(bm.name, func(b *testing.B) {
+			db, r := setupPassthrough(b, bm.passthrough)
+			b.ResetTimer()
+			for range b.N {
+				if w := servePassthrough(r); w.Code != http.StatusOK {
+					b.Fatalf("status = %d", w.Code)
+				}
+			}
+			b.StopTimer()
+			if !bm.passthrough {
+				// 等待异步的日志处理完成后再关闭数据库
+				waitFor(b, func() bool {
+					var count int64
+					db.Model(&models.ChatLog{}).Where("total_tokens > 0").Count(&count)
+					return count == int64(b.N)
+				})
+			}
+		})
+	}
+}
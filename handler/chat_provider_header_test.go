@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestChatProviderHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{}, &models.ChatLog{},
+		&models.ChatIO{}, &models.Config{}, &models.ProviderKey{})
+
+	model := models.Model{Name: "gpt-4o", MaxRetry: 1, TimeOut: 10}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	for _, name := range []string{"alpha", "beta"} {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"choices":[{"message":{"content":"hi"}}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`)
+		}))
+		t.Cleanup(upstream.Close)
+		provider := models.Provider{Name: name, Type: consts.StyleOpenAI, Config: `{"base_url":"` + upstream.URL + `","api_key":"sk-test"}`}
+		if err := db.Create(&provider).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o-" + name, Status: &enabled, Weight: 1}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		ctx := context.WithValue(c.Request.Context(), consts.ContextKeyAllowAllModel, true)
+		if c.GetHeader("X-Test-Auth-Key") != "" {
+			ctx = context.WithValue(ctx, consts.ContextKeyAuthKeyID, uint(1))
+		}
+		c.Request = c.Request.WithContext(ctx)
+	}, ChatCompletionsHandler)
+
+	for i, tt := range []struct {
+		name    string
+		authKey bool
+	}{
+		{"admin", false},
+		{"auth key hidden", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+			// 缓存命中的响应不返回提供商信息 重复运行时跳过缓存
+			req.Header.Set("Cache-Control", "no-store")
+			if tt.authKey {
+				req.Header.Set("X-Test-Auth-Key", "1")
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+			}
+			// 等待异步的日志记录完成
+			waitFor(t, func() bool {
+				var count int64
+				db.Model(&models.ChatLog{}).Where("total_tokens > 0").Count(&count)
+				return count == int64(i+1)
+			})
+
+			// 读取实际发送的响应头 写入响应头后再设置的值不会发给客户端
+			sent := w.Result().Header
+			provider, upstreamModel := sent.Get(headerProvider), sent.Get(headerUpstreamModel)
+			if tt.authKey {
+				if provider != "" || upstreamModel != "" {
+					t.Errorf("auth key request exposed provider %q model %q", provider, upstreamModel)
+				}
+				return
+			}
+			// 与请求日志中实际选中的提供商一致
+			var log models.ChatLog
+			if err := db.Where("status = ?", "success").Order("id DESC").First(&log).Error; err != nil {
+				t.Fatal(err)
+			}
+			if provider != log.ProviderName || upstreamModel != "gpt-4o-"+log.ProviderName {
+				t.Errorf("headers = %q %q, want %q %q", provider, upstreamModel, log.ProviderName, "gpt-4o-"+log.ProviderName)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestChatShadow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{}, &models.ChatLog{},
+		&models.ChatIO{}, &models.Config{}, &models.ProviderKey{})
+
+	model := models.Model{Name: "gpt-4o", MaxRetry: 1, TimeOut: 10}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	var shadowHits atomic.Int32
+	enabled := true
+	for _, p := range []struct {
+		name   string
+		shadow bool
+	}{
+		{"primary", false},
+		{"candidate", true},
+	} {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if p.shadow {
+				shadowHits.Add(1)
+			}
+			fmt.Fprintf(w, `{"choices":[{"message":{"content":"from %s"}}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`, p.name)
+		}))
+		t.Cleanup(upstream.Close)
+		provider := models.Provider{Name: p.name, Type: consts.StyleOpenAI, Config: `{"base_url":"` + upstream.URL + `","api_key":"sk-test"}`}
+		if err := db.Create(&provider).Error; err != nil {
+			t.Fatal(err)
+		}
+		shadow := p.shadow
+		if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o",
+			Status: &enabled, Weight: 1, Shadow: &shadow}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), consts.ContextKeyAllowAllModel, true))
+	}, ChatCompletionsHandler)
+
+	const requests = 5
+	for i := range requests {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+		req.Header.Set("Cache-Control", "no-store")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		// 客户端只收到主关联的响应
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "from primary") {
+			t.Fatalf("request %d: status = %d, body %s", i, w.Code, w.Body.String())
+		}
+	}
+	// 等待异步的影子请求与日志记录完成
+	waitFor(t, func() bool {
+		var count int64
+		db.Model(&models.ChatLog{}).Where("total_tokens > 0").Count(&count)
+		return count == 2*requests
+	})
+
+	if got := shadowHits.Load(); got != requests {
+		t.Errorf("shadow upstream hits = %d, want %d", got, requests)
+	}
+	for _, p := range []struct {
+		name   string
+		shadow bool
+	}{
+		{"primary", false},
+		{"candidate", true},
+	} {
+		var count int64
+		db.Model(&models.ChatLog{}).Where("provider_name = ? AND shadow = ?", p.name, p.shadow).Count(&count)
+		if count != requests {
+			t.Errorf("%s logs with shadow=%v = %d, want %d", p.name, p.shadow, count, requests)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestChatStreamErrorFrame(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{}, &models.ChatLog{},
+		&models.ChatIO{}, &models.Config{}, &models.ProviderKey{})
+
+	// 上游发送部分内容后断开连接
+	partial := `data: {"choices":[{"index":0,"delta":{"content":"Hel"}}]}` + "\n\n"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(partial))
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}))
+	t.Cleanup(upstream.Close)
+
+	model := models.Model{Name: "gpt-4o", MaxRetry: 1, TimeOut: 10}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	provider := models.Provider{Name: "flaky", Type: consts.StyleOpenAI, Config: `{"base_url":"` + upstream.URL + `","api_key":"sk-test"}`}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: &enabled, Weight: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), consts.ContextKeyAllowAllModel, true))
+	}, ChatCompletionsHandler)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("Cache-Control", "no-store")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	// 等待异步的日志记录完成
+	waitFor(t, func() bool {
+		var count int64
+		db.Model(&models.ChatLog{}).Where("status = ?", "error").Count(&count)
+		return count == 1
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	body := w.Body.String()
+	rest, ok := strings.CutPrefix(body, partial)
+	if !ok {
+		t.Fatalf("body = %q, want partial content first", body)
+	}
+	// 以 OpenAI 格式的错误事件结束 而不是追加的 JSON 响应
+	if !strings.HasPrefix(rest, `data: {"error":{`) || !strings.Contains(rest, `"type":"server_error"`) || !strings.HasSuffix(rest, "}}\n\n") {
+		t.Errorf("trailing frame = %q, want an OpenAI error event", rest)
+	}
+}
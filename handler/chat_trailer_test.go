@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestChatUsageTrailers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{}, &models.ChatLog{},
+		&models.ChatIO{}, &models.Config{}, &models.ProviderKey{})
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":7,\"total_tokens\":19}}\n\ndata: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+
+	provider := models.Provider{Name: "mock", Type: consts.StyleOpenAI, Config: `{"base_url":"` + upstream.URL + `","api_key":"sk-test"}`}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	model := models.Model{Name: "gpt-4o", MaxRetry: 1, TimeOut: 10}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: &enabled, Weight: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		ctx := context.WithValue(c.Request.Context(), consts.ContextKeyAllowAllModel, true)
+		c.Request = c.Request.WithContext(ctx)
+	}, ChatCompletionsHandler)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		optIn      bool
+		stream     bool
+		prompt     string
+		completion string
+	}{
+		{"stream with opt-in", true, true, "12", "7"},
+		{"stream without opt-in", false, true, "", ""},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"stream":%t}`, tt.stream)
+			req, _ := http.NewRequest(http.MethodPost, server.URL+"/v1/chat/completions", strings.NewReader(body))
+			if tt.optIn {
+				req.Header.Set(headerUsageTrailers, "1")
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			data, _ := io.ReadAll(res.Body)
+			if !strings.Contains(string(data), "[DONE]") {
+				t.Fatalf("unexpected body %s", data)
+			}
+			// trailer 在读完响应体后才可用
+			if got := res.Trailer.Get(trailerUsagePromptTokens); got != tt.prompt {
+				t.Errorf("%s = %q, want %q", trailerUsagePromptTokens, got, tt.prompt)
+			}
+			if got := res.Trailer.Get(trailerUsageCompletionTokens); got != tt.completion {
+				t.Errorf("%s = %q, want %q", trailerUsageCompletionTokens, got, tt.completion)
+			}
+
+			waitFor(t, func() bool {
+				var count int64
+				db.Model(&models.ChatLog{}).Where("total_tokens > 0").Count(&count)
+				return count == int64(i+1)
+			})
+		})
+	}
+}
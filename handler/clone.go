@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"cmp"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service/keypool"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// CloneProviderRequest 复制提供商的请求体
+type CloneProviderRequest struct {
+	Name         string `json:"name"`
+	Associations bool   `json:"associations"` // 是否同时复制该提供商的全部模型关联
+}
+
+// CloneModelProviderRequest 复制关联的请求体 未填写的字段沿用原关联
+type CloneModelProviderRequest struct {
+	ModelID       uint   `json:"model_id"`
+	ProviderID    uint   `json:"provider_id"`
+	ProviderModel string `json:"provider_name"`
+}
+
+// cloneAssociation 复制关联配置 不带冷却等运行时状态 复制后默认停用
+func cloneAssociation(src models.ModelWithProvider) models.ModelWithProvider {
+	disabled := false
+	return models.ModelWithProvider{
+		ModelID:          src.ModelID,
+		ProviderModel:    src.ProviderModel,
+		ProviderID:       src.ProviderID,
+		ToolCall:         src.ToolCall,
+		StructuredOutput: src.StructuredOutput,
+		Image:            src.Image,
+		Completion:       src.Completion,
+		Audio:            src.Audio,
+		WithHeader:       src.WithHeader,
+		NonStream:        src.NonStream,
+		Status:           &disabled,
+		CustomerHeaders:  src.CustomerHeaders,
+		ForwardHeaders:   src.ForwardHeaders,
+		Weight:           src.Weight,
+		TimeOut:          src.TimeOut,
+		Tier:             src.Tier,
+		ResponseRules:    src.ResponseRules,
+		TransformStream:  src.TransformStream,
+		Shadow:           src.Shadow,
+		InputPrice:       src.InputPrice,
+		OutputPrice:      src.OutputPrice,
+	}
+}
+
+// CloneProvider 以新名称复制提供商 可选复制其全部模型关联
+// 提供商本身没有启停状态 复制出的关联全部停用 修改配置后再逐个启用
+func CloneProvider(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+
+	var req CloneProviderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		common.BadRequest(c, "name is required")
+		return
+	}
+
+	ctx := c.Request.Context()
+	source, err := gorm.G[models.Provider](models.DB).Where("id = ?", id).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.NotFound(c, "Provider not found")
+			return
+		}
+		common.InternalServerError(c, "Database error: "+err.Error())
+		return
+	}
+
+	count, err := gorm.G[models.Provider](models.DB).Where("name = ?", req.Name).Count(ctx, "id")
+	if err != nil {
+		common.InternalServerError(c, "Database error: "+err.Error())
+		return
+	}
+	if count > 0 {
+		common.BadRequest(c, "Provider already exists")
+		return
+	}
+
+	provider := models.Provider{
+		Name:    req.Name,
+		Type:    source.Type,
+		Config:  source.Config,
+		Console: source.Console,
+	}
+	associationIDs := make([]uint, 0)
+	var clones []models.ModelWithProvider
+	if err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := gorm.G[models.Provider](tx).Create(ctx, &provider); err != nil {
+			return err
+		}
+		if !req.Associations {
+			return nil
+		}
+		associations, err := gorm.G[models.ModelWithProvider](tx).Where("provider_id = ?", source.ID).Order("id").Find(ctx)
+		if err != nil {
+			return err
+		}
+		for _, association := range associations {
+			clone := cloneAssociation(association)
+			clone.ProviderID = provider.ID
+			if err := gorm.G[models.ModelWithProvider](tx).Create(ctx, &clone); err != nil {
+				return err
+			}
+			if association.Weight == 0 {
+				if err := saveStandbyWeight(ctx, tx, &clone); err != nil {
+					return err
+				}
+			}
+			associationIDs = append(associationIDs, clone.ID)
+			clones = append(clones, clone)
+		}
+		return nil
+	}); err != nil {
+		common.InternalServerError(c, "Failed to clone provider: "+err.Error())
+		return
+	}
+
+	// 与创建提供商一致 同步配置中的 keys 到 key pool 新 key 不带原提供商的冷却状态
+	if err := keypool.SyncProviderConfigKeys(ctx, models.DB, provider.ID, provider.Config); err != nil {
+		slog.Warn("Failed to sync provider keys", "error", err, "provider_id", provider.ID)
+	}
+
+	recordAudit(c, models.AuditActionCreate, models.AuditTargetProvider, provider.ID, nil, provider)
+	for _, clone := range clones {
+		recordAudit(c, models.AuditActionCreate, models.AuditTargetModelProvider, clone.ID, nil, clone)
+	}
+	common.Success(c, gin.H{
+		"id":              provider.ID,
+		"association_ids": associationIDs,
+	})
+}
+
+// CloneModelProvider 复制单个模型关联 可替换模型、提供商或提供商模型名
+func CloneModelProvider(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+
+	var req CloneModelProviderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	source, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", id).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.NotFound(c, "Model-provider association not found")
+			return
+		}
+		common.InternalServerError(c, "Database error: "+err.Error())
+		return
+	}
+
+	clone := cloneAssociation(source)
+	clone.ModelID = cmp.Or(req.ModelID, source.ModelID)
+	clone.ProviderID = cmp.Or(req.ProviderID, source.ProviderID)
+	clone.ProviderModel = cmp.Or(req.ProviderModel, source.ProviderModel)
+
+	if _, err := gorm.G[models.Model](models.DB).Where("id = ?", clone.ModelID).First(ctx); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.BadRequest(c, "Model not found")
+			return
+		}
+		common.InternalServerError(c, "Database error: "+err.Error())
+		return
+	}
+	if _, err := gorm.G[models.Provider](models.DB).Where("id = ?", clone.ProviderID).First(ctx); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.BadRequest(c, "Provider not found")
+			return
+		}
+		common.InternalServerError(c, "Database error: "+err.Error())
+		return
+	}
+	// 三者都不变时与原关联重复
+	if rejectDuplicateModelProvider(c, clone.ModelID, clone.ProviderID, clone.ProviderModel, 0) {
+		return
+	}
+
+	if err := gorm.G[models.ModelWithProvider](models.DB).Create(ctx, &clone); err != nil {
+		common.InternalServerError(c, "Failed to clone model-provider association: "+err.Error())
+		return
+	}
+	if source.Weight == 0 {
+		if err := saveStandbyWeight(ctx, models.DB, &clone); err != nil {
+			common.InternalServerError(c, "Failed to clone model-provider association: "+err.Error())
+			return
+		}
+	}
+
+	recordAudit(c, models.AuditActionCreate, models.AuditTargetModelProvider, clone.ID, nil, clone)
+	common.Success(c, gin.H{"id": clone.ID})
+}
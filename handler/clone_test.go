@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCloneProvider(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{}, &models.ProviderKey{})
+
+	source := models.Provider{Name: "east", Type: "openai", Config: `{"base_url":"https://east.example.com/v1","api_key":"sk-east"}`}
+	if err := db.Create(&source).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	cooldownUntil := time.Now().Add(time.Hour)
+	var modelIDs []uint
+	for _, name := range []string{"gpt-4o", "gpt-4o-mini"} {
+		model := models.Model{Name: name}
+		if err := db.Create(&model).Error; err != nil {
+			t.Fatal(err)
+		}
+		modelIDs = append(modelIDs, model.ID)
+		if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: source.ID, ProviderModel: name, Status: &enabled,
+			Weight: 3, Tier: 1, CustomerHeaders: map[string]string{"X-Region": "east"},
+			ProviderCooldownUntil: &cooldownUntil, ProviderCooldownStep: 2}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := gin.New()
+	r.POST("/providers/:id/clone", CloneProvider)
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		code int64
+	}{
+		{"missing name", "/providers/1/clone", `{}`, 400},
+		{"name taken", "/providers/1/clone", `{"name":"east"}`, 400},
+		{"unknown provider", "/providers/99/clone", `{"name":"west"}`, 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if res := doJSON(r, http.MethodPost, tt.path, tt.body); res.Get("code").Int() != tt.code {
+				t.Errorf("code = %d, want %d: %s", res.Get("code").Int(), tt.code, res.Raw)
+			}
+		})
+	}
+
+	// 仅复制提供商
+	res := doJSON(r, http.MethodPost, "/providers/1/clone", `{"name":"bare"}`)
+	if res.Get("code").Int() != 200 || len(res.Get("data.association_ids").Array()) != 0 {
+		t.Fatalf("clone without associations: %s", res.Raw)
+	}
+
+	res = doJSON(r, http.MethodPost, "/providers/1/clone", `{"name":"west","associations":true}`)
+	if res.Get("code").Int() != 200 {
+		t.Fatalf("clone failed: %s", res.Raw)
+	}
+	cloneID := uint(res.Get("data.id").Uint())
+	var clone models.Provider
+	if err := db.First(&clone, cloneID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if clone.Name != "west" || clone.Type != source.Type || clone.Config != source.Config {
+		t.Errorf("cloned provider = %+v", clone)
+	}
+
+	ids := res.Get("data.association_ids").Array()
+	if len(ids) != 2 {
+		t.Fatalf("expected 2 cloned associations, got %s", res.Raw)
+	}
+	for i, id := range ids {
+		var mp models.ModelWithProvider
+		if err := db.First(&mp, id.Uint()).Error; err != nil {
+			t.Fatal(err)
+		}
+		if mp.ProviderID != cloneID || mp.ModelID != modelIDs[i] {
+			t.Errorf("association %d references provider %d model %d, want %d %d", mp.ID, mp.ProviderID, mp.ModelID, cloneID, modelIDs[i])
+		}
+		if mp.Status == nil || *mp.Status {
+			t.Errorf("association %d should start disabled", mp.ID)
+		}
+		if mp.Weight != 3 || mp.Tier != 1 || mp.CustomerHeaders["X-Region"] != "east" {
+			t.Errorf("association %d lost settings: %+v", mp.ID, mp)
+		}
+		if mp.ProviderCooldownUntil != nil || mp.ProviderCooldownStep != 0 {
+			t.Errorf("association %d copied cooldown state", mp.ID)
+		}
+	}
+
+	// 原关联保持不变
+	var sourceCount int64
+	db.Model(&models.ModelWithProvider{}).Where("provider_id = ? AND status = ?", source.ID, true).Count(&sourceCount)
+	if sourceCount != 2 {
+		t.Errorf("source associations = %d, want 2", sourceCount)
+	}
+}
+
+func TestCloneModelProvider(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{})
+
+	for _, name := range []string{"east", "west"} {
+		if err := db.Create(&models.Provider{Name: name, Type: "openai", Config: "{}"}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Create(&models.Model{Name: "gpt-4o"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	cooldownUntil := time.Now().Add(time.Hour)
+	if err := db.Create(&models.ModelWithProvider{ModelID: 1, ProviderID: 1, ProviderModel: "gpt-4o", Status: &enabled, Weight: 5,
+		KeyCooldownUntil: &cooldownUntil, KeyCooldownStep: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.POST("/model-providers/:id/clone", CloneModelProvider)
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		code int64
+	}{
+		{"unchanged is duplicate", "/model-providers/1/clone", `{}`, 400},
+		{"unknown association", "/model-providers/9/clone", `{}`, 404},
+		{"unknown provider", "/model-providers/1/clone", `{"provider_id":9}`, 400},
+		{"other provider", "/model-providers/1/clone", `{"provider_id":2}`, 200},
+		{"other provider model", "/model-providers/1/clone", `{"provider_name":"gpt-4o-2024-08-06"}`, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := doJSON(r, http.MethodPost, tt.path, tt.body)
+			if res.Get("code").Int() != tt.code {
+				t.Fatalf("code = %d, want %d: %s", res.Get("code").Int(), tt.code, res.Raw)
+			}
+			if tt.code != 200 {
+				return
+			}
+			var mp models.ModelWithProvider
+			if err := db.First(&mp, res.Get("data.id").Uint()).Error; err != nil {
+				t.Fatal(err)
+			}
+			if mp.ModelID != 1 || mp.Weight != 5 || mp.KeyCooldownUntil != nil || mp.Status == nil || *mp.Status {
+				t.Errorf("cloned association = %+v", mp)
+			}
+		})
+	}
+}
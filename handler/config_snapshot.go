@@ -0,0 +1,498 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/providers"
+	"github.com/atopos31/llmio/service/keypool"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"gorm.io/gorm"
+)
+
+// snapshotVersion 配置快照格式版本
+const snapshotVersion = 1
+
+// redactedSecret 导出时替换密钥的占位值 导入时按提供商名称沿用已有的密钥
+const redactedSecret = "<redacted>"
+
+// 导入模式
+const (
+	ImportModeMerge   = "merge"   // 只创建不存在的项 已存在且不同的项作为冲突报告 不修改
+	ImportModeReplace = "replace" // 删除现有提供商、模型与关联后按快照重建
+)
+
+// providerSecretPaths 提供商配置中的密钥字段 keys 数组中的 term 单独处理
+var providerSecretPaths = []string{"api_key", "secret_access_key", "session_token"}
+
+// ConfigSnapshot 路由配置快照 关联通过名称引用模型与提供商 导入时重新分配 id
+type ConfigSnapshot struct {
+	Version      int                   `json:"version"`
+	Secrets      bool                  `json:"secrets"` // 是否包含密钥
+	Providers    []SnapshotProvider    `json:"providers"`
+	Models       []models.Model        `json:"models"`
+	Associations []SnapshotAssociation `json:"associations"`
+}
+
+type SnapshotProvider struct {
+	Name    string        `json:"name"`
+	Type    string        `json:"type"`
+	Config  string        `json:"config"`
+	Console string        `json:"console"`
+	Keys    []SnapshotKey `json:"keys,omitempty"` // key 池中的 key 仅在包含密钥时导出
+}
+
+// SnapshotKey key 池中的 key 不含冷却与用量等运行时状态
+type SnapshotKey struct {
+	Key          string `json:"key"`
+	Remark       string `json:"remark"`
+	Status       bool   `json:"status"`
+	Weight       int    `json:"weight"`
+	Budget       int    `json:"budget"`
+	BudgetRefill int    `json:"budget_refill"`
+	// 有效期 为空不限
+	ValidFrom  *time.Time `json:"valid_from,omitempty"`
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+}
+
+type SnapshotAssociation struct {
+	Model    string `json:"model"`
+	Provider string `json:"provider"`
+	models.ModelWithProvider
+}
+
+// ImportCounts 各类配置的数量
+type ImportCounts struct {
+	Providers    int `json:"providers"`
+	Models       int `json:"models"`
+	Associations int `json:"associations"`
+}
+
+// ImportReport 导入结果 冲突项未被导入
+type ImportReport struct {
+	Mode      string       `json:"mode"`
+	Created   ImportCounts `json:"created"`
+	Unchanged ImportCounts `json:"unchanged"` // 已存在且与快照一致
+	Conflicts []string     `json:"conflicts"`
+}
+
+// ExportConfig 导出全部提供商、模型与关联 secrets=true 时包含密钥
+func ExportConfig(c *gin.Context) {
+	secrets := false
+	if value := c.Query("secrets"); value != "" {
+		var err error
+		if secrets, err = strconv.ParseBool(value); err != nil {
+			common.BadRequest(c, "Invalid secrets option: "+value)
+			return
+		}
+	}
+	snapshot, err := buildConfigSnapshot(c.Request.Context(), secrets)
+	if err != nil {
+		common.InternalServerError(c, "Failed to export config: "+err.Error())
+		return
+	}
+	common.Success(c, snapshot)
+}
+
+func buildConfigSnapshot(ctx context.Context, secrets bool) (*ConfigSnapshot, error) {
+	providerList, err := gorm.G[models.Provider](models.DB).Order("id").Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	modelList, err := gorm.G[models.Model](models.DB).Order("id").Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	associations, err := gorm.G[models.ModelWithProvider](models.DB).Order("id").Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &ConfigSnapshot{
+		Version:      snapshotVersion,
+		Secrets:      secrets,
+		Providers:    make([]SnapshotProvider, 0, len(providerList)),
+		Models:       make([]models.Model, 0, len(modelList)),
+		Associations: make([]SnapshotAssociation, 0, len(associations)),
+	}
+	providerNames := make(map[uint]string, len(providerList))
+	for _, provider := range providerList {
+		providerNames[provider.ID] = provider.Name
+		item := SnapshotProvider{Name: provider.Name, Type: provider.Type, Config: provider.Config, Console: provider.Console}
+		if !secrets {
+			if item.Config, err = redactProviderConfig(provider.Config); err != nil {
+				return nil, fmt.Errorf("provider %s: %w", provider.Name, err)
+			}
+			snapshot.Providers = append(snapshot.Providers, item)
+			continue
+		}
+		keys, err := gorm.G[models.ProviderKey](models.DB).Where("provider_id = ?", provider.ID).Order("id").Find(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			item.Keys = append(item.Keys, SnapshotKey{
+				Key:          key.Key,
+				Remark:       key.Remark,
+				Status:       key.Status,
+				Weight:       key.Weight,
+				Budget:       key.Budget,
+				BudgetRefill: key.BudgetRefill,
+				ValidFrom:    key.ValidFrom,
+				ValidUntil:   key.ValidUntil,
+			})
+		}
+		snapshot.Providers = append(snapshot.Providers, item)
+	}
+	modelNames := make(map[uint]string, len(modelList))
+	for _, model := range modelList {
+		modelNames[model.ID] = model.Name
+		model.Model = gorm.Model{}
+		snapshot.Models = append(snapshot.Models, model)
+	}
+	for _, association := range associations {
+		modelName, okModel := modelNames[association.ModelID]
+		providerName, okProvider := providerNames[association.ProviderID]
+		if !okModel || !okProvider {
+			// 模型或提供商已删除的关联不会被使用
+			continue
+		}
+		snapshot.Associations = append(snapshot.Associations, SnapshotAssociation{
+			Model:             modelName,
+			Provider:          providerName,
+			ModelWithProvider: normalizeAssociation(association),
+		})
+	}
+	return snapshot, nil
+}
+
+// redactProviderConfig 将配置中的密钥替换为占位值
+func redactProviderConfig(config string) (string, error) {
+	var err error
+	for _, path := range providerSecretPaths {
+		if gjson.Get(config, path).String() == "" {
+			continue
+		}
+		if config, err = sjson.Set(config, path, redactedSecret); err != nil {
+			return "", err
+		}
+	}
+	for i := range gjson.Get(config, "keys").Array() {
+		path := fmt.Sprintf("keys.%d.term", i)
+		if gjson.Get(config, path).String() == "" {
+			continue
+		}
+		if config, err = sjson.Set(config, path, redactedSecret); err != nil {
+			return "", err
+		}
+	}
+	return config, nil
+}
+
+// restoreProviderSecrets 将占位值还原为同名提供商已有的密钥 没有可沿用的值时移除该项
+func restoreProviderSecrets(config, existing string) (string, error) {
+	var err error
+	for _, path := range providerSecretPaths {
+		if gjson.Get(config, path).String() != redactedSecret {
+			continue
+		}
+		if value := gjson.Get(existing, path); value.String() != "" {
+			config, err = sjson.Set(config, path, value.String())
+		} else {
+			config, err = sjson.Delete(config, path)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	// 按下标沿用 key 倒序处理使删除不影响后续下标
+	keys := gjson.Get(config, "keys").Array()
+	for i := len(keys) - 1; i >= 0; i-- {
+		if keys[i].Get("term").String() != redactedSecret {
+			continue
+		}
+		if term := gjson.Get(existing, fmt.Sprintf("keys.%d.term", i)).String(); term != "" {
+			config, err = sjson.Set(config, fmt.Sprintf("keys.%d.term", i), term)
+		} else {
+			config, err = sjson.Delete(config, fmt.Sprintf("keys.%d", i))
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return config, nil
+}
+
+// normalizeAssociation 去除 id 与冷却等运行时状态 用于导出与比较
+func normalizeAssociation(mp models.ModelWithProvider) models.ModelWithProvider {
+	mp.Model = gorm.Model{}
+	mp.ModelID = 0
+	mp.ProviderID = 0
+	mp.KeyCooldownUntil = nil
+	mp.KeyCooldownStep = 0
+	mp.ProviderCooldownUntil = nil
+	mp.ProviderCooldownStep = 0
+	return mp
+}
+
+// sameJSON 两个值序列化后的 JSON 是否等价 忽略字段顺序与空白
+func sameJSON(a, b any) bool {
+	normalize := func(v any) string {
+		data, _ := json.Marshal(v)
+		if s, ok := v.(string); ok {
+			data = []byte(s)
+		}
+		var value any
+		if err := json.Unmarshal(data, &value); err != nil {
+			return string(data)
+		}
+		data, _ = json.Marshal(value)
+		return string(data)
+	}
+	return normalize(a) == normalize(b)
+}
+
+// validateSnapshot 检查快照版本与名称 导入前拒绝格式错误的快照
+func validateSnapshot(snapshot ConfigSnapshot) error {
+	if snapshot.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", snapshot.Version)
+	}
+	providerNames := make(map[string]bool, len(snapshot.Providers))
+	for i, provider := range snapshot.Providers {
+		if provider.Name == "" {
+			return fmt.Errorf("providers[%d]: name is required", i)
+		}
+		if providerNames[provider.Name] {
+			return fmt.Errorf("providers[%d]: duplicate name %s", i, provider.Name)
+		}
+		providerNames[provider.Name] = true
+	}
+	modelNames := make(map[string]bool, len(snapshot.Models))
+	for i, model := range snapshot.Models {
+		if model.Name == "" {
+			return fmt.Errorf("models[%d]: name is required", i)
+		}
+		if modelNames[model.Name] {
+			return fmt.Errorf("models[%d]: duplicate name %s", i, model.Name)
+		}
+		modelNames[model.Name] = true
+	}
+	for i, association := range snapshot.Associations {
+		if association.Model == "" || association.Provider == "" || association.ProviderModel == "" {
+			return fmt.Errorf("associations[%d]: model, provider and ProviderModel are required", i)
+		}
+	}
+	return nil
+}
+
+// ImportConfig 按快照导入配置 mode 为 merge(默认) 或 replace 整个导入在同一事务中完成
+func ImportConfig(c *gin.Context) {
+	mode := c.DefaultQuery("mode", ImportModeMerge)
+	if mode != ImportModeMerge && mode != ImportModeReplace {
+		common.BadRequest(c, "Invalid import mode: "+mode)
+		return
+	}
+	var snapshot ConfigSnapshot
+	if err := c.ShouldBindJSON(&snapshot); err != nil {
+		common.BadRequest(c, "Invalid snapshot: "+err.Error())
+		return
+	}
+	if err := validateSnapshot(snapshot); err != nil {
+		common.BadRequest(c, "Invalid snapshot: "+err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	report := ImportReport{Mode: mode, Conflicts: []string{}}
+	var created []models.Provider
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		created, err = importSnapshot(ctx, tx, snapshot, mode, &report)
+		return err
+	})
+	if err != nil {
+		var invalid invalidSnapshotError
+		if errors.As(err, &invalid) {
+			common.BadRequest(c, "Invalid snapshot: "+err.Error())
+			return
+		}
+		common.InternalServerError(c, "Failed to import config: "+err.Error())
+		return
+	}
+
+	// 与创建提供商一致 同步配置中的 keys 到 key pool
+	for _, provider := range created {
+		if err := keypool.SyncProviderConfigKeys(ctx, models.DB, provider.ID, provider.Config); err != nil {
+			slog.Warn("Failed to sync provider keys", "error", err, "provider_id", provider.ID)
+		}
+	}
+	// 导入整体记录一条 各项数量见导入报告
+	recordAudit(c, models.AuditActionImport, models.AuditTargetConfig, "snapshot", nil, report)
+	common.Success(c, report)
+}
+
+// invalidSnapshotError 快照内容无效 导入回滚并返回 400
+type invalidSnapshotError struct{ error }
+
+func importSnapshot(ctx context.Context, tx *gorm.DB, snapshot ConfigSnapshot, mode string, report *ImportReport) ([]models.Provider, error) {
+	existingProviders, err := gorm.G[models.Provider](tx).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// 替换模式下删除前记录原配置 脱敏快照仍可沿用同名提供商的密钥
+	secretSource := make(map[string]string, len(existingProviders))
+	for _, provider := range existingProviders {
+		secretSource[provider.Name] = provider.Config
+	}
+
+	providerIDs := make(map[string]uint)
+	modelIDs := make(map[string]uint)
+	existingProviderMap := make(map[string]models.Provider)
+	existingModelMap := make(map[string]models.Model)
+	existingAssociations := make(map[string]models.ModelWithProvider)
+	associationKey := func(modelID, providerID uint, providerModel string) string {
+		return fmt.Sprintf("%d|%d|%s", modelID, providerID, providerModel)
+	}
+
+	if mode == ImportModeReplace {
+		for _, table := range []any{&models.ModelWithProvider{}, &models.ProviderKey{}, &models.Model{}, &models.Provider{}} {
+			if err := tx.WithContext(ctx).Where("1 = 1").Delete(table).Error; err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		for _, provider := range existingProviders {
+			existingProviderMap[provider.Name] = provider
+			providerIDs[provider.Name] = provider.ID
+		}
+		modelList, err := gorm.G[models.Model](tx).Find(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, model := range modelList {
+			existingModelMap[model.Name] = model
+			modelIDs[model.Name] = model.ID
+		}
+		associations, err := gorm.G[models.ModelWithProvider](tx).Find(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, association := range associations {
+			existingAssociations[associationKey(association.ModelID, association.ProviderID, association.ProviderModel)] = association
+		}
+	}
+
+	var created []models.Provider
+	for _, item := range snapshot.Providers {
+		config, err := restoreProviderSecrets(item.Config, secretSource[item.Name])
+		if err != nil {
+			return nil, invalidSnapshotError{fmt.Errorf("provider %s: %w", item.Name, err)}
+		}
+		if _, err := providers.New(item.Type, config); err != nil {
+			return nil, invalidSnapshotError{fmt.Errorf("provider %s: %w", item.Name, err)}
+		}
+		if existing, ok := existingProviderMap[item.Name]; ok {
+			if existing.Type == item.Type && existing.Console == item.Console && sameJSON(existing.Config, config) {
+				report.Unchanged.Providers++
+			} else {
+				report.Conflicts = append(report.Conflicts, fmt.Sprintf("provider %s differs from existing", item.Name))
+			}
+			continue
+		}
+		provider := models.Provider{Name: item.Name, Type: item.Type, Config: config, Console: item.Console}
+		if err := gorm.G[models.Provider](tx).Create(ctx, &provider); err != nil {
+			return nil, err
+		}
+		for _, key := range item.Keys {
+			if err := createSnapshotKey(ctx, tx, provider.ID, key); err != nil {
+				return nil, err
+			}
+		}
+		providerIDs[item.Name] = provider.ID
+		created = append(created, provider)
+		report.Created.Providers++
+	}
+
+	for _, model := range snapshot.Models {
+		model.Model = gorm.Model{}
+		if existing, ok := existingModelMap[model.Name]; ok {
+			existing.Model = gorm.Model{}
+			if sameJSON(existing, model) {
+				report.Unchanged.Models++
+			} else {
+				report.Conflicts = append(report.Conflicts, fmt.Sprintf("model %s differs from existing", model.Name))
+			}
+			continue
+		}
+		if err := gorm.G[models.Model](tx).Create(ctx, &model); err != nil {
+			return nil, err
+		}
+		modelIDs[model.Name] = model.ID
+		report.Created.Models++
+	}
+
+	for _, item := range snapshot.Associations {
+		name := fmt.Sprintf("association %s/%s/%s", item.Model, item.Provider, item.ProviderModel)
+		modelID, okModel := modelIDs[item.Model]
+		providerID, okProvider := providerIDs[item.Provider]
+		if !okModel || !okProvider {
+			report.Conflicts = append(report.Conflicts, name+" references a missing model or provider")
+			continue
+		}
+		association := normalizeAssociation(item.ModelWithProvider)
+		if existing, ok := existingAssociations[associationKey(modelID, providerID, item.ProviderModel)]; ok {
+			if sameJSON(normalizeAssociation(existing), association) {
+				report.Unchanged.Associations++
+			} else {
+				report.Conflicts = append(report.Conflicts, name+" differs from existing")
+			}
+			continue
+		}
+		association.ModelID = modelID
+		association.ProviderID = providerID
+		if err := gorm.G[models.ModelWithProvider](tx).Create(ctx, &association); err != nil {
+			return nil, err
+		}
+		// weight 带数据库默认值 备用关联需单独写入 0
+		if item.Weight == 0 {
+			if err := saveStandbyWeight(ctx, tx, &association); err != nil {
+				return nil, err
+			}
+		}
+		existingAssociations[associationKey(modelID, providerID, item.ProviderModel)] = association
+		report.Created.Associations++
+	}
+	return created, nil
+}
+
+// createSnapshotKey 创建 key 池中的 key 停用状态与零权重需单独写入以覆盖数据库默认值
+func createSnapshotKey(ctx context.Context, tx *gorm.DB, providerID uint, key SnapshotKey) error {
+	providerKey := models.ProviderKey{
+		ProviderID:   providerID,
+		Key:          key.Key,
+		Remark:       key.Remark,
+		Status:       key.Status,
+		Weight:       key.Weight,
+		Budget:       key.Budget,
+		BudgetRefill: key.BudgetRefill,
+		ValidFrom:    key.ValidFrom,
+		ValidUntil:   key.ValidUntil,
+	}
+	if err := gorm.G[models.ProviderKey](tx).Create(ctx, &providerKey); err != nil {
+		return err
+	}
+	_, err := gorm.G[models.ProviderKey](tx).Where("id = ?", providerKey.ID).Select("status", "weight").Updates(ctx, models.ProviderKey{
+		Status: key.Status,
+		Weight: key.Weight,
+	})
+	return err
+}
@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+func configSnapshotRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/config/export", ExportConfig)
+	r.POST("/config/import", ImportConfig)
+	return r
+}
+
+func setupSnapshotDB(t *testing.T) *gorm.DB {
+	return setupTestDB(t, &models.Provider{}, &models.ProviderKey{}, &models.Model{}, &models.ModelWithProvider{})
+}
+
+func seedSnapshotConfig(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	provider := models.Provider{Name: "openai", Type: "openai", Config: `{"base_url":"https://api.openai.com/v1","api_key":"sk-secret"}`}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.ProviderKey{ProviderID: provider.ID, Key: "sk-pool", Remark: "pool", Weight: 3}).Error; err != nil {
+		t.Fatal(err)
+	}
+	model := models.Model{Name: "gpt", MaxRetry: 2, TimeOut: 30}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Weight: 5}).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigSnapshotRoundTrip(t *testing.T) {
+	db := setupSnapshotDB(t)
+	seedSnapshotConfig(t, db)
+	// 已过期的 key 导入后仍应保持过期
+	retired := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	if err := db.Create(&models.ProviderKey{ProviderID: 1, Key: "sk-retired", Weight: 1, ValidUntil: &retired}).Error; err != nil {
+		t.Fatal(err)
+	}
+	r := configSnapshotRouter()
+
+	exported := doJSON(r, "GET", "/config/export?secrets=true", "")
+	if exported.Get("code").Int() != 200 {
+		t.Fatalf("export failed: %s", exported.Raw)
+	}
+	data := exported.Get("data").Raw
+	if got := exported.Get("data.providers.0.keys.0.key").String(); got != "sk-pool" {
+		t.Fatalf("expected pool key in export, got %q", got)
+	}
+
+	// 导入到空库后再次导出应与原快照一致
+	setupSnapshotDB(t)
+	res := doJSON(r, "POST", "/config/import?mode=replace", data)
+	if res.Get("code").Int() != 200 {
+		t.Fatalf("import failed: %s", res.Raw)
+	}
+	if got := res.Get("data.created").Raw; got != `{"providers":1,"models":1,"associations":1}` {
+		t.Fatalf("unexpected created counts: %s", got)
+	}
+	again := doJSON(r, "GET", "/config/export?secrets=true", "")
+	if !sameJSON(again.Get("data").Raw, data) {
+		t.Fatalf("round trip mismatch:\n%s\n%s", data, again.Get("data").Raw)
+	}
+	key, err := gorm.G[models.ProviderKey](models.DB).Where("key = ?", "sk-pool").First(t.Context())
+	if err != nil || key.Weight != 3 || !key.Status {
+		t.Fatalf("unexpected pool key: %+v, %v", key, err)
+	}
+	key, err = gorm.G[models.ProviderKey](models.DB).Where("key = ?", "sk-retired").First(t.Context())
+	if err != nil || key.ValidUntil == nil || !key.ValidUntil.Equal(retired) {
+		t.Fatalf("expected retired key to keep its validity window: %+v, %v", key, err)
+	}
+
+	// 合并模式下重复导入不产生变化
+	res = doJSON(r, "POST", "/config/import", data)
+	if got := res.Get("data.unchanged").Raw; got != `{"providers":1,"models":1,"associations":1}` {
+		t.Fatalf("expected everything unchanged, got %s", res.Raw)
+	}
+}
+
+func TestConfigSnapshotRedactedSecrets(t *testing.T) {
+	db := setupSnapshotDB(t)
+	seedSnapshotConfig(t, db)
+	r := configSnapshotRouter()
+
+	exported := doJSON(r, "GET", "/config/export", "")
+	config := exported.Get("data.providers.0.config").String()
+	if gjson.Get(config, "api_key").String() != redactedSecret {
+		t.Fatalf("expected redacted api_key, got %s", config)
+	}
+	if exported.Get("data.providers.0.keys").Exists() {
+		t.Fatalf("expected pool keys omitted, got %s", exported.Raw)
+	}
+
+	// 替换模式沿用同名提供商原有的密钥
+	res := doJSON(r, "POST", "/config/import?mode=replace", exported.Get("data").Raw)
+	if res.Get("code").Int() != 200 {
+		t.Fatalf("import failed: %s", res.Raw)
+	}
+	provider, err := gorm.G[models.Provider](models.DB).Where("name = ?", "openai").First(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gjson.Get(provider.Config, "api_key").String(); got != "sk-secret" {
+		t.Fatalf("expected restored api_key, got %q", got)
+	}
+}
+
+func TestImportConfigConflicts(t *testing.T) {
+	db := setupSnapshotDB(t)
+	seedSnapshotConfig(t, db)
+	r := configSnapshotRouter()
+
+	snapshot := `{"version":1,"providers":[
+		{"name":"openai","type":"openai","config":"{\"base_url\":\"https://other/v1\",\"api_key\":\"sk\"}"},
+		{"name":"new","type":"openai","config":"{\"base_url\":\"https://new/v1\",\"api_key\":\"sk\"}"}],
+	"models":[{"Name":"gpt","MaxRetry":9},{"Name":"fresh","MaxRetry":1}],
+	"associations":[
+		{"model":"fresh","provider":"new","ProviderModel":"m","Weight":0},
+		{"model":"missing","provider":"new","ProviderModel":"m"}]}`
+	res := doJSON(r, "POST", "/config/import", snapshot)
+	if res.Get("code").Int() != 200 {
+		t.Fatalf("import failed: %s", res.Raw)
+	}
+	if got := res.Get("data.created").Raw; got != `{"providers":1,"models":1,"associations":1}` {
+		t.Fatalf("unexpected created counts: %s", res.Raw)
+	}
+	if got := res.Get("data.conflicts.#").Int(); got != 3 {
+		t.Fatalf("expected 3 conflicts, got %s", res.Get("data.conflicts").Raw)
+	}
+	existing, err := gorm.G[models.Model](models.DB).Where("name = ?", "gpt").First(t.Context())
+	if err != nil || existing.MaxRetry != 2 {
+		t.Fatalf("expected existing model untouched, got %+v, %v", existing, err)
+	}
+	standby, err := gorm.G[models.ModelWithProvider](models.DB).Where("provider_model = ?", "m").First(t.Context())
+	if err != nil || standby.Weight != 0 {
+		t.Fatalf("expected standby association, got %+v, %v", standby, err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		snapshot string
+	}{
+		{"bad mode", "/config/import?mode=overwrite", `{"version":1}`},
+		{"bad version", "/config/import", `{"version":2}`},
+		{"duplicate provider", "/config/import", `{"version":1,"providers":[{"name":"a","type":"openai","config":"{}"},{"name":"a","type":"openai","config":"{}"}]}`},
+		{"unknown type", "/config/import?mode=replace", `{"version":1,"providers":[{"name":"a","type":"nope","config":"{}"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if res := doJSON(r, "POST", tt.path, tt.snapshot); res.Get("code").Int() != 400 {
+				t.Fatalf("expected 400, got %s", res.Raw)
+			}
+		})
+	}
+	// 校验失败的替换导入已回滚
+	if count, _ := gorm.G[models.Provider](models.DB).Count(t.Context(), "id"); count != 2 {
+		t.Fatalf("expected rollback to keep 2 providers, got %d", count)
+	}
+}
@@ -86,11 +86,7 @@ func CountTokens(c *gin.Context) {
 
 	c.Status(res.StatusCode)
 
-	for k, values := range res.Header {
-		for _, value := range values {
-			c.Writer.Header().Add(k, value)
-		}
-	}
+	copyResponseHeader(c.Writer.Header(), res.Header)
 	c.Writer.Flush()
 
 	if _, err := io.Copy(c.Writer, res.Body); err != nil {
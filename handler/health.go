@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+)
+
+const readinessTimeout = 3 * time.Second
+
+// ComponentStatus 单个组件的健康状态
+type ComponentStatus struct {
+	Status string `json:"status"` // ok, disabled or error
+	Error  string `json:"error,omitempty"`
+}
+
+// ReadinessRes 就绪检查结果
+type ReadinessRes struct {
+	Status          string                     `json:"status"`
+	ActiveProviders int64                      `json:"active_providers"`
+	Components      map[string]ComponentStatus `json:"components"`
+}
+
+// Healthz 存活检查，进程可响应即返回 200
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz 就绪检查，数据库不可用或没有可用提供商时返回 503
+func Readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	res := ReadinessRes{
+		Status:     "ok",
+		Components: make(map[string]ComponentStatus),
+	}
+	ready := true
+
+	if err := models.DB.WithContext(ctx).Exec("SELECT 1").Error; err != nil {
+		ready = false
+		res.Components["database"] = ComponentStatus{Status: "error", Error: "database unreachable"}
+	} else {
+		res.Components["database"] = ComponentStatus{Status: "ok"}
+
+		// 统计存在启用关联的提供商数量
+		if err := models.DB.WithContext(ctx).Model(&models.ModelWithProvider{}).
+			Where("status = ?", true).
+			Distinct("provider_id").
+			Count(&res.ActiveProviders).Error; err != nil {
+			ready = false
+			res.Components["providers"] = ComponentStatus{Status: "error", Error: "failed to count providers"}
+		} else if res.ActiveProviders == 0 {
+			ready = false
+			res.Components["providers"] = ComponentStatus{Status: "error", Error: "no active providers"}
+		} else {
+			res.Components["providers"] = ComponentStatus{Status: "ok"}
+		}
+	}
+
+	res.Components["cache"] = cacheStatus(ctx)
+	if res.Components["cache"].Status == "error" {
+		ready = false
+	}
+
+	if !ready {
+		res.Status = "unavailable"
+		c.JSON(http.StatusServiceUnavailable, res)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// cacheStatus 检查缓存后端，支持 Ping 的后端（如远程缓存）会实际探测连通性
+func cacheStatus(ctx context.Context) ComponentStatus {
+	if chatCache == nil {
+		return ComponentStatus{Status: "disabled"}
+	}
+	if pinger, ok := chatCache.(interface{ Ping(context.Context) error }); ok {
+		if err := pinger.Ping(ctx); err != nil {
+			return ComponentStatus{Status: "error", Error: "cache unreachable"}
+		}
+	}
+	return ComponentStatus{Status: "ok"}
+}
@@ -1,19 +1,19 @@
 package handler
 
 import (
-	"database/sql"
 	"strconv"
 	"time"
 
 	"github.com/atopos31/llmio/common"
-	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
+	"github.com/samber/lo"
 )
 
 type MetricsRes struct {
-	Reqs   int64 `json:"reqs"`
-	Tokens int64 `json:"tokens"`
+	Reqs            int64 `json:"reqs"`
+	Tokens          int64 `json:"tokens"`
+	ReasoningTokens int64 `json:"reasoning_tokens"` // 推理模型的思考用量 已包含在 tokens 中
 }
 
 func Metrics(c *gin.Context) {
@@ -25,21 +25,15 @@ func Metrics(c *gin.Context) {
 
 	now := time.Now()
 	year, month, day := now.Date()
-	chain := gorm.G[models.ChatLog](models.DB).Where("created_at >= ?", time.Date(year, month, day, 0, 0, 0, 0, now.Location()).AddDate(0, 0, -days))
-
-	reqs, err := chain.Count(c.Request.Context(), "id")
+	totals, err := service.QueryStatsTotals(c.Request.Context(), time.Date(year, month, day, 0, 0, 0, 0, now.Location()).AddDate(0, 0, -days))
 	if err != nil {
-		common.InternalServerError(c, "Failed to count requests: "+err.Error())
-		return
-	}
-	var tokens sql.NullInt64
-	if err := chain.Select("sum(total_tokens) as tokens").Scan(c.Request.Context(), &tokens); err != nil {
-		common.InternalServerError(c, "Failed to sum tokens: "+err.Error())
+		common.InternalServerError(c, "Failed to query metrics: "+err.Error())
 		return
 	}
 	common.Success(c, MetricsRes{
-		Reqs:   reqs,
-		Tokens: tokens.Int64,
+		Reqs:            totals.Reqs,
+		Tokens:          totals.Tokens,
+		ReasoningTokens: totals.ReasoningTokens,
 	})
 }
 
@@ -49,10 +43,14 @@ type Count struct {
 }
 
 func Counts(c *gin.Context) {
-	results := make([]Count, 0)
-	if err := models.DB.Raw("SELECT name as model,COUNT(*) as calls FROM `chat_logs` WHERE `chat_logs`.`deleted_at` IS NULL  GROUP BY `name` ORDER BY `calls` DESC").Scan(&results).Error; err != nil {
+	calls, err := service.QueryModelCalls(c.Request.Context())
+	if err != nil {
 		common.InternalServerError(c, err.Error())
+		return
 	}
+	results := lo.Map(calls, func(item service.ModelCalls, _ int) Count {
+		return Count{Model: item.Model, Calls: item.Calls}
+	})
 	const topN = 5
 	if len(results) > topN {
 		var othersCalls int64
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultMaintenanceMessage = "service is under maintenance, please try again later"
+
+// maintenanceBody 按接口风格构造错误响应体，便于客户端 SDK 正常解析
+func maintenanceBody(style, message string) any {
+	if style == consts.StyleAnthropic {
+		return gin.H{
+			"type": "error",
+			"error": gin.H{
+				"type":    "overloaded_error",
+				"message": message,
+			},
+		}
+	}
+	return gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    "service_unavailable",
+			"code":    "maintenance",
+		},
+	}
+}
+
+// checkMaintenance 维护模式下直接返回 503 并终止请求，读取配置失败时放行
+func checkMaintenance(c *gin.Context, style string) bool {
+	config, err := service.LoadConfig[models.Maintenance](c.Request.Context(), models.KeyMaintenance)
+	if err != nil {
+		slog.Error("load maintenance config error", "error", err)
+		return false
+	}
+	if config == nil || !config.Enabled {
+		return false
+	}
+
+	message := config.Message
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+	if config.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(config.RetryAfter))
+	}
+	c.JSON(http.StatusServiceUnavailable, maintenanceBody(style, message))
+	return true
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+// setupTestDB 使用内存数据库并迁移指定的表
+func setupTestDB(t testing.TB, tables ...any) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get sql db: %v", err)
+	}
+	// 内存数据库每个连接独立 异步的日志写入可能打开新连接 限制为单连接
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	models.DB = db
+	t.Cleanup(func() { models.DB = nil })
+	return db
+}
+
+func setupMaintenanceDB(t *testing.T, value string) {
+	db := setupTestDB(t, &models.Config{})
+	if value != "" {
+		if err := db.Create(&models.Config{Key: models.KeyMaintenance, Value: value}).Error; err != nil {
+			t.Fatalf("failed to create config: %v", err)
+		}
+	}
+}
+
+func TestCheckMaintenance(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		config     string
+		style      string
+		blocked    bool
+		errPath    string
+		message    string
+		retryAfter string
+	}{
+		{name: "no config", style: consts.StyleOpenAI},
+		{name: "disabled", config: `{"enabled":false}`, style: consts.StyleOpenAI},
+		{
+			name:       "openai",
+			config:     `{"enabled":true,"message":"upgrading","retry_after":120}`,
+			style:      consts.StyleOpenAI,
+			blocked:    true,
+			errPath:    "error.code",
+			message:    "upgrading",
+			retryAfter: "120",
+		},
+		{
+			name:    "anthropic default message",
+			config:  `{"enabled":true}`,
+			style:   consts.StyleAnthropic,
+			blocked: true,
+			errPath: "error.type",
+			message: defaultMaintenanceMessage,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupMaintenanceDB(t, tt.config)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+
+			if got := checkMaintenance(c, tt.style); got != tt.blocked {
+				t.Fatalf("expected blocked=%v, got %v", tt.blocked, got)
+			}
+			if !tt.blocked {
+				return
+			}
+			if w.Code != http.StatusServiceUnavailable {
+				t.Errorf("expected 503, got %d", w.Code)
+			}
+			if w.Header().Get("Retry-After") != tt.retryAfter {
+				t.Errorf("expected Retry-After %q, got %q", tt.retryAfter, w.Header().Get("Retry-After"))
+			}
+			body := gjson.Parse(w.Body.String())
+			if !body.Get(tt.errPath).Exists() {
+				t.Errorf("expected %s in body: %s", tt.errPath, w.Body.String())
+			}
+			if body.Get("error.message").String() != tt.message {
+				t.Errorf("expected message %q, got %q", tt.message, body.Get("error.message").String())
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"strconv"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+// ProviderModelUsage 指向某个提供商模型的逻辑模型及其关联
+type ProviderModelUsage struct {
+	Model        models.Model          `json:"model"`
+	Associations []ProviderModelTarget `json:"associations"`
+}
+
+// ProviderModelTarget 关联及其提供商名称
+type ProviderModelTarget struct {
+	models.ModelWithProvider
+	ProviderName string `json:"provider_name"`
+}
+
+// GetProviderModelUsages 按提供商模型名称反查使用它的逻辑模型与关联 可按 provider_id 限定提供商
+func GetProviderModelUsages(c *gin.Context) {
+	ctx := c.Request.Context()
+	providerModel := c.Query("provider_model")
+	if providerModel == "" {
+		common.BadRequest(c, "provider_model query parameter is required")
+		return
+	}
+	query := gorm.G[models.ModelWithProvider](models.DB).Where("provider_model = ?", providerModel)
+	if providerID := c.Query("provider_id"); providerID != "" {
+		id, err := strconv.ParseUint(providerID, 10, 64)
+		if err != nil {
+			common.BadRequest(c, "Invalid provider_id format")
+			return
+		}
+		query = query.Where("provider_id = ?", id)
+	}
+	associations, err := query.Order("model_id, id").Find(ctx)
+	if err != nil {
+		common.InternalServerError(c, err.Error())
+		return
+	}
+	usages := make([]ProviderModelUsage, 0)
+	if len(associations) == 0 {
+		common.Success(c, usages)
+		return
+	}
+
+	modelList, err := gorm.G[models.Model](models.DB).
+		Where("id IN ?", lo.Uniq(lo.Map(associations, func(mp models.ModelWithProvider, _ int) uint { return mp.ModelID }))).
+		Order("id").
+		Find(ctx)
+	if err != nil {
+		common.InternalServerError(c, err.Error())
+		return
+	}
+	providerList, err := gorm.G[models.Provider](models.DB).
+		Where("id IN ?", lo.Uniq(lo.Map(associations, func(mp models.ModelWithProvider, _ int) uint { return mp.ProviderID }))).
+		Find(ctx)
+	if err != nil {
+		common.InternalServerError(c, err.Error())
+		return
+	}
+	providerNames := lo.SliceToMap(providerList, func(p models.Provider) (uint, string) { return p.ID, p.Name })
+	byModel := lo.GroupBy(associations, func(mp models.ModelWithProvider) uint { return mp.ModelID })
+
+	// 模型已删除的关联不会被路由 不返回
+	for _, model := range modelList {
+		usage := ProviderModelUsage{Model: model}
+		for _, mp := range byModel[model.ID] {
+			usage.Associations = append(usage.Associations, ProviderModelTarget{
+				ModelWithProvider: mp,
+				ProviderName:      providerNames[mp.ProviderID],
+			})
+		}
+		usages = append(usages, usage)
+	}
+	common.Success(c, usages)
+}
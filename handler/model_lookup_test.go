@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetProviderModelUsages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{})
+	r := gin.New()
+	r.GET("/model-providers/usages", GetProviderModelUsages)
+
+	enabled, disabled := true, false
+	anthropic := models.Provider{Name: "anthropic", Type: "anthropic", Config: "{}"}
+	bedrock := models.Provider{Name: "bedrock", Type: "bedrock", Config: "{}"}
+	for _, p := range []*models.Provider{&anthropic, &bedrock} {
+		if err := db.Create(p).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	opus := models.Model{Name: "opus"}
+	smart := models.Model{Name: "smart"}
+	other := models.Model{Name: "other"}
+	for _, m := range []*models.Model{&opus, &smart, &other} {
+		if err := db.Create(m).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	const target = "claude-3-opus-20240229"
+	for _, mp := range []models.ModelWithProvider{
+		{ModelID: opus.ID, ProviderID: anthropic.ID, ProviderModel: target, Weight: 3, Status: &enabled},
+		{ModelID: opus.ID, ProviderID: bedrock.ID, ProviderModel: target, Weight: 1, Status: &disabled},
+		{ModelID: smart.ID, ProviderID: anthropic.ID, ProviderModel: target, Weight: 2, Status: &enabled},
+		{ModelID: other.ID, ProviderID: anthropic.ID, ProviderModel: "claude-3-haiku", Weight: 1, Status: &enabled},
+	} {
+		if err := db.Create(&mp).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res := doJSON(r, "GET", "/model-providers/usages?provider_model="+target, "")
+	if res.Get("code").Int() != 200 {
+		t.Fatalf("unexpected response: %s", res.Raw)
+	}
+	data := res.Get("data")
+	if got := data.Get("#.model.Name").Raw; got != `["opus","smart"]` {
+		t.Fatalf("models = %s", got)
+	}
+	if got := data.Get("0.associations.#.provider_name").Raw; got != `["anthropic","bedrock"]` {
+		t.Errorf("opus providers = %s", got)
+	}
+	if got := data.Get("0.associations.#.Weight").Raw; got != `[3,1]` {
+		t.Errorf("opus weights = %s", got)
+	}
+	if got := data.Get("0.associations.#.Status").Raw; got != `[true,false]` {
+		t.Errorf("opus statuses = %s", got)
+	}
+	if got := data.Get("1.associations.#.Weight").Raw; got != `[2]` {
+		t.Errorf("smart weights = %s", got)
+	}
+
+	// 限定提供商
+	res = doJSON(r, "GET", "/model-providers/usages?provider_model="+target+"&provider_id=2", "")
+	if got := res.Get("data.#.model.Name").Raw; got != `["opus"]` {
+		t.Errorf("bedrock models = %s", got)
+	}
+
+	tests := []struct {
+		path string
+		code int64
+		want string
+	}{
+		{"/model-providers/usages?provider_model=unknown", 200, `[]`},
+		{"/model-providers/usages", 400, ``},
+		{"/model-providers/usages?provider_model=x&provider_id=abc", 400, ``},
+	}
+	for _, tt := range tests {
+		res := doJSON(r, "GET", tt.path, "")
+		if res.Get("code").Int() != tt.code {
+			t.Errorf("%s: code = %d, want %d", tt.path, res.Get("code").Int(), tt.code)
+		}
+		if tt.want != "" && res.Get("data").Raw != tt.want {
+			t.Errorf("%s: data = %s, want %s", tt.path, res.Get("data").Raw, tt.want)
+		}
+	}
+}
@@ -1,13 +1,73 @@
 package handler
 
 import (
+	"net/http"
+	"strings"
+
 	"github.com/atopos31/llmio/common"
 	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
 	"github.com/atopos31/llmio/providers"
 	"github.com/atopos31/llmio/service"
 	"github.com/gin-gonic/gin"
 )
 
+// openAIModel 在标准字段之外通过 llmio 字段返回模型能力信息 未设置时省略
+type openAIModel struct {
+	providers.Model
+	LLMIO *models.ModelMetadata `json:"llmio,omitempty"`
+}
+
+type anthropicModel struct {
+	providers.AnthropicModel
+	LLMIO *models.ModelMetadata `json:"llmio,omitempty"`
+}
+
+// openAIModelList 与 providers.ModelList 字段一致
+type openAIModelList struct {
+	Object string        `json:"object"`
+	Data   []openAIModel `json:"data"`
+}
+
+// anthropicModelList 与 providers.AnthropicModelsResponse 字段一致
+type anthropicModelList struct {
+	Data    []anthropicModel `json:"data"`
+	FirstID string           `json:"first_id"`
+	HasMore bool             `json:"has_more"`
+	LastID  string           `json:"last_id"`
+}
+
+func newOpenAIModel(model models.Model) openAIModel {
+	return openAIModel{
+		Model: providers.Model{
+			ID:      model.Name,
+			Object:  "model",
+			Created: model.CreatedAt.Unix(),
+			OwnedBy: "llmio",
+		},
+		LLMIO: modelMetadata(model),
+	}
+}
+
+func newAnthropicModel(model models.Model) anthropicModel {
+	return anthropicModel{
+		AnthropicModel: providers.AnthropicModel{
+			ID:          model.Name,
+			CreatedAt:   model.CreatedAt,
+			DisplayName: model.Name,
+			Type:        "model",
+		},
+		LLMIO: modelMetadata(model),
+	}
+}
+
+func modelMetadata(model models.Model) *models.ModelMetadata {
+	if model.Metadata.IsZero() {
+		return nil
+	}
+	return &model.Metadata
+}
+
 func OpenAIModelsHandler(c *gin.Context) {
 	ctx := c.Request.Context()
 	models, err := service.ModelsByTypes(ctx, consts.StyleOpenAI, consts.StyleOpenAIRes)
@@ -15,16 +75,11 @@ func OpenAIModelsHandler(c *gin.Context) {
 		common.InternalServerError(c, err.Error())
 		return
 	}
-	resModels := make([]providers.Model, 0)
+	resModels := make([]openAIModel, 0)
 	for _, model := range models {
-		resModels = append(resModels, providers.Model{
-			ID:      model.Name,
-			Object:  "model",
-			Created: model.CreatedAt.Unix(),
-			OwnedBy: "llmio",
-		})
+		resModels = append(resModels, newOpenAIModel(model))
 	}
-	common.SuccessRaw(c, providers.ModelList{
+	common.SuccessRaw(c, openAIModelList{
 		Object: "list",
 		Data:   resModels,
 	})
@@ -32,22 +87,84 @@ func OpenAIModelsHandler(c *gin.Context) {
 
 func AnthropicModelsHandler(c *gin.Context) {
 	ctx := c.Request.Context()
-	models, err := service.ModelsByTypes(ctx, consts.StyleAnthropic)
+	models, err := service.ModelsByTypes(ctx, service.ProviderTypes(consts.StyleAnthropic)...)
 	if err != nil {
 		common.InternalServerError(c, err.Error())
 		return
 	}
-	resModels := make([]providers.AnthropicModel, 0)
+	resModels := make([]anthropicModel, 0)
 	for _, model := range models {
-		resModels = append(resModels, providers.AnthropicModel{
-			ID:          model.Name,
-			CreatedAt:   model.CreatedAt,
-			DisplayName: model.Name,
-			Type:        "model",
-		})
+		resModels = append(resModels, newAnthropicModel(model))
 	}
-	common.SuccessRaw(c, providers.AnthropicModelsResponse{
+	common.SuccessRaw(c, anthropicModelList{
 		Data:    resModels,
 		HasMore: false,
 	})
 }
+
+// OpenAIModelHandler 获取单个模型 不存在或无权限时返回 404
+func OpenAIModelHandler(c *gin.Context) {
+	model, ok := findModel(c, consts.StyleOpenAI, consts.StyleOpenAI, consts.StyleOpenAIRes)
+	if !ok {
+		return
+	}
+	common.SuccessRaw(c, newOpenAIModel(*model))
+}
+
+// AnthropicModelHandler 获取单个模型 不存在或无权限时返回 404
+func AnthropicModelHandler(c *gin.Context) {
+	model, ok := findModel(c, consts.StyleAnthropic, service.ProviderTypes(consts.StyleAnthropic)...)
+	if !ok {
+		return
+	}
+	common.SuccessRaw(c, newAnthropicModel(*model))
+}
+
+// findModel 按路由中的模型名查找 无权限与不存在同样返回 404 避免泄露模型列表
+func findModel(c *gin.Context, style string, providerTypes ...string) (*models.Model, bool) {
+	ctx := c.Request.Context()
+	// 兼容带斜杠的模型名
+	name := strings.TrimPrefix(c.Param("id"), "/")
+
+	allowed, err := validateAuthKey(ctx, name)
+	if err != nil {
+		common.InternalServerError(c, err.Error())
+		return nil, false
+	}
+	if allowed {
+		llmModels, err := service.ModelsByTypes(ctx, providerTypes...)
+		if err != nil {
+			common.InternalServerError(c, err.Error())
+			return nil, false
+		}
+		for _, model := range llmModels {
+			if model.Name == name {
+				return &model, true
+			}
+		}
+	}
+
+	c.JSON(http.StatusNotFound, modelNotFoundBody(style, name))
+	return nil, false
+}
+
+// modelNotFoundBody 按接口风格构造模型不存在的错误响应体
+func modelNotFoundBody(style, name string) any {
+	if style == consts.StyleAnthropic {
+		return gin.H{
+			"type": "error",
+			"error": gin.H{
+				"type":    "not_found_error",
+				"message": "model: " + name,
+			},
+		}
+	}
+	return gin.H{
+		"error": gin.H{
+			"message": "The model '" + name + "' does not exist or you do not have access to it.",
+			"type":    "invalid_request_error",
+			"param":   nil,
+			"code":    "model_not_found",
+		},
+	}
+}
@@ -0,0 +1,295 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestModelRetrieve(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{})
+
+	openaiProvider := models.Provider{Name: "openai", Type: consts.StyleOpenAI}
+	anthropicProvider := models.Provider{Name: "anthropic", Type: consts.StyleAnthropic}
+	for _, p := range []*models.Provider{&openaiProvider, &anthropicProvider} {
+		if err := db.Create(p).Error; err != nil {
+			t.Fatalf("failed to create provider: %v", err)
+		}
+	}
+	for name, providerID := range map[string]uint{
+		"gpt-4o":          openaiProvider.ID,
+		"meta/llama-3":    openaiProvider.ID,
+		"claude-sonnet-4": anthropicProvider.ID,
+	} {
+		model := models.Model{Name: name}
+		if name == "gpt-4o" {
+			model.Metadata = models.ModelMetadata{ContextLength: 128000, MaxOutput: 16384}
+		}
+		if err := db.Create(&model).Error; err != nil {
+			t.Fatalf("failed to create model: %v", err)
+		}
+		if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: providerID, ProviderModel: name}).Error; err != nil {
+			t.Fatalf("failed to create model provider: %v", err)
+		}
+	}
+
+	// 模拟鉴权中间件写入的模型权限
+	auth := func(allowed []string) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			ctx := context.WithValue(c.Request.Context(), consts.ContextKeyAllowAllModel, allowed == nil)
+			if allowed != nil {
+				ctx = context.WithValue(ctx, consts.ContextKeyAllowModels, allowed)
+			}
+			c.Request = c.Request.WithContext(ctx)
+		}
+	}
+	router := gin.New()
+	router.GET("/openai/v1/models", auth(nil), OpenAIModelsHandler)
+	router.GET("/openai/v1/models/*id", auth(nil), OpenAIModelHandler)
+	router.GET("/anthropic/v1/models/*id", auth(nil), AnthropicModelHandler)
+	router.GET("/restricted/v1/models/*id", auth([]string{"claude-sonnet-4"}), OpenAIModelHandler)
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		field  string // 需要校验的 JSON 字段
+		want   string
+	}{
+		{"openai model", "/openai/v1/models/gpt-4o", http.StatusOK, "object", "model"},
+		{"model name with slash", "/openai/v1/models/meta/llama-3", http.StatusOK, "id", "meta/llama-3"},
+		{"unknown model", "/openai/v1/models/gpt-5", http.StatusNotFound, "error.code", "model_not_found"},
+		{"model of other style", "/openai/v1/models/claude-sonnet-4", http.StatusNotFound, "error.code", "model_not_found"},
+		{"anthropic model", "/anthropic/v1/models/claude-sonnet-4", http.StatusOK, "type", "model"},
+		{"anthropic unknown", "/anthropic/v1/models/gpt-4o", http.StatusNotFound, "error.type", "not_found_error"},
+		{"not allowed", "/restricted/v1/models/gpt-4o", http.StatusNotFound, "error.code", "model_not_found"},
+		{"list still served", "/openai/v1/models", http.StatusOK, "object", "list"},
+		{"metadata", "/openai/v1/models/gpt-4o", http.StatusOK, "llmio.context_length", "128000"},
+		{"metadata in list", "/openai/v1/models", http.StatusOK, `data.#(id=="gpt-4o").llmio.max_output`, "16384"},
+		{"no metadata omitted", "/openai/v1/models/meta/llama-3", http.StatusOK, "llmio", ""},
+		{"anthropic without metadata", "/anthropic/v1/models/claude-sonnet-4", http.StatusOK, "llmio", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.status, w.Body.String())
+			}
+			if got := gjson.Get(w.Body.String(), tt.field).String(); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.field, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModelStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{}, &models.ChatLog{}, &models.Config{})
+
+	provider := models.Provider{Name: "openai", Type: consts.StyleOpenAI, Config: "{}"}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled, disabled := true, false
+	model := models.Model{Name: "gpt-4o", Status: &enabled}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 历史数据未设置状态 视为启用
+	if err := db.Create(&models.Model{Name: "legacy"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range []*bool{&enabled, &disabled} {
+		if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: status}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := gin.New()
+	r.GET("/models", GetModels)
+	r.PATCH("/models/:id/status", UpdateModelStatus)
+	ctx := context.Background()
+	before := service.Before{Model: "gpt-4o"}
+
+	if res := doJSON(r, http.MethodPatch, "/models/999/status", `{"status":false}`); res.Get("code").Int() != 404 {
+		t.Errorf("expected 404 for unknown model, got %s", res.Raw)
+	}
+	if res := doJSON(r, http.MethodPatch, "/models/1/status", `{"status":false}`); res.Get("code").Int() != 200 || res.Get("data.Status").Bool() {
+		t.Fatalf("disable failed: %s", res.Raw)
+	}
+	if _, err := service.ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAI, before); !errors.Is(err, service.ErrModelDisabled) {
+		t.Errorf("expected ErrModelDisabled, got %v", err)
+	}
+	if res := doJSON(r, http.MethodGet, "/models?status=disabled", ""); res.Get("data.#").Int() != 1 || res.Get("data.0.Name").String() != "gpt-4o" {
+		t.Errorf("disabled filter = %s", res.Get("data").Raw)
+	}
+	if res := doJSON(r, http.MethodGet, "/models?status=enabled", ""); res.Get("data.#").Int() != 1 || res.Get("data.0.Name").String() != "legacy" {
+		t.Errorf("enabled filter = %s", res.Get("data").Raw)
+	}
+
+	if res := doJSON(r, http.MethodPatch, "/models/1/status", `{"status":true}`); res.Get("code").Int() != 200 {
+		t.Fatalf("enable failed: %s", res.Raw)
+	}
+	// 恢复后各关联保持原有状态
+	meta, err := service.ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAI, before)
+	if err != nil {
+		t.Fatalf("ProvidersWithMetaBymodelsName() error = %v", err)
+	}
+	if len(meta.ModelWithProviderMap) != 1 {
+		t.Errorf("got %d active associations, want 1", len(meta.ModelWithProviderMap))
+	}
+	var count int64
+	if err := db.Model(&models.ModelWithProvider{}).Where("status = ?", false).Count(&count).Error; err != nil || count != 1 {
+		t.Errorf("disabled associations = %d, want 1 (err %v)", count, err)
+	}
+}
+
+func TestModelProviderDuplicate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t, &models.ModelWithProvider{})
+
+	r := gin.New()
+	r.POST("/model-providers", CreateModelProvider)
+	r.PUT("/model-providers/:id", UpdateModelProvider)
+	r.DELETE("/model-providers/:id", DeleteModelProvider)
+
+	association := `{"model_id":1,"provider_id":1,"provider_name":"%s","weight":1}`
+	if res := doJSON(r, http.MethodPost, "/model-providers", fmt.Sprintf(association, "gpt-4o")); res.Get("code").Int() != 200 {
+		t.Fatalf("create failed: %s", res.Raw)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int64
+	}{
+		{"duplicate create", http.MethodPost, "/model-providers", fmt.Sprintf(association, "gpt-4o"), 400},
+		{"other provider model", http.MethodPost, "/model-providers", fmt.Sprintf(association, "gpt-4o-mini"), 200},
+		{"update onto existing", http.MethodPut, "/model-providers/2", fmt.Sprintf(association, "gpt-4o"), 400},
+		{"update keeps own triple", http.MethodPut, "/model-providers/2", `{"provider_name":"gpt-4o-mini","weight":2}`, 200},
+		{"delete original", http.MethodDelete, "/model-providers/1", "", 200},
+		{"recreate after delete", http.MethodPost, "/model-providers", fmt.Sprintf(association, "gpt-4o"), 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := doJSON(r, tt.method, tt.path, tt.body)
+			if res.Get("code").Int() != tt.code {
+				t.Errorf("code = %d, want %d, body %s", res.Get("code").Int(), tt.code, res.Raw)
+			}
+			if tt.code == 400 && !strings.Contains(res.Get("message").String(), "already exists") {
+				t.Errorf("unclear message: %s", res.Raw)
+			}
+		})
+	}
+}
+
+func TestModelProviderWeight(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.ModelWithProvider{})
+
+	r := gin.New()
+	r.POST("/model-providers", CreateModelProvider)
+	r.PUT("/model-providers/:id", UpdateModelProvider)
+
+	weightOf := func(id int64) int {
+		var mp models.ModelWithProvider
+		if err := db.First(&mp, id).Error; err != nil {
+			t.Fatal(err)
+		}
+		return mp.Weight
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int64
+		id     int64
+		weight int
+	}{
+		{"create default weight", http.MethodPost, "/model-providers", `{"model_id":1,"provider_id":1,"provider_name":"a"}`, 200, 1, 1},
+		{"create standby", http.MethodPost, "/model-providers", `{"model_id":1,"provider_id":1,"provider_name":"b","weight":0}`, 200, 2, 0},
+		{"create negative", http.MethodPost, "/model-providers", `{"model_id":1,"provider_id":1,"provider_name":"c","weight":-1}`, 400, 0, 0},
+		{"update to standby", http.MethodPut, "/model-providers/1", `{"weight":0}`, 200, 1, 0},
+		{"update without weight keeps standby", http.MethodPut, "/model-providers/1", `{"tier":1}`, 200, 1, 0},
+		{"update back to active", http.MethodPut, "/model-providers/1", `{"weight":5}`, 200, 1, 5},
+		{"update negative", http.MethodPut, "/model-providers/1", `{"weight":-2}`, 400, 1, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := doJSON(r, tt.method, tt.path, tt.body)
+			if res.Get("code").Int() != tt.code {
+				t.Fatalf("code = %d, want %d, body %s", res.Get("code").Int(), tt.code, res.Raw)
+			}
+			if tt.id == 0 {
+				return
+			}
+			if tt.code == 200 && res.Get("data.Weight").Int() != int64(tt.weight) {
+				t.Errorf("response weight = %d, want %d", res.Get("data.Weight").Int(), tt.weight)
+			}
+			if got := weightOf(tt.id); got != tt.weight {
+				t.Errorf("stored weight = %d, want %d", got, tt.weight)
+			}
+		})
+	}
+}
+
+func TestModelMetadata(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.Model{})
+
+	r := gin.New()
+	r.POST("/models", CreateModel)
+	r.PUT("/models/:id", UpdateModel)
+
+	metadataOf := func() models.ModelMetadata {
+		var model models.Model
+		if err := db.First(&model, 1).Error; err != nil {
+			t.Fatal(err)
+		}
+		return model.Metadata
+	}
+	yes := true
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int64
+		want   models.ModelMetadata
+	}{
+		{"create", http.MethodPost, "/models", `{"name":"gpt-4o","max_retry":1,"time_out":10,"metadata":{"context_length":128000,"supports_tools":true}}`, 200, models.ModelMetadata{ContextLength: 128000, SupportsTools: &yes}},
+		{"create negative", http.MethodPost, "/models", `{"name":"gpt-4o-mini","max_retry":1,"time_out":10,"metadata":{"max_output":-1}}`, 400, models.ModelMetadata{ContextLength: 128000, SupportsTools: &yes}},
+		{"update replaces", http.MethodPut, "/models/1", `{"metadata":{"max_output":4096}}`, 200, models.ModelMetadata{MaxOutput: 4096}},
+		{"update clears", http.MethodPut, "/models/1", `{}`, 200, models.ModelMetadata{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := doJSON(r, tt.method, tt.path, tt.body)
+			if res.Get("code").Int() != tt.code {
+				t.Fatalf("code = %d, want %d, body %s", res.Get("code").Int(), tt.code, res.Raw)
+			}
+			got := metadataOf()
+			if got.ContextLength != tt.want.ContextLength || got.MaxOutput != tt.want.MaxOutput ||
+				(got.SupportsTools == nil) != (tt.want.SupportsTools == nil) || got.SupportsVision != nil {
+				t.Errorf("metadata = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
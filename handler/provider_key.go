@@ -1,7 +1,10 @@
 package handler
 
 import (
-	"fmt"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/atopos31/llmio/common"
 	"github.com/atopos31/llmio/models"
@@ -9,6 +12,87 @@ import (
 	"gorm.io/gorm"
 )
 
+// recentErrorWindow 列表中统计近期错误次数的时间窗口
+const recentErrorWindow = 24 * time.Hour
+
+type ProviderKeyRequest struct {
+	Key          string  `json:"key"`
+	Remark       *string `json:"remark"`
+	Status       *bool   `json:"status"`
+	Weight       *int    `json:"weight"`
+	Budget       *int    `json:"budget"`
+	BudgetRefill *int    `json:"budget_refill"`
+	// 有效期 RFC3339 时间 空字符串清除
+	ValidFrom  *string `json:"valid_from"`
+	ValidUntil *string `json:"valid_until"`
+}
+
+// parseKeyWindow 解析请求中的有效期 未传入时沿用 current
+func parseKeyWindow(value *string, current *time.Time) (*time.Time, error) {
+	if value == nil {
+		return current, nil
+	}
+	if *value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, *value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// validKeyWindow 有效期的结束时间必须晚于开始时间
+func validKeyWindow(from, until *time.Time) bool {
+	return from == nil || until == nil || until.After(*from)
+}
+
+// ProviderKeyRes Key 列表响应 Key 内容脱敏
+type ProviderKeyRes struct {
+	ID            uint       `json:"id"`
+	ProviderID    uint       `json:"provider_id"`
+	Key           string     `json:"key"`
+	Remark        string     `json:"remark"`
+	Status        bool       `json:"status"`
+	CooldownUntil *time.Time `json:"cooldown_until"`
+	CooldownStep  int        `json:"cooldown_step"`
+	SuccessCount  int64      `json:"success_count"`
+	FailCount     int64      `json:"fail_count"`
+	RecentErrors  int64      `json:"recent_errors"` // 近 24 小时请求错误次数
+	Weight        int        `json:"weight"`
+	Budget        int        `json:"budget"`
+	BudgetRefill  int        `json:"budget_refill"`
+	Remaining     float64    `json:"remaining"`
+	ValidFrom     *time.Time `json:"valid_from"`
+	ValidUntil    *time.Time `json:"valid_until"`
+	LastUsedAt    *time.Time `json:"last_used_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// maskKey 仅保留首尾各 4 位
+func maskKey(key string) string {
+	if len(key) < 8 {
+		return "****"
+	}
+	return key[:4] + "..." + key[len(key)-4:]
+}
+
+// parseProviderKeyID 解析路由中的 provider id 与 key id
+func parseProviderKeyID(c *gin.Context) (uint, uint, bool) {
+	pid, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "invalid provider id")
+		return 0, 0, false
+	}
+	kid, err := strconv.ParseUint(c.Param("keyId"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "invalid key id")
+		return 0, 0, false
+	}
+	return uint(pid), uint(kid), true
+}
+
 // ListProviderKeys 获取 Provider 的 Key 列表
 func ListProviderKeys(c *gin.Context) {
 	ctx := c.Request.Context()
@@ -23,30 +107,131 @@ func ListProviderKeys(c *gin.Context) {
 		return
 	}
 
-	common.Success(c, keys)
+	// 统计近期错误次数
+	var errorCounts []struct {
+		ProviderKeyID uint
+		Count         int64
+	}
+	if len(keys) > 0 {
+		ids := make([]uint, 0, len(keys))
+		for _, key := range keys {
+			ids = append(ids, key.ID)
+		}
+		if err := models.DB.WithContext(ctx).Model(&models.ChatLog{}).
+			Select("provider_key_id, COUNT(*) AS count").
+			Where("provider_key_id IN ? AND status = ? AND created_at > ?", ids, "error", time.Now().Add(-recentErrorWindow)).
+			Group("provider_key_id").
+			Scan(&errorCounts).Error; err != nil {
+			common.InternalServerError(c, err.Error())
+			return
+		}
+	}
+	errorCountMap := make(map[uint]int64, len(errorCounts))
+	for _, count := range errorCounts {
+		errorCountMap[count.ProviderKeyID] = count.Count
+	}
+
+	res := make([]ProviderKeyRes, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, ProviderKeyRes{
+			ID:            key.ID,
+			ProviderID:    key.ProviderID,
+			Key:           maskKey(key.Key),
+			Remark:        key.Remark,
+			Status:        key.Status,
+			CooldownUntil: key.CooldownUntil,
+			CooldownStep:  key.CooldownStep,
+			SuccessCount:  key.SuccessCount,
+			FailCount:     key.FailCount,
+			RecentErrors:  errorCountMap[key.ID],
+			Weight:        key.Weight,
+			Budget:        key.Budget,
+			BudgetRefill:  key.BudgetRefill,
+			Remaining:     key.Remaining,
+			ValidFrom:     key.ValidFrom,
+			ValidUntil:    key.ValidUntil,
+			LastUsedAt:    key.LastUsedAt,
+			CreatedAt:     key.CreatedAt,
+			UpdatedAt:     key.UpdatedAt,
+		})
+	}
+
+	common.Success(c, res)
 }
 
 // CreateProviderKey 创建 Provider Key
 func CreateProviderKey(c *gin.Context) {
 	ctx := c.Request.Context()
-	providerID := c.Param("id")
+	pid, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "invalid provider id")
+		return
+	}
 
-	var key models.ProviderKey
-	if err := c.ShouldBindJSON(&key); err != nil {
+	var req ProviderKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		common.BadRequest(c, err.Error())
 		return
 	}
+	req.Key = strings.TrimSpace(req.Key)
+	if req.Key == "" {
+		common.BadRequest(c, "key is required")
+		return
+	}
 
-	// 强制使用 URL 中的 provider_id
-	var pid uint
-	if _, err := fmt.Sscanf(providerID, "%d", &pid); err != nil {
-		common.BadRequest(c, "invalid provider id")
+	if _, err := gorm.G[models.Provider](models.DB).Where("id = ?", pid).First(ctx); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.NotFound(c, "Provider not found")
+			return
+		}
+		common.InternalServerError(c, err.Error())
 		return
 	}
-	key.ProviderID = pid
+
+	count, err := gorm.G[models.ProviderKey](models.DB).Where("provider_id = ? AND key = ?", pid, req.Key).Count(ctx, "id")
+	if err != nil {
+		common.InternalServerError(c, err.Error())
+		return
+	}
+	if count > 0 {
+		common.BadRequest(c, "Key already exists")
+		return
+	}
+
 	// 默认启用新创建的 Key
-	if !key.Status {
-		key.Status = true
+	key := models.ProviderKey{
+		ProviderID: uint(pid),
+		Key:        req.Key,
+		Status:     req.Status == nil || *req.Status,
+		Weight:     1,
+	}
+	if req.Remark != nil {
+		key.Remark = *req.Remark
+	}
+	if req.Weight != nil {
+		key.Weight = *req.Weight
+	}
+	if req.Budget != nil {
+		key.Budget = *req.Budget
+	}
+	if req.BudgetRefill != nil {
+		key.BudgetRefill = *req.BudgetRefill
+	}
+	if key.Weight < 1 || key.Budget < 0 || key.BudgetRefill < 0 {
+		common.BadRequest(c, "weight must be positive and budget must not be negative")
+		return
+	}
+	if key.ValidFrom, err = parseKeyWindow(req.ValidFrom, nil); err != nil {
+		common.BadRequest(c, "Invalid valid_from, must be RFC3339")
+		return
+	}
+	if key.ValidUntil, err = parseKeyWindow(req.ValidUntil, nil); err != nil {
+		common.BadRequest(c, "Invalid valid_until, must be RFC3339")
+		return
+	}
+	if !validKeyWindow(key.ValidFrom, key.ValidUntil) {
+		common.BadRequest(c, "valid_until must be after valid_from")
+		return
 	}
 
 	if err := gorm.G[models.ProviderKey](models.DB).Create(ctx, &key); err != nil {
@@ -54,67 +239,173 @@ func CreateProviderKey(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, models.AuditActionCreate, models.AuditTargetProviderKey, key.ID, nil, key)
+	key.Key = maskKey(key.Key)
 	common.Success(c, key)
 }
 
-// UpdateProviderKey 更新 Provider Key
+// UpdateProviderKey 更新 Provider Key 的备注与状态
 func UpdateProviderKey(c *gin.Context) {
 	ctx := c.Request.Context()
-	var pid, kid uint
-	if _, err := fmt.Sscanf(c.Param("id"), "%d", &pid); err != nil {
-		common.BadRequest(c, "invalid provider id")
-		return
-	}
-	if _, err := fmt.Sscanf(c.Param("keyId"), "%d", &kid); err != nil {
-		common.BadRequest(c, "invalid key id")
+	pid, kid, ok := parseProviderKeyID(c)
+	if !ok {
 		return
 	}
 
-	var req struct {
-		Remark string `json:"remark"`
-		Status *bool  `json:"status"`
-	}
+	var req ProviderKeyRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		common.BadRequest(c, err.Error())
 		return
 	}
 
-	update := models.ProviderKey{
-		Remark: req.Remark,
+	// 使用 map 更新以支持清空备注与禁用
+	updates := map[string]any{}
+	if req.Remark != nil {
+		updates["remark"] = *req.Remark
 	}
 	if req.Status != nil {
-		update.Status = *req.Status
+		updates["status"] = *req.Status
+	}
+	if req.Weight != nil {
+		if *req.Weight < 1 {
+			common.BadRequest(c, "weight must be positive")
+			return
+		}
+		updates["weight"] = *req.Weight
+	}
+	if req.Budget != nil || req.BudgetRefill != nil {
+		if (req.Budget != nil && *req.Budget < 0) || (req.BudgetRefill != nil && *req.BudgetRefill < 0) {
+			common.BadRequest(c, "budget must not be negative")
+			return
+		}
+		if req.Budget != nil {
+			updates["budget"] = *req.Budget
+		}
+		if req.BudgetRefill != nil {
+			updates["budget_refill"] = *req.BudgetRefill
+		}
+		// 预算变更后重新从满额开始估算
+		updates["remaining"] = 0
+		updates["remaining_at"] = nil
+	}
+	if len(updates) == 0 && req.ValidFrom == nil && req.ValidUntil == nil {
+		common.BadRequest(c, "nothing to update")
+		return
 	}
 
-	if _, err := gorm.G[models.ProviderKey](models.DB).
-		Where("id = ? AND provider_id = ?", kid, pid).
-		Updates(ctx, update); err != nil {
+	existing, err := gorm.G[models.ProviderKey](models.DB).Where("id = ? AND provider_id = ?", kid, pid).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.NotFound(c, "Provider key not found")
+			return
+		}
 		common.InternalServerError(c, err.Error())
 		return
 	}
+	// 有效期与未修改的一端一起校验
+	if req.ValidFrom != nil || req.ValidUntil != nil {
+		validFrom, err := parseKeyWindow(req.ValidFrom, existing.ValidFrom)
+		if err != nil {
+			common.BadRequest(c, "Invalid valid_from, must be RFC3339")
+			return
+		}
+		validUntil, err := parseKeyWindow(req.ValidUntil, existing.ValidUntil)
+		if err != nil {
+			common.BadRequest(c, "Invalid valid_until, must be RFC3339")
+			return
+		}
+		if !validKeyWindow(validFrom, validUntil) {
+			common.BadRequest(c, "valid_until must be after valid_from")
+			return
+		}
+		updates["valid_from"] = validFrom
+		updates["valid_until"] = validUntil
+	}
+
+	result := models.DB.WithContext(ctx).Model(&models.ProviderKey{}).
+		Where("id = ? AND provider_id = ?", kid, pid).
+		Updates(updates)
+	if result.Error != nil {
+		common.InternalServerError(c, result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 {
+		common.NotFound(c, "Provider key not found")
+		return
+	}
 
+	if updated, err := gorm.G[models.ProviderKey](models.DB).Where("id = ?", kid).First(ctx); err == nil {
+		recordAudit(c, models.AuditActionUpdate, models.AuditTargetProviderKey, kid, existing, updated)
+	}
 	common.Success(c, nil)
 }
 
+// ToggleProviderKeyStatus 切换 Provider Key 启用状态 重新启用时清除冷却
+func ToggleProviderKeyStatus(c *gin.Context) {
+	ctx := c.Request.Context()
+	pid, kid, ok := parseProviderKeyID(c)
+	if !ok {
+		return
+	}
+
+	key, err := gorm.G[models.ProviderKey](models.DB).Where("id = ? AND provider_id = ?", kid, pid).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.NotFound(c, "Provider key not found")
+			return
+		}
+		common.InternalServerError(c, err.Error())
+		return
+	}
+
+	updates := map[string]any{"status": !key.Status}
+	if !key.Status {
+		updates["cooldown_until"] = nil
+		updates["cooldown_step"] = 0
+		updates["fail_count"] = 0
+	}
+	if err := models.DB.WithContext(ctx).Model(&models.ProviderKey{}).Where("id = ?", key.ID).Updates(updates).Error; err != nil {
+		common.InternalServerError(c, "Failed to update status: "+err.Error())
+		return
+	}
+
+	if updated, err := gorm.G[models.ProviderKey](models.DB).Where("id = ?", key.ID).First(ctx); err == nil {
+		recordAudit(c, models.AuditActionUpdate, models.AuditTargetProviderKey, key.ID, key, updated)
+	}
+	common.Success(c, gin.H{"id": key.ID, "status": !key.Status})
+}
+
 // DeleteProviderKey 删除 Provider Key
+// Key 池状态全部保存在数据库中 删除后不会再被选中
 func DeleteProviderKey(c *gin.Context) {
 	ctx := c.Request.Context()
-	var pid, kid uint
-	if _, err := fmt.Sscanf(c.Param("id"), "%d", &pid); err != nil {
-		common.BadRequest(c, "invalid provider id")
+	pid, kid, ok := parseProviderKeyID(c)
+	if !ok {
 		return
 	}
-	if _, err := fmt.Sscanf(c.Param("keyId"), "%d", &kid); err != nil {
-		common.BadRequest(c, "invalid key id")
+
+	existing, err := gorm.G[models.ProviderKey](models.DB).Where("id = ? AND provider_id = ?", kid, pid).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.NotFound(c, "Provider key not found")
+			return
+		}
+		common.InternalServerError(c, err.Error())
 		return
 	}
 
-	if _, err := gorm.G[models.ProviderKey](models.DB).
+	rows, err := gorm.G[models.ProviderKey](models.DB).
 		Where("id = ? AND provider_id = ?", kid, pid).
-		Delete(ctx); err != nil {
+		Delete(ctx)
+	if err != nil {
 		common.InternalServerError(c, err.Error())
 		return
 	}
+	if rows == 0 {
+		common.NotFound(c, "Provider key not found")
+		return
+	}
 
+	recordAudit(c, models.AuditActionDelete, models.AuditTargetProviderKey, kid, existing, nil)
 	common.Success(c, nil)
 }
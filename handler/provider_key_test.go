@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func providerKeyRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/providers/:id/keys", ListProviderKeys)
+	r.POST("/providers/:id/keys", CreateProviderKey)
+	r.PUT("/providers/:id/keys/:keyId", UpdateProviderKey)
+	r.PATCH("/providers/:id/keys/:keyId/status", ToggleProviderKeyStatus)
+	r.DELETE("/providers/:id/keys/:keyId", DeleteProviderKey)
+	return r
+}
+
+func doJSON(r http.Handler, method, path, body string) gjson.Result {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return gjson.Parse(w.Body.String())
+}
+
+func TestProviderKeyCRUD(t *testing.T) {
+	db := setupTestDB(t, &models.Provider{}, &models.ProviderKey{}, &models.ChatLog{})
+	provider := models.Provider{Name: "p", Type: "openai", Config: "{}"}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	r := providerKeyRouter()
+
+	if res := doJSON(r, http.MethodPost, "/providers/999/keys", `{"key":"sk-abcdefgh1234"}`); res.Get("code").Int() != 404 {
+		t.Errorf("expected 404 for unknown provider, got %s", res.Raw)
+	}
+	if res := doJSON(r, http.MethodPost, "/providers/1/keys", `{"key":"  "}`); res.Get("code").Int() != 400 {
+		t.Errorf("expected 400 for empty key, got %s", res.Raw)
+	}
+
+	res := doJSON(r, http.MethodPost, "/providers/1/keys", `{"key":"sk-abcdefgh1234","remark":"primary"}`)
+	if res.Get("code").Int() != 200 {
+		t.Fatalf("create failed: %s", res.Raw)
+	}
+	if strings.Contains(res.Raw, "abcdefgh") {
+		t.Errorf("expected created key to be masked: %s", res.Raw)
+	}
+	if res := doJSON(r, http.MethodPost, "/providers/1/keys", `{"key":"sk-abcdefgh1234"}`); res.Get("code").Int() != 400 {
+		t.Errorf("expected duplicate key to be rejected, got %s", res.Raw)
+	}
+
+	var key models.ProviderKey
+	if err := db.First(&key).Error; err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&models.ChatLog{Status: "error", ProviderKeyID: key.ID})
+	db.Create(&models.ChatLog{Status: "error", ProviderKeyID: key.ID})
+	db.Create(&models.ChatLog{Status: "success", ProviderKeyID: key.ID})
+
+	list := doJSON(r, http.MethodGet, "/providers/1/keys", "").Get("data.0")
+	if list.Get("key").String() != "sk-a...1234" {
+		t.Errorf("expected masked key, got %s", list.Get("key").String())
+	}
+	if list.Get("recent_errors").Int() != 2 {
+		t.Errorf("expected 2 recent errors, got %d", list.Get("recent_errors").Int())
+	}
+
+	// 禁用与清空备注需要零值也能写入
+	if res := doJSON(r, http.MethodPut, "/providers/1/keys/1", `{"status":false,"remark":""}`); res.Get("code").Int() != 200 {
+		t.Fatalf("update failed: %s", res.Raw)
+	}
+	db.First(&key, key.ID)
+	if key.Status || key.Remark != "" {
+		t.Errorf("expected key disabled with empty remark, got status=%v remark=%q", key.Status, key.Remark)
+	}
+
+	if res := doJSON(r, http.MethodPatch, "/providers/1/keys/1/status", ""); !res.Get("data.status").Bool() {
+		t.Errorf("expected toggle to enable key, got %s", res.Raw)
+	}
+
+	if res := doJSON(r, http.MethodDelete, "/providers/1/keys/1", ""); res.Get("code").Int() != 200 {
+		t.Fatalf("delete failed: %s", res.Raw)
+	}
+	if res := doJSON(r, http.MethodDelete, "/providers/1/keys/1", ""); res.Get("code").Int() != 404 {
+		t.Errorf("expected 404 after delete, got %s", res.Raw)
+	}
+	if res := doJSON(r, http.MethodPut, "/providers/1/keys/1", `{"status":true}`); res.Get("code").Int() != 404 {
+		t.Errorf("expected 404 updating deleted key, got %s", res.Raw)
+	}
+}
+
+func TestProviderKeyValidityWindow(t *testing.T) {
+	db := setupTestDB(t, &models.Provider{}, &models.ProviderKey{}, &models.ChatLog{})
+	if err := db.Create(&models.Provider{Name: "p", Type: "openai", Config: "{}"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	r := providerKeyRouter()
+
+	tests := []struct {
+		method, path, body string
+		wantCode           int64
+		wantFrom           string // 请求后 Key 的有效期 为空表示未设置
+		wantUntil          string
+	}{
+		{http.MethodPost, "/providers/1/keys", `{"key":"sk-window-1234","valid_until":"2026-01-01T00:00:00Z","valid_from":"2026-02-01T00:00:00Z"}`, 400, "", ""},
+		{http.MethodPost, "/providers/1/keys", `{"key":"sk-window-1234","valid_until":"tomorrow"}`, 400, "", ""},
+		{http.MethodPost, "/providers/1/keys", `{"key":"sk-window-1234","valid_from":"2026-01-01T00:00:00Z","valid_until":"2026-02-01T00:00:00Z"}`, 200, "2026-01-01T00:00:00Z", "2026-02-01T00:00:00Z"},
+		// 只修改一端时与另一端一起校验
+		{http.MethodPut, "/providers/1/keys/1", `{"valid_until":"2025-12-01T00:00:00Z"}`, 400, "2026-01-01T00:00:00Z", "2026-02-01T00:00:00Z"},
+		{http.MethodPut, "/providers/1/keys/1", `{"valid_until":"2026-03-01T00:00:00Z"}`, 200, "2026-01-01T00:00:00Z", "2026-03-01T00:00:00Z"},
+		{http.MethodPut, "/providers/1/keys/1", `{"valid_from":""}`, 200, "", "2026-03-01T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		res := doJSON(r, tt.method, tt.path, tt.body)
+		if code := res.Get("code").Int(); code != tt.wantCode {
+			t.Fatalf("%s %s %s: code %d, want %d: %s", tt.method, tt.path, tt.body, code, tt.wantCode, res.Raw)
+		}
+		list := doJSON(r, http.MethodGet, "/providers/1/keys", "").Get("data.0")
+		from, until := list.Get("valid_from"), list.Get("valid_until")
+		if tt.wantFrom == "" && tt.wantUntil == "" && !list.Exists() {
+			continue
+		}
+		if (tt.wantFrom == "" && from.Type != gjson.Null) || (tt.wantFrom != "" && !timeEqual(from.String(), tt.wantFrom)) ||
+			!timeEqual(until.String(), tt.wantUntil) {
+			t.Errorf("%s %s: window = %s ~ %s, want %s ~ %s", tt.method, tt.body, from.Raw, until.Raw, tt.wantFrom, tt.wantUntil)
+		}
+	}
+}
+
+// timeEqual 比较 RFC3339 时间 忽略时区表示的差异
+func timeEqual(a, b string) bool {
+	ta, errA := time.Parse(time.RFC3339, a)
+	tb, errB := time.Parse(time.RFC3339, b)
+	return errA == nil && errB == nil && ta.Equal(tb)
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// 配置字段的 JSON 类型
+const (
+	ConfigFieldString  = "string"
+	ConfigFieldArray   = "array"
+	ConfigFieldObject  = "object"
+	ConfigFieldBoolean = "boolean"
+	ConfigFieldNumber  = "number"
+)
+
+// ConfigFormatURL 字段须为 http(s) 地址
+const ConfigFormatURL = "url"
+
+// ConfigField 提供商配置中单个字段的约束 未列出的字段不做校验
+type ConfigField struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Required bool   `json:"required"`
+	Format   string `json:"format,omitempty"`
+	// 同组字段至少填写一个 如 api_key 与 keys
+	Group string `json:"group,omitempty"`
+}
+
+// validateProviderConfig 按提供商类型的 schema 校验配置 一次列出所有缺失或无效的字段
+func validateProviderConfig(providerType, config string) error {
+	idx := slices.IndexFunc(template, func(t ProviderTemplate) bool { return t.Type == providerType })
+	if idx < 0 {
+		return fmt.Errorf("unknown provider type: %s", providerType)
+	}
+	if !gjson.Valid(config) || !gjson.Parse(config).IsObject() {
+		return fmt.Errorf("invalid %s config: must be a JSON object", providerType)
+	}
+
+	var problems []string
+	var groups []string
+	filled := make(map[string]bool)
+	for _, field := range template[idx].Schema {
+		value := gjson.Get(config, field.Name)
+		if field.Group != "" {
+			if !slices.Contains(groups, field.Group) {
+				groups = append(groups, field.Group)
+			}
+			if configValueSet(value) {
+				filled[field.Group] = true
+			}
+		}
+		if !value.Exists() || value.Type == gjson.Null {
+			if field.Required {
+				problems = append(problems, fmt.Sprintf("%s is required", field.Name))
+			}
+			continue
+		}
+		if !configTypeMatches(value, field.Type) {
+			problems = append(problems, fmt.Sprintf("%s must be of type %s", field.Name, field.Type))
+			continue
+		}
+		if field.Required && !configValueSet(value) {
+			problems = append(problems, fmt.Sprintf("%s must not be empty", field.Name))
+			continue
+		}
+		if field.Format == ConfigFormatURL && value.String() != "" && !validHTTPURL(value.String()) {
+			problems = append(problems, fmt.Sprintf("%s must be an http(s) URL", field.Name))
+		}
+	}
+	for _, group := range groups {
+		if filled[group] {
+			continue
+		}
+		var names []string
+		for _, field := range template[idx].Schema {
+			if field.Group == group {
+				names = append(names, field.Name)
+			}
+		}
+		problems = append(problems, fmt.Sprintf("one of %s is required", strings.Join(names, ", ")))
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid " + providerType + " config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func configTypeMatches(value gjson.Result, fieldType string) bool {
+	switch fieldType {
+	case ConfigFieldString:
+		return value.Type == gjson.String
+	case ConfigFieldArray:
+		return value.IsArray()
+	case ConfigFieldObject:
+		return value.IsObject()
+	case ConfigFieldBoolean:
+		return value.IsBool()
+	case ConfigFieldNumber:
+		return value.Type == gjson.Number
+	}
+	return true
+}
+
+// configValueSet 字符串非空白 数组与对象非空
+func configValueSet(value gjson.Result) bool {
+	switch {
+	case value.Type == gjson.String:
+		return strings.TrimSpace(value.String()) != ""
+	case value.IsArray():
+		return len(value.Array()) > 0
+	case value.IsObject():
+		return len(value.Map()) > 0
+	}
+	return value.Exists() && value.Type != gjson.Null
+}
+
+func validHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateProviderConfig(t *testing.T) {
+	tests := []struct {
+		name         string
+		providerType string
+		config       string
+		wantErr      []string
+	}{
+		{name: "openai valid", providerType: "openai", config: `{"base_url":"https://api.openai.com/v1","api_key":"sk-x"}`},
+		{name: "openai keys only", providerType: "openai", config: `{"base_url":"https://api.openai.com/v1","keys":[{"term":"sk-x","status":true}]}`},
+		{name: "openai missing fields", providerType: "openai", config: `{}`, wantErr: []string{"base_url is required", "one of api_key, keys is required"}},
+		{name: "openai bad url", providerType: "openai", config: `{"base_url":"api.openai.com","api_key":"sk-x"}`, wantErr: []string{"base_url must be an http(s) URL"}},
+		{name: "openai wrong type", providerType: "openai", config: `{"base_url":"https://a","api_key":"sk-x","strip_params":"user"}`, wantErr: []string{"strip_params must be of type array"}},
+		{name: "openai-res valid", providerType: "openai-res", config: `{"base_url":"http://localhost:8080/v1","api_key":"sk-x","strip_params":[]}`},
+		{name: "openai-res blank key", providerType: "openai-res", config: `{"base_url":"https://a","api_key":"  "}`, wantErr: []string{"one of api_key, keys is required"}},
+		{name: "anthropic valid", providerType: "anthropic", config: `{"base_url":"https://api.anthropic.com/v1","api_key":"k","version":"2023-06-01"}`},
+		{name: "anthropic missing version", providerType: "anthropic", config: `{"base_url":"https://api.anthropic.com/v1","api_key":"k"}`, wantErr: []string{"version is required"}},
+		{name: "anthropic empty version", providerType: "anthropic", config: `{"base_url":"https://api.anthropic.com/v1","api_key":"k","version":""}`, wantErr: []string{"version must not be empty"}},
+		{name: "bedrock valid", providerType: "bedrock", config: `{"region":"us-east-1","access_key_id":"AK","secret_access_key":"SK"}`},
+		{name: "bedrock missing credentials", providerType: "bedrock", config: `{"region":"us-east-1"}`, wantErr: []string{"access_key_id is required", "secret_access_key is required"}},
+		{name: "bedrock bad base url", providerType: "bedrock", config: `{"region":"us-east-1","access_key_id":"AK","secret_access_key":"SK","base_url":"ftp://x"}`, wantErr: []string{"base_url must be an http(s) URL"}},
+		{name: "not an object", providerType: "openai", config: `[]`, wantErr: []string{"must be a JSON object"}},
+		{name: "unknown type", providerType: "gemini", config: `{}`, wantErr: []string{"unknown provider type"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateProviderConfig(tt.providerType, tt.config)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error containing %q", tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestProviderTemplatesMatchSchema(t *testing.T) {
+	for _, tpl := range template {
+		if err := validateProviderConfig(tpl.Type, tpl.Template); err != nil {
+			t.Errorf("template %s does not satisfy its schema: %v", tpl.Type, err)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// headerRequestID 客户端提供的请求 id 响应中总是返回 未提供时由网关生成
+	headerRequestID = "X-Request-ID"
+	// headerDeduplicated 响应复用了同一请求 id 的原请求结果
+	headerDeduplicated = "X-LLMIO-Deduplicated"
+	// defaultRequestDedupWindow 未配置时同一请求 id 视为重复的时长
+	defaultRequestDedupWindow = time.Minute
+	// requestDedupMaxEntries 同时保留的请求 id 数量 超出后新请求不参与去重
+	requestDedupMaxEntries = 4096
+	// requestDedupMaxBody 可复用的响应体上限 超出后重复请求重新转发
+	requestDedupMaxBody = 4 << 20
+)
+
+// requestDedups 全局的请求 id 去重表 按 auth key 与请求 id 查找
+var requestDedups = newDedupRegistry(requestDedupMaxEntries)
+
+// dedupResult 原请求的完整响应
+type dedupResult struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// dedupEntry 同一请求 id 的原请求 done 关闭后 result 可读
+type dedupEntry struct {
+	bodyHash  string
+	startedAt time.Time
+	done      chan struct{}
+	result    *dedupResult // 原请求失败或响应过大时为空 重复请求需重新转发
+}
+
+// dedupRegistry 有界的请求 id 表 完成且超过窗口的条目在登记时清理
+type dedupRegistry struct {
+	mu      sync.Mutex
+	entries map[string]*dedupEntry
+	max     int
+	now     func() time.Time
+}
+
+func newDedupRegistry(max int) *dedupRegistry {
+	return &dedupRegistry{entries: make(map[string]*dedupEntry), max: max, now: time.Now}
+}
+
+// begin 返回窗口内同一请求 id 的条目 不存在时登记新条目 owner 为 true 表示由调用方转发
+// 表已满时返回空条目 调用方不参与去重
+func (r *dedupRegistry) begin(key, bodyHash string, window time.Duration) (entry *dedupEntry, owner bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if entry, ok := r.entries[key]; ok {
+		if !entry.finished() || now.Sub(entry.startedAt) < window {
+			return entry, false
+		}
+		delete(r.entries, key)
+	}
+	if len(r.entries) >= r.max {
+		for k, entry := range r.entries {
+			if entry.finished() && now.Sub(entry.startedAt) >= window {
+				delete(r.entries, k)
+			}
+		}
+		if len(r.entries) >= r.max {
+			return nil, true
+		}
+	}
+	entry = &dedupEntry{bodyHash: bodyHash, startedAt: now, done: make(chan struct{})}
+	r.entries[key] = entry
+	return entry, true
+}
+
+// finish 记录原请求的结果并唤醒等待的重复请求 无法复用的结果不保留
+func (r *dedupRegistry) finish(key string, entry *dedupEntry, result *dedupResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry.result = result
+	if result == nil && r.entries[key] == entry {
+		delete(r.entries, key)
+	}
+	close(entry.done)
+}
+
+func (e *dedupEntry) finished() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// dedupWriter 在写出响应的同时保留响应体 供重复请求复用
+type dedupWriter struct {
+	gin.ResponseWriter
+	body     []byte
+	overflow bool
+	failed   bool // 响应头发送后转发中途出错 已写出的内容不完整
+}
+
+func (w *dedupWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *dedupWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *dedupWriter) capture(data []byte) {
+	if w.overflow {
+		return
+	}
+	if len(w.body)+len(data) > requestDedupMaxBody {
+		w.overflow = true
+		w.body = nil
+		return
+	}
+	w.body = append(w.body, data...)
+}
+
+// result 仅成功的完整响应可复用 状态码为 2xx 但中途出错的响应不复用
+func (w *dedupWriter) result() *dedupResult {
+	status := w.Status()
+	if w.overflow || w.failed || status < http.StatusOK || status >= http.StatusMultipleChoices {
+		return nil
+	}
+	header := w.Header().Clone()
+	// trailer 不随复用的响应返回
+	header.Del("Trailer")
+	return &dedupResult{status: status, header: header, body: w.body}
+}
+
+// markDedupFailed 转发中途出错时标记响应不可复用 重复请求将重新转发
+func markDedupFailed(c *gin.Context) {
+	if w, ok := c.Writer.(*dedupWriter); ok {
+		w.failed = true
+	}
+}
+
+// echoRequestID 在响应中返回请求 id 客户端未提供时生成 返回客户端提供的 id
+func echoRequestID(c *gin.Context) string {
+	requestID := c.GetHeader(headerRequestID)
+	if requestID == "" {
+		c.Header(headerRequestID, rand.Text())
+		return ""
+	}
+	c.Header(headerRequestID, requestID)
+	return requestID
+}
+
+// dedupRequest 窗口内同一 auth key 重复的请求 id 等待原请求完成并复用其成功结果 避免重复转发
+// 返回 true 表示已响应 否则调用方需在请求结束后调用 finish
+func dedupRequest(c *gin.Context, requestID string, body []byte) (handled bool, finish func()) {
+	finish = func() {}
+	if requestID == "" {
+		return false, finish
+	}
+	ctx := c.Request.Context()
+	config, err := service.LoadConfig[models.RequestDedup](ctx, models.KeyRequestDedup)
+	if err != nil {
+		slog.Error("load request dedup config error", "error", err)
+		return false, finish
+	}
+	if config == nil || !config.Enabled {
+		return false, finish
+	}
+	window := defaultRequestDedupWindow
+	if config.Window > 0 {
+		window = time.Duration(config.Window) * time.Second
+	}
+
+	authKeyID, _ := ctx.Value(consts.ContextKeyAuthKeyID).(uint)
+	key := fmt.Sprintf("%d|%s", authKeyID, requestID)
+	sum := sha256.Sum256(body)
+	bodyHash := hex.EncodeToString(sum[:])
+	for {
+		entry, owner := requestDedups.begin(key, bodyHash, window)
+		if owner {
+			if entry == nil {
+				return false, finish
+			}
+			writer := &dedupWriter{ResponseWriter: c.Writer}
+			c.Writer = writer
+			return false, func() { requestDedups.finish(key, entry, writer.result()) }
+		}
+		if entry.bodyHash != bodyHash {
+			common.ErrorWithHttpStatus(c, http.StatusUnprocessableEntity, http.StatusUnprocessableEntity, "X-Request-ID is reused with a different request body")
+			return true, finish
+		}
+		select {
+		case <-ctx.Done():
+			c.Abort()
+			return true, finish
+		case <-entry.done:
+		}
+		// 原请求未产生可复用的结果时重新登记 由其中一个重复请求转发
+		if result := entry.result; result != nil {
+			writeDedupResult(c, result)
+			return true, finish
+		}
+	}
+}
+
+func writeDedupResult(c *gin.Context, result *dedupResult) {
+	header := c.Writer.Header()
+	for k, v := range result.header {
+		header[k] = v
+	}
+	c.Header(headerDeduplicated, "true")
+	setContentLength(header, result.body, strings.HasPrefix(header.Get("Content-Type"), "text/event-stream"))
+	c.Status(result.status)
+	if _, err := c.Writer.Write(result.body); err != nil {
+		slog.Warn("write deduplicated response error", "error", err)
+	}
+}
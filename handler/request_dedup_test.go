@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+)
+
+func setupDedupTest(t *testing.T) (*gin.Engine, *atomic.Int32, chan struct{}, *time.Time) {
+	t.Helper()
+	// 上游每次返回不同内容 release 关闭前阻塞 便于构造进行中的原请求
+	var hits atomic.Int32
+	release := make(chan struct{})
+	r, now := setupDedupUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		<-release
+		fmt.Fprintf(w, `{"choices":[{"message":{"content":"reply-%d"}}]}`, n)
+	})
+	return r, &hits, release, now
+}
+
+// setupDedupUpstream 开启请求去重 并以 handler 作为唯一提供商的上游
+func setupDedupUpstream(t *testing.T, handler http.HandlerFunc) (*gin.Engine, *time.Time) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{}, &models.ChatLog{},
+		&models.ChatIO{}, &models.Config{}, &models.ProviderKey{})
+	if err := db.Create(&models.Config{Key: models.KeyRequestDedup, Value: `{"enabled":true,"window":60}`}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	upstream := httptest.NewServer(handler)
+	t.Cleanup(upstream.Close)
+
+	model := models.Model{Name: "gpt-4o", MaxRetry: 1, TimeOut: 10}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	provider := models.Provider{Name: "alpha", Type: consts.StyleOpenAI, Config: `{"base_url":"` + upstream.URL + `","api_key":"sk-test"}`}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: &enabled, Weight: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	original := requestDedups
+	requestDedups = newDedupRegistry(requestDedupMaxEntries)
+	requestDedups.now = func() time.Time { return now }
+	t.Cleanup(func() { requestDedups = original })
+
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		ctx := context.WithValue(c.Request.Context(), consts.ContextKeyAllowAllModel, true)
+		c.Request = c.Request.WithContext(ctx)
+	}, ChatCompletionsHandler)
+	// 等待异步的日志记录完成
+	t.Cleanup(func() { service.StartLogWriter(nil)(context.Background()) })
+	return r, &now
+}
+
+func doDedup(r http.Handler, requestID, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Cache-Control", "no-store")
+	if requestID != "" {
+		req.Header.Set(headerRequestID, requestID)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+const dedupBody = `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`
+
+func TestRequestDedupWithinWindow(t *testing.T) {
+	r, hits, release, _ := setupDedupTest(t)
+
+	// 原请求进行中时重复请求等待并复用其结果
+	var wg sync.WaitGroup
+	results := make([]*httptest.ResponseRecorder, 2)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0] = doDedup(r, "req-1", dedupBody)
+	}()
+	waitFor(t, func() bool { return hits.Load() == 1 })
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[1] = doDedup(r, "req-1", dedupBody)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// 原请求完成后窗口内的重试直接返回原结果
+	results = append(results, doDedup(r, "req-1", dedupBody))
+	for i, w := range results {
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "reply-1") {
+			t.Fatalf("response %d = %d %s, want reply-1", i, w.Code, w.Body.String())
+		}
+		if got := w.Header().Get(headerRequestID); got != "req-1" {
+			t.Errorf("response %d request id = %q, want req-1", i, got)
+		}
+		if deduplicated := w.Header().Get(headerDeduplicated) != ""; deduplicated != (i > 0) {
+			t.Errorf("response %d deduplicated = %v", i, deduplicated)
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("upstream hits = %d, want 1", n)
+	}
+
+	// 不同的请求 id 照常转发 复用的请求 id 携带不同请求体时拒绝
+	if w := doDedup(r, "req-2", dedupBody); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "reply-2") {
+		t.Errorf("other id = %d %s, want reply-2", w.Code, w.Body.String())
+	}
+	if w := doDedup(r, "req-1", `{"model":"gpt-4o","messages":[{"role":"user","content":"bye"}]}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("mismatched body status = %d, want 422", w.Code)
+	}
+}
+
+func TestRequestDedupExpiredID(t *testing.T) {
+	r, hits, release, now := setupDedupTest(t)
+	close(release)
+
+	if w := doDedup(r, "req-1", dedupBody); !strings.Contains(w.Body.String(), "reply-1") {
+		t.Fatalf("first = %s", w.Body.String())
+	}
+	// 超过窗口后同一请求 id 视为新请求
+	*now = now.Add(61 * time.Second)
+	w := doDedup(r, "req-1", dedupBody)
+	if !strings.Contains(w.Body.String(), "reply-2") || w.Header().Get(headerDeduplicated) != "" {
+		t.Errorf("expired id = %s, want fresh reply-2", w.Body.String())
+	}
+	if n := hits.Load(); n != 2 {
+		t.Errorf("upstream hits = %d, want 2", n)
+	}
+
+	// 未提供请求 id 时不去重 响应中返回生成的 id
+	first, second := doDedup(r, "", dedupBody), doDedup(r, "", dedupBody)
+	if first.Header().Get(headerRequestID) == "" || first.Header().Get(headerRequestID) == second.Header().Get(headerRequestID) {
+		t.Errorf("generated request ids = %q %q", first.Header().Get(headerRequestID), second.Header().Get(headerRequestID))
+	}
+	if n := hits.Load(); n != 4 {
+		t.Errorf("upstream hits = %d, want 4", n)
+	}
+}
+
+func TestRequestDedupFailedStream(t *testing.T) {
+	// 首次请求返回 200 并发送一个事件后断开连接 之后的请求正常结束
+	var hits atomic.Int32
+	r, _ := setupDedupUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":\"reply-%d\"}}]}\n\n", n)
+		if n == 1 {
+			w.(http.Flusher).Flush()
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	})
+
+	body := `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	if w := doDedup(r, "req-1", body); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "error") {
+		t.Fatalf("original = %d %s, want 200 ending with an error event", w.Code, w.Body.String())
+	}
+	// 中途出错使唯一的提供商进入冷却 清除后观察重复请求是否重新转发
+	if err := models.DB.Model(&models.ModelWithProvider{}).Where("1 = 1").Update("provider_cooldown_until", nil).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 原请求的流中途出错 重复请求重新转发而不是复用不完整的结果
+	w := doDedup(r, "req-1", body)
+	if w.Header().Get(headerDeduplicated) != "" || !strings.Contains(w.Body.String(), "reply-2") {
+		t.Errorf("duplicate = %s deduplicated %q, want fresh reply-2", w.Body.String(), w.Header().Get(headerDeduplicated))
+	}
+	if n := hits.Load(); n != 2 {
+		t.Errorf("upstream hits = %d, want 2", n)
+	}
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetRequestLogsFilters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.ChatLog{}, &models.AuthKey{}, &models.ProviderKey{})
+	reasons := []string{models.FailureReasonProviderError, models.FailureReasonClientAbort, models.FailureReasonKeyError, models.FailureReasonTimeout}
+	for i, anomaly := range []string{"", "tools=200", "prompt_chars=500000,max_tokens=1000000", ""} {
+		if err := db.Create(&models.ChatLog{Name: "gpt-4o", Status: "error", FailureReason: reasons[i], Anomaly: anomaly, Shadow: i == 3, ModelDrift: i == 1}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := gin.New()
+	r.GET("/logs", GetRequestLogs)
+
+	tests := []struct {
+		query string
+		code  int64
+		total int64
+	}{
+		{"", 200, 4},
+		{"?anomaly=true", 200, 2},
+		{"?anomaly=false", 200, 2},
+		{"?anomaly=maybe", 400, 0},
+		{"?shadow=true", 200, 1},
+		{"?shadow=false", 200, 3},
+		{"?shadow=maybe", 400, 0},
+		{"?model_drift=true", 200, 1},
+		{"?model_drift=false", 200, 3},
+		{"?model_drift=maybe", 400, 0},
+		{"?failure_reason=" + models.FailureReasonProviderError, 200, 1},
+		{"?failure_reason=" + models.FailureReasonClientAbort, 200, 1},
+		{"?failure_reason=" + models.FailureReasonKeyError, 200, 1},
+		{"?failure_reason=" + models.FailureReasonTimeout, 200, 1},
+		{"?failure_reason=" + models.FailureReasonClientError, 200, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			res := doJSON(r, http.MethodGet, "/logs"+tt.query, "")
+			if res.Get("code").Int() != tt.code {
+				t.Fatalf("code = %d, want %d: %s", res.Get("code").Int(), tt.code, res.Raw)
+			}
+			if tt.code == 200 && res.Get("data.total").Int() != tt.total {
+				t.Errorf("total = %d, want %d", res.Get("data.total").Int(), tt.total)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// hopByHopHeaders 逐跳响应头 只对与上游的连接有效 不转发给客户端
+var hopByHopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding", "Upgrade", "Te", "Trailer"}
+
+// copyResponseHeader 复制上游或缓存的响应头 去掉逐跳头与 Content-Length
+// 转发的响应体可能已被解压或改写 长度由实际写出的响应体决定
+func copyResponseHeader(dst, src http.Header) {
+	for k, values := range src {
+		k = http.CanonicalHeaderKey(k)
+		if k == "Content-Length" || slices.Contains(hopByHopHeaders, k) {
+			continue
+		}
+		for _, value := range values {
+			dst.Add(k, value)
+		}
+	}
+}
+
+// setContentLength 完整缓冲的响应体设置准确的 Content-Length 流式响应不设置 由服务器分块发送
+func setContentLength(header http.Header, body []byte, stream bool) {
+	if stream {
+		header.Del("Content-Length")
+		return
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+}
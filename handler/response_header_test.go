@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/atopos31/llmio/service/cache"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCopyResponseHeader(t *testing.T) {
+	src := http.Header{
+		"Content-Type":      {"application/json"},
+		"Content-Length":    {"999"},
+		"Transfer-Encoding": {"chunked"},
+		"Connection":        {"keep-alive"},
+		"X-Request-Id":      {"req-1"},
+	}
+	dst := http.Header{}
+	copyResponseHeader(dst, src)
+	for _, k := range []string{"Content-Length", "Transfer-Encoding", "Connection"} {
+		if got := dst.Get(k); got != "" {
+			t.Errorf("%s = %q, want stripped", k, got)
+		}
+	}
+	if dst.Get("Content-Type") != "application/json" || dst.Get("X-Request-Id") != "req-1" {
+		t.Errorf("headers not copied: %v", dst)
+	}
+}
+
+func TestWriteCachedResponseContentLength(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name       string
+		stream     bool
+		body       string
+		wantLength int64
+	}{
+		{"non stream", false, `{"choices":[{"message":{"content":"hi"}}]}`, int64(len(`{"choices":[{"message":{"content":"hi"}}]}`))},
+		{"stream", true, "data: {\"choices\":[]}\n\ndata: [DONE]\n\n", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cached := &cache.Value{
+				StatusCode: http.StatusOK,
+				// 缓存中残留的长度与传输编码不应影响重放
+				Header: http.Header{"Content-Length": {"999"}, "Transfer-Encoding": {"chunked"}},
+				Body:   []byte(tt.body),
+			}
+			r := gin.New()
+			r.GET("/", func(c *gin.Context) {
+				writeCachedResponse(c, cached, tt.stream)
+			})
+			server := httptest.NewServer(r)
+			defer server.Close()
+
+			res, err := http.Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+			if res.ContentLength != tt.wantLength {
+				t.Errorf("ContentLength = %d, want %d", res.ContentLength, tt.wantLength)
+			}
+			if tt.stream && res.Header.Get("Content-Length") != "" {
+				t.Errorf("stream response has Content-Length %s", res.Header.Get("Content-Length"))
+			}
+			if !tt.stream && res.Header.Get("Content-Length") != strconv.Itoa(len(tt.body)) {
+				t.Errorf("Content-Length = %s, want %d", res.Header.Get("Content-Length"), len(tt.body))
+			}
+		})
+	}
+}
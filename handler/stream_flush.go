@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+)
+
+// flushWriter 流式响应的写出包装 每个 SSE 事件写完后刷新 避免事件停留在响应缓冲中
+// interval 大于 0 时同一间隔内的事件合并为一次刷新 最多延迟 interval
+type flushWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	flusher  http.Flusher
+	interval time.Duration
+	timer    *time.Timer
+	pending  bool   // 有已写出但未刷新的完整事件
+	tail     []byte // 上次写入的末尾 用于识别跨写入的事件结束
+}
+
+func newFlushWriter(w io.Writer, interval time.Duration) *flushWriter {
+	flusher, _ := w.(http.Flusher)
+	return &flushWriter{w: w, flusher: flusher, interval: interval}
+}
+
+func (w *flushWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n, err := w.w.Write(p)
+	if err != nil || w.flusher == nil {
+		return n, err
+	}
+	// 事件结束的空行可能跨两次写入
+	ended := sseEventEnd(p[:n]) >= 0 || sseEventEnd(append(w.tail, p[:min(n, 2)]...)) >= 0
+	w.tail = append(w.tail, p[max(n-2, 0):n]...)
+	w.tail = w.tail[max(len(w.tail)-2, 0):]
+	if !ended {
+		return n, nil
+	}
+	if w.interval <= 0 {
+		w.flusher.Flush()
+		return n, nil
+	}
+	w.pending = true
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.interval, w.flushPending)
+	}
+	return n, nil
+}
+
+func (w *flushWriter) flushPending() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timer = nil
+	if w.pending {
+		w.flusher.Flush()
+		w.pending = false
+	}
+}
+
+// Close 停止定时刷新并刷新剩余的数据 之后的写入按事件立即刷新
+// 调用方在直接刷新底层响应前需先调用 避免与定时刷新并发
+func (w *flushWriter) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	w.interval = 0
+	if w.flusher != nil {
+		w.flusher.Flush()
+	}
+	w.pending = false
+}
+
+// loadStreamFlushInterval 读取流式响应的刷新间隔 未配置或读取失败时每个事件后立即刷新
+func loadStreamFlushInterval(ctx context.Context) time.Duration {
+	config, err := service.LoadConfig[models.StreamFlush](ctx, models.KeyStreamFlush)
+	if err != nil {
+		slog.Error("load stream flush config error", "error", err)
+		return 0
+	}
+	if config == nil || config.Interval <= 0 {
+		return 0
+	}
+	return time.Duration(config.Interval) * time.Millisecond
+}
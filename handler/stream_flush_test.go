@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+)
+
+// countingFlusher 记录刷新次数与刷新时已写入的数据
+type countingFlusher struct {
+	mu      sync.Mutex
+	written strings.Builder
+	flushed []string
+}
+
+func (f *countingFlusher) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.written.Write(p)
+}
+
+func (f *countingFlusher) Flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flushed = append(f.flushed, f.written.String())
+}
+
+func (f *countingFlusher) flushes() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.flushed...)
+}
+
+func TestFlushWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   []string // 每次刷新时已写出的数据
+	}{
+		{"flush per event", []string{"data: a\n\n", "data: b\n\n"}, []string{"data: a\n\n", "data: a\n\ndata: b\n\n"}},
+		{"partial event waits", []string{"data: a", "\n"}, nil},
+		{"boundary across writes", []string{"data: a\n", "\n"}, []string{"data: a\n\n"}},
+		{"crlf boundary across writes", []string{"data: a\r\n", "\r\n"}, []string{"data: a\r\n\r\n"}},
+		{"events in one write flush once", []string{"data: a\n\ndata: b\n\n"}, []string{"data: a\n\ndata: b\n\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &countingFlusher{}
+			w := newFlushWriter(f, 0)
+			for _, s := range tt.writes {
+				if _, err := w.Write([]byte(s)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got := f.flushes()
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("flushes = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlushWriterInterval(t *testing.T) {
+	f := &countingFlusher{}
+	w := newFlushWriter(f, 30*time.Millisecond)
+	w.Write([]byte("data: a\n\n"))
+	w.Write([]byte("data: b\n\n"))
+	if got := f.flushes(); len(got) != 0 {
+		t.Fatalf("flushed before interval: %q", got)
+	}
+	// 同一间隔内的事件合并为一次刷新
+	waitFor(t, func() bool { return len(f.flushes()) == 1 })
+	if got := f.flushes(); got[0] != "data: a\n\ndata: b\n\n" {
+		t.Errorf("flush = %q", got[0])
+	}
+
+	// 关闭时刷新剩余数据 之后的事件立即刷新
+	w.Write([]byte("data: c\n\n"))
+	w.Close()
+	w.Write([]byte("data: d\n\n"))
+	if got := f.flushes(); len(got) != 3 || !strings.HasSuffix(got[2], "data: d\n\n") {
+		t.Errorf("flushes = %q", got)
+	}
+}
+
+func TestChatStreamFlushesEachEvent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{}, &models.ChatLog{},
+		&models.ChatIO{}, &models.Config{}, &models.ProviderKey{})
+	t.Cleanup(func() { service.StartLogWriter(nil)(context.Background()) })
+
+	const interval = 300 * time.Millisecond
+	model := models.Model{Name: "gpt-4o", MaxRetry: 1, TimeOut: 10}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	provider := models.Provider{Name: "mock", Type: consts.StyleMock, Config: `{"body":{},"chunk_interval":300,"chunks":[` +
+		`{"choices":[{"delta":{"content":"a"}}]},{"choices":[{"delta":{"content":"b"}}]},"[DONE]"]}`}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: &enabled, Weight: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), consts.ContextKeyAllowAllModel, true))
+	}, ChatCompletionsHandler)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/v1/chat/completions",
+		strings.NewReader(`{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("Cache-Control", "no-store")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	// 首个事件应在上游发送后续事件之前到达 而不是在流结束时一起到达
+	reader := bufio.NewReader(res.Body)
+	first, err := reader.ReadString('\n')
+	if err != nil || !strings.Contains(first, `"a"`) {
+		t.Fatalf("first line = %q, err %v", first, err)
+	}
+	firstAt := time.Now()
+	var rest strings.Builder
+	if _, err := reader.WriteTo(&rest); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rest.String(), `"b"`) || !strings.Contains(rest.String(), "[DONE]") {
+		t.Fatalf("rest of stream = %q", rest.String())
+	}
+	if elapsed := time.Since(firstAt); elapsed < interval {
+		t.Errorf("first event arrived %v before the end of the stream, want at least %v", elapsed, interval)
+	}
+}
@@ -0,0 +1,270 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	headerLastEventID = "Last-Event-ID"
+	// resumeMaxStreams 同时保留的可续传流数量
+	resumeMaxStreams = 256
+	// resumeMaxEvents 与 resumeMaxBytes 限制单个流缓冲的事件 超出后丢弃最早的事件
+	resumeMaxEvents = 2048
+	resumeMaxBytes  = 1 << 20
+	// resumeRetention 流结束后仍可续传的时间
+	resumeRetention = time.Minute
+)
+
+// streamResumes 全局续传缓冲 按流 id 查找
+var streamResumes = newResumeRegistry(resumeMaxStreams, resumeRetention)
+
+// resumeStream 单个流式响应最近的事件 序号从 1 开始
+type resumeStream struct {
+	id    string
+	scope string // 仅允许同一 auth key 与模型续传
+
+	mu         sync.Mutex
+	events     [][]byte // 已带 id 行的完整事件
+	firstSeq   int      // events[0] 的序号
+	size       int
+	done       bool
+	finishedAt time.Time
+	notify     chan struct{} // 有新事件或结束时关闭
+}
+
+func newResumeStream(id, scope string) *resumeStream {
+	return &resumeStream{id: id, scope: scope, firstSeq: 1, notify: make(chan struct{})}
+}
+
+// append 为事件分配序号并写入缓冲 返回带 id 行的事件
+func (s *resumeStream) append(event []byte) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seq := s.firstSeq + len(s.events)
+	framed := append(fmt.Appendf(nil, "id: %s-%d\n", s.id, seq), event...)
+	s.events = append(s.events, framed)
+	s.size += len(framed)
+	for len(s.events) > resumeMaxEvents || (s.size > resumeMaxBytes && len(s.events) > 1) {
+		s.size -= len(s.events[0])
+		s.events = s.events[1:]
+		s.firstSeq++
+	}
+	s.broadcast()
+	return framed
+}
+
+func (s *resumeStream) finish(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+	s.done = true
+	s.finishedAt = now
+	s.broadcast()
+}
+
+func (s *resumeStream) broadcast() {
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// since 返回序号大于 after 的事件 所需事件已被丢弃时 ok 为 false
+func (s *resumeStream) since(after int) (events [][]byte, done bool, notify <-chan struct{}, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start := after - (s.firstSeq - 1)
+	if start < 0 || start > len(s.events) {
+		return nil, false, nil, false
+	}
+	return slices.Clone(s.events[start:]), s.done, s.notify, true
+}
+
+// resumeRegistry 有界的流注册表 结束超过保留时间的流会被淘汰 已满时淘汰最早注册的流
+type resumeRegistry struct {
+	mu        sync.Mutex
+	streams   map[string]*resumeStream
+	order     []string
+	max       int
+	retention time.Duration
+	now       func() time.Time
+}
+
+func newResumeRegistry(max int, retention time.Duration) *resumeRegistry {
+	return &resumeRegistry{
+		streams:   make(map[string]*resumeStream),
+		max:       max,
+		retention: retention,
+		now:       time.Now,
+	}
+}
+
+func (r *resumeRegistry) register(scope string) *resumeStream {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	r.order = slices.DeleteFunc(r.order, func(id string) bool {
+		stream := r.streams[id]
+		stream.mu.Lock()
+		expired := stream.done && now.Sub(stream.finishedAt) >= r.retention
+		stream.mu.Unlock()
+		if expired {
+			delete(r.streams, id)
+		}
+		return expired
+	})
+	for len(r.order) >= r.max {
+		delete(r.streams, r.order[0])
+		r.order = r.order[1:]
+	}
+
+	stream := newResumeStream(rand.Text(), scope)
+	r.streams[stream.id] = stream
+	r.order = append(r.order, stream.id)
+	return stream
+}
+
+// lookup 解析 Last-Event-ID 格式为 {流id}-{序号}
+func (r *resumeRegistry) lookup(lastEventID, scope string) (*resumeStream, int, bool) {
+	idx := strings.LastIndex(lastEventID, "-")
+	if idx <= 0 {
+		return nil, 0, false
+	}
+	seq, err := strconv.Atoi(lastEventID[idx+1:])
+	if err != nil || seq < 0 {
+		return nil, 0, false
+	}
+	r.mu.Lock()
+	stream, ok := r.streams[lastEventID[:idx]]
+	r.mu.Unlock()
+	if !ok || stream.scope != scope {
+		return nil, 0, false
+	}
+	return stream, seq, true
+}
+
+// resumeWriter 将上游 SSE 按事件拆分 为数据事件添加 id 行并写入续传缓冲
+// 客户端断开后不再写出 但继续缓冲直到上游结束 供重连续传
+type resumeWriter struct {
+	w        io.Writer
+	stream   *resumeStream
+	pending  []byte
+	detached bool
+	now      func() time.Time
+}
+
+func newResumeWriter(w io.Writer, stream *resumeStream) *resumeWriter {
+	return &resumeWriter{w: w, stream: stream, now: time.Now}
+}
+
+func (w *resumeWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	for {
+		end := sseEventEnd(w.pending)
+		if end < 0 {
+			break
+		}
+		w.writeEvent(w.pending[:end])
+		w.pending = w.pending[end:]
+	}
+	return len(p), nil
+}
+
+func (w *resumeWriter) writeEvent(event []byte) {
+	// 仅心跳注释等不含数据的事件不分配 id
+	if hasSSEData(event) {
+		event = w.stream.append(event)
+	}
+	if w.detached {
+		return
+	}
+	if _, err := w.w.Write(event); err != nil {
+		w.detached = true
+		return
+	}
+	if flusher, ok := w.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close 写出末尾未以空行结束的数据并标记流结束
+func (w *resumeWriter) Close() {
+	if len(w.pending) > 0 {
+		w.writeEvent(w.pending)
+		w.pending = nil
+	}
+	w.stream.finish(w.now())
+}
+
+// sseEventEnd 返回首个事件结束(空行)之后的位置 未找到时返回 -1
+func sseEventEnd(data []byte) int {
+	end := -1
+	if i := bytes.Index(data, []byte("\n\n")); i >= 0 {
+		end = i + 2
+	}
+	if i := bytes.Index(data, []byte("\n\r\n")); i >= 0 && (end < 0 || i+3 < end) {
+		end = i + 3
+	}
+	return end
+}
+
+func hasSSEData(event []byte) bool {
+	for line := range strings.Lines(string(event)) {
+		if strings.HasPrefix(line, "data:") {
+			return true
+		}
+	}
+	return false
+}
+
+// resumeFromLastEvent 按 Last-Event-ID 重放之后的事件 原响应仍在生成时继续跟随直到结束
+// 找不到对应的流或所需事件已被丢弃时返回 false 由调用方按新请求处理
+func resumeFromLastEvent(c *gin.Context, lastEventID, scope string) bool {
+	stream, after, ok := streamResumes.lookup(lastEventID, scope)
+	if !ok {
+		return false
+	}
+	events, done, notify, ok := stream.since(after)
+	if !ok {
+		return false
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	for {
+		for _, event := range events {
+			if _, err := c.Writer.Write(event); err != nil {
+				return true
+			}
+			after++
+		}
+		c.Writer.Flush()
+		if done {
+			return true
+		}
+		select {
+		case <-notify:
+		case <-c.Request.Context().Done():
+			return true
+		}
+		if events, done, notify, ok = stream.since(after); !ok {
+			// 跟随过程中缓冲被截断 无法保证连续 直接结束
+			return true
+		}
+	}
+}
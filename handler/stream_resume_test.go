@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResumeWriterAssignsIDs(t *testing.T) {
+	stream := newResumeStream("S", "scope")
+	var out bytes.Buffer
+	w := newResumeWriter(&out, stream)
+
+	// 事件跨多次写入 心跳不分配 id
+	for _, chunk := range []string{"data: {\"a\":1}\n", "\n: keep-alive\n\ndata: {\"a\"", ":2}\n\ndata: [DONE]"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	want := "id: S-1\ndata: {\"a\":1}\n\n: keep-alive\n\nid: S-2\ndata: {\"a\":2}\n\nid: S-3\ndata: [DONE]"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+	events, done, _, ok := stream.since(1)
+	if !ok || !done || len(events) != 2 {
+		t.Fatalf("since(1) = %d events, done %v, ok %v", len(events), done, ok)
+	}
+	if _, _, _, ok := stream.since(4); ok {
+		t.Error("expected unknown future id to be rejected")
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("client gone") }
+
+func TestResumeWriterKeepsBufferingAfterDisconnect(t *testing.T) {
+	stream := newResumeStream("S", "scope")
+	w := newResumeWriter(failingWriter{}, stream)
+	for i := range 3 {
+		if _, err := fmt.Fprintf(w, "data: %d\n\n", i); err != nil {
+			t.Fatalf("write after disconnect should not fail: %v", err)
+		}
+	}
+	w.Close()
+	if events, _, _, _ := stream.since(0); len(events) != 3 {
+		t.Errorf("buffered %d events, want 3", len(events))
+	}
+}
+
+func TestResumeStreamTruncation(t *testing.T) {
+	stream := newResumeStream("S", "scope")
+	for i := range resumeMaxEvents + 10 {
+		stream.append(fmt.Appendf(nil, "data: %d\n\n", i))
+	}
+	if _, _, _, ok := stream.since(5); ok {
+		t.Error("expected dropped events to make resume impossible")
+	}
+	if events, _, _, ok := stream.since(resumeMaxEvents + 9); !ok || len(events) != 1 {
+		t.Errorf("expected last event to be resumable, got %d events ok %v", len(events), ok)
+	}
+}
+
+func TestResumeRegistry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	registry := newResumeRegistry(2, time.Minute)
+	registry.now = func() time.Time { return now }
+
+	a := registry.register("key1|gpt-4o")
+	if _, _, ok := registry.lookup(a.id+"-0", "key2|gpt-4o"); ok {
+		t.Error("expected other scope to be rejected")
+	}
+	if stream, seq, ok := registry.lookup(a.id+"-3", "key1|gpt-4o"); !ok || stream != a || seq != 3 {
+		t.Errorf("lookup = %v, %d, %v", stream, seq, ok)
+	}
+	for _, id := range []string{"", a.id, a.id + "-x", "missing-1"} {
+		if _, _, ok := registry.lookup(id, "key1|gpt-4o"); ok {
+			t.Errorf("expected %q to be rejected", id)
+		}
+	}
+
+	// 结束超过保留时间后淘汰
+	a.finish(now)
+	now = now.Add(time.Minute)
+	registry.register("key1|gpt-4o")
+	if _, _, ok := registry.lookup(a.id+"-0", "key1|gpt-4o"); ok {
+		t.Error("expected finished stream to expire")
+	}
+	// 已满时淘汰最早注册的流
+	registry.register("key1|gpt-4o")
+	c := registry.register("key1|gpt-4o")
+	if len(registry.streams) != 2 || registry.streams[c.id] == nil {
+		t.Errorf("registry holds %d streams", len(registry.streams))
+	}
+}
+
+func TestResumeFromLastEvent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	scope := "1|gpt-4o"
+	stream := streamResumes.register(scope)
+	live := newResumeWriter(io.Discard, stream)
+	fmt.Fprint(live, "data: one\n\ndata: two\n\n")
+
+	r := gin.New()
+	r.POST("/resume", func(c *gin.Context) {
+		if !resumeFromLastEvent(c, c.GetHeader(headerLastEventID), c.Query("scope")) {
+			c.String(http.StatusTeapot, "fresh")
+		}
+	})
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	resume := func(lastEventID, scope string) (*http.Response, error) {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/resume?scope="+scope, nil)
+		req.Header.Set(headerLastEventID, lastEventID)
+		return http.DefaultClient.Do(req)
+	}
+
+	// 其他 key 或未知 id 按新请求处理
+	for _, tt := range []struct{ id, scope string }{{stream.id + "-1", "2|gpt-4o"}, {"unknown-1", scope}} {
+		res, err := resume(tt.id, tt.scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusTeapot {
+			t.Errorf("Last-Event-ID %s scope %s: status %d, want fallback", tt.id, tt.scope, res.StatusCode)
+		}
+	}
+
+	// 重连后先重放缓冲中的事件 再跟随仍在生成的响应
+	res, err := resume(stream.id+"-1", scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		fmt.Fprint(live, "data: three\n\n")
+		live.Close()
+	}()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("id: %[1]s-2\ndata: two\n\nid: %[1]s-3\ndata: three\n\n", stream.id)
+	if string(body) != want {
+		t.Errorf("resumed body = %q, want %q", body, want)
+	}
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/event-stream") {
+		t.Errorf("content type = %s", res.Header.Get("Content-Type"))
+	}
+}
@@ -94,12 +94,16 @@ func ProviderTestHandler(c *gin.Context) {
 	}
 
 	// Test connectivity by fetching models
-	client := providers.GetClient(time.Second * time.Duration(30))
+	client, err := providers.GetProviderClient(time.Second*time.Duration(30), chatModel.Config)
+	if err != nil {
+		common.BadRequest(c, "Failed to create client: "+err.Error())
+		return
+	}
 	var testBody []byte
 	switch chatModel.Type {
-	case consts.StyleOpenAI:
+	case consts.StyleOpenAI, consts.StyleMock:
 		testBody = []byte(testOpenAI)
-	case consts.StyleAnthropic:
+	case consts.StyleAnthropic, consts.StyleBedrock:
 		testBody = []byte(testAnthropic)
 	case consts.StyleOpenAIRes:
 		testBody = []byte(testOpenAIRes)
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strconv"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// PreviewModelProviderTransform 预览样例请求经过转换后发往该关联提供商的请求体 不发送请求
+// 接口风格由 style 查询参数指定 未指定时按提供商类型推断
+func PreviewModelProviderTransform(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+	raw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	mp, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", id).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.NotFound(c, "Model provider association not found")
+			return
+		}
+		common.InternalServerError(c, "Database error: "+err.Error())
+		return
+	}
+	model, err := gorm.G[models.Model](models.DB).Where("id = ?", mp.ModelID).First(ctx)
+	if err != nil {
+		common.InternalServerError(c, "Failed to load model: "+err.Error())
+		return
+	}
+	provider, err := gorm.G[models.Provider](models.DB).Where("id = ?", mp.ProviderID).First(ctx)
+	if err != nil {
+		common.InternalServerError(c, "Failed to load provider: "+err.Error())
+		return
+	}
+
+	style := c.Query("style")
+	if style == "" {
+		style = previewStyle(provider.Type)
+	}
+	if !slices.Contains(service.ProviderTypes(style), provider.Type) {
+		common.BadRequest(c, "Provider type "+provider.Type+" cannot serve style "+style)
+		return
+	}
+	preview, err := service.PreviewTransform(ctx, style, raw, model, mp, provider)
+	if err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+	common.Success(c, preview)
+}
+
+// previewStyle 提供商类型默认对应的接口风格
+func previewStyle(providerType string) string {
+	switch providerType {
+	case consts.StyleBedrock:
+		return consts.StyleAnthropic
+	case consts.StyleMock:
+		return consts.StyleOpenAI
+	}
+	return providerType
+}
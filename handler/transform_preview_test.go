@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestPreviewModelProviderTransform(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.Provider{}, &models.Model{}, &models.ModelWithProvider{})
+
+	maxTemp := 1.0
+	nonStream := true
+	openai := models.Provider{Name: "compat", Type: "openai", Config: `{"base_url":"https://compat.example/v1","api_key":"sk","strip_params":["service_tier"]}`}
+	anthropic := models.Provider{Name: "claude", Type: "anthropic", Config: `{"base_url":"https://api.anthropic.com/v1","api_key":"sk","version":"2023-06-01"}`}
+	clamp := models.Model{Name: "chat", ParamPolicy: models.ParamPolicy{Bounds: map[string]models.ParamBound{"temperature": {Max: &maxTemp}}}}
+	reject := models.Model{Name: "strict", ParamPolicy: models.ParamPolicy{Mode: models.ParamPolicyReject, Bounds: map[string]models.ParamBound{"temperature": {Max: &maxTemp}}}}
+	for _, v := range []any{&openai, &anthropic, &clamp, &reject} {
+		if err := db.Create(v).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, mp := range []models.ModelWithProvider{
+		{ModelID: clamp.ID, ProviderID: openai.ID, ProviderModel: "upstream-chat", NonStream: &nonStream},
+		{ModelID: reject.ID, ProviderID: openai.ID, ProviderModel: "upstream-strict"},
+		{ModelID: clamp.ID, ProviderID: anthropic.ID, ProviderModel: "claude-x"},
+	} {
+		if err := db.Create(&mp).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := gin.New()
+	r.POST("/model-providers/:id/transform/preview", PreviewModelProviderTransform)
+
+	body := `{"model":"chat","messages":[{"role":"user","content":"hi"}],"temperature":1.5,"stream":true,"stream_options":{"include_usage":true},"service_tier":"flex"}`
+	res := doJSON(r, http.MethodPost, "/model-providers/1/transform/preview", body)
+	if res.Get("code").Int() != 200 {
+		t.Fatalf("preview: %s", res.Raw)
+	}
+	data := res.Get("data")
+	if data.Get("style").String() != "openai" || data.Get("method").String() != http.MethodPost ||
+		data.Get("url").String() != "https://compat.example/v1/chat/completions" {
+		t.Errorf("request line: %s", data.Raw)
+	}
+	after := data.Get("after")
+	if after.Get("model").String() != "upstream-chat" || after.Get("temperature").Float() != 1 ||
+		after.Get("stream").Bool() || after.Get("stream_options").Exists() || after.Get("service_tier").Exists() {
+		t.Errorf("after = %s", after.Raw)
+	}
+	if !data.Get("synthetic_stream").Bool() || data.Get("clamped_params.0").String() != "temperature" {
+		t.Errorf("flags: %s", data.Raw)
+	}
+	changes := map[string]string{}
+	for _, c := range data.Get("changes").Array() {
+		changes[c.Get("path").String()] = c.Get("op").String()
+	}
+	for path, op := range map[string]string{"model": "replace", "temperature": "replace", "stream": "replace",
+		"stream_options.include_usage": "remove", "service_tier": "remove"} {
+		if changes[path] != op {
+			t.Errorf("change %s = %q, want %q (%v)", path, changes[path], op, changes)
+		}
+	}
+	if _, ok := changes["messages.0.content"]; ok {
+		t.Errorf("unchanged path listed: %v", changes)
+	}
+
+	// reject 策略不生成上游请求
+	res = doJSON(r, http.MethodPost, "/model-providers/2/transform/preview", body)
+	if res.Get("data.rejected").String() == "" || len(res.Get("data.changes").Array()) != 0 {
+		t.Errorf("reject preview: %s", res.Raw)
+	}
+
+	// Anthropic 提供商默认按 anthropic 风格预览
+	res = doJSON(r, http.MethodPost, "/model-providers/3/transform/preview", `{"model":"chat","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}`)
+	if res.Get("data.style").String() != "anthropic" || res.Get("data.after.model").String() != "claude-x" {
+		t.Errorf("anthropic preview: %s", res.Raw)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		code int64
+	}{
+		{"unknown association", "/model-providers/99/transform/preview", body, 404},
+		{"style mismatch", "/model-providers/3/transform/preview?style=openai", body, 400},
+		{"unknown style", "/model-providers/1/transform/preview?style=gemini", body, 400},
+		{"invalid body", "/model-providers/1/transform/preview", `{"model":`, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if res := doJSON(r, http.MethodPost, tt.path, tt.body); res.Get("code").Int() != tt.code {
+				t.Errorf("code = %d, want %d: %s", res.Get("code").Int(), tt.code, res.Raw)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+)
+
+// usageBucketWidth 支持的分桶宽度及每页桶数的默认值与上限 与 OpenAI 用量接口一致
+var usageBucketWidth = map[string]struct {
+	width        time.Duration
+	defaultLimit int
+	maxLimit     int
+}{
+	"1h": {time.Hour, 24, 168},
+	"1d": {24 * time.Hour, 7, 31},
+}
+
+// UsagePage OpenAI 用量接口的分页响应
+type UsagePage struct {
+	Object   string        `json:"object"`
+	Data     []UsageBucket `json:"data"`
+	HasMore  bool          `json:"has_more"`
+	NextPage *string       `json:"next_page"`
+}
+
+type UsageBucket struct {
+	Object    string        `json:"object"`
+	StartTime int64         `json:"start_time"`
+	EndTime   int64         `json:"end_time"`
+	Results   []UsageResult `json:"results"`
+}
+
+// UsageResult 对应 organization.usage.completions.result 未分组的维度为 null
+type UsageResult struct {
+	Object            string  `json:"object"`
+	InputTokens       int64   `json:"input_tokens"`
+	OutputTokens      int64   `json:"output_tokens"`
+	InputCachedTokens int64   `json:"input_cached_tokens"`
+	InputAudioTokens  int64   `json:"input_audio_tokens"`
+	OutputAudioTokens int64   `json:"output_audio_tokens"`
+	NumModelRequests  int64   `json:"num_model_requests"`
+	ProjectID         *string `json:"project_id"`
+	UserID            *string `json:"user_id"`
+	APIKeyID          *string `json:"api_key_id"`
+	Model             *string `json:"model"`
+	Batch             *bool   `json:"batch"`
+}
+
+// queryArray 兼容 models=a&models=b 与 models[]=a 两种数组参数
+func queryArray(c *gin.Context, key string) []string {
+	return append(c.QueryArray(key), c.QueryArray(key+"[]")...)
+}
+
+// UsageCompletionsHandler 兼容 OpenAI /v1/organization/usage/completions 的用量查询
+// 普通 auth key 只能查询自身用量 管理员 token 可通过 api_key_ids 筛选
+func UsageCompletionsHandler(c *gin.Context) {
+	startTime, err := strconv.ParseInt(c.Query("start_time"), 10, 64)
+	if err != nil {
+		common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, "start_time is required and must be a unix timestamp")
+		return
+	}
+	end := time.Now()
+	if raw := c.Query("end_time"); raw != "" {
+		endTime, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, "end_time must be a unix timestamp")
+			return
+		}
+		end = time.Unix(endTime, 0)
+	}
+	bucket, ok := usageBucketWidth[c.DefaultQuery("bucket_width", "1d")]
+	if !ok {
+		common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, "bucket_width must be one of 1h, 1d")
+		return
+	}
+	limit := bucket.defaultLimit
+	if raw := c.Query("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 1 || limit > bucket.maxLimit {
+			common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(bucket.maxLimit))
+			return
+		}
+	}
+	groupByModel, groupByKey := false, false
+	for _, field := range queryArray(c, "group_by") {
+		switch field {
+		case "model":
+			groupByModel = true
+		case "api_key_id":
+			groupByKey = true
+		case "project_id", "user_id", "batch":
+			// 网关没有这些维度 始终为 null
+		default:
+			common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, "invalid group_by: "+field)
+			return
+		}
+	}
+
+	// 桶起点按 UTC 对齐 page 为下一页首个桶的起点
+	start := time.Unix(startTime, 0).Truncate(bucket.width)
+	if page := c.Query("page"); page != "" {
+		cursor, err := strconv.ParseInt(page, 10, 64)
+		if err != nil || cursor < start.Unix() {
+			common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, "invalid page")
+			return
+		}
+		start = time.Unix(cursor, 0)
+	}
+	pageEnd := start.Add(time.Duration(limit) * bucket.width)
+	hasMore := pageEnd.Before(end)
+	if hasMore {
+		end = pageEnd
+	}
+
+	query := service.UsageQuery{Start: start, End: end, Width: bucket.width, Models: queryArray(c, "models")}
+	if authKeyID, ok := c.Request.Context().Value(consts.ContextKeyAuthKeyID).(uint); ok {
+		query.AuthKeyIDs = []uint{authKeyID}
+	} else {
+		for _, raw := range queryArray(c, "api_key_ids") {
+			id, err := strconv.ParseUint(raw, 10, 64)
+			if err != nil {
+				common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, "invalid api_key_ids: "+raw)
+				return
+			}
+			query.AuthKeyIDs = append(query.AuthKeyIDs, uint(id))
+		}
+	}
+	rows, err := service.QueryUsage(c.Request.Context(), query)
+	if err != nil {
+		common.InternalServerError(c, err.Error())
+		return
+	}
+
+	buckets := make([]UsageBucket, 0, limit)
+	for bucketStart := start; bucketStart.Before(end); bucketStart = bucketStart.Add(bucket.width) {
+		buckets = append(buckets, UsageBucket{
+			Object:    "bucket",
+			StartTime: bucketStart.Unix(),
+			EndTime:   bucketStart.Add(bucket.width).Unix(),
+			Results:   make([]UsageResult, 0),
+		})
+	}
+	for _, row := range rows {
+		if row.Bucket < 0 || int(row.Bucket) >= len(buckets) {
+			continue
+		}
+		results := &buckets[row.Bucket].Results
+		var model, keyID *string
+		if groupByModel {
+			model = &row.Name
+		}
+		if groupByKey {
+			id := strconv.FormatUint(uint64(row.AuthKeyID), 10)
+			keyID = &id
+		}
+		idx := slices.IndexFunc(*results, func(r UsageResult) bool {
+			return equalPtr(r.Model, model) && equalPtr(r.APIKeyID, keyID)
+		})
+		if idx < 0 {
+			*results = append(*results, UsageResult{Object: "organization.usage.completions.result", Model: model, APIKeyID: keyID})
+			idx = len(*results) - 1
+		}
+		result := &(*results)[idx]
+		result.InputTokens += row.PromptTokens
+		result.OutputTokens += row.CompletionTokens
+		result.InputCachedTokens += row.CachedTokens
+		result.InputAudioTokens += row.PromptAudioTokens
+		result.NumModelRequests += row.Reqs
+	}
+
+	response := UsagePage{Object: "page", Data: buckets, HasMore: hasMore}
+	if hasMore {
+		next := strconv.FormatInt(end.Unix(), 10)
+		response.NextPage = &next
+	}
+	common.SuccessRaw(c, response)
+}
+
+func equalPtr(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestUsageCompletionsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t, &models.ChatLog{})
+
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC).Unix()
+	for _, l := range []struct {
+		offset        time.Duration
+		key           uint
+		model, status string
+		input, output int64
+		cached        int64
+	}{
+		{time.Hour, 1, "gpt-4o", "success", 10, 5, 4},
+		{2 * time.Hour, 2, "gpt-4o-mini", "success", 20, 10, 0},
+		{3 * time.Hour, 1, "gpt-4o", "error", 0, 0, 0}, // 失败的重试记录不计入
+		{25 * time.Hour, 1, "gpt-4o-mini", "success", 7, 3, 0},
+	} {
+		log := models.ChatLog{Name: l.model, Status: l.status, AuthKeyID: l.key, Usage: models.Usage{
+			PromptTokens: l.input, CompletionTokens: l.output, TotalTokens: l.input + l.output,
+			PromptTokensDetails: models.PromptTokensDetails{CachedTokens: l.cached},
+		}}
+		log.CreatedAt = time.Unix(day, 0).Add(l.offset)
+		if err := db.Create(&log).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := gin.New()
+	r.GET("/usage", func(c *gin.Context) {
+		// 模拟鉴权中间件 未携带时视为管理员
+		if key := c.GetHeader("X-Test-Key"); key != "" {
+			id, _ := strconv.Atoi(key)
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), consts.ContextKeyAuthKeyID, uint(id)))
+		}
+	}, UsageCompletionsHandler)
+	get := func(key, query string) *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, "/usage?"+query, nil)
+		if key != "" {
+			req.Header.Set("X-Test-Key", key)
+		}
+		return req
+	}
+	window := fmt.Sprintf("start_time=%d&end_time=%d", day+3600, day+2*86400)
+
+	tests := []struct {
+		name    string
+		key     string
+		query   string
+		buckets int
+		// 每个桶的结果 以 input_tokens/num_model_requests 形式表示
+		want    []string
+		hasMore bool
+	}{
+		{name: "totals", query: window, buckets: 2, want: []string{"30/2", "7/1"}},
+		{name: "group by model", query: window + "&group_by=model", buckets: 2, want: []string{"10/1 20/1", "7/1"}},
+		{name: "own key only", key: "1", query: window + "&api_key_ids=2", buckets: 2, want: []string{"10/1", "7/1"}},
+		{name: "admin key filter", query: window + "&api_key_ids[]=2", buckets: 2, want: []string{"20/1", ""}},
+		{name: "model filter", query: window + "&models=gpt-4o-mini", buckets: 2, want: []string{"20/1", "7/1"}},
+		{name: "first page", query: window + "&limit=1", buckets: 1, want: []string{"30/2"}, hasMore: true},
+		{name: "next page", query: window + fmt.Sprintf("&limit=1&page=%d", day+86400), buckets: 1, want: []string{"7/1"}},
+		{name: "hourly", query: fmt.Sprintf("start_time=%d&end_time=%d&bucket_width=1h", day, day+3*3600), buckets: 3, want: []string{"", "10/1", "20/1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := doRequest(r, get(tt.key, tt.query))
+			if res.Get("object").String() != "page" || len(res.Get("data").Array()) != tt.buckets {
+				t.Fatalf("unexpected page: %s", res.Raw)
+			}
+			for i, bucket := range res.Get("data").Array() {
+				var got string
+				for j, result := range bucket.Get("results").Array() {
+					if j > 0 {
+						got += " "
+					}
+					got += fmt.Sprintf("%d/%d", result.Get("input_tokens").Int(), result.Get("num_model_requests").Int())
+				}
+				if got != tt.want[i] {
+					t.Errorf("bucket %d = %q, want %q", i, got, tt.want[i])
+				}
+			}
+			if res.Get("has_more").Bool() != tt.hasMore {
+				t.Errorf("has_more = %v, want %v", res.Get("has_more").Bool(), tt.hasMore)
+			}
+		})
+	}
+
+	res := doRequest(r, get("", window+"&group_by=model&group_by=api_key_id"))
+	first := res.Get("data.0.results.0")
+	if first.Get("object").String() != "organization.usage.completions.result" || first.Get("model").String() != "gpt-4o" ||
+		first.Get("api_key_id").String() != "1" || first.Get("input_cached_tokens").Int() != 4 || first.Get("output_tokens").Int() != 5 {
+		t.Errorf("unexpected grouped result: %s", first.Raw)
+	}
+	if start := res.Get("data.0.start_time").Int(); start != day {
+		t.Errorf("bucket start = %d, want aligned %d", start, day)
+	}
+	if res := doRequest(r, get("", window)); !res.Get("data.0.results.0.model").Exists() || res.Get("data.0.results.0.model").Type.String() != "Null" {
+		t.Errorf("ungrouped model should be null: %s", res.Raw)
+	}
+
+	for _, query := range []string{"", "start_time=abc", window + "&bucket_width=1m", window + "&limit=32", window + "&group_by=foo"} {
+		if res := doRequest(r, get("", query)); res.Get("code").Int() != http.StatusBadRequest {
+			t.Errorf("query %q: expected 400, got %s", query, res.Raw)
+		}
+	}
+}
+
+func doRequest(r http.Handler, req *http.Request) gjson.Result {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return gjson.Parse(w.Body.String())
+}
@@ -3,18 +3,24 @@ package main
 import (
 	"context"
 	"embed"
+	"errors"
+	"flag"
 	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 	_ "time/tzdata"
 
 	"github.com/atopos31/llmio/handler"
 	"github.com/atopos31/llmio/middleware"
 	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
 	"github.com/atopos31/llmio/service/keypool"
+	"github.com/atopos31/llmio/service/tracing"
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
 	_ "golang.org/x/crypto/x509roots/fallback"
@@ -34,35 +40,77 @@ func init() {
 }
 
 func main() {
+	ctx := context.Background()
+	if len(os.Args) > 1 && os.Args[1] == "backfill-stats" {
+		backfillStats(ctx, os.Args[2:])
+		return
+	}
+	// 链路追踪 配置读取失败时不影响服务启动
+	tracingConfig, err := service.LoadConfig[models.Tracing](ctx, models.KeyTracing)
+	if err != nil {
+		slog.Error("Failed to load tracing config", "error", err)
+	}
+	shutdownTracing, err := tracing.Init(ctx, tracingConfig)
+	if err != nil {
+		slog.Error("Failed to init tracing", "error", err)
+	} else {
+		defer shutdownTracing(ctx)
+	}
+
+	// 后台汇总统计 供仪表盘查询
+	go service.RunStatsRollup(ctx, statsRollupInterval)
+
+	// 后台预热空闲提供商的连接 按 warmup 配置与提供商配置决定是否发送
+	go service.RunProviderWarmup(ctx, warmupCheckInterval)
+
+	// 请求日志批量写入 配置读取失败时逐条写入
+	logWriterConfig, err := service.LoadConfig[models.LogWriter](ctx, models.KeyLogWriter)
+	if err != nil {
+		slog.Error("Failed to load log writer config", "error", err)
+	}
+	drainLogs := service.StartLogWriter(logWriterConfig)
+
 	router := gin.Default()
 
 	router.Use(gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedPaths([]string{"/openai", "/anthropic", "/v1"})))
 
+	// 健康检查 无需鉴权
+	router.GET("/healthz", handler.Healthz)
+	router.GET("/readyz", handler.Readyz)
+
 	token := os.Getenv("TOKEN")
 
 	authOpenAI := middleware.AuthOpenAI(token)
 	authAnthropic := middleware.AuthAnthropic(token)
+	tracingMiddleware := middleware.Tracing()
 
-	openai := router.Group("/openai/v1", authOpenAI)
+	openai := router.Group("/openai/v1", tracingMiddleware, authOpenAI)
 	{
 		openai.GET("/models", handler.OpenAIModelsHandler)
+		openai.GET("/models/*id", handler.OpenAIModelHandler)
 		openai.POST("/chat/completions", handler.ChatCompletionsHandler)
+		openai.POST("/completions", handler.CompletionsHandler)
 		openai.POST("/responses", handler.ResponsesHandler)
+		openai.GET("/organization/usage/completions", handler.UsageCompletionsHandler)
 	}
 
-	anthropic := router.Group("/anthropic/v1", authAnthropic)
+	anthropic := router.Group("/anthropic/v1", tracingMiddleware, authAnthropic)
 	{
 		anthropic.GET("/models", handler.AnthropicModelsHandler)
+		anthropic.GET("/models/*id", handler.AnthropicModelHandler)
 		anthropic.POST("/messages", handler.Messages)
 		anthropic.POST("/messages/count_tokens", handler.CountTokens)
 	}
 
 	// 兼容性保留
-	v1 := router.Group("/v1")
+	v1 := router.Group("/v1", tracingMiddleware)
 	{
 		v1.GET("/models", authOpenAI, handler.OpenAIModelsHandler)
+		v1.GET("/models/*id", authOpenAI, handler.OpenAIModelHandler)
 		v1.POST("/chat/completions", authOpenAI, handler.ChatCompletionsHandler)
+		v1.POST("/completions", authOpenAI, handler.CompletionsHandler)
 		v1.POST("/responses", authOpenAI, handler.ResponsesHandler)
+		v1.GET("/organization/usage/completions", authOpenAI, handler.UsageCompletionsHandler)
 		v1.POST("/messages", authAnthropic, handler.Messages)
 		v1.POST("/messages/count_tokens", authAnthropic, handler.CountTokens)
 	}
@@ -78,31 +126,38 @@ func main() {
 		api.GET("/providers/models/:id", handler.GetProviderModels)
 		api.POST("/providers", handler.CreateProvider)
 		api.PUT("/providers/:id", handler.UpdateProvider)
+		api.POST("/providers/:id/clone", handler.CloneProvider)
 		api.DELETE("/providers/:id", handler.DeleteProvider)
 
 		// Provider key management
 		api.GET("/providers/:id/keys", handler.ListProviderKeys)
 		api.POST("/providers/:id/keys", handler.CreateProviderKey)
 		api.PUT("/providers/:id/keys/:keyId", handler.UpdateProviderKey)
+		api.PATCH("/providers/:id/keys/:keyId/status", handler.ToggleProviderKeyStatus)
 		api.DELETE("/providers/:id/keys/:keyId", handler.DeleteProviderKey)
 
 		// Model management
 		api.GET("/models", handler.GetModels)
 		api.POST("/models", handler.CreateModel)
 		api.PUT("/models/:id", handler.UpdateModel)
+		api.PATCH("/models/:id/status", handler.UpdateModelStatus)
 		api.DELETE("/models/:id", handler.DeleteModel)
 
 		// Model-provider association management
 		api.GET("/model-providers", handler.GetModelProviders)
 		api.GET("/model-providers/status", handler.GetModelProviderStatus)
+		api.GET("/model-providers/usages", handler.GetProviderModelUsages)
 		api.POST("/model-providers", handler.CreateModelProvider)
 		api.PUT("/model-providers/:id", handler.UpdateModelProvider)
+		api.POST("/model-providers/:id/clone", handler.CloneModelProvider)
+		api.POST("/model-providers/:id/transform/preview", handler.PreviewModelProviderTransform)
 		api.PATCH("/model-providers/:id/status", handler.UpdateModelProviderStatus)
 		api.DELETE("/model-providers/:id", handler.DeleteModelProvider)
 
 		// System status and monitoring
 		api.GET("/logs", handler.GetRequestLogs)
 		api.GET("/logs/:id/chat-io", handler.GetChatIO)
+		api.GET("/logs/:id/output/rendered", handler.GetRenderedOutput)
 		api.GET("/user-agents", handler.GetUserAgents)
 
 		// Auth key management
@@ -113,17 +168,78 @@ func main() {
 		api.PATCH("/auth-keys/:id/status", handler.ToggleAuthKeyStatus)
 		api.DELETE("/auth-keys/:id", handler.DeleteAuthKey)
 
+		// Config snapshot
+		api.GET("/config/export", handler.ExportConfig)
+		api.POST("/config/import", handler.ImportConfig)
+
 		// Config management
 		api.GET("/config/:key", handler.GetConfigByKey)
 		api.PUT("/config/:key", handler.UpdateConfigByKey)
 
+		// Audit logs
+		api.GET("/audit-logs", handler.GetAuditLogs)
+
+		// Response cache
+		api.GET("/cache/stats", handler.GetCacheStats)
+		api.GET("/cache/entries", handler.ListCacheEntries)
+
 		// Provider connectivity test
 		api.GET("/test/:id", handler.ProviderTestHandler)
 		api.GET("/test/react/:id", handler.TestReactHandler)
 		api.GET("/test/count_tokens", handler.TestCountTokens)
 	}
 	setwebui(router)
-	router.Run(":7070")
+
+	srv := &http.Server{Addr: ":7070", Handler: router}
+	signalCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Failed to serve", "error", err)
+			stop()
+		}
+	}()
+	<-signalCtx.Done()
+
+	// 停止接收新请求 等待进行中的请求与日志写入完成
+	shutdownCtx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to shutdown server", "error", err)
+	}
+	handler.DrainRevalidations(shutdownCtx)
+	drainLogs(shutdownCtx)
+}
+
+// shutdownTimeout 退出时等待进行中请求与日志写入的最长时间
+const shutdownTimeout = 30 * time.Second
+
+// statsRollupInterval 统计汇总任务的执行间隔
+const statsRollupInterval = 5 * time.Minute
+
+// warmupCheckInterval 检查提供商是否需要预热的间隔 实际预热间隔由配置决定
+const warmupCheckInterval = 10 * time.Second
+
+// backfillStats 重新汇总历史日志 用法: llmio backfill-stats [-from 2006-01-02]
+func backfillStats(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("backfill-stats", flag.ExitOnError)
+	fromFlag := flags.String("from", "", "起始日期 格式 2006-01-02 为空时从最早的日志开始")
+	flags.Parse(args)
+
+	var from time.Time
+	if *fromFlag != "" {
+		var err error
+		if from, err = time.ParseInLocation(time.DateOnly, *fromFlag, time.Local); err != nil {
+			slog.Error("Invalid -from date", "error", err)
+			os.Exit(1)
+		}
+	}
+	hours, err := service.BackfillStats(ctx, from, time.Now())
+	if err != nil {
+		slog.Error("Failed to backfill stats", "hours", hours, "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Backfilled stats", "hours", hours)
 }
 
 //go:embed webui/dist
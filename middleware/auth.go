@@ -69,6 +69,7 @@ func checkAuthKey(c *gin.Context, key string, adminToken string) {
 	// 如果系统中未配置Token 或者使用的是最高权限的token 则允许访问所有模型
 	if adminToken == "" || key == adminToken {
 		ctx = context.WithValue(ctx, consts.ContextKeyAllowAllModel, true)
+		ctx = context.WithValue(ctx, consts.ContextKeyExcludeProviders, true)
 		c.Request = c.Request.WithContext(ctx)
 		return
 	}
@@ -90,9 +91,33 @@ func checkAuthKey(c *gin.Context, key string, adminToken string) {
 		c.Abort()
 		return
 	}
+	// 开启防重放的 key 必须携带未使用过的 nonce
+	if authKey.ReplayProtection != nil && *authKey.ReplayProtection && !checkNonce(c, nonces, authKey.ID) {
+		return
+	}
 	allowAll := authKey.AllowAll != nil && *authKey.AllowAll
 	ctx = context.WithValue(ctx, consts.ContextKeyAuthKeyID, authKey.ID)
 	ctx = context.WithValue(ctx, consts.ContextKeyAllowAllModel, allowAll)
+	// 设置该 key 的内容审核开关 未设置时跟随全局配置
+	if authKey.Moderation != nil {
+		ctx = context.WithValue(ctx, consts.ContextKeyModeration, *authKey.Moderation)
+	}
+	// 禁用缓存的 key 不读取也不写入缓存
+	if authKey.NoCache != nil && *authKey.NoCache {
+		ctx = context.WithValue(ctx, consts.ContextKeyNoCache, true)
+	}
+	// 允许客户端按请求排除提供商
+	if authKey.ExcludeProviders != nil && *authKey.ExcludeProviders {
+		ctx = context.WithValue(ctx, consts.ContextKeyExcludeProviders, true)
+	}
+	// 模型别名 聊天接口在校验权限与选择提供商前改写请求的模型
+	if len(authKey.ModelAliases) > 0 {
+		ctx = context.WithValue(ctx, consts.ContextKeyModelAliases, authKey.ModelAliases)
+	}
+	// 费用预算 聊天接口按已用比例优先选择更便宜的提供商或拒绝请求
+	if authKey.Budget.Limit > 0 {
+		ctx = context.WithValue(ctx, consts.ContextKeyBudget, authKey.Budget)
+	}
 	// 如果不允许所有模型 则设置允许的模型列表
 	if !allowAll {
 		ctx = context.WithValue(ctx, consts.ContextKeyAllowModels, authKey.Models)
@@ -463,3 +463,31 @@ func TestCheckAuthKey_NilExpiry(t *testing.T) {
 
 	t.Log("✓ Nil expiry (never expires) key is accepted")
 }
+
+func TestCheckAuthKey_NoCache(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	gin.SetMode(gin.TestMode)
+	for _, authKey := range []models.AuthKey{
+		{Name: "Eval Project", Key: "no-cache-key", Status: boolPtr(true), AllowAll: boolPtr(true), NoCache: boolPtr(true)},
+		{Name: "Default Project", Key: "cache-key", Status: boolPtr(true), AllowAll: boolPtr(true)},
+	} {
+		if err := db.Create(&authKey).Error; err != nil {
+			t.Fatalf("failed to create test auth key: %v", err)
+		}
+	}
+
+	for key, want := range map[string]bool{"no-cache-key": true, "cache-key": false} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("POST", "/", nil)
+		checkAuthKey(c, key, "admin-token")
+		if c.IsAborted() {
+			t.Fatalf("%s: expected request to not be aborted", key)
+		}
+		noCache, _ := c.Request.Context().Value(consts.ContextKeyNoCache).(bool)
+		if noCache != want {
+			t.Errorf("%s: expected NoCache %v, got %v", key, want, noCache)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	headerNonce     = "X-LLMIO-Nonce"
+	headerTimestamp = "X-LLMIO-Timestamp"
+	// nonceWindow 时间戳允许的最大偏差 超出视为过期请求
+	nonceWindow = 5 * time.Minute
+	// maxNonceLength 限制单个 nonce 的长度 避免占用过多内存
+	maxNonceLength = 128
+	// maxNoncesPerKey 每个 key 在窗口内最多记录的 nonce 数量
+	maxNoncesPerKey = 10000
+)
+
+var (
+	errNonceReplayed = errors.New("nonce has already been used")
+	errNonceFull     = errors.New("too many requests within the nonce window")
+)
+
+type nonceEntry struct {
+	nonce     string
+	expiresAt time.Time
+}
+
+// keyNonces 单个 key 已使用的 nonce 按写入顺序排列 过期时间单调递增
+type keyNonces struct {
+	seen  map[string]struct{}
+	queue []nonceEntry
+}
+
+// nonceStore 按 auth key 隔离的已使用 nonce 集合 记录在 ttl 后淘汰
+type nonceStore struct {
+	mu       sync.Mutex
+	keys     map[uint]*keyNonces
+	ttl      time.Duration
+	capacity int
+	now      func() time.Time
+}
+
+func newNonceStore(ttl time.Duration, capacity int) *nonceStore {
+	return &nonceStore{
+		keys:     make(map[uint]*keyNonces),
+		ttl:      ttl,
+		capacity: capacity,
+		now:      time.Now,
+	}
+}
+
+// Use 记录 nonce 已在该 key 下使用 重复使用或容量已满时返回错误
+func (s *nonceStore) Use(keyID uint, nonce string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	entries, ok := s.keys[keyID]
+	if !ok {
+		entries = &keyNonces{seen: make(map[string]struct{})}
+		s.keys[keyID] = entries
+	}
+	// 淘汰已过期的记录
+	expired := 0
+	for expired < len(entries.queue) && !now.Before(entries.queue[expired].expiresAt) {
+		delete(entries.seen, entries.queue[expired].nonce)
+		expired++
+	}
+	entries.queue = entries.queue[expired:]
+
+	if _, ok := entries.seen[nonce]; ok {
+		return errNonceReplayed
+	}
+	// 已满时拒绝而不是淘汰未过期的记录 否则被淘汰的 nonce 可被重放
+	if len(entries.queue) >= s.capacity {
+		return errNonceFull
+	}
+	entries.seen[nonce] = struct{}{}
+	entries.queue = append(entries.queue, nonceEntry{nonce: nonce, expiresAt: now.Add(s.ttl)})
+	return nil
+}
+
+// nonces 全局 nonce 记录 时间戳可向前或向后偏差一个窗口 因此记录保留两个窗口
+var nonces = newNonceStore(2*nonceWindow, maxNoncesPerKey)
+
+// checkNonce 校验开启防重放的 key 携带的 nonce 与时间戳 校验失败时中止请求
+func checkNonce(c *gin.Context, store *nonceStore, keyID uint) bool {
+	nonce := c.GetHeader(headerNonce)
+	if nonce == "" || len(nonce) > maxNonceLength {
+		common.ErrorWithHttpStatus(c, http.StatusUnauthorized, http.StatusUnauthorized, headerNonce+" header is missing or invalid")
+		c.Abort()
+		return false
+	}
+	timestamp, err := strconv.ParseInt(c.GetHeader(headerTimestamp), 10, 64)
+	if err != nil {
+		common.ErrorWithHttpStatus(c, http.StatusUnauthorized, http.StatusUnauthorized, headerTimestamp+" header is missing or invalid")
+		c.Abort()
+		return false
+	}
+	skew := store.now().Sub(time.Unix(timestamp, 0))
+	if skew > nonceWindow || skew < -nonceWindow {
+		common.ErrorWithHttpStatus(c, http.StatusUnauthorized, http.StatusUnauthorized, "request timestamp is outside the allowed window")
+		c.Abort()
+		return false
+	}
+	if err := store.Use(keyID, nonce); err != nil {
+		status := http.StatusUnauthorized
+		if errors.Is(err, errNonceFull) {
+			status = http.StatusTooManyRequests
+		}
+		common.ErrorWithHttpStatus(c, status, status, err.Error())
+		c.Abort()
+		return false
+	}
+	return true
+}
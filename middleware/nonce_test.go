@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestNonceStore(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := newNonceStore(time.Minute, 2)
+	store.now = func() time.Time { return now }
+
+	if err := store.Use(1, "a"); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := store.Use(1, "a"); !errors.Is(err, errNonceReplayed) {
+		t.Fatalf("replay: expected errNonceReplayed, got %v", err)
+	}
+	// 不同 key 的 nonce 互不影响
+	if err := store.Use(2, "a"); err != nil {
+		t.Fatalf("other key: %v", err)
+	}
+	if err := store.Use(1, "b"); err != nil {
+		t.Fatalf("second nonce: %v", err)
+	}
+	// 容量已满时拒绝 不淘汰未过期的记录
+	if err := store.Use(1, "c"); !errors.Is(err, errNonceFull) {
+		t.Fatalf("full: expected errNonceFull, got %v", err)
+	}
+
+	// 过期后记录被淘汰 容量释放
+	now = now.Add(time.Minute)
+	if err := store.Use(1, "c"); err != nil {
+		t.Fatalf("after expiry: %v", err)
+	}
+	if got := len(store.keys[1].queue); got != 1 {
+		t.Errorf("queue length = %d, want 1", got)
+	}
+}
+
+func TestCheckAuthKey_ReplayProtection(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	authKey := models.AuthKey{
+		Name:             "Replay Protected",
+		Key:              "replay-key",
+		Status:           boolPtr(true),
+		AllowAll:         boolPtr(true),
+		ReplayProtection: boolPtr(true),
+	}
+	if err := db.Create(&authKey).Error; err != nil {
+		t.Fatalf("failed to create test auth key: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	nonce := "nonce-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	now := time.Now().Unix()
+	tests := []struct {
+		name      string
+		nonce     string
+		timestamp string
+		want      int
+	}{
+		{name: "fresh nonce", nonce: nonce, timestamp: strconv.FormatInt(now, 10), want: http.StatusOK},
+		{name: "replayed nonce", nonce: nonce, timestamp: strconv.FormatInt(now, 10), want: http.StatusUnauthorized},
+		{name: "stale timestamp", nonce: nonce + "-stale", timestamp: strconv.FormatInt(now-int64(nonceWindow/time.Second)-60, 10), want: http.StatusUnauthorized},
+		{name: "future timestamp", nonce: nonce + "-future", timestamp: strconv.FormatInt(now+int64(nonceWindow/time.Second)+60, 10), want: http.StatusUnauthorized},
+		{name: "missing nonce", timestamp: strconv.FormatInt(now, 10), want: http.StatusUnauthorized},
+		{name: "missing timestamp", nonce: nonce + "-no-ts", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			req := httptest.NewRequest("POST", "/", nil)
+			if tt.nonce != "" {
+				req.Header.Set(headerNonce, tt.nonce)
+			}
+			if tt.timestamp != "" {
+				req.Header.Set(headerTimestamp, tt.timestamp)
+			}
+			c.Request = req
+
+			checkAuthKey(c, "replay-key", "admin-token")
+
+			if c.IsAborted() != (tt.want != http.StatusOK) {
+				t.Errorf("aborted = %v, want status %d", c.IsAborted(), tt.want)
+			}
+			if c.Writer.Status() != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, c.Writer.Status())
+			}
+		})
+	}
+
+	// 未开启防重放的 key 不要求 nonce
+	if err := db.Model(&authKey).Update("replay_protection", false).Error; err != nil {
+		t.Fatal(err)
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/", nil)
+	checkAuthKey(c, "replay-key", "admin-token")
+	if c.IsAborted() {
+		t.Error("expected request without nonce to pass when replay protection is off")
+	}
+}
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// 审计记录的操作类型
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+	AuditActionImport = "import"
+)
+
+// 审计记录的对象类型
+const (
+	AuditTargetProvider      = "provider"
+	AuditTargetProviderKey   = "provider_key"
+	AuditTargetModel         = "model"
+	AuditTargetModelProvider = "model_provider"
+	AuditTargetAuthKey       = "auth_key"
+	AuditTargetConfig        = "config"
+)
+
+// AuditLog 管理接口的变更记录 只追加不修改
+type AuditLog struct {
+	ID         uint      `gorm:"primarykey"`
+	CreatedAt  time.Time `gorm:"index"`
+	Actor      string    // 操作者 取自 X-LLMIO-Admin 请求头 未设置时为 admin
+	IP         string    // 客户端地址
+	Action     string
+	TargetType string `gorm:"index:idx_audit_target,priority:1"`
+	TargetID   string `gorm:"index:idx_audit_target,priority:2"` // 配置为配置的 key
+
+	// 变化的字段 密钥已脱敏
+	Diff map[string]AuditChange `gorm:"serializer:json"`
+}
+
+// AuditChange 字段变更前后的值 创建时 Before 为空 删除时 After 为空
+type AuditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
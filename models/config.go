@@ -10,6 +10,22 @@ type Config struct {
 
 const (
 	KeyAnthropicCountTokens = "anthropic_count_tokens"
+	KeyModeration           = "moderation"
+	KeyScanner              = "scanner"
+	KeyMaintenance          = "maintenance"
+	KeyTracing              = "tracing"
+	KeyRetryLog             = "retry_log"
+	KeyAnomaly              = "anomaly"
+	KeyLogWriter            = "log_writer"
+	KeyCooldown             = "cooldown"
+	KeyCache                = "cache"
+	KeyRequestDedup         = "request_dedup"
+	KeyFormatDetection      = "format_detection"
+	KeyModelDiscovery       = "model_discovery"
+	KeyStreamFlush          = "stream_flush"
+	KeySlowStream           = "slow_stream"
+	KeyWarmup               = "warmup"
+	KeyStatsRollup          = "stats_rollup" // 汇总任务进度 由后台任务维护
 )
 
 type AnthropicCountTokens struct {
@@ -17,3 +33,118 @@ type AnthropicCountTokens struct {
 	APIKey  string `json:"api_key"`
 	Version string `json:"version"`
 }
+
+// Moderation 转发前内容审核配置
+type Moderation struct {
+	Enabled  bool     `json:"enabled"`  // 全局是否启用
+	Endpoint string   `json:"endpoint"` // OpenAI moderations 兼容接口地址 为空时仅使用关键词策略
+	APIKey   string   `json:"api_key"`
+	Model    string   `json:"model"`
+	Keywords []string `json:"keywords"`  // 本地关键词策略
+	TimeOut  int      `json:"time_out"`  // 审核超时 单位秒
+	FailOpen bool     `json:"fail_open"` // 审核异常时是否放行
+}
+
+// Scanner 响应处理的全局配置
+type Scanner struct {
+	MaxBuffer int `json:"max_buffer"` // 响应单行最大缓冲 单位MB 负数不限制
+}
+
+// Maintenance 维护模式配置 开启后所有对话接口直接返回 503
+type Maintenance struct {
+	Enabled    bool   `json:"enabled"`
+	Message    string `json:"message"`     // 返回给客户端的提示信息
+	RetryAfter int    `json:"retry_after"` // Retry-After 响应头 单位秒 0不设置
+}
+
+// 重试错误日志的记录方式
+const (
+	RetryLogModeAll       = "all"       // 每次失败的尝试各记录一条 默认
+	RetryLogModeAggregate = "aggregate" // 窗口内相同提供商 模型与错误的失败合并为一条并计数
+	RetryLogModeFinal     = "final"     // 每个请求只记录最后一次失败 并附带全部失败的汇总
+)
+
+// RetryLog 重试错误日志配置 成功的请求日志不受影响
+type RetryLog struct {
+	Mode   string `json:"mode"`   // all aggregate final 为空时同 all
+	Window int    `json:"window"` // aggregate 模式的合并窗口 单位秒 默认60
+}
+
+// LogWriter 请求日志批量写入配置 修改后重启生效
+type LogWriter struct {
+	BatchSize     int `json:"batch_size"`     // 单批最多写入的日志数 不大于1时逐条写入
+	FlushInterval int `json:"flush_interval"` // 未满一批时的最长等待 单位毫秒 默认1000
+}
+
+// Cooldown 冷却退避配置 key 与 provider 未设置的字段使用全局值
+type Cooldown struct {
+	CooldownBackoff
+	Key      *CooldownBackoff `json:"key"`      // key 级错误 如 429 401
+	Provider *CooldownBackoff `json:"provider"` // 渠道级错误 如 5xx
+}
+
+// CooldownBackoff 指数退避参数 为 0 时使用默认值
+type CooldownBackoff struct {
+	Base       int     `json:"base"`       // 首次冷却时间 单位毫秒 默认1000
+	Multiplier float64 `json:"multiplier"` // 每次连续失败的倍数 默认2
+	Max        int     `json:"max"`        // 冷却时间上限 单位毫秒 默认30分钟
+	MaxSteps   int     `json:"max_steps"`  // 退避次数上限 之后保持该次的冷却时间 默认20
+}
+
+// Cache 响应缓存的有效期配置 修改后对新写入的缓存生效
+type Cache struct {
+	TTL                  int `json:"ttl"`                    // 新鲜期 单位秒 默认300 期间直接返回缓存
+	StaleWhileRevalidate int `json:"stale_while_revalidate"` // 新鲜期后的宽限期 单位秒 期间返回旧结果并在后台刷新 0关闭
+}
+
+// RequestDedup 按客户端 X-Request-ID 对重试请求去重
+type RequestDedup struct {
+	Enabled bool `json:"enabled"`
+	Window  int  `json:"window"` // 同一请求 id 视为重复的时长 自原请求开始计算 单位秒 默认60
+}
+
+// FormatDetection 响应格式识别配置 格式与接口风格不一致时总会记录在日志中
+type FormatDetection struct {
+	Switch bool `json:"switch"` // 改用识别出的格式对应的处理器统计用量
+}
+
+// ModelDiscovery 提供商模型列表的缓存配置
+type ModelDiscovery struct {
+	TTL int `json:"ttl"` // 缓存有效期 单位秒 默认600
+}
+
+// StreamFlush 流式响应的刷新间隔
+type StreamFlush struct {
+	Interval int `json:"interval"` // 单位毫秒 0 表示每个事件写完后立即刷新 大于 0 时合并同一间隔内的事件
+}
+
+// SlowStream 流式响应的慢速检测 滑动窗口内的输出速度低于阈值时在日志中标记
+type SlowStream struct {
+	MinTps   float64 `json:"min_tps"`  // 最低输出速度 单位 token/秒 0不检查
+	Window   int     `json:"window"`   // 滑动窗口 单位毫秒 默认5000
+	Cooldown bool    `json:"cooldown"` // 标记为慢速时按提供商错误触发冷却
+}
+
+// Warmup 提供商连接预热 定期向空闲的提供商发送不携带密钥的 HEAD 请求 保持连接与 TLS 会话
+// 提供商配置中的 warmup 可单独开启、关闭或调整间隔
+type Warmup struct {
+	Enabled  bool `json:"enabled"`  // 是否预热所有提供商
+	Interval int  `json:"interval"` // 空闲多久后预热 单位秒 默认60
+}
+
+// Anomaly 异常请求标记配置 仅在日志中标记 不拦截请求 阈值为 0 时不检查该项
+type Anomaly struct {
+	Enabled        bool  `json:"enabled"`
+	MaxPromptChars int   `json:"max_prompt_chars"` // 提示词文本字符数
+	MaxTools       int   `json:"max_tools"`        // 工具定义数量
+	MaxTokens      int64 `json:"max_tokens"`       // 请求的最大输出 token
+}
+
+// Tracing OpenTelemetry 链路追踪配置 启动时读取 修改后重启生效
+type Tracing struct {
+	Enabled     bool              `json:"enabled"`
+	Endpoint    string            `json:"endpoint"`     // OTLP HTTP 接收地址 如 http://localhost:4318 为空时使用 OTEL_EXPORTER_OTLP_* 环境变量
+	Headers     map[string]string `json:"headers"`      // 导出时附加的请求头 如鉴权信息
+	ServiceName string            `json:"service_name"` // 为空时使用 llmio
+	SampleRatio float64           `json:"sample_ratio"` // 采样比例 0~1 不设置时全部采样
+}
@@ -2,8 +2,11 @@ package models
 
 import (
 	"context"
+	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/atopos31/llmio/consts"
 	"github.com/glebarez/sqlite"
@@ -30,9 +33,22 @@ func Init(ctx context.Context, path string) {
 		&Config{},
 		&AuthKey{},
 		&ProviderKey{},
+		&StatsHourly{},
+		&AuditLog{},
 	); err != nil {
 		panic(err)
 	}
+	// 合并重复的关联后建立唯一索引 软删除的记录不参与
+	if err := mergeDuplicateModelProviders(ctx, DB, os.Getenv(EnvMergeDuplicateAssociations) == "true"); err != nil {
+		panic(err)
+	}
+	if err := DB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_model_provider_unique ON model_with_providers(model_id, provider_id, provider_model) WHERE deleted_at IS NULL").Error; err != nil {
+		panic(err)
+	}
+	// 按时间范围汇总日志
+	if err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_chat_logs_created_at ON chat_logs(created_at)").Error; err != nil {
+		panic(err)
+	}
 	// 兼容性考虑
 	if _, err := gorm.G[ModelWithProvider](DB).Where("status IS NULL").Update(ctx, "status", true); err != nil {
 		panic(err)
@@ -47,6 +63,54 @@ func Init(ctx context.Context, path string) {
 	}
 }
 
+// EnvMergeDuplicateAssociations 为 true 时启动时自动合并重复的关联 否则发现重复关联时拒绝启动
+const EnvMergeDuplicateAssociations = "MERGE_DUPLICATE_ASSOCIATIONS"
+
+// mergeDuplicateModelProviders 检查相同模型 提供商与提供商模型的重复关联
+// merge 为 false 时返回列出重复关联的错误 为 true 时每组优先保留启用的关联 其次保留最早创建的一条
+func mergeDuplicateModelProviders(ctx context.Context, db *gorm.DB, merge bool) error {
+	var groups []struct {
+		ModelID       uint
+		ProviderID    uint
+		ProviderModel string
+	}
+	if err := db.WithContext(ctx).Model(&ModelWithProvider{}).
+		Select("model_id, provider_id, provider_model").
+		Group("model_id, provider_id, provider_model").
+		Having("COUNT(*) > 1").
+		Scan(&groups).Error; err != nil {
+		return err
+	}
+	var found []string
+	for _, g := range groups {
+		// 状态为空的旧数据视为启用
+		rows, err := gorm.G[ModelWithProvider](db).
+			Where("model_id = ? AND provider_id = ? AND provider_model = ?", g.ModelID, g.ProviderID, g.ProviderModel).
+			Order("COALESCE(status, true) DESC, id").
+			Find(ctx)
+		if err != nil {
+			return err
+		}
+		ids := make([]uint, 0, len(rows))
+		for _, row := range rows {
+			ids = append(ids, row.ID)
+		}
+		if !merge {
+			found = append(found, fmt.Sprintf("model_id=%d provider_id=%d provider_model=%q ids=%v", g.ModelID, g.ProviderID, g.ProviderModel, ids))
+			continue
+		}
+		if _, err := gorm.G[ModelWithProvider](db).Where("id IN ?", ids[1:]).Delete(ctx); err != nil {
+			return err
+		}
+		slog.Warn("merged duplicate model provider associations", "model_id", g.ModelID, "provider_id", g.ProviderID, "provider_model", g.ProviderModel, "kept", ids[0], "removed", ids[1:])
+	}
+	if len(found) > 0 {
+		return fmt.Errorf("duplicate model provider associations found, remove them or set %s=true to merge on startup: %s",
+			EnvMergeDuplicateAssociations, strings.Join(found, "; "))
+	}
+	return nil
+}
+
 func ensureDBFile(path string) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return err
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestMergeDuplicateModelProviders(t *testing.T) {
+	disabled := false
+	enabled := true
+	tests := []struct {
+		name    string
+		merge   bool
+		wantErr bool
+		wantIDs []uint
+	}{
+		// 默认只检测 不删除任何关联
+		{"detect only", false, true, []uint{1, 2, 3, 4, 5, 6, 7}},
+		// 优先保留启用的关联 其次保留最早创建的
+		{"merge", true, false, []uint{2, 3, 5, 6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+			if err != nil {
+				t.Fatalf("failed to open test database: %v", err)
+			}
+			if err := db.AutoMigrate(&ModelWithProvider{}); err != nil {
+				t.Fatalf("failed to migrate database: %v", err)
+			}
+			for _, mp := range []ModelWithProvider{
+				{ModelID: 1, ProviderID: 1, ProviderModel: "gpt-4o", Weight: 3, Status: &disabled},
+				{ModelID: 1, ProviderID: 1, ProviderModel: "gpt-4o", Weight: 1, Status: &enabled},
+				{ModelID: 1, ProviderID: 1, ProviderModel: "gpt-4o-mini", Weight: 1},
+				{ModelID: 1, ProviderID: 1, ProviderModel: "gpt-4o", Weight: 1, Status: &enabled},
+				{ModelID: 2, ProviderID: 1, ProviderModel: "gpt-4o", Weight: 1},
+				{ModelID: 3, ProviderID: 1, ProviderModel: "gpt-4o", Weight: 1, Status: &enabled},
+				{ModelID: 3, ProviderID: 1, ProviderModel: "gpt-4o", Weight: 1, Status: &enabled},
+			} {
+				if err := db.Create(&mp).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = mergeDuplicateModelProviders(context.Background(), db, tt.merge)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "ids=[2 4 1]") || !strings.Contains(err.Error(), "ids=[6 7]") {
+					t.Fatalf("expected error listing duplicates, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("mergeDuplicateModelProviders() error = %v", err)
+			}
+			var remaining []ModelWithProvider
+			if err := db.Order("id").Find(&remaining).Error; err != nil {
+				t.Fatal(err)
+			}
+			var ids []uint
+			for _, mp := range remaining {
+				ids = append(ids, mp.ID)
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("remaining ids = %v, want %v", ids, tt.wantIDs)
+			}
+			if !tt.merge {
+				return
+			}
+			// 合并后可以建立唯一索引
+			if err := db.Exec("CREATE UNIQUE INDEX idx_model_provider_unique ON model_with_providers(model_id, provider_id, provider_model) WHERE deleted_at IS NULL").Error; err != nil {
+				t.Errorf("unique index after merge: %v", err)
+			}
+		})
+	}
+}
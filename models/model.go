@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"net/http"
 	"time"
 
@@ -23,12 +24,120 @@ type AnthropicConfig struct {
 
 type Model struct {
 	gorm.Model
-	Name     string
-	Remark   string
-	MaxRetry int    // 重试次数限制
-	TimeOut  int    // 超时时间 单位秒
-	IOLog    *bool  // 是否记录IO
-	Strategy string // 负载均衡策略 默认 lottery
+	Name      string
+	Remark    string
+	MaxRetry  int    // 重试次数限制
+	TimeOut   int    // 超时时间 单位秒
+	IOLog     *bool  // 是否记录IO
+	Strategy  string // 负载均衡策略 默认 lottery
+	MaxBuffer int    // 响应单行最大缓冲 单位MB 0使用全局配置 负数不限制
+	// 流式响应在首个有效内容前失败时切换提供商重试
+	StreamFailover *bool
+	// 流式响应空闲超时 单位秒 0不限制
+	StreamIdleTimeout int
+	// 流式响应首个有效内容超时 单位秒 0不限制 超时后切换提供商
+	FirstChunkTimeout int
+	// 流式响应开始后两个有效内容之间的最长间隔 单位秒 0不限制 心跳不计入 超过后中止并计为提供商错误
+	StreamStallTimeout int
+	// 空闲超时时向客户端补发结束事件 而非直接断开
+	GracefulTimeout *bool
+	// 流式事件附带 id 客户端断线后可携带 Last-Event-ID 重连续传
+	StreamResume *bool
+	// 粘性会话保持时间 单位秒 0不启用 携带相同 X-LLMIO-Session-ID 的请求优先使用同一关联
+	StickyTTL int
+	// 直通模式 响应直接复制给客户端 不解析用量 不记录IO 不缓存 仅在出错时更新日志状态
+	Passthrough *bool
+	// 是否启用 为空视为启用 停用时保留各关联的状态
+	Status *bool
+	// 缓存策略 never non_stream always 为空时仅缓存非流式请求
+	CachePolicy string
+	// 按请求的采样参数限制缓存 随机性高的请求不缓存或缩短有效期
+	CacheSampling CacheSampling `gorm:"serializer:json"`
+	// 客户端请求参数的取值范围
+	ParamPolicy ParamPolicy `gorm:"serializer:json"`
+	// 消息条数与估算 token 数的上限 防止超出提供商的上下文窗口
+	ContextPolicy ContextPolicy `gorm:"serializer:json"`
+	// 模型能力信息 在模型列表的 llmio 字段中返回
+	Metadata ModelMetadata `gorm:"serializer:json"`
+	// 可用(未冷却)提供商的最低数量 不足时告警 0或1不检查
+	MinHealthyProviders int
+	// 可用提供商不足时拒绝请求 而非继续使用剩余的提供商
+	RefuseDegraded *bool
+	// 所有提供商均失败时返回的降级响应 按接口风格配置 未配置的风格仍返回错误
+	Fallback ModelFallback `gorm:"serializer:json"`
+	// 同时转发的请求上限 0不限制 超出后按 auth key 公平排队
+	MaxConcurrency int
+	// 排队等待上限 单位秒 0使用默认值30秒
+	QueueTimeout int
+}
+
+// ModelFallback 接口风格到非流式响应 JSON 的映射 流式请求转为最小 SSE 流
+type ModelFallback map[string]json.RawMessage
+
+// ModelMetadata 模型能力信息 供客户端展示与限制请求 未设置的项不返回
+type ModelMetadata struct {
+	ContextLength  int   `json:"context_length,omitempty"` // 上下文长度
+	MaxOutput      int   `json:"max_output,omitempty"`     // 最大输出 token 数
+	SupportsVision *bool `json:"supports_vision,omitempty"`
+	SupportsTools  *bool `json:"supports_tools,omitempty"`
+}
+
+// IsZero 未设置任何能力信息
+func (m ModelMetadata) IsZero() bool {
+	return m == ModelMetadata{}
+}
+
+// 高随机性请求的缓存处理方式
+const (
+	CacheSamplingSkip   = "skip"   // 不缓存 默认
+	CacheSamplingReduce = "reduce" // 缓存但缩短有效期
+	CacheSamplingOff    = "off"    // 不按采样参数区分
+)
+
+// CacheSampling 按 temperature 与 top_p 判断请求的随机性 重放高随机性请求的输出没有意义
+// 请求未传入 temperature 时按上游的默认值判断
+type CacheSampling struct {
+	Mode               string   `json:"mode"`                // skip reduce off 为空时同 skip
+	MaxTemperature     *float64 `json:"max_temperature"`     // temperature 高于该值视为高随机性 为空时使用默认值 0.5
+	MaxTopP            *float64 `json:"max_top_p"`           // top_p 不高于该值时即使 temperature 较高也视为确定性请求 为空时不使用
+	ReducedTTL         int      `json:"reduced_ttl"`         // reduce 模式下的缓存有效期 单位秒 0使用默认值60秒
+	DefaultTemperature *float64 `json:"default_temperature"` // 请求未传入 temperature 时假定的值 为空时使用 OpenAI 与 Anthropic 的默认值 1
+}
+
+// 请求参数超出范围时的处理方式
+const (
+	ParamPolicyClamp  = "clamp"  // 修正为边界值后转发 默认
+	ParamPolicyReject = "reject" // 拒绝请求
+)
+
+// ParamPolicy 限制客户端传入的请求参数 未传入的参数不受影响
+type ParamPolicy struct {
+	Mode   string                `json:"mode"`   // clamp reject 为空时同 clamp
+	Bounds map[string]ParamBound `json:"bounds"` // 键为请求体中的 JSON 路径 如 temperature max_tokens
+}
+
+// 上下文策略模式
+const (
+	ContextPolicyTruncate = "truncate" // 从最早的消息开始丢弃 保留系统消息与最后一轮用户消息 默认
+	ContextPolicyReject   = "reject"   // 拒绝请求
+)
+
+// ContextPolicy 限制请求的上下文规模 为 0 的上限不检查
+type ContextPolicy struct {
+	Mode        string `json:"mode"`         // truncate reject 为空时同 truncate
+	MaxMessages int    `json:"max_messages"` // 消息条数上限 系统提示词单独传入的风格不计入
+	MaxTokens   int    `json:"max_tokens"`   // 按文本估算的提示词 token 上限
+}
+
+// ParamBound 参数的取值范围 为空表示不限制
+type ParamBound struct {
+	Min *float64 `json:"min"`
+	Max *float64 `json:"max"`
+}
+
+// Enabled 模型是否启用
+func (m Model) Enabled() bool {
+	return m.Status == nil || *m.Status
 }
 
 type ModelWithProvider struct {
@@ -39,14 +148,49 @@ type ModelWithProvider struct {
 	ToolCall              *bool             // 能否接受带有工具调用的请求
 	StructuredOutput      *bool             // 能否接受带有结构化输出的请求
 	Image                 *bool             // 能否接受带有图片的请求(视觉)
+	Completion            *bool             // 能否接受 legacy completions(FIM) 请求
+	Audio                 *bool             // 能否接受音频输入或要求音频输出的请求
 	WithHeader            *bool             // 是否透传header
+	NonStream             *bool             // 上游不支持流式 流式请求将降级为非流式后合成SSE返回
 	Status                *bool             // 是否启用
 	CustomerHeaders       map[string]string `gorm:"serializer:json"` // 自定义headers
+	ForwardHeaders        []string          `gorm:"serializer:json"` // 透传的客户端 header 白名单 支持 X-Stainless-* 前缀匹配 WithHeader 开启时全部透传
 	Weight                int               `gorm:"default:1"`
+	TimeOut               int               // 超时时间覆盖 单位秒 为0时使用模型配置
+	Tier                  int               // 优先级层级 数值越小越优先 同层全部不可用时才降级到下一层
+	ResponseRules         []ResponseRule    `gorm:"serializer:json"` // 返回客户端前对响应的改写规则
+	TransformStream       *bool             // 是否对流式响应的每个 chunk 应用改写规则
+	Shadow                *bool             // 影子关联 不参与正常选择 只接收请求副本 响应仅记录日志
 	KeyCooldownUntil      *time.Time        // key级冷却截止时间
 	KeyCooldownStep       int               // key级退避次数
 	ProviderCooldownUntil *time.Time        // 渠道冷却截止时间
 	ProviderCooldownStep  int               // 渠道退避次数
+
+	// 每百万 token 的价格 用于计算请求费用与按预算降级 未设置时视为免费
+	InputPrice  float64
+	OutputPrice float64
+}
+
+// Cost 按用量与价格计算的费用
+func (mp ModelWithProvider) Cost(usage Usage) float64 {
+	return (float64(usage.PromptTokens)*mp.InputPrice + float64(usage.CompletionTokens)*mp.OutputPrice) / 1e6
+}
+
+// 响应改写规则类型
+const (
+	ResponseRuleRename     = "rename"      // 将 Path 字段移动到 To
+	ResponseRuleSet        = "set"         // 将 Path 设置为 Value 表示的 JSON 值
+	ResponseRuleDelete     = "delete"      // 删除 Path 字段
+	ResponseRuleTrimPrefix = "trim_prefix" // 去除 Path 字符串字段的 Value 前缀
+	ResponseRuleHeader     = "header"      // 设置响应头 Path 为头名称
+)
+
+// ResponseRule 响应改写规则 Path 与 To 使用 gjson 路径语法
+type ResponseRule struct {
+	Type  string `json:"type"`
+	Path  string `json:"path"`
+	To    string `json:"to,omitempty"`
+	Value string `json:"value,omitempty"`
 }
 
 type ChatLog struct {
@@ -54,29 +198,65 @@ type ChatLog struct {
 	Name          string `gorm:"index"`
 	ProviderModel string `gorm:"index"`
 	ProviderName  string `gorm:"index"`
-	Status        string `gorm:"index"` // error or success
+	Status        string `gorm:"index"` // error, success, blocked or truncated
 	Style         string // 类型
 	UserAgent     string `gorm:"index"` // 用户代理
 	RemoteIP      string // 访问ip
 	AuthKeyID     uint   `gorm:"index"` // 使用的AuthKey ID
 	ProviderKeyID uint   `gorm:"index"` // 使用的ProviderKey ID
+	EndUser       string `gorm:"index"` // 终端用户标识 (user / metadata.user_id)
 	ChatIO        bool   // 是否开启IO记录
 
+	Seed              *int64 // 请求中的 seed 未设置时为空
+	SystemFingerprint string // 上游返回的 system_fingerprint 用于校验可复现性
+	FormatWarning     string // 成功响应缺少预期的结构 上游格式可能已变化 响应仍已转发
+	FormatMismatch    string // 从首个事件识别出的响应格式 与接口风格不一致时记录 多为关联的提供商类型配置错误
+	ExcludedProviders string // 客户端通过请求头排除的提供商 逗号分隔
+	Stalled           bool   // 流式响应中途停滞超过间隔上限被中止
+	SlowStream        bool   // 流式响应的滑动窗口速度低于慢速检测阈值
+	Fallback          bool   // 所有提供商失败后返回了模型配置的降级响应
+	RequestedModel    string // 按 auth key 模型别名改写前客户端请求的模型 未改写时为空
+	ServedModel       string // 上游响应中的模型 与请求的提供商模型不同时记录
+	ModelDrift        bool   // 实际服务的模型不是请求模型的日期或版本快照 可能被上游静默替换
+	BudgetShed        string // 预算接近或达到上限时的降级 prefer_cheaper 或 cheapest 未降级时为空
+	TruncatedMessages int    // 超出模型上下文策略时丢弃的最早消息条数
+
 	Error          string        // if status is error, this field will be set
+	FailureReason  string        `gorm:"index"` // 失败原因归类 见 FailureReason* 成功或无法归类时为空
 	Retry          int           // 重试次数
+	Tier           int           // 实际服务的提供商层级
+	ClampedParams  string        // 被参数策略修正的参数 逗号分隔
+	Anomaly        string        `gorm:"index"` // 异常请求原因 逗号分隔 为空表示正常
+	ThinkingBudget int64         // 请求的思考预算 OpenAI 请求按 reasoning_effort 折算
+	SessionID      string        `gorm:"index"` // 粘性会话标识
+	StickyHit      bool          // 是否命中粘性会话绑定的提供商
+	Shadow         bool          `gorm:"index"` // 影子流量 响应未返回客户端 不计入客户端用量
+	ErrorCount     int           // 合并记录的失败次数 未合并时为 0
 	ProxyTime      time.Duration // 代理耗时
+	QueueTime      time.Duration // 等待模型并发名额的时间
 	FirstChunkTime time.Duration // 首个chunk耗时
 	ChunkTime      time.Duration // chunk耗时
+	Cost           float64       // 按关联价格计算的费用
 	Tps            float64
-	Size           int // 响应大小 字节
+	MinTps         float64 // 流式响应滑动窗口内的最低输出速度 未开启慢速检测或流过短时为 0
+	Size           int     // 响应大小 字节
 
 	// 缓存相关字段
-	Cached         bool  `gorm:"index;default:false"` // 是否来源于缓存命中
-	CachedFromLogID *uint `gorm:"index"`              // 指向最初生成缓存的日志ID
+	Cached          bool  `gorm:"index;default:false"` // 是否来源于缓存命中
+	CachedFromLogID *uint `gorm:"index"`               // 指向最初生成缓存的日志ID
 
 	Usage
 }
 
+// 失败日志的原因归类 统计提供商成功率时可排除客户端中止与客户端错误
+const (
+	FailureReasonClientAbort   = "client_abort"   // 客户端断开或取消请求
+	FailureReasonClientError   = "client_error"   // 请求本身有误 如 400
+	FailureReasonProviderError = "provider_error" // 提供商故障 如 5xx 或连接失败
+	FailureReasonKeyError      = "key_error"      // Key 限流或失效 如 429 401
+	FailureReasonTimeout       = "timeout"        // 响应头、首包、空闲或停滞超时
+)
+
 func (l ChatLog) WithError(err error) ChatLog {
 	l.Error = err.Error()
 	l.Status = "error"
@@ -88,6 +268,13 @@ type Usage struct {
 	CompletionTokens    int64               `json:"completion_tokens"`
 	TotalTokens         int64               `json:"total_tokens"`
 	PromptTokensDetails PromptTokensDetails `json:"prompt_tokens_details" gorm:"serializer:json"`
+	// 推理模型的输出用量明细 非推理模型为空
+	CompletionTokensDetails CompletionTokensDetails `json:"completion_tokens_details" gorm:"serializer:json"`
+}
+
+type CompletionTokensDetails struct {
+	ReasoningTokens int64 `json:"reasoning_tokens"` // 思考用量 已计入 CompletionTokens
+	AudioTokens     int64 `json:"audio_tokens"`     // 输出音频用量 已计入 CompletionTokens
 }
 
 type PromptTokensDetails struct {
@@ -123,4 +310,34 @@ type AuthKey struct {
 	ExpiresAt  *time.Time // nil=永不过期，有值=具体过期时间
 	UsageCount int64      // 使用次数统计
 	LastUsedAt *time.Time // 最后使用时间
+	Moderation *bool      // 是否启用内容审核 nil=跟随全局配置
+	// 是否要求请求携带一次性 nonce 与时间戳 防止请求被重放
+	ReplayProtection *bool
+	NoCache          *bool // 是否禁用响应缓存 开启后既不读取也不写入缓存
+	ExcludeProviders *bool // 是否允许通过 X-LLMIO-Exclude-Providers 请求头排除提供商
+	// 模型别名 请求的模型命中别名时改写为对应的模型 仅对该 key 生效
+	ModelAliases map[string]string `gorm:"serializer:json"`
+	// 费用预算 接近上限时优先选择更便宜的提供商
+	Budget BudgetPolicy `gorm:"serializer:json"`
+}
+
+// 预算周期
+const (
+	BudgetPeriodTotal   = "total"   // 累计 默认
+	BudgetPeriodDaily   = "daily"   // 每天零点重置
+	BudgetPeriodMonthly = "monthly" // 每月一日重置
+)
+
+// 达到预算上限后的行为
+const (
+	BudgetExhaustedBlock    = "block"    // 拒绝请求 默认
+	BudgetExhaustedCheapest = "cheapest" // 只使用最便宜的提供商
+)
+
+// BudgetPolicy auth key 的费用预算 费用按关联价格计算 Limit 为 0 时不限制
+type BudgetPolicy struct {
+	Limit     float64 `json:"limit"`      // 周期内的费用上限
+	Period    string  `json:"period"`     // total daily monthly 为空同 total
+	ShedRatio float64 `json:"shed_ratio"` // 费用达到上限的该比例后优先选择更便宜的提供商 为 0 时使用 0.8
+	Exhausted string  `json:"exhausted"`  // block cheapest 为空同 block
 }
@@ -9,13 +9,24 @@ import (
 // ProviderKey Key 池管理
 type ProviderKey struct {
 	gorm.Model
-	ProviderID    uint       `gorm:"not null;index:idx_provider_key,priority:1"`
-	Key           string     `gorm:"type:text;not null;index:idx_provider_key,priority:2"` // API Key
+	ProviderID    uint   `gorm:"not null;index:idx_provider_key,priority:1"`
+	Key           string `gorm:"type:text;not null;index:idx_provider_key,priority:2"` // API Key
 	Remark        string
 	Status        bool       `gorm:"not null;default:true;index"` // 是否启用
-	CooldownUntil *time.Time `gorm:"index"` // Key 级冷却截止时间
+	CooldownUntil *time.Time `gorm:"index"`                       // Key 级冷却截止时间
 	CooldownStep  int
 	SuccessCount  int64      `gorm:"not null;default:0"` // 成功次数
 	FailCount     int64      `gorm:"not null;default:0"` // 失败次数
-	LastUsedAt    *time.Time `gorm:"index"` // 最后使用时间
-}
\ No newline at end of file
+	LastUsedAt    *time.Time `gorm:"index"`              // 最后使用时间
+
+	// 加权选择 任一 Key 配置了权重或预算时按比例随机选择
+	Weight       int        `gorm:"not null;default:1"` // 选择权重
+	Budget       int        `gorm:"not null;default:0"` // 预算上限 单位请求数 0不限
+	BudgetRefill int        `gorm:"not null;default:0"` // 每分钟补充的预算
+	Remaining    float64    // 剩余预算估算 每次使用减一
+	RemainingAt  *time.Time // 剩余预算估算的更新时间 为空表示预算充足
+
+	// 有效期 用于定期轮换的 Key 有效期外的 Key 与冷却中的 Key 一样跳过 为空不限
+	ValidFrom  *time.Time
+	ValidUntil *time.Time `gorm:"index"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// StatsHourly 按模型 提供商与小时汇总的请求统计 由后台任务从 ChatLog 生成
+type StatsHourly struct {
+	ID            uint      `gorm:"primarykey"`
+	Hour          time.Time `gorm:"uniqueIndex:idx_stats_hourly,priority:1"` // 小时起点
+	Name          string    `gorm:"uniqueIndex:idx_stats_hourly,priority:2"`
+	ProviderName  string    `gorm:"uniqueIndex:idx_stats_hourly,priority:3"`
+	ProviderModel string    `gorm:"uniqueIndex:idx_stats_hourly,priority:4"`
+
+	Reqs    int64 // 日志条数
+	Success int64 // 成功条数
+	Cached  int64 // 缓存命中条数
+
+	PromptTokens     int64
+	CompletionTokens int64
+	TotalTokens      int64
+	ReasoningTokens  int64
+
+	// 成功请求的耗时总和
+	ProxyTime      time.Duration
+	FirstChunkTime time.Duration
+	ChunkTime      time.Duration
+
+	// 成功请求按总耗时(首个chunk耗时+chunk耗时)分桶计数
+	LatencyUnder1s  int64 `gorm:"column:latency_under_1s"`
+	LatencyUnder5s  int64 `gorm:"column:latency_under_5s"`
+	LatencyUnder30s int64 `gorm:"column:latency_under_30s"`
+	LatencyOver30s  int64 `gorm:"column:latency_over_30s"`
+}
+
+// StatsRollup 汇总任务的进度 Watermark 之前的整点小时均已汇总
+type StatsRollup struct {
+	Watermark time.Time `json:"watermark"`
+}
@@ -8,16 +8,49 @@ import (
 	"io"
 	"math/rand"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/tidwall/sjson"
 )
 
 type Anthropic struct {
-	BaseURL string      `json:"base_url"`
-	APIKey  string      `json:"api_key"`
-	Keys    []KeyConfig `json:"keys"`
-	Version string      `json:"version"`
+	BaseURL   string      `json:"base_url"`
+	APIKey    string      `json:"api_key"`
+	Keys      []KeyConfig `json:"keys"`
+	Version   string      `json:"version"`
+	Betas     []string    `json:"betas"`      // 以 anthropic-beta 请求头开启的测试特性 如 prompt-caching-2024-07-31
+	UserAgent string      `json:"user_agent"` // 发往上游的 User-Agent 默认 llmio/<version>
+}
+
+// validate 校验 beta 特性名不为空
+func (a *Anthropic) validate() error {
+	for i, beta := range a.Betas {
+		if strings.TrimSpace(beta) == "" {
+			return fmt.Errorf("betas[%d] must be a non-empty string", i)
+		}
+	}
+	return nil
+}
+
+// setBetas 将配置的 beta 特性追加到 anthropic-beta 请求头 保留客户端透传的值并去重
+func (a *Anthropic) setBetas(header http.Header) {
+	if len(a.Betas) == 0 {
+		return
+	}
+	var betas []string
+	seen := make(map[string]struct{})
+	for _, value := range append(header.Values("anthropic-beta"), a.Betas...) {
+		for _, beta := range strings.Split(value, ",") {
+			beta = strings.TrimSpace(beta)
+			if _, ok := seen[beta]; ok || beta == "" {
+				continue
+			}
+			seen[beta] = struct{}{}
+			betas = append(betas, beta)
+		}
+	}
+	header.Set("anthropic-beta", strings.Join(betas, ","))
 }
 
 // pickKey 随机抽取状态有效的 key，兼容旧 api_key 配置
@@ -60,6 +93,7 @@ func (a *Anthropic) BuildReqWithKey(ctx context.Context, header http.Header, mod
 		req.Header = header
 	}
 	req.Header.Set("content-type", "application/json")
+	setUserAgent(req.Header, a.UserAgent)
 	apiKey := key
 	usedKeyID := keyID
 	if apiKey == "" {
@@ -71,6 +105,7 @@ func (a *Anthropic) BuildReqWithKey(ctx context.Context, header http.Header, mod
 	}
 	req.Header.Set("x-api-key", apiKey)
 	req.Header.Set("anthropic-version", a.Version)
+	a.setBetas(req.Header)
 	return req, usedKeyID, nil
 }
 
@@ -93,6 +128,10 @@ type AnthropicModel struct {
 	Type        string    `json:"type"`
 }
 
+func (a *Anthropic) WarmupReq(ctx context.Context) (*http.Request, error) {
+	return warmupReq(ctx, a.BaseURL, a.UserAgent)
+}
+
 func (a *Anthropic) Models(ctx context.Context) ([]Model, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/models", a.BaseURL), nil)
 	if err != nil {
@@ -101,7 +140,8 @@ func (a *Anthropic) Models(ctx context.Context) ([]Model, error) {
 	req.Header.Set("content-type", "application/json")
 	req.Header.Set("x-api-key", a.pickKey())
 	req.Header.Set("anthropic-version", a.Version)
-	res, err := http.DefaultClient.Do(req)
+	setUserAgent(req.Header, a.UserAgent)
+	res, err := clientFrom(ctx).Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -136,5 +176,7 @@ func (a *Anthropic) BuildCountTokensReq(ctx context.Context, header http.Header,
 	req.Header.Set("content-type", "application/json")
 	req.Header.Set("x-api-key", a.pickKey())
 	req.Header.Set("anthropic-version", a.Version)
+	setUserAgent(req.Header, a.UserAgent)
+	a.setBetas(req.Header)
 	return req, nil
 }
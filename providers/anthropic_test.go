@@ -0,0 +1,69 @@
+package providers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+)
+
+func TestAnthropicBetas(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		header string // 客户端透传的 anthropic-beta
+		want   string
+	}{
+		{
+			name:   "no betas",
+			config: `{"base_url":"https://api.anthropic.com/v1","api_key":"sk","version":"2023-06-01"}`,
+			want:   "",
+		},
+		{
+			name:   "configured betas",
+			config: `{"base_url":"https://api.anthropic.com/v1","api_key":"sk","version":"2023-06-01","betas":["prompt-caching-2024-07-31","context-1m-2025-08-07"]}`,
+			want:   "prompt-caching-2024-07-31,context-1m-2025-08-07",
+		},
+		{
+			name:   "merged with client header",
+			config: `{"base_url":"https://api.anthropic.com/v1","api_key":"sk","version":"2023-06-01","betas":["prompt-caching-2024-07-31"]}`,
+			header: "prompt-caching-2024-07-31, token-efficient-tools-2025-02-19",
+			want:   "prompt-caching-2024-07-31,token-efficient-tools-2025-02-19",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, err := New(consts.StyleAnthropic, tt.config)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			header := http.Header{}
+			if tt.header != "" {
+				header.Set("anthropic-beta", tt.header)
+			}
+			req, err := provider.BuildReq(context.Background(), header, "claude-sonnet-4", []byte(`{"messages":[]}`))
+			if err != nil {
+				t.Fatalf("BuildReq() error = %v", err)
+			}
+			if got := req.Header.Get("anthropic-beta"); got != tt.want {
+				t.Errorf("anthropic-beta = %q, want %q", got, tt.want)
+			}
+			if got := req.Header.Get("anthropic-version"); got != "2023-06-01" {
+				t.Errorf("anthropic-version = %q", got)
+			}
+		})
+	}
+}
+
+func TestAnthropicBetasValidation(t *testing.T) {
+	for _, config := range []string{
+		`{"base_url":"https://api.anthropic.com/v1","betas":[""]}`,
+		`{"base_url":"https://api.anthropic.com/v1","betas":["prompt-caching-2024-07-31","  "]}`,
+		`{"base_url":"https://api.anthropic.com/v1","betas":[1]}`,
+	} {
+		if _, err := New(consts.StyleAnthropic, config); err == nil {
+			t.Errorf("New(%s) should fail", config)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	bedrockService          = "bedrock"
+	bedrockAnthropicVersion = "bedrock-2023-05-31"
+	bedrockEventStream      = "application/vnd.amazon.eventstream"
+)
+
+// Bedrock 通过 AWS Bedrock 调用 Anthropic 模型，请求使用 SigV4 签名
+type Bedrock struct {
+	Region          string `json:"region"`
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	SessionToken    string `json:"session_token"`
+	BaseURL         string `json:"base_url"`   // 可选 默认 https://bedrock-runtime.{region}.amazonaws.com
+	UserAgent       string `json:"user_agent"` // 发往上游的 User-Agent 默认 llmio/<version>
+}
+
+func (b *Bedrock) credentials() AWSCredentials {
+	return AWSCredentials{
+		AccessKeyID:     b.AccessKeyID,
+		SecretAccessKey: b.SecretAccessKey,
+		SessionToken:    b.SessionToken,
+	}
+}
+
+func (b *Bedrock) runtimeURL() string {
+	if b.BaseURL != "" {
+		return strings.TrimSuffix(b.BaseURL, "/")
+	}
+	return fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", b.Region)
+}
+
+func (b *Bedrock) WarmupReq(ctx context.Context) (*http.Request, error) {
+	return warmupReq(ctx, b.runtimeURL(), b.UserAgent)
+}
+
+// bedrockBody 将 Anthropic 请求体转换为 Bedrock 格式 模型与流式由 URL 决定
+func bedrockBody(rawBody []byte) ([]byte, error) {
+	body, err := sjson.DeleteBytes(rawBody, "model")
+	if err != nil {
+		return nil, err
+	}
+	if body, err = sjson.DeleteBytes(body, "stream"); err != nil {
+		return nil, err
+	}
+	if !gjson.GetBytes(body, "anthropic_version").Exists() {
+		if body, err = sjson.SetBytes(body, "anthropic_version", bedrockAnthropicVersion); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+func (b *Bedrock) BuildReq(ctx context.Context, header http.Header, model string, rawBody []byte) (*http.Request, error) {
+	if b.Region == "" || b.AccessKeyID == "" || b.SecretAccessKey == "" {
+		return nil, errors.New("bedrock region and credentials are required")
+	}
+	body, err := bedrockBody(rawBody)
+	if err != nil {
+		return nil, err
+	}
+
+	action := "invoke"
+	if gjson.GetBytes(rawBody, "stream").Bool() {
+		action = "invoke-with-response-stream"
+	}
+	u, err := url.Parse(b.runtimeURL())
+	if err != nil {
+		return nil, err
+	}
+	// 模型 ID 中含有 ':' 等字符 需按 AWS 规则编码路径后参与签名
+	u.RawPath = u.EscapedPath() + fmt.Sprintf("/model/%s/%s", awsURIEncode(model), action)
+	u.Path += fmt.Sprintf("/model/%s/%s", model, action)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if header != nil {
+		req.Header = header.Clone()
+	}
+	// 移除 Anthropic 原生认证头 避免泄露给上游
+	req.Header.Del("x-api-key")
+	req.Header.Del("anthropic-version")
+	req.Header.Del("Authorization")
+	req.Header.Set("Content-Type", "application/json")
+	setUserAgent(req.Header, b.UserAgent)
+	if action == "invoke" {
+		req.Header.Set("Accept", "application/json")
+	} else {
+		req.Header.Set("Accept", bedrockEventStream)
+	}
+	SignV4(req, body, b.credentials(), b.Region, bedrockService, time.Now())
+	return req, nil
+}
+
+// TransformResponse 将流式响应的 event-stream 帧转换为 Anthropic SSE
+func (b *Bedrock) TransformResponse(res *http.Response) error {
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), bedrockEventStream) {
+		return nil
+	}
+	res.Body = newEventStreamSSEReader(res.Body)
+	res.Header.Set("Content-Type", "text/event-stream")
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	return nil
+}
+
+type bedrockModelsResponse struct {
+	ModelSummaries []struct {
+		ModelID   string `json:"modelId"`
+		ModelName string `json:"modelName"`
+	} `json:"modelSummaries"`
+}
+
+func (b *Bedrock) Models(ctx context.Context) ([]Model, error) {
+	endpoint := fmt.Sprintf("https://bedrock.%s.amazonaws.com/foundation-models?byProvider=anthropic", b.Region)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	SignV4(req, nil, b.credentials(), b.Region, bedrockService, time.Now())
+	res, err := clientFrom(ctx).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code: %d", res.StatusCode)
+	}
+	var bedrockModels bedrockModelsResponse
+	if err := json.NewDecoder(res.Body).Decode(&bedrockModels); err != nil {
+		return nil, err
+	}
+
+	var modelList ModelList
+	for _, model := range bedrockModels.ModelSummaries {
+		modelList.Data = append(modelList.Data, Model{
+			ID:      model.ModelID,
+			OwnedBy: "anthropic",
+		})
+	}
+	return modelList.Data, nil
+}
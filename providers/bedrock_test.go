@@ -0,0 +1,143 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// encodeEventStreamMessage 按 AWS event-stream 格式编码一帧 仅支持字符串头
+func encodeEventStreamMessage(headers [][2]string, payload []byte) []byte {
+	var hb bytes.Buffer
+	for _, h := range headers {
+		hb.WriteByte(byte(len(h[0])))
+		hb.WriteString(h[0])
+		hb.WriteByte(7)
+		binary.Write(&hb, binary.BigEndian, uint16(len(h[1])))
+		hb.WriteString(h[1])
+	}
+	total := 12 + hb.Len() + len(payload) + 4
+
+	var msg bytes.Buffer
+	binary.Write(&msg, binary.BigEndian, uint32(total))
+	binary.Write(&msg, binary.BigEndian, uint32(hb.Len()))
+	binary.Write(&msg, binary.BigEndian, crc32.ChecksumIEEE(msg.Bytes()))
+	msg.Write(hb.Bytes())
+	msg.Write(payload)
+	binary.Write(&msg, binary.BigEndian, crc32.ChecksumIEEE(msg.Bytes()))
+	return msg.Bytes()
+}
+
+func chunkMessage(event string) []byte {
+	payload := `{"bytes":"` + base64.StdEncoding.EncodeToString([]byte(event)) + `"}`
+	return encodeEventStreamMessage([][2]string{
+		{":event-type", "chunk"},
+		{":content-type", "application/json"},
+		{":message-type", "event"},
+	}, []byte(payload))
+}
+
+func TestBedrockTransformResponse(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(chunkMessage(`{"type":"message_start","message":{"usage":{"input_tokens":5,"output_tokens":1}}}`))
+	stream.Write(chunkMessage(`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}`))
+	stream.Write(encodeEventStreamMessage([][2]string{
+		{":exception-type", "throttlingException"},
+		{":message-type", "exception"},
+	}, []byte(`{"message":"Too many requests"}`)))
+
+	res := &http.Response{
+		Header:        http.Header{"Content-Type": {bedrockEventStream}, "Content-Length": {"100"}},
+		Body:          io.NopCloser(&stream),
+		ContentLength: 100,
+	}
+	if err := (&Bedrock{}).TransformResponse(res); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Header.Get("Content-Type") != "text/event-stream" || res.ContentLength != -1 {
+		t.Errorf("expected SSE headers, got %v", res.Header)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	events := strings.Split(strings.TrimSuffix(string(body), "\n\n"), "\n\n")
+	if len(events) != 3 {
+		t.Fatalf("expected 3 SSE events, got %d: %q", len(events), body)
+	}
+	if !strings.HasPrefix(events[0], "event: message_start\ndata: {") {
+		t.Errorf("unexpected first event: %q", events[0])
+	}
+	if !strings.HasPrefix(events[1], "event: content_block_delta\n") {
+		t.Errorf("unexpected second event: %q", events[1])
+	}
+	errData := gjson.Parse(strings.TrimPrefix(events[2], "event: error\ndata: "))
+	if errData.Get("error.type").String() != "throttlingException" || errData.Get("error.message").String() != "Too many requests" {
+		t.Errorf("unexpected error event: %q", events[2])
+	}
+}
+
+func TestEventStreamChecksumMismatch(t *testing.T) {
+	msg := chunkMessage(`{"type":"ping"}`)
+	msg[len(msg)-5] ^= 0xff
+	if _, err := readEventStreamMessage(bytes.NewReader(msg)); err == nil {
+		t.Fatal("expected checksum error")
+	}
+}
+
+func TestBedrockBuildReq(t *testing.T) {
+	b := &Bedrock{Region: "us-west-2", AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "token"}
+	header := http.Header{"X-Api-Key": {"sk-leak"}, "Anthropic-Version": {"2023-06-01"}}
+	raw := []byte(`{"model":"claude","stream":true,"max_tokens":10,"messages":[]}`)
+
+	req, err := b.BuildReq(context.Background(), header, "anthropic.claude-3-5-sonnet-20240620-v1:0", raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantURL := "https://bedrock-runtime.us-west-2.amazonaws.com/model/anthropic.claude-3-5-sonnet-20240620-v1%3A0/invoke-with-response-stream"
+	if req.URL.String() != wantURL {
+		t.Errorf("expected %s, got %s", wantURL, req.URL.String())
+	}
+	if req.Header.Get("X-Api-Key") != "" || req.Header.Get("Anthropic-Version") != "" {
+		t.Error("expected anthropic auth headers to be removed")
+	}
+	if header.Get("X-Api-Key") == "" {
+		t.Error("expected caller header to be untouched")
+	}
+	if req.Header.Get("X-Amz-Security-Token") != "token" {
+		t.Error("expected session token header")
+	}
+	auth := req.Header.Get("Authorization")
+	if !strings.Contains(auth, "Credential=AKID/") || !strings.Contains(auth, "/us-west-2/bedrock/aws4_request") ||
+		!strings.Contains(auth, "SignedHeaders=content-type;host;x-amz-date;x-amz-security-token") {
+		t.Errorf("unexpected authorization: %s", auth)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gjson.GetBytes(body, "model").Exists() || gjson.GetBytes(body, "stream").Exists() {
+		t.Errorf("expected model and stream to be removed: %s", body)
+	}
+	if gjson.GetBytes(body, "anthropic_version").String() != bedrockAnthropicVersion {
+		t.Errorf("expected anthropic_version to be set: %s", body)
+	}
+
+	req, err = b.BuildReq(context.Background(), nil, "m", []byte(`{"max_tokens":1}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasSuffix(req.URL.Path, "/model/m/invoke") {
+		t.Errorf("expected non-stream invoke, got %s", req.URL.Path)
+	}
+}
@@ -7,13 +7,19 @@ import (
 	"time"
 )
 
+// clientKey 按超时与传输配置区分客户端
+type clientKey struct {
+	responseHeaderTimeout time.Duration
+	transport             TransportConfig
+}
+
 type clientCache struct {
 	mu      sync.RWMutex
-	clients map[time.Duration]*http.Client
+	clients map[clientKey]*http.Client
 }
 
 var cache = &clientCache{
-	clients: make(map[time.Duration]*http.Client),
+	clients: make(map[clientKey]*http.Client),
 }
 
 var dialer = &net.Dialer{
@@ -25,10 +31,20 @@ var dialer = &net.Dialer{
 // If a client with the same timeout already exists, it returns the cached one.
 // Otherwise, it creates a new client and caches it.
 func GetClient(responseHeaderTimeout time.Duration) *http.Client {
+	// 默认传输配置不会构建失败
+	client, _ := GetClientWithTransport(responseHeaderTimeout, TransportConfig{})
+	return client
+}
+
+// GetClientWithTransport returns an http.Client for a provider with custom proxy / TLS settings.
+// Clients are cached per (timeout, transport config) pair.
+func GetClientWithTransport(responseHeaderTimeout time.Duration, config TransportConfig) (*http.Client, error) {
+	key := clientKey{responseHeaderTimeout: responseHeaderTimeout, transport: config}
+
 	cache.mu.RLock()
-	if client, exists := cache.clients[responseHeaderTimeout]; exists {
+	if client, exists := cache.clients[key]; exists {
 		cache.mu.RUnlock()
-		return client
+		return client, nil
 	}
 	cache.mu.RUnlock()
 
@@ -36,8 +52,8 @@ func GetClient(responseHeaderTimeout time.Duration) *http.Client {
 	defer cache.mu.Unlock()
 
 	// Double-check after acquiring write lock
-	if client, exists := cache.clients[responseHeaderTimeout]; exists {
-		return client
+	if client, exists := cache.clients[key]; exists {
+		return client, nil
 	}
 
 	transport := &http.Transport{
@@ -50,12 +66,16 @@ func GetClient(responseHeaderTimeout time.Duration) *http.Client {
 		ExpectContinueTimeout: 1 * time.Second,
 		ResponseHeaderTimeout: responseHeaderTimeout,
 	}
+	if err := config.apply(transport); err != nil {
+		return nil, err
+	}
+	transport.RegisterProtocol(mockScheme, mockTransport{responseHeaderTimeout: responseHeaderTimeout})
 
 	client := &http.Client{
 		Transport: transport,
 		Timeout:   0, // No overall timeout, let ResponseHeaderTimeout control header timing
 	}
 
-	cache.clients[responseHeaderTimeout] = client
-	return client
+	cache.clients[key] = client
+	return client, nil
 }
@@ -0,0 +1,174 @@
+package providers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/tidwall/gjson"
+)
+
+// eventStreamMessage AWS event-stream 二进制帧
+type eventStreamMessage struct {
+	Headers map[string]string
+	Payload []byte
+}
+
+// maxEventStreamMessage 单帧大小上限，防止异常长度导致超大分配
+const maxEventStreamMessage = 16 * 1024 * 1024
+
+// readEventStreamMessage 读取并校验一帧 event-stream 消息
+func readEventStreamMessage(r io.Reader) (*eventStreamMessage, error) {
+	prelude := make([]byte, 12)
+	if _, err := io.ReadFull(r, prelude); err != nil {
+		return nil, err
+	}
+	totalLen := binary.BigEndian.Uint32(prelude[0:4])
+	headersLen := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return nil, errors.New("event stream prelude checksum mismatch")
+	}
+	if totalLen < 16+headersLen || totalLen > maxEventStreamMessage {
+		return nil, fmt.Errorf("invalid event stream message length: %d", totalLen)
+	}
+
+	rest := make([]byte, totalLen-12)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	messageCRC := binary.BigEndian.Uint32(rest[len(rest)-4:])
+	crc := crc32.NewIEEE()
+	crc.Write(prelude)
+	crc.Write(rest[:len(rest)-4])
+	if crc.Sum32() != messageCRC {
+		return nil, errors.New("event stream message checksum mismatch")
+	}
+
+	headers, err := parseEventStreamHeaders(rest[:headersLen])
+	if err != nil {
+		return nil, err
+	}
+	return &eventStreamMessage{
+		Headers: headers,
+		Payload: rest[headersLen : len(rest)-4],
+	}, nil
+}
+
+// parseEventStreamHeaders 解析帧头，仅保留字符串类型的值
+func parseEventStreamHeaders(b []byte) (map[string]string, error) {
+	headers := make(map[string]string)
+	for len(b) > 0 {
+		nameLen := int(b[0])
+		if len(b) < 1+nameLen+1 {
+			return nil, errors.New("invalid event stream header")
+		}
+		name := string(b[1 : 1+nameLen])
+		valueType := b[1+nameLen]
+		b = b[2+nameLen:]
+
+		var size int
+		switch valueType {
+		case 0, 1: // bool true / false
+			size = 0
+		case 2: // byte
+			size = 1
+		case 3: // short
+			size = 2
+		case 4: // int
+			size = 4
+		case 5, 8: // long / timestamp
+			size = 8
+		case 9: // uuid
+			size = 16
+		case 6, 7: // bytes / string
+			if len(b) < 2 {
+				return nil, errors.New("invalid event stream header")
+			}
+			valueLen := int(binary.BigEndian.Uint16(b[:2]))
+			if len(b) < 2+valueLen {
+				return nil, errors.New("invalid event stream header")
+			}
+			if valueType == 7 {
+				headers[name] = string(b[2 : 2+valueLen])
+			}
+			b = b[2+valueLen:]
+			continue
+		default:
+			return nil, fmt.Errorf("unknown event stream header type: %d", valueType)
+		}
+		if len(b) < size {
+			return nil, errors.New("invalid event stream header")
+		}
+		b = b[size:]
+	}
+	return headers, nil
+}
+
+// eventStreamSSEReader 将 Bedrock 的 event-stream 响应转为 Anthropic SSE 格式
+type eventStreamSSEReader struct {
+	src io.ReadCloser
+	buf bytes.Buffer
+	err error
+}
+
+func newEventStreamSSEReader(src io.ReadCloser) io.ReadCloser {
+	return &eventStreamSSEReader{src: src}
+}
+
+func (r *eventStreamSSEReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 && r.err == nil {
+		r.err = r.next()
+	}
+	if r.buf.Len() > 0 {
+		return r.buf.Read(p)
+	}
+	return 0, r.err
+}
+
+func (r *eventStreamSSEReader) Close() error {
+	return r.src.Close()
+}
+
+func (r *eventStreamSSEReader) next() error {
+	msg, err := readEventStreamMessage(r.src)
+	if err != nil {
+		return err
+	}
+	switch msg.Headers[":message-type"] {
+	case "event":
+		if msg.Headers[":event-type"] != "chunk" {
+			return nil
+		}
+		data, err := base64.StdEncoding.DecodeString(gjson.GetBytes(msg.Payload, "bytes").String())
+		if err != nil {
+			return fmt.Errorf("invalid event stream chunk: %w", err)
+		}
+		fmt.Fprintf(&r.buf, "event: %s\ndata: %s\n\n", gjson.GetBytes(data, "type").String(), data)
+	case "exception", "error":
+		errType := msg.Headers[":exception-type"]
+		if errType == "" {
+			errType = msg.Headers[":error-code"]
+		}
+		message := gjson.GetBytes(msg.Payload, "message").String()
+		if message == "" {
+			message = msg.Headers[":error-message"]
+		}
+		data, err := json.Marshal(map[string]any{
+			"type": "error",
+			"error": map[string]string{
+				"type":    errType,
+				"message": message,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&r.buf, "event: error\ndata: %s\n\n", data)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Limits 提供商对单次请求规模的限制 与提供商类型无关 0 表示不限制
+type Limits struct {
+	MaxMessages    int `json:"max_messages"`     // 消息条数上限
+	MaxPromptChars int `json:"max_prompt_chars"` // 提示词总字符数上限
+}
+
+// ParseLimits 从提供商配置中读取请求规模限制 配置无效时视为不限制
+func ParseLimits(config string) Limits {
+	var limits Limits
+	if err := json.Unmarshal([]byte(config), &limits); err != nil {
+		return Limits{}
+	}
+	return limits
+}
+
+func (l Limits) validate() error {
+	if l.MaxMessages < 0 {
+		return fmt.Errorf("max_messages must be >= 0")
+	}
+	if l.MaxPromptChars < 0 {
+		return fmt.Errorf("max_prompt_chars must be >= 0")
+	}
+	return nil
+}
+
+// Exceeded 返回请求超出的限制项 格式为 项=实际值 未超出时为空
+func (l Limits) Exceeded(messages, promptChars int) string {
+	if l.MaxMessages > 0 && messages > l.MaxMessages {
+		return fmt.Sprintf("messages=%d", messages)
+	}
+	if l.MaxPromptChars > 0 && promptChars > l.MaxPromptChars {
+		return fmt.Sprintf("prompt_chars=%d", promptChars)
+	}
+	return ""
+}
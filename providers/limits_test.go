@@ -0,0 +1,35 @@
+package providers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+)
+
+func TestLimits(t *testing.T) {
+	limits := ParseLimits(`{"base_url":"http://upstream","max_messages":2,"max_prompt_chars":100}`)
+	if limits != (Limits{MaxMessages: 2, MaxPromptChars: 100}) {
+		t.Fatalf("ParseLimits() = %+v", limits)
+	}
+	tests := []struct {
+		messages, chars int
+		want            string
+	}{
+		{2, 100, ""},
+		{3, 10, "messages=3"},
+		{1, 101, "prompt_chars=101"},
+	}
+	for _, tt := range tests {
+		if got := limits.Exceeded(tt.messages, tt.chars); got != tt.want {
+			t.Errorf("Exceeded(%d, %d) = %q, want %q", tt.messages, tt.chars, got, tt.want)
+		}
+	}
+	if got := (Limits{}).Exceeded(1000, 1000000); got != "" {
+		t.Errorf("zero limits exceeded: %q", got)
+	}
+
+	if _, err := New(consts.StyleOpenAI, `{"base_url":"http://upstream","max_messages":-1}`); err == nil || !strings.Contains(err.Error(), "max_messages") {
+		t.Errorf("New() with negative limit error = %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// mockScheme mock 提供商请求使用的 URL scheme 由提供商客户端内置的传输层处理 不产生网络请求
+const mockScheme = "mock"
+
+// mockFailureBody 注入失败时返回的错误响应
+const mockFailureBody = `{"error":{"message":"mock injected failure","type":"server_error"}}`
+
+// Mock 按配置返回固定响应的提供商 用于集成测试与演示 不访问任何上游
+// 可服务任意接口风格 响应内容需与请求风格一致
+type Mock struct {
+	Status        int               `json:"status"`         // 响应状态码 默认200
+	Header        map[string]string `json:"header"`         // 附加的响应头
+	Body          json.RawMessage   `json:"body"`           // 非流式响应 JSON 字符串按原文返回
+	Chunks        []json.RawMessage `json:"chunks"`         // 流式响应依次作为 SSE data 发送 未设置时以 body 作为唯一的块
+	Latency       int               `json:"latency"`        // 返回响应头前的延迟 单位毫秒
+	ChunkInterval int               `json:"chunk_interval"` // 流式块之间的间隔 单位毫秒
+	FailureRate   float64           `json:"failure_rate"`   // 注入失败的概率 0~1
+	FailureStatus int               `json:"failure_status"` // 注入失败时的状态码 默认500
+	Seed          *uint64           `json:"seed"`           // 失败注入的随机种子 设置后同一配置的失败序列可复现
+	ModelList     []string          `json:"models"`         // 模型列表接口返回的模型
+
+	config string // 原始配置 区分各提供商的随机序列
+}
+
+func (m *Mock) validate() error {
+	if len(m.Body) == 0 && len(m.Chunks) == 0 {
+		return errors.New("body or chunks is required")
+	}
+	if m.Status != 0 && (m.Status < 100 || m.Status > 599) {
+		return errors.New("status must be a valid HTTP status code")
+	}
+	if m.FailureStatus != 0 && (m.FailureStatus < 100 || m.FailureStatus > 599) {
+		return errors.New("failure_status must be a valid HTTP status code")
+	}
+	if m.FailureRate < 0 || m.FailureRate > 1 {
+		return errors.New("failure_rate must be between 0 and 1")
+	}
+	if m.Latency < 0 || m.ChunkInterval < 0 {
+		return errors.New("latency and chunk_interval must not be negative")
+	}
+	return nil
+}
+
+type mockContextKey struct{}
+
+func (m *Mock) BuildReq(ctx context.Context, header http.Header, model string, rawBody []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(context.WithValue(ctx, mockContextKey{}, m), http.MethodPost, mockScheme+"://mock/"+model, bytes.NewReader(rawBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+func (m *Mock) Models(ctx context.Context) ([]Model, error) {
+	list := make([]Model, 0, len(m.ModelList))
+	for _, id := range m.ModelList {
+		list = append(list, Model{ID: id, Object: "model", OwnedBy: "mock"})
+	}
+	return list, nil
+}
+
+// mockRands 设置了种子的 mock 配置各自的随机序列 跨请求保持
+var mockRands = struct {
+	sync.Mutex
+	rands map[string]*rand.Rand
+}{rands: make(map[string]*rand.Rand)}
+
+// fail 按失败概率决定本次请求是否注入失败
+func (m *Mock) fail() bool {
+	if m.FailureRate <= 0 {
+		return false
+	}
+	if m.Seed == nil {
+		return rand.Float64() < m.FailureRate
+	}
+	mockRands.Lock()
+	defer mockRands.Unlock()
+	r, ok := mockRands.rands[m.config]
+	if !ok {
+		r = rand.New(rand.NewPCG(*m.Seed, 0))
+		mockRands.rands[m.config] = r
+	}
+	return r.Float64() < m.FailureRate
+}
+
+// mockTransport 处理 mock 提供商的请求 responseHeaderTimeout 与真实客户端的响应头超时一致
+type mockTransport struct {
+	responseHeaderTimeout time.Duration
+}
+
+func (t mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	m, ok := req.Context().Value(mockContextKey{}).(*Mock)
+	if !ok {
+		return nil, errors.New("mock request without mock provider")
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body.Close()
+
+	latency := time.Duration(m.Latency) * time.Millisecond
+	timedOut := t.responseHeaderTimeout > 0 && latency > t.responseHeaderTimeout
+	if timedOut {
+		latency = t.responseHeaderTimeout
+	}
+	if err := sleepContext(req.Context(), latency); err != nil {
+		return nil, err
+	}
+	if timedOut {
+		return nil, errors.New("mock: timeout awaiting response headers")
+	}
+
+	if m.fail() {
+		status := http.StatusInternalServerError
+		if m.FailureStatus != 0 {
+			status = m.FailureStatus
+		}
+		res := newMockResponse(req, status)
+		res.Header.Set("Content-Type", "application/json")
+		res.Body = io.NopCloser(bytes.NewReader([]byte(mockFailureBody)))
+		return res, nil
+	}
+
+	status := http.StatusOK
+	if m.Status != 0 {
+		status = m.Status
+	}
+	res := newMockResponse(req, status)
+	for k, v := range m.Header {
+		res.Header.Set(k, v)
+	}
+
+	// 只有成功响应按流式返回 错误响应与真实上游一样为 JSON
+	if gjson.GetBytes(body, "stream").Bool() && res.StatusCode == http.StatusOK {
+		chunks := m.Chunks
+		if len(chunks) == 0 {
+			chunks = []json.RawMessage{m.Body}
+		}
+		res.Header.Set("Content-Type", "text/event-stream")
+		res.Body = m.stream(req.Context(), chunks)
+		return res, nil
+	}
+	if res.Header.Get("Content-Type") == "" {
+		res.Header.Set("Content-Type", "application/json")
+	}
+	res.Body = io.NopCloser(bytes.NewReader(mockPayload(m.Body)))
+	return res, nil
+}
+
+func newMockResponse(req *http.Request, status int) *http.Response {
+	return &http.Response{
+		Status:     strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Request:    req,
+	}
+}
+
+// stream 按间隔依次写出 SSE 块 请求取消时结束
+func (m *Mock) stream(ctx context.Context, chunks []json.RawMessage) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		interval := time.Duration(m.ChunkInterval) * time.Millisecond
+		for i, chunk := range chunks {
+			if i > 0 {
+				if err := sleepContext(ctx, interval); err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+			}
+			if _, err := fmt.Fprintf(pw, "data: %s\n\n", mockPayload(chunk)); err != nil {
+				return
+			}
+		}
+		pw.Close()
+	}()
+	return pr
+}
+
+// mockPayload JSON 字符串返回其内容 其余 JSON 值原样返回
+func mockPayload(raw json.RawMessage) []byte {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return []byte(text)
+	}
+	return raw
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
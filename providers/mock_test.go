@@ -0,0 +1,169 @@
+package providers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+)
+
+func doMock(t *testing.T, config, body string, timeout time.Duration) (*http.Response, error) {
+	t.Helper()
+	provider, err := New(consts.StyleMock, config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	req, err := provider.BuildReq(context.Background(), http.Header{}, "mock-model", []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := GetProviderClient(timeout, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.Do(req)
+}
+
+func TestMockResponses(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		body        string
+		wantStatus  int
+		wantType    string
+		wantBody    string
+		wantHeaders map[string]string
+	}{
+		{
+			name:       "json body",
+			config:     `{"body":{"choices":[{"message":{"content":"hi"}}]}}`,
+			body:       `{"model":"m"}`,
+			wantStatus: http.StatusOK,
+			wantType:   "application/json",
+			wantBody:   `{"choices":[{"message":{"content":"hi"}}]}`,
+		},
+		{
+			name:        "status and headers",
+			config:      `{"status":429,"header":{"Retry-After":"3"},"body":"slow down"}`,
+			body:        `{"model":"m","stream":true}`,
+			wantStatus:  http.StatusTooManyRequests,
+			wantType:    "application/json",
+			wantBody:    "slow down",
+			wantHeaders: map[string]string{"Retry-After": "3"},
+		},
+		{
+			name:       "stream chunks",
+			config:     `{"body":{},"chunks":[{"choices":[{"delta":{"content":"a"}}]},"[DONE]"]}`,
+			body:       `{"model":"m","stream":true}`,
+			wantStatus: http.StatusOK,
+			wantType:   "text/event-stream",
+			wantBody:   "data: {\"choices\":[{\"delta\":{\"content\":\"a\"}}]}\n\ndata: [DONE]\n\n",
+		},
+		{
+			name:       "stream falls back to body",
+			config:     `{"body":{"x":1}}`,
+			body:       `{"model":"m","stream":true}`,
+			wantStatus: http.StatusOK,
+			wantType:   "text/event-stream",
+			wantBody:   "data: {\"x\":1}\n\n",
+		},
+		{
+			name:       "injected failure",
+			config:     `{"body":{},"failure_rate":1,"failure_status":503}`,
+			body:       `{"model":"m"}`,
+			wantStatus: http.StatusServiceUnavailable,
+			wantType:   "application/json",
+			wantBody:   mockFailureBody,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := doMock(t, tt.config, tt.body, 0)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			defer res.Body.Close()
+			body, _ := io.ReadAll(res.Body)
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+			if got := res.Header.Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if string(body) != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+			for k, v := range tt.wantHeaders {
+				if got := res.Header.Get(k); got != v {
+					t.Errorf("header %s = %q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestMockSeededFailures(t *testing.T) {
+	config := `{"body":{},"failure_rate":0.5,"seed":42}`
+	sequence := func() string {
+		mockRands.Lock()
+		delete(mockRands.rands, config)
+		mockRands.Unlock()
+		var sb strings.Builder
+		for range 20 {
+			res, err := doMock(t, config, `{}`, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode == http.StatusOK {
+				sb.WriteByte('.')
+			} else {
+				sb.WriteByte('x')
+			}
+		}
+		return sb.String()
+	}
+	first := sequence()
+	if second := sequence(); first != second {
+		t.Errorf("seeded sequences differ: %s vs %s", first, second)
+	}
+	if !strings.Contains(first, ".") || !strings.Contains(first, "x") {
+		t.Errorf("sequence %s should mix successes and failures", first)
+	}
+}
+
+func TestMockLatency(t *testing.T) {
+	start := time.Now()
+	res, err := doMock(t, `{"body":{},"latency":30}`, `{}`, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("elapsed = %v, want at least 30ms", elapsed)
+	}
+
+	// 超过响应头超时时与真实上游一样返回错误
+	if _, err := doMock(t, `{"body":{},"latency":1000}`, `{}`, 20*time.Millisecond); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+}
+
+func TestMockInvalidConfig(t *testing.T) {
+	for _, config := range []string{
+		`{}`,
+		`{"body":{},"status":42}`,
+		`{"body":{},"failure_rate":1.5}`,
+		`{"body":{},"failure_status":700}`,
+		`{"body":{},"latency":-1}`,
+		`not json`,
+	} {
+		if _, err := New(consts.StyleMock, config); err == nil {
+			t.Errorf("New(%s) expected error", config)
+		}
+	}
+}
@@ -13,9 +13,11 @@ import (
 )
 
 type OpenAI struct {
-	BaseURL string      `json:"base_url"`
-	APIKey  string      `json:"api_key"`
-	Keys    []KeyConfig `json:"keys"`
+	BaseURL     string      `json:"base_url"`
+	APIKey      string      `json:"api_key"`
+	Keys        []KeyConfig `json:"keys"`
+	StripParams []string    `json:"strip_params"` // 转发前移除的上游不支持字段
+	UserAgent   string      `json:"user_agent"`   // 发往上游的 User-Agent 默认 llmio/<version>
 }
 
 // pickKey 随机抽取状态有效的 key，兼容旧 api_key 配置
@@ -45,12 +47,31 @@ func (o *OpenAI) pickKey() string {
 	return o.APIKey
 }
 
+type completionContextKey struct{}
+
+// WithCompletion 标记请求转发到 legacy completions 接口而非 chat/completions
+func WithCompletion(ctx context.Context) context.Context {
+	return context.WithValue(ctx, completionContextKey{}, true)
+}
+
+func isCompletion(ctx context.Context) bool {
+	completion, _ := ctx.Value(completionContextKey{}).(bool)
+	return completion
+}
+
 func (o *OpenAI) BuildReqWithKey(ctx context.Context, header http.Header, model string, rawBody []byte, key string, keyID uint) (*http.Request, uint, error) {
 	body, err := sjson.SetBytes(rawBody, "model", model)
 	if err != nil {
 		return nil, 0, err
 	}
-	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/chat/completions", o.BaseURL), bytes.NewReader(body))
+	if body, err = stripParams(body, o.StripParams); err != nil {
+		return nil, 0, err
+	}
+	endpoint := "chat/completions"
+	if isCompletion(ctx) {
+		endpoint = "completions"
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/%s", o.BaseURL, endpoint), bytes.NewReader(body))
 	if err != nil {
 		return nil, 0, err
 	}
@@ -58,6 +79,7 @@ func (o *OpenAI) BuildReqWithKey(ctx context.Context, header http.Header, model
 		req.Header = header
 	}
 	req.Header.Set("Content-Type", "application/json")
+	setUserAgent(req.Header, o.UserAgent)
 	apiKey := key
 	usedKeyID := keyID
 	if apiKey == "" {
@@ -77,13 +99,18 @@ func (o *OpenAI) BuildReq(ctx context.Context, header http.Header, model string,
 	return req, err
 }
 
+func (o *OpenAI) WarmupReq(ctx context.Context) (*http.Request, error) {
+	return warmupReq(ctx, o.BaseURL, o.UserAgent)
+}
+
 func (o *OpenAI) Models(ctx context.Context) ([]Model, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/models", o.BaseURL), nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", o.pickKey()))
-	res, err := http.DefaultClient.Do(req)
+	setUserAgent(req.Header, o.UserAgent)
+	res, err := clientFrom(ctx).Do(req)
 	if err != nil {
 		return nil, err
 	}
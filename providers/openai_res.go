@@ -12,8 +12,10 @@ import (
 
 // openai responses api
 type OpenAIRes struct {
-	BaseURL string `json:"base_url"`
-	APIKey  string `json:"api_key"`
+	BaseURL     string   `json:"base_url"`
+	APIKey      string   `json:"api_key"`
+	StripParams []string `json:"strip_params"` // 转发前移除的上游不支持字段
+	UserAgent   string   `json:"user_agent"`   // 发往上游的 User-Agent 默认 llmio/<version>
 }
 
 func (o *OpenAIRes) BuildReq(ctx context.Context, header http.Header, model string, rawBody []byte) (*http.Request, error) {
@@ -21,6 +23,9 @@ func (o *OpenAIRes) BuildReq(ctx context.Context, header http.Header, model stri
 	if err != nil {
 		return nil, err
 	}
+	if body, err = stripParams(body, o.StripParams); err != nil {
+		return nil, err
+	}
 	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/responses", o.BaseURL), bytes.NewReader(body))
 	if err != nil {
 		return nil, err
@@ -29,6 +34,7 @@ func (o *OpenAIRes) BuildReq(ctx context.Context, header http.Header, model stri
 		req.Header = header
 	}
 	req.Header.Set("Content-Type", "application/json")
+	setUserAgent(req.Header, o.UserAgent)
 	if req.Header.Get("Authorization") == "" {
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", o.APIKey))
 	}
@@ -36,13 +42,18 @@ func (o *OpenAIRes) BuildReq(ctx context.Context, header http.Header, model stri
 	return req, nil
 }
 
+func (o *OpenAIRes) WarmupReq(ctx context.Context) (*http.Request, error) {
+	return warmupReq(ctx, o.BaseURL, o.UserAgent)
+}
+
 func (o *OpenAIRes) Models(ctx context.Context) ([]Model, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/models", o.BaseURL), nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", o.APIKey))
-	res, err := http.DefaultClient.Do(req)
+	setUserAgent(req.Header, o.UserAgent)
+	res, err := clientFrom(ctx).Do(req)
 	if err != nil {
 		return nil, err
 	}
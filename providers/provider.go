@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/atopos31/llmio/consts"
@@ -28,18 +29,52 @@ type KeyConfig struct {
 	Status bool   `json:"status"`
 }
 
+// setUserAgent 设置发往上游的 User-Agent 覆盖客户端透传的值 未配置时使用网关默认值
+func setUserAgent(header http.Header, userAgent string) {
+	if userAgent == "" {
+		userAgent = consts.UserAgent()
+	}
+	header.Set("User-Agent", userAgent)
+}
+
+// warmupReq 对上游地址发送不携带密钥的 HEAD 请求 任意响应都说明连接与 TLS 会话已建立
+func warmupReq(ctx context.Context, baseURL, userAgent string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, baseURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	setUserAgent(req.Header, userAgent)
+	return req, nil
+}
+
 type Provider interface {
 	BuildReq(ctx context.Context, header http.Header, model string, rawData []byte) (*http.Request, error)
 	Models(ctx context.Context) ([]Model, error)
 }
 
+// Warmer 支持连接预热的提供商实现此接口 预热请求不携带密钥 不消耗 Key 额度也不计入上游限流
+type Warmer interface {
+	WarmupReq(ctx context.Context) (*http.Request, error)
+}
+
+// ResponseTransformer 上游响应需转换为标准格式的提供商实现此接口
+type ResponseTransformer interface {
+	TransformResponse(res *http.Response) error
+}
+
 func New(Type, providerConfig string) (Provider, error) {
+	if err := ParseLimits(providerConfig).validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s config: %w", Type, err)
+	}
 	switch Type {
 	case consts.StyleOpenAI:
 		var openai OpenAI
 		if err := json.Unmarshal([]byte(providerConfig), &openai); err != nil {
 			return nil, errors.New("invalid openai config")
 		}
+		if err := validateStripParams(openai.StripParams); err != nil {
+			return nil, fmt.Errorf("invalid openai config: %w", err)
+		}
 
 		return &openai, nil
 	case consts.StyleOpenAIRes:
@@ -47,6 +82,9 @@ func New(Type, providerConfig string) (Provider, error) {
 		if err := json.Unmarshal([]byte(providerConfig), &openaiRes); err != nil {
 			return nil, errors.New("invalid openai-res config")
 		}
+		if err := validateStripParams(openaiRes.StripParams); err != nil {
+			return nil, fmt.Errorf("invalid openai-res config: %w", err)
+		}
 
 		return &openaiRes, nil
 	case consts.StyleAnthropic:
@@ -54,7 +92,25 @@ func New(Type, providerConfig string) (Provider, error) {
 		if err := json.Unmarshal([]byte(providerConfig), &anthropic); err != nil {
 			return nil, errors.New("invalid anthropic config")
 		}
+		if err := anthropic.validate(); err != nil {
+			return nil, fmt.Errorf("invalid anthropic config: %w", err)
+		}
 		return &anthropic, nil
+	case consts.StyleBedrock:
+		var bedrock Bedrock
+		if err := json.Unmarshal([]byte(providerConfig), &bedrock); err != nil {
+			return nil, errors.New("invalid bedrock config")
+		}
+		return &bedrock, nil
+	case consts.StyleMock:
+		mock := Mock{config: providerConfig}
+		if err := json.Unmarshal([]byte(providerConfig), &mock); err != nil {
+			return nil, errors.New("invalid mock config")
+		}
+		if err := mock.validate(); err != nil {
+			return nil, fmt.Errorf("invalid mock config: %w", err)
+		}
+		return &mock, nil
 	default:
 		return nil, errors.New("unknown provider")
 	}
@@ -0,0 +1,73 @@
+package providers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+)
+
+func TestBuildReqUserAgent(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider Provider
+		want     string
+	}{
+		{"openai default", &OpenAI{BaseURL: "http://upstream", APIKey: "sk"}, consts.UserAgent()},
+		{"openai configured", &OpenAI{BaseURL: "http://upstream", APIKey: "sk", UserAgent: "acme/1.0"}, "acme/1.0"},
+		{"openai-res configured", &OpenAIRes{BaseURL: "http://upstream", APIKey: "sk", UserAgent: "acme/1.0"}, "acme/1.0"},
+		{"anthropic configured", &Anthropic{BaseURL: "http://upstream", APIKey: "sk", Version: "2023-06-01", UserAgent: "acme/1.0"}, "acme/1.0"},
+		{"bedrock default", &Bedrock{Region: "us-east-1", AccessKeyID: "AK", SecretAccessKey: "SK"}, consts.UserAgent()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 客户端透传的 User-Agent 不应发往上游
+			header := http.Header{"User-Agent": []string{"curl/8.0"}}
+			req, err := tt.provider.BuildReq(context.Background(), header, "m", []byte(`{"model":"x"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := req.Header.Values("User-Agent"); len(got) != 1 || got[0] != tt.want {
+				t.Errorf("User-Agent = %v, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWarmupReq(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider Provider
+		wantURL  string
+	}{
+		{"openai", &OpenAI{BaseURL: "http://upstream/v1", APIKey: "sk"}, "http://upstream/v1"},
+		{"openai-res", &OpenAIRes{BaseURL: "http://upstream/v1", APIKey: "sk"}, "http://upstream/v1"},
+		{"anthropic", &Anthropic{BaseURL: "http://upstream/v1", APIKey: "sk", Version: "2023-06-01"}, "http://upstream/v1"},
+		{"bedrock", &Bedrock{Region: "us-east-1", AccessKeyID: "ak", SecretAccessKey: "sk"}, "https://bedrock-runtime.us-east-1.amazonaws.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warmer, ok := tt.provider.(Warmer)
+			if !ok {
+				t.Fatal("provider does not support warmup")
+			}
+			req, err := warmer.WarmupReq(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Method != http.MethodHead || req.URL.String() != tt.wantURL {
+				t.Errorf("request = %s %s, want HEAD %s", req.Method, req.URL, tt.wantURL)
+			}
+			// 预热不携带密钥 不消耗额度
+			for _, h := range []string{"Authorization", "X-Api-Key"} {
+				if req.Header.Get(h) != "" {
+					t.Errorf("warmup request carries %s", h)
+				}
+			}
+		})
+	}
+	if _, ok := Provider(&Mock{}).(Warmer); ok {
+		t.Error("mock provider has no connection to warm")
+	}
+}
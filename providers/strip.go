@@ -0,0 +1,40 @@
+package providers
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// validateStripParams 校验需要移除的字段路径不为空
+func validateStripParams(params []string) error {
+	for i, param := range params {
+		if strings.TrimSpace(param) == "" {
+			return fmt.Errorf("strip_params[%d] must be a non-empty path", i)
+		}
+	}
+	return nil
+}
+
+// stripParams 移除上游不支持的请求字段 如 stream_options.include_obfuscation
+// 路径使用 gjson 语法 移除整个 stream_options 会导致流式响应缺少用量统计
+func stripParams(body []byte, params []string) ([]byte, error) {
+	var stripped []string
+	for _, param := range params {
+		if !gjson.GetBytes(body, param).Exists() {
+			continue
+		}
+		var err error
+		if body, err = sjson.DeleteBytes(body, param); err != nil {
+			return nil, err
+		}
+		stripped = append(stripped, param)
+	}
+	if len(stripped) > 0 {
+		slog.Debug("strip unsupported params", "params", stripped)
+	}
+	return body, nil
+}
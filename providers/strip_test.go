@@ -0,0 +1,77 @@
+package providers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/tidwall/gjson"
+)
+
+func TestStripParams(t *testing.T) {
+	raw := []byte(`{"model":"m","stream":true,"stream_options":{"include_usage":true,"include_obfuscation":false},"messages":[]}`)
+
+	tests := []struct {
+		name    string
+		style   string
+		config  string
+		removed []string
+		kept    []string
+	}{
+		{
+			name:   "openai without strip_params",
+			style:  consts.StyleOpenAI,
+			config: `{"base_url":"https://api.openai.com/v1","api_key":"sk"}`,
+			kept:   []string{"stream_options.include_obfuscation", "stream_options.include_usage"},
+		},
+		{
+			name:    "openai targeted field",
+			style:   consts.StyleOpenAI,
+			config:  `{"base_url":"https://compat.example/v1","api_key":"sk","strip_params":["stream_options.include_obfuscation","service_tier"]}`,
+			removed: []string{"stream_options.include_obfuscation"},
+			kept:    []string{"stream_options.include_usage", "messages"},
+		},
+		{
+			name:    "openai-res whole object",
+			style:   consts.StyleOpenAIRes,
+			config:  `{"base_url":"https://compat.example/v1","api_key":"sk","strip_params":["stream_options"]}`,
+			removed: []string{"stream_options"},
+			kept:    []string{"stream"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, err := New(tt.style, tt.config)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			req, err := provider.BuildReq(context.Background(), http.Header{}, "upstream-model", raw)
+			if err != nil {
+				t.Fatalf("BuildReq() error = %v", err)
+			}
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := gjson.GetBytes(body, "model").String(); got != "upstream-model" {
+				t.Errorf("model = %q, want upstream-model", got)
+			}
+			for _, path := range tt.removed {
+				if gjson.GetBytes(body, path).Exists() {
+					t.Errorf("%s should be stripped, body %s", path, body)
+				}
+			}
+			for _, path := range tt.kept {
+				if !gjson.GetBytes(body, path).Exists() {
+					t.Errorf("%s should be kept, body %s", path, body)
+				}
+			}
+		})
+	}
+
+	if _, err := New(consts.StyleOpenAI, `{"strip_params":[" "]}`); err == nil {
+		t.Error("expected error for empty strip_params entry")
+	}
+}
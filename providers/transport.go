@@ -0,0 +1,93 @@
+package providers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// TransportConfig 提供商可选的代理与 TLS 配置，与提供商配置位于同一 JSON 中
+type TransportConfig struct {
+	Proxy              string `json:"proxy"`                // HTTP(S) 代理地址 为空时使用环境变量
+	CAFile             string `json:"ca_file"`              // 自定义 CA 证书路径 PEM 格式
+	CertFile           string `json:"cert_file"`            // 客户端证书路径
+	KeyFile            string `json:"key_file"`             // 客户端私钥路径
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"` // 跳过证书校验 仅用于测试
+}
+
+// ParseTransportConfig 从提供商配置中解析代理与 TLS 配置
+func ParseTransportConfig(providerConfig string) (TransportConfig, error) {
+	var config TransportConfig
+	if err := json.Unmarshal([]byte(providerConfig), &config); err != nil {
+		return TransportConfig{}, errors.New("invalid transport config")
+	}
+	return config, nil
+}
+
+func (c TransportConfig) apply(transport *http.Transport) error {
+	if c.Proxy != "" {
+		proxyURL, err := url.Parse(c.Proxy)
+		if err != nil {
+			return fmt.Errorf("invalid proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if c.CAFile == "" && c.CertFile == "" && c.KeyFile == "" && !c.InsecureSkipVerify {
+		return nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return fmt.Errorf("read ca file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("no valid certificates in ca file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport.TLSClientConfig = tlsConfig
+	return nil
+}
+
+type clientContextKey struct{}
+
+// WithClient 指定提供商非对话请求（如获取模型列表）使用的客户端
+func WithClient(ctx context.Context, client *http.Client) context.Context {
+	return context.WithValue(ctx, clientContextKey{}, client)
+}
+
+func clientFrom(ctx context.Context) *http.Client {
+	if client, ok := ctx.Value(clientContextKey{}).(*http.Client); ok && client != nil {
+		return client
+	}
+	return http.DefaultClient
+}
+
+// GetProviderClient 按提供商配置中的代理与 TLS 设置获取客户端
+func GetProviderClient(responseHeaderTimeout time.Duration, providerConfig string) (*http.Client, error) {
+	config, err := ParseTransportConfig(providerConfig)
+	if err != nil {
+		return nil, err
+	}
+	return GetClientWithTransport(responseHeaderTimeout, config)
+}
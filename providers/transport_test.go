@@ -0,0 +1,179 @@
+package providers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newClientCert 生成自签名客户端证书 返回证书与私钥文件路径
+func newClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "llmio-test-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, writePEM(t, dir, "client.pem", "CERTIFICATE", der), writePEM(t, dir, "client-key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+func providerConfig(t *testing.T, config TransportConfig) string {
+	t.Helper()
+	raw, err := json.Marshal(map[string]any{
+		"base_url":             "https://example.com/v1",
+		"api_key":              "sk-test",
+		"proxy":                config.Proxy,
+		"ca_file":              config.CAFile,
+		"cert_file":            config.CertFile,
+		"key_file":             config.KeyFile,
+		"insecure_skip_verify": config.InsecureSkipVerify,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw)
+}
+
+func TestGetProviderClientTLS(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	dir := t.TempDir()
+
+	server := httptest.NewTLSServer(ok)
+	defer server.Close()
+	caFile := writePEM(t, dir, "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+
+	clientCert, certFile, keyFile := newClientCert(t, dir)
+	mtlsServer := httptest.NewUnstartedServer(ok)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	mtlsServer.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	mtlsServer.StartTLS()
+	defer mtlsServer.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		config  TransportConfig
+		wantErr bool
+	}{
+		{name: "default rejects self-signed", url: server.URL, wantErr: true},
+		{name: "custom ca", url: server.URL, config: TransportConfig{CAFile: caFile}},
+		{name: "insecure skip verify", url: server.URL, config: TransportConfig{InsecureSkipVerify: true}},
+		{name: "mtls without client cert", url: mtlsServer.URL, config: TransportConfig{InsecureSkipVerify: true}, wantErr: true},
+		{name: "mtls with client cert", url: mtlsServer.URL, config: TransportConfig{InsecureSkipVerify: true, CertFile: certFile, KeyFile: keyFile}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := GetProviderClient(time.Second*5, providerConfig(t, tt.config))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			res, err := client.Get(tt.url)
+			if tt.wantErr {
+				if err == nil {
+					res.Body.Close()
+					t.Fatal("expected tls error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer res.Body.Close()
+			if body, _ := io.ReadAll(res.Body); string(body) != "ok" {
+				t.Errorf("unexpected body: %s", body)
+			}
+		})
+	}
+}
+
+func TestGetProviderClientProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Write([]byte("via proxy"))
+	}))
+	defer proxy.Close()
+
+	client, err := GetProviderClient(time.Second*5, providerConfig(t, TransportConfig{Proxy: proxy.URL}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://upstream.invalid/v1/models", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer res.Body.Close()
+	if proxied != "http://upstream.invalid/v1/models" {
+		t.Errorf("expected request to go through proxy, got %q", proxied)
+	}
+}
+
+func TestGetProviderClientCache(t *testing.T) {
+	config := `{"base_url":"https://example.com","api_key":"k"}`
+	a, err := GetProviderClient(time.Second, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := GetClient(time.Second); a != b {
+		t.Error("expected providers without transport config to share the default client")
+	}
+	c, err := GetProviderClient(time.Second, `{"insecure_skip_verify":true}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == c {
+		t.Error("expected custom transport config to use a separate client")
+	}
+	if _, err := GetProviderClient(time.Second, `{"ca_file":"/nonexistent/ca.pem"}`); err == nil {
+		t.Error("expected error for missing ca file")
+	}
+}
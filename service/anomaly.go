@@ -0,0 +1,41 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"unicode/utf8"
+
+	"github.com/atopos31/llmio/models"
+)
+
+// FlagAnomaly 按全局阈值标记形态异常的请求 结果记录在请求日志中 不影响转发
+// 检查只使用 Beforer 已解析出的字段 不再重新解析请求体
+func FlagAnomaly(ctx context.Context, before *Before) {
+	config, err := LoadConfig[models.Anomaly](ctx, models.KeyAnomaly)
+	if err != nil {
+		slog.Error("load anomaly config error", "error", err)
+		return
+	}
+	if config == nil || !config.Enabled {
+		return
+	}
+	before.anomalies = anomalyReasons(*before, *config)
+}
+
+// anomalyReasons 返回超出阈值的项 格式为 项=实际值
+func anomalyReasons(before Before, config models.Anomaly) []string {
+	var reasons []string
+	if config.MaxPromptChars > 0 {
+		if chars := utf8.RuneCountInString(before.prompt); chars > config.MaxPromptChars {
+			reasons = append(reasons, fmt.Sprintf("prompt_chars=%d", chars))
+		}
+	}
+	if config.MaxTools > 0 && before.toolCount > config.MaxTools {
+		reasons = append(reasons, fmt.Sprintf("tools=%d", before.toolCount))
+	}
+	if config.MaxTokens > 0 && before.maxTokens > config.MaxTokens {
+		reasons = append(reasons, fmt.Sprintf("max_tokens=%d", before.maxTokens))
+	}
+	return reasons
+}
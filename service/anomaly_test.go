@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/models"
+)
+
+func TestAnomalyReasons(t *testing.T) {
+	config := models.Anomaly{Enabled: true, MaxPromptChars: 10, MaxTools: 2, MaxTokens: 4096}
+	tools := `[{"type":"function","function":{"name":"a"}},{"type":"function","function":{"name":"b"}},{"type":"function","function":{"name":"c"}}]`
+
+	tests := []struct {
+		name    string
+		beforer Beforer
+		body    string
+		config  models.Anomaly
+		want    string
+	}{
+		{name: "normal", beforer: BeforerOpenAI, body: `{"model":"m","messages":[{"role":"user","content":"hi"}],"max_tokens":100}`, config: config},
+		// 按字符而非字节计数
+		{name: "multibyte prompt within limit", beforer: BeforerOpenAI, body: `{"model":"m","messages":[{"role":"user","content":"你好你好你好"}]}`, config: config},
+		{name: "long prompt", beforer: BeforerOpenAI, body: `{"model":"m","messages":[{"role":"user","content":"hello world!"}]}`, config: config, want: "prompt_chars=13"},
+		{name: "openai all", beforer: BeforerOpenAI, body: `{"model":"m","messages":[{"role":"user","content":"hello world!"}],"tools":` + tools + `,"max_completion_tokens":8192}`, config: config, want: "prompt_chars=13,tools=3,max_tokens=8192"},
+		{name: "responses max output", beforer: BeforerOpenAIRes, body: `{"model":"m","input":"hi","max_output_tokens":100000}`, config: config, want: "max_tokens=100000"},
+		{name: "anthropic tools", beforer: BeforerAnthropic, body: `{"model":"m","messages":[{"role":"user","content":"hi"}],"tools":` + tools + `,"max_tokens":1024}`, config: config, want: "tools=3"},
+		{name: "completion", beforer: BeforerOpenAICompletion, body: `{"model":"m","prompt":"hi","max_tokens":5000}`, config: config, want: "max_tokens=5000"},
+		{name: "zero threshold skipped", beforer: BeforerOpenAI, body: `{"model":"m","messages":[{"role":"user","content":"hello world!"}],"tools":` + tools + `}`, config: models.Anomaly{Enabled: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, err := tt.beforer([]byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(anomalyReasons(*before, tt.config), ","); got != tt.want {
+				t.Errorf("reasons = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlagAnomaly(t *testing.T) {
+	setupChatDB(t)
+	ctx := context.Background()
+	body := []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}],"max_tokens":100000}`)
+
+	// 未配置时不标记
+	before, err := BeforerOpenAI(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	FlagAnomaly(ctx, before)
+	if before.Anomaly() != "" {
+		t.Errorf("flagged without config: %s", before.Anomaly())
+	}
+
+	for _, tt := range []struct {
+		enabled bool
+		want    string
+	}{{false, ""}, {true, "max_tokens=100000"}} {
+		if err := SaveConfig(ctx, models.KeyAnomaly, models.Anomaly{Enabled: tt.enabled, MaxTokens: 32000}); err != nil {
+			t.Fatal(err)
+		}
+		before, err := BeforerOpenAI(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		FlagAnomaly(ctx, before)
+		if before.Anomaly() != tt.want {
+			t.Errorf("enabled %v: anomaly = %q, want %q", tt.enabled, before.Anomaly(), tt.want)
+		}
+	}
+}
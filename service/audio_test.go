@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+)
+
+func TestProcesserAudioUsage(t *testing.T) {
+	tests := []struct {
+		name       string
+		processer  Processer
+		stream     bool
+		body       string
+		wantInput  int64
+		wantOutput int64
+	}{
+		{
+			name:       "openai audio output",
+			processer:  ProcesserOpenAI,
+			body:       `{"object":"chat.completion","choices":[{"message":{"role":"assistant","audio":{"id":"audio_1","data":"UklG","transcript":"hi"}}}],"usage":{"prompt_tokens":40,"completion_tokens":120,"total_tokens":160,"prompt_tokens_details":{"cached_tokens":0,"audio_tokens":32},"completion_tokens_details":{"reasoning_tokens":0,"audio_tokens":100}}}`,
+			wantInput:  32,
+			wantOutput: 100,
+		},
+		{
+			name:      "openai audio stream",
+			processer: ProcesserOpenAI,
+			stream:    true,
+			body: "data: {\"choices\":[{\"delta\":{\"audio\":{\"id\":\"audio_1\",\"data\":\"UklG\"}}}]}\n\n" +
+				"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":40,\"completion_tokens\":80,\"total_tokens\":120,\"prompt_tokens_details\":{\"audio_tokens\":16},\"completion_tokens_details\":{\"audio_tokens\":64}}}\n\n" +
+				"data: [DONE]\n\n",
+			wantInput:  16,
+			wantOutput: 64,
+		},
+		{
+			name:       "responses",
+			processer:  ProcesserOpenAiRes,
+			stream:     true,
+			body:       "event: response.completed\ndata: {\"type\":\"response.completed\",\"response\":{\"usage\":{\"input_tokens\":30,\"input_tokens_details\":{\"audio_tokens\":8},\"output_tokens\":50,\"output_tokens_details\":{\"audio_tokens\":40},\"total_tokens\":80}}}\n\n",
+			wantInput:  8,
+			wantOutput: 40,
+		},
+		{
+			name:      "text only",
+			processer: ProcesserOpenAI,
+			body:      `{"choices":[],"usage":{"prompt_tokens":5,"completion_tokens":3,"total_tokens":8}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, _, err := tt.processer(context.Background(), strings.NewReader(tt.body), tt.stream, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if log.PromptTokensDetails.AudioTokens != tt.wantInput || log.CompletionTokensDetails.AudioTokens != tt.wantOutput {
+				t.Errorf("audio tokens = %d/%d, want %d/%d", log.PromptTokensDetails.AudioTokens, log.CompletionTokensDetails.AudioTokens, tt.wantInput, tt.wantOutput)
+			}
+		})
+	}
+}
+
+func TestBeforeAudio(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{"text", `{"model":"m","messages":[{"role":"user","content":"hi"}]}`, false},
+		{"text modality", `{"model":"m","modalities":["text"],"messages":[{"role":"user","content":"hi"}]}`, false},
+		{"audio output", `{"model":"m","modalities":["text","audio"],"audio":{"voice":"alloy","format":"wav"},"messages":[{"role":"user","content":"hi"}]}`, true},
+		{"audio input", `{"model":"m","messages":[{"role":"user","content":[{"type":"text","text":"what is this"},{"type":"input_audio","input_audio":{"data":"UklG","format":"wav"}}]}]}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, err := BeforerOpenAI([]byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if before.audio != tt.want {
+				t.Errorf("audio = %v, want %v", before.audio, tt.want)
+			}
+		})
+	}
+}
+
+func TestProvidersWithMetaAudio(t *testing.T) {
+	db := setupChatDB(t)
+	ctx := context.Background()
+	model := models.Model{Name: "gpt-4o-audio"}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled, audio := true, true
+	for _, name := range []string{"text", "audio"} {
+		provider := models.Provider{Name: name, Type: consts.StyleOpenAI, Config: "{}"}
+		if err := db.Create(&provider).Error; err != nil {
+			t.Fatal(err)
+		}
+		mp := models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: model.Name, Status: &enabled, Weight: 1}
+		if name == "audio" {
+			mp.Audio = &audio
+		}
+		if err := db.Create(&mp).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		name  string
+		audio bool
+		want  []string
+	}{
+		{"text request uses all providers", false, []string{"text", "audio"}},
+		{"audio request uses audio providers", true, []string{"audio"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			meta, err := ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAI, Before{Model: model.Name, audio: tt.audio})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(meta.ModelWithProviderMap) != len(tt.want) {
+				t.Fatalf("got %d associations, want %v", len(meta.ModelWithProviderMap), tt.want)
+			}
+			for _, mp := range meta.ModelWithProviderMap {
+				if name := meta.ProviderMap[mp.ProviderID].Name; !strings.Contains(strings.Join(tt.want, ","), name) {
+					t.Errorf("unexpected provider %s", name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/balancers"
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+)
+
+// lightest 测试用策略 总是选择权重最小的项
+type lightest map[uint]int
+
+func (l lightest) Pop() (uint, error) {
+	var picked uint
+	for id, weight := range l {
+		if picked == 0 || weight < l[picked] || (weight == l[picked] && id < picked) {
+			picked = id
+		}
+	}
+	if picked == 0 {
+		return 0, errors.New("no items")
+	}
+	return picked, nil
+}
+
+func (l lightest) Delete(key uint) { delete(l, key) }
+
+func (l lightest) Reduce(key uint) {}
+
+func init() {
+	balancers.Register("test_lightest", func(items map[uint]int) balancers.Balancer {
+		l := make(lightest, len(items))
+		for id, weight := range items {
+			l[id] = weight
+		}
+		return l
+	})
+}
+
+func TestBalanceChatRegisteredStrategy(t *testing.T) {
+	tests := []struct {
+		strategy string
+		want     string
+	}{
+		{"test_lightest", "light"},
+		// 未注册的策略使用默认策略 仍能正常选择提供商
+		{"unknown_strategy", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			db := setupChatDB(t)
+			model := models.Model{Name: "gpt-4o", MaxRetry: 1, TimeOut: 10, Strategy: tt.strategy}
+			if err := db.Create(&model).Error; err != nil {
+				t.Fatal(err)
+			}
+			enabled := true
+			for _, p := range []struct {
+				name   string
+				weight int
+			}{{"heavy", 100}, {"light", 1}} {
+				provider := models.Provider{Name: p.name, Type: consts.StyleMock, Config: `{"body":{"choices":[{"message":{"content":"` + p.name + `"}}]}}`}
+				if err := db.Create(&provider).Error; err != nil {
+					t.Fatal(err)
+				}
+				if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: &enabled, Weight: p.weight}).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ctx := context.Background()
+			for range 5 {
+				before, err := BeforerOpenAI([]byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+				if err != nil {
+					t.Fatal(err)
+				}
+				meta, err := ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAI, *before)
+				if err != nil {
+					t.Fatal(err)
+				}
+				res, _, err := BalanceChat(ctx, time.Now(), consts.StyleOpenAI, *before, *meta, models.ReqMeta{Header: http.Header{}})
+				if err != nil {
+					t.Fatalf("BalanceChat() error = %v", err)
+				}
+				body, _ := io.ReadAll(res.Body)
+				res.Body.Close()
+				if tt.want != "" && !strings.Contains(string(body), tt.want) {
+					t.Fatalf("served %s, want %s", body, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
@@ -13,11 +14,129 @@ type Before struct {
 	toolCall         bool
 	structuredOutput bool
 	image            bool
+	completion       bool // legacy completions 请求 仅路由到支持的提供商
+	audio            bool // 包含音频输入或要求音频输出 仅路由到支持的提供商
+	prompt           string
+	endUser          string
+	seed             *int64
 	raw              []byte
+	clampedParams    []string // 被参数策略修正的参数
+	toolCount        int      // 工具定义数量
+	messageCount     int      // 消息条数 用于提供商请求规模限制
+	maxTokens        int64    // 请求的最大输出 token 未设置时为 0
+	thinkingBudget   int64    // 思考预算 OpenAI 请求按 reasoning_effort 折算 未开启时为 0
+	anomalies        []string // 超出异常阈值的项
+	requestedModel   string   // 按模型别名改写前的模型 未改写时为空
+	// 被上下文策略丢弃的消息条数
+	truncatedMessages int
+}
+
+// Prompt 返回请求中提取出的提示词文本，用于审核等转发前检查
+func (b Before) Prompt() string {
+	return b.prompt
+}
+
+// EndUser 返回请求中携带的终端用户标识，用于滥用追踪
+func (b Before) EndUser() string {
+	return b.endUser
+}
+
+// Seed 返回请求中的 seed，未设置时为 nil
+func (b Before) Seed() *int64 {
+	return b.seed
+}
+
+// ThinkingBudget 返回请求的思考预算 未开启思考时为 0
+func (b Before) ThinkingBudget() int64 {
+	return b.thinkingBudget
+}
+
+// Anomaly 返回请求被标记的异常原因 逗号分隔
+func (b Before) Anomaly() string {
+	return strings.Join(b.anomalies, ",")
+}
+
+// requestMaxTokens 返回请求中最大输出 token 字段的值 兼容各风格的字段名
+func requestMaxTokens(data []byte, paths ...string) int64 {
+	var maxTokens int64
+	for _, value := range gjson.GetManyBytes(data, paths...) {
+		if value.Int() > maxTokens {
+			maxTokens = value.Int()
+		}
+	}
+	return maxTokens
+}
+
+// openAIAudio 请求是否要求音频输出或在用户消息中包含音频输入
+func openAIAudio(data []byte) bool {
+	for _, modality := range gjson.GetBytes(data, "modalities").Array() {
+		if modality.String() == "audio" {
+			return true
+		}
+	}
+	for _, message := range gjson.GetBytes(data, "messages").Array() {
+		for _, block := range message.Get("content").Array() {
+			if block.Get("type").String() == "input_audio" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requestSeed 提取请求中的 seed 字段，缺省或非数字时返回 nil
+func requestSeed(data []byte) *int64 {
+	seed := gjson.GetBytes(data, "seed")
+	if seed.Type != gjson.Number {
+		return nil
+	}
+	value := seed.Int()
+	return &value
+}
+
+// openAIEndUser 提取 OpenAI 风格的终端用户标识 兼容 safety_identifier
+func openAIEndUser(data []byte) string {
+	if user := gjson.GetBytes(data, "user").String(); user != "" {
+		return user
+	}
+	return gjson.GetBytes(data, "safety_identifier").String()
 }
 
 type Beforer func(data []byte) (*Before, error)
 
+// appendText 收集 content 中的文本，兼容字符串与内容块数组两种形式
+func appendText(sb *strings.Builder, content gjson.Result) {
+	if content.Type == gjson.String {
+		if text := content.String(); text != "" {
+			sb.WriteString(text)
+			sb.WriteString("\n")
+		}
+		return
+	}
+	content.ForEach(func(_, block gjson.Result) bool {
+		if text := block.Get("text"); text.Exists() && text.String() != "" {
+			sb.WriteString(text.String())
+			sb.WriteString("\n")
+		}
+		return true
+	})
+}
+
+// messagesText 提取 messages 数组中所有消息的文本内容
+func messagesText(sb *strings.Builder, messages gjson.Result) {
+	messages.ForEach(func(_, value gjson.Result) bool {
+		appendText(sb, value.Get("content"))
+		return true
+	})
+}
+
+// includeStreamUsage 为processTee记录usage添加选项 PS:很多客户端只会开启stream 而不会开启include_usage
+func includeStreamUsage(data []byte) ([]byte, error) {
+	return sjson.SetBytes(data, "stream_options", struct {
+		IncludeUsage bool `json:"include_usage"`
+	}{IncludeUsage: true})
+}
+
 func BeforerOpenAI(data []byte) (*Before, error) {
 	model := gjson.GetBytes(data, "model").String()
 	if model == "" {
@@ -25,18 +144,15 @@ func BeforerOpenAI(data []byte) (*Before, error) {
 	}
 	stream := gjson.GetBytes(data, "stream").Bool()
 	if stream {
-		// 为processTee记录usage添加选项 PS:很多客户端只会开启stream 而不会开启include_usage
-		newData, err := sjson.SetBytes(data, "stream_options", struct {
-			IncludeUsage bool `json:"include_usage"`
-		}{IncludeUsage: true})
+		newData, err := includeStreamUsage(data)
 		if err != nil {
 			return nil, err
 		}
 		data = newData
 	}
 	var toolCall bool
-	tools := gjson.GetBytes(data, "tools")
-	if tools.Exists() && len(tools.Array()) != 0 {
+	toolCount := len(gjson.GetBytes(data, "tools").Array())
+	if toolCount != 0 {
 		toolCall = true
 	}
 	var structuredOutput bool
@@ -59,13 +175,60 @@ func BeforerOpenAI(data []byte) (*Before, error) {
 		}
 		return true
 	})
+	var prompt strings.Builder
+	messagesText(&prompt, gjson.GetBytes(data, "messages"))
 	return &Before{
 		Model:            model,
 		Stream:           stream,
 		toolCall:         toolCall,
 		structuredOutput: structuredOutput,
 		image:            image,
+		audio:            openAIAudio(data),
+		prompt:           prompt.String(),
+		endUser:          openAIEndUser(data),
+		seed:             requestSeed(data),
 		raw:              data,
+		toolCount:        toolCount,
+		messageCount:     len(gjson.GetBytes(data, "messages").Array()),
+		maxTokens:        requestMaxTokens(data, "max_tokens", "max_completion_tokens"),
+		thinkingBudget:   requestThinkingBudget(data, "reasoning_effort"),
+	}, nil
+}
+
+// BeforerOpenAICompletion 解析 legacy completions 请求 prompt 可为字符串或字符串数组 suffix 用于 FIM
+func BeforerOpenAICompletion(data []byte) (*Before, error) {
+	model := gjson.GetBytes(data, "model").String()
+	if model == "" {
+		return nil, errors.New("model is empty")
+	}
+	stream := gjson.GetBytes(data, "stream").Bool()
+	if stream {
+		newData, err := includeStreamUsage(data)
+		if err != nil {
+			return nil, err
+		}
+		data = newData
+	}
+	var prompt strings.Builder
+	promptField := gjson.GetBytes(data, "prompt")
+	if promptField.IsArray() {
+		promptField.ForEach(func(_, value gjson.Result) bool {
+			appendText(&prompt, value)
+			return true
+		})
+	} else {
+		appendText(&prompt, promptField)
+	}
+	appendText(&prompt, gjson.GetBytes(data, "suffix"))
+	return &Before{
+		Model:      model,
+		Stream:     stream,
+		completion: true,
+		prompt:     prompt.String(),
+		endUser:    openAIEndUser(data),
+		seed:       requestSeed(data),
+		raw:        data,
+		maxTokens:  requestMaxTokens(data, "max_tokens"),
 	}, nil
 }
 
@@ -76,8 +239,8 @@ func BeforerOpenAIRes(data []byte) (*Before, error) {
 	}
 	stream := gjson.GetBytes(data, "stream").Bool()
 	var toolCall bool
-	tools := gjson.GetBytes(data, "tools")
-	if tools.Exists() && len(tools.Array()) != 0 {
+	toolCount := len(gjson.GetBytes(data, "tools").Array())
+	if toolCount != 0 {
 		toolCall = true
 	}
 	var structuredOutput bool
@@ -100,13 +263,28 @@ func BeforerOpenAIRes(data []byte) (*Before, error) {
 		}
 		return true
 	})
+	var prompt strings.Builder
+	appendText(&prompt, gjson.GetBytes(data, "instructions"))
+	input := gjson.GetBytes(data, "input")
+	messageCount := len(input.Array())
+	if input.Type == gjson.String {
+		appendText(&prompt, input)
+	} else {
+		messagesText(&prompt, input)
+	}
 	return &Before{
 		Model:            model,
 		Stream:           stream,
 		toolCall:         toolCall,
 		structuredOutput: structuredOutput,
 		image:            image,
+		prompt:           prompt.String(),
+		endUser:          openAIEndUser(data),
 		raw:              data,
+		toolCount:        toolCount,
+		messageCount:     messageCount,
+		maxTokens:        requestMaxTokens(data, "max_output_tokens"),
+		thinkingBudget:   requestThinkingBudget(data, "reasoning.effort"),
 	}, nil
 }
 
@@ -117,8 +295,8 @@ func BeforerAnthropic(data []byte) (*Before, error) {
 	}
 	stream := gjson.GetBytes(data, "stream").Bool()
 	var toolCall bool
-	tools := gjson.GetBytes(data, "tools")
-	if tools.Exists() && len(tools.Array()) != 0 {
+	toolCount := len(gjson.GetBytes(data, "tools").Array())
+	if toolCount != 0 {
 		toolCall = true
 	}
 	var image bool
@@ -137,12 +315,21 @@ func BeforerAnthropic(data []byte) (*Before, error) {
 		}
 		return true
 	})
+	var prompt strings.Builder
+	appendText(&prompt, gjson.GetBytes(data, "system"))
+	messagesText(&prompt, gjson.GetBytes(data, "messages"))
 	return &Before{
 		Model:            model,
 		Stream:           stream,
 		toolCall:         toolCall,
 		structuredOutput: toolCall,
 		image:            image,
+		prompt:           prompt.String(),
+		endUser:          gjson.GetBytes(data, "metadata.user_id").String(),
 		raw:              data,
+		toolCount:        toolCount,
+		messageCount:     len(gjson.GetBytes(data, "messages").Array()),
+		maxTokens:        requestMaxTokens(data, "max_tokens"),
+		thinkingBudget:   requestThinkingBudget(data, ""),
 	}, nil
 }
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/samber/lo"
+)
+
+func TestBeforePrompt(t *testing.T) {
+	tests := []struct {
+		name    string
+		beforer Beforer
+		body    string
+		want    string
+	}{
+		{
+			name:    "openai",
+			beforer: BeforerOpenAI,
+			body:    `{"model":"m","messages":[{"role":"system","content":"sys"},{"role":"user","content":[{"type":"text","text":"hi"},{"type":"image_url","image_url":{"url":"x"}}]}]}`,
+			want:    "sys\nhi\n",
+		},
+		{
+			name:    "openai responses",
+			beforer: BeforerOpenAIRes,
+			body:    `{"model":"m","instructions":"ins","input":[{"role":"user","content":[{"type":"input_text","text":"hello"}]}]}`,
+			want:    "ins\nhello\n",
+		},
+		{
+			name:    "anthropic",
+			beforer: BeforerAnthropic,
+			body:    `{"model":"m","system":[{"type":"text","text":"sys"}],"messages":[{"role":"user","content":"hey"}]}`,
+			want:    "sys\nhey\n",
+		},
+		{
+			name:    "completion fim",
+			beforer: BeforerOpenAICompletion,
+			body:    `{"model":"m","prompt":"def add(a, b):","suffix":"return c"}`,
+			want:    "def add(a, b):\nreturn c\n",
+		},
+		{
+			name:    "completion prompt array",
+			beforer: BeforerOpenAICompletion,
+			body:    `{"model":"m","prompt":["one","two"]}`,
+			want:    "one\ntwo\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, err := tt.beforer([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if before.Prompt() != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, before.Prompt())
+			}
+		})
+	}
+}
+
+func TestBeforeEndUser(t *testing.T) {
+	tests := []struct {
+		name    string
+		beforer Beforer
+		body    string
+		want    string
+	}{
+		{name: "openai user", beforer: BeforerOpenAI, body: `{"model":"m","user":"u-1"}`, want: "u-1"},
+		{name: "openai safety identifier", beforer: BeforerOpenAI, body: `{"model":"m","safety_identifier":"u-2"}`, want: "u-2"},
+		{name: "responses user", beforer: BeforerOpenAIRes, body: `{"model":"m","user":"u-3"}`, want: "u-3"},
+		{name: "anthropic metadata", beforer: BeforerAnthropic, body: `{"model":"m","metadata":{"user_id":"u-4"}}`, want: "u-4"},
+		{name: "absent", beforer: BeforerAnthropic, body: `{"model":"m"}`, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, err := tt.beforer([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if before.EndUser() != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, before.EndUser())
+			}
+		})
+	}
+}
+
+func TestBeforeSeed(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want *int64
+	}{
+		{name: "set", body: `{"model":"m","seed":42}`, want: lo.ToPtr(int64(42))},
+		{name: "zero", body: `{"model":"m","seed":0}`, want: lo.ToPtr(int64(0))},
+		{name: "absent", body: `{"model":"m"}`},
+		{name: "null", body: `{"model":"m","seed":null}`},
+		{name: "not a number", body: `{"model":"m","seed":"42"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, err := BeforerOpenAI([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := before.Seed()
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+)
+
+// 预算降级方式 记录在日志的 BudgetShed 字段
+const (
+	BudgetShedPreferCheaper = "prefer_cheaper" // 按价格重新划分层级 便宜的提供商优先
+	BudgetShedCheapest      = "cheapest"       // 只保留价格最低的提供商
+)
+
+// defaultBudgetShedRatio 未设置时费用达到上限的 80% 开始优先选择更便宜的提供商
+const defaultBudgetShedRatio = 0.8
+
+// ErrBudgetExceeded auth key 的费用达到预算上限
+var ErrBudgetExceeded = errors.New("auth key budget exceeded")
+
+// ValidateBudgetPolicy 校验费用预算配置
+func ValidateBudgetPolicy(policy models.BudgetPolicy) error {
+	if policy.Limit < 0 {
+		return errors.New("budget limit must not be negative")
+	}
+	switch policy.Period {
+	case "", models.BudgetPeriodTotal, models.BudgetPeriodDaily, models.BudgetPeriodMonthly:
+	default:
+		return fmt.Errorf("unknown budget period: %s", policy.Period)
+	}
+	if policy.ShedRatio < 0 || policy.ShedRatio > 1 {
+		return errors.New("budget shed_ratio must be between 0 and 1")
+	}
+	switch policy.Exhausted {
+	case "", models.BudgetExhaustedBlock, models.BudgetExhaustedCheapest:
+	default:
+		return fmt.Errorf("unknown budget exhausted behavior: %s", policy.Exhausted)
+	}
+	return nil
+}
+
+// budgetPeriodStart 预算周期的起点 累计预算返回零值
+func budgetPeriodStart(period string, now time.Time) time.Time {
+	year, month, day := now.Date()
+	switch period {
+	case models.BudgetPeriodDaily:
+		return time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+	case models.BudgetPeriodMonthly:
+		return time.Date(year, month, 1, 0, 0, 0, 0, now.Location())
+	}
+	return time.Time{}
+}
+
+// BudgetSpend auth key 自 since 起的费用 影子流量不计入
+func BudgetSpend(ctx context.Context, authKeyID uint, since time.Time) (float64, error) {
+	var spend float64
+	err := models.DB.WithContext(ctx).Raw(`SELECT COALESCE(SUM(cost), 0) FROM chat_logs
+WHERE deleted_at IS NULL AND auth_key_id = ? AND COALESCE(shadow, 0) = 0 AND created_at >= ?`, authKeyID, since.Local()).Scan(&spend).Error
+	return spend, err
+}
+
+// ApplyBudget 按 auth key 已用费用的比例调整可选的提供商
+// 达到降级比例后按价格重新划分层级 达到上限后拒绝请求或只使用最便宜的提供商
+func ApplyBudget(ctx context.Context, providersWithMeta *ProvidersWithMeta) error {
+	authKeyID, _ := ctx.Value(consts.ContextKeyAuthKeyID).(uint)
+	policy, ok := ctx.Value(consts.ContextKeyBudget).(models.BudgetPolicy)
+	if !ok || authKeyID == 0 || policy.Limit <= 0 {
+		return nil
+	}
+	spend, err := BudgetSpend(ctx, authKeyID, budgetPeriodStart(policy.Period, time.Now()))
+	if err != nil {
+		return err
+	}
+	ratio := spend / policy.Limit
+	switch {
+	case ratio >= 1:
+		if policy.Exhausted != models.BudgetExhaustedCheapest {
+			return fmt.Errorf("%w: spent %.4f of %.4f", ErrBudgetExceeded, spend, policy.Limit)
+		}
+		keepCheapest(providersWithMeta)
+		providersWithMeta.BudgetShed = BudgetShedCheapest
+	case ratio >= cmp.Or(policy.ShedRatio, defaultBudgetShedRatio):
+		tierByPrice(providersWithMeta)
+		providersWithMeta.BudgetShed = BudgetShedPreferCheaper
+	}
+	return nil
+}
+
+// associationPrice 比较价格时使用的输入与输出单价之和
+func associationPrice(mp *models.ModelWithProvider) float64 {
+	return mp.InputPrice + mp.OutputPrice
+}
+
+// tierByPrice 以价格排名作为层级 同价的关联在同一层按权重选择
+func tierByPrice(providersWithMeta *ProvidersWithMeta) {
+	prices := make([]float64, 0, len(providersWithMeta.ModelWithProviderMap))
+	for _, mp := range providersWithMeta.ModelWithProviderMap {
+		prices = append(prices, associationPrice(mp))
+	}
+	slices.Sort(prices)
+	prices = slices.Compact(prices)
+	tiers := make(map[uint]int, len(providersWithMeta.ModelWithProviderMap))
+	for id, mp := range providersWithMeta.ModelWithProviderMap {
+		tiers[id], _ = slices.BinarySearch(prices, associationPrice(mp))
+	}
+	providersWithMeta.TierItems = tiers
+}
+
+// keepCheapest 只保留价格最低的关联
+func keepCheapest(providersWithMeta *ProvidersWithMeta) {
+	cheapest := -1.0
+	for _, mp := range providersWithMeta.ModelWithProviderMap {
+		if price := associationPrice(mp); cheapest < 0 || price < cheapest {
+			cheapest = price
+		}
+	}
+	for id, mp := range providersWithMeta.ModelWithProviderMap {
+		if associationPrice(mp) > cheapest {
+			delete(providersWithMeta.ModelWithProviderMap, id)
+			delete(providersWithMeta.WeightItems, id)
+			delete(providersWithMeta.TierItems, id)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+)
+
+func TestApplyBudgetShedding(t *testing.T) {
+	tests := []struct {
+		name      string
+		exhausted string
+		// 每次请求使用的提供商与降级方式 最后一次为空表示被拒绝
+		want []string
+	}{
+		{"block at limit", models.BudgetExhaustedBlock, []string{
+			"premium|", "premium|", "cheap|prefer_cheaper", "cheap|prefer_cheaper", "cheap|prefer_cheaper", "cheap|prefer_cheaper", "",
+		}},
+		{"cheapest at limit", models.BudgetExhaustedCheapest, []string{
+			"premium|", "premium|", "cheap|prefer_cheaper", "cheap|prefer_cheaper", "cheap|prefer_cheaper", "cheap|prefer_cheaper", "cheap|cheapest",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupChatDB(t)
+			model := models.Model{Name: "gpt-4o", MaxRetry: 1, TimeOut: 10}
+			if err := db.Create(&model).Error; err != nil {
+				t.Fatal(err)
+			}
+			// 每次请求输入输出各 10000 token premium 费用 0.5 cheap 费用 0.25
+			body := `{"body":{"model":"gpt-4o","choices":[{"message":{"role":"assistant","content":"ok"}}],"usage":{"prompt_tokens":10000,"completion_tokens":10000,"total_tokens":20000}}}`
+			enabled := true
+			for _, p := range []struct {
+				name           string
+				in, out        float64
+				configuredTier int
+			}{{"premium", 20, 30, 0}, {"cheap", 10, 15, 1}} {
+				provider := models.Provider{Name: p.name, Type: consts.StyleMock, Config: body}
+				if err := db.Create(&provider).Error; err != nil {
+					t.Fatal(err)
+				}
+				if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: &enabled,
+					Weight: 1, Tier: p.configuredTier, InputPrice: p.in, OutputPrice: p.out}).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ctx := context.WithValue(context.Background(), consts.ContextKeyAuthKeyID, uint(7))
+			ctx = context.WithValue(ctx, consts.ContextKeyBudget, models.BudgetPolicy{Limit: 2, ShedRatio: 0.5, Exhausted: tt.exhausted})
+			before, err := BeforerOpenAI([]byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, want := range tt.want {
+				meta, err := ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAI, *before)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := ApplyBudget(ctx, meta); err != nil {
+					if want != "" || !errors.Is(err, ErrBudgetExceeded) {
+						t.Fatalf("request %d: ApplyBudget() error = %v, want %q", i, err, want)
+					}
+					continue
+				}
+				start := time.Now()
+				res, logID, err := BalanceChat(ctx, start, consts.StyleOpenAI, *before, *meta, models.ReqMeta{Header: http.Header{}})
+				if err != nil {
+					t.Fatalf("request %d: BalanceChat() error = %v", i, err)
+				}
+				RecordLog(CopyStreamContext(res.Request.Context()), start, res.Body, ProcesserOpenAI, logID, *before, false)
+
+				var log models.ChatLog
+				if err := db.First(&log, logID).Error; err != nil {
+					t.Fatal(err)
+				}
+				if got := fmt.Sprintf("%s|%s", log.ProviderName, log.BudgetShed); got != want {
+					t.Errorf("request %d = %s, want %s", i, got, want)
+				}
+				wantCost := map[string]float64{"premium": 0.5, "cheap": 0.25}[log.ProviderName]
+				if log.Cost != wantCost || log.AuthKeyID != 7 {
+					t.Errorf("request %d cost = %v auth key = %d, want %v", i, log.Cost, log.AuthKeyID, wantCost)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyBudgetPeriod(t *testing.T) {
+	db := setupChatDB(t)
+	now := time.Now()
+	for _, log := range []models.ChatLog{
+		{AuthKeyID: 3, Cost: 5},
+		{AuthKeyID: 3, Cost: 1, Shadow: true},
+		{AuthKeyID: 4, Cost: 9},
+	} {
+		if err := db.Create(&log).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 上个周期的费用不计入按天的预算
+	old := models.ChatLog{AuthKeyID: 3, Cost: 7}
+	old.CreatedAt = budgetPeriodStart(models.BudgetPeriodDaily, now).Add(-time.Minute)
+	if err := db.Create(&old).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		period  string
+		wantErr bool
+	}{
+		{models.BudgetPeriodTotal, true},
+		{models.BudgetPeriodDaily, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), consts.ContextKeyAuthKeyID, uint(3))
+			ctx = context.WithValue(ctx, consts.ContextKeyBudget, models.BudgetPolicy{Limit: 10, Period: tt.period, ShedRatio: 1})
+			err := ApplyBudget(ctx, &ProvidersWithMeta{})
+			if errors.Is(err, ErrBudgetExceeded) != tt.wantErr {
+				t.Errorf("ApplyBudget() error = %v, want exceeded %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateBudgetPolicy(t *testing.T) {
+	tests := []struct {
+		policy  models.BudgetPolicy
+		wantErr bool
+	}{
+		{models.BudgetPolicy{}, false},
+		{models.BudgetPolicy{Limit: 10, Period: models.BudgetPeriodMonthly, ShedRatio: 0.9, Exhausted: models.BudgetExhaustedCheapest}, false},
+		{models.BudgetPolicy{Limit: -1}, true},
+		{models.BudgetPolicy{Limit: 1, Period: "weekly"}, true},
+		{models.BudgetPolicy{Limit: 1, ShedRatio: 1.5}, true},
+		{models.BudgetPolicy{Limit: 1, Exhausted: "ignore"}, true},
+	}
+	for _, tt := range tests {
+		if err := ValidateBudgetPolicy(tt.policy); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateBudgetPolicy(%+v) error = %v, wantErr %v", tt.policy, err, tt.wantErr)
+		}
+	}
+}
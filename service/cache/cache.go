@@ -4,17 +4,19 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"slices"
 	"sync"
 	"time"
 )
 
 // Scope 定义缓存作用域，确保多租户隔离
 type Scope struct {
-	AuthKeyID uint   `json:"auth_key_id"`
-	Style     string `json:"style"`     // API风格：OpenAI/Anthropic/OpenAIRes
-	Model     string `json:"model"`
-	Mode      string `json:"mode"`
-	Stream    bool   `json:"stream"`
+	AuthKeyID     uint   `json:"auth_key_id"`
+	Style         string `json:"style"` // API风格：OpenAI/Anthropic/OpenAIRes
+	Model         string `json:"model"`
+	Mode          string `json:"mode"`
+	Stream        bool   `json:"stream"`
+	ConfigVersion string `json:"config_version"` // 模型参数与改写配置的指纹 配置变化后旧缓存不再命中
 }
 
 // Key 表示缓存键，由作用域和请求体哈希组成
@@ -44,7 +46,9 @@ type Value struct {
 	Header        http.Header `json:"header"`
 	Body          []byte      `json:"body"`
 	CreatedAt     time.Time   `json:"created_at"`
-	ExpiresAt     time.Time   `json:"expires_at"`
+	StaleAt       time.Time   `json:"stale_at"`   // 软过期 之后到 ExpiresAt 之间返回的值标记为过时
+	ExpiresAt     time.Time   `json:"expires_at"` // 硬过期 之后不再命中
+	Stale         bool        `json:"stale"`      // 读取时已过软过期 调用方应在后台刷新
 
 	// 审计相关字段
 	SourceLogID   uint        `json:"source_log_id"`   // 最初生成缓存的日志ID
@@ -61,8 +65,8 @@ type Cache interface {
 	// Get 获取缓存数据，返回值、是否命中、错误
 	Get(ctx context.Context, key Key) (*Value, bool, error)
 
-	// Set 设置缓存数据，指定TTL
-	Set(ctx context.Context, key Key, value *Value, ttl time.Duration) error
+	// Set 设置缓存数据，ttl 后软过期，再过 grace 后硬过期
+	Set(ctx context.Context, key Key, value *Value, ttl, grace time.Duration) error
 
 	// DeleteByAuthKey 按AuthKeyID清空对应租户的所有缓存
 	DeleteByAuthKey(ctx context.Context, authKeyID uint) error
@@ -72,6 +76,9 @@ type Cache interface {
 
 	// Stats 获取缓存统计信息
 	Stats() CacheStats
+
+	// List 列出未硬过期的缓存条目 按创建时间从新到旧排列 不包含响应体
+	List(ctx context.Context) ([]EntryInfo, error)
 }
 
 // CacheStats 缓存统计信息
@@ -81,10 +88,28 @@ type CacheStats struct {
 	MissCount int `json:"miss_count"`
 }
 
+// bodyHashPrefixLen 条目列表中展示的请求体哈希长度
+const bodyHashPrefixLen = 12
+
+// EntryInfo 缓存条目的概要信息 剩余时间按列出时计算 单位秒
+type EntryInfo struct {
+	Scope     Scope     `json:"scope"`
+	BodyHash  string    `json:"body_hash"` // 请求体哈希前缀
+	CreatedAt time.Time `json:"created_at"`
+	StaleAt   time.Time `json:"stale_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	TTL       int64     `json:"ttl"`        // 距软过期的剩余时间 已软过期时为 0
+	ExpiresIn int64     `json:"expires_in"` // 距硬过期的剩余时间
+	Stale     bool      `json:"stale"`
+	Size      int       `json:"size"` // 响应体字节数
+	Hits      int       `json:"hits"`
+}
+
 // entry 内存缓存的单条记录
 type entry struct {
 	key   Key
 	value *Value
+	hits  int // 命中次数 覆盖写入后重新计数
 }
 
 // MemoryCache 线程安全的内存缓存实现
@@ -96,6 +121,7 @@ type MemoryCache struct {
 	shareThreshold int
 	hitCount       int
 	missCount      int
+	now            func() time.Time
 }
 
 const (
@@ -124,13 +150,14 @@ func NewMemoryCacheWithOptions(opts Options) *MemoryCache {
 		maxEntries:     opts.MaxEntries,
 		readPolicy:     opts.ReadPolicy,
 		shareThreshold: opts.ShareThreshold,
+		now:            time.Now,
 	}
 }
 
 // Get 获取缓存数据，自动处理过期清理
 func (c *MemoryCache) Get(ctx context.Context, key Key) (*Value, bool, error) {
 	mapKey := c.makeMapKey(key)
-	now := time.Now()
+	now := c.now()
 
 	c.mu.RLock()
 	e, exists := c.data[mapKey]
@@ -157,8 +184,15 @@ func (c *MemoryCache) Get(ctx context.Context, key Key) (*Value, bool, error) {
 
 	c.mu.Lock()
 	c.hitCount++
+	if e, exists := c.data[mapKey]; exists {
+		e.hits++
+		c.data[mapKey] = e
+	}
 	c.mu.Unlock()
 
+	// 处于宽限期内仍然命中 由调用方决定是否刷新
+	stale := !e.value.StaleAt.IsZero() && now.After(e.value.StaleAt)
+
 	// 根据策略决定是否共享引用
 	shareAllowed := c.readPolicy == ReadPolicyShareReadOnly
 	bigEnough := c.shareThreshold <= 0 || len(e.value.Body) >= c.shareThreshold
@@ -167,29 +201,36 @@ func (c *MemoryCache) Get(ctx context.Context, key Key) (*Value, bool, error) {
 		// 只读共享模式：直接返回引用，标记为共享
 		shared := *e.value
 		shared.Shared = true
+		shared.Stale = stale
 		return &shared, true, nil
 	}
 
 	// 深拷贝模式：返回副本，避免调用方修改内部状态
 	cloned := c.cloneValue(e.value)
 	cloned.Shared = false
+	cloned.Stale = stale
 	return cloned, true, nil
 }
 
-// Set 设置缓存数据
-func (c *MemoryCache) Set(ctx context.Context, key Key, value *Value, ttl time.Duration) error {
+// Set 设置缓存数据 grace 为软过期后仍可返回旧值的时长
+func (c *MemoryCache) Set(ctx context.Context, key Key, value *Value, ttl, grace time.Duration) error {
 	if value == nil {
 		return fmt.Errorf("cache value cannot be nil")
 	}
 
-	now := time.Now()
+	now := c.now()
 	if value.CreatedAt.IsZero() {
 		value.CreatedAt = now
 	}
 	if ttl <= 0 {
 		ttl = DefaultTTL
 	}
-	value.ExpiresAt = now.Add(ttl)
+	if grace < 0 {
+		grace = 0
+	}
+	value.StaleAt = now.Add(ttl)
+	value.ExpiresAt = value.StaleAt.Add(grace)
+	value.Stale = false
 
 	mapKey := c.makeMapKey(key)
 	stored := c.cloneValue(value)
@@ -247,6 +288,49 @@ func (c *MemoryCache) Stats() CacheStats {
 	}
 }
 
+// List 列出未硬过期的缓存条目
+func (c *MemoryCache) List(ctx context.Context) ([]EntryInfo, error) {
+	now := c.now()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	list := make([]EntryInfo, 0, len(c.data))
+	for _, e := range c.data {
+		v := e.value
+		if !v.ExpiresAt.IsZero() && now.After(v.ExpiresAt) {
+			continue
+		}
+		hash := e.key.BodyHash
+		if len(hash) > bodyHashPrefixLen {
+			hash = hash[:bodyHashPrefixLen]
+		}
+		list = append(list, EntryInfo{
+			Scope:     e.key.Scope,
+			BodyHash:  hash,
+			CreatedAt: v.CreatedAt,
+			StaleAt:   v.StaleAt,
+			ExpiresAt: v.ExpiresAt,
+			TTL:       remainingSeconds(v.StaleAt, now),
+			ExpiresIn: remainingSeconds(v.ExpiresAt, now),
+			Stale:     !v.StaleAt.IsZero() && now.After(v.StaleAt),
+			Size:      len(v.Body),
+			Hits:      e.hits,
+		})
+	}
+	slices.SortFunc(list, func(a, b EntryInfo) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return list, nil
+}
+
+// remainingSeconds 距 t 的剩余秒数 向上取整 已过或未设置时为 0
+func remainingSeconds(t, now time.Time) int64 {
+	if t.IsZero() || !t.After(now) {
+		return 0
+	}
+	return int64((t.Sub(now) + time.Second - 1) / time.Second)
+}
+
 // makeMapKey 将结构化Key转为map使用的字符串键
 func (c *MemoryCache) makeMapKey(key Key) string {
 	s := key.Scope
@@ -282,7 +366,9 @@ func (v *Value) Clone() *Value {
 		Header:        cloneHeader(v.Header),
 		Body:          make([]byte, len(v.Body)),
 		CreatedAt:     v.CreatedAt,
+		StaleAt:       v.StaleAt,
 		ExpiresAt:     v.ExpiresAt,
+		Stale:         v.Stale,
 		SourceLogID:   v.SourceLogID,
 		Usage:         v.Usage,
 		ProviderName:  v.ProviderName,
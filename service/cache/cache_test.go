@@ -0,0 +1,168 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheStaleWhileRevalidate(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	key := Key{Scope: Scope{AuthKeyID: 1, Style: "openai", Model: "gpt-4o"}, BodyHash: "h"}
+
+	tests := []struct {
+		name      string
+		grace     time.Duration
+		elapsed   time.Duration
+		wantHit   bool
+		wantStale bool
+	}{
+		{"fresh", time.Minute, 30 * time.Second, true, false},
+		{"soft expiry boundary is fresh", time.Minute, time.Minute, true, false},
+		{"stale within grace", time.Minute, 90 * time.Second, true, true},
+		{"expired after grace", time.Minute, 2*time.Minute + time.Second, false, false},
+		{"no grace expires at ttl", 0, time.Minute + time.Second, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := start
+			c := NewMemoryCache(4)
+			c.now = func() time.Time { return now }
+
+			ctx := context.Background()
+			if err := c.Set(ctx, key, &Value{StatusCode: 200, Body: []byte("v1")}, time.Minute, tt.grace); err != nil {
+				t.Fatal(err)
+			}
+			now = start.Add(tt.elapsed)
+			got, hit, err := c.Get(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hit != tt.wantHit {
+				t.Fatalf("hit = %v, want %v", hit, tt.wantHit)
+			}
+			if !hit {
+				if c.Stats().Entries != 0 {
+					t.Errorf("expired entry not removed")
+				}
+				return
+			}
+			if got.Stale != tt.wantStale {
+				t.Errorf("stale = %v, want %v", got.Stale, tt.wantStale)
+			}
+		})
+	}
+}
+
+func TestMemoryCacheSetRefreshesStaleEntry(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	c := NewMemoryCache(4)
+	c.now = func() time.Time { return now }
+	key := Key{Scope: Scope{AuthKeyID: 1, Model: "gpt-4o"}, BodyHash: "h"}
+	ctx := context.Background()
+
+	if err := c.Set(ctx, key, &Value{Body: []byte("v1")}, time.Minute, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	now = start.Add(90 * time.Second)
+	if got, hit, _ := c.Get(ctx, key); !hit || !got.Stale {
+		t.Fatalf("expected stale hit, got hit=%v", hit)
+	}
+
+	// 刷新覆盖后重新计算软过期与硬过期
+	if err := c.Set(ctx, key, &Value{Body: []byte("v2")}, time.Minute, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	got, hit, _ := c.Get(ctx, key)
+	if !hit || got.Stale || string(got.Body) != "v2" {
+		t.Fatalf("got hit=%v value=%+v, want fresh v2", hit, got)
+	}
+	if want := now.Add(time.Minute); !got.StaleAt.Equal(want) || !got.ExpiresAt.Equal(want.Add(time.Minute)) {
+		t.Errorf("stale at %v expires at %v", got.StaleAt, got.ExpiresAt)
+	}
+}
+
+func TestMemoryCacheList(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	c := NewMemoryCache(8)
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	fresh := Key{Scope: Scope{AuthKeyID: 1, Style: "openai", Model: "gpt-4o"}, BodyHash: "0123456789abcdef0123"}
+	stale := Key{Scope: Scope{AuthKeyID: 2, Style: "anthropic", Model: "claude"}, BodyHash: "short"}
+	expired := Key{Scope: Scope{AuthKeyID: 3, Model: "gpt-4o-mini"}, BodyHash: "gone"}
+	if err := c.Set(ctx, expired, &Value{Body: []byte("x")}, 10*time.Second, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(ctx, stale, &Value{Body: []byte("stale")}, 30*time.Second, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	now = start.Add(time.Second)
+	if err := c.Set(ctx, fresh, &Value{Body: []byte("fresh body")}, 5*time.Minute, 0); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, hit, _ := c.Get(ctx, fresh); !hit {
+			t.Fatal("expected hit")
+		}
+	}
+
+	now = start.Add(40*time.Second + 500*time.Millisecond)
+	list, err := c.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("List() returned %d entries, want 2 (expired excluded)", len(list))
+	}
+	tests := []struct {
+		got       EntryInfo
+		hash      string
+		ttl       int64
+		expiresIn int64
+		stale     bool
+		size      int
+		hits      int
+	}{
+		// 最新写入的排在前面 剩余时间向上取整
+		{list[0], "0123456789ab", 261, 261, false, 10, 2},
+		{list[1], "short", 0, 50, true, 5, 0},
+	}
+	for _, tt := range tests {
+		e := tt.got
+		if e.BodyHash != tt.hash || e.TTL != tt.ttl || e.ExpiresIn != tt.expiresIn || e.Stale != tt.stale || e.Size != tt.size || e.Hits != tt.hits {
+			t.Errorf("entry = %+v, want hash %s ttl %d expires_in %d stale %v size %d hits %d",
+				e, tt.hash, tt.ttl, tt.expiresIn, tt.stale, tt.size, tt.hits)
+		}
+	}
+
+	// 覆盖写入后命中次数重新计算
+	if err := c.Set(ctx, fresh, &Value{Body: []byte("v2")}, time.Minute, 0); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := c.List(ctx); list[0].Hits != 0 {
+		t.Errorf("hits after overwrite = %d, want 0", list[0].Hits)
+	}
+}
+
+func TestRemainingSeconds(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		t    time.Time
+		want int64
+	}{
+		{"unset", time.Time{}, 0},
+		{"past", now.Add(-time.Second), 0},
+		{"now", now, 0},
+		{"partial second rounds up", now.Add(1500 * time.Millisecond), 2},
+		{"exact", now.Add(time.Minute), 60},
+	}
+	for _, tt := range tests {
+		if got := remainingSeconds(tt.t, now); got != tt.want {
+			t.Errorf("%s: remainingSeconds() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -7,18 +7,41 @@ import (
 	"encoding/json"
 	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
 	"github.com/atopos31/llmio/service/cache"
 )
 
 // BuildCacheKey 构造缓存键，确保按AuthKeyID与关键参数进行隔离
-// 返回ok=false表示本次请求不参与缓存
-func BuildCacheKey(ctx context.Context, style string, before Before) (cache.Key, bool) {
+// policy 为模型的缓存策略，sampling 为按采样参数的缓存限制，configVersion 为模型配置指纹，返回ok=false表示本次请求不参与缓存
+func BuildCacheKey(ctx context.Context, style string, before Before, policy string, sampling models.CacheSampling, configVersion string) (cache.Key, bool) {
 	var empty cache.Key
 
-	// 仅对非stream请求做缓存，避免复杂的SSE重放问题
-	if before.Stream {
+	// auth key 禁用缓存时优先于模型的缓存策略
+	if noCache, _ := ctx.Value(consts.ContextKeyNoCache).(bool); noCache {
+		return empty, false
+	}
+
+	if policy == "" {
+		policy = consts.CachePolicyDefault
+	}
+	switch policy {
+	case consts.CachePolicyNever:
+		return empty, false
+	case consts.CachePolicyNonStream:
+		if before.Stream {
+			return empty, false
+		}
+	case consts.CachePolicyAlways:
+		// 流式与非流式通过 Scope.Stream 区分，互不命中
+	default:
+		return empty, false
+	}
+
+	// 高随机性的请求默认不缓存 reduce 模式仍缓存但缩短有效期
+	if highRandomness(before, sampling) && sampling.Mode != models.CacheSamplingReduce {
 		return empty, false
 	}
 
@@ -41,11 +64,12 @@ func BuildCacheKey(ctx context.Context, style string, before Before) (cache.Key,
 
 	key := cache.Key{
 		Scope: cache.Scope{
-			AuthKeyID: authKeyID,
-			Style:     style,
-			Model:     before.Model,
-			Mode:      mode,
-			Stream:    before.Stream,
+			AuthKeyID:     authKeyID,
+			Style:         style,
+			Model:         before.Model,
+			Mode:          mode,
+			Stream:        before.Stream,
+			ConfigVersion: configVersion,
 		},
 		BodyHash: bodyHash,
 	}
@@ -53,6 +77,55 @@ func BuildCacheKey(ctx context.Context, style string, before Before) (cache.Key,
 	return key, true
 }
 
+// ConfigVersion 模型中影响响应内容的配置指纹 包括参数范围与各关联的响应改写规则
+// 只用于缓存键 按关联 ID 排序保证稳定
+func (p ProvidersWithMeta) ConfigVersion() string {
+	type association struct {
+		ID              uint                  `json:"id"`
+		ResponseRules   []models.ResponseRule `json:"response_rules"`
+		TransformStream bool                  `json:"transform_stream"`
+	}
+	associations := make([]association, 0, len(p.ModelWithProviderMap))
+	for id, mp := range p.ModelWithProviderMap {
+		associations = append(associations, association{
+			ID:              id,
+			ResponseRules:   mp.ResponseRules,
+			TransformStream: mp.TransformStream != nil && *mp.TransformStream,
+		})
+	}
+	sort.Slice(associations, func(i, j int) bool { return associations[i].ID < associations[j].ID })
+	data, err := json.Marshal(struct {
+		ParamPolicy   models.ParamPolicy   `json:"param_policy"`
+		ContextPolicy models.ContextPolicy `json:"context_policy"`
+		Associations  []association        `json:"associations"`
+	}{p.ParamPolicy, p.ContextPolicy, associations})
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// CacheControl 请求头 Cache-Control 中影响网关缓存的指令
+type CacheControl struct {
+	NoStore bool // 本次请求不读取也不写入缓存
+	NoCache bool // 跳过缓存读取，重新请求并覆盖已缓存的结果
+}
+
+// ParseCacheControl 解析 Cache-Control 请求头，忽略无关指令
+func ParseCacheControl(header string) CacheControl {
+	var cc CacheControl
+	for _, directive := range strings.Split(header, ",") {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "no-store":
+			cc.NoStore = true
+		case "no-cache":
+			cc.NoCache = true
+		}
+	}
+	return cc
+}
+
 // normalizeAndHashRequestBody 规范化请求体并生成哈希
 func normalizeAndHashRequestBody(rawBody []byte) (string, error) {
 	var raw map[string]interface{}
@@ -153,6 +226,8 @@ func determineMode(style string) string {
 		return "chat_completions"
 	case consts.StyleOpenAIRes:
 		return "responses"
+	case consts.StyleOpenAICompletion:
+		return "completions"
 	case consts.StyleAnthropic:
 		return "messages"
 	default:
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service/cache"
+)
+
+func TestBuildCacheKeyPolicy(t *testing.T) {
+	ctx := context.WithValue(context.Background(), consts.ContextKeyAuthKeyID, uint(1))
+
+	tests := []struct {
+		name   string
+		policy string
+		stream bool
+		want   bool
+	}{
+		{"default non-stream", "", false, true},
+		{"default stream", "", true, false},
+		{"non_stream non-stream", consts.CachePolicyNonStream, false, true},
+		{"non_stream stream", consts.CachePolicyNonStream, true, false},
+		{"never", consts.CachePolicyNever, false, false},
+		{"always non-stream", consts.CachePolicyAlways, false, true},
+		{"always stream", consts.CachePolicyAlways, true, true},
+		{"unknown", "sometimes", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, err := BeforerOpenAI([]byte(`{"model":"m","messages":[{"role":"user","content":"hi"}],"temperature":0,"stream":` + map[bool]string{true: "true", false: "false"}[tt.stream] + `}`))
+			if err != nil {
+				t.Fatalf("BeforerOpenAI() error = %v", err)
+			}
+			key, ok := BuildCacheKey(ctx, consts.StyleOpenAI, *before, tt.policy, models.CacheSampling{}, "")
+			if ok != tt.want {
+				t.Fatalf("BuildCacheKey() ok = %v, want %v", ok, tt.want)
+			}
+			if ok && key.Scope.Stream != tt.stream {
+				t.Errorf("Scope.Stream = %v, want %v", key.Scope.Stream, tt.stream)
+			}
+		})
+	}
+
+	// 没有 AuthKeyID 时不缓存
+	before, _ := BeforerOpenAI([]byte(`{"model":"m","messages":[]}`))
+	if _, ok := BuildCacheKey(context.Background(), consts.StyleOpenAI, *before, consts.CachePolicyAlways, models.CacheSampling{}, ""); ok {
+		t.Error("BuildCacheKey() without auth key should not cache")
+	}
+	// auth key 禁用缓存时任何策略都不缓存
+	if _, ok := BuildCacheKey(context.WithValue(ctx, consts.ContextKeyNoCache, true), consts.StyleOpenAI, *before, consts.CachePolicyAlways, models.CacheSampling{}, ""); ok {
+		t.Error("BuildCacheKey() with no-cache auth key should not cache")
+	}
+}
+
+func TestCacheKeyConfigVersion(t *testing.T) {
+	ctx := context.WithValue(context.Background(), consts.ContextKeyAuthKeyID, uint(1))
+	before, err := BeforerOpenAI([]byte(`{"model":"m","messages":[{"role":"user","content":"hi"}],"temperature":0}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upper := 1.0
+	rules := []models.ResponseRule{{Type: models.ResponseRuleDelete, Path: "usage"}}
+	newMeta := func(policy models.ParamPolicy, rules []models.ResponseRule) ProvidersWithMeta {
+		return ProvidersWithMeta{
+			ParamPolicy: policy,
+			ModelWithProviderMap: map[uint]*models.ModelWithProvider{
+				1: {ResponseRules: rules},
+				2: {},
+			},
+		}
+	}
+	base := newMeta(models.ParamPolicy{}, nil)
+	keyOf := func(meta ProvidersWithMeta) cache.Key {
+		t.Helper()
+		key, ok := BuildCacheKey(ctx, consts.StyleOpenAI, *before, "", meta.CacheSampling, meta.ConfigVersion())
+		if !ok {
+			t.Fatal("BuildCacheKey() ok = false")
+		}
+		return key
+	}
+
+	// 相同配置多次计算结果一致
+	if keyOf(base) != keyOf(newMeta(models.ParamPolicy{}, nil)) {
+		t.Error("same config produced different cache keys")
+	}
+	for name, meta := range map[string]ProvidersWithMeta{
+		"param bounds":   newMeta(models.ParamPolicy{Bounds: map[string]models.ParamBound{"temperature": {Max: &upper}}}, nil),
+		"param mode":     newMeta(models.ParamPolicy{Mode: models.ParamPolicyReject}, nil),
+		"response rules": newMeta(models.ParamPolicy{}, rules),
+	} {
+		if keyOf(meta) == keyOf(base) {
+			t.Errorf("changing %s kept the same cache key", name)
+		}
+	}
+}
+
+func TestParseCacheControl(t *testing.T) {
+	tests := []struct {
+		header string
+		want   CacheControl
+	}{
+		{"", CacheControl{}},
+		{"no-store", CacheControl{NoStore: true}},
+		{"No-Cache", CacheControl{NoCache: true}},
+		{"max-age=0, no-cache, no-store", CacheControl{NoStore: true, NoCache: true}},
+		{"max-age=60", CacheControl{}},
+	}
+	for _, tt := range tests {
+		if got := ParseCacheControl(tt.header); got != tt.want {
+			t.Errorf("ParseCacheControl(%q) = %+v, want %+v", tt.header, got, tt.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"strings"
 
 	"github.com/atopos31/llmio/consts"
 	"github.com/atopos31/llmio/models"
@@ -9,9 +10,11 @@ import (
 )
 
 // RecordCacheHit 记录缓存命中的审计日志
-func RecordCacheHit(ctx context.Context, cacheKey cache.Key, cached *cache.Value, reqMeta models.ReqMeta) {
-	// 异步记录，不阻塞响应
+func RecordCacheHit(ctx context.Context, cacheKey cache.Key, cached *cache.Value, reqMeta models.ReqMeta, before Before) {
+	// 异步记录，不阻塞响应 退出前与其他日志记录一同等待完成
+	recordLogs.Add(1)
 	go func() {
+		defer recordLogs.Done()
 		defer func() {
 			if r := recover(); r != nil {
 				// 记录日志失败不应影响主流程，仅记录错误
@@ -24,18 +27,24 @@ func RecordCacheHit(ctx context.Context, cacheKey cache.Key, cached *cache.Value
 
 		// 构建缓存命中日志
 		log := models.ChatLog{
-			Name:          cacheKey.Scope.Model,
-			ProviderModel: cached.ProviderModel,
-			ProviderName:  cached.ProviderName,
-			Status:        "success",
-			Style:         cacheKey.Scope.Style,
-			UserAgent:     reqMeta.UserAgent,
-			RemoteIP:      reqMeta.RemoteIP,
-			AuthKeyID:     authKeyID,
-			ChatIO:        false, // 缓存命中不记录IO
-			Size:          len(cached.Body),
-			Cached:        true,
+			Name:            cacheKey.Scope.Model,
+			ProviderModel:   cached.ProviderModel,
+			ProviderName:    cached.ProviderName,
+			Status:          "success",
+			Style:           cacheKey.Scope.Style,
+			UserAgent:       reqMeta.UserAgent,
+			RemoteIP:        reqMeta.RemoteIP,
+			AuthKeyID:       authKeyID,
+			EndUser:         before.EndUser(),
+			RequestedModel:  before.RequestedModel(),
+			Seed:            before.Seed(),
+			ChatIO:          false, // 缓存命中不记录IO
+			Size:            len(cached.Body),
+			Cached:          true,
 			CachedFromLogID: &cached.SourceLogID,
+			ClampedParams:   strings.Join(before.clampedParams, ","),
+			Anomaly:         before.Anomaly(),
+			ThinkingBudget:  before.ThinkingBudget(),
 		}
 
 		// 复制Usage信息（如果存在）
@@ -60,4 +69,4 @@ func RecordCacheWrite(logID uint, usage models.Usage, providerName, providerMode
 		ProviderName:  providerName,
 		ProviderModel: providerModel,
 	}
-}
\ No newline at end of file
+}
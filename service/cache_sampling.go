@@ -0,0 +1,79 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// DefaultCacheMaxTemperature temperature 高于该值的请求默认不缓存
+	DefaultCacheMaxTemperature = 0.5
+	// DefaultCacheReducedTTL reduce 模式下默认的缓存有效期
+	DefaultCacheReducedTTL = time.Minute
+	// DefaultCacheAssumedTemperature 请求未传入 temperature 时假定的值 与 OpenAI 与 Anthropic 的默认值一致
+	DefaultCacheAssumedTemperature = 1.0
+)
+
+// ValidateCacheSampling 校验模型的采样缓存配置
+func ValidateCacheSampling(sampling models.CacheSampling) error {
+	switch sampling.Mode {
+	case "", models.CacheSamplingSkip, models.CacheSamplingReduce, models.CacheSamplingOff:
+	default:
+		return fmt.Errorf("invalid cache sampling mode: %s", sampling.Mode)
+	}
+	if sampling.MaxTemperature != nil && *sampling.MaxTemperature < 0 {
+		return errors.New("cache sampling max_temperature must not be negative")
+	}
+	if sampling.MaxTopP != nil && (*sampling.MaxTopP < 0 || *sampling.MaxTopP > 1) {
+		return errors.New("cache sampling max_top_p must be between 0 and 1")
+	}
+	if sampling.ReducedTTL < 0 {
+		return errors.New("cache sampling reduced_ttl must not be negative")
+	}
+	if sampling.DefaultTemperature != nil && *sampling.DefaultTemperature < 0 {
+		return errors.New("cache sampling default_temperature must not be negative")
+	}
+	return nil
+}
+
+// highRandomness 请求的采样参数是否随机性过高 未传入 temperature 时按假定的默认值判断
+func highRandomness(before Before, sampling models.CacheSampling) bool {
+	if sampling.Mode == models.CacheSamplingOff {
+		return false
+	}
+	temperature := DefaultCacheAssumedTemperature
+	if sampling.DefaultTemperature != nil {
+		temperature = *sampling.DefaultTemperature
+	}
+	if value := gjson.GetBytes(before.raw, "temperature"); value.Type == gjson.Number {
+		temperature = value.Float()
+	}
+	maxTemperature := DefaultCacheMaxTemperature
+	if sampling.MaxTemperature != nil {
+		maxTemperature = *sampling.MaxTemperature
+	}
+	if temperature <= maxTemperature {
+		return false
+	}
+	if topP := gjson.GetBytes(before.raw, "top_p"); sampling.MaxTopP != nil && topP.Type == gjson.Number && topP.Float() <= *sampling.MaxTopP {
+		return false
+	}
+	return true
+}
+
+// SamplingCacheTTL reduce 模式下高随机性请求的缓存有效期不超过配置值 其余请求使用原有效期
+func SamplingCacheTTL(before Before, sampling models.CacheSampling, ttl CacheTTL) CacheTTL {
+	if sampling.Mode != models.CacheSamplingReduce || !highRandomness(before, sampling) {
+		return ttl
+	}
+	reduced := DefaultCacheReducedTTL
+	if sampling.ReducedTTL > 0 {
+		reduced = time.Duration(sampling.ReducedTTL) * time.Second
+	}
+	ttl.TTL = min(ttl.TTL, reduced)
+	return ttl
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+)
+
+func TestBuildCacheKeySampling(t *testing.T) {
+	ctx := context.WithValue(context.Background(), consts.ContextKeyAuthKeyID, uint(1))
+	raised, maxTopP, assumed := 1.5, 0.2, 0.0
+
+	tests := []struct {
+		name     string
+		params   string // 追加到请求体的采样参数
+		sampling models.CacheSampling
+		want     bool
+	}{
+		{"temperature 0 caches", `"temperature":0`, models.CacheSampling{}, true},
+		{"temperature 1 skips", `"temperature":1`, models.CacheSampling{}, false},
+		{"default threshold inclusive", `"temperature":0.5`, models.CacheSampling{}, true},
+		// 未传入 temperature 时按上游默认值 1 判断
+		{"no temperature skips", `"top_p":1`, models.CacheSampling{}, false},
+		{"no temperature with low top_p caches", `"top_p":0.1`, models.CacheSampling{MaxTopP: &maxTopP}, true},
+		{"configured default temperature caches", `"top_p":1`, models.CacheSampling{DefaultTemperature: &assumed}, true},
+		{"raised threshold", `"temperature":1`, models.CacheSampling{MaxTemperature: &raised}, true},
+		{"low top_p counts as deterministic", `"temperature":1,"top_p":0.1`, models.CacheSampling{MaxTopP: &maxTopP}, true},
+		{"high top_p still skips", `"temperature":1,"top_p":0.9`, models.CacheSampling{MaxTopP: &maxTopP}, false},
+		{"off mode caches", `"temperature":1`, models.CacheSampling{Mode: models.CacheSamplingOff}, true},
+		{"reduce mode caches", `"temperature":1`, models.CacheSampling{Mode: models.CacheSamplingReduce}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, err := BeforerOpenAI([]byte(`{"model":"m","messages":[{"role":"user","content":"hi"}],` + tt.params + `}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := BuildCacheKey(ctx, consts.StyleOpenAI, *before, "", tt.sampling, ""); ok != tt.want {
+				t.Errorf("BuildCacheKey() ok = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestSamplingCacheTTL(t *testing.T) {
+	ttl := CacheTTL{TTL: time.Hour, Grace: time.Minute}
+	reduce := models.CacheSampling{Mode: models.CacheSamplingReduce}
+	tests := []struct {
+		name     string
+		body     string
+		sampling models.CacheSampling
+		want     time.Duration
+	}{
+		{"deterministic keeps ttl", `{"temperature":0}`, reduce, time.Hour},
+		{"creative uses default reduced ttl", `{"temperature":1}`, reduce, DefaultCacheReducedTTL},
+		{"creative uses configured ttl", `{"temperature":1}`, models.CacheSampling{Mode: models.CacheSamplingReduce, ReducedTTL: 10}, 10 * time.Second},
+		{"skip mode keeps ttl", `{"temperature":1}`, models.CacheSampling{}, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SamplingCacheTTL(Before{raw: []byte(tt.body)}, tt.sampling, ttl)
+			if got.TTL != tt.want || got.Grace != ttl.Grace {
+				t.Errorf("SamplingCacheTTL() = %+v, want ttl %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateCacheSampling(t *testing.T) {
+	negative, above := -1.0, 1.5
+	for _, sampling := range []models.CacheSampling{
+		{Mode: "sometimes"},
+		{MaxTemperature: &negative},
+		{MaxTopP: &above},
+		{ReducedTTL: -1},
+	} {
+		if err := ValidateCacheSampling(sampling); err == nil {
+			t.Errorf("ValidateCacheSampling(%+v) expected error", sampling)
+		}
+	}
+	if err := ValidateCacheSampling(models.CacheSampling{Mode: models.CacheSamplingReduce, ReducedTTL: 30}); err != nil {
+		t.Errorf("ValidateCacheSampling() error = %v", err)
+	}
+}
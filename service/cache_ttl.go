@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service/cache"
+)
+
+// CacheTTL 缓存条目的新鲜期与宽限期
+type CacheTTL struct {
+	TTL   time.Duration // 新鲜期 之后为软过期
+	Grace time.Duration // 软过期后仍返回旧结果并后台刷新的时长 0 表示软过期即失效
+}
+
+// DefaultCacheTTL 未配置时新鲜期 5 分钟 不使用旧结果
+var DefaultCacheTTL = CacheTTL{TTL: cache.DefaultTTL}
+
+// ParseCacheTTL 解析缓存有效期配置 未设置的新鲜期使用默认值
+func ParseCacheTTL(config *models.Cache) (CacheTTL, error) {
+	if config == nil {
+		return DefaultCacheTTL, nil
+	}
+	if config.TTL < 0 {
+		return CacheTTL{}, errors.New("ttl must not be negative")
+	}
+	if config.StaleWhileRevalidate < 0 {
+		return CacheTTL{}, errors.New("stale_while_revalidate must not be negative")
+	}
+	ttl := DefaultCacheTTL
+	if config.TTL > 0 {
+		ttl.TTL = time.Duration(config.TTL) * time.Second
+	}
+	ttl.Grace = time.Duration(config.StaleWhileRevalidate) * time.Second
+	return ttl, nil
+}
+
+// LoadCacheTTL 读取缓存有效期配置 读取失败时使用默认值
+func LoadCacheTTL(ctx context.Context) CacheTTL {
+	config, err := LoadConfig[models.Cache](ctx, models.KeyCache)
+	if err != nil {
+		slog.Error("load cache config error", "error", err)
+		return DefaultCacheTTL
+	}
+	ttl, err := ParseCacheTTL(config)
+	if err != nil {
+		slog.Error("invalid cache config", "error", err)
+		return DefaultCacheTTL
+	}
+	return ttl
+}
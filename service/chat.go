@@ -7,9 +7,14 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
+	"math/rand/v2"
 	"net/http"
 	"net/http/httptrace"
+	"slices"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/atopos31/llmio/balancers"
 	"github.com/atopos31/llmio/consts"
@@ -17,17 +22,82 @@ import (
 	"github.com/atopos31/llmio/providers"
 	"github.com/atopos31/llmio/service/cooldown"
 	"github.com/atopos31/llmio/service/keypool"
+	"github.com/atopos31/llmio/service/tracing"
 	"github.com/samber/lo"
 	"gorm.io/gorm"
 )
 
+// balancerStore 跨请求共享的负载均衡状态
+var balancerStore = balancers.NewStore()
+
+// balancerFactory 根据策略返回负载均衡器构造函数 存在多个层级时按层级严格降级
+func balancerFactory(strategy string, tiers map[uint]int) balancers.Factory {
+	factory := strategyFactory(strategy)
+	if !multiTier(tiers) {
+		return factory
+	}
+	return func(items map[uint]int) balancers.Balancer {
+		return balancers.NewTiered(items, tiers, factory)
+	}
+}
+
+// standbyTiers 权重为 0 的关联作为备用 排在所有正权重关联的层级之后 仅在它们全部不可用时才会被选中
+// 备用关联之间保持原有层级顺序 层级内按权重 1 均分
+func standbyTiers(weights, tiers map[uint]int) (map[uint]int, map[uint]int) {
+	maxTier, standby := -1, false
+	for id, weight := range weights {
+		if weight > 0 {
+			maxTier = max(maxTier, tiers[id])
+		} else {
+			standby = true
+		}
+	}
+	if !standby {
+		return weights, tiers
+	}
+	adjustedWeights := maps.Clone(weights)
+	adjustedTiers := maps.Clone(tiers)
+	if adjustedTiers == nil {
+		adjustedTiers = make(map[uint]int, len(weights))
+	}
+	for id, weight := range weights {
+		if weight <= 0 {
+			adjustedWeights[id] = 1
+			adjustedTiers[id] = maxTier + 1 + tiers[id]
+		}
+	}
+	return adjustedWeights, adjustedTiers
+}
+
+// multiTier 是否存在多个层级
+func multiTier(tiers map[uint]int) bool {
+	return len(lo.Uniq(lo.Values(tiers))) > 1
+}
+
+// lotterySource 抽签策略使用的随机源 为空时使用全局随机源 测试中可固定种子以复现选择顺序
+var lotterySource rand.Source
+
+// strategyFactory 从注册表查找层级内使用的负载均衡器构造函数 未注册的策略使用默认的抽签策略
+// 抽签策略使用可替换的随机源 不经过注册表
+func strategyFactory(strategy string) balancers.Factory {
+	if strategy != consts.BalancerLottery {
+		if factory, ok := balancers.Lookup(strategy); ok {
+			return factory
+		}
+	}
+	return balancers.LotteryFactory(lotterySource)
+}
+
 type streamContextKey struct{}
 
 type streamContext struct {
 	modelWithProvider *models.ModelWithProvider
+	providerName      string
 	cooldownManager   *cooldown.Manager
 	keyPool           *keypool.Pool
 	keyID             uint
+	maxScannerBuffer  int
+	statusOverrides   cooldown.StatusOverrides
 }
 
 func withStreamContext(ctx context.Context, streamCtx *streamContext) context.Context {
@@ -45,6 +115,18 @@ func streamContextFrom(ctx context.Context) *streamContext {
 	return streamCtx
 }
 
+// SelectedProvider 返回实际处理该响应的提供商名称与上游模型名
+func SelectedProvider(res *http.Response) (providerName, providerModel string, ok bool) {
+	if res == nil || res.Request == nil {
+		return "", "", false
+	}
+	streamCtx := streamContextFrom(res.Request.Context())
+	if streamCtx == nil || streamCtx.modelWithProvider == nil {
+		return "", "", false
+	}
+	return streamCtx.providerName, streamCtx.modelWithProvider.ProviderModel, true
+}
+
 func CopyStreamContext(ctx context.Context) context.Context {
 	if streamCtx := streamContextFrom(ctx); streamCtx != nil {
 		// 保留原始 context 的取消信号，只复制 stream context
@@ -54,42 +136,73 @@ func CopyStreamContext(ctx context.Context) context.Context {
 }
 
 func BalanceChat(ctx context.Context, start time.Time, style string, before Before, providersWithMeta ProvidersWithMeta, reqMeta models.ReqMeta) (*http.Response, uint, error) {
-	slog.Info("request", "model", before.Model, "stream", before.Stream, "tool_call", before.toolCall, "structured_output", before.structuredOutput, "image", before.image)
+	slog.Info("request", "model", before.Model, "stream", before.Stream, "tool_call", before.toolCall, "structured_output", before.structuredOutput, "image", before.image, "audio", before.audio)
+	tracing.AnnotateRequest(ctx, style, before.Model, before.Stream)
+	if style == consts.StyleOpenAICompletion {
+		ctx = providers.WithCompletion(ctx)
+	}
 
 	providerMap := providersWithMeta.ProviderMap
-	cooldownManager := cooldown.NewManager(models.DB)
-	keyPool := keypool.NewPool(models.DB)
+	schedule := loadCooldownSchedule(ctx)
+	cooldownManager := cooldown.NewManager(models.DB).WithSchedule(schedule)
+	keyPool := keypool.NewPool(models.DB).WithBackoff(schedule.Key).WithRateLimits(keyRateLimits.keyFactor)
 
 	// 鏀堕泦閲嶈瘯杩囩▼涓殑err鏃ュ織
 	retryLog := make(chan models.ChatLog, providersWithMeta.MaxRetry)
 	defer close(retryLog)
 
-	go RecordRetryLog(context.Background(), retryLog)
-
-	// 閫夋嫨璐熻浇鍧囪　绛栫暐
-	var balancer balancers.Balancer
-	switch providersWithMeta.Strategy {
-	case consts.BalancerSmoothWeightedRR:
-		balancer = balancers.NewSmoothWeightedRR(providersWithMeta.WeightItems)
-	case consts.BalancerRotor:
-		balancer = balancers.NewRotor(providersWithMeta.WeightItems)
-	case consts.BalancerLottery, "":
-		balancer = balancers.NewLottery(providersWithMeta.WeightItems)
-	default:
-		balancer = balancers.NewLottery(providersWithMeta.WeightItems)
+	retryLogConfig, err := LoadConfig[models.RetryLog](ctx, models.KeyRetryLog)
+	if err != nil {
+		slog.Error("load retry log config error", "error", err)
 	}
+	go RecordRetryLog(context.Background(), retryLog, retryLogConfig)
 
-	// 璁剧疆璇锋眰瓒呮椂
-	responseHeaderTimeout := time.Second * time.Duration(providersWithMeta.TimeOut)
-	// 娴佸紡瓒呮椂鏃堕棿缂╃煭
-	if before.Stream {
-		responseHeaderTimeout = responseHeaderTimeout / 3
+	// 选择负载均衡策略，轮转状态按模型跨请求复用
+	weightItems, tierItems := standbyTiers(providersWithMeta.WeightItems, providersWithMeta.TierItems)
+	// 上游限流额度接近耗尽的关联提前降低权重
+	weightItems = associationRateLimits.weights(weightItems)
+	tiered := multiTier(tierItems)
+	balancer := balancerStore.Session(
+		fmt.Sprintf("%d|%s|%s", providersWithMeta.ModelID, providersWithMeta.Strategy, tierSignature(tierItems)),
+		weightItems,
+		balancerFactory(providersWithMeta.Strategy, tierItems),
+	)
+	// 并发请求刚失败的关联放到最后尝试 避免故障期间每个请求都重复踩到同一个提供商
+	recentlyFailed := recentFailures.recent(slices.Collect(maps.Keys(weightItems)))
+	if len(recentlyFailed) > 0 {
+		balancer = balancers.NewDeferred(balancer, recentlyFailed)
 	}
-	client := providers.GetClient(responseHeaderTimeout)
 
 	authKeyID, _ := ctx.Value(consts.ContextKeyAuthKeyID).(uint)
 
-	timer := time.NewTimer(time.Second * time.Duration(providersWithMeta.TimeOut))
+	// 粘性会话 优先使用该会话上次成功的关联 失败后回退到负载均衡并重新绑定
+	var sessionID, sessionKey string
+	var pinnedID uint
+	if providersWithMeta.StickyTTL > 0 {
+		sessionID = strings.TrimSpace(reqMeta.Header.Get(HeaderSessionID))
+	}
+	if sessionID != "" {
+		sessionKey = stickyKey(authKeyID, providersWithMeta.ModelID, sessionID)
+		if id, ok := stickySessions.get(sessionKey); ok {
+			if _, candidate := weightItems[id]; candidate && !slices.Contains(recentlyFailed, id) {
+				pinnedID = id
+				balancer = balancers.NewPinned(balancer, id)
+			}
+		}
+	}
+
+	// 提供商级错误同时记入近期失败 并发请求在记忆期内优先尝试其他关联
+	onProviderError := func(mp *models.ModelWithProvider, category cooldown.Category) {
+		if category == cooldown.CategoryProvider {
+			recentFailures.add(mp.ID)
+		}
+		if err := cooldownManager.OnError(ctx, mp, category); err != nil {
+			slog.Error("update cooldown error", "error", err)
+		}
+	}
+
+	// 模型未设置超时时使用默认值 避免零时长的计时器立即触发
+	timer := time.NewTimer(time.Second * time.Duration(resolveTimeOut(providersWithMeta.TimeOut)))
 	defer timer.Stop()
 
 	retries := providersWithMeta.MaxRetry
@@ -97,9 +210,6 @@ func BalanceChat(ctx context.Context, start time.Time, style string, before Befo
 		retries = 1
 	}
 	activeProviders := len(providersWithMeta.WeightItems)
-	if activeProviders == 0 {
-		activeProviders = len(providersWithMeta.ModelWithProviderMap)
-	}
 	if activeProviders == 0 {
 		return nil, 0, errors.New("no active providers")
 	}
@@ -110,12 +220,12 @@ func BalanceChat(ctx context.Context, start time.Time, style string, before Befo
 		case <-ctx.Done():
 			return nil, 0, ctx.Err()
 		case <-timer.C:
-			return nil, 0, errors.New("retry time out")
+			return nil, 0, fmt.Errorf("%w: retry time out", ErrProvidersExhausted)
 		default:
 			// 鍔犳潈璐熻浇鍧囪
 			id, err := balancer.Pop()
 			if err != nil {
-				return nil, 0, err
+				return nil, 0, fmt.Errorf("%w: %w", ErrProvidersExhausted, err)
 			}
 
 			modelWithProvider, ok := providersWithMeta.ModelWithProviderMap[id]
@@ -126,9 +236,14 @@ func BalanceChat(ctx context.Context, start time.Time, style string, before Befo
 			}
 			if cooldownManager.InCooldown(modelWithProvider) {
 				cooldownSkipped++
-				balancer.Reduce(id)
+				if tiered {
+					// 分层时剔除冷却中的提供商 使流量降级到下一层级
+					balancer.Delete(id)
+				} else {
+					balancer.Reduce(id)
+				}
 				if cooldownSkipped >= activeProviders {
-					return nil, 0, fmt.Errorf("all providers are in cooldown")
+					return nil, 0, fmt.Errorf("%w: all providers are in cooldown", ErrProvidersExhausted)
 				}
 				continue
 			}
@@ -137,7 +252,7 @@ func BalanceChat(ctx context.Context, start time.Time, style string, before Befo
 
 			provider := providerMap[modelWithProvider.ProviderID]
 
-			chatModel, err := providers.New(style, provider.Config)
+			chatModel, err := providers.New(provider.Type, provider.Config)
 			if err != nil {
 				return nil, 0, err
 			}
@@ -145,30 +260,78 @@ func BalanceChat(ctx context.Context, start time.Time, style string, before Befo
 			slog.Info("using provider", "provider", provider.Name, "model", modelWithProvider.ProviderModel)
 
 			log := models.ChatLog{
-				Name:          before.Model,
-				ProviderModel: modelWithProvider.ProviderModel,
-				ProviderName:  provider.Name,
-				Status:        "success",
-				Style:         style,
-				UserAgent:     reqMeta.UserAgent,
-				RemoteIP:      reqMeta.RemoteIP,
-				AuthKeyID:     authKeyID,
-				ProviderKeyID: 0, // 将在获取 key 后更新
-				ChatIO:        providersWithMeta.IOLog,
-				Retry:         retry,
-				ProxyTime:     time.Since(start),
+				Name:           before.Model,
+				ProviderModel:  modelWithProvider.ProviderModel,
+				ProviderName:   provider.Name,
+				Status:         "success",
+				Style:          style,
+				UserAgent:      reqMeta.UserAgent,
+				RemoteIP:       reqMeta.RemoteIP,
+				AuthKeyID:      authKeyID,
+				ProviderKeyID:  0, // 将在获取 key 后更新
+				EndUser:        before.EndUser(),
+				Seed:           before.Seed(),
+				ChatIO:         providersWithMeta.IOLog,
+				Retry:          retry,
+				Tier:           modelWithProvider.Tier,
+				ClampedParams:  strings.Join(before.clampedParams, ","),
+				Anomaly:        before.Anomaly(),
+				ThinkingBudget: before.ThinkingBudget(),
+				SessionID:      sessionID,
+				Shadow:         providersWithMeta.Shadow,
+				ProxyTime:      time.Since(start),
+				QueueTime:      queueWaitFrom(ctx),
+
+				ExcludedProviders: strings.Join(providersWithMeta.ExcludedProviders, ","),
+				RequestedModel:    before.RequestedModel(),
+				BudgetShed:        providersWithMeta.BudgetShed,
+				TruncatedMessages: before.truncatedMessages,
+			}
+			// 每次尝试对应一个子 span 失败时记录重试日志并结束 span
+			attemptStart := time.Now()
+			attemptCtx, span := tracing.StartAttempt(ctx, provider.Name, modelWithProvider.ProviderModel, retry)
+			fail := func(statusCode int, category cooldown.Category, err error) {
+				failed := log.WithError(err)
+				failed.FailureReason = FailureReason(err, category)
+				retryLog <- failed
+				tracing.EndAttempt(span, statusCode, time.Since(attemptStart), err)
 			}
+
 			// 鏍规嵁璇锋眰鍘熷璇锋眰澶?鏄惁閫忎紶璇锋眰澶?鑷畾涔夎姹傚ご 鏋勫缓鏂扮殑璇锋眰澶?
 			withHeader := false
 			if modelWithProvider.WithHeader != nil {
 				withHeader = *modelWithProvider.WithHeader
 			}
-			header := buildHeaders(reqMeta.Header, withHeader, modelWithProvider.CustomerHeaders, before.Stream)
+			header := buildHeaders(reqMeta.Header, withHeader, modelWithProvider.ForwardHeaders, modelWithProvider.CustomerHeaders, before.Stream)
+
+			// 每次尝试按关联、模型与提供商配置单独计算超时，并使用提供商的代理与 TLS 配置
+			providerTimeOut, err := providerDefaultTimeOut(provider.Config)
+			if err != nil {
+				fail(0, cooldown.CategoryProvider, err)
+				balancer.Delete(id)
+				onProviderError(modelWithProvider, cooldown.CategoryProvider)
+				continue
+			}
+			client, err := providers.GetProviderClient(attemptTimeout(providersWithMeta.TimeOut, providerTimeOut, modelWithProvider, before.Stream), provider.Config)
+			if err != nil {
+				fail(0, cooldown.CategoryProvider, err)
+				balancer.Delete(id)
+				onProviderError(modelWithProvider, cooldown.CategoryProvider)
+				continue
+			}
+			// 提供商自定义的状态码归类 决定重试与冷却行为
+			statusOverrides, err := providerStatusOverrides(provider.Config)
+			if err != nil {
+				fail(0, cooldown.CategoryProvider, err)
+				balancer.Delete(id)
+				onProviderError(modelWithProvider, cooldown.CategoryProvider)
+				continue
+			}
 
 			// 从 Key 池获取可用 Key
 			var keyID uint
 			keyFromPool := ""
-			if keyPool != nil {
+			if keyPool != nil && provider.Type != consts.StyleBedrock {
 				k, kid, err := keyPool.Pick(ctx, provider.ID)
 				if err != nil {
 					slog.Warn("key pool pick failed", "provider", provider.Name, "error", err)
@@ -187,27 +350,24 @@ func BalanceChat(ctx context.Context, start time.Time, style string, before Befo
 			reqStart := time.Now()
 			trace := &httptrace.ClientTrace{
 				GotFirstResponseByte: func() {
-					fmt.Printf("鍝嶅簲鏃堕棿: %v", time.Since(reqStart))
+					tracing.FirstResponseByte(span, time.Since(reqStart))
 				},
 			}
 
-			usedKeyID := keyID
-			var req *http.Request
-			if builder, ok := chatModel.(interface {
-				BuildReqWithKey(ctx context.Context, header http.Header, model string, rawBody []byte, key string, keyID uint) (*http.Request, uint, error)
-			}); ok {
-				req, usedKeyID, err = builder.BuildReqWithKey(httptrace.WithClientTrace(ctx, trace), header, modelWithProvider.ProviderModel, before.raw, keyFromPool, keyID)
-			} else {
-				req, err = chatModel.BuildReq(httptrace.WithClientTrace(ctx, trace), header, modelWithProvider.ProviderModel, before.raw)
+			// 上游不支持流式时改为非流式请求，响应后再合成 SSE 返回客户端
+			reqBody, syntheticStream, err := upstreamBody(style, before, modelWithProvider)
+			if err != nil {
+				tracing.EndAttempt(span, 0, time.Since(attemptStart), err)
+				return nil, 0, err
 			}
+
+			req, usedKeyID, err := buildProviderReq(httptrace.WithClientTrace(attemptCtx, trace), chatModel, header, modelWithProvider.ProviderModel, reqBody, keyFromPool, keyID)
 			if err != nil {
 				log.ProviderKeyID = usedKeyID
-				retryLog <- log.WithError(err)
+				fail(0, cooldown.CategoryProvider, err)
 				// 鏋勫缓璇锋眰澶辫触 绉婚櫎寰呴€?
 				balancer.Delete(id)
-				if err := cooldownManager.OnError(ctx, modelWithProvider, cooldown.CategoryProvider); err != nil {
-					slog.Error("update cooldown error", "error", err)
-				}
+				onProviderError(modelWithProvider, cooldown.CategoryProvider)
 				if usedKeyID > 0 && keyPool != nil {
 					if err := keyPool.OnError(ctx, usedKeyID, cooldown.CategoryProvider); err != nil {
 						slog.Error("key pool on error", "error", err)
@@ -221,19 +381,20 @@ func BalanceChat(ctx context.Context, start time.Time, style string, before Befo
 			// 将 stream context 附加到请求上下文，用于流处理时的错误处理
 			req = req.WithContext(withStreamContext(req.Context(), &streamContext{
 				modelWithProvider: modelWithProvider,
+				providerName:      provider.Name,
 				cooldownManager:   cooldownManager,
 				keyPool:           keyPool,
 				keyID:             keyID,
+				maxScannerBuffer:  providersWithMeta.MaxScannerBuffer,
+				statusOverrides:   statusOverrides,
 			}))
 
 			res, err := client.Do(req)
 			if err != nil {
-				retryLog <- log.WithError(err)
+				fail(0, cooldown.CategoryProvider, err)
 				// 璇锋眰澶辫触 绉婚櫎寰呴€?
 				balancer.Delete(id)
-				if err := cooldownManager.OnError(ctx, modelWithProvider, cooldown.CategoryProvider); err != nil {
-					slog.Error("update cooldown error", "error", err)
-				}
+				onProviderError(modelWithProvider, cooldown.CategoryProvider)
 				if keyID > 0 && keyPool != nil {
 					if err := keyPool.OnError(ctx, keyID, cooldown.CategoryProvider); err != nil {
 						slog.Error("key pool on error", "error", err)
@@ -241,18 +402,22 @@ func BalanceChat(ctx context.Context, start time.Time, style string, before Befo
 				}
 				continue
 			}
+			// 刚收到上游响应的提供商连接仍是热的 预热间隔从此时重新计算
+			providerActivity.touch(provider.ID)
+
+			// 直通模式原样转发 其余情况解压后再解析
+			if !providersWithMeta.Passthrough {
+				decodeResponseBody(res)
+			}
 
 			if res.StatusCode != http.StatusOK {
 				byteBody, err := io.ReadAll(res.Body)
 				if err != nil {
 					slog.Error("read body error", "error", err)
 				}
-				retryLog <- log.WithError(fmt.Errorf("status: %d, body: %s", res.StatusCode, string(byteBody)))
-
-				category := cooldown.ClassifyStatus(res.StatusCode)
-				if err := cooldownManager.OnError(ctx, modelWithProvider, category); err != nil {
-					slog.Error("update cooldown error", "error", err)
-				}
+				category := statusOverrides.Classify(res.StatusCode)
+				fail(res.StatusCode, category, fmt.Errorf("status: %d, body: %s", res.StatusCode, string(byteBody)))
+				onProviderError(modelWithProvider, category)
 				if keyID > 0 && keyPool != nil {
 					if err := keyPool.OnError(ctx, keyID, category); err != nil {
 						slog.Error("key pool on error", "error", err)
@@ -266,85 +431,266 @@ func BalanceChat(ctx context.Context, start time.Time, style string, before Befo
 					// 闈濺PM闄愬埗 绉婚櫎寰呴€?
 					balancer.Delete(id)
 				}
-				res.Body.Close()
+				discardBody(res.Body)
 				continue
 			}
 
+			observeRateLimits(provider.Type, res.Header, modelWithProvider.ID, keyID)
+
+			if before.Stream && providersWithMeta.StreamIdleTimeout > 0 {
+				res.Body = newIdleTimeoutBody(res.Body, providersWithMeta.StreamIdleTimeout)
+			}
+
+			if transformer, ok := chatModel.(providers.ResponseTransformer); ok {
+				if err := transformer.TransformResponse(res); err != nil {
+					fail(res.StatusCode, cooldown.CategoryProvider, err)
+					balancer.Delete(id)
+					onProviderError(modelWithProvider, cooldown.CategoryProvider)
+					discardBody(res.Body)
+					continue
+				}
+			}
+
+			// 非流式响应提交前检查响应体中的错误 避免将上游失败记为成功
+			if !before.Stream || syntheticStream {
+				if err := checkErrorBody(WithStatusOverrides(ctx, statusOverrides), res); err != nil {
+					category := cooldown.CategoryProvider
+					var streamErr StreamError
+					if errors.As(err, &streamErr) {
+						category = streamErr.Category
+					}
+					fail(res.StatusCode, category, err)
+					onProviderError(modelWithProvider, category)
+					if keyID > 0 && keyPool != nil {
+						if err := keyPool.OnError(ctx, keyID, category); err != nil {
+							slog.Error("key pool on error", "error", err)
+						}
+					}
+					balancer.Delete(id)
+					continue
+				}
+			}
+
+			if syntheticStream {
+				if err := toSyntheticStream(res); err != nil {
+					discardBody(res.Body)
+					fail(res.StatusCode, cooldown.CategoryProvider, err)
+					balancer.Delete(id)
+					onProviderError(modelWithProvider, cooldown.CategoryProvider)
+					continue
+				}
+			}
+
+			// 在响应转为 SSE 后检测停滞 首个有效内容前由首包超时处理
+			if before.Stream && providersWithMeta.StreamStallTimeout > 0 {
+				res.Body = newStallBody(res.Body, providersWithMeta.StreamStallTimeout)
+			}
+
+			// 缓冲至首个有效内容 期间出错或超时则切换提供商 客户端不会感知
+			if before.Stream && (providersWithMeta.StreamFailover || providersWithMeta.FirstChunkTimeout > 0) {
+				if err := preCommitStreamWithin(WithStatusOverrides(ctx, statusOverrides), res, style, PreCommitBufferSize, providersWithMeta.FirstChunkTimeout); err != nil {
+					discardBody(res.Body)
+					category := cooldown.CategoryProvider
+					var streamErr StreamError
+					if errors.As(err, &streamErr) {
+						category = streamErr.Category
+					}
+					fail(res.StatusCode, category, err)
+					onProviderError(modelWithProvider, category)
+					if keyID > 0 && keyPool != nil {
+						if err := keyPool.OnError(ctx, keyID, category); err != nil {
+							slog.Error("key pool on error", "error", err)
+						}
+					}
+					balancer.Delete(id)
+					continue
+				}
+			}
+
+			if sessionKey != "" {
+				stickySessions.pin(sessionKey, id, providersWithMeta.StickyTTL)
+				log.StickyHit = id == pinnedID
+			}
+
 			logId, err := SaveChatLog(ctx, log)
 			if err != nil {
+				// 正常的响应可能仍在生成 直接断开而非读完
 				res.Body.Close()
+				tracing.EndAttempt(span, res.StatusCode, time.Since(attemptStart), err)
 				return nil, 0, err
 			}
+			tracing.EndAttempt(span, res.StatusCode, time.Since(attemptStart), nil)
 
 			return res, logId, nil
 		}
 	}
 
-	return nil, 0, errors.New("maximum retry attempts reached")
+	return nil, 0, fmt.Errorf("%w: maximum retry attempts reached", ErrProvidersExhausted)
+}
+
+// upstreamBody 按关联配置调整发往提供商的请求体 上游不支持流式时改为非流式请求 由网关合成 SSE
+func upstreamBody(style string, before Before, mp *models.ModelWithProvider) ([]byte, bool, error) {
+	syntheticStream := before.Stream && style == consts.StyleOpenAI && mp.NonStream != nil && *mp.NonStream
+	if !syntheticStream {
+		return before.raw, false, nil
+	}
+	body, err := nonStreamBody(before.raw)
+	return body, true, err
+}
+
+// buildProviderReq 由提供商构造上游请求 支持 Key 池的提供商使用指定的 Key 返回实际使用的 Key ID
+func buildProviderReq(ctx context.Context, chatModel providers.Provider, header http.Header, model string, body []byte, key string, keyID uint) (*http.Request, uint, error) {
+	if builder, ok := chatModel.(interface {
+		BuildReqWithKey(ctx context.Context, header http.Header, model string, rawBody []byte, key string, keyID uint) (*http.Request, uint, error)
+	}); ok {
+		return builder.BuildReqWithKey(ctx, header, model, body, key, keyID)
+	}
+	req, err := chatModel.BuildReq(ctx, header, model, body)
+	return req, keyID, err
 }
 
-func RecordRetryLog(ctx context.Context, retryLog chan models.ChatLog) {
-	for log := range retryLog {
-		if _, err := SaveChatLog(ctx, log); err != nil {
-			slog.Error("save chat log error", "error", err)
+// tierSignature 生成层级配置的稳定签名 层级变化时使用新的负载均衡状态
+func tierSignature(tiers map[uint]int) string {
+	if !multiTier(tiers) {
+		return ""
+	}
+	keys := slices.Sorted(maps.Keys(tiers))
+	var sb strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&sb, "%d:%d,", k, tiers[k])
+	}
+	return sb.String()
+}
+
+// defaultTimeOut 关联、模型与提供商均未设置超时时使用的超时 单位秒
+const defaultTimeOut = 60
+
+// resolveTimeOut 返回第一个大于 0 的超时 均未设置时使用默认超时 单位秒
+func resolveTimeOut(timeOuts ...int) int {
+	for _, timeOut := range timeOuts {
+		if timeOut > 0 {
+			return timeOut
 		}
 	}
+	return defaultTimeOut
+}
+
+// attemptTimeout 计算单次尝试的响应头超时，按关联、模型、提供商默认值、全局默认值的顺序取值，流式请求缩短为三分之一
+func attemptTimeout(modelTimeOut, providerTimeOut int, mp *models.ModelWithProvider, stream bool) time.Duration {
+	var associationTimeOut int
+	if mp != nil {
+		associationTimeOut = mp.TimeOut
+	}
+	responseHeaderTimeout := time.Second * time.Duration(resolveTimeOut(associationTimeOut, modelTimeOut, providerTimeOut))
+	if stream {
+		responseHeaderTimeout = responseHeaderTimeout / 3
+	}
+	return responseHeaderTimeout
+}
+
+// RecordLogAsync 在后台记录日志 退出前可通过 StartLogWriter 返回的 drain 等待完成
+func RecordLogAsync(ctx context.Context, reqStart time.Time, reader io.ReadCloser, processer Processer, logId uint, before Before, ioLog bool) {
+	recordLogs.Add(1)
+	go func() {
+		defer recordLogs.Done()
+		RecordLog(ctx, reqStart, reader, processer, logId, before, ioLog)
+	}()
 }
 
 func RecordLog(ctx context.Context, reqStart time.Time, reader io.ReadCloser, processer Processer, logId uint, before Before, ioLog bool) {
 	streamCtx := streamContextFrom(ctx)
-	recordFunc := func() error {
-		defer reader.Close()
-		// 使用独立 context，避免请求结束后 context 被取消导致数据库更新失败
-		bgCtx := context.Background()
-		if ioLog {
-			if err := gorm.G[models.ChatIO](models.DB).Create(bgCtx, &models.ChatIO{
-				Input: string(before.raw),
-				LogId: logId,
-			}); err != nil {
-				return err
-			}
+	usageReport := usageReportFrom(ctx)
+	if usageReport != nil {
+		defer close(usageReport)
+	}
+	defer reader.Close()
+	bgCtx := context.Background()
+	if streamCtx != nil {
+		bgCtx = WithMaxScannerBuffer(bgCtx, streamCtx.maxScannerBuffer)
+		bgCtx = WithStatusOverrides(bgCtx, streamCtx.statusOverrides)
+	}
+	if before.Stream {
+		bgCtx = withSlowStream(bgCtx, loadSlowStream(bgCtx))
+	}
+	write := logWrite{logID: logId}
+	if ioLog {
+		write.io = &models.ChatIO{
+			Input: string(before.raw),
+			LogId: logId,
 		}
-		log, output, err := processer(bgCtx, reader, before.Stream, reqStart)
-		if err != nil {
-			handleStreamError(bgCtx, streamCtx, err)
-			// 更新 ChatLog 状态为错误
-			if _, updateErr := gorm.G[models.ChatLog](models.DB).Where("id = ?", logId).Updates(bgCtx, models.ChatLog{
-				Status: "error",
-				Error:  err.Error(),
-			}); updateErr != nil {
-				slog.Error("update chat log error status failed", "error", updateErr)
-			}
-			return err
+	}
+	log, output, err := processer(bgCtx, reader, before.Stream, reqStart)
+	if err != nil {
+		handleStreamError(bgCtx, streamCtx, err)
+		// 更新 ChatLog 状态为错误
+		write.updates = map[string]any{
+			"status":         "error",
+			"error":          err.Error(),
+			"failure_reason": FailureReason(err, classifyStreamError(err)),
+		}
+		if errors.Is(err, ErrStreamStalled) {
+			write.updates["stalled"] = true
 		}
+		writeLog(write)
+		return
+	}
 
+	// 慢速流按配置触发冷却 响应本身已完整返回 仍按成功记录
+	if log.SlowStream && slowStreamCooldown(bgCtx) {
+		handleStreamError(bgCtx, streamCtx, ErrSlowStream)
+	} else {
 		handleStreamSuccess(bgCtx, streamCtx)
+	}
+	if usageReport != nil {
+		usageReport <- log.Usage
+	}
 
-		// 使用 map 更新以确保零值也能被更新
-		promptDetailsJSON, _ := json.Marshal(log.PromptTokensDetails)
-		updates := map[string]interface{}{
-			"first_chunk_time":      log.FirstChunkTime,
-			"chunk_time":            log.ChunkTime,
-			"tps":                   log.Tps,
-			"size":                  log.Size,
-			"prompt_tokens":         log.PromptTokens,
-			"completion_tokens":     log.CompletionTokens,
-			"total_tokens":          log.TotalTokens,
-			"prompt_tokens_details": string(promptDetailsJSON),
-		}
-		if err := models.DB.WithContext(bgCtx).Model(&models.ChatLog{}).Where("id = ?", logId).Updates(updates).Error; err != nil {
-			return err
+	promptDetailsJSON, _ := json.Marshal(log.PromptTokensDetails)
+	completionDetailsJSON, _ := json.Marshal(log.CompletionTokensDetails)
+	write.updates = map[string]any{
+		"first_chunk_time":          log.FirstChunkTime,
+		"chunk_time":                log.ChunkTime,
+		"tps":                       log.Tps,
+		"min_tps":                   log.MinTps,
+		"size":                      log.Size,
+		"prompt_tokens":             log.PromptTokens,
+		"completion_tokens":         log.CompletionTokens,
+		"total_tokens":              log.TotalTokens,
+		"prompt_tokens_details":     string(promptDetailsJSON),
+		"completion_tokens_details": string(completionDetailsJSON),
+	}
+	if log.SystemFingerprint != "" {
+		write.updates["system_fingerprint"] = log.SystemFingerprint
+	}
+	if log.SlowStream {
+		slog.Warn("slow stream", "log_id", logId, "min_tps", log.MinTps)
+		write.updates["slow_stream"] = true
+	}
+	if streamCtx != nil {
+		if cost := streamCtx.modelWithProvider.Cost(log.Usage); cost > 0 {
+			write.updates["cost"] = cost
 		}
-		if ioLog {
-			if _, err := gorm.G[models.ChatIO](models.DB).Where("log_id = ?", logId).Updates(bgCtx, models.ChatIO{OutputUnion: *output}); err != nil {
-				return err
+		if record, drift := servedModelDrift(streamCtx.modelWithProvider.ProviderModel, log.ServedModel); record {
+			write.updates["served_model"] = log.ServedModel
+			if drift {
+				slog.Warn("upstream served a different model", "log_id", logId, "provider", streamCtx.providerName,
+					"requested", streamCtx.modelWithProvider.ProviderModel, "served", log.ServedModel)
+				write.updates["model_drift"] = true
 			}
 		}
-		return nil
 	}
-	if err := recordFunc(); err != nil {
-		slog.Error("record log error", "error", err)
+	if log.FormatMismatch != "" {
+		slog.Warn("response format does not match request style", "log_id", logId, "detected", log.FormatMismatch)
+		write.updates["format_mismatch"] = log.FormatMismatch
+	}
+	if log.FormatWarning != "" {
+		slog.Warn("unexpected upstream response format", "log_id", logId, "warning", log.FormatWarning)
+		write.updates["format_warning"] = log.FormatWarning
 	}
+	if write.io != nil {
+		write.io.OutputUnion = *output
+	}
+	writeLog(write)
 }
 
 func handleStreamSuccess(ctx context.Context, streamCtx *streamContext) {
@@ -366,6 +712,9 @@ func handleStreamError(ctx context.Context, streamCtx *streamContext, processErr
 		return
 	}
 	category := classifyStreamError(processErr)
+	if category == cooldown.CategoryProvider {
+		recentFailures.add(streamCtx.modelWithProvider.ID)
+	}
 	if err := streamCtx.cooldownManager.OnError(ctx, streamCtx.modelWithProvider, category); err != nil {
 		slog.Error("update cooldown error", "error", err)
 	}
@@ -386,7 +735,7 @@ func classifyStreamError(err error) cooldown.Category {
 		return cooldown.CategoryProvider
 	case errors.Is(err, context.Canceled):
 		return cooldown.CategoryClient
-	case errors.Is(err, context.DeadlineExceeded):
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrStreamStalled):
 		return cooldown.CategoryProvider
 	default:
 		return cooldown.CategoryProvider
@@ -400,10 +749,16 @@ func SaveChatLog(ctx context.Context, log models.ChatLog) (uint, error) {
 	return log.ID, nil
 }
 
-func buildHeaders(source http.Header, withHeader bool, customHeaders map[string]string, stream bool) http.Header {
+func buildHeaders(source http.Header, withHeader bool, forwardHeaders []string, customHeaders map[string]string, stream bool) http.Header {
 	header := http.Header{}
 	if withHeader {
 		header = source.Clone()
+	} else if len(forwardHeaders) > 0 {
+		for key, values := range source {
+			if matchForwardHeader(forwardHeaders, key) {
+				header[key] = slices.Clone(values)
+			}
+		}
 	}
 
 	if stream {
@@ -420,25 +775,92 @@ func buildHeaders(source http.Header, withHeader bool, customHeaders map[string]
 	return header
 }
 
+// matchForwardHeader 判断 header 是否在白名单中 不区分大小写 以 * 结尾的项按前缀匹配
+func matchForwardHeader(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if len(key) >= len(prefix) && strings.EqualFold(key[:len(prefix)], prefix) {
+				return true
+			}
+		} else if strings.EqualFold(key, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateForwardHeaders 校验透传 header 白名单 * 只能出现在末尾
+func ValidateForwardHeaders(patterns []string) error {
+	for i, pattern := range patterns {
+		name := strings.TrimSuffix(pattern, "*")
+		if strings.TrimSpace(name) == "" || strings.ContainsAny(name, "* \t:") {
+			return fmt.Errorf("forward_headers[%d]: invalid header %q", i, pattern)
+		}
+	}
+	return nil
+}
+
 type ProvidersWithMeta struct {
+	ModelID              uint
 	ModelWithProviderMap map[uint]*models.ModelWithProvider
 	WeightItems          map[uint]int
+	TierItems            map[uint]int // 关联 ID 对应的优先级层级
 	ProviderMap          map[uint]models.Provider
 	MaxRetry             int
 	TimeOut              int
 	IOLog                bool
 	Strategy             string // 璐熻浇鍧囪　绛栫暐
+	MaxScannerBuffer     int    // 响应单行最大缓冲 单位字节 0使用默认值 负数不限制
+	StreamFailover       bool   // 首个有效内容前出错时切换提供商
+	CachePolicy          string // 缓存策略
+	CacheSampling        models.CacheSampling
+	ParamPolicy          models.ParamPolicy
+	ContextPolicy        models.ContextPolicy
+	StreamIdleTimeout    time.Duration
+	FirstChunkTimeout    time.Duration // 流式首个有效内容超时
+	StreamStallTimeout   time.Duration // 流式有效内容之间的最长间隔
+	GracefulTimeout      bool          // 空闲超时时补发结束事件
+	StreamResume         bool          // 流式事件附带 id 支持断线续传
+	StickyTTL            time.Duration // 粘性会话保持时间 0不启用
+	Passthrough          bool          // 直通模式 跳过响应处理
+	// 影子关联 接收请求副本用于验证新提供商 不参与正常选择
+	ShadowProviders map[uint]*models.ModelWithProvider
+	Shadow          bool // 本次为影子请求 日志带有影子标记
+	// 客户端通过请求头排除的提供商名称
+	ExcludedProviders []string
+	// 所有提供商失败时按接口风格返回的降级响应
+	Fallback models.ModelFallback
+	// 模型的并发上限与排队超时 上限为 0 时不限制
+	MaxConcurrency int
+	QueueTimeout   time.Duration
+	// auth key 预算接近或达到上限时的降级 记录在日志中
+	BudgetShed string
 }
 
+// ErrModelDisabled 模型已被停用
+var ErrModelDisabled = errors.New("model disabled")
+
+// ErrInsufficientProviders 可用提供商数量低于模型要求
+var ErrInsufficientProviders = errors.New("insufficient healthy providers")
+
+// ErrNoMatchingProvider 模型没有与请求风格匹配的提供商
+var ErrNoMatchingProvider = errors.New("no matching provider")
+
+// ErrRequestTooLarge 请求超出模型所有提供商的规模限制
+var ErrRequestTooLarge = errors.New("request exceeds provider limits")
+
 func ProvidersWithMetaBymodelsName(ctx context.Context, style string, before Before) (*ProvidersWithMeta, error) {
 	model, err := gorm.G[models.Model](models.DB).Where("name = ?", before.Model).First(ctx)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			if _, err := SaveChatLog(ctx, models.ChatLog{
-				Name:   before.Model,
-				Status: "error",
-				Style:  style,
-				Error:  err.Error(),
+				Name:           before.Model,
+				Status:         "error",
+				Style:          style,
+				EndUser:        before.EndUser(),
+				RequestedModel: before.RequestedModel(),
+				Anomaly:        before.Anomaly(),
+				Error:          err.Error(),
 			}); err != nil {
 				return nil, err
 			}
@@ -446,6 +868,20 @@ func ProvidersWithMetaBymodelsName(ctx context.Context, style string, before Bef
 		}
 		return nil, err
 	}
+	if !model.Enabled() {
+		if _, err := SaveChatLog(ctx, models.ChatLog{
+			Name:           before.Model,
+			Status:         "error",
+			Style:          style,
+			EndUser:        before.EndUser(),
+			RequestedModel: before.RequestedModel(),
+			Anomaly:        before.Anomaly(),
+			Error:          ErrModelDisabled.Error(),
+		}); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s", ErrModelDisabled, before.Model)
+	}
 
 	modelWithProviderChain := gorm.G[models.ModelWithProvider](models.DB).Where("model_id = ?", model.ID).Where("status = ?", true)
 
@@ -461,6 +897,14 @@ func ProvidersWithMetaBymodelsName(ctx context.Context, style string, before Bef
 		modelWithProviderChain = modelWithProviderChain.Where("image = ?", true)
 	}
 
+	if before.completion {
+		modelWithProviderChain = modelWithProviderChain.Where("completion = ?", true)
+	}
+
+	if before.audio {
+		modelWithProviderChain = modelWithProviderChain.Where("audio = ?", true)
+	}
+
 	modelWithProviders, err := modelWithProviderChain.Find(ctx)
 	if err != nil {
 		return nil, err
@@ -470,41 +914,189 @@ func ProvidersWithMetaBymodelsName(ctx context.Context, style string, before Bef
 		return nil, errors.New("not provider for model " + before.Model)
 	}
 
-	modelWithProviderMap := make(map[uint]*models.ModelWithProvider, len(modelWithProviders))
-	for i := range modelWithProviders {
-		mp := &modelWithProviders[i]
-		modelWithProviderMap[mp.ID] = mp
-	}
-
-	providers, err := gorm.G[models.Provider](models.DB).
+	providerList, err := gorm.G[models.Provider](models.DB).
 		Where("id IN ?", lo.Map(modelWithProviders, func(mp models.ModelWithProvider, _ int) uint { return mp.ProviderID })).
-		Where("type = ?", style).
+		Where("type IN ?", ProviderTypes(style)).
 		Find(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	providerMap := lo.KeyBy(providers, func(p models.Provider) uint { return p.ID })
+	providerMap := lo.KeyBy(providerList, func(p models.Provider) uint { return p.ID })
 
+	// 只保留提供商类型与请求风格匹配 且能承载请求规模的关联
+	modelWithProviderMap := make(map[uint]*models.ModelWithProvider, len(modelWithProviders))
 	weightItems := make(map[uint]int)
-	for _, mp := range modelWithProviders {
-		if _, ok := providerMap[mp.ProviderID]; !ok {
+	tierItems := make(map[uint]int)
+	shadows := make(map[uint]*models.ModelWithProvider)
+	promptChars := utf8.RuneCountInString(before.prompt)
+	excluded := excludedProviders(ctx)
+	var exceeded []string
+	excludedCount := 0
+	for i := range modelWithProviders {
+		mp := &modelWithProviders[i]
+		provider, ok := providerMap[mp.ProviderID]
+		if !ok {
+			continue
+		}
+		if slices.Contains(excluded, provider.Name) {
+			excludedCount++
 			continue
 		}
+		if reason := providers.ParseLimits(provider.Config).Exceeded(before.messageCount, promptChars); reason != "" {
+			exceeded = append(exceeded, reason)
+			continue
+		}
+		if mp.Shadow != nil && *mp.Shadow {
+			shadows[mp.ID] = mp
+			continue
+		}
+		modelWithProviderMap[mp.ID] = mp
 		weightItems[mp.ID] = mp.Weight
+		tierItems[mp.ID] = mp.Tier
+	}
+	if len(modelWithProviderMap) == 0 {
+		if excludedCount > 0 {
+			return nil, fmt.Errorf("%w: %s (excluded %s)", ErrProvidersExcluded, before.Model, strings.Join(excluded, ", "))
+		}
+		if len(exceeded) > 0 {
+			return nil, fmt.Errorf("%w: %s (%s)", ErrRequestTooLarge, before.Model, strings.Join(lo.Uniq(exceeded), ", "))
+		}
+		return nil, fmt.Errorf("%w: %s has no %s provider", ErrNoMatchingProvider, before.Model, style)
+	}
+	if err := checkHealthyProviders(ctx, style, before, model, modelWithProviderMap); err != nil {
+		return nil, err
 	}
 
 	if model.IOLog == nil {
 		model.IOLog = new(bool)
 	}
 
+	maxScannerBuffer, err := resolveMaxScannerBuffer(ctx, model.MaxBuffer)
+	if err != nil {
+		return nil, err
+	}
+
 	return &ProvidersWithMeta{
+		ModelID:              model.ID,
 		ModelWithProviderMap: modelWithProviderMap,
 		WeightItems:          weightItems,
+		TierItems:            tierItems,
 		ProviderMap:          providerMap,
 		MaxRetry:             model.MaxRetry,
 		TimeOut:              model.TimeOut,
 		IOLog:                *model.IOLog,
 		Strategy:             model.Strategy,
+		MaxScannerBuffer:     maxScannerBuffer,
+		StreamFailover:       model.StreamFailover != nil && *model.StreamFailover,
+		CachePolicy:          model.CachePolicy,
+		CacheSampling:        model.CacheSampling,
+		ParamPolicy:          model.ParamPolicy,
+		ContextPolicy:        model.ContextPolicy,
+		StreamIdleTimeout:    time.Second * time.Duration(model.StreamIdleTimeout),
+		FirstChunkTimeout:    time.Second * time.Duration(model.FirstChunkTimeout),
+		StreamStallTimeout:   time.Second * time.Duration(model.StreamStallTimeout),
+		GracefulTimeout:      model.GracefulTimeout != nil && *model.GracefulTimeout,
+		StreamResume:         model.StreamResume != nil && *model.StreamResume,
+		StickyTTL:            time.Second * time.Duration(model.StickyTTL),
+		Passthrough:          model.Passthrough != nil && *model.Passthrough,
+		ShadowProviders:      shadows,
+		ExcludedProviders:    excluded,
+		Fallback:             model.Fallback,
+		MaxConcurrency:       model.MaxConcurrency,
+		QueueTimeout:         time.Second * time.Duration(model.QueueTimeout),
 	}, nil
 }
+
+// loadCooldownSchedule 读取冷却退避配置 配置无效时使用默认值
+func loadCooldownSchedule(ctx context.Context) cooldown.Schedule {
+	config, err := LoadConfig[models.Cooldown](ctx, models.KeyCooldown)
+	if err != nil {
+		slog.Error("load cooldown config error", "error", err)
+		return cooldown.DefaultSchedule()
+	}
+	schedule, err := cooldown.ParseSchedule(config)
+	if err != nil {
+		slog.Error("invalid cooldown config", "error", err)
+		return cooldown.DefaultSchedule()
+	}
+	return schedule
+}
+
+// checkHealthyProviders 未冷却的提供商少于模型要求时告警 开启 RefuseDegraded 时拒绝请求
+func checkHealthyProviders(ctx context.Context, style string, before Before, model models.Model, candidates map[uint]*models.ModelWithProvider) error {
+	if model.MinHealthyProviders <= 1 {
+		return nil
+	}
+	cooldownManager := cooldown.NewManager(models.DB)
+	healthy := 0
+	for _, mp := range candidates {
+		if !cooldownManager.InCooldown(mp) {
+			healthy++
+		}
+	}
+	if healthy >= model.MinHealthyProviders {
+		return nil
+	}
+	refuse := model.RefuseDegraded != nil && *model.RefuseDegraded
+	slog.Warn("healthy providers below threshold", "model", model.Name, "healthy", healthy, "min", model.MinHealthyProviders, "refuse", refuse)
+	if !refuse {
+		return nil
+	}
+	err := fmt.Errorf("%w: %s has %d of required %d", ErrInsufficientProviders, model.Name, healthy, model.MinHealthyProviders)
+	if _, saveErr := SaveChatLog(ctx, models.ChatLog{
+		Name:           before.Model,
+		Status:         "error",
+		Style:          style,
+		EndUser:        before.EndUser(),
+		RequestedModel: before.RequestedModel(),
+		Anomaly:        before.Anomaly(),
+		Error:          err.Error(),
+	}); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// providerStatusOverrides 解析提供商配置中的 status_categories 自定义状态码归类
+func providerStatusOverrides(providerConfig string) (cooldown.StatusOverrides, error) {
+	var config struct {
+		StatusCategories map[string]string `json:"status_categories"`
+	}
+	if err := json.Unmarshal([]byte(providerConfig), &config); err != nil {
+		return nil, errors.New("invalid status_categories config")
+	}
+	return cooldown.ParseStatusOverrides(config.StatusCategories)
+}
+
+// providerDefaultTimeOut 解析提供商配置中的 time_out 默认超时 单位秒 未设置时为 0
+func providerDefaultTimeOut(providerConfig string) (int, error) {
+	var config struct {
+		TimeOut int `json:"time_out"`
+	}
+	if err := json.Unmarshal([]byte(providerConfig), &config); err != nil {
+		return 0, errors.New("invalid time_out config")
+	}
+	if config.TimeOut < 0 {
+		return 0, errors.New("time_out must not be negative")
+	}
+	return config.TimeOut, nil
+}
+
+// resolveMaxScannerBuffer 按模型配置、全局配置的顺序确定单行最大缓冲（字节）
+func resolveMaxScannerBuffer(ctx context.Context, modelMaxBuffer int) (int, error) {
+	sizeMB := modelMaxBuffer
+	if sizeMB == 0 {
+		config, err := LoadConfig[models.Scanner](ctx, models.KeyScanner)
+		if err != nil {
+			return 0, err
+		}
+		if config != nil {
+			sizeMB = config.MaxBuffer
+		}
+	}
+	if sizeMB < 0 {
+		return -1, nil
+	}
+	return sizeMB * 1024 * 1024, nil
+}
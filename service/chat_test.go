@@ -0,0 +1,972 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestAttemptTimeout(t *testing.T) {
+	slow := &models.ModelWithProvider{TimeOut: 300}
+	fast := &models.ModelWithProvider{TimeOut: 0}
+
+	tests := []struct {
+		name     string
+		model    int
+		provider int
+		mp       *models.ModelWithProvider
+		stream   bool
+		want     time.Duration
+	}{
+		{name: "override", model: 60, provider: 90, mp: slow, want: 300 * time.Second},
+		{name: "override stream", model: 60, mp: slow, stream: true, want: 100 * time.Second},
+		{name: "fallback to model", model: 60, provider: 90, mp: fast, want: 60 * time.Second},
+		{name: "fallback to model stream", model: 60, mp: fast, stream: true, want: 20 * time.Second},
+		{name: "nil association", model: 60, mp: nil, want: 60 * time.Second},
+		{name: "fallback to provider", provider: 90, mp: fast, want: 90 * time.Second},
+		{name: "fallback to provider stream", provider: 90, mp: nil, stream: true, want: 30 * time.Second},
+		{name: "fallback to global", mp: fast, want: defaultTimeOut * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := attemptTimeout(tt.model, tt.provider, tt.mp, tt.stream); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func setupChatDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get sql db: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&models.Provider{}, &models.Model{}, &models.ModelWithProvider{}, &models.ChatLog{},
+		&models.Config{}, &models.ProviderKey{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	models.DB = db
+	// 关联 ID 在每个测试库中从 1 开始 清空上个测试留下的近期失败
+	previous := recentFailures
+	recentFailures = newFailureSet(recentFailureTTL)
+	t.Cleanup(func() {
+		models.DB = nil
+		recentFailures = previous
+	})
+	return db
+}
+
+func TestBalanceChatTierSpillover(t *testing.T) {
+	db := setupChatDB(t)
+
+	var hits sync.Map
+	upstream := func(name string, status int) string {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			count, _ := hits.LoadOrStore(name, new(atomic.Int32))
+			count.(*atomic.Int32).Add(1)
+			w.WriteHeader(status)
+			fmt.Fprintf(w, `{"choices":[{"message":{"content":"%s"}}]}`, name)
+		}))
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+	hitCount := func(name string) int32 {
+		if count, ok := hits.Load(name); ok {
+			return count.(*atomic.Int32).Load()
+		}
+		return 0
+	}
+
+	model := models.Model{Name: "gpt-4o", MaxRetry: 5, TimeOut: 10}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	for _, p := range []struct {
+		name   string
+		status int
+		tier   int
+		weight int
+	}{
+		{"primary-a", http.StatusInternalServerError, 0, 1},
+		{"primary-b", http.StatusBadGateway, 0, 1},
+		{"secondary", http.StatusOK, 1, 100},
+		{"tertiary", http.StatusOK, 2, 100},
+	} {
+		provider := models.Provider{Name: p.name, Type: consts.StyleOpenAI, Config: `{"base_url":"` + upstream(p.name, p.status) + `","api_key":"sk-test"}`}
+		if err := db.Create(&provider).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o",
+			Status: &enabled, Weight: p.weight, Tier: p.tier}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	for i := range 3 {
+		before, err := BeforerOpenAI([]byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta, err := ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAI, *before)
+		if err != nil {
+			t.Fatalf("ProvidersWithMetaBymodelsName() error = %v", err)
+		}
+		res, logID, err := BalanceChat(ctx, time.Now(), consts.StyleOpenAI, *before, *meta, models.ReqMeta{Header: http.Header{}})
+		if err != nil {
+			t.Fatalf("request %d: BalanceChat() error = %v", i+1, err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		// 第一层全部失败后严格降级到第二层 而不会按权重选到第三层
+		if !strings.Contains(string(body), "secondary") {
+			t.Errorf("request %d served by %s, want secondary", i+1, body)
+		}
+		var log models.ChatLog
+		if err := db.First(&log, logID).Error; err != nil {
+			t.Fatal(err)
+		}
+		if log.Tier != 1 || log.ProviderName != "secondary" {
+			t.Errorf("request %d log = %s tier %d, want secondary tier 1", i+1, log.ProviderName, log.Tier)
+		}
+	}
+
+	// 失败的第一层进入冷却后直接跳过 不再重复请求
+	if a, b := hitCount("primary-a"), hitCount("primary-b"); a != 1 || b != 1 {
+		t.Errorf("tier 0 hits = %d, %d, want 1, 1", a, b)
+	}
+	if got := hitCount("tertiary"); got != 0 {
+		t.Errorf("tertiary hits = %d, want 0", got)
+	}
+}
+
+func TestProvidersWithMetaMixedStyles(t *testing.T) {
+	db := setupChatDB(t)
+	ctx := context.Background()
+
+	enabled := true
+	providerIDs := make(map[string]uint)
+	for _, providerType := range []string{consts.StyleOpenAI, consts.StyleAnthropic, consts.StyleBedrock} {
+		provider := models.Provider{Name: providerType, Type: providerType, Config: "{}"}
+		if err := db.Create(&provider).Error; err != nil {
+			t.Fatal(err)
+		}
+		providerIDs[providerType] = provider.ID
+	}
+	associate := func(model string, providerTypes ...string) {
+		m := models.Model{Name: model}
+		if err := db.Create(&m).Error; err != nil {
+			t.Fatal(err)
+		}
+		for _, providerType := range providerTypes {
+			if err := db.Create(&models.ModelWithProvider{ModelID: m.ID, ProviderID: providerIDs[providerType], ProviderModel: model, Status: &enabled, Weight: 1}).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	associate("mixed", consts.StyleOpenAI, consts.StyleAnthropic, consts.StyleBedrock)
+	associate("claude-only", consts.StyleAnthropic)
+
+	tests := []struct {
+		name      string
+		model     string
+		style     string
+		providers []string
+		wantErr   bool
+	}{
+		{"openai keeps openai", "mixed", consts.StyleOpenAI, []string{consts.StyleOpenAI}, false},
+		{"anthropic keeps anthropic and bedrock", "mixed", consts.StyleAnthropic, []string{consts.StyleAnthropic, consts.StyleBedrock}, false},
+		{"no matching style", "claude-only", consts.StyleOpenAI, nil, true},
+		{"no responses provider", "mixed", consts.StyleOpenAIRes, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta, err := ProvidersWithMetaBymodelsName(ctx, tt.style, Before{Model: tt.model})
+			if tt.wantErr {
+				if !errors.Is(err, ErrNoMatchingProvider) {
+					t.Fatalf("expected ErrNoMatchingProvider, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ProvidersWithMetaBymodelsName() error = %v", err)
+			}
+			if len(meta.ModelWithProviderMap) != len(tt.providers) || len(meta.WeightItems) != len(tt.providers) {
+				t.Fatalf("got %d associations and %d weight items, want %d", len(meta.ModelWithProviderMap), len(meta.WeightItems), len(tt.providers))
+			}
+			for id, mp := range meta.ModelWithProviderMap {
+				provider, ok := meta.ProviderMap[mp.ProviderID]
+				if !ok || !slices.Contains(tt.providers, provider.Type) {
+					t.Errorf("association %d has provider %+v", id, provider)
+				}
+				if _, ok := meta.WeightItems[id]; !ok {
+					t.Errorf("association %d missing from weight items", id)
+				}
+			}
+		})
+	}
+}
+
+func TestProvidersWithMetaLimits(t *testing.T) {
+	db := setupChatDB(t)
+	ctx := context.Background()
+
+	upstream := func(name string) string {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"choices":[{"message":{"content":"%s"}}]}`, name)
+		}))
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+	enabled := true
+	associate := func(model string, providers map[string]string) {
+		m := models.Model{Name: model, MaxRetry: 1, TimeOut: 10}
+		if err := db.Create(&m).Error; err != nil {
+			t.Fatal(err)
+		}
+		for name, limits := range providers {
+			provider := models.Provider{Name: model + "-" + name, Type: consts.StyleOpenAI,
+				Config: `{"base_url":"` + upstream(name) + `","api_key":"sk-test"` + limits + `}`}
+			if err := db.Create(&provider).Error; err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Create(&models.ModelWithProvider{ModelID: m.ID, ProviderID: provider.ID, ProviderModel: "gpt",
+				Status: &enabled, Weight: 1}).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	associate("mixed", map[string]string{
+		"limited":   `,"max_messages":2,"max_prompt_chars":20`,
+		"unlimited": "",
+	})
+	associate("limited-only", map[string]string{"limited": `,"max_messages":2`})
+
+	message := func(content string) string {
+		return `{"role":"user","content":"` + content + `"}`
+	}
+	tests := []struct {
+		name     string
+		model    string
+		messages []string
+		want     []string // 可选的提供商 为空时期望 ErrRequestTooLarge
+	}{
+		{"small request uses both", "mixed", []string{message("hi")}, []string{"limited", "unlimited"}},
+		{"too many messages", "mixed", []string{message("a"), message("b"), message("c")}, []string{"unlimited"}},
+		{"prompt too long", "mixed", []string{message(strings.Repeat("x", 21))}, []string{"unlimited"}},
+		{"prompt counts runes", "mixed", []string{message(strings.Repeat("长", 19))}, []string{"limited", "unlimited"}},
+		{"no provider qualifies", "limited-only", []string{message("a"), message("b"), message("c")}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, err := BeforerOpenAI([]byte(`{"model":"` + tt.model + `","messages":[` + strings.Join(tt.messages, ",") + `]}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta, err := ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAI, *before)
+			if tt.want == nil {
+				if !errors.Is(err, ErrRequestTooLarge) || !strings.Contains(err.Error(), "messages=3") {
+					t.Fatalf("expected ErrRequestTooLarge with reason, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ProvidersWithMetaBymodelsName() error = %v", err)
+			}
+			var got []string
+			for _, mp := range meta.ModelWithProviderMap {
+				got = append(got, strings.TrimPrefix(meta.ProviderMap[mp.ProviderID].Name, tt.model+"-"))
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("candidates = %v, want %v", got, tt.want)
+			}
+			if len(tt.want) != 1 {
+				return
+			}
+			// 超出限制的请求始终由能承载的提供商处理
+			for range 5 {
+				res, _, err := BalanceChat(ctx, time.Now(), consts.StyleOpenAI, *before, *meta, models.ReqMeta{Header: http.Header{}})
+				if err != nil {
+					t.Fatalf("BalanceChat() error = %v", err)
+				}
+				body, _ := io.ReadAll(res.Body)
+				res.Body.Close()
+				if !strings.Contains(string(body), tt.want[0]) {
+					t.Errorf("served by %s, want %s", body, tt.want[0])
+				}
+			}
+		})
+	}
+}
+
+func TestProvidersWithMetaMinHealthy(t *testing.T) {
+	db := setupChatDB(t)
+	ctx := context.Background()
+
+	provider := models.Provider{Name: "p", Type: consts.StyleOpenAI, Config: "{}"}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	refuse := true
+	cooldownUntil := time.Now().Add(time.Hour)
+	for _, m := range []models.Model{
+		{Name: "default"},
+		{Name: "warn", MinHealthyProviders: 2},
+		{Name: "refuse", MinHealthyProviders: 2, RefuseDegraded: &refuse},
+		{Name: "met", MinHealthyProviders: 1, RefuseDegraded: &refuse},
+	} {
+		if err := db.Create(&m).Error; err != nil {
+			t.Fatal(err)
+		}
+		// 两个关联 其中一个处于冷却
+		for i, until := range []*time.Time{nil, &cooldownUntil} {
+			mp := models.ModelWithProvider{ModelID: m.ID, ProviderID: provider.ID, ProviderModel: fmt.Sprintf("%s-%d", m.Name, i), Status: &enabled, Weight: 1, ProviderCooldownUntil: until}
+			if err := db.Create(&mp).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tests := []struct {
+		model   string
+		wantErr bool
+	}{
+		{"default", false},
+		{"warn", false},
+		{"refuse", true},
+		{"met", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			meta, err := ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAI, Before{Model: tt.model})
+			if tt.wantErr {
+				if !errors.Is(err, ErrInsufficientProviders) {
+					t.Fatalf("expected ErrInsufficientProviders, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ProvidersWithMetaBymodelsName() error = %v", err)
+			}
+			if len(meta.ModelWithProviderMap) != 2 {
+				t.Fatalf("got %d associations, want 2", len(meta.ModelWithProviderMap))
+			}
+		})
+	}
+
+	var logs []models.ChatLog
+	if err := db.Where("name = ?", "refuse").Find(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].Status != "error" {
+		t.Fatalf("expected one error log for refused model, got %+v", logs)
+	}
+}
+
+func TestBalanceChatCompletion(t *testing.T) {
+	db := setupChatDB(t)
+
+	var paths sync.Map
+	upstream := func(name string) string {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths.Store(name, r.URL.Path)
+			fmt.Fprintf(w, `{"choices":[{"text":"%s"}],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`, name)
+		}))
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+
+	model := models.Model{Name: "codestral", MaxRetry: 3, TimeOut: 10}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled, disabled := true, false
+	for _, p := range []struct {
+		name       string
+		completion *bool
+	}{
+		{"chat-only", &disabled},
+		{"fim", &enabled},
+	} {
+		provider := models.Provider{Name: p.name, Type: consts.StyleOpenAI, Config: `{"base_url":"` + upstream(p.name) + `","api_key":"sk-test"}`}
+		if err := db.Create(&provider).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "codestral",
+			Status: &enabled, Weight: 1, Completion: p.completion}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	before, err := BeforerOpenAICompletion([]byte(`{"model":"codestral","prompt":"def f(","suffix":"):"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAICompletion, *before)
+	if err != nil {
+		t.Fatalf("ProvidersWithMetaBymodelsName() error = %v", err)
+	}
+	if len(meta.ModelWithProviderMap) != 1 {
+		t.Fatalf("got %d associations, want only the completion-capable one", len(meta.ModelWithProviderMap))
+	}
+	res, _, err := BalanceChat(ctx, time.Now(), consts.StyleOpenAICompletion, *before, *meta, models.ReqMeta{Header: http.Header{}})
+	if err != nil {
+		t.Fatalf("BalanceChat() error = %v", err)
+	}
+	defer res.Body.Close()
+	log, _, err := ProcesserOpenAI(ctx, res.Body, false, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.Usage.TotalTokens != 5 {
+		t.Errorf("total tokens = %d, want 5", log.Usage.TotalTokens)
+	}
+	if path, _ := paths.Load("fim"); path != "/completions" {
+		t.Errorf("upstream path = %v, want /completions", path)
+	}
+	if _, ok := paths.Load("chat-only"); ok {
+		t.Error("chat-only provider should not serve completions")
+	}
+}
+
+func TestBalanceChatUserAgent(t *testing.T) {
+	db := setupChatDB(t)
+
+	userAgents := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents <- r.Header.Get("User-Agent")
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"ok"}}]}`)
+	}))
+	t.Cleanup(server.Close)
+
+	enabled := true
+	for _, tt := range []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{"default", "", consts.UserAgent()},
+		{"configured", "acme-gateway/2.1", "acme-gateway/2.1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			model := models.Model{Name: "ua-" + tt.name, MaxRetry: 1, TimeOut: 10}
+			if err := db.Create(&model).Error; err != nil {
+				t.Fatal(err)
+			}
+			provider := models.Provider{Name: "ua-" + tt.name, Type: consts.StyleOpenAI,
+				Config: `{"base_url":"` + server.URL + `","api_key":"sk-test","user_agent":"` + tt.userAgent + `"}`}
+			if err := db.Create(&provider).Error; err != nil {
+				t.Fatal(err)
+			}
+			// 透传客户端 header 时仍以提供商配置为准
+			if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt",
+				Status: &enabled, Weight: 1, WithHeader: &enabled}).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+			before, err := BeforerOpenAI([]byte(`{"model":"` + model.Name + `","messages":[]}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta, err := ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAI, *before)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reqMeta := models.ReqMeta{Header: http.Header{"User-Agent": []string{"OpenAI/Python 1.0"}}}
+			res, _, err := BalanceChat(ctx, time.Now(), consts.StyleOpenAI, *before, *meta, reqMeta)
+			if err != nil {
+				t.Fatalf("BalanceChat() error = %v", err)
+			}
+			res.Body.Close()
+			if got := <-userAgents; got != tt.want {
+				t.Errorf("upstream User-Agent = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStandbyTiers(t *testing.T) {
+	weights := map[uint]int{1: 3, 2: 1, 3: 0, 4: 0}
+	tiers := map[uint]int{1: 0, 2: 1, 3: 0, 4: 1}
+	gotWeights, gotTiers := standbyTiers(weights, tiers)
+	wantWeights := map[uint]int{1: 3, 2: 1, 3: 1, 4: 1}
+	wantTiers := map[uint]int{1: 0, 2: 1, 3: 2, 4: 3}
+	for id := range weights {
+		if gotWeights[id] != wantWeights[id] || gotTiers[id] != wantTiers[id] {
+			t.Errorf("id %d: weight %d tier %d, want %d %d", id, gotWeights[id], gotTiers[id], wantWeights[id], wantTiers[id])
+		}
+	}
+	// 不修改原始数据
+	if weights[3] != 0 || tiers[3] != 0 {
+		t.Error("standbyTiers mutated its input")
+	}
+
+	// 全部为备用时按原层级顺序依次尝试
+	_, onlyStandby := standbyTiers(map[uint]int{1: 0, 2: 0}, map[uint]int{1: 1, 2: 0})
+	if onlyStandby[1] != 1 || onlyStandby[2] != 0 {
+		t.Errorf("standby-only tiers = %v", onlyStandby)
+	}
+}
+
+func TestBuildHeaders(t *testing.T) {
+	source := http.Header{}
+	source.Set("Authorization", "Bearer client")
+	source.Set("X-Api-Key", "client")
+	source.Set("Cookie", "session=1")
+	source.Set("X-Stainless-Os", "Linux")
+	source.Set("X-Stainless-Lang", "js")
+	source.Set("X-Tenant", "acme")
+	source.Set("User-Agent", "sdk")
+
+	tests := []struct {
+		name       string
+		withHeader bool
+		forward    []string
+		want       []string
+	}{
+		{name: "none"},
+		{name: "allow list", forward: []string{"x-stainless-*", "X-Tenant"}, want: []string{"X-Stainless-Lang", "X-Stainless-Os", "X-Tenant"}},
+		// 白名单中的鉴权头同样被移除
+		{name: "auth never forwarded", forward: []string{"Authorization", "X-Api-Key", "Cookie"}, want: []string{"Cookie"}},
+		{name: "with header clones all", withHeader: true, forward: []string{"X-Tenant"}, want: []string{"Cookie", "User-Agent", "X-Stainless-Lang", "X-Stainless-Os", "X-Tenant"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := buildHeaders(source, tt.withHeader, tt.forward, map[string]string{"X-Custom": "1"}, false)
+			if header.Get("X-Custom") != "1" {
+				t.Error("custom header missing")
+			}
+			header.Del("X-Custom")
+			got := slices.Sorted(maps.Keys(header))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("forwarded = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateForwardHeaders(t *testing.T) {
+	if err := ValidateForwardHeaders([]string{"X-Tenant", "X-Stainless-*"}); err != nil {
+		t.Errorf("valid list rejected: %v", err)
+	}
+	for _, invalid := range []string{"", "*", "X-*-Os", "X Tenant", "X-Tenant:"} {
+		if err := ValidateForwardHeaders([]string{invalid}); err == nil {
+			t.Errorf("ValidateForwardHeaders(%q) accepted", invalid)
+		}
+	}
+}
+
+func TestBalanceChatStandby(t *testing.T) {
+	db := setupChatDB(t)
+
+	var primaryDown atomic.Bool
+	var standbyHits atomic.Int32
+	upstream := func(name string, handler func(w http.ResponseWriter)) string {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler(w)
+			fmt.Fprintf(w, `{"choices":[{"message":{"content":"%s"}}]}`, name)
+		}))
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+
+	model := models.Model{Name: "gpt-4o", MaxRetry: 3, TimeOut: 10}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	for _, p := range []struct {
+		name    string
+		weight  int
+		handler func(w http.ResponseWriter)
+	}{
+		{"primary", 1, func(w http.ResponseWriter) {
+			if primaryDown.Load() {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}},
+		{"standby", 0, func(w http.ResponseWriter) { standbyHits.Add(1) }},
+	} {
+		provider := models.Provider{Name: p.name, Type: consts.StyleOpenAI, Config: `{"base_url":"` + upstream(p.name, p.handler) + `","api_key":"sk-test"}`}
+		if err := db.Create(&provider).Error; err != nil {
+			t.Fatal(err)
+		}
+		mp := models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: &enabled, Weight: p.weight}
+		if err := db.Create(&mp).Error; err != nil {
+			t.Fatal(err)
+		}
+		// weight 带数据库默认值 零值需单独写入
+		if err := db.Model(&mp).Select("weight").Updates(map[string]any{"weight": p.weight}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	serve := func() string {
+		t.Helper()
+		before, err := BeforerOpenAI([]byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta, err := ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAI, *before)
+		if err != nil {
+			t.Fatalf("ProvidersWithMetaBymodelsName() error = %v", err)
+		}
+		res, _, err := BalanceChat(ctx, time.Now(), consts.StyleOpenAI, *before, *meta, models.ReqMeta{Header: http.Header{}})
+		if err != nil {
+			t.Fatalf("BalanceChat() error = %v", err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return string(body)
+	}
+
+	// 正权重提供商可用时备用提供商不接收流量
+	for range 10 {
+		if body := serve(); !strings.Contains(body, "primary") {
+			t.Fatalf("served by %s, want primary", body)
+		}
+	}
+	if got := standbyHits.Load(); got != 0 {
+		t.Fatalf("standby hits = %d while primary healthy, want 0", got)
+	}
+
+	// 正权重提供商全部不可用后切换到备用
+	primaryDown.Store(true)
+	if body := serve(); !strings.Contains(body, "standby") {
+		t.Errorf("served by %s, want standby", body)
+	}
+}
+
+func TestRecordLogFormatWarning(t *testing.T) {
+	db := setupChatDB(t)
+	log := models.ChatLog{Name: "gpt-4o", Status: "success"}
+	if err := db.Create(&log).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 上游返回 200 但结构已变化 日志仍为成功并带有告警
+	body := io.NopCloser(strings.NewReader(`{"result":{"text":"hi"},"token_usage":{"total":2}}`))
+	RecordLog(context.Background(), time.Now(), body, ProcesserOpenAI, log.ID, Before{Model: "gpt-4o"}, false)
+
+	if err := db.First(&log, log.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if log.Status != "success" || log.FormatWarning != "response missing expected choices" || log.Size == 0 {
+		t.Errorf("log = status %s warning %q size %d", log.Status, log.FormatWarning, log.Size)
+	}
+}
+
+func TestRecordLogFailureReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"client abort", context.Canceled, models.FailureReasonClientAbort},
+		{"idle timeout", ErrStreamIdleTimeout, models.FailureReasonTimeout},
+		{"upstream reset", errors.New("connection reset by peer"), models.FailureReasonProviderError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupChatDB(t)
+			log := models.ChatLog{Name: "gpt-4o", Status: "success"}
+			if err := db.Create(&log).Error; err != nil {
+				t.Fatal(err)
+			}
+			// 流式响应读到一半中断
+			chunk := strings.NewReader("data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\n")
+			body := io.NopCloser(io.MultiReader(chunk, iotest.ErrReader(tt.err)))
+			RecordLog(context.Background(), time.Now(), body, ProcesserOpenAI, log.ID, Before{Model: "gpt-4o", Stream: true}, false)
+
+			if err := db.First(&log, log.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if log.Status != "error" || log.FailureReason != tt.want {
+				t.Errorf("log = status %s reason %q, want error %q", log.Status, log.FailureReason, tt.want)
+			}
+		})
+	}
+}
+
+func TestProvidersWithMetaExcluded(t *testing.T) {
+	db := setupChatDB(t)
+	ctx := context.Background()
+
+	model := models.Model{Name: "gpt", MaxRetry: 1, TimeOut: 10}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	for _, name := range []string{"alpha", "beta", "gamma"} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"choices":[{"message":{"content":"%s"}}]}`, name)
+		}))
+		t.Cleanup(server.Close)
+		provider := models.Provider{Name: name, Type: consts.StyleOpenAI, Config: `{"base_url":"` + server.URL + `","api_key":"sk-test"}`}
+		if err := db.Create(&provider).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt",
+			Status: &enabled, Weight: 1}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, err := BeforerOpenAI([]byte(`{"model":"gpt","messages":[{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		want   []string // 剩余的提供商 为空时期望 ErrProvidersExcluded
+	}{
+		{"no exclusion", "", []string{"alpha", "beta", "gamma"}},
+		{"exclude one", "beta", []string{"alpha", "gamma"}},
+		{"trims and dedupes", " alpha , beta,alpha ,", []string{"gamma"}},
+		{"unknown name ignored", "delta", []string{"alpha", "beta", "gamma"}},
+		{"exclude all", "alpha,beta,gamma", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := WithExcludedProviders(ctx, ParseExcludedProviders(tt.header))
+			meta, err := ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAI, *before)
+			if tt.want == nil {
+				if !errors.Is(err, ErrProvidersExcluded) || !strings.Contains(err.Error(), "alpha, beta, gamma") {
+					t.Fatalf("expected ErrProvidersExcluded listing the names, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ProvidersWithMetaBymodelsName() error = %v", err)
+			}
+			var got []string
+			for _, mp := range meta.ModelWithProviderMap {
+				got = append(got, meta.ProviderMap[mp.ProviderID].Name)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("candidates = %v, want %v", got, tt.want)
+			}
+
+			// 排除项记录在请求日志中 请求不会发往被排除的提供商
+			res, logID, err := BalanceChat(ctx, time.Now(), consts.StyleOpenAI, *before, *meta, models.ReqMeta{Header: http.Header{}})
+			if err != nil {
+				t.Fatalf("BalanceChat() error = %v", err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if !slices.ContainsFunc(tt.want, func(name string) bool { return strings.Contains(string(body), name) }) {
+				t.Errorf("served by %s, want one of %v", body, tt.want)
+			}
+			var log models.ChatLog
+			if err := db.First(&log, logID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if want := strings.Join(ParseExcludedProviders(tt.header), ","); log.ExcludedProviders != want {
+				t.Errorf("ExcludedProviders = %q, want %q", log.ExcludedProviders, want)
+			}
+		})
+	}
+}
+
+func TestBalanceChatMockFailover(t *testing.T) {
+	const (
+		healthy = `{"body":{"choices":[{"message":{"content":"healthy"}}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}},` +
+			`"chunks":[{"choices":[{"delta":{"content":"healthy"}}]},{"choices":[],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}},"[DONE]"]}`
+		broken = `{"body":{},"failure_rate":1,"failure_status":502}`
+		// 状态码 200 但响应体携带错误
+		errorBody = `{"body":{"error":{"message":"upstream overloaded","type":"server_error"}}}`
+		// 状态码 200 但响应体在传输中途截断
+		truncated = `{"body":"{\"choices\":[{\"message\":{\"content\":\"hea"}`
+	)
+	tests := []struct {
+		name      string
+		providers []string // mock 配置 依次作为各层级的提供商 保证先尝试故障的提供商
+		stream    bool
+		failures  int64 // 期望的重试错误日志数
+		wantErr   error
+	}{
+		{"fails over to healthy", []string{broken, healthy}, false, 1, nil},
+		{"stream fails over to healthy", []string{broken, healthy}, true, 1, nil},
+		{"all broken exhausts", []string{broken, broken}, false, 2, ErrProvidersExhausted},
+		{"200 error body fails over", []string{errorBody, healthy}, false, 1, nil},
+		{"truncated body fails over", []string{truncated, healthy}, false, 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupChatDB(t)
+			model := models.Model{Name: "mocked", MaxRetry: 3, TimeOut: 10}
+			if err := db.Create(&model).Error; err != nil {
+				t.Fatal(err)
+			}
+			enabled := true
+			for i, config := range tt.providers {
+				provider := models.Provider{Name: fmt.Sprintf("mock-%d", i), Type: consts.StyleMock, Config: config}
+				if err := db.Create(&provider).Error; err != nil {
+					t.Fatal(err)
+				}
+				if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "mocked",
+					Status: &enabled, Weight: 1, Tier: i}).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ctx := context.Background()
+			before, err := BeforerOpenAI([]byte(fmt.Sprintf(`{"model":"mocked","messages":[{"role":"user","content":"hi"}],"stream":%t}`, tt.stream)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta, err := ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAI, *before)
+			if err != nil {
+				t.Fatalf("ProvidersWithMetaBymodelsName() error = %v", err)
+			}
+			res, _, err := BalanceChat(ctx, time.Now(), consts.StyleOpenAI, *before, *meta, models.ReqMeta{Header: http.Header{}})
+			// 每个故障的提供商各记录一条重试日志 等待异步写入完成
+			deadline := time.Now().Add(2 * time.Second)
+			for {
+				var count int64
+				db.Model(&models.ChatLog{}).Where("status = ?", "error").Count(&count)
+				if count == tt.failures {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("error logs = %d, want %d", count, tt.failures)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			// 上游故障归为提供商错误
+			var reasons []string
+			db.Model(&models.ChatLog{}).Where("status = ?", "error").Distinct().Pluck("failure_reason", &reasons)
+			if len(reasons) != 1 || reasons[0] != models.FailureReasonProviderError {
+				t.Errorf("failure reasons = %v, want [%s]", reasons, models.FailureReasonProviderError)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("BalanceChat() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BalanceChat() error = %v", err)
+			}
+			defer res.Body.Close()
+			if providerName, _, _ := SelectedProvider(res); providerName != "mock-1" {
+				t.Errorf("selected provider = %q, want mock-1", providerName)
+			}
+			// 注入的失败与真实上游一样触发冷却
+			var failed models.ModelWithProvider
+			if err := db.Where("tier = ?", 0).First(&failed).Error; err != nil {
+				t.Fatal(err)
+			}
+			if failed.ProviderCooldownUntil == nil || failed.ProviderCooldownStep != 1 {
+				t.Errorf("broken provider cooldown = %v step %d, want cooldown", failed.ProviderCooldownUntil, failed.ProviderCooldownStep)
+			}
+			log, output, err := ProcesserOpenAI(ctx, res.Body, tt.stream, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if log.Usage.TotalTokens != 2 {
+				t.Errorf("total tokens = %d, want 2", log.Usage.TotalTokens)
+			}
+			if !strings.Contains(output.OfString, "healthy") && !strings.Contains(strings.Join(output.OfStringArray, ""), "healthy") {
+				t.Errorf("output = %+v, want healthy", output)
+			}
+		})
+	}
+}
+
+func TestBalanceChatDefaultTimeOut(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string // mock 提供商配置
+		wantErr bool
+	}{
+		// 模型超时为 0 时不应立即超时
+		{"zero model timeout uses default", `{"body":{"choices":[{"message":{"content":"ok"}}]}}`, false},
+		// 模型未设置超时时使用提供商的默认超时
+		{"provider default applies", `{"body":{},"latency":1500,"time_out":1}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupChatDB(t)
+			model := models.Model{Name: "untimed", MaxRetry: 1}
+			if err := db.Create(&model).Error; err != nil {
+				t.Fatal(err)
+			}
+			provider := models.Provider{Name: "mock", Type: consts.StyleMock, Config: tt.config}
+			if err := db.Create(&provider).Error; err != nil {
+				t.Fatal(err)
+			}
+			enabled := true
+			if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "untimed", Status: &enabled, Weight: 1}).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+			before, err := BeforerOpenAI([]byte(`{"model":"untimed","messages":[{"role":"user","content":"hi"}]}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta, err := ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAI, *before)
+			if err != nil {
+				t.Fatalf("ProvidersWithMetaBymodelsName() error = %v", err)
+			}
+			res, _, err := BalanceChat(ctx, time.Now(), consts.StyleOpenAI, *before, *meta, models.ReqMeta{Header: http.Header{}})
+			if tt.wantErr {
+				if !errors.Is(err, ErrProvidersExhausted) {
+					t.Fatalf("BalanceChat() error = %v, want %v", err, ErrProvidersExhausted)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("BalanceChat() error = %v", err)
+				}
+				res.Body.Close()
+			}
+			// 等待失败尝试的日志写完 避免测试数据库关闭后写入
+			var failures int64
+			if tt.wantErr {
+				failures = 1
+			}
+			deadline := time.Now().Add(2 * time.Second)
+			for {
+				var count int64
+				db.Model(&models.ChatLog{}).Where("status = ?", "error").Count(&count)
+				if count == failures {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("error logs = %d, want %d", count, failures)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		})
+	}
+}
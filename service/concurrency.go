@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultQueueTimeout 模型未设置排队超时时的最长等待
+const DefaultQueueTimeout = 30 * time.Second
+
+// ErrQueueTimeout 等待模型并发名额超时
+var ErrQueueTimeout = errors.New("model concurrency queue timeout")
+
+// modelLimiters 全局的模型并发限制 按模型 ID 查找
+var modelLimiters = struct {
+	sync.Mutex
+	limiters map[uint]*fairLimiter
+}{limiters: make(map[uint]*fairLimiter)}
+
+type queueWaitKey struct{}
+
+// AcquireModelSlot 获取模型的并发名额 超出上限时按 auth key 公平排队
+// 返回的 ctx 携带排队时间 供日志记录 release 需在请求结束后调用
+func AcquireModelSlot(ctx context.Context, providersWithMeta ProvidersWithMeta, authKeyID uint) (context.Context, func(), error) {
+	if providersWithMeta.MaxConcurrency <= 0 {
+		return ctx, func() {}, nil
+	}
+	modelLimiters.Lock()
+	limiter, ok := modelLimiters.limiters[providersWithMeta.ModelID]
+	if !ok {
+		limiter = newFairLimiter()
+		modelLimiters.limiters[providersWithMeta.ModelID] = limiter
+	}
+	modelLimiters.Unlock()
+
+	timeout := providersWithMeta.QueueTimeout
+	if timeout <= 0 {
+		timeout = DefaultQueueTimeout
+	}
+	start := time.Now()
+	if err := limiter.acquire(ctx, authKeyID, providersWithMeta.MaxConcurrency, timeout); err != nil {
+		return ctx, nil, err
+	}
+	return context.WithValue(ctx, queueWaitKey{}, time.Since(start)), limiter.release, nil
+}
+
+func queueWaitFrom(ctx context.Context) time.Duration {
+	wait, _ := ctx.Value(queueWaitKey{}).(time.Duration)
+	return wait
+}
+
+// fairWaiter 排队中的请求 ready 关闭表示已获得名额
+type fairWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// fairLimiter 并发上限内直接放行 超出后各 key 内先进先出 key 之间轮流获得空出的名额
+// 避免单个 key 的大量请求占满队列
+type fairLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	active int
+	queues map[uint][]*fairWaiter
+	order  []uint // 有排队请求的 key 轮转顺序
+}
+
+func newFairLimiter() *fairLimiter {
+	return &fairLimiter{queues: make(map[uint][]*fairWaiter)}
+}
+
+func (l *fairLimiter) acquire(ctx context.Context, key uint, limit int, timeout time.Duration) error {
+	l.mu.Lock()
+	// 上限以最新的模型配置为准
+	l.limit = limit
+	if len(l.order) == 0 && l.active < l.limit {
+		l.active++
+		l.mu.Unlock()
+		return nil
+	}
+	w := &fairWaiter{ready: make(chan struct{})}
+	if len(l.queues[key]) == 0 {
+		l.order = append(l.order, key)
+	}
+	l.queues[key] = append(l.queues[key], w)
+	l.dispatch()
+	l.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-w.ready:
+		return nil
+	case <-timer.C:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// 超时的同时可能已获得名额 此时照常使用
+	if w.granted {
+		return nil
+	}
+	l.remove(key, w)
+	return err
+}
+
+// release 归还名额并交给下一个排队的请求
+func (l *fairLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	l.dispatch()
+}
+
+// dispatch 按 key 轮转把空闲名额分给排队的请求
+func (l *fairLimiter) dispatch() {
+	for l.active < l.limit && len(l.order) > 0 {
+		key := l.order[0]
+		l.order = l.order[1:]
+		queue := l.queues[key]
+		w := queue[0]
+		if len(queue) > 1 {
+			l.queues[key] = queue[1:]
+			l.order = append(l.order, key)
+		} else {
+			delete(l.queues, key)
+		}
+		w.granted = true
+		close(w.ready)
+		l.active++
+	}
+}
+
+func (l *fairLimiter) remove(key uint, w *fairWaiter) {
+	queue := l.queues[key]
+	for i, waiter := range queue {
+		if waiter != w {
+			continue
+		}
+		queue = append(queue[:i], queue[i+1:]...)
+		break
+	}
+	if len(queue) > 0 {
+		l.queues[key] = queue
+		return
+	}
+	delete(l.queues, key)
+	for i, k := range l.order {
+		if k == key {
+			l.order = append(l.order[:i], l.order[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// queued 返回排队中的请求数
+func (l *fairLimiter) queued() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, queue := range l.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+func TestFairLimiterAlternatesKeys(t *testing.T) {
+	l := newFairLimiter()
+	ctx := context.Background()
+	// 占满唯一的名额 之后的请求全部排队
+	if err := l.acquire(ctx, 0, 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var granted []string
+	var wg sync.WaitGroup
+	enqueue := func(key uint, name string) {
+		want := l.queued() + 1
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.acquire(ctx, key, 1, 5*time.Second); err != nil {
+				t.Errorf("acquire %s: %v", name, err)
+				return
+			}
+			mu.Lock()
+			granted = append(granted, name)
+			mu.Unlock()
+			l.release()
+		}()
+		// 保证按调用顺序入队
+		for l.queued() != want {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// 吵闹的 key 1 先排入大量请求 key 2 随后到达
+	for _, name := range []string{"a1", "a2", "a3", "a4"} {
+		enqueue(1, name)
+	}
+	for _, name := range []string{"b1", "b2"} {
+		enqueue(2, name)
+	}
+	l.release()
+	wg.Wait()
+
+	want := []string{"a1", "b1", "a2", "b2", "a3", "a4"}
+	if len(granted) != len(want) {
+		t.Fatalf("granted = %v, want %v", granted, want)
+	}
+	for i := range want {
+		if granted[i] != want[i] {
+			t.Fatalf("granted = %v, want %v", granted, want)
+		}
+	}
+	if l.active != 0 {
+		t.Errorf("active = %d, want 0", l.active)
+	}
+}
+
+func TestFairLimiterQueueTimeout(t *testing.T) {
+	l := newFairLimiter()
+	ctx := context.Background()
+	if err := l.acquire(ctx, 1, 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.acquire(ctx, 2, 1, 20*time.Millisecond); !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("acquire error = %v, want ErrQueueTimeout", err)
+	}
+	// 超时的请求离开队列 归还名额后新请求直接获得
+	if n := l.queued(); n != 0 {
+		t.Errorf("queued = %d, want 0", n)
+	}
+	l.release()
+	if err := l.acquire(ctx, 2, 1, 20*time.Millisecond); err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+
+	// 上限提高后排队的请求立即获得名额
+	done := make(chan error, 1)
+	go func() { done <- l.acquire(ctx, 3, 1, time.Second) }()
+	for l.queued() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := l.acquire(ctx, 4, 3, time.Second); err != nil {
+		t.Fatalf("acquire with raised limit: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("queued acquire: %v", err)
+	}
+}
+
+func TestAcquireModelSlotRecordsQueueTime(t *testing.T) {
+	meta := ProvidersWithMeta{ModelID: 9001, MaxConcurrency: 1, QueueTimeout: time.Second}
+	t.Cleanup(func() {
+		modelLimiters.Lock()
+		delete(modelLimiters.limiters, meta.ModelID)
+		modelLimiters.Unlock()
+	})
+	_, release, err := AcquireModelSlot(context.Background(), meta, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		release()
+	}()
+	ctx, release2, err := AcquireModelSlot(context.Background(), meta, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release2()
+	if wait := queueWaitFrom(ctx); wait < 30*time.Millisecond {
+		t.Errorf("queue wait = %v, want at least 30ms", wait)
+	}
+
+	// 未设置上限时不排队
+	ctx, release3, err := AcquireModelSlot(context.Background(), ProvidersWithMeta{ModelID: 9002}, 1)
+	if err != nil || queueWaitFrom(ctx) != 0 {
+		t.Fatalf("unlimited acquire = %v wait %v", err, queueWaitFrom(ctx))
+	}
+	release3()
+}
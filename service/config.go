@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service/cooldown"
+	"gorm.io/gorm"
+)
+
+// LoadConfig 从配置表读取指定 key 的 JSON 配置，配置不存在时返回 nil
+func LoadConfig[T any](ctx context.Context, key string) (*T, error) {
+	config, err := gorm.G[models.Config](models.DB).Where("key = ?", key).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if config.Value == "" {
+		return nil, nil
+	}
+	var value T
+	if err := json.Unmarshal([]byte(config.Value), &value); err != nil {
+		return nil, fmt.Errorf("invalid %s config: %w", key, err)
+	}
+	return &value, nil
+}
+
+// SaveConfig 将配置序列化为 JSON 写入配置表 不存在时创建
+func SaveConfig[T any](ctx context.Context, key string, value T) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	rows, err := gorm.G[models.Config](models.DB).Where("key = ?", key).Update(ctx, "value", string(data))
+	if err != nil {
+		return err
+	}
+	if rows > 0 {
+		return nil
+	}
+	return gorm.G[models.Config](models.DB).Create(ctx, &models.Config{Key: key, Value: string(data)})
+}
+
+// configValidators 保存前需要校验的配置 未注册的配置不做检查
+var configValidators = map[string]func(value string) error{
+	models.KeyCooldown: func(value string) error {
+		var config models.Cooldown
+		if err := json.Unmarshal([]byte(value), &config); err != nil {
+			return err
+		}
+		_, err := cooldown.ParseSchedule(&config)
+		return err
+	},
+	models.KeyCache: func(value string) error {
+		var config models.Cache
+		if err := json.Unmarshal([]byte(value), &config); err != nil {
+			return err
+		}
+		_, err := ParseCacheTTL(&config)
+		return err
+	},
+	models.KeyModelDiscovery: func(value string) error {
+		var config models.ModelDiscovery
+		if err := json.Unmarshal([]byte(value), &config); err != nil {
+			return err
+		}
+		if config.TTL < 0 {
+			return errors.New("ttl must not be negative")
+		}
+		return nil
+	},
+	models.KeyStreamFlush: func(value string) error {
+		var config models.StreamFlush
+		if err := json.Unmarshal([]byte(value), &config); err != nil {
+			return err
+		}
+		if config.Interval < 0 {
+			return errors.New("interval must not be negative")
+		}
+		return nil
+	},
+	models.KeySlowStream: func(value string) error {
+		var config models.SlowStream
+		if err := json.Unmarshal([]byte(value), &config); err != nil {
+			return err
+		}
+		if config.MinTps < 0 || config.Window < 0 {
+			return errors.New("min_tps and window must not be negative")
+		}
+		return nil
+	},
+	models.KeyWarmup: func(value string) error {
+		var config models.Warmup
+		if err := json.Unmarshal([]byte(value), &config); err != nil {
+			return err
+		}
+		if config.Interval < 0 {
+			return errors.New("interval must not be negative")
+		}
+		return nil
+	},
+	models.KeyRequestDedup: func(value string) error {
+		var config models.RequestDedup
+		if err := json.Unmarshal([]byte(value), &config); err != nil {
+			return err
+		}
+		if config.Window < 0 {
+			return errors.New("window must not be negative")
+		}
+		return nil
+	},
+}
+
+// ValidateConfig 校验待保存的配置内容 空值表示清除配置
+func ValidateConfig(key, value string) error {
+	validate, ok := configValidators[key]
+	if !ok || value == "" {
+		return nil
+	}
+	if err := validate(value); err != nil {
+		return fmt.Errorf("invalid %s config: %w", key, err)
+	}
+	return nil
+}
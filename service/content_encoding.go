@@ -0,0 +1,64 @@
+package service
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// contentDecoders 支持解压的响应编码 deflate 按 RFC 9110 为 zlib 格式
+var contentDecoders = map[string]func(io.Reader) (io.ReadCloser, error){
+	"gzip":   func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	"x-gzip": func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	"deflate": func(r io.Reader) (io.ReadCloser, error) {
+		return zlib.NewReader(r)
+	},
+}
+
+// decodeResponseBody 解压带 Content-Encoding 的上游响应 供用量统计与改写规则解析
+// 客户端总是收到未压缩的内容 不依赖客户端是否接受该编码
+// 不支持的编码保持原样 响应仍可转发但无法统计用量
+func decodeResponseBody(res *http.Response) {
+	encoding := strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" {
+		return
+	}
+	newDecoder, ok := contentDecoders[encoding]
+	if !ok {
+		slog.Warn("unsupported response content encoding", "encoding", encoding)
+		return
+	}
+	res.Body = &decodedBody{body: res.Body, newDecoder: newDecoder}
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	res.Uncompressed = true
+}
+
+// decodedBody 首次读取时才创建解压器 避免读取压缩头时阻塞在首包超时等逻辑之外
+type decodedBody struct {
+	body       io.ReadCloser
+	newDecoder func(io.Reader) (io.ReadCloser, error)
+	decoder    io.ReadCloser
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.decoder == nil {
+		decoder, err := b.newDecoder(b.body)
+		if err != nil {
+			return 0, err
+		}
+		b.decoder = decoder
+	}
+	return b.decoder.Read(p)
+}
+
+func (b *decodedBody) Close() error {
+	if b.decoder != nil {
+		b.decoder.Close()
+	}
+	return b.body.Close()
+}
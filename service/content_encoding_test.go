@@ -0,0 +1,60 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestDecodeResponseBody(t *testing.T) {
+	const payload = "data: {\"usage\":{\"total_tokens\":3}}\n\n"
+	compress := func(newWriter func(io.Writer) io.WriteCloser) []byte {
+		var buf bytes.Buffer
+		w := newWriter(&buf)
+		w.Write([]byte(payload))
+		w.Close()
+		return buf.Bytes()
+	}
+	gzipped := compress(func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+	deflated := compress(func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) })
+
+	tests := []struct {
+		name         string
+		encoding     string
+		body         []byte
+		want         string
+		wantEncoding string
+	}{
+		{name: "identity", encoding: "", body: []byte(payload), want: payload},
+		{name: "gzip", encoding: "gzip", body: gzipped, want: payload},
+		{name: "case insensitive", encoding: " GZIP ", body: gzipped, want: payload},
+		{name: "deflate", encoding: "deflate", body: deflated, want: payload},
+		{name: "unsupported", encoding: "br", body: []byte("raw"), want: "raw", wantEncoding: "br"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(tt.body))}
+			if tt.encoding != "" {
+				res.Header.Set("Content-Encoding", tt.encoding)
+				res.Header.Set("Content-Length", "42")
+			}
+			decodeResponseBody(res)
+			got, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+			if enc := res.Header.Get("Content-Encoding"); enc != tt.wantEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", enc, tt.wantEncoding)
+			}
+			if tt.wantEncoding == "" && tt.encoding != "" && res.Header.Get("Content-Length") != "" {
+				t.Error("Content-Length should be removed after decoding")
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// contextMessagesPath 各接口风格的消息数组 legacy completions 没有消息 不受上下文策略限制
+var contextMessagesPath = map[string]string{
+	consts.StyleOpenAI:    "messages",
+	consts.StyleOpenAIRes: "input",
+	consts.StyleAnthropic: "messages",
+}
+
+// contextSystemPath 单独传入的系统提示词 始终保留 计入 token 估算
+var contextSystemPath = map[string]string{
+	consts.StyleOpenAIRes: "instructions",
+	consts.StyleAnthropic: "system",
+}
+
+// ApplyContextPolicy 按模型的上下文策略检查消息条数与估算 token 数
+// truncate 模式从最早的消息开始丢弃 reject 模式或截断后仍超出时返回 PolicyError 并记录 blocked 日志
+func ApplyContextPolicy(ctx context.Context, style string, before *Before, policy models.ContextPolicy, reqMeta models.ReqMeta) error {
+	raw, dropped, err := truncateContext(style, before.raw, policy)
+	if err != nil {
+		var policyErr PolicyError
+		if errors.As(err, &policyErr) {
+			saveBlockedLog(ctx, style, *before, reqMeta, policyErr)
+		}
+		return err
+	}
+	if dropped > 0 {
+		before.raw = raw
+		before.messageCount -= dropped
+		before.truncatedMessages = dropped
+	}
+	return nil
+}
+
+// truncateContext 返回截断后的请求体与丢弃的消息条数
+// 系统消息与最后一轮用户消息(最后一条用户消息及之后的消息)不会被丢弃 丢弃按整轮进行 避免留下缺少调用的工具结果
+func truncateContext(style string, raw []byte, policy models.ContextPolicy) ([]byte, int, error) {
+	path, ok := contextMessagesPath[style]
+	if !ok || policy.MaxMessages <= 0 && policy.MaxTokens <= 0 {
+		return raw, 0, nil
+	}
+	messages := gjson.GetBytes(raw, path)
+	if !messages.IsArray() {
+		return raw, 0, nil
+	}
+	items := messages.Array()
+	count, tokens := len(items), contentTokens(gjson.GetBytes(raw, contextSystemPath[style]))
+	itemTokens := make([]int, len(items))
+	for i, item := range items {
+		itemTokens[i] = contentTokens(item.Get("content"))
+		tokens += itemTokens[i]
+	}
+	exceeded := func() bool {
+		return policy.MaxMessages > 0 && count > policy.MaxMessages || policy.MaxTokens > 0 && tokens > policy.MaxTokens
+	}
+	if !exceeded() {
+		return raw, 0, nil
+	}
+	if policy.Mode == models.ContextPolicyReject {
+		return nil, 0, PolicyError{Reason: fmt.Sprintf("context exceeds limit: %d messages, about %d tokens", count, tokens)}
+	}
+
+	latest := len(items) - 1
+	for i := len(items) - 1; i >= 0; i-- {
+		if turnStart(items[i]) {
+			latest = i
+			break
+		}
+	}
+	kept := make([]string, 0, len(items))
+	truncating := true
+	for i, item := range items {
+		// 满足上限后继续丢弃到下一轮用户消息为止
+		if truncating && (i >= latest || !exceeded() && turnStart(item)) {
+			truncating = false
+		}
+		if !truncating || systemMessage(item) {
+			kept = append(kept, item.Raw)
+			continue
+		}
+		count--
+		tokens -= itemTokens[i]
+	}
+	if exceeded() {
+		return nil, 0, PolicyError{Reason: fmt.Sprintf("context exceeds limit after truncation: %d messages, about %d tokens", count, tokens)}
+	}
+	raw, err := sjson.SetRawBytes(raw, path, []byte("["+strings.Join(kept, ",")+"]"))
+	if err != nil {
+		return nil, 0, err
+	}
+	return raw, len(items) - len(kept), nil
+}
+
+// contentTokens 按文本估算内容的 token 数
+func contentTokens(content gjson.Result) int {
+	var sb strings.Builder
+	appendText(&sb, content)
+	return estimateTextTokens(sb.String())
+}
+
+// systemMessage 是否为系统消息
+func systemMessage(item gjson.Result) bool {
+	role := item.Get("role").String()
+	return role == "system" || role == "developer"
+}
+
+// turnStart 是否为一轮对话开始的用户消息 Anthropic 的工具结果以用户消息返回 不作为新的一轮
+func turnStart(item gjson.Result) bool {
+	if item.Get("role").String() != "user" {
+		return false
+	}
+	return !item.Get(`content.#(type=="tool_result")`).Exists()
+}
+
+// ValidateContextPolicy 校验上下文策略的模式与上限
+func ValidateContextPolicy(policy models.ContextPolicy) error {
+	switch policy.Mode {
+	case "", models.ContextPolicyTruncate, models.ContextPolicyReject:
+	default:
+		return fmt.Errorf("invalid context policy mode: %s", policy.Mode)
+	}
+	if policy.MaxMessages < 0 || policy.MaxTokens < 0 {
+		return errors.New("context policy limits must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+)
+
+func TestTruncateContext(t *testing.T) {
+	long := strings.Repeat("word ", 400)
+	tests := []struct {
+		name        string
+		style       string
+		body        string
+		policy      models.ContextPolicy
+		wantRoles   string // 保留消息的 role 或 type 逗号分隔 为空表示请求体不变
+		wantDropped int
+		wantErr     bool
+	}{
+		{
+			name:   "within limits",
+			style:  consts.StyleOpenAI,
+			body:   `{"messages":[{"role":"system","content":"s"},{"role":"user","content":"u1"}]}`,
+			policy: models.ContextPolicy{MaxMessages: 2},
+		},
+		{
+			name:        "keeps system and latest user turn",
+			style:       consts.StyleOpenAI,
+			body:        `{"messages":[{"role":"system","content":"s"},{"role":"user","content":"u1"},{"role":"assistant","content":"a1"},{"role":"user","content":"u2"},{"role":"assistant","content":"a2"},{"role":"user","content":"u3"}]}`,
+			policy:      models.ContextPolicy{MaxMessages: 4},
+			wantRoles:   "system:s,user:u2,assistant:a2,user:u3",
+			wantDropped: 2,
+		},
+		{
+			name:        "drops whole turns with tool results",
+			style:       consts.StyleOpenAI,
+			body:        `{"messages":[{"role":"user","content":"u1"},{"role":"assistant","tool_calls":[{"id":"c1"}]},{"role":"tool","content":"r1"},{"role":"assistant","content":"a1"},{"role":"user","content":"u2"}]}`,
+			policy:      models.ContextPolicy{MaxMessages: 4},
+			wantRoles:   "user:u2",
+			wantDropped: 4,
+		},
+		{
+			name:        "token limit drops oldest",
+			style:       consts.StyleOpenAI,
+			body:        `{"messages":[{"role":"developer","content":"d"},{"role":"user","content":"` + long + `"},{"role":"assistant","content":"a1"},{"role":"user","content":"u2"}]}`,
+			policy:      models.ContextPolicy{MaxTokens: 100},
+			wantRoles:   "developer:d,user:u2",
+			wantDropped: 2,
+		},
+		{
+			name:        "anthropic tool result is not a new turn",
+			style:       consts.StyleAnthropic,
+			body:        `{"system":"s","messages":[{"role":"user","content":"u1"},{"role":"assistant","content":[{"type":"tool_use","id":"t"}]},{"role":"user","content":[{"type":"tool_result","tool_use_id":"t"}]},{"role":"assistant","content":"a1"},{"role":"user","content":"u2"},{"role":"assistant","content":"a2"},{"role":"user","content":"u3"}]}`,
+			policy:      models.ContextPolicy{MaxMessages: 5},
+			wantRoles:   "user:u2,assistant:a2,user:u3",
+			wantDropped: 4,
+		},
+		{
+			name:        "responses input",
+			style:       consts.StyleOpenAIRes,
+			body:        `{"instructions":"s","input":[{"role":"user","content":"u1"},{"type":"function_call_output","output":"r"},{"role":"user","content":"u2"}]}`,
+			policy:      models.ContextPolicy{MaxMessages: 1},
+			wantRoles:   "user:u2",
+			wantDropped: 2,
+		},
+		{
+			name:   "responses string input",
+			style:  consts.StyleOpenAIRes,
+			body:   `{"input":"` + long + `"}`,
+			policy: models.ContextPolicy{MaxTokens: 10},
+		},
+		{
+			name:   "completions are not limited",
+			style:  consts.StyleOpenAICompletion,
+			body:   `{"prompt":"` + long + `"}`,
+			policy: models.ContextPolicy{MaxTokens: 10},
+		},
+		{
+			name:    "latest turn alone exceeds",
+			style:   consts.StyleOpenAI,
+			body:    `{"messages":[{"role":"user","content":"u1"},{"role":"user","content":"` + long + `"}]}`,
+			policy:  models.ContextPolicy{MaxTokens: 100},
+			wantErr: true,
+		},
+		{
+			name:    "reject mode",
+			style:   consts.StyleOpenAI,
+			body:    `{"messages":[{"role":"user","content":"u1"},{"role":"assistant","content":"a1"},{"role":"user","content":"u2"}]}`,
+			policy:  models.ContextPolicy{Mode: models.ContextPolicyReject, MaxMessages: 2},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, dropped, err := truncateContext(tt.style, []byte(tt.body), tt.policy)
+			var policyErr PolicyError
+			if tt.wantErr {
+				if !errors.As(err, &policyErr) {
+					t.Fatalf("error = %v, want PolicyError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dropped != tt.wantDropped {
+				t.Errorf("dropped = %d, want %d", dropped, tt.wantDropped)
+			}
+			if tt.wantRoles == "" {
+				if string(raw) != tt.body {
+					t.Errorf("body changed: %s", raw)
+				}
+				return
+			}
+			var roles []string
+			gjson.GetBytes(raw, contextMessagesPath[tt.style]).ForEach(func(_, item gjson.Result) bool {
+				roles = append(roles, item.Get("role").String()+":"+item.Get("content").String())
+				return true
+			})
+			if got := strings.Join(roles, ","); got != tt.wantRoles {
+				t.Errorf("kept = %s, want %s", got, tt.wantRoles)
+			}
+			if system := contextSystemPath[tt.style]; system != "" && gjson.GetBytes(raw, system).String() != "s" {
+				t.Errorf("system prompt lost: %s", raw)
+			}
+		})
+	}
+}
+
+func TestApplyContextPolicy(t *testing.T) {
+	db := setupChatDB(t)
+	ctx := context.Background()
+	body := `{"model":"m","messages":[{"role":"system","content":"s"},{"role":"user","content":"u1"},{"role":"assistant","content":"a1"},{"role":"user","content":"u2"}]}`
+
+	before, err := BeforerOpenAI([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyContextPolicy(ctx, consts.StyleOpenAI, before, models.ContextPolicy{MaxMessages: 2}, models.ReqMeta{}); err != nil {
+		t.Fatal(err)
+	}
+	if before.truncatedMessages != 2 || before.messageCount != 2 || len(gjson.GetBytes(before.raw, "messages").Array()) != 2 {
+		t.Errorf("truncated = %d count = %d body = %s", before.truncatedMessages, before.messageCount, before.raw)
+	}
+
+	// reject 模式记录 blocked 日志
+	before, err = BeforerOpenAI([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ApplyContextPolicy(ctx, consts.StyleOpenAI, before, models.ContextPolicy{Mode: models.ContextPolicyReject, MaxMessages: 2}, models.ReqMeta{})
+	var policyErr PolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("error = %v, want PolicyError", err)
+	}
+	var log models.ChatLog
+	if err := db.Where("status = ?", "blocked").First(&log).Error; err != nil || !strings.Contains(log.Error, "context exceeds limit") {
+		t.Errorf("blocked log = %+v, err = %v", log, err)
+	}
+}
+
+func TestValidateContextPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		policy  models.ContextPolicy
+		wantErr bool
+	}{
+		{models.ContextPolicy{}, false},
+		{models.ContextPolicy{Mode: models.ContextPolicyReject, MaxMessages: 10, MaxTokens: 1000}, false},
+		{models.ContextPolicy{Mode: "drop"}, true},
+		{models.ContextPolicy{MaxTokens: -1}, true},
+	} {
+		if err := ValidateContextPolicy(tt.policy); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateContextPolicy(%+v) error = %v, wantErr %v", tt.policy, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package cooldown
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+)
+
+// Backoff 指数退避参数 第 n 次连续失败冷却 Base*Multiplier^(n-1) 不超过 Max
+type Backoff struct {
+	Base       time.Duration
+	Multiplier float64
+	Max        time.Duration
+	MaxSteps   int // 退避次数上限 之后的失败沿用该次的冷却时间
+}
+
+// DefaultBackoff 未配置时的退避参数 1s 起翻倍 30min 封顶
+var DefaultBackoff = Backoff{Base: time.Second, Multiplier: 2, Max: 30 * time.Minute, MaxSteps: 20}
+
+// Validate 检查参数是否合理
+func (b Backoff) Validate() error {
+	switch {
+	case b.Base <= 0:
+		return errors.New("base must be positive")
+	case b.Multiplier < 1:
+		return errors.New("multiplier must be at least 1")
+	case b.Max < b.Base:
+		return errors.New("max must not be less than base")
+	case b.MaxSteps <= 0:
+		return errors.New("max_steps must be positive")
+	}
+	return nil
+}
+
+// Delay 第 step 次连续失败的冷却时间
+func (b Backoff) Delay(step int) time.Duration {
+	step = min(max(step, 1), b.MaxSteps)
+	delay := float64(b.Base) * math.Pow(b.Multiplier, float64(step-1))
+	if delay >= float64(b.Max) {
+		return b.Max
+	}
+	return time.Duration(delay)
+}
+
+// Schedule 按错误归类区分的退避参数
+type Schedule struct {
+	Key      Backoff
+	Provider Backoff
+}
+
+// DefaultSchedule 两类错误均使用默认退避参数
+func DefaultSchedule() Schedule {
+	return Schedule{Key: DefaultBackoff, Provider: DefaultBackoff}
+}
+
+// ParseSchedule 解析冷却配置 key 与 provider 未设置的字段依次使用全局值与默认值
+func ParseSchedule(config *models.Cooldown) (Schedule, error) {
+	if config == nil {
+		return DefaultSchedule(), nil
+	}
+	global := mergeBackoff(DefaultBackoff, config.CooldownBackoff)
+	schedule := Schedule{Key: global, Provider: global}
+	if config.Key != nil {
+		schedule.Key = mergeBackoff(global, *config.Key)
+	}
+	if config.Provider != nil {
+		schedule.Provider = mergeBackoff(global, *config.Provider)
+	}
+	if err := schedule.Key.Validate(); err != nil {
+		return Schedule{}, fmt.Errorf("invalid key cooldown: %w", err)
+	}
+	if err := schedule.Provider.Validate(); err != nil {
+		return Schedule{}, fmt.Errorf("invalid provider cooldown: %w", err)
+	}
+	return schedule, nil
+}
+
+// mergeBackoff 用配置中的非零值覆盖 base 负数保留以便校验时报错
+func mergeBackoff(base Backoff, config models.CooldownBackoff) Backoff {
+	if config.Base != 0 {
+		base.Base = time.Duration(config.Base) * time.Millisecond
+	}
+	if config.Multiplier != 0 {
+		base.Multiplier = config.Multiplier
+	}
+	if config.Max != 0 {
+		base.Max = time.Duration(config.Max) * time.Millisecond
+	}
+	if config.MaxSteps != 0 {
+		base.MaxSteps = config.MaxSteps
+	}
+	return base
+}
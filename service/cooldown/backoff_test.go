@@ -0,0 +1,121 @@
+package cooldown
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	backoff := Backoff{Base: 500 * time.Millisecond, Multiplier: 3, Max: 10 * time.Second, MaxSteps: 5}
+	tests := []struct {
+		step int
+		want time.Duration
+	}{
+		{step: 0, want: 500 * time.Millisecond},
+		{step: 1, want: 500 * time.Millisecond},
+		{step: 2, want: 1500 * time.Millisecond},
+		{step: 3, want: 4500 * time.Millisecond},
+		{step: 4, want: 10 * time.Second},
+		{step: 100, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := backoff.Delay(tt.step); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.step, got, tt.want)
+		}
+	}
+
+	// 超过退避次数上限后不再增长
+	capped := Backoff{Base: time.Second, Multiplier: 2, Max: time.Hour, MaxSteps: 3}
+	if got := capped.Delay(10); got != 4*time.Second {
+		t.Errorf("Delay(10) = %v, want 4s", got)
+	}
+	if got := DefaultBackoff.Delay(1000); got != 30*time.Minute {
+		t.Errorf("default Delay(1000) = %v, want 30m", got)
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	schedule, err := ParseSchedule(&models.Cooldown{
+		CooldownBackoff: models.CooldownBackoff{Base: 2000, Max: 60000},
+		Provider:        &models.CooldownBackoff{Multiplier: 1.5, MaxSteps: 4},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantKey := Backoff{Base: 2 * time.Second, Multiplier: 2, Max: time.Minute, MaxSteps: 20}
+	wantProvider := Backoff{Base: 2 * time.Second, Multiplier: 1.5, Max: time.Minute, MaxSteps: 4}
+	if schedule.Key != wantKey || schedule.Provider != wantProvider {
+		t.Errorf("schedule = %+v, want key %+v provider %+v", schedule, wantKey, wantProvider)
+	}
+	if schedule, err := ParseSchedule(nil); err != nil || schedule != DefaultSchedule() {
+		t.Errorf("ParseSchedule(nil) = %+v, %v", schedule, err)
+	}
+
+	invalid := []models.Cooldown{
+		{CooldownBackoff: models.CooldownBackoff{Base: -1}},
+		{CooldownBackoff: models.CooldownBackoff{Multiplier: 0.5}},
+		{CooldownBackoff: models.CooldownBackoff{Base: 5000, Max: 1000}},
+		{Key: &models.CooldownBackoff{MaxSteps: -2}},
+		{Provider: &models.CooldownBackoff{Max: 10}},
+	}
+	for _, config := range invalid {
+		if _, err := ParseSchedule(&config); err == nil {
+			t.Errorf("expected error for %+v", config)
+		}
+	}
+}
+
+func TestManagerOnErrorSchedule(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.ModelWithProvider{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	mp := models.ModelWithProvider{ModelID: 1, ProviderID: 1, ProviderModel: "m"}
+	if err := db.Create(&mp).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	manager := NewManager(db).WithSchedule(Schedule{
+		Key:      Backoff{Base: time.Second, Multiplier: 2, Max: 3 * time.Second, MaxSteps: 10},
+		Provider: Backoff{Base: 10 * time.Second, Multiplier: 3, Max: time.Minute, MaxSteps: 10},
+	})
+	manager.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	wantProvider := []time.Duration{10 * time.Second, 30 * time.Second, time.Minute, time.Minute}
+	for i, want := range wantProvider {
+		if err := manager.OnError(ctx, &mp, CategoryProvider); err != nil {
+			t.Fatal(err)
+		}
+		if got := mp.ProviderCooldownUntil.Sub(now); got != want {
+			t.Errorf("provider error %d: cooldown %v, want %v", i+1, got, want)
+		}
+	}
+	wantKey := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}
+	for i, want := range wantKey {
+		if err := manager.OnError(ctx, &mp, CategoryKey); err != nil {
+			t.Fatal(err)
+		}
+		if got := mp.KeyCooldownUntil.Sub(now); got != want {
+			t.Errorf("key error %d: cooldown %v, want %v", i+1, got, want)
+		}
+	}
+
+	// 冷却状态写入数据库
+	var stored models.ModelWithProvider
+	if err := db.First(&stored, mp.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.ProviderCooldownStep != 4 || stored.KeyCooldownStep != 3 || !stored.ProviderCooldownUntil.Equal(now.Add(time.Minute)) {
+		t.Errorf("stored = steps %d/%d until %v", stored.ProviderCooldownStep, stored.KeyCooldownStep, stored.ProviderCooldownUntil)
+	}
+}
@@ -1,6 +1,10 @@
 package cooldown
 
-import "net/http"
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
 
 // Category 表示错误归类结果
 type Category int
@@ -27,3 +31,50 @@ func ClassifyStatus(code int) Category {
 		return CategoryNone
 	}
 }
+
+// StatusOverrides 自定义状态码归类 未配置的状态码使用默认规则
+type StatusOverrides map[int]Category
+
+// Classify 优先使用自定义映射归类状态码
+func (o StatusOverrides) Classify(code int) Category {
+	if category, ok := o[code]; ok {
+		return category
+	}
+	return ClassifyStatus(code)
+}
+
+// ParseStatusOverrides 解析 {"400": "provider"} 形式的配置
+func ParseStatusOverrides(raw map[string]string) (StatusOverrides, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	overrides := make(StatusOverrides, len(raw))
+	for code, name := range raw {
+		status, err := strconv.Atoi(code)
+		if err != nil || status < 100 || status > 599 {
+			return nil, fmt.Errorf("invalid status code: %s", code)
+		}
+		category, err := ParseCategory(name)
+		if err != nil {
+			return nil, err
+		}
+		overrides[status] = category
+	}
+	return overrides, nil
+}
+
+// ParseCategory 将配置中的名称转为错误归类
+func ParseCategory(name string) (Category, error) {
+	switch name {
+	case "none":
+		return CategoryNone, nil
+	case "key":
+		return CategoryKey, nil
+	case "provider":
+		return CategoryProvider, nil
+	case "client":
+		return CategoryClient, nil
+	default:
+		return CategoryNone, fmt.Errorf("invalid error category: %s", name)
+	}
+}
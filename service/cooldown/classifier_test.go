@@ -0,0 +1,42 @@
+package cooldown
+
+import "testing"
+
+func TestStatusOverridesClassify(t *testing.T) {
+	overrides, err := ParseStatusOverrides(map[string]string{"400": "provider", "429": "client"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		overrides StatusOverrides
+		code      int
+		want      Category
+	}{
+		{name: "override 400", overrides: overrides, code: 400, want: CategoryProvider},
+		{name: "override 429", overrides: overrides, code: 429, want: CategoryClient},
+		{name: "fallback default", overrides: overrides, code: 503, want: CategoryProvider},
+		{name: "fallback client", overrides: overrides, code: 404, want: CategoryClient},
+		{name: "nil overrides", overrides: nil, code: 400, want: CategoryClient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.overrides.Classify(tt.code); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseStatusOverridesInvalid(t *testing.T) {
+	for _, raw := range []map[string]string{
+		{"abc": "provider"},
+		{"999": "provider"},
+		{"400": "retry"},
+	} {
+		if _, err := ParseStatusOverrides(raw); err == nil {
+			t.Errorf("expected error for %v", raw)
+		}
+	}
+}
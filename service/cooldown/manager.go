@@ -10,19 +10,25 @@ import (
 
 // Manager 缁存姢閿骇涓庢笭閬撶骇鍐峰嵈绐楀彛
 type Manager struct {
-	db         *gorm.DB
-	now        func() time.Time
-	maxBackoff time.Duration
+	db       *gorm.DB
+	now      func() time.Time
+	schedule Schedule
 }
 
 func NewManager(db *gorm.DB) *Manager {
 	return &Manager{
-		db:         db,
-		now:        time.Now,
-		maxBackoff: 30 * time.Minute,
+		db:       db,
+		now:      time.Now,
+		schedule: DefaultSchedule(),
 	}
 }
 
+// WithSchedule 使用配置的退避参数
+func (m *Manager) WithSchedule(schedule Schedule) *Manager {
+	m.schedule = schedule
+	return m
+}
+
 // InCooldown 鍒ゆ柇鏄惁澶勪簬浠讳竴鍐峰嵈绐楀彛
 func (m *Manager) InCooldown(mp *models.ModelWithProvider) bool {
 	return m.CooldownLeft(mp) > 0
@@ -65,7 +71,7 @@ func (m *Manager) OnError(ctx context.Context, mp *models.ModelWithProvider, cat
 	switch category {
 	case CategoryKey:
 		mp.KeyCooldownStep++
-		until := m.nextTime(mp.KeyCooldownStep)
+		until := m.nextTime(m.schedule.Key, mp.KeyCooldownStep)
 		mp.KeyCooldownUntil = &until
 		_, err := gorm.G[models.ModelWithProvider](m.db).Where("id = ?", mp.ID).Updates(ctx, models.ModelWithProvider{
 			KeyCooldownStep:  mp.KeyCooldownStep,
@@ -74,7 +80,7 @@ func (m *Manager) OnError(ctx context.Context, mp *models.ModelWithProvider, cat
 		return err
 	case CategoryProvider:
 		mp.ProviderCooldownStep++
-		until := m.nextTime(mp.ProviderCooldownStep)
+		until := m.nextTime(m.schedule.Provider, mp.ProviderCooldownStep)
 		mp.ProviderCooldownUntil = &until
 		_, err := gorm.G[models.ModelWithProvider](m.db).Where("id = ?", mp.ID).Updates(ctx, models.ModelWithProvider{
 			ProviderCooldownStep:  mp.ProviderCooldownStep,
@@ -86,22 +92,6 @@ func (m *Manager) OnError(ctx context.Context, mp *models.ModelWithProvider, cat
 	}
 }
 
-func (m *Manager) nextTime(step int) time.Time {
-	return m.now().Add(m.backoff(step))
-}
-
-// backoff 閲囩敤鎸囨暟閫€閬匡紝1s 璧枫€佺炕鍊嶈嚦30min灏侀《
-func (m *Manager) backoff(step int) time.Duration {
-	if step <= 0 {
-		return time.Second
-	}
-	// 闄愬埗浣嶇Щ閬垮厤婧㈠嚭
-	if step > 20 {
-		step = 20
-	}
-	delay := time.Second << (step - 1)
-	if delay > m.maxBackoff {
-		return m.maxBackoff
-	}
-	return delay
+func (m *Manager) nextTime(backoff Backoff, step int) time.Time {
+	return m.now().Add(backoff.Delay(step))
 }
@@ -0,0 +1,13 @@
+package service
+
+import "io"
+
+// maxDiscardSize 丢弃响应体时最多读取的字节数 超出后直接关闭连接
+const maxDiscardSize = 256 << 10
+
+// discardBody 读完剩余内容后关闭响应体 使连接能够回到连接池复用
+// 未读到 EOF 就关闭时 http.Transport 会直接断开连接
+func discardBody(body io.ReadCloser) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(body, maxDiscardSize))
+	body.Close()
+}
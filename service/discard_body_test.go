@@ -0,0 +1,59 @@
+package service
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiscardBodyReusesConnection(t *testing.T) {
+	// 上游缓慢返回失败响应的错误体 超出 Transport 关闭时自行读取的时间
+	errBody := strings.Repeat("x", 4<<10)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(2*len(errBody)))
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errBody))
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(errBody))
+	}))
+	var conns atomic.Int32
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	tests := []struct {
+		name  string
+		close func(res *http.Response)
+		want  int32
+	}{
+		{"close only", func(res *http.Response) { res.Body.Close() }, 3},
+		{"discard", func(res *http.Response) { discardBody(res.Body) }, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conns.Store(0)
+			client := &http.Client{Transport: &http.Transport{}}
+			defer client.CloseIdleConnections()
+			for range 3 {
+				res, err := client.Get(server.URL)
+				if err != nil {
+					t.Fatalf("Get() error = %v", err)
+				}
+				tt.close(res)
+			}
+			if got := conns.Load(); got != tt.want {
+				t.Errorf("new connections = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
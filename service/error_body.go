@@ -0,0 +1,37 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/tidwall/gjson"
+)
+
+// ErrTruncatedBody 非流式响应体是不完整的 JSON 通常是上游连接在传输中途断开
+var ErrTruncatedBody = errors.New("truncated JSON response body")
+
+// checkErrorBody 检查状态码为 200 的非流式响应 部分兼容上游会在响应体顶层的 error 字段中返回错误
+// 存在非空的 error 时返回 StreamError 响应体为不完整的 JSON 时返回 ErrTruncatedBody 由调用方按失败处理
+// 否则将已读内容回放给后续处理
+func checkErrorBody(ctx context.Context, res *http.Response) error {
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	// 以 JSON 开头却无法完整解析的响应视为截断 非 JSON 响应仍原样返回
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && !gjson.ValidBytes(trimmed) {
+		return fmt.Errorf("%w: received %d bytes", ErrTruncatedBody, len(body))
+	}
+	// 部分正常响应带有 "error": null
+	switch gjson.GetBytes(body, "error").Type {
+	case gjson.Null, gjson.False:
+		return nil
+	}
+	return parseStreamError(ctx, string(body))
+}
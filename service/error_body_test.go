@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/service/cooldown"
+)
+
+func TestCheckErrorBody(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		wantCategory cooldown.Category // CategoryNone 表示不应返回错误
+	}{
+		{"success", `{"choices":[{"message":{"content":"hi"}}]}`, cooldown.CategoryNone},
+		{"null error", `{"id":"resp_1","error":null,"output":[]}`, cooldown.CategoryNone},
+		{"server error", `{"error":{"message":"overloaded","type":"server_error"}}`, cooldown.CategoryProvider},
+		{"quota error", `{"error":{"message":"quota","code":"insufficient_quota"}}`, cooldown.CategoryKey},
+		{"anthropic error", `{"type":"error","error":{"type":"invalid_request_error","message":"bad"}}`, cooldown.CategoryClient},
+		{"string error", `{"error":"upstream failed"}`, cooldown.CategoryProvider},
+		{"not json", `hello`, cooldown.CategoryNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(tt.body))}
+			err := checkErrorBody(context.Background(), res)
+			if tt.wantCategory == cooldown.CategoryNone {
+				if err != nil {
+					t.Fatalf("checkErrorBody() error = %v", err)
+				}
+			} else {
+				var streamErr StreamError
+				if !errors.As(err, &streamErr) {
+					t.Fatalf("checkErrorBody() error = %v, want StreamError", err)
+				}
+				if streamErr.Category != tt.wantCategory {
+					t.Errorf("category = %v, want %v", streamErr.Category, tt.wantCategory)
+				}
+			}
+			// 读取后的响应体仍可完整读取
+			body, _ := io.ReadAll(res.Body)
+			if string(body) != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestCheckErrorBodyTruncated(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{"complete object", `{"choices":[{"message":{"content":"hi"}}]}`, false},
+		{"complete with trailing newline", "{\"id\":\"1\"}\n", false},
+		{"truncated object", `{"choices":[{"message":{"content":"h`, true},
+		{"truncated array", `[{"id":1},`, true},
+		{"not json", `hello`, false},
+		{"empty", ``, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(tt.body))}
+			err := checkErrorBody(context.Background(), res)
+			if got := errors.Is(err, ErrTruncatedBody); got != tt.wantErr {
+				t.Errorf("checkErrorBody() error = %v, want truncated %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// HeaderExcludeProviders 客户端排除的提供商名称 逗号分隔 仅管理员与开启权限的 auth key 可用
+const HeaderExcludeProviders = "X-LLMIO-Exclude-Providers"
+
+// ErrProvidersExcluded 排除指定提供商后模型没有可用的提供商
+var ErrProvidersExcluded = errors.New("all providers excluded")
+
+type excludedProvidersKey struct{}
+
+// ParseExcludedProviders 解析请求头中的提供商名称 去除空白与重复项
+func ParseExcludedProviders(value string) []string {
+	names := lo.Map(strings.Split(value, ","), func(name string, _ int) string { return strings.TrimSpace(name) })
+	return lo.Uniq(lo.Compact(names))
+}
+
+// WithExcludedProviders 本次请求选择提供商时跳过指定名称的提供商
+func WithExcludedProviders(ctx context.Context, names []string) context.Context {
+	if len(names) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, excludedProvidersKey{}, names)
+}
+
+func excludedProviders(ctx context.Context) []string {
+	names, _ := ctx.Value(excludedProvidersKey{}).([]string)
+	return names
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service/cooldown"
+)
+
+// FailureReason 按错误与冷却归类确定日志的失败原因 超时与客户端中止优先于归类
+func FailureReason(err error, category cooldown.Category) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return models.FailureReasonClientAbort
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrStreamIdleTimeout),
+		errors.Is(err, ErrFirstChunkTimeout), errors.Is(err, ErrStreamStalled),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return models.FailureReasonTimeout
+	}
+	switch category {
+	case cooldown.CategoryKey:
+		return models.FailureReasonKeyError
+	case cooldown.CategoryClient:
+		return models.FailureReasonClientError
+	default:
+		return models.FailureReasonProviderError
+	}
+}
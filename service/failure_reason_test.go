@@ -0,0 +1,43 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service/cooldown"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestFailureReason(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		category cooldown.Category
+		want     string
+	}{
+		{"client canceled", fmt.Errorf("read body: %w", context.Canceled), cooldown.CategoryProvider, models.FailureReasonClientAbort},
+		{"deadline exceeded", context.DeadlineExceeded, cooldown.CategoryProvider, models.FailureReasonTimeout},
+		{"first chunk timeout", ErrFirstChunkTimeout, cooldown.CategoryProvider, models.FailureReasonTimeout},
+		{"stream idle timeout", ErrStreamIdleTimeout, cooldown.CategoryProvider, models.FailureReasonTimeout},
+		{"stream stalled", ErrStreamStalled, cooldown.CategoryProvider, models.FailureReasonTimeout},
+		{"network timeout", fmt.Errorf("dial: %w", timeoutError{}), cooldown.CategoryProvider, models.FailureReasonTimeout},
+		{"rate limited key", errors.New("status: 429"), cooldown.CategoryKey, models.FailureReasonKeyError},
+		{"bad request", errors.New("status: 400"), cooldown.CategoryClient, models.FailureReasonClientError},
+		{"upstream error", errors.New("status: 502"), cooldown.CategoryProvider, models.FailureReasonProviderError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FailureReason(tt.err, tt.category); got != tt.want {
+				t.Errorf("FailureReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
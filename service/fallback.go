@@ -0,0 +1,197 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// ErrProvidersExhausted 重试次数、超时或冷却使所有提供商都已尝试失败 客户端取消与内部错误不属于此类
+var ErrProvidersExhausted = errors.New("all providers failed")
+
+// fallbackStyles 可配置降级响应的接口风格
+var fallbackStyles = []string{consts.StyleOpenAI, consts.StyleOpenAICompletion, consts.StyleOpenAIRes, consts.StyleAnthropic}
+
+// ValidateFallback 校验降级响应 键为接口风格 值为该风格的非流式响应 JSON 对象
+func ValidateFallback(fallback models.ModelFallback) error {
+	for style, body := range fallback {
+		if !containsStyle(style) {
+			return fmt.Errorf("invalid fallback style: %s", style)
+		}
+		if !json.Valid(body) || !gjson.ParseBytes(body).IsObject() {
+			return fmt.Errorf("fallback for %s must be a JSON object", style)
+		}
+		if _, err := FallbackStream(style, body); err != nil {
+			return fmt.Errorf("invalid fallback for %s: %w", style, err)
+		}
+	}
+	return nil
+}
+
+func containsStyle(style string) bool {
+	for _, s := range fallbackStyles {
+		if s == style {
+			return true
+		}
+	}
+	return false
+}
+
+// FallbackStream 将非流式降级响应转为对应风格的最小 SSE 流
+func FallbackStream(style string, body []byte) ([]byte, error) {
+	switch style {
+	case consts.StyleOpenAI:
+		return SyntheticOpenAIStream(body)
+	case consts.StyleOpenAICompletion:
+		// completions 的流式块与非流式响应结构相同
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, body); err != nil {
+			return nil, err
+		}
+		var stream bytes.Buffer
+		writeSSEData(&stream, buf.Bytes())
+		writeSSEData(&stream, []byte("[DONE]"))
+		return stream.Bytes(), nil
+	case consts.StyleOpenAIRes:
+		return syntheticResponsesStream(body)
+	case consts.StyleAnthropic:
+		return syntheticAnthropicStream(body)
+	default:
+		return nil, fmt.Errorf("unsupported fallback style: %s", style)
+	}
+}
+
+// syntheticResponsesStream 以 response.created 与 response.completed 两个事件输出完整响应
+func syntheticResponsesStream(body []byte) ([]byte, error) {
+	var response bytes.Buffer
+	if err := json.Compact(&response, body); err != nil {
+		return nil, err
+	}
+	created, err := sjson.SetBytes(response.Bytes(), "status", "in_progress")
+	if err != nil {
+		return nil, err
+	}
+	if created, err = sjson.SetRawBytes(created, "output", []byte("[]")); err != nil {
+		return nil, err
+	}
+	var stream bytes.Buffer
+	for _, event := range []struct {
+		name     string
+		response []byte
+	}{{"response.created", created}, {"response.completed", response.Bytes()}} {
+		data, err := sjson.SetRawBytes([]byte(`{"type":""}`), "response", event.response)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = sjson.SetBytes(data, "type", event.name); err != nil {
+			return nil, err
+		}
+		stream.WriteString("event: " + event.name + "\n")
+		writeSSEData(&stream, data)
+	}
+	return stream.Bytes(), nil
+}
+
+// syntheticAnthropicStream 按 Messages 流式事件顺序输出文本内容块 非文本块原样作为块开始事件
+func syntheticAnthropicStream(body []byte) ([]byte, error) {
+	message := gjson.ParseBytes(body)
+	if !message.Get("content").IsArray() {
+		return nil, errors.New("anthropic fallback requires a content array")
+	}
+	var stream bytes.Buffer
+	event := func(name string, data []byte) {
+		stream.WriteString("event: " + name + "\n")
+		writeSSEData(&stream, data)
+	}
+
+	start, err := sjson.SetRawBytes([]byte(message.Raw), "content", []byte("[]"))
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range []string{"stop_reason", "stop_sequence"} {
+		if start, err = sjson.SetRawBytes(start, field, []byte("null")); err != nil {
+			return nil, err
+		}
+	}
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, start); err != nil {
+		return nil, err
+	}
+	data, err := sjson.SetRawBytes([]byte(`{"type":"message_start"}`), "message", compacted.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	event("message_start", data)
+
+	for i, block := range message.Get("content").Array() {
+		blockStart := []byte(block.Raw)
+		text := block.Get("text")
+		isText := block.Get("type").String() == "text"
+		if isText {
+			blockStart = []byte(`{"type":"text","text":""}`)
+		}
+		var compactedBlock bytes.Buffer
+		if err := json.Compact(&compactedBlock, blockStart); err != nil {
+			return nil, err
+		}
+		if data, err = sjson.SetRawBytes([]byte(`{"type":"content_block_start"}`), "content_block", compactedBlock.Bytes()); err != nil {
+			return nil, err
+		}
+		if data, err = sjson.SetBytes(data, "index", i); err != nil {
+			return nil, err
+		}
+		event("content_block_start", data)
+		if isText {
+			if data, err = sjson.SetBytes([]byte(`{"type":"content_block_delta","delta":{"type":"text_delta"}}`), "index", i); err != nil {
+				return nil, err
+			}
+			if data, err = sjson.SetBytes(data, "delta.text", text.String()); err != nil {
+				return nil, err
+			}
+			event("content_block_delta", data)
+		}
+		if data, err = sjson.SetBytes([]byte(`{"type":"content_block_stop"}`), "index", i); err != nil {
+			return nil, err
+		}
+		event("content_block_stop", data)
+	}
+
+	stopReason := message.Get("stop_reason").String()
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	if data, err = sjson.SetBytes([]byte(`{"type":"message_delta","delta":{"stop_sequence":null}}`), "delta.stop_reason", stopReason); err != nil {
+		return nil, err
+	}
+	if data, err = sjson.SetBytes(data, "usage.output_tokens", message.Get("usage.output_tokens").Int()); err != nil {
+		return nil, err
+	}
+	event("message_delta", data)
+	event("message_stop", []byte(`{"type":"message_stop"}`))
+	return stream.Bytes(), nil
+}
+
+// RecordFallback 记录返回降级响应的请求 状态为 error 并带有降级标记
+func RecordFallback(ctx context.Context, style string, before Before, reqMeta models.ReqMeta, cause error) (uint, error) {
+	authKeyID, _ := ctx.Value(consts.ContextKeyAuthKeyID).(uint)
+	return SaveChatLog(ctx, models.ChatLog{
+		Name:           before.Model,
+		Status:         "error",
+		Style:          style,
+		UserAgent:      reqMeta.UserAgent,
+		RemoteIP:       reqMeta.RemoteIP,
+		AuthKeyID:      authKeyID,
+		EndUser:        before.EndUser(),
+		RequestedModel: before.RequestedModel(),
+		Anomaly:        before.Anomaly(),
+		Error:          cause.Error(),
+		Fallback:       true,
+	})
+}
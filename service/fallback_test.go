@@ -0,0 +1,92 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+)
+
+func TestFallbackStream(t *testing.T) {
+	tests := []struct {
+		name  string
+		style string
+		body  string
+		want  []string // 按顺序出现的片段
+	}{
+		{
+			name:  "openai",
+			style: consts.StyleOpenAI,
+			body:  `{"id":"fb","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":""},"finish_reason":"stop"}]}`,
+			want:  []string{`data: {"object":"chat.completion.chunk","choices":[{"index":0,"delta":{"role":"assistant","content":""},"finish_reason":"stop"}],"id":"fb"}`, "data: [DONE]"},
+		},
+		{
+			name:  "completion",
+			style: consts.StyleOpenAICompletion,
+			body:  "{\n  \"object\": \"text_completion\",\n  \"choices\": [{\"index\": 0, \"text\": \"\"}]\n}",
+			want:  []string{`data: {"object":"text_completion","choices":[{"index":0,"text":""}]}`, "data: [DONE]"},
+		},
+		{
+			name:  "responses",
+			style: consts.StyleOpenAIRes,
+			body:  `{"id":"resp","object":"response","status":"completed","output":[{"type":"message","content":[{"type":"output_text","text":"n/a"}]}]}`,
+			want: []string{
+				"event: response.created\n" + `data: {"type":"response.created","response":{"id":"resp","object":"response","status":"in_progress","output":[]}}`,
+				"event: response.completed\n" + `data: {"type":"response.completed","response":{"id":"resp","object":"response","status":"completed","output":[{"type":"message","content":[{"type":"output_text","text":"n/a"}]}]}}`,
+			},
+		},
+		{
+			name:  "anthropic",
+			style: consts.StyleAnthropic,
+			body:  `{"id":"msg","type":"message","role":"assistant","content":[{"type":"text","text":"busy"}],"stop_reason":"end_turn","usage":{"input_tokens":0,"output_tokens":0}}`,
+			want: []string{
+				"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg","type":"message","role":"assistant","content":[],"stop_reason":null,"usage":{"input_tokens":0,"output_tokens":0},"stop_sequence":null}}`,
+				"event: content_block_start\n" + `data: {"type":"content_block_start","content_block":{"type":"text","text":""},"index":0}`,
+				"event: content_block_delta\n" + `data: {"type":"content_block_delta","delta":{"type":"text_delta","text":"busy"},"index":0}`,
+				"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":0}`,
+				"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_sequence":null,"stop_reason":"end_turn"},"usage":{"output_tokens":0}}`,
+				"event: message_stop\n" + `data: {"type":"message_stop"}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := FallbackStream(tt.style, []byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rest := string(stream)
+			for _, want := range tt.want {
+				i := strings.Index(rest, want+"\n\n")
+				if i < 0 {
+					t.Fatalf("missing %q in remaining stream %q", want, rest)
+				}
+				rest = rest[i+len(want):]
+			}
+		})
+	}
+}
+
+func TestValidateFallback(t *testing.T) {
+	valid := models.ModelFallback{
+		consts.StyleOpenAI:    json.RawMessage(`{"choices":[]}`),
+		consts.StyleAnthropic: json.RawMessage(`{"content":[]}`),
+	}
+	if err := ValidateFallback(valid); err != nil {
+		t.Fatalf("ValidateFallback() error = %v", err)
+	}
+	if err := ValidateFallback(nil); err != nil {
+		t.Fatalf("ValidateFallback(nil) error = %v", err)
+	}
+	for name, fallback := range map[string]models.ModelFallback{
+		"unknown style":       {"bedrock": json.RawMessage(`{}`)},
+		"not an object":       {consts.StyleOpenAI: json.RawMessage(`"busy"`)},
+		"anthropic no blocks": {consts.StyleAnthropic: json.RawMessage(`{"type":"message"}`)},
+	} {
+		if err := ValidateFallback(fallback); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+)
+
+// responseFormats 各接口风格期望的响应格式 legacy completions 与 chat 一致
+var responseFormats = map[string]string{
+	consts.StyleOpenAI:           consts.StyleOpenAI,
+	consts.StyleOpenAICompletion: consts.StyleOpenAI,
+	consts.StyleOpenAIRes:        consts.StyleOpenAIRes,
+	consts.StyleAnthropic:        consts.StyleAnthropic,
+}
+
+// formatProcessers 各响应格式对应的处理器
+var formatProcessers = map[string]Processer{
+	consts.StyleOpenAI:    ProcesserOpenAI,
+	consts.StyleOpenAIRes: ProcesserOpenAiRes,
+	consts.StyleAnthropic: ProcesserAnthropic,
+}
+
+// detectResponseFormat 按首个事件识别响应格式 无法识别时返回空
+func detectResponseFormat(event SSEEvent) string {
+	kind := cmp.Or(event.Event, gjson.Get(event.Data, "type").String())
+	switch {
+	case kind == "message_start" || kind == "message" || strings.HasPrefix(kind, "content_block_"):
+		return consts.StyleAnthropic
+	case strings.HasPrefix(kind, "response.") || gjson.Get(event.Data, "object").String() == "response":
+		return consts.StyleOpenAIRes
+	case gjson.Get(event.Data, "choices").Exists():
+		return consts.StyleOpenAI
+	}
+	return ""
+}
+
+// sniffResponseFormat 读取到首个带数据的事件为止并识别格式 返回的 reader 包含已读取的内容
+// 流式响应按行读取 不会为识别格式而等待后续事件
+func sniffResponseFormat(pr io.Reader, stream bool) (string, io.Reader, error) {
+	if !stream {
+		body, err := io.ReadAll(pr)
+		if err != nil {
+			return "", nil, err
+		}
+		return detectResponseFormat(SSEEvent{Data: string(bytes.TrimSpace(body))}), bytes.NewReader(body), nil
+	}
+	br := bufio.NewReader(pr)
+	var consumed bytes.Buffer
+	var event SSEEvent
+	for {
+		line, err := br.ReadBytes('\n')
+		consumed.Write(line)
+		field, value, _ := strings.Cut(strings.TrimRight(string(line), "\r\n"), ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event.Event = value
+		case "data":
+			event.Data = value
+		}
+		if event.Data != "" || err != nil {
+			break
+		}
+	}
+	return detectResponseFormat(event), io.MultiReader(&consumed, br), nil
+}
+
+// WithFormatDetection 从响应的首个事件识别实际格式 与接口风格不一致时记录在日志中
+// 常见于 anthropic 类型的提供商被关联到 openai 接口 此时用量无法正确提取 配置开启 switch 后改用对应的处理器
+func WithFormatDetection(style string, processer Processer) Processer {
+	expected, ok := responseFormats[style]
+	if !ok {
+		return processer
+	}
+	return func(ctx context.Context, pr io.Reader, stream bool, start time.Time) (*models.ChatLog, *models.OutputUnion, error) {
+		detected, reader, err := sniffResponseFormat(pr, stream)
+		if err != nil {
+			return nil, nil, err
+		}
+		mismatch := detected != "" && detected != expected
+		process := processer
+		if mismatch && formatSwitchEnabled(ctx) {
+			process = formatProcessers[detected]
+		}
+		log, output, err := process(ctx, reader, stream, start)
+		if err != nil {
+			return nil, nil, err
+		}
+		if mismatch {
+			log.FormatMismatch = detected
+		}
+		return log, output, nil
+	}
+}
+
+// formatSwitchEnabled 是否按识别出的格式切换处理器 读取失败时不切换
+func formatSwitchEnabled(ctx context.Context) bool {
+	config, err := LoadConfig[models.FormatDetection](ctx, models.KeyFormatDetection)
+	if err != nil {
+		slog.Error("load format detection config error", "error", err)
+		return false
+	}
+	return config != nil && config.Switch
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+)
+
+func TestWithFormatDetection(t *testing.T) {
+	anthropicStream := "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"model\":\"claude\",\"usage\":{\"input_tokens\":10,\"output_tokens\":1}}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"hi\"}}\n\n" +
+		"event: message_delta\ndata: {\"type\":\"message_delta\",\"usage\":{\"output_tokens\":5}}\n\n"
+	openaiStream := "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\n" +
+		"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":10,\"completion_tokens\":5,\"total_tokens\":15}}\n\n" +
+		"data: [DONE]\n\n"
+	openaiBody := `{"choices":[{"message":{"content":"hi"}}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`
+
+	tests := []struct {
+		name         string
+		style        string
+		processer    Processer
+		stream       bool
+		body         string
+		switchFormat bool
+		wantMismatch string
+		wantTokens   int64
+	}{
+		{"matching stream", consts.StyleOpenAI, ProcesserOpenAI, true, openaiStream, false, "", 15},
+		{"matching completion body", consts.StyleOpenAICompletion, ProcesserOpenAI, false, openaiBody, false, "", 15},
+		{"anthropic stream under openai", consts.StyleOpenAI, ProcesserOpenAI, true, anthropicStream, false, consts.StyleAnthropic, 0},
+		{"anthropic stream under openai switched", consts.StyleOpenAI, ProcesserOpenAI, true, anthropicStream, true, consts.StyleAnthropic, 15},
+		{"openai body under anthropic switched", consts.StyleAnthropic, ProcesserAnthropic, false, openaiBody, true, consts.StyleOpenAI, 15},
+		{"openai stream under responses switched", consts.StyleOpenAIRes, ProcesserOpenAiRes, true, openaiStream, true, consts.StyleOpenAI, 15},
+		{"unrecognized stream", consts.StyleOpenAI, ProcesserOpenAI, true, "data: {\"foo\":1}\n\n", true, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupChatDB(t)
+			ctx := context.Background()
+			if tt.switchFormat {
+				if err := SaveConfig(ctx, models.KeyFormatDetection, models.FormatDetection{Switch: true}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			log, _, err := WithFormatDetection(tt.style, tt.processer)(ctx, strings.NewReader(tt.body), tt.stream, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if log.FormatMismatch != tt.wantMismatch {
+				t.Errorf("FormatMismatch = %q, want %q", log.FormatMismatch, tt.wantMismatch)
+			}
+			if log.TotalTokens != tt.wantTokens {
+				t.Errorf("TotalTokens = %d, want %d", log.TotalTokens, tt.wantTokens)
+			}
+		})
+	}
+}
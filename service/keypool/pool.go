@@ -3,6 +3,10 @@ package keypool
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/atopos31/llmio/models"
@@ -10,47 +14,169 @@ import (
 	"gorm.io/gorm"
 )
 
+// ExpiringWithin 有效期剩余不足该时长的 Key 视为即将过期 仅在没有其他可用 Key 时使用
+const ExpiringWithin = 24 * time.Hour
+
+// expiryWarned 已告警即将过期的 Key 与告警时的截止时间 截止时间变化后重新告警
+var expiryWarned sync.Map
+
 type Pool struct {
-	db *gorm.DB
+	db        *gorm.DB
+	backoff   cooldown.Backoff
+	rateLimit func(keyID uint) float64 // 按上游限流额度估算的权重系数 为空时不调整
+	now       func() time.Time
 }
 
 func NewPool(db *gorm.DB) *Pool {
-	return &Pool{db: db}
+	return &Pool{db: db, backoff: cooldown.DefaultBackoff, now: time.Now}
+}
+
+// WithBackoff 使用配置的 key 级退避参数
+func (p *Pool) WithBackoff(backoff cooldown.Backoff) *Pool {
+	p.backoff = backoff
+	return p
+}
+
+// WithRateLimits 按上游返回的限流额度降低接近耗尽的 Key 的权重 factor 返回 (0,1] 的系数
+func (p *Pool) WithRateLimits(factor func(keyID uint) float64) *Pool {
+	p.rateLimit = factor
+	return p
 }
 
 // Pick 选择可用的 Key
+// 默认选择最久未用的 Key；任一 Key 配置了权重、预算或限流额度接近耗尽时按有效权重随机选择，预算耗尽与有效期外的 Key 与冷却中的 Key 一样跳过
+// 存在有效期充足的 Key 时不使用即将过期的 Key
 func (p *Pool) Pick(ctx context.Context, providerID uint) (key string, keyID uint, err error) {
-	now := time.Now()
-	var keys []models.ProviderKey
+	now := p.now()
 
-	// 查询启用且未冷却的 Key
-	keys, err = gorm.G[models.ProviderKey](p.db).
+	// 查询启用、未冷却且处于有效期内的 Key
+	keys, err := gorm.G[models.ProviderKey](p.db).
 		Where("provider_id = ? AND status = ? AND (cooldown_until IS NULL OR cooldown_until < ?)",
 			providerID, true, now).
+		Where("(valid_from IS NULL OR valid_from <= ?) AND (valid_until IS NULL OR valid_until > ?)", now, now).
 		Order("last_used_at IS NOT NULL, last_used_at ASC"). // 优先使用最久未用的
-		Limit(1).
 		Find(ctx)
 	if err != nil {
 		return "", 0, err
 	}
 
-	if len(keys) == 0 {
-		return "", 0, fmt.Errorf("no available key for provider %d", providerID)
+	candidates := make([]models.ProviderKey, 0, len(keys))
+	var expiring []models.ProviderKey
+	for _, k := range keys {
+		if k.Budget > 0 && remainingBudget(k, now) < 1 {
+			continue
+		}
+		if expiringSoon(k, now) {
+			warnExpiring(k, now)
+			expiring = append(expiring, k)
+			continue
+		}
+		candidates = append(candidates, k)
+	}
+	for _, group := range [][]models.ProviderKey{candidates, expiring} {
+		for len(group) > 0 {
+			selected := p.choose(group, now)
+			claimed, err := p.claim(ctx, selected, now)
+			if err != nil {
+				return "", 0, err
+			}
+			if claimed {
+				return selected.Key, selected.ID, nil
+			}
+			// 预算已被并发请求用尽 换用其他 Key
+			group = slices.DeleteFunc(group, func(k models.ProviderKey) bool { return k.ID == selected.ID })
+		}
+	}
+	return "", 0, fmt.Errorf("no available key for provider %d", providerID)
+}
+
+// choose 从候选中选择 Key 任一 Key 配置了权重、预算或限流额度接近耗尽时按有效权重随机选择 否则选择最久未用的
+func (p *Pool) choose(candidates []models.ProviderKey, now time.Time) models.ProviderKey {
+	for _, k := range candidates {
+		if k.Budget > 0 || k.Weight > 1 || p.rateLimitFactor(k.ID) < 1 {
+			return pickWeighted(candidates, func(k models.ProviderKey) float64 {
+				return effectiveWeight(k, now) * p.rateLimitFactor(k.ID)
+			}, rand.Float64())
+		}
+	}
+	return candidates[0]
+}
+
+// refilledBudget 按补充速率计算当前剩余预算的 SQL 表达式 与 remainingBudget 一致 参数为当前时间
+// 经过的时间先取整到毫秒 避免儒略日相减的浮点误差使恰好补满的预算略小于 1
+const refilledBudget = "MIN(budget, CASE WHEN remaining_at IS NULL THEN budget " +
+	"ELSE remaining + ROUND((julianday(?) - julianday(remaining_at)) * 86400000) / 60000.0 * budget_refill END)"
+
+// claim 更新最后使用时间 配置了预算的 Key 在同一条语句中补充并扣减预算
+// 剩余预算不足 1 时不更新并返回 false 避免并发请求重复使用同一份预算
+func (p *Pool) claim(ctx context.Context, k models.ProviderKey, now time.Time) (bool, error) {
+	db := p.db.WithContext(ctx).Model(&models.ProviderKey{}).Where("id = ?", k.ID)
+	if k.Budget <= 0 {
+		return true, db.Update("last_used_at", now).Error
+	}
+	res := db.Where(refilledBudget+" >= 1", now).Updates(map[string]any{
+		"last_used_at": now,
+		"remaining":    gorm.Expr(refilledBudget+" - 1", now),
+		"remaining_at": now,
+	})
+	return res.RowsAffected > 0, res.Error
+}
+
+// expiringSoon Key 的有效期是否即将结束
+func expiringSoon(k models.ProviderKey, now time.Time) bool {
+	return k.ValidUntil != nil && k.ValidUntil.Sub(now) < ExpiringWithin
+}
+
+// warnExpiring 即将过期的 Key 在每个截止时间只告警一次
+func warnExpiring(k models.ProviderKey, now time.Time) {
+	if until, loaded := expiryWarned.Swap(k.ID, *k.ValidUntil); loaded && until.(time.Time).Equal(*k.ValidUntil) {
+		return
 	}
+	slog.Warn("provider key expiring soon", "provider_id", k.ProviderID, "key_id", k.ID, "valid_until", *k.ValidUntil, "remaining", k.ValidUntil.Sub(now).Round(time.Minute))
+}
 
-	// 选择第一个可用 Key
-	selected := keys[0]
+// remainingBudget 按补充速率估算当前剩余预算
+func remainingBudget(k models.ProviderKey, now time.Time) float64 {
+	if k.RemainingAt == nil {
+		return float64(k.Budget)
+	}
+	remaining := k.Remaining + now.Sub(*k.RemainingAt).Minutes()*float64(k.BudgetRefill)
+	return min(remaining, float64(k.Budget))
+}
 
-	// 更新最后使用时间
-	nowTime := time.Now()
-	selected.LastUsedAt = &nowTime
-	if _, err := gorm.G[models.ProviderKey](p.db).
-		Where("id = ?", selected.ID).
-		Update(ctx, "last_used_at", nowTime); err != nil {
-		// 非致命错误，继续
+// rateLimitFactor Key 的限流额度系数 未配置时为 1
+func (p *Pool) rateLimitFactor(keyID uint) float64 {
+	if p.rateLimit == nil {
+		return 1
 	}
+	return p.rateLimit(keyID)
+}
 
-	return selected.Key, selected.ID, nil
+// effectiveWeight 有效权重 = 配置权重 × 剩余预算比例
+func effectiveWeight(k models.ProviderKey, now time.Time) float64 {
+	weight := float64(max(k.Weight, 1))
+	if k.Budget > 0 {
+		weight *= remainingBudget(k, now) / float64(k.Budget)
+	}
+	return weight
+}
+
+// pickWeighted 按权重比例选择 r 为 [0,1) 的随机数
+func pickWeighted(candidates []models.ProviderKey, weight func(models.ProviderKey) float64, r float64) models.ProviderKey {
+	weights := make([]float64, len(candidates))
+	var total float64
+	for i, k := range candidates {
+		weights[i] = weight(k)
+		total += weights[i]
+	}
+	target := r * total
+	for i, w := range weights {
+		if target < w {
+			return candidates[i]
+		}
+		target -= w
+	}
+	return candidates[len(candidates)-1]
 }
 
 // OnSuccess Key 使用成功
@@ -112,16 +238,5 @@ func (p *Pool) OnError(ctx context.Context, keyID uint, category cooldown.Catego
 }
 
 func (p *Pool) nextCooldownTime(step int) time.Time {
-	if step <= 0 {
-		return time.Now().Add(time.Second)
-	}
-	if step > 20 {
-		step = 20
-	}
-	delay := time.Second << (step - 1)
-	maxBackoff := 30 * time.Minute
-	if delay > maxBackoff {
-		delay = maxBackoff
-	}
-	return time.Now().Add(delay)
+	return time.Now().Add(p.backoff.Delay(step))
 }
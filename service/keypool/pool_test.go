@@ -0,0 +1,279 @@
+package keypool
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/glebarez/sqlite"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+func setupPoolDB(t *testing.T, keys ...models.ProviderKey) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.ProviderKey{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	for i := range keys {
+		if err := db.Create(&keys[i]).Error; err != nil {
+			t.Fatalf("failed to create key: %v", err)
+		}
+	}
+	return db
+}
+
+func TestPickWeighted(t *testing.T) {
+	now := time.Now()
+	candidates := []models.ProviderKey{
+		{Key: "a", Weight: 1},
+		{Key: "b", Weight: 3},
+		{Key: "c", Weight: 2, Budget: 10, Remaining: 5, RemainingAt: &now}, // 有效权重 1
+	}
+
+	tests := []struct {
+		r    float64
+		want string
+	}{
+		{0, "a"},
+		{0.19, "a"},
+		{0.2, "b"},
+		{0.79, "b"},
+		{0.8, "c"},
+		{0.99, "c"},
+	}
+	for _, tt := range tests {
+		weight := func(k models.ProviderKey) float64 { return effectiveWeight(k, now) }
+		if got := pickWeighted(candidates, weight, tt.r); got.Key != tt.want {
+			t.Errorf("pickWeighted(r=%v) = %q, want %q", tt.r, got.Key, tt.want)
+		}
+	}
+}
+
+func TestRemainingBudget(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		key  models.ProviderKey
+		want float64
+	}{
+		{"unused", models.ProviderKey{Budget: 10}, 10},
+		{"consumed", models.ProviderKey{Budget: 10, Remaining: 3, RemainingAt: &now}, 3},
+		{"refilled", models.ProviderKey{Budget: 10, BudgetRefill: 2, Remaining: 3, RemainingAt: lo.ToPtr(now.Add(-2 * time.Minute))}, 7},
+		{"capped", models.ProviderKey{Budget: 10, BudgetRefill: 5, Remaining: 3, RemainingAt: lo.ToPtr(now.Add(-time.Hour))}, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := remainingBudget(tt.key, now); got != tt.want {
+				t.Errorf("remainingBudget() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPickWeightedDistribution(t *testing.T) {
+	ctx := context.Background()
+	db := setupPoolDB(t,
+		models.ProviderKey{ProviderID: 1, Key: "light", Status: true, Weight: 1},
+		models.ProviderKey{ProviderID: 1, Key: "heavy", Status: true, Weight: 3},
+	)
+	pool := NewPool(db)
+
+	counts := map[string]int{}
+	const picks = 2000
+	for range picks {
+		key, _, err := pool.Pick(ctx, 1)
+		if err != nil {
+			t.Fatalf("Pick() error = %v", err)
+		}
+		counts[key]++
+	}
+
+	ratio := float64(counts["heavy"]) / picks
+	if ratio < 0.68 || ratio > 0.82 {
+		t.Errorf("heavy key picked %.2f of the time, want about 0.75 (counts %v)", ratio, counts)
+	}
+}
+
+func TestPickSkipsExhaustedBudget(t *testing.T) {
+	ctx := context.Background()
+	db := setupPoolDB(t,
+		models.ProviderKey{ProviderID: 1, Key: "limited", Status: true, Weight: 1, Budget: 2},
+	)
+	pool := NewPool(db)
+
+	for i := range 2 {
+		if _, _, err := pool.Pick(ctx, 1); err != nil {
+			t.Fatalf("Pick() #%d error = %v", i+1, err)
+		}
+	}
+	if _, _, err := pool.Pick(ctx, 1); err == nil {
+		t.Fatal("Pick() with exhausted budget should fail")
+	}
+
+	// 补充预算后恢复可用
+	if err := db.Model(&models.ProviderKey{}).Where("key = ?", "limited").Updates(map[string]any{
+		"budget_refill": 1,
+		"remaining_at":  time.Now().Add(-time.Minute),
+	}).Error; err != nil {
+		t.Fatalf("failed to update key: %v", err)
+	}
+	if key, _, err := pool.Pick(ctx, 1); err != nil || key != "limited" {
+		t.Fatalf("Pick() after refill = %q, %v", key, err)
+	}
+}
+
+func TestPickConcurrentBudget(t *testing.T) {
+	ctx := context.Background()
+	db := setupPoolDB(t,
+		models.ProviderKey{ProviderID: 1, Key: "limited", Status: true, Weight: 1, Budget: 5},
+	)
+	// 内存数据库只能使用单个连接 并发请求在语句之间仍会交错
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	// 所有请求都读到 Key 后才继续更新 模拟并发请求读到同一份预算
+	const concurrency = 20
+	var read sync.WaitGroup
+	read.Add(concurrency)
+	if err := db.Callback().Query().After("gorm:query").Register("test:barrier", func(*gorm.DB) {
+		read.Done()
+		read.Wait()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	pool := NewPool(db)
+
+	var picked atomic.Int32
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := pool.Pick(ctx, 1); err == nil {
+				picked.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := picked.Load(); got != 5 {
+		t.Fatalf("expected 5 picks within budget, got %d", got)
+	}
+	var remaining float64
+	if err := sqlDB.QueryRow("SELECT remaining FROM provider_keys WHERE key = ?", "limited").Scan(&remaining); err != nil || remaining != 0 {
+		t.Fatalf("expected budget to be used up: %v, %v", remaining, err)
+	}
+}
+
+func TestPickLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	db := setupPoolDB(t,
+		models.ProviderKey{ProviderID: 1, Key: "recent", Status: true, Weight: 1, LastUsedAt: lo.ToPtr(now.Add(-time.Minute))},
+		models.ProviderKey{ProviderID: 1, Key: "stale", Status: true, Weight: 1, LastUsedAt: lo.ToPtr(now.Add(-time.Hour))},
+	)
+	pool := NewPool(db)
+
+	want := []string{"stale", "recent", "stale"}
+	for i, w := range want {
+		key, _, err := pool.Pick(ctx, 1)
+		if err != nil {
+			t.Fatalf("Pick() #%d error = %v", i+1, err)
+		}
+		if key != w {
+			t.Errorf("Pick() #%d = %q, want %q", i+1, key, w)
+		}
+	}
+}
+
+func TestPickRateLimitedKey(t *testing.T) {
+	ctx := context.Background()
+	db := setupPoolDB(t,
+		models.ProviderKey{ProviderID: 1, Key: "limited", Status: true, Weight: 1},
+		models.ProviderKey{ProviderID: 1, Key: "fresh", Status: true, Weight: 1},
+	)
+	var limitedID uint
+	if err := db.Model(&models.ProviderKey{}).Where("key = ?", "limited").Pluck("id", &limitedID).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 上游限流额度接近耗尽的 Key 权重降为原来的 5%
+	pool := NewPool(db).WithRateLimits(func(keyID uint) float64 {
+		if keyID == limitedID {
+			return 0.05
+		}
+		return 1
+	})
+
+	counts := map[string]int{}
+	const picks = 1000
+	for range picks {
+		key, _, err := pool.Pick(ctx, 1)
+		if err != nil {
+			t.Fatalf("Pick() error = %v", err)
+		}
+		counts[key]++
+	}
+	if ratio := float64(counts["limited"]) / picks; ratio > 0.1 {
+		t.Errorf("limited key picked %.2f of the time, want about 0.05 (counts %v)", ratio, counts)
+	}
+}
+
+func TestPickValidityWindow(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	keys := func() []models.ProviderKey {
+		return []models.ProviderKey{
+			// old 即将轮换下线 next 为轮换后的新 Key later 尚未生效
+			{ProviderID: 1, Key: "old", Status: true, Weight: 1, ValidUntil: lo.ToPtr(base.Add(time.Hour))},
+			{ProviderID: 1, Key: "next", Status: true, Weight: 1, ValidFrom: lo.ToPtr(base.Add(-time.Hour)), ValidUntil: lo.ToPtr(base.Add(30 * 24 * time.Hour))},
+			{ProviderID: 1, Key: "later", Status: true, Weight: 1, ValidFrom: lo.ToPtr(base.Add(48 * time.Hour))},
+			{ProviderID: 2, Key: "pending", Status: true, Weight: 1, ValidFrom: lo.ToPtr(base.Add(24 * time.Hour))},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		providerID uint
+		at         time.Time
+		want       []string // 多次选择中出现的 Key 为空表示没有可用 Key
+	}{
+		{"expiring key used when it is the only one", 1, base.Add(-2 * time.Hour), []string{"old"}},
+		{"fresh key preferred during overlap", 1, base, []string{"next"}},
+		{"expired key skipped", 1, base.Add(2 * time.Hour), []string{"next"}},
+		{"future key included once valid", 1, base.Add(72 * time.Hour), []string{"later", "next"}},
+		{"all bounded keys expired", 1, base.Add(31 * 24 * time.Hour), []string{"later"}},
+		{"key not yet valid", 2, base, nil},
+		{"key becomes valid", 2, base.Add(25 * time.Hour), []string{"pending"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := NewPool(setupPoolDB(t, keys()...))
+			pool.now = func() time.Time { return tt.at }
+
+			seen := map[string]bool{}
+			for range 4 {
+				key, _, err := pool.Pick(ctx, tt.providerID)
+				if err != nil {
+					if tt.want != nil {
+						t.Fatalf("Pick() error = %v", err)
+					}
+					return
+				}
+				seen[key] = true
+			}
+			if got := lo.Keys(seen); !lo.ElementsMatch(got, tt.want) {
+				t.Errorf("picked %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+const defaultLogFlushInterval = time.Second
+
+// logWrite 单个请求结束后需要持久化的日志内容
+type logWrite struct {
+	logID   uint
+	updates map[string]any // chat_logs 需要更新的列 使用 map 以确保零值也能被更新
+	io      *models.ChatIO // 开启 IOLog 时的输入输出 为空不写入
+}
+
+// logWriter 后台汇总日志写入 按条数或间隔在同一事务中批量提交
+// 更新列相同的日志合并为一条 UPDATE 语句 输入输出批量插入
+// 日志的创建仍逐条同步执行 请求需要立即拿到日志 id
+type logWriter struct {
+	mu       sync.RWMutex
+	closed   bool
+	writes   chan logWrite
+	done     chan struct{}
+	size     int
+	interval time.Duration
+}
+
+var (
+	currentLogWriter atomic.Pointer[logWriter]
+	// recordLogs 进行中的日志记录 退出前等待其完成
+	recordLogs sync.WaitGroup
+)
+
+// StartLogWriter 按配置启动日志批量写入 未启用时日志逐条写入
+// 返回的 drain 等待进行中的日志记录完成并写入剩余批次 用于退出前调用
+func StartLogWriter(config *models.LogWriter) (drain func(ctx context.Context)) {
+	var w *logWriter
+	if config != nil && config.BatchSize > 1 {
+		w = newLogWriter(config.BatchSize, time.Duration(config.FlushInterval)*time.Millisecond)
+		currentLogWriter.Store(w)
+		go w.run()
+	}
+	return func(ctx context.Context) {
+		waited := make(chan struct{})
+		go func() {
+			recordLogs.Wait()
+			close(waited)
+		}()
+		select {
+		case <-waited:
+		case <-ctx.Done():
+			slog.Warn("wait for record log timeout", "error", ctx.Err())
+		}
+		if w != nil {
+			w.stop()
+			currentLogWriter.CompareAndSwap(w, nil)
+		}
+	}
+}
+
+func newLogWriter(size int, interval time.Duration) *logWriter {
+	if interval <= 0 {
+		interval = defaultLogFlushInterval
+	}
+	return &logWriter{
+		writes:   make(chan logWrite, size),
+		done:     make(chan struct{}),
+		size:     size,
+		interval: interval,
+	}
+}
+
+func (w *logWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	batch := make([]logWrite, 0, w.size)
+	flush := func() {
+		if len(batch) > 0 {
+			flushLogWrites(batch)
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case write, ok := <-w.writes:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, write)
+			if len(batch) >= w.size {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// enqueue 加入待写队列 队列满时阻塞 已停止时返回 false
+func (w *logWriter) enqueue(write logWrite) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return false
+	}
+	w.writes <- write
+	return true
+}
+
+// stop 停止接收并写入剩余批次
+func (w *logWriter) stop() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.writes)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
+
+// writeLog 持久化日志 批量写入未启用或已停止时直接写入
+func writeLog(write logWrite) {
+	if w := currentLogWriter.Load(); w != nil && w.enqueue(write) {
+		return
+	}
+	flushLogWrites([]logWrite{write})
+}
+
+// flushLogWrites 在同一事务中按入队顺序写入 失败时逐条重试 避免单条错误丢弃整批
+func flushLogWrites(writes []logWrite) {
+	// 使用独立 context，避免请求结束后 context 被取消导致数据库更新失败
+	ctx := context.Background()
+	err := applyLogWrites(ctx, writes)
+	if err == nil || len(writes) == 1 {
+		if err != nil {
+			slog.Error("record log error", "log_id", writes[0].logID, "error", err)
+		}
+		return
+	}
+	slog.Warn("batch record log failed, retry one by one", "count", len(writes), "error", err)
+	for _, write := range writes {
+		if err := applyLogWrites(ctx, []logWrite{write}); err != nil {
+			slog.Error("record log error", "log_id", write.logID, "error", err)
+		}
+	}
+}
+
+func applyLogWrites(ctx context.Context, writes []logWrite) error {
+	return models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		ios := make([]models.ChatIO, 0, len(writes))
+		for _, write := range writes {
+			if write.io != nil {
+				ios = append(ios, *write.io)
+			}
+		}
+		for _, group := range groupLogUpdates(writes) {
+			if err := tx.Model(&models.ChatLog{}).Where("id IN ?", group.ids).Updates(group.updates()).Error; err != nil {
+				return err
+			}
+		}
+		if len(ios) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(ios, len(ios)).Error
+	})
+}
+
+// logUpdateGroup 更新列相同的一组日志 合并为一条 UPDATE 语句
+type logUpdateGroup struct {
+	columns []string
+	ids     []uint
+	values  []map[string]any // 与 ids 一一对应
+}
+
+// updates 每列按 id 取各自的值 CASE id WHEN ? THEN ? ... END
+func (g logUpdateGroup) updates() map[string]any {
+	updates := make(map[string]any, len(g.columns))
+	for _, column := range g.columns {
+		var sql strings.Builder
+		args := make([]any, 0, len(g.ids)*2)
+		sql.WriteString("CASE id")
+		for i, id := range g.ids {
+			sql.WriteString(" WHEN ? THEN ?")
+			args = append(args, id, g.values[i][column])
+		}
+		sql.WriteString(" END")
+		updates[column] = gorm.Expr(sql.String(), args...)
+	}
+	return updates
+}
+
+// groupLogUpdates 同一日志的多次更新按入队顺序合并 后写入的值覆盖先写入的 再按更新列分组
+func groupLogUpdates(writes []logWrite) []logUpdateGroup {
+	merged := make(map[uint]map[string]any, len(writes))
+	var order []uint
+	for _, write := range writes {
+		if len(write.updates) == 0 {
+			continue
+		}
+		updates, ok := merged[write.logID]
+		if !ok {
+			updates = make(map[string]any, len(write.updates))
+			merged[write.logID] = updates
+			order = append(order, write.logID)
+		}
+		maps.Copy(updates, write.updates)
+	}
+	var groups []logUpdateGroup
+	index := make(map[string]int)
+	for _, id := range order {
+		updates := merged[id]
+		columns := slices.Sorted(maps.Keys(updates))
+		key := strings.Join(columns, ",")
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, logUpdateGroup{columns: columns})
+		}
+		groups[i].ids = append(groups[i].ids, id)
+		groups[i].values = append(groups[i].values, updates)
+	}
+	return groups
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+func TestLogWriterFlush(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		interval time.Duration
+		writes   int
+		drain    bool // 不等待自动写入 直接 drain
+	}{
+		{"by size", 3, time.Hour, 3, false},
+		{"by interval", 100, 10 * time.Millisecond, 2, false},
+		{"on drain", 100, time.Hour, 5, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupChatDB(t)
+			if err := db.AutoMigrate(&models.ChatIO{}); err != nil {
+				t.Fatal(err)
+			}
+			drain := StartLogWriter(&models.LogWriter{BatchSize: tt.size, FlushInterval: int(tt.interval / time.Millisecond)})
+			t.Cleanup(func() { drain(context.Background()) })
+
+			ids := make([]uint, tt.writes)
+			for i := range ids {
+				log := models.ChatLog{Name: "gpt-4o", Status: "success"}
+				if err := db.Create(&log).Error; err != nil {
+					t.Fatal(err)
+				}
+				ids[i] = log.ID
+				writeLog(logWrite{
+					logID:   log.ID,
+					updates: map[string]any{"total_tokens": i + 1},
+					io:      &models.ChatIO{LogId: log.ID, Input: "in", OutputUnion: models.OutputUnion{OfString: "out"}},
+				})
+			}
+			if tt.drain {
+				drain(context.Background())
+			}
+
+			flushed := func() bool {
+				var count int64
+				db.Model(&models.ChatLog{}).Where("id IN ? AND total_tokens > 0", ids).Count(&count)
+				return count == int64(tt.writes)
+			}
+			for deadline := time.Now().Add(2 * time.Second); !flushed(); time.Sleep(5 * time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatal("log writes not flushed")
+				}
+			}
+			for i, id := range ids {
+				var log models.ChatLog
+				if err := db.First(&log, id).Error; err != nil {
+					t.Fatal(err)
+				}
+				if log.TotalTokens != int64(i+1) {
+					t.Errorf("log %d total tokens = %d, want %d", id, log.TotalTokens, i+1)
+				}
+				var io models.ChatIO
+				if err := db.Where("log_id = ?", id).First(&io).Error; err != nil {
+					t.Fatalf("chat io of log %d: %v", id, err)
+				}
+				if io.Input != "in" || io.OfString != "out" {
+					t.Errorf("chat io = %q/%q", io.Input, io.OfString)
+				}
+			}
+		})
+	}
+}
+
+func TestLogWriterBatchFailure(t *testing.T) {
+	db := setupChatDB(t)
+	var ids []uint
+	for range 3 {
+		log := models.ChatLog{Name: "gpt-4o", Status: "success"}
+		if err := db.Create(&log).Error; err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, log.ID)
+	}
+	// 单条写入失败不影响同批次其他日志
+	flushLogWrites([]logWrite{
+		{logID: ids[0], updates: map[string]any{"total_tokens": 1}},
+		{logID: ids[1], updates: map[string]any{"no_such_column": 1}},
+		{logID: ids[2], updates: map[string]any{"total_tokens": 3}},
+	})
+	var tokens []int64
+	if err := db.Model(&models.ChatLog{}).Order("id").Pluck("total_tokens", &tokens).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 3 || tokens[0] != 1 || tokens[1] != 0 || tokens[2] != 3 {
+		t.Errorf("total tokens = %v, want [1 0 3]", tokens)
+	}
+}
+
+func TestLogWriterBatchStatements(t *testing.T) {
+	db := setupChatDB(t)
+	var ids []uint
+	for range 4 {
+		log := models.ChatLog{Name: "gpt-4o", Status: "success"}
+		if err := db.Create(&log).Error; err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, log.ID)
+	}
+	var statements int
+	if err := db.Callback().Update().Before("gorm:update").Register("test:count", func(*gorm.DB) { statements++ }); err != nil {
+		t.Fatal(err)
+	}
+
+	flushLogWrites([]logWrite{
+		{logID: ids[0], updates: map[string]any{"total_tokens": 1, "size": 10}},
+		{logID: ids[1], updates: map[string]any{"total_tokens": 2, "size": 20}},
+		{logID: ids[2], updates: map[string]any{"status": "error", "error": "boom"}},
+		{logID: ids[3], updates: map[string]any{"total_tokens": 4, "size": 40}},
+		// 同一日志的后续更新覆盖之前的值
+		{logID: ids[1], updates: map[string]any{"size": 25}},
+	})
+	// 更新列相同的日志合并为同一条语句 用量与错误各一条
+	if statements != 2 {
+		t.Errorf("update statements = %d, want 2", statements)
+	}
+	var logs []models.ChatLog
+	if err := db.Order("id").Find(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		tokens int64
+		size   int
+		status string
+	}{{1, 10, "success"}, {2, 25, "success"}, {0, 0, "error"}, {4, 40, "success"}}
+	for i, log := range logs {
+		if log.TotalTokens != want[i].tokens || log.Size != want[i].size || log.Status != want[i].status {
+			t.Errorf("log %d = %d/%d/%s, want %+v", log.ID, log.TotalTokens, log.Size, log.Status, want[i])
+		}
+	}
+}
+
+func TestLogWriterAfterDrain(t *testing.T) {
+	db := setupChatDB(t)
+	drain := StartLogWriter(&models.LogWriter{BatchSize: 10, FlushInterval: int(time.Hour / time.Millisecond)})
+	drain(context.Background())
+
+	// 停止后的写入直接落库 不会丢失
+	log := models.ChatLog{Name: "gpt-4o", Status: "success"}
+	if err := db.Create(&log).Error; err != nil {
+		t.Fatal(err)
+	}
+	writeLog(logWrite{logID: log.ID, updates: map[string]any{"status": "error", "error": "boom"}})
+	if err := db.First(&log, log.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if log.Status != "error" || log.Error != "boom" {
+		t.Errorf("log = %s %q, want error boom", log.Status, log.Error)
+	}
+}
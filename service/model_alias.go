@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/atopos31/llmio/consts"
+)
+
+// ApplyModelAlias 按 auth key 配置的模型别名改写请求的模型 返回是否发生改写
+// 改写前的名称保留在 RequestedModel 中用于日志
+func ApplyModelAlias(ctx context.Context, before *Before) bool {
+	aliases, _ := ctx.Value(consts.ContextKeyModelAliases).(map[string]string)
+	target, ok := aliases[before.Model]
+	if !ok || target == "" || target == before.Model {
+		return false
+	}
+	slog.Info("model alias", "requested", before.Model, "model", target)
+	before.requestedModel = before.Model
+	before.Model = target
+	return true
+}
+
+// RequestedModel 返回按模型别名改写前客户端请求的模型 未改写时为空
+func (b Before) RequestedModel() string {
+	return b.requestedModel
+}
+
+// SanitizeModelAliases 去除别名与目标模型两端的空白 别名与目标均不能为空
+func SanitizeModelAliases(aliases map[string]string) (map[string]string, error) {
+	if aliases == nil {
+		return nil, nil
+	}
+	result := make(map[string]string, len(aliases))
+	for alias, target := range aliases {
+		alias, target = strings.TrimSpace(alias), strings.TrimSpace(target)
+		if alias == "" || target == "" {
+			return nil, errors.New("model alias and target must not be empty")
+		}
+		if alias == target {
+			return nil, errors.New("model alias " + alias + " points to itself")
+		}
+		result[alias] = target
+	}
+	return result, nil
+}
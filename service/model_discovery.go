@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/providers"
+)
+
+// defaultModelDiscoveryTTL 未配置时模型列表的缓存有效期
+const defaultModelDiscoveryTTL = 10 * time.Minute
+
+// providerModels 全局的提供商模型列表缓存 按提供商 id 保存
+var providerModels = newModelDiscoveryCache()
+
+// modelDiscoveryEntry 缓存的模型列表 configHash 为获取时提供商配置的摘要
+type modelDiscoveryEntry struct {
+	configHash string
+	models     []providers.Model
+	expires    time.Time
+}
+
+// modelDiscoveryCache 提供商模型列表的内存缓存 提供商配置变化后原有条目失效
+type modelDiscoveryCache struct {
+	mu      sync.Mutex
+	entries map[uint]modelDiscoveryEntry
+	now     func() time.Time
+}
+
+func newModelDiscoveryCache() *modelDiscoveryCache {
+	return &modelDiscoveryCache{entries: make(map[uint]modelDiscoveryEntry), now: time.Now}
+}
+
+// get 返回未过期且配置一致的模型列表
+func (c *modelDiscoveryCache) get(id uint, configHash string) ([]providers.Model, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	if entry.configHash != configHash || !c.now().Before(entry.expires) {
+		delete(c.entries, id)
+		return nil, false
+	}
+	return entry.models, true
+}
+
+func (c *modelDiscoveryCache) set(id uint, configHash string, list []providers.Model, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[id] = modelDiscoveryEntry{configHash: configHash, models: list, expires: c.now().Add(ttl)}
+}
+
+// providerConfigHash 提供商类型与配置的摘要 任一变化都使缓存失效
+func providerConfigHash(provider models.Provider) string {
+	sum := sha256.Sum256([]byte(provider.Type + "\x00" + provider.Config))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadModelDiscoveryTTL 读取模型列表缓存有效期 读取失败时使用默认值
+func loadModelDiscoveryTTL(ctx context.Context) time.Duration {
+	config, err := LoadConfig[models.ModelDiscovery](ctx, models.KeyModelDiscovery)
+	if err != nil {
+		slog.Error("load model discovery config error", "error", err)
+		return defaultModelDiscoveryTTL
+	}
+	if config == nil || config.TTL <= 0 {
+		return defaultModelDiscoveryTTL
+	}
+	return time.Duration(config.TTL) * time.Second
+}
+
+// CachedProviderModels 返回提供商的模型列表 优先使用缓存 refresh 为 true 时重新获取
+// 第二个返回值表示结果来自缓存 获取失败的结果不缓存
+func CachedProviderModels(ctx context.Context, provider models.Provider, refresh bool, fetch func(ctx context.Context) ([]providers.Model, error)) ([]providers.Model, bool, error) {
+	configHash := providerConfigHash(provider)
+	if !refresh {
+		if list, ok := providerModels.get(provider.ID, configHash); ok {
+			return list, true, nil
+		}
+	}
+	list, err := fetch(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	providerModels.set(provider.ID, configHash, list, loadModelDiscoveryTTL(ctx))
+	return list, false, nil
+}
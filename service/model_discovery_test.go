@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/providers"
+)
+
+func TestCachedProviderModels(t *testing.T) {
+	setupChatDB(t)
+	ctx := context.Background()
+	if err := SaveConfig(ctx, models.KeyModelDiscovery, models.ModelDiscovery{TTL: 60}); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	orig := providerModels
+	providerModels = newModelDiscoveryCache()
+	providerModels.now = func() time.Time { return now }
+	t.Cleanup(func() { providerModels = orig })
+
+	fetches := 0
+	fetch := func(context.Context) ([]providers.Model, error) {
+		fetches++
+		return []providers.Model{{ID: "gpt-4o"}}, nil
+	}
+	provider := models.Provider{Type: "openai", Config: `{"base_url":"https://a","api_key":"k"}`}
+	provider.ID = 1
+
+	tests := []struct {
+		name        string
+		elapsed     time.Duration
+		config      string
+		refresh     bool
+		wantCached  bool
+		wantFetches int
+	}{
+		{"first call fetches", 0, "", false, false, 1},
+		{"cache hit", 30 * time.Second, "", false, true, 1},
+		{"refresh bypasses cache", 30 * time.Second, "", true, false, 2},
+		{"ttl expiry", 91 * time.Second, "", false, false, 3},
+		{"config change invalidates", 91 * time.Second, `{"base_url":"https://b","api_key":"k"}`, false, false, 4},
+		{"new config cached", 100 * time.Second, `{"base_url":"https://b","api_key":"k"}`, false, true, 4},
+	}
+	for _, tt := range tests {
+		now = start.Add(tt.elapsed)
+		if tt.config != "" {
+			provider.Config = tt.config
+		}
+		list, cached, err := CachedProviderModels(ctx, provider, tt.refresh, fetch)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if cached != tt.wantCached || fetches != tt.wantFetches || len(list) != 1 {
+			t.Errorf("%s: cached = %v fetches = %d, want %v %d", tt.name, cached, fetches, tt.wantCached, tt.wantFetches)
+		}
+	}
+
+	// 获取失败的结果不缓存
+	provider.ID = 2
+	if _, _, err := CachedProviderModels(ctx, provider, false, func(context.Context) ([]providers.Model, error) {
+		return nil, errors.New("upstream down")
+	}); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, cached, _ := CachedProviderModels(ctx, provider, false, fetch); cached {
+		t.Error("failed fetch was cached")
+	}
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"strings"
+)
+
+// servedModelDrift 比较请求的提供商模型与上游响应中的模型
+// record 表示两者不同需记录实际服务的模型 drift 表示不是请求模型的日期或版本快照 可能被静默替换
+// 如请求 gpt-4o 返回 gpt-4o-2024-08-06 仅记录 返回 gpt-4o-mini 视为漂移
+func servedModelDrift(requested, served string) (record bool, drift bool) {
+	if served == "" || requested == "" || strings.EqualFold(requested, served) {
+		return false, false
+	}
+	base := strings.ToLower(strings.TrimSuffix(requested, "-latest"))
+	suffix, ok := strings.CutPrefix(strings.ToLower(served), base+"-")
+	if ok && isVersionSuffix(suffix) {
+		return true, false
+	}
+	return true, true
+}
+
+// isVersionSuffix 仅由数字与连字符组成的后缀 如 2024-08-06 20241022 0613
+func isVersionSuffix(suffix string) bool {
+	if suffix == "" {
+		return false
+	}
+	for _, r := range suffix {
+		if (r < '0' || r > '9') && r != '-' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+)
+
+func TestServedModelDrift(t *testing.T) {
+	tests := []struct {
+		requested, served string
+		record, drift     bool
+	}{
+		{"gpt-4o", "", false, false},
+		{"gpt-4o", "gpt-4o", false, false},
+		{"gpt-4o", "GPT-4o", false, false},
+		{"gpt-4o", "gpt-4o-2024-08-06", true, false},
+		{"gpt-4", "gpt-4-0613", true, false},
+		{"claude-3-5-sonnet-latest", "claude-3-5-sonnet-20241022", true, false},
+		{"gpt-4o", "gpt-4o-mini", true, true},
+		{"gpt-4o", "gpt-4o-mini-2024-07-18", true, true},
+		{"gpt-4", "gpt-3.5-turbo", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.requested+"/"+tt.served, func(t *testing.T) {
+			record, drift := servedModelDrift(tt.requested, tt.served)
+			if record != tt.record || drift != tt.drift {
+				t.Errorf("servedModelDrift() = %v, %v, want %v, %v", record, drift, tt.record, tt.drift)
+			}
+		})
+	}
+}
+
+func TestRecordLogServedModel(t *testing.T) {
+	tests := []struct {
+		name       string
+		served     string
+		wantServed string
+		wantDrift  bool
+	}{
+		{"same model", "gpt-4o", "", false},
+		{"dated snapshot", "gpt-4o-2024-08-06", "gpt-4o-2024-08-06", false},
+		{"silent downgrade", "gpt-4o-mini", "gpt-4o-mini", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupChatDB(t)
+			ctx := context.Background()
+			model := models.Model{Name: "gpt-4o", MaxRetry: 1, TimeOut: 10}
+			if err := db.Create(&model).Error; err != nil {
+				t.Fatal(err)
+			}
+			provider := models.Provider{Name: "mock", Type: consts.StyleMock, Config: `{"body":{"model":"` + tt.served + `","choices":[]}}`}
+			if err := db.Create(&provider).Error; err != nil {
+				t.Fatal(err)
+			}
+			enabled := true
+			if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: &enabled, Weight: 1}).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			before, err := BeforerOpenAI([]byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta, err := ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAI, *before)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			res, logID, err := BalanceChat(ctx, start, consts.StyleOpenAI, *before, *meta, models.ReqMeta{Header: http.Header{}})
+			if err != nil {
+				t.Fatalf("BalanceChat() error = %v", err)
+			}
+			RecordLog(CopyStreamContext(res.Request.Context()), start, res.Body, ProcesserOpenAI, logID, *before, false)
+
+			var log models.ChatLog
+			if err := db.First(&log, logID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if log.ServedModel != tt.wantServed || log.ModelDrift != tt.wantDrift {
+				t.Errorf("served = %q drift = %v, want %q %v", log.ServedModel, log.ModelDrift, tt.wantServed, tt.wantDrift)
+			}
+		})
+	}
+}
@@ -3,11 +3,23 @@ package service
 import (
 	"context"
 
+	"github.com/atopos31/llmio/consts"
 	"github.com/atopos31/llmio/models"
 	"github.com/samber/lo"
 	"gorm.io/gorm"
 )
 
+// ProviderTypes 返回可服务指定接口风格的提供商类型 mock 提供商可服务任意风格
+func ProviderTypes(style string) []string {
+	switch style {
+	case consts.StyleAnthropic:
+		return []string{consts.StyleAnthropic, consts.StyleBedrock, consts.StyleMock}
+	case consts.StyleOpenAICompletion:
+		return []string{consts.StyleOpenAI, consts.StyleMock}
+	}
+	return []string{style, consts.StyleMock}
+}
+
 func ModelsByTypes(ctx context.Context, modelTypes ...string) ([]models.Model, error) {
 	llmproviders, err := gorm.G[models.Provider](models.DB).Where("type IN ?", modelTypes).Find(ctx)
 	if err != nil {
@@ -19,7 +31,11 @@ func ModelsByTypes(ctx context.Context, modelTypes ...string) ([]models.Model, e
 		return nil, err
 	}
 
-	models, err := gorm.G[models.Model](models.DB).Where("id IN ?", lo.Map(modelWithProviders, func(mp models.ModelWithProvider, _ int) uint { return mp.ModelID })).Find(ctx)
+	// 停用的模型不对外展示
+	models, err := gorm.G[models.Model](models.DB).
+		Where("id IN ?", lo.Map(modelWithProviders, func(mp models.ModelWithProvider, _ int) uint { return mp.ModelID })).
+		Where("status IS NULL OR status = ?", true).
+		Find(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+)
+
+const defaultModerationTimeout = 5 * time.Second
+
+// PolicyError 请求被转发前策略拒绝
+type PolicyError struct {
+	Reason string
+}
+
+func (e PolicyError) Error() string {
+	return "request blocked by policy: " + e.Reason
+}
+
+// ErrModerationUnavailable 审核不放行时审核服务不可用 请求未经审核 不视为违规
+var ErrModerationUnavailable = errors.New("moderation unavailable")
+
+// PreDispatchHook 转发前执行的钩子，返回 PolicyError 表示拒绝该请求
+type PreDispatchHook func(ctx context.Context, style string, before Before) error
+
+var preDispatchHooks = []PreDispatchHook{ModerationHook}
+
+// RegisterPreDispatchHook 注册转发前钩子
+func RegisterPreDispatchHook(hook PreDispatchHook) {
+	preDispatchHooks = append(preDispatchHooks, hook)
+}
+
+// RunPreDispatchHooks 依次执行转发前钩子，被策略拒绝的请求记录为 blocked 日志，审核服务不可用记录为 error 日志
+func RunPreDispatchHooks(ctx context.Context, style string, before Before, reqMeta models.ReqMeta) error {
+	for _, hook := range preDispatchHooks {
+		err := hook(ctx, style, before)
+		if err == nil {
+			continue
+		}
+		var policyErr PolicyError
+		if errors.As(err, &policyErr) {
+			saveBlockedLog(ctx, style, before, reqMeta, policyErr)
+		} else if errors.Is(err, ErrModerationUnavailable) {
+			saveRejectedLog(ctx, style, before, reqMeta, "error", err.Error())
+		}
+		return err
+	}
+	return nil
+}
+
+// saveBlockedLog 记录被策略拒绝的请求
+func saveBlockedLog(ctx context.Context, style string, before Before, reqMeta models.ReqMeta, policyErr PolicyError) {
+	saveRejectedLog(ctx, style, before, reqMeta, "blocked", policyErr.Reason)
+}
+
+// saveRejectedLog 记录转发前被拒绝的请求
+func saveRejectedLog(ctx context.Context, style string, before Before, reqMeta models.ReqMeta, status, reason string) {
+	authKeyID, _ := ctx.Value(consts.ContextKeyAuthKeyID).(uint)
+	if _, err := SaveChatLog(ctx, models.ChatLog{
+		Name:           before.Model,
+		Status:         status,
+		Style:          style,
+		UserAgent:      reqMeta.UserAgent,
+		RemoteIP:       reqMeta.RemoteIP,
+		AuthKeyID:      authKeyID,
+		EndUser:        before.EndUser(),
+		RequestedModel: before.RequestedModel(),
+		Anomaly:        before.Anomaly(),
+		Error:          reason,
+	}); err != nil {
+		slog.Error("save rejected chat log error", "error", err)
+	}
+}
+
+// Moderator 内容审核器
+type Moderator interface {
+	Moderate(ctx context.Context, text string) (flagged bool, reason string, err error)
+}
+
+// KeywordModerator 本地关键词策略，忽略大小写匹配
+type KeywordModerator []string
+
+func (k KeywordModerator) Moderate(_ context.Context, text string) (bool, string, error) {
+	lower := strings.ToLower(text)
+	for _, keyword := range k {
+		keyword = strings.TrimSpace(keyword)
+		if keyword == "" {
+			continue
+		}
+		if strings.Contains(lower, strings.ToLower(keyword)) {
+			return true, "keyword: " + keyword, nil
+		}
+	}
+	return false, "", nil
+}
+
+// EndpointModerator 调用 OpenAI moderations 兼容接口
+type EndpointModerator struct {
+	Endpoint string
+	APIKey   string
+	Model    string
+	Client   *http.Client
+}
+
+func (e EndpointModerator) Moderate(ctx context.Context, text string) (bool, string, error) {
+	payload := map[string]string{"input": text}
+	if e.Model != "" {
+		payload["model"] = e.Model
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return false, "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.APIKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", e.APIKey))
+	}
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return false, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return false, "", fmt.Errorf("moderation status code: %d", res.StatusCode)
+	}
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(res.Body); err != nil {
+		return false, "", err
+	}
+	var categories []string
+	flagged := false
+	gjson.GetBytes(buf.Bytes(), "results").ForEach(func(_, result gjson.Result) bool {
+		if !result.Get("flagged").Bool() {
+			return true
+		}
+		flagged = true
+		result.Get("categories").ForEach(func(key, value gjson.Result) bool {
+			if value.Bool() {
+				categories = append(categories, key.String())
+			}
+			return true
+		})
+		return true
+	})
+	if !flagged {
+		return false, "", nil
+	}
+	sort.Strings(categories)
+	if len(categories) == 0 {
+		return true, "flagged by moderation", nil
+	}
+	return true, "flagged: " + strings.Join(categories, ","), nil
+}
+
+// ModerationHook 按全局配置与 AuthKey 开关执行内容审核
+func ModerationHook(ctx context.Context, _ string, before Before) error {
+	config, err := LoadConfig[models.Moderation](ctx, models.KeyModeration)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+	enabled := config.Enabled
+	if override, ok := ctx.Value(consts.ContextKeyModeration).(bool); ok {
+		enabled = override
+	}
+	if !enabled || before.Prompt() == "" {
+		return nil
+	}
+
+	timeout := defaultModerationTimeout
+	if config.TimeOut > 0 {
+		timeout = time.Second * time.Duration(config.TimeOut)
+	}
+	moderateCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	moderators := []Moderator{KeywordModerator(config.Keywords)}
+	if config.Endpoint != "" {
+		moderators = append(moderators, EndpointModerator{
+			Endpoint: config.Endpoint,
+			APIKey:   config.APIKey,
+			Model:    config.Model,
+		})
+	}
+	for _, moderator := range moderators {
+		flagged, reason, err := moderator.Moderate(moderateCtx, before.Prompt())
+		if err != nil {
+			if config.FailOpen {
+				slog.Warn("moderation failed, fail open", "model", before.Model, "error", err)
+				continue
+			}
+			slog.Error("moderation failed, fail closed", "model", before.Model, "error", err)
+			return fmt.Errorf("%w: %v", ErrModerationUnavailable, err)
+		}
+		if flagged {
+			return PolicyError{Reason: reason}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKeywordModerator(t *testing.T) {
+	moderator := KeywordModerator{"Forbidden", " ", ""}
+	tests := []struct {
+		text    string
+		flagged bool
+	}{
+		{text: "this is fine", flagged: false},
+		{text: "a forbidden topic", flagged: true},
+		{text: "FORBIDDEN", flagged: true},
+	}
+	for _, tt := range tests {
+		flagged, _, err := moderator.Moderate(context.Background(), tt.text)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if flagged != tt.flagged {
+			t.Errorf("text %q: expected flagged=%v, got %v", tt.text, tt.flagged, flagged)
+		}
+	}
+}
+
+func TestEndpointModerator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sk-test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"results":[{"flagged":true,"categories":{"violence":true,"hate":false,"harassment":true}}]}`))
+	}))
+	defer server.Close()
+
+	flagged, reason, err := EndpointModerator{Endpoint: server.URL, APIKey: "sk-test"}.Moderate(context.Background(), "text")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !flagged {
+		t.Fatal("expected flagged")
+	}
+	if reason != "flagged: harassment,violence" {
+		t.Errorf("unexpected reason: %s", reason)
+	}
+
+	if _, _, err := (EndpointModerator{Endpoint: server.URL}).Moderate(context.Background(), "text"); err == nil {
+		t.Error("expected error on non-200 status")
+	}
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// ApplyParamPolicy 按模型的参数策略检查请求参数
+// clamp 模式将越界的值修正为边界值 reject 模式返回 PolicyError 并记录 blocked 日志
+func ApplyParamPolicy(ctx context.Context, style string, before *Before, policy models.ParamPolicy, reqMeta models.ReqMeta) error {
+	raw, clamped, err := clampParams(before.raw, policy)
+	if err != nil {
+		var policyErr PolicyError
+		if errors.As(err, &policyErr) {
+			saveBlockedLog(ctx, style, *before, reqMeta, policyErr)
+		}
+		return err
+	}
+	before.raw = raw
+	before.clampedParams = clamped
+	return nil
+}
+
+// clampParams 返回修正后的请求体与被修正的参数 非数值的参数交由上游校验
+func clampParams(raw []byte, policy models.ParamPolicy) ([]byte, []string, error) {
+	var clamped []string
+	for _, path := range slices.Sorted(maps.Keys(policy.Bounds)) {
+		value := gjson.GetBytes(raw, path)
+		if value.Type != gjson.Number {
+			continue
+		}
+		bound := policy.Bounds[path]
+		limit := value.Num
+		if bound.Min != nil && limit < *bound.Min {
+			limit = *bound.Min
+		}
+		if bound.Max != nil && limit > *bound.Max {
+			limit = *bound.Max
+		}
+		if limit == value.Num {
+			continue
+		}
+		if policy.Mode == models.ParamPolicyReject {
+			return nil, nil, PolicyError{Reason: fmt.Sprintf("%s %s out of range %s", path, value.Raw, formatBound(bound))}
+		}
+		var err error
+		if raw, err = sjson.SetBytes(raw, path, limit); err != nil {
+			return nil, nil, err
+		}
+		clamped = append(clamped, path)
+	}
+	return raw, clamped, nil
+}
+
+// formatBound 以区间形式描述取值范围
+func formatBound(bound models.ParamBound) string {
+	format := func(v *float64, unbounded string) string {
+		if v == nil {
+			return unbounded
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64)
+	}
+	return "[" + format(bound.Min, "-inf") + ", " + format(bound.Max, "+inf") + "]"
+}
+
+// ValidateParamPolicy 校验参数策略的模式与取值范围
+func ValidateParamPolicy(policy models.ParamPolicy) error {
+	switch policy.Mode {
+	case "", models.ParamPolicyClamp, models.ParamPolicyReject:
+	default:
+		return fmt.Errorf("invalid param policy mode: %s", policy.Mode)
+	}
+	for path, bound := range policy.Bounds {
+		if strings.TrimSpace(path) == "" {
+			return fmt.Errorf("param policy path must not be empty")
+		}
+		if bound.Min != nil && bound.Max != nil && *bound.Min > *bound.Max {
+			return fmt.Errorf("param policy %s: min must not exceed max", path)
+		}
+	}
+	return nil
+}
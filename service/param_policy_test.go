@@ -0,0 +1,112 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+)
+
+func TestClampParams(t *testing.T) {
+	ptr := func(v float64) *float64 { return &v }
+	bounds := map[string]models.ParamBound{
+		"temperature":           {Max: ptr(1)},
+		"max_tokens":            {Min: ptr(1), Max: ptr(8192)},
+		"top_p":                 {Min: ptr(0.1), Max: ptr(0.9)},
+		"thinking.budget_token": {Max: ptr(1024)},
+	}
+
+	tests := []struct {
+		name    string
+		mode    string
+		body    string
+		want    map[string]string // 期望的参数值
+		clamped []string
+		reject  bool
+	}{
+		{
+			name: "within range",
+			body: `{"temperature":0.7,"max_tokens":1024}`,
+			want: map[string]string{"temperature": "0.7", "max_tokens": "1024"},
+		},
+		{
+			name: "boundary values are kept",
+			body: `{"temperature":1,"max_tokens":8192,"top_p":0.1}`,
+			want: map[string]string{"temperature": "1", "max_tokens": "8192", "top_p": "0.1"},
+		},
+		{
+			name:    "clamp above max and below min",
+			body:    `{"temperature":1.5,"max_tokens":0,"top_p":0.95}`,
+			want:    map[string]string{"temperature": "1", "max_tokens": "1", "top_p": "0.9"},
+			clamped: []string{"max_tokens", "temperature", "top_p"},
+		},
+		{
+			name:    "nested path",
+			body:    `{"thinking":{"type":"enabled","budget_token":4096}}`,
+			want:    map[string]string{"thinking.budget_token": "1024", "thinking.type": "enabled"},
+			clamped: []string{"thinking.budget_token"},
+		},
+		{
+			name: "missing and non-number params are ignored",
+			body: `{"temperature":"hot"}`,
+			want: map[string]string{"temperature": "hot"},
+		},
+		{
+			name: "reject within range",
+			mode: models.ParamPolicyReject,
+			body: `{"temperature":1,"max_tokens":8192}`,
+			want: map[string]string{"temperature": "1", "max_tokens": "8192"},
+		},
+		{
+			name:   "reject out of range",
+			mode:   models.ParamPolicyReject,
+			body:   `{"temperature":1.01}`,
+			reject: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, clamped, err := clampParams([]byte(tt.body), models.ParamPolicy{Mode: tt.mode, Bounds: bounds})
+			if tt.reject {
+				var policyErr PolicyError
+				if !errors.As(err, &policyErr) {
+					t.Fatalf("clampParams() error = %v, want PolicyError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("clampParams() error = %v", err)
+			}
+			for path, want := range tt.want {
+				if got := gjson.GetBytes(raw, path).String(); got != want {
+					t.Errorf("%s = %s, want %s", path, got, want)
+				}
+			}
+			if !slices.Equal(clamped, tt.clamped) {
+				t.Errorf("clamped = %v, want %v", clamped, tt.clamped)
+			}
+		})
+	}
+}
+
+func TestValidateParamPolicy(t *testing.T) {
+	one, two := 1.0, 2.0
+	tests := []struct {
+		name    string
+		policy  models.ParamPolicy
+		wantErr bool
+	}{
+		{"empty", models.ParamPolicy{}, false},
+		{"valid", models.ParamPolicy{Mode: models.ParamPolicyReject, Bounds: map[string]models.ParamBound{"temperature": {Min: &one, Max: &two}}}, false},
+		{"unknown mode", models.ParamPolicy{Mode: "drop"}, true},
+		{"min above max", models.ParamPolicy{Bounds: map[string]models.ParamBound{"temperature": {Min: &two, Max: &one}}}, true},
+		{"empty path", models.ParamPolicy{Bounds: map[string]models.ParamBound{" ": {Max: &one}}}, true},
+	}
+	for _, tt := range tests {
+		if err := ValidateParamPolicy(tt.policy); (err != nil) != tt.wantErr {
+			t.Errorf("%s: ValidateParamPolicy() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
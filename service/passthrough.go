@@ -0,0 +1,32 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+// FinishPassthrough 直通模式的响应复制结束后更新冷却状态 不解析响应内容
+// 成功时日志只保留转发时记录的状态与代理耗时 出错时标记为错误
+func FinishPassthrough(res *http.Response, logId uint, copyErr error) {
+	var streamCtx *streamContext
+	if res.Request != nil {
+		streamCtx = streamContextFrom(res.Request.Context())
+	}
+	ctx := context.Background()
+	if copyErr == nil {
+		handleStreamSuccess(ctx, streamCtx)
+		return
+	}
+	handleStreamError(ctx, streamCtx, copyErr)
+	if _, err := gorm.G[models.ChatLog](models.DB).Where("id = ?", logId).Updates(ctx, models.ChatLog{
+		Status:        "error",
+		Error:         copyErr.Error(),
+		FailureReason: FailureReason(copyErr, classifyStreamError(copyErr)),
+	}); err != nil {
+		slog.Error("update passthrough log error", "error", err)
+	}
+}
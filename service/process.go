@@ -4,9 +4,11 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"iter"
+	"math"
 	"strings"
 	"sync"
 	"time"
@@ -21,6 +23,39 @@ const (
 	MaxScannerBufferSize  = 1024 * 1024 * 64 // 64MB
 )
 
+type scannerBufferKey struct{}
+
+// WithMaxScannerBuffer 设置处理响应时 SSE 单行的最大缓冲，小于0表示不限制
+func WithMaxScannerBuffer(ctx context.Context, size int) context.Context {
+	if size == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, scannerBufferKey{}, size)
+}
+
+// newScanner 按 context 中的配置创建 scanner，缓冲区按需增长至上限
+func newScanner(ctx context.Context, pr io.Reader) (*bufio.Scanner, int) {
+	maxBuffer := MaxScannerBufferSize
+	if size, ok := ctx.Value(scannerBufferKey{}).(int); ok && size != 0 {
+		maxBuffer = size
+		if size < 0 {
+			maxBuffer = math.MaxInt
+		}
+	}
+	scanner := bufio.NewScanner(pr)
+	scanner.Buffer(make([]byte, 0, min(InitScannerBufferSize, maxBuffer)), maxBuffer)
+	return scanner, maxBuffer
+}
+
+// scannerErr 将超长行错误转为明确的错误信息，避免被当作部分读取
+func scannerErr(scanner *bufio.Scanner, maxBuffer int) error {
+	err := scanner.Err()
+	if errors.Is(err, bufio.ErrTooLong) {
+		return fmt.Errorf("response line exceeds max scanner buffer of %d bytes: %w", maxBuffer, err)
+	}
+	return err
+}
+
 type Processer func(ctx context.Context, pr io.Reader, stream bool, start time.Time) (*models.ChatLog, *models.OutputUnion, error)
 
 // StreamError SSE 流中的结构化错误
@@ -49,9 +84,24 @@ func (e StreamError) Error() string {
 	return msg
 }
 
-func (e *StreamError) resolveCategory() {
+type statusOverridesKey struct{}
+
+// WithStatusOverrides 设置提供商自定义的状态码归类，用于流中错误的分类
+func WithStatusOverrides(ctx context.Context, overrides cooldown.StatusOverrides) context.Context {
+	if len(overrides) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, statusOverridesKey{}, overrides)
+}
+
+func statusOverridesFrom(ctx context.Context) cooldown.StatusOverrides {
+	overrides, _ := ctx.Value(statusOverridesKey{}).(cooldown.StatusOverrides)
+	return overrides
+}
+
+func (e *StreamError) resolveCategory(ctx context.Context) {
 	if e.Status != 0 {
-		e.Category = cooldown.ClassifyStatus(e.Status)
+		e.Category = statusOverridesFrom(ctx).Classify(e.Status)
 		return
 	}
 	// 根据错误代码和类型分类
@@ -69,7 +119,7 @@ func (e *StreamError) resolveCategory() {
 	}
 }
 
-func parseStreamError(chunk string) error {
+func parseStreamError(ctx context.Context, chunk string) error {
 	errStr := gjson.Get(chunk, "error")
 	if !errStr.Exists() {
 		return nil
@@ -83,22 +133,56 @@ func parseStreamError(chunk string) error {
 	if streamErr.Message == "" {
 		streamErr.Message = errStr.String()
 	}
-	streamErr.resolveCategory()
+	streamErr.resolveCategory(ctx)
 	return streamErr
 }
 
+// tpsPrecision Tps 保留的小数位数
+const tpsPrecision = 2
+
+// tokensPerSecond 计算输出速度并按 tpsPrecision 取整 时钟回拨或耗时为 0 时返回 0 避免写入 Inf 与负值
+func tokensPerSecond(tokens int64, chunkTime time.Duration) float64 {
+	if tokens <= 0 || chunkTime <= 0 {
+		return 0
+	}
+	scale := math.Pow10(tpsPrecision)
+	tps := math.Round(float64(tokens)/chunkTime.Seconds()*scale) / scale
+	if math.IsInf(tps, 0) || math.IsNaN(tps) {
+		return 0
+	}
+	return tps
+}
+
+// joinBody 非流式响应按行读取 拼接为完整响应体 多行或格式化的 JSON 整体校验后再检查结构标记
+func joinBody(lines []string) (string, bool) {
+	body := strings.Join(lines, "\n")
+	return body, json.Valid([]byte(body))
+}
+
+// formatWarning 响应中未出现预期的结构标记时返回告警 用于发现上游格式变化导致的用量提取失效
+func formatWarning(matched bool, marker string) string {
+	if matched {
+		return ""
+	}
+	return "response missing expected " + marker
+}
+
 func ProcesserOpenAI(ctx context.Context, pr io.Reader, stream bool, start time.Time) (*models.ChatLog, *models.OutputUnion, error) {
 	// 首字时延
 	var firstChunkTime time.Duration
 	var once sync.Once
 
 	var usageStr string
+	var fingerprint string
+	var servedModel string
 	var output models.OutputUnion
 	var size int
+	var matched bool
+	var body []string
 
-	scanner := bufio.NewScanner(pr)
-	scanner.Buffer(make([]byte, 0, InitScannerBufferSize), MaxScannerBufferSize)
-	for chunk, chunkSize := range ScannerToken(scanner) {
+	rate := newStreamRate(ctx)
+	scanner, maxBuffer := newScanner(ctx, pr)
+	for event, chunkSize := range ScannerEvents(scanner, stream) {
 		select {
 		case <-ctx.Done():
 			return nil, nil, ctx.Err()
@@ -108,19 +192,28 @@ func ProcesserOpenAI(ctx context.Context, pr io.Reader, stream bool, start time.
 		once.Do(func() {
 			firstChunkTime = time.Since(start)
 		})
+		if stream {
+			rate.observe(event.Data)
+		}
+		chunk := event.Data
 		if !stream {
-			output.OfString = chunk
-			usageStr = gjson.Get(chunk, "usage").String()
-			break
+			body = append(body, chunk)
+			continue
+		}
+		// 只有注释或事件名而没有数据的事件
+		if chunk == "" {
+			continue
 		}
-		chunk = strings.TrimPrefix(chunk, "data: ")
 		if chunk == "[DONE]" {
 			break
 		}
-		if err := parseStreamError(chunk); err != nil {
+		if err := parseStreamError(ctx, chunk); err != nil {
 			return nil, nil, err
 		}
 		output.OfStringArray = append(output.OfStringArray, chunk)
+		if !matched {
+			matched = gjson.Get(chunk, "choices").Exists()
+		}
 
 		// 部分厂商openai格式中 每段sse响应都会返回usage 兼容性考虑
 		// if usageStr != "" {
@@ -131,10 +224,25 @@ func ProcesserOpenAI(ctx context.Context, pr io.Reader, stream bool, start time.
 		if usage.Exists() && usage.Get("total_tokens").Int() != 0 {
 			usageStr = usage.String()
 		}
+		if fp := gjson.Get(chunk, "system_fingerprint").String(); fp != "" {
+			fingerprint = fp
+		}
+		// 模型在早期的块中出现 取第一个
+		if servedModel == "" {
+			servedModel = gjson.Get(chunk, "model").String()
+		}
 	}
-	if err := scanner.Err(); err != nil {
+	if err := scannerErr(scanner, maxBuffer); err != nil {
 		return nil, nil, err
 	}
+	if !stream {
+		chunk, valid := joinBody(body)
+		output.OfString = chunk
+		usageStr = gjson.Get(chunk, "usage").String()
+		fingerprint = gjson.Get(chunk, "system_fingerprint").String()
+		servedModel = gjson.Get(chunk, "model").String()
+		matched = valid && gjson.Get(chunk, "choices").Exists()
+	}
 
 	// token用量
 	var openaiUsage models.Usage
@@ -148,11 +256,16 @@ func ProcesserOpenAI(ctx context.Context, pr io.Reader, stream bool, start time.
 	chunkTime := time.Since(start) - firstChunkTime
 
 	return &models.ChatLog{
-		FirstChunkTime: firstChunkTime,
-		ChunkTime:      chunkTime,
-		Usage:          openaiUsage,
-		Tps:            float64(openaiUsage.TotalTokens) / chunkTime.Seconds(),
-		Size:           size,
+		FirstChunkTime:    firstChunkTime,
+		ChunkTime:         chunkTime,
+		Usage:             openaiUsage,
+		Tps:               tokensPerSecond(openaiUsage.TotalTokens, chunkTime),
+		MinTps:            rate.lowestTps(),
+		SlowStream:        rate.slow(),
+		Size:              size,
+		SystemFingerprint: fingerprint,
+		FormatWarning:     formatWarning(matched, "choices"),
+		ServedModel:       servedModel,
 	}, &output, nil
 }
 
@@ -161,10 +274,13 @@ type OpenAIResUsage struct {
 	OutputTokens       int64              `json:"output_tokens"`
 	TotalTokens        int64              `json:"total_tokens"`
 	InputTokensDetails InputTokensDetails `json:"input_tokens_details"`
+	// 推理模型在此返回思考用量
+	OutputTokensDetails models.CompletionTokensDetails `json:"output_tokens_details"`
 }
 
 type InputTokensDetails struct {
 	CachedTokens int64 `json:"cached_tokens"`
+	AudioTokens  int64 `json:"audio_tokens"`
 }
 
 type AnthropicUsage struct {
@@ -175,18 +291,38 @@ type AnthropicUsage struct {
 	ServiceTier              string `json:"service_tier"`
 }
 
-func mergeAnthropicUsage(target *AnthropicUsage, source gjson.Result) {
-	if v := source.Get("input_tokens").Int(); v > 0 {
-		target.InputTokens += v
-	}
-	if v := source.Get("cache_creation_input_tokens").Int(); v > 0 {
-		target.CacheCreationInputTokens += v
-	}
-	if v := source.Get("cache_read_input_tokens").Int(); v > 0 {
-		target.CacheReadInputTokens += v
-	}
-	if v := source.Get("output_tokens").Int(); v > 0 {
-		target.OutputTokens += v
+// applyAnthropicStreamUsage 按 Anthropic 流式事件语义提取 usage
+// input_tokens 与 cache_* 仅在 message_start 中取一次，output_tokens 为 message_delta 中的累计值，取最后一次而非求和
+func applyAnthropicStreamUsage(target *AnthropicUsage, data string) {
+	switch gjson.Get(data, "type").String() {
+	case "message_start":
+		usage := gjson.Get(data, "message.usage")
+		if !usage.Exists() {
+			return
+		}
+		target.InputTokens = usage.Get("input_tokens").Int()
+		target.CacheCreationInputTokens = usage.Get("cache_creation_input_tokens").Int()
+		target.CacheReadInputTokens = usage.Get("cache_read_input_tokens").Int()
+		target.OutputTokens = usage.Get("output_tokens").Int()
+		target.ServiceTier = usage.Get("service_tier").String()
+	case "message_delta":
+		usage := gjson.Get(data, "usage")
+		if !usage.Exists() {
+			return
+		}
+		if v := usage.Get("output_tokens"); v.Exists() {
+			target.OutputTokens = v.Int()
+		}
+		// 部分兼容厂商 message_start 中不返回输入用量，仅在未取到时使用 message_delta 中的值
+		if target.InputTokens == 0 {
+			target.InputTokens = usage.Get("input_tokens").Int()
+		}
+		if target.CacheCreationInputTokens == 0 {
+			target.CacheCreationInputTokens = usage.Get("cache_creation_input_tokens").Int()
+		}
+		if target.CacheReadInputTokens == 0 {
+			target.CacheReadInputTokens = usage.Get("cache_read_input_tokens").Int()
+		}
 	}
 }
 
@@ -196,13 +332,20 @@ func ProcesserOpenAiRes(ctx context.Context, pr io.Reader, stream bool, start ti
 	var once sync.Once
 
 	var usageStr string
+	var servedModel string
 	var output models.OutputUnion
 	var size int
+	// 非流式响应应包含 output 流式响应应为 response.* 事件
+	var matched bool
+	var body []string
+	marker := "output"
+	if stream {
+		marker = "response events"
+	}
 
-	scanner := bufio.NewScanner(pr)
-	scanner.Buffer(make([]byte, 0, InitScannerBufferSize), MaxScannerBufferSize)
-	var event string
-	for chunk, chunkSize := range ScannerToken(scanner) {
+	rate := newStreamRate(ctx)
+	scanner, maxBuffer := newScanner(ctx, pr)
+	for event, chunkSize := range ScannerEvents(scanner, stream) {
 		select {
 		case <-ctx.Done():
 			return nil, nil, ctx.Err()
@@ -212,31 +355,48 @@ func ProcesserOpenAiRes(ctx context.Context, pr io.Reader, stream bool, start ti
 		once.Do(func() {
 			firstChunkTime = time.Since(start)
 		})
-		if !stream {
-			output.OfString = chunk
-			usageStr = gjson.Get(chunk, "usage").String()
-			break
+		if stream {
+			rate.observe(event.Data)
 		}
-
-		if after, ok := strings.CutPrefix(chunk, "event: "); ok {
-			event = after
+		if !stream {
+			body = append(body, event.Data)
 			continue
 		}
-		content := strings.TrimPrefix(chunk, "data: ")
+
+		content := event.Data
 		if content == "" {
 			continue
 		}
-		if err := parseStreamError(content); err != nil {
+		if err := parseStreamError(ctx, content); err != nil {
 			return nil, nil, err
 		}
 		output.OfStringArray = append(output.OfStringArray, content)
-		if event == "response.completed" {
+		if !matched {
+			matched = strings.HasPrefix(gjson.Get(content, "type").String(), "response.")
+		}
+		// 事件名缺失时使用数据中的 type
+		name := event.Event
+		if name == "" {
+			name = gjson.Get(content, "type").String()
+		}
+		if name == "response.completed" {
 			usageStr = gjson.Get(content, "response.usage").String()
 		}
+		// response.created 等事件携带完整的 response 对象
+		if servedModel == "" {
+			servedModel = gjson.Get(content, "response.model").String()
+		}
 	}
-	if err := scanner.Err(); err != nil {
+	if err := scannerErr(scanner, maxBuffer); err != nil {
 		return nil, nil, err
 	}
+	if !stream {
+		chunk, valid := joinBody(body)
+		output.OfString = chunk
+		usageStr = gjson.Get(chunk, "usage").String()
+		servedModel = gjson.Get(chunk, "model").String()
+		matched = valid && gjson.Get(chunk, "output").Exists()
+	}
 
 	var openAIResUsage OpenAIResUsage
 	usage := []byte(usageStr)
@@ -257,10 +417,16 @@ func ProcesserOpenAiRes(ctx context.Context, pr io.Reader, stream bool, start ti
 			TotalTokens:      openAIResUsage.TotalTokens,
 			PromptTokensDetails: models.PromptTokensDetails{
 				CachedTokens: openAIResUsage.InputTokensDetails.CachedTokens,
+				AudioTokens:  openAIResUsage.InputTokensDetails.AudioTokens,
 			},
+			CompletionTokensDetails: openAIResUsage.OutputTokensDetails,
 		},
-		Tps:  float64(openAIResUsage.TotalTokens) / chunkTime.Seconds(),
-		Size: size,
+		Tps:           tokensPerSecond(openAIResUsage.TotalTokens, chunkTime),
+		MinTps:        rate.lowestTps(),
+		SlowStream:    rate.slow(),
+		Size:          size,
+		FormatWarning: formatWarning(matched, marker),
+		ServedModel:   servedModel,
 	}, &output, nil
 }
 
@@ -270,13 +436,23 @@ func ProcesserAnthropic(ctx context.Context, pr io.Reader, stream bool, start ti
 	var once sync.Once
 
 	var athropicUsage AnthropicUsage
+	// Anthropic 不单独返回思考用量 按思考内容估算
+	var thinking strings.Builder
+	var servedModel string
 
 	var output models.OutputUnion
 	var size int
+	// 非流式响应应包含 content 流式响应应以 message_start 事件开始
+	var matched bool
+	var body []string
+	marker := "content"
+	if stream {
+		marker = "message_start event"
+	}
 
-	scanner := bufio.NewScanner(pr)
-	scanner.Buffer(make([]byte, 0, InitScannerBufferSize), MaxScannerBufferSize)
-	for chunk, chunkSize := range ScannerToken(scanner) {
+	rate := newStreamRate(ctx)
+	scanner, maxBuffer := newScanner(ctx, pr)
+	for event, chunkSize := range ScannerEvents(scanner, stream) {
 		select {
 		case <-ctx.Done():
 			return nil, nil, ctx.Err()
@@ -286,49 +462,56 @@ func ProcesserAnthropic(ctx context.Context, pr io.Reader, stream bool, start ti
 		once.Do(func() {
 			firstChunkTime = time.Since(start)
 		})
-		if !stream {
-			output.OfString = chunk
-			if usageStr := gjson.Get(chunk, "usage").String(); usageStr != "" {
-				usage := []byte(usageStr)
-				if json.Valid(usage) {
-					json.Unmarshal(usage, &athropicUsage)
-				}
-			}
-			break
+		if stream {
+			rate.observe(event.Data)
 		}
-
-		if _, ok := strings.CutPrefix(chunk, "event: "); ok {
+		if !stream {
+			body = append(body, event.Data)
 			continue
 		}
 
-		after, ok := strings.CutPrefix(chunk, "data: ")
-		if !ok {
+		after := event.Data
+		if after == "" {
 			continue
 		}
 
-		if err := parseStreamError(after); err != nil {
+		if err := parseStreamError(ctx, after); err != nil {
 			return nil, nil, err
 		}
 		output.OfStringArray = append(output.OfStringArray, after)
-
-		// 从所有事件中提取 usage 并合并
-		if topUsage := gjson.Get(after, "usage"); topUsage.Exists() {
-			mergeAnthropicUsage(&athropicUsage, topUsage)
+		if !matched {
+			matched = gjson.Get(after, "type").String() == "message_start"
 		}
-		if msgUsage := gjson.Get(after, "message.usage"); msgUsage.Exists() {
-			mergeAnthropicUsage(&athropicUsage, msgUsage)
+		// 模型在 message_start 事件中返回
+		if servedModel == "" {
+			servedModel = gjson.Get(after, "message.model").String()
 		}
+
+		applyAnthropicStreamUsage(&athropicUsage, after)
+		anthropicThinkingText(&thinking, after)
 	}
-	if err := scanner.Err(); err != nil {
+	if err := scannerErr(scanner, maxBuffer); err != nil {
 		return nil, nil, err
 	}
+	if !stream {
+		chunk, valid := joinBody(body)
+		output.OfString = chunk
+		servedModel = gjson.Get(chunk, "model").String()
+		anthropicThinkingText(&thinking, chunk)
+		if usageStr := gjson.Get(chunk, "usage").String(); usageStr != "" {
+			usage := []byte(usageStr)
+			if json.Valid(usage) {
+				json.Unmarshal(usage, &athropicUsage)
+			}
+		}
+		matched = valid && gjson.Get(chunk, "content").Exists()
+	}
 
 	chunkTime := time.Since(start) - firstChunkTime
 	totalTokens := athropicUsage.InputTokens + athropicUsage.OutputTokens
-
-	var tps float64
-	if chunkTime.Seconds() > 0 {
-		tps = float64(totalTokens) / chunkTime.Seconds()
+	reasoningTokens := int64(estimateTextTokens(thinking.String()))
+	if reasoningTokens > athropicUsage.OutputTokens {
+		reasoningTokens = athropicUsage.OutputTokens
 	}
 
 	return &models.ChatLog{
@@ -341,12 +524,84 @@ func ProcesserAnthropic(ctx context.Context, pr io.Reader, stream bool, start ti
 			PromptTokensDetails: models.PromptTokensDetails{
 				CachedTokens: athropicUsage.CacheReadInputTokens,
 			},
+			CompletionTokensDetails: models.CompletionTokensDetails{
+				ReasoningTokens: reasoningTokens,
+			},
 		},
-		Tps:  tps,
-		Size: size,
+		Tps:           tokensPerSecond(totalTokens, chunkTime),
+		MinTps:        rate.lowestTps(),
+		SlowStream:    rate.slow(),
+		Size:          size,
+		FormatWarning: formatWarning(matched, marker),
+		ServedModel:   servedModel,
 	}, &output, nil
 }
 
+// SSEEvent 一个完整的 SSE 事件 多行 data 以换行连接
+type SSEEvent struct {
+	Event string
+	Data  string
+	ID    string
+}
+
+// ScannerEvents 按空行分隔读取完整的 SSE 事件 同时返回事件各行的字节数
+// 注释与未知字段忽略 缺少空行分隔的上游在已有完整 JSON 数据或出现新的事件名时也视为新事件
+// 非流式响应每个非空行作为一个事件的 data
+func ScannerEvents(reader *bufio.Scanner, stream bool) iter.Seq2[SSEEvent, int] {
+	return func(yield func(SSEEvent, int) bool) {
+		if !stream {
+			for chunk, size := range ScannerToken(reader) {
+				if !yield(SSEEvent{Data: chunk}, size) {
+					return
+				}
+			}
+			return
+		}
+
+		var event SSEEvent
+		var data []string
+		size := 0
+		pending := false
+		flush := func() bool {
+			if !pending {
+				return true
+			}
+			event.Data = strings.Join(data, "\n")
+			ok := yield(event, size)
+			event, data, size, pending = SSEEvent{}, nil, 0, false
+			return ok
+		}
+		for reader.Scan() {
+			line := reader.Text()
+			if line == "" {
+				if !flush() {
+					return
+				}
+				continue
+			}
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "event":
+				if len(data) > 0 && !flush() {
+					return
+				}
+				event.Event = value
+			case "data":
+				if len(data) > 0 && gjson.Valid(strings.Join(data, "\n")) && !flush() {
+					return
+				}
+				data = append(data, value)
+			case "id":
+				event.ID = value
+			}
+			size += len(reader.Bytes())
+			pending = true
+		}
+		flush()
+	}
+}
+
 func ScannerToken(reader *bufio.Scanner) iter.Seq2[string, int] {
 	return func(yield func(string, int) bool) {
 		for reader.Scan() {
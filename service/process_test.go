@@ -0,0 +1,523 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/service/cooldown"
+)
+
+const anthropicStreamTranscript = `event: message_start
+data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-sonnet-4-5","content":[],"stop_reason":null,"usage":{"input_tokens":472,"cache_creation_input_tokens":120,"cache_read_input_tokens":2048,"output_tokens":2,"service_tier":"standard"}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: ping
+data: {"type":"ping"}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" world"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"input_tokens":472,"cache_creation_input_tokens":120,"cache_read_input_tokens":2048,"output_tokens":15}}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":23}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+
+func TestProcesserAnthropicStreamUsage(t *testing.T) {
+	log, output, err := ProcesserAnthropic(context.Background(), strings.NewReader(anthropicStreamTranscript), true, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if log.PromptTokens != 472 {
+		t.Errorf("expected prompt tokens 472, got %d", log.PromptTokens)
+	}
+	if log.CompletionTokens != 23 {
+		t.Errorf("expected completion tokens 23 from final message_delta, got %d", log.CompletionTokens)
+	}
+	if log.TotalTokens != 472+23 {
+		t.Errorf("expected total tokens %d, got %d", 472+23, log.TotalTokens)
+	}
+	if log.PromptTokensDetails.CachedTokens != 2048 {
+		t.Errorf("expected cached tokens 2048, got %d", log.PromptTokensDetails.CachedTokens)
+	}
+	if len(output.OfStringArray) != 9 {
+		t.Errorf("expected 9 data chunks, got %d", len(output.OfStringArray))
+	}
+}
+
+func TestApplyAnthropicStreamUsage(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []string
+		want   AnthropicUsage
+	}{
+		{
+			name: "input only from message_start",
+			events: []string{
+				`{"type":"message_start","message":{"usage":{"input_tokens":10,"cache_read_input_tokens":5,"output_tokens":1}}}`,
+				`{"type":"message_delta","usage":{"input_tokens":10,"cache_read_input_tokens":5,"output_tokens":7}}`,
+			},
+			want: AnthropicUsage{InputTokens: 10, CacheReadInputTokens: 5, OutputTokens: 7},
+		},
+		{
+			name: "output is cumulative not summed",
+			events: []string{
+				`{"type":"message_start","message":{"usage":{"input_tokens":3,"output_tokens":1}}}`,
+				`{"type":"message_delta","usage":{"output_tokens":4}}`,
+				`{"type":"message_delta","usage":{"output_tokens":9}}`,
+			},
+			want: AnthropicUsage{InputTokens: 3, OutputTokens: 9},
+		},
+		{
+			name: "fallback to message_delta input when message_start lacks it",
+			events: []string{
+				`{"type":"message_start","message":{"usage":{"output_tokens":0}}}`,
+				`{"type":"message_delta","usage":{"input_tokens":12,"output_tokens":6}}`,
+			},
+			want: AnthropicUsage{InputTokens: 12, OutputTokens: 6},
+		},
+		{
+			name: "ignore usage-like fields on other events",
+			events: []string{
+				`{"type":"message_start","message":{"usage":{"input_tokens":2,"output_tokens":1}}}`,
+				`{"type":"content_block_delta","usage":{"input_tokens":100,"output_tokens":100}}`,
+			},
+			want: AnthropicUsage{InputTokens: 2, OutputTokens: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got AnthropicUsage
+			for _, event := range tt.events {
+				applyAnthropicStreamUsage(&got, event)
+			}
+			got.ServiceTier = ""
+			if got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestProcesserMaxScannerBuffer(t *testing.T) {
+	line := `{"usage":{"total_tokens":3},"content":"` + strings.Repeat("a", 4096) + `"}`
+
+	ctx := WithMaxScannerBuffer(context.Background(), 1024)
+	_, _, err := ProcesserOpenAI(ctx, strings.NewReader(line), false, time.Now())
+	if !errors.Is(err, bufio.ErrTooLong) {
+		t.Fatalf("expected ErrTooLong, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "1024 bytes") {
+		t.Errorf("expected error to mention the configured limit, got %v", err)
+	}
+
+	ctx = WithMaxScannerBuffer(context.Background(), -1)
+	log, output, err := ProcesserOpenAI(ctx, strings.NewReader(line), false, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if output.OfString != line || log.TotalTokens != 3 {
+		t.Errorf("expected full line to be processed")
+	}
+}
+
+func TestProcesserLineLargerThanDefault(t *testing.T) {
+	if testing.Short() {
+		t.Skip("allocates a line larger than the default buffer")
+	}
+	line := `{"content":"` + strings.Repeat("a", MaxScannerBufferSize) + `"}`
+
+	if _, _, err := ProcesserOpenAI(context.Background(), strings.NewReader(line), false, time.Now()); !errors.Is(err, bufio.ErrTooLong) {
+		t.Fatalf("expected ErrTooLong with default buffer, got %v", err)
+	}
+
+	ctx := WithMaxScannerBuffer(context.Background(), MaxScannerBufferSize*2)
+	_, output, err := ProcesserOpenAI(ctx, strings.NewReader(line), false, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(output.OfString) != len(line) {
+		t.Errorf("expected %d bytes, got %d", len(line), len(output.OfString))
+	}
+}
+
+func TestProcesserOpenAISystemFingerprint(t *testing.T) {
+	stream := "data: {\"choices\":[{\"delta\":{\"content\":\"a\"}}],\"system_fingerprint\":\"fp_1\"}\n\n" +
+		"data: {\"choices\":[],\"usage\":{\"total_tokens\":2}}\n\n" +
+		"data: [DONE]\n\n"
+	log, _, err := ProcesserOpenAI(context.Background(), strings.NewReader(stream), true, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if log.SystemFingerprint != "fp_1" {
+		t.Errorf("expected fp_1, got %q", log.SystemFingerprint)
+	}
+
+	log, _, err = ProcesserOpenAI(context.Background(), strings.NewReader(`{"choices":[],"system_fingerprint":"fp_2"}`), false, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if log.SystemFingerprint != "fp_2" {
+		t.Errorf("expected fp_2, got %q", log.SystemFingerprint)
+	}
+
+	log, _, err = ProcesserOpenAI(context.Background(), strings.NewReader(`{"choices":[]}`), false, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if log.SystemFingerprint != "" {
+		t.Errorf("expected empty fingerprint, got %q", log.SystemFingerprint)
+	}
+}
+
+func TestProcesserReasoningTokens(t *testing.T) {
+	tests := []struct {
+		name      string
+		processer Processer
+		stream    bool
+		body      string
+		want      int64
+		total     int64
+	}{
+		{
+			name:      "openai o-series",
+			processer: ProcesserOpenAI,
+			body:      `{"id":"chatcmpl-1","object":"chat.completion","model":"o3-mini","choices":[{"index":0,"message":{"role":"assistant","content":"42"},"finish_reason":"stop"}],"usage":{"prompt_tokens":25,"completion_tokens":1245,"total_tokens":1270,"prompt_tokens_details":{"cached_tokens":0,"audio_tokens":0},"completion_tokens_details":{"reasoning_tokens":1216,"audio_tokens":0,"accepted_prediction_tokens":0,"rejected_prediction_tokens":0}}}`,
+			want:      1216,
+			total:     1270,
+		},
+		{
+			name:      "openai stream",
+			processer: ProcesserOpenAI,
+			stream:    true,
+			body: "data: {\"choices\":[{\"delta\":{\"content\":\"42\"}}]}\n\n" +
+				"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":25,\"completion_tokens\":300,\"total_tokens\":325,\"completion_tokens_details\":{\"reasoning_tokens\":256}}}\n\n" +
+				"data: [DONE]\n\n",
+			want:  256,
+			total: 325,
+		},
+		{
+			name:      "openai without reasoning",
+			processer: ProcesserOpenAI,
+			body:      `{"choices":[],"usage":{"prompt_tokens":5,"completion_tokens":3,"total_tokens":8}}`,
+			want:      0,
+			total:     8,
+		},
+		{
+			name:      "responses",
+			processer: ProcesserOpenAiRes,
+			stream:    true,
+			body: "event: response.created\ndata: {\"type\":\"response.created\"}\n\n" +
+				"event: response.completed\ndata: {\"type\":\"response.completed\",\"response\":{\"usage\":{\"input_tokens\":30,\"input_tokens_details\":{\"cached_tokens\":0},\"output_tokens\":700,\"output_tokens_details\":{\"reasoning_tokens\":640},\"total_tokens\":730}}}\n\n",
+			want:  640,
+			total: 730,
+		},
+		// Anthropic 不返回思考用量 按思考内容估算 英文约 4 字符一个 token
+		{
+			name:      "anthropic thinking",
+			processer: ProcesserAnthropic,
+			body:      `{"type":"message","content":[{"type":"thinking","thinking":"` + strings.Repeat("abcd", 10) + `"},{"type":"text","text":"42"}],"usage":{"input_tokens":20,"output_tokens":512}}`,
+			want:      10,
+			total:     532,
+		},
+		{
+			name:      "anthropic thinking stream",
+			processer: ProcesserAnthropic,
+			stream:    true,
+			body: "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":20,\"output_tokens\":1}}}\n\n" +
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"thinking_delta\",\"thinking\":\"" + strings.Repeat("abcd", 5) + "\"}}\n\n" +
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"thinking_delta\",\"thinking\":\"" + strings.Repeat("abcd", 5) + "\"}}\n\n" +
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"text_delta\",\"text\":\"42\"}}\n\n" +
+				"event: message_delta\ndata: {\"type\":\"message_delta\",\"usage\":{\"output_tokens\":64}}\n\n",
+			want:  10,
+			total: 84,
+		},
+		{
+			name:      "anthropic thinking capped by output",
+			processer: ProcesserAnthropic,
+			body:      `{"type":"message","content":[{"type":"thinking","thinking":"` + strings.Repeat("abcd", 100) + `"}],"usage":{"input_tokens":20,"output_tokens":50}}`,
+			want:      50,
+			total:     70,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, _, err := tt.processer(context.Background(), strings.NewReader(tt.body), tt.stream, time.Now())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := log.CompletionTokensDetails.ReasoningTokens; got != tt.want {
+				t.Errorf("reasoning tokens = %d, want %d", got, tt.want)
+			}
+			if log.TotalTokens != tt.total {
+				t.Errorf("total tokens = %d, want %d", log.TotalTokens, tt.total)
+			}
+		})
+	}
+}
+
+func TestParseStreamErrorStatusOverrides(t *testing.T) {
+	chunk := `{"error":{"message":"rate limited","status":400}}`
+
+	var streamErr StreamError
+	if err := parseStreamError(context.Background(), chunk); !errors.As(err, &streamErr) || streamErr.Category != cooldown.CategoryClient {
+		t.Fatalf("expected default client category, got %v", err)
+	}
+
+	ctx := WithStatusOverrides(context.Background(), cooldown.StatusOverrides{400: cooldown.CategoryKey})
+	if err := parseStreamError(ctx, chunk); !errors.As(err, &streamErr) || streamErr.Category != cooldown.CategoryKey {
+		t.Fatalf("expected overridden key category, got %v", err)
+	}
+}
+
+func TestProviderStatusOverrides(t *testing.T) {
+	overrides, err := providerStatusOverrides(`{"base_url":"https://example.com","status_categories":{"400":"provider"}}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if overrides.Classify(400) != cooldown.CategoryProvider {
+		t.Errorf("expected 400 to be retried on another provider")
+	}
+	if overrides, err := providerStatusOverrides(`{"base_url":"https://example.com"}`); err != nil || overrides != nil {
+		t.Errorf("expected no overrides, got %v %v", overrides, err)
+	}
+	if _, err := providerStatusOverrides(`{"status_categories":{"400":"bogus"}}`); err == nil {
+		t.Error("expected error for invalid category")
+	}
+}
+
+func TestProcesserFormatWarning(t *testing.T) {
+	tests := []struct {
+		name      string
+		processer Processer
+		stream    bool
+		body      string
+		want      string
+	}{
+		{"openai ok", ProcesserOpenAI, false, `{"choices":[{"message":{"content":"hi"}}],"usage":{"total_tokens":2}}`, ""},
+		{"openai drifted", ProcesserOpenAI, false, `{"result":{"text":"hi"},"token_usage":{"total":2}}`, "response missing expected choices"},
+		{"openai stream ok", ProcesserOpenAI, true, "data: {\"choices\":[{\"delta\":{\"content\":\"a\"}}]}\n\ndata: [DONE]\n\n", ""},
+		{"openai stream drifted", ProcesserOpenAI, true, "data: {\"delta\":\"a\"}\n\ndata: [DONE]\n\n", "response missing expected choices"},
+		{"openai empty body", ProcesserOpenAI, false, "", "response missing expected choices"},
+		{"openai pretty printed", ProcesserOpenAI, false, "{\n  \"choices\": [\n    {\"message\": {\"content\": \"hi\"}}\n  ]\n}\n", ""},
+		{"openai truncated", ProcesserOpenAI, false, "{\n  \"choices\": [\n", "response missing expected choices"},
+		{"responses ok", ProcesserOpenAiRes, false, `{"object":"response","output":[],"usage":{"total_tokens":2}}`, ""},
+		{"responses drifted", ProcesserOpenAiRes, false, `{"choices":[]}`, "response missing expected output"},
+		{"responses pretty printed", ProcesserOpenAiRes, false, "{\n  \"object\": \"response\",\n  \"output\": []\n}", ""},
+		{"responses stream ok", ProcesserOpenAiRes, true, "event: response.created\ndata: {\"type\":\"response.created\"}\n\n", ""},
+		{"responses stream drifted", ProcesserOpenAiRes, true, "data: {\"choices\":[]}\n\n", "response missing expected response events"},
+		{"anthropic ok", ProcesserAnthropic, false, `{"type":"message","content":[],"usage":{"input_tokens":1}}`, ""},
+		{"anthropic drifted", ProcesserAnthropic, false, `{"type":"message","completion":"hi"}`, "response missing expected content"},
+		{"anthropic pretty printed", ProcesserAnthropic, false, "{\n  \"type\": \"message\",\n  \"content\": []\n}", ""},
+		{"anthropic stream ok", ProcesserAnthropic, true, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":1}}}\n\n", ""},
+		{"anthropic stream drifted", ProcesserAnthropic, true, "event: completion\ndata: {\"completion\":\"hi\"}\n\n", "response missing expected message_start event"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, output, err := tt.processer(context.Background(), strings.NewReader(tt.body), tt.stream, time.Now())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if log.FormatWarning != tt.want {
+				t.Errorf("FormatWarning = %q, want %q", log.FormatWarning, tt.want)
+			}
+			// 告警不影响响应内容的记录
+			if tt.body != "" && output.OfString == "" && len(output.OfStringArray) == 0 {
+				t.Error("expected output to be recorded")
+			}
+		})
+	}
+}
+
+func TestProcesserServedModel(t *testing.T) {
+	tests := []struct {
+		name      string
+		processer Processer
+		stream    bool
+		body      string
+		want      string
+	}{
+		{"openai", ProcesserOpenAI, false, `{"model":"gpt-4o-mini","choices":[]}`, "gpt-4o-mini"},
+		{"openai stream first chunk", ProcesserOpenAI, true, "data: {\"model\":\"gpt-4o-mini\",\"choices\":[]}\n\ndata: {\"model\":\"other\",\"choices\":[]}\n\ndata: [DONE]\n\n", "gpt-4o-mini"},
+		{"openai missing", ProcesserOpenAI, false, `{"choices":[]}`, ""},
+		{"responses", ProcesserOpenAiRes, false, `{"model":"gpt-4.1","output":[]}`, "gpt-4.1"},
+		{"responses stream", ProcesserOpenAiRes, true, "event: response.created\ndata: {\"type\":\"response.created\",\"response\":{\"model\":\"gpt-4.1\"}}\n\n", "gpt-4.1"},
+		{"anthropic", ProcesserAnthropic, false, `{"type":"message","model":"claude-3-haiku","content":[]}`, "claude-3-haiku"},
+		{"anthropic stream", ProcesserAnthropic, true, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"model\":\"claude-3-haiku\"}}\n\n", "claude-3-haiku"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, _, err := tt.processer(context.Background(), strings.NewReader(tt.body), tt.stream, time.Now())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if log.ServedModel != tt.want {
+				t.Errorf("ServedModel = %q, want %q", log.ServedModel, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokensPerSecond(t *testing.T) {
+	tests := []struct {
+		name      string
+		tokens    int64
+		chunkTime time.Duration
+		want      float64
+	}{
+		{"rounded to two decimals", 100, 3 * time.Second, 33.33},
+		{"tiny chunk time", 1, 3 * time.Nanosecond, 333333333.33},
+		{"tiny rate rounds to zero", 1, 1000 * time.Hour, 0},
+		{"zero chunk time", 10, 0, 0},
+		{"negative chunk time", 10, -time.Millisecond, 0},
+		{"no tokens", 0, time.Second, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tokensPerSecond(tt.tokens, tt.chunkTime)
+			if got != tt.want {
+				t.Errorf("tokensPerSecond(%d, %v) = %v, want %v", tt.tokens, tt.chunkTime, got, tt.want)
+			}
+			// 写入日志与返回 JSON 时不会出现过长的小数展开
+			data, err := json.Marshal(got)
+			if err != nil || len(data) > 16 {
+				t.Errorf("json = %s, err = %v", data, err)
+			}
+		})
+	}
+}
+
+func TestScannerEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		stream bool
+		want   []SSEEvent
+	}{
+		{
+			name:   "multi field events",
+			input:  "id: 1\nevent: response.created\ndata: {\"a\":1}\n\n: keep-alive\n\nevent: response.completed\nid: 2\ndata: {\"b\":2}\n\n",
+			stream: true,
+			want: []SSEEvent{
+				{ID: "1", Event: "response.created", Data: `{"a":1}`},
+				{},
+				{ID: "2", Event: "response.completed", Data: `{"b":2}`},
+			},
+		},
+		{
+			name:   "multi line data joined",
+			input:  "event: message_delta\ndata: {\"type\":\"message_delta\",\ndata: \"usage\":{\"output_tokens\":5}}\n\n",
+			stream: true,
+			want:   []SSEEvent{{Event: "message_delta", Data: "{\"type\":\"message_delta\",\n\"usage\":{\"output_tokens\":5}}"}},
+		},
+		{
+			name:   "no space after colon and trailing event without blank line",
+			input:  "event:done\ndata:[DONE]",
+			stream: true,
+			want:   []SSEEvent{{Event: "done", Data: "[DONE]"}},
+		},
+		{
+			name:   "missing blank lines between events",
+			input:  "data: {\"n\":1}\ndata: {\"n\":2}\nevent: x\ndata: {\"n\":3}\n",
+			stream: true,
+			want:   []SSEEvent{{Data: `{"n":1}`}, {Data: `{"n":2}`}, {Event: "x", Data: `{"n":3}`}},
+		},
+		{
+			name:  "non stream lines",
+			input: "{\"a\":1}\n\n{\"b\":2}\n",
+			want:  []SSEEvent{{Data: `{"a":1}`}, {Data: `{"b":2}`}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []SSEEvent
+			for event := range ScannerEvents(bufio.NewScanner(strings.NewReader(tt.input)), tt.stream) {
+				got = append(got, event)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d events %+v, want %d", len(got), got, len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("event %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestProcesserMultiFieldEvents(t *testing.T) {
+	tests := []struct {
+		name       string
+		processer  Processer
+		transcript string
+		wantTokens int64
+		wantChunks int
+	}{
+		{
+			// 事件名与数据之间夹带 id 与注释 用量仍归属 response.completed
+			name:      "responses",
+			processer: ProcesserOpenAiRes,
+			transcript: "event: response.created\nid: evt_1\ndata: {\"type\":\"response.created\",\"response\":{\"usage\":{\"total_tokens\":1}}}\n\n" +
+				": processing\n\n" +
+				"id: evt_2\nevent: response.completed\n: trailing comment\ndata: {\"type\":\"response.completed\",\n" +
+				"data: \"response\":{\"usage\":{\"input_tokens\":4,\"output_tokens\":6,\"total_tokens\":10}}}\n\n",
+			wantTokens: 10,
+			wantChunks: 2,
+		},
+		{
+			// 多行 data 按单行解析时无法得到完整的 JSON
+			name:      "anthropic",
+			processer: ProcesserAnthropic,
+			transcript: "event: message_start\nid: 1\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":7,\"output_tokens\":1}}}\n\n" +
+				"event: message_delta\nid: 2\ndata: {\"type\":\"message_delta\",\ndata: \"usage\":{\"output_tokens\":9}}\n\n" +
+				"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+			wantTokens: 16,
+			wantChunks: 3,
+		},
+		{
+			name:      "openai ignores comments and event names",
+			processer: ProcesserOpenAI,
+			transcript: ": OPENROUTER PROCESSING\n\n" +
+				"event: chunk\nid: 1\ndata: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\n" +
+				"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":2,\"completion_tokens\":3,\"total_tokens\":5}}\n\ndata: [DONE]\n\n",
+			wantTokens: 5,
+			wantChunks: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, output, err := tt.processer(context.Background(), strings.NewReader(tt.transcript), true, time.Now())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if log.TotalTokens != tt.wantTokens {
+				t.Errorf("total tokens = %d, want %d", log.TotalTokens, tt.wantTokens)
+			}
+			if len(output.OfStringArray) != tt.wantChunks {
+				t.Errorf("chunks = %d %q, want %d", len(output.OfStringArray), output.OfStringArray, tt.wantChunks)
+			}
+			if log.FormatWarning != "" {
+				t.Errorf("format warning = %q", log.FormatWarning)
+			}
+		})
+	}
+}
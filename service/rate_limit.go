@@ -0,0 +1,214 @@
+package service
+
+import (
+	"maps"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+)
+
+const (
+	// rateLimitLowRatio 剩余额度低于该比例时开始按比例降低权重
+	rateLimitLowRatio = 0.2
+	// rateLimitMinFactor 额度耗尽时的最低权重系数 仍保留少量机会以便及时观察到额度恢复
+	rateLimitMinFactor = 0.01
+	// rateLimitMaxAge 估算的最长有效期 未提供重置时间或重置时间过长时以此为准
+	rateLimitMaxAge = time.Minute
+)
+
+// 全局的限流额度估算 使用 Key 池时按 Key 记录 否则按关联记录
+var (
+	associationRateLimits = newRateLimitSet()
+	keyRateLimits         = newRateLimitSet()
+)
+
+// RateLimitWindow 单个限流维度的额度 Limit 为 0 表示响应未提供
+type RateLimitWindow struct {
+	Limit     int64
+	Remaining int64
+	Reset     time.Time // 额度重置时间 零值表示未知
+}
+
+// RateLimitBudget 上游响应头中的限流额度 按请求数与 token 数区分
+type RateLimitBudget struct {
+	Windows []RateLimitWindow
+}
+
+// rateLimitHeaders 各提供商类型的限流响应头 %s 为 limit remaining reset
+// OpenAI 的重置时间为 "6m0s" 形式的时长 Anthropic 为 RFC 3339 时间
+var rateLimitHeaders = map[string][]string{
+	consts.StyleOpenAI:    {"x-ratelimit-%s-requests", "x-ratelimit-%s-tokens"},
+	consts.StyleOpenAIRes: {"x-ratelimit-%s-requests", "x-ratelimit-%s-tokens"},
+	consts.StyleAnthropic: {
+		"anthropic-ratelimit-requests-%s",
+		"anthropic-ratelimit-tokens-%s",
+		"anthropic-ratelimit-input-tokens-%s",
+		"anthropic-ratelimit-output-tokens-%s",
+	},
+}
+
+// ParseRateLimitHeaders 按提供商类型解析限流响应头 没有可用的额度信息时返回 false
+func ParseRateLimitHeaders(providerType string, header http.Header, now time.Time) (RateLimitBudget, bool) {
+	var budget RateLimitBudget
+	for _, pattern := range rateLimitHeaders[providerType] {
+		limit, err := strconv.ParseInt(header.Get(strings.Replace(pattern, "%s", "limit", 1)), 10, 64)
+		if err != nil || limit <= 0 {
+			continue
+		}
+		remaining, err := strconv.ParseInt(header.Get(strings.Replace(pattern, "%s", "remaining", 1)), 10, 64)
+		if err != nil || remaining < 0 {
+			continue
+		}
+		budget.Windows = append(budget.Windows, RateLimitWindow{
+			Limit:     limit,
+			Remaining: remaining,
+			Reset:     parseRateLimitReset(header.Get(strings.Replace(pattern, "%s", "reset", 1)), now),
+		})
+	}
+	return budget, len(budget.Windows) > 0
+}
+
+// parseRateLimitReset 解析重置时间 支持时长 秒数与 RFC 3339 时间
+func parseRateLimitReset(value string, now time.Time) time.Time {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(d)
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return now.Add(time.Duration(seconds * float64(time.Second)))
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+// Ratio 尚未重置的维度中最小的剩余比例 没有有效维度时为 1
+func (b RateLimitBudget) Ratio(now time.Time) float64 {
+	ratio := 1.0
+	for _, w := range b.Windows {
+		if !w.Reset.IsZero() && !now.Before(w.Reset) {
+			continue
+		}
+		ratio = min(ratio, float64(w.Remaining)/float64(w.Limit))
+	}
+	return ratio
+}
+
+// rateLimitFactor 剩余比例对应的权重系数 额度充足时不调整
+func rateLimitFactor(ratio float64) float64 {
+	if ratio >= rateLimitLowRatio {
+		return 1
+	}
+	return math.Max(ratio/rateLimitLowRatio, rateLimitMinFactor)
+}
+
+// rateLimitWeight 按剩余比例降低整数权重 至少保留 1
+func rateLimitWeight(weight int, ratio float64) int {
+	factor := rateLimitFactor(ratio)
+	if factor >= 1 {
+		return weight
+	}
+	return max(int(math.Round(float64(weight)*factor)), 1)
+}
+
+// rateLimitEntry 最近一次观察到的额度与估算的失效时间
+type rateLimitEntry struct {
+	budget  RateLimitBudget
+	expires time.Time
+}
+
+// rateLimitSet 按 ID 保存最近的限流额度 过期项在读取时清理
+type rateLimitSet struct {
+	mu      sync.Mutex
+	entries map[uint]rateLimitEntry
+	now     func() time.Time
+}
+
+func newRateLimitSet() *rateLimitSet {
+	return &rateLimitSet{entries: make(map[uint]rateLimitEntry), now: time.Now}
+}
+
+func (s *rateLimitSet) observe(id uint, budget RateLimitBudget) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	expires := now.Add(rateLimitMaxAge)
+	// 所有维度都重置后估算失效
+	var latest time.Time
+	for _, w := range budget.Windows {
+		if w.Reset.IsZero() {
+			latest = expires
+			break
+		}
+		if w.Reset.After(latest) {
+			latest = w.Reset
+		}
+	}
+	if latest.After(expires) {
+		latest = expires
+	}
+	s.entries[id] = rateLimitEntry{budget: budget, expires: latest}
+}
+
+// ratio 返回 ID 当前的剩余比例 未观察到或已失效时为 1
+func (s *rateLimitSet) ratio(id uint) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[id]
+	if !ok {
+		return 1
+	}
+	now := s.now()
+	if !now.Before(entry.expires) {
+		delete(s.entries, id)
+		return 1
+	}
+	return entry.budget.Ratio(now)
+}
+
+// keyFactor 提供商 Key 的权重系数 供 Key 池选择时使用
+func (s *rateLimitSet) keyFactor(id uint) float64 {
+	return rateLimitFactor(s.ratio(id))
+}
+
+// weights 返回按剩余额度调整后的关联权重 没有需要调整的关联时返回原始权重
+func (s *rateLimitSet) weights(items map[uint]int) map[uint]int {
+	var adjusted map[uint]int
+	for id, weight := range items {
+		w := rateLimitWeight(weight, s.ratio(id))
+		if w == weight {
+			continue
+		}
+		if adjusted == nil {
+			adjusted = maps.Clone(items)
+		}
+		adjusted[id] = w
+	}
+	if adjusted == nil {
+		return items
+	}
+	return adjusted
+}
+
+// observeRateLimits 记录成功响应中的限流额度 供后续请求提前降低接近耗尽的关联与 Key 的权重
+func observeRateLimits(providerType string, header http.Header, associationID, keyID uint) {
+	budget, ok := ParseRateLimitHeaders(providerType, header, time.Now())
+	if !ok {
+		return
+	}
+	// 额度属于实际使用的 Key 同一关联的其他 Key 不受影响
+	if keyID > 0 {
+		keyRateLimits.observe(keyID, budget)
+		return
+	}
+	associationRateLimits.observe(associationID, budget)
+}
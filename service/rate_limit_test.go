@@ -0,0 +1,154 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+)
+
+func TestParseRateLimitHeaders(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		providerType string
+		header       map[string]string
+		wantOK       bool
+		wantRatio    float64
+		wantReset    []time.Time
+	}{
+		{
+			name:         "openai",
+			providerType: consts.StyleOpenAI,
+			header: map[string]string{
+				"x-ratelimit-limit-requests":     "60",
+				"x-ratelimit-remaining-requests": "6",
+				"x-ratelimit-reset-requests":     "1m30s",
+				"x-ratelimit-limit-tokens":       "150000",
+				"x-ratelimit-remaining-tokens":   "149984",
+				"x-ratelimit-reset-tokens":       "6ms",
+			},
+			wantOK:    true,
+			wantRatio: 0.1,
+			wantReset: []time.Time{now.Add(90 * time.Second), now.Add(6 * time.Millisecond)},
+		},
+		{
+			name:         "openai responses with second reset",
+			providerType: consts.StyleOpenAIRes,
+			header: map[string]string{
+				"x-ratelimit-limit-requests":     "100",
+				"x-ratelimit-remaining-requests": "50",
+				"x-ratelimit-reset-requests":     "2.5",
+			},
+			wantOK:    true,
+			wantRatio: 0.5,
+			wantReset: []time.Time{now.Add(2500 * time.Millisecond)},
+		},
+		{
+			name:         "anthropic uses the lowest window",
+			providerType: consts.StyleAnthropic,
+			header: map[string]string{
+				"anthropic-ratelimit-requests-limit":         "50",
+				"anthropic-ratelimit-requests-remaining":     "49",
+				"anthropic-ratelimit-requests-reset":         "2026-01-01T00:00:10Z",
+				"anthropic-ratelimit-input-tokens-limit":     "40000",
+				"anthropic-ratelimit-input-tokens-remaining": "2000",
+				"anthropic-ratelimit-input-tokens-reset":     "2026-01-01T00:00:30Z",
+			},
+			wantOK:    true,
+			wantRatio: 0.05,
+			wantReset: []time.Time{now.Add(10 * time.Second), now.Add(30 * time.Second)},
+		},
+		{
+			name:         "openai names ignored for anthropic",
+			providerType: consts.StyleAnthropic,
+			header:       map[string]string{"x-ratelimit-limit-requests": "60", "x-ratelimit-remaining-requests": "1"},
+		},
+		{
+			name:         "bedrock has no rate limit headers",
+			providerType: consts.StyleBedrock,
+			header:       map[string]string{"x-ratelimit-limit-requests": "60", "x-ratelimit-remaining-requests": "1"},
+		},
+		{
+			name:         "invalid values",
+			providerType: consts.StyleOpenAI,
+			header:       map[string]string{"x-ratelimit-limit-requests": "0", "x-ratelimit-remaining-requests": "1", "x-ratelimit-limit-tokens": "10", "x-ratelimit-remaining-tokens": "n/a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for k, v := range tt.header {
+				header.Set(k, v)
+			}
+			budget, ok := ParseRateLimitHeaders(tt.providerType, header, now)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if got := budget.Ratio(now); got != tt.wantRatio {
+				t.Errorf("Ratio() = %v, want %v", got, tt.wantRatio)
+			}
+			if len(budget.Windows) != len(tt.wantReset) {
+				t.Fatalf("windows = %+v", budget.Windows)
+			}
+			for i, w := range budget.Windows {
+				if !w.Reset.Equal(tt.wantReset[i]) {
+					t.Errorf("window %d reset = %v, want %v", i, w.Reset, tt.wantReset[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitWeights(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	set := newRateLimitSet()
+	set.now = func() time.Time { return now }
+
+	window := func(limit, remaining int64, reset time.Duration) RateLimitBudget {
+		return RateLimitBudget{Windows: []RateLimitWindow{{Limit: limit, Remaining: remaining, Reset: now.Add(reset)}}}
+	}
+	set.observe(1, window(100, 50, time.Minute))    // 额度充足 不调整
+	set.observe(2, window(100, 10, time.Minute))    // 剩余 10% 权重减半
+	set.observe(3, window(100, 0, 30*time.Second))  // 耗尽 保留最低权重
+	set.observe(4, window(1000, 1, 10*time.Second)) // 接近耗尽 但很快重置
+
+	items := map[uint]int{1: 10, 2: 10, 3: 10, 4: 10, 5: 10}
+	got := set.weights(items)
+	want := map[uint]int{1: 10, 2: 5, 3: 1, 4: 1, 5: 10}
+	for id, w := range want {
+		if got[id] != w {
+			t.Errorf("weight[%d] = %d, want %d", id, got[id], w)
+		}
+	}
+	if items[2] != 10 {
+		t.Error("original weights modified")
+	}
+
+	// 重置时间过后恢复原始权重
+	now = now.Add(15 * time.Second)
+	if got := set.weights(items); got[4] != 10 || got[3] != 1 {
+		t.Errorf("after 15s weights = %v", got)
+	}
+	now = now.Add(time.Minute)
+	if got := set.weights(items); got[2] != 10 || got[3] != 10 {
+		t.Errorf("after reset weights = %v", got)
+	}
+	if len(set.entries) != 0 {
+		t.Errorf("expired entries not removed: %v", set.entries)
+	}
+
+	// 没有重置时间时最多保留 rateLimitMaxAge
+	set.observe(6, RateLimitBudget{Windows: []RateLimitWindow{{Limit: 10, Remaining: 0}}})
+	if f := set.keyFactor(6); f != rateLimitMinFactor {
+		t.Errorf("keyFactor = %v, want %v", f, rateLimitMinFactor)
+	}
+	now = now.Add(rateLimitMaxAge)
+	if f := set.keyFactor(6); f != 1 {
+		t.Errorf("keyFactor after max age = %v, want 1", f)
+	}
+}
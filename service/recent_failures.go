@@ -0,0 +1,52 @@
+package service
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// recentFailureTTL 提供商级失败的记忆时间 只用于调整并发请求的尝试顺序 不替代冷却
+const recentFailureTTL = 5 * time.Second
+
+// recentFailures 全局的近期失败关联 并发请求在故障期间优先尝试其他关联
+var recentFailures = newFailureSet(recentFailureTTL)
+
+// failureSet 带过期时间的关联 ID 集合 过期项在读取时清理
+type failureSet struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[uint]time.Time // 关联 ID 对应的过期时间
+	now     func() time.Time
+}
+
+func newFailureSet(ttl time.Duration) *failureSet {
+	return &failureSet{ttl: ttl, entries: make(map[uint]time.Time), now: time.Now}
+}
+
+func (s *failureSet) add(id uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[id] = s.now().Add(s.ttl)
+}
+
+// recent 返回 ids 中仍在失败记忆期内的关联 按 ID 排序
+func (s *failureSet) recent(ids []uint) []uint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	var result []uint
+	for _, id := range ids {
+		expires, ok := s.entries[id]
+		if !ok {
+			continue
+		}
+		if !now.Before(expires) {
+			delete(s.entries, id)
+			continue
+		}
+		result = append(result, id)
+	}
+	slices.Sort(result)
+	return result
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+)
+
+func TestFailureSet(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	set := newFailureSet(5 * time.Second)
+	set.now = func() time.Time { return now }
+
+	set.add(3)
+	set.add(1)
+	if got := set.recent([]uint{1, 2, 3}); !slices.Equal(got, []uint{1, 3}) {
+		t.Fatalf("recent() = %v, want [1 3]", got)
+	}
+	// 再次失败时重新计时
+	now = now.Add(4 * time.Second)
+	set.add(1)
+	now = now.Add(2 * time.Second)
+	if got := set.recent([]uint{1, 2, 3}); !slices.Equal(got, []uint{1}) {
+		t.Fatalf("recent() after expiry = %v, want [1]", got)
+	}
+	if _, ok := set.entries[3]; ok {
+		t.Error("expired entry not removed")
+	}
+	now = now.Add(5 * time.Second)
+	if got := set.recent([]uint{1}); len(got) != 0 {
+		t.Fatalf("recent() = %v, want empty", got)
+	}
+}
+
+func TestBalanceChatRecentFailures(t *testing.T) {
+	db := setupChatDB(t)
+	now := time.Now()
+	recentFailures.now = func() time.Time { return now }
+
+	var down atomic.Bool
+	hits := map[string]*atomic.Int32{"flaky": {}, "stable": {}}
+	model := models.Model{Name: "gpt-4o", MaxRetry: 3, TimeOut: 10, Strategy: consts.BalancerRotor}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	ids := map[string]uint{}
+	// flaky 权重更高 轮转时总是先被选中
+	for _, p := range []struct {
+		name   string
+		weight int
+	}{{"flaky", 10}, {"stable", 1}} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[p.name].Add(1)
+			if p.name == "flaky" && down.Load() {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			fmt.Fprintf(w, `{"choices":[{"message":{"content":"%s"}}]}`, p.name)
+		}))
+		t.Cleanup(server.Close)
+		provider := models.Provider{Name: p.name, Type: consts.StyleOpenAI, Config: `{"base_url":"` + server.URL + `","api_key":"sk-test"}`}
+		if err := db.Create(&provider).Error; err != nil {
+			t.Fatal(err)
+		}
+		mp := models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: &enabled, Weight: p.weight}
+		if err := db.Create(&mp).Error; err != nil {
+			t.Fatal(err)
+		}
+		ids[p.name] = mp.ID
+	}
+
+	ctx := context.Background()
+	serve := func() string {
+		t.Helper()
+		before, err := BeforerOpenAI([]byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta, err := ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAI, *before)
+		if err != nil {
+			t.Fatalf("ProvidersWithMetaBymodelsName() error = %v", err)
+		}
+		res, _, err := BalanceChat(ctx, time.Now(), consts.StyleOpenAI, *before, *meta, models.ReqMeta{Header: http.Header{}})
+		if err != nil {
+			t.Fatalf("BalanceChat() error = %v", err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return string(body)
+	}
+
+	// 首个请求踩到故障后记入近期失败
+	down.Store(true)
+	if body := serve(); !strings.Contains(body, "stable") {
+		t.Fatalf("served by %s, want stable", body)
+	}
+	if got := recentFailures.recent([]uint{ids["flaky"]}); len(got) != 1 {
+		t.Fatal("flaky provider not recorded as recently failed")
+	}
+
+	// 记忆期内后续请求直接使用其他提供商 不再尝试失败的提供商
+	// 冷却在提供商恢复后也可能仍然生效 这里清除冷却只观察近期失败的效果
+	if err := db.Model(&models.ModelWithProvider{}).Where("id = ?", ids["flaky"]).
+		Updates(map[string]any{"provider_cooldown_until": nil, "provider_cooldown_step": 0}).Error; err != nil {
+		t.Fatal(err)
+	}
+	hits["flaky"].Store(0)
+	for range 5 {
+		if body := serve(); !strings.Contains(body, "stable") {
+			t.Fatalf("served by %s, want stable", body)
+		}
+	}
+	if got := hits["flaky"].Load(); got != 0 {
+		t.Errorf("flaky hits = %d within failure window, want 0", got)
+	}
+
+	// 过期后恢复原有的选择顺序
+	down.Store(false)
+	now = now.Add(recentFailureTTL)
+	if body := serve(); !strings.Contains(body, "flaky") {
+		t.Errorf("served by %s after expiry, want flaky", body)
+	}
+}
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+)
+
+// RenderedToolCall 重组后的工具调用
+type RenderedToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// RenderedOutput 从日志输出重组得到的最终助手消息
+type RenderedOutput struct {
+	Text      string             `json:"text"`
+	Reasoning string             `json:"reasoning,omitempty"`
+	ToolCalls []RenderedToolCall `json:"tool_calls"`
+}
+
+// renderer 按出现顺序累积文本与工具调用
+type renderer struct {
+	text      strings.Builder
+	reasoning strings.Builder
+	order     []string
+	toolCalls map[string]*RenderedToolCall
+}
+
+func newRenderer() *renderer {
+	return &renderer{toolCalls: make(map[string]*RenderedToolCall)}
+}
+
+func (r *renderer) toolCall(key string) *RenderedToolCall {
+	call, ok := r.toolCalls[key]
+	if !ok {
+		call = &RenderedToolCall{}
+		r.toolCalls[key] = call
+		r.order = append(r.order, key)
+	}
+	return call
+}
+
+func (r *renderer) result() *RenderedOutput {
+	out := &RenderedOutput{
+		Text:      r.text.String(),
+		Reasoning: r.reasoning.String(),
+		ToolCalls: make([]RenderedToolCall, 0, len(r.order)),
+	}
+	for _, key := range r.order {
+		out.ToolCalls = append(out.ToolCalls, *r.toolCalls[key])
+	}
+	return out
+}
+
+// RenderOutput 将记录的原始输出按 API 风格重组为可读的助手消息
+func RenderOutput(style string, output models.OutputUnion) *RenderedOutput {
+	r := newRenderer()
+	switch style {
+	case consts.StyleOpenAI:
+		renderOpenAI(r, output)
+	case consts.StyleOpenAIRes:
+		renderOpenAIRes(r, output)
+	case consts.StyleAnthropic:
+		renderAnthropic(r, output)
+	case consts.StyleOpenAICompletion:
+		renderOpenAICompletion(r, output)
+	}
+	return r.result()
+}
+
+func renderOpenAI(r *renderer, output models.OutputUnion) {
+	if output.OfString != "" {
+		message := gjson.Get(output.OfString, "choices.0.message")
+		r.text.WriteString(message.Get("content").String())
+		r.reasoning.WriteString(message.Get("reasoning_content").String())
+		message.Get("tool_calls").ForEach(func(key, value gjson.Result) bool {
+			call := r.toolCall(key.String())
+			call.ID = value.Get("id").String()
+			call.Name = value.Get("function.name").String()
+			call.Arguments = value.Get("function.arguments").String()
+			return true
+		})
+		return
+	}
+	for _, chunk := range output.OfStringArray {
+		delta := gjson.Get(chunk, "choices.0.delta")
+		r.text.WriteString(delta.Get("content").String())
+		r.reasoning.WriteString(delta.Get("reasoning_content").String())
+		delta.Get("tool_calls").ForEach(func(_, value gjson.Result) bool {
+			call := r.toolCall(value.Get("index").String())
+			if id := value.Get("id").String(); id != "" {
+				call.ID = id
+			}
+			if name := value.Get("function.name").String(); name != "" {
+				call.Name = name
+			}
+			call.Arguments += value.Get("function.arguments").String()
+			return true
+		})
+	}
+}
+
+// renderOpenAICompletion 拼接 completions 响应中的 choices.text
+func renderOpenAICompletion(r *renderer, output models.OutputUnion) {
+	if output.OfString != "" {
+		r.text.WriteString(gjson.Get(output.OfString, "choices.0.text").String())
+		return
+	}
+	for _, chunk := range output.OfStringArray {
+		r.text.WriteString(gjson.Get(chunk, "choices.0.text").String())
+	}
+}
+
+// renderOpenAIResOutput 解析 Responses API 完整的 output 数组
+func renderOpenAIResOutput(r *renderer, items gjson.Result) {
+	items.ForEach(func(key, item gjson.Result) bool {
+		switch item.Get("type").String() {
+		case "message":
+			item.Get("content").ForEach(func(_, content gjson.Result) bool {
+				if content.Get("type").String() == "output_text" {
+					r.text.WriteString(content.Get("text").String())
+				}
+				return true
+			})
+		case "reasoning":
+			item.Get("summary").ForEach(func(_, summary gjson.Result) bool {
+				r.reasoning.WriteString(summary.Get("text").String())
+				return true
+			})
+		case "function_call":
+			call := r.toolCall(key.String())
+			call.ID = item.Get("call_id").String()
+			call.Name = item.Get("name").String()
+			call.Arguments = item.Get("arguments").String()
+		}
+		return true
+	})
+}
+
+func renderOpenAIRes(r *renderer, output models.OutputUnion) {
+	if output.OfString != "" {
+		renderOpenAIResOutput(r, gjson.Get(output.OfString, "output"))
+		return
+	}
+	// 优先使用 response.completed 中的完整输出
+	for _, chunk := range output.OfStringArray {
+		if gjson.Get(chunk, "type").String() == "response.completed" {
+			renderOpenAIResOutput(r, gjson.Get(chunk, "response.output"))
+			return
+		}
+	}
+	for _, chunk := range output.OfStringArray {
+		event := gjson.Parse(chunk)
+		switch event.Get("type").String() {
+		case "response.output_text.delta":
+			r.text.WriteString(event.Get("delta").String())
+		case "response.reasoning_summary_text.delta":
+			r.reasoning.WriteString(event.Get("delta").String())
+		case "response.output_item.added":
+			if event.Get("item.type").String() == "function_call" {
+				call := r.toolCall(event.Get("item.id").String())
+				call.ID = event.Get("item.call_id").String()
+				call.Name = event.Get("item.name").String()
+			}
+		case "response.function_call_arguments.delta":
+			call := r.toolCall(event.Get("item_id").String())
+			call.Arguments += event.Get("delta").String()
+		}
+	}
+}
+
+func renderAnthropic(r *renderer, output models.OutputUnion) {
+	if output.OfString != "" {
+		gjson.Get(output.OfString, "content").ForEach(func(key, block gjson.Result) bool {
+			switch block.Get("type").String() {
+			case "text":
+				r.text.WriteString(block.Get("text").String())
+			case "thinking":
+				r.reasoning.WriteString(block.Get("thinking").String())
+			case "tool_use":
+				call := r.toolCall(key.String())
+				call.ID = block.Get("id").String()
+				call.Name = block.Get("name").String()
+				call.Arguments = block.Get("input").Raw
+			}
+			return true
+		})
+		return
+	}
+	for _, chunk := range output.OfStringArray {
+		event := gjson.Parse(chunk)
+		index := event.Get("index").String()
+		switch event.Get("type").String() {
+		case "content_block_start":
+			block := event.Get("content_block")
+			if block.Get("type").String() == "tool_use" {
+				call := r.toolCall(index)
+				call.ID = block.Get("id").String()
+				call.Name = block.Get("name").String()
+			}
+		case "content_block_delta":
+			delta := event.Get("delta")
+			switch delta.Get("type").String() {
+			case "text_delta":
+				r.text.WriteString(delta.Get("text").String())
+			case "thinking_delta":
+				r.reasoning.WriteString(delta.Get("thinking").String())
+			case "input_json_delta":
+				call := r.toolCall(index)
+				call.Arguments += delta.Get("partial_json").String()
+			}
+		}
+	}
+}
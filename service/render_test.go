@@ -0,0 +1,119 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+)
+
+func TestRenderOutput(t *testing.T) {
+	tests := []struct {
+		name      string
+		style     string
+		output    models.OutputUnion
+		text      string
+		reasoning string
+		toolCalls []RenderedToolCall
+	}{
+		{
+			name:  "openai stream",
+			style: consts.StyleOpenAI,
+			output: models.OutputUnion{OfStringArray: []string{
+				`{"choices":[{"index":0,"delta":{"role":"assistant","reasoning_content":"think"}}]}`,
+				`{"choices":[{"index":0,"delta":{"content":"Hel"}}]}`,
+				`{"choices":[{"index":0,"delta":{"content":"lo"}}]}`,
+				`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]}}]}`,
+				`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"location\":"}}]}}]}`,
+				`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"NJ\"}"}}]}}]}`,
+				`{"choices":[],"usage":{"total_tokens":3}}`,
+			}},
+			text:      "Hello",
+			reasoning: "think",
+			toolCalls: []RenderedToolCall{{ID: "call_1", Name: "get_weather", Arguments: `{"location":"NJ"}`}},
+		},
+		{
+			name:      "openai non-stream",
+			style:     consts.StyleOpenAI,
+			output:    models.OutputUnion{OfString: `{"choices":[{"message":{"content":"Hi","tool_calls":[{"id":"c","function":{"name":"f","arguments":"{}"}}]}}]}`},
+			text:      "Hi",
+			toolCalls: []RenderedToolCall{{ID: "c", Name: "f", Arguments: "{}"}},
+		},
+		{
+			name:  "completion stream",
+			style: consts.StyleOpenAICompletion,
+			output: models.OutputUnion{OfStringArray: []string{
+				`{"choices":[{"index":0,"text":"return "}]}`,
+				`{"choices":[{"index":0,"text":"a + b"}]}`,
+			}},
+			text: "return a + b",
+		},
+		{
+			name:  "openai responses stream deltas",
+			style: consts.StyleOpenAIRes,
+			output: models.OutputUnion{OfStringArray: []string{
+				`{"type":"response.output_text.delta","delta":"Hi "}`,
+				`{"type":"response.output_text.delta","delta":"there"}`,
+				`{"type":"response.output_item.added","item":{"type":"function_call","id":"fc_1","call_id":"call_1","name":"f"}}`,
+				`{"type":"response.function_call_arguments.delta","item_id":"fc_1","delta":"{\"a\":"}`,
+				`{"type":"response.function_call_arguments.delta","item_id":"fc_1","delta":"1}"}`,
+			}},
+			text:      "Hi there",
+			toolCalls: []RenderedToolCall{{ID: "call_1", Name: "f", Arguments: `{"a":1}`}},
+		},
+		{
+			name:  "openai responses completed",
+			style: consts.StyleOpenAIRes,
+			output: models.OutputUnion{OfStringArray: []string{
+				`{"type":"response.output_text.delta","delta":"ignored"}`,
+				`{"type":"response.completed","response":{"output":[{"type":"message","content":[{"type":"output_text","text":"Done"}]}]}}`,
+			}},
+			text:      "Done",
+			toolCalls: []RenderedToolCall{},
+		},
+		{
+			name:  "anthropic stream",
+			style: consts.StyleAnthropic,
+			output: models.OutputUnion{OfStringArray: []string{
+				`{"type":"message_start","message":{"usage":{"input_tokens":1}}}`,
+				`{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`,
+				`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"hmm"}}`,
+				`{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`,
+				`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Sure"}}`,
+				`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_1","name":"f","input":{}}}`,
+				`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"x\":"}}`,
+				`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"2}"}}`,
+				`{"type":"message_stop"}`,
+			}},
+			text:      "Sure",
+			reasoning: "hmm",
+			toolCalls: []RenderedToolCall{{ID: "toolu_1", Name: "f", Arguments: `{"x":2}`}},
+		},
+		{
+			name:      "anthropic non-stream",
+			style:     consts.StyleAnthropic,
+			output:    models.OutputUnion{OfString: `{"content":[{"type":"text","text":"A"},{"type":"tool_use","id":"t","name":"f","input":{"k":"v"}}]}`},
+			text:      "A",
+			toolCalls: []RenderedToolCall{{ID: "t", Name: "f", Arguments: `{"k":"v"}`}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RenderOutput(tt.style, tt.output)
+			if got.Text != tt.text {
+				t.Errorf("expected text %q, got %q", tt.text, got.Text)
+			}
+			if got.Reasoning != tt.reasoning {
+				t.Errorf("expected reasoning %q, got %q", tt.reasoning, got.Reasoning)
+			}
+			if len(got.ToolCalls) != len(tt.toolCalls) {
+				t.Fatalf("expected %d tool calls, got %d", len(tt.toolCalls), len(got.ToolCalls))
+			}
+			for i := range tt.toolCalls {
+				if got.ToolCalls[i] != tt.toolCalls[i] {
+					t.Errorf("tool call %d: expected %+v, got %+v", i, tt.toolCalls[i], got.ToolCalls[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// ValidateResponseRules 校验响应改写规则
+func ValidateResponseRules(rules []models.ResponseRule) error {
+	for i, rule := range rules {
+		if strings.TrimSpace(rule.Path) == "" {
+			return fmt.Errorf("response_rules[%d]: path must not be empty", i)
+		}
+		switch rule.Type {
+		case models.ResponseRuleRename:
+			if strings.TrimSpace(rule.To) == "" {
+				return fmt.Errorf("response_rules[%d]: rename requires to", i)
+			}
+		case models.ResponseRuleSet:
+			if !gjson.Valid(rule.Value) {
+				return fmt.Errorf("response_rules[%d]: set value must be valid JSON", i)
+			}
+		case models.ResponseRuleDelete, models.ResponseRuleTrimPrefix, models.ResponseRuleHeader:
+		default:
+			return fmt.Errorf("response_rules[%d]: unknown type %q", i, rule.Type)
+		}
+	}
+	return nil
+}
+
+// TransformResponse 按选中关联的改写规则处理返回客户端的响应
+// 用量统计读取的是原始响应 不受改写影响 流式响应需开启 TransformStream
+func TransformResponse(res *http.Response, body io.Reader, stream bool) io.Reader {
+	if res.Request == nil {
+		return body
+	}
+	streamCtx := streamContextFrom(res.Request.Context())
+	if streamCtx == nil || streamCtx.modelWithProvider == nil {
+		return body
+	}
+	mp := streamCtx.modelWithProvider
+
+	var rules []models.ResponseRule
+	for _, rule := range mp.ResponseRules {
+		if rule.Type == models.ResponseRuleHeader {
+			res.Header.Set(rule.Path, rule.Value)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if len(rules) == 0 {
+		return body
+	}
+	if stream {
+		if mp.TransformStream == nil || !*mp.TransformStream {
+			return body
+		}
+		res.Header.Del("Content-Length")
+		return &ruleStreamReader{src: bufio.NewReader(body), rules: rules}
+	}
+	// 改写后长度变化
+	res.Header.Del("Content-Length")
+	return &ruleBodyReader{src: body, rules: rules}
+}
+
+// applyResponseRules 对 JSON 应用改写规则 原内容不是 JSON 或改写出错时保持原样 避免破坏响应
+func applyResponseRules(body []byte, rules []models.ResponseRule) []byte {
+	if !gjson.ValidBytes(body) {
+		return body
+	}
+	out := body
+	for _, rule := range rules {
+		var err error
+		switch rule.Type {
+		case models.ResponseRuleRename:
+			value := gjson.GetBytes(out, rule.Path)
+			if !value.Exists() {
+				continue
+			}
+			if out, err = sjson.SetRawBytes(out, rule.To, []byte(value.Raw)); err != nil {
+				break
+			}
+			// 目标路径无法写入时不删除原字段 避免丢失数据
+			if !gjson.GetBytes(out, rule.To).Exists() {
+				err = fmt.Errorf("cannot set %s", rule.To)
+				break
+			}
+			out, err = sjson.DeleteBytes(out, rule.Path)
+		case models.ResponseRuleSet:
+			out, err = sjson.SetRawBytes(out, rule.Path, []byte(rule.Value))
+		case models.ResponseRuleDelete:
+			out, err = sjson.DeleteBytes(out, rule.Path)
+		case models.ResponseRuleTrimPrefix:
+			value := gjson.GetBytes(out, rule.Path)
+			if value.Type != gjson.String || !strings.HasPrefix(value.Str, rule.Value) {
+				continue
+			}
+			out, err = sjson.SetBytes(out, rule.Path, strings.TrimPrefix(value.Str, rule.Value))
+		}
+		if err != nil {
+			slog.Warn("apply response rule error", "type", rule.Type, "path", rule.Path, "error", err)
+			return body
+		}
+	}
+	if !gjson.ValidBytes(out) {
+		slog.Warn("response rules produced invalid JSON, keep original response")
+		return body
+	}
+	return out
+}
+
+// ruleBodyReader 读取完整的非流式响应后改写
+type ruleBodyReader struct {
+	src   io.Reader
+	rules []models.ResponseRule
+	out   *bytes.Reader
+}
+
+func (r *ruleBodyReader) Read(p []byte) (int, error) {
+	if r.out == nil {
+		body, err := io.ReadAll(r.src)
+		if err != nil {
+			return 0, err
+		}
+		r.out = bytes.NewReader(applyResponseRules(body, r.rules))
+	}
+	return r.out.Read(p)
+}
+
+// ruleStreamReader 逐行改写 SSE 的 data 行 每个 chunk 单独处理
+type ruleStreamReader struct {
+	src     *bufio.Reader
+	rules   []models.ResponseRule
+	pending []byte
+	err     error
+}
+
+func (r *ruleStreamReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		line, err := r.src.ReadBytes('\n')
+		r.err = err
+		r.pending = transformSSELine(line, r.rules)
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// transformSSELine 改写 data 行中的 JSON 保留原有的换行符
+func transformSSELine(line []byte, rules []models.ResponseRule) []byte {
+	data, ok := bytes.CutPrefix(line, []byte("data:"))
+	if !ok {
+		return line
+	}
+	content := bytes.TrimRight(data, "\r\n")
+	ending := data[len(content):]
+	payload := bytes.TrimLeft(content, " ")
+	transformed := applyResponseRules(payload, rules)
+	if bytes.Equal(transformed, payload) {
+		return line
+	}
+	out := make([]byte, 0, len(transformed)+len(ending)+6)
+	out = append(out, "data: "...)
+	out = append(out, transformed...)
+	return append(out, ending...)
+}
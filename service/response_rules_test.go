@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/models"
+)
+
+func TestApplyResponseRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []models.ResponseRule
+		body  string
+		want  string
+	}{
+		{
+			name:  "rename field",
+			rules: []models.ResponseRule{{Type: models.ResponseRuleRename, Path: "choices.0.message.reasoning", To: "choices.0.message.reasoning_content"}},
+			body:  `{"choices":[{"message":{"content":"hi","reasoning":"think"}}]}`,
+			want:  `{"choices":[{"message":{"content":"hi","reasoning_content":"think"}}]}`,
+		},
+		{
+			name:  "rename missing field",
+			rules: []models.ResponseRule{{Type: models.ResponseRuleRename, Path: "missing", To: "other"}},
+			body:  `{"id":"1"}`,
+			want:  `{"id":"1"}`,
+		},
+		{
+			name: "set delete and trim prefix",
+			rules: []models.ResponseRule{
+				{Type: models.ResponseRuleSet, Path: "provider", Value: `"llmio"`},
+				{Type: models.ResponseRuleDelete, Path: "system_fingerprint"},
+				{Type: models.ResponseRuleTrimPrefix, Path: "choices.0.message.content", Value: "[bot] "},
+			},
+			body: `{"system_fingerprint":"fp","choices":[{"message":{"content":"[bot] hello"}}]}`,
+			want: `{"choices":[{"message":{"content":"hello"}}],"provider":"llmio"}`,
+		},
+		{
+			name:  "non json body untouched",
+			rules: []models.ResponseRule{{Type: models.ResponseRuleDelete, Path: "id"}},
+			body:  `[DONE]`,
+			want:  `[DONE]`,
+		},
+		{
+			name:  "invalid path keeps original",
+			rules: []models.ResponseRule{{Type: models.ResponseRuleRename, Path: "id", To: "#"}},
+			body:  `{"id":"1"}`,
+			want:  `{"id":"1"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(applyResponseRules([]byte(tt.body), tt.rules)); got != tt.want {
+				t.Errorf("applyResponseRules() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformResponse(t *testing.T) {
+	rename := models.ResponseRule{Type: models.ResponseRuleRename, Path: "usage.total_tokens", To: "usage.total"}
+	header := models.ResponseRule{Type: models.ResponseRuleHeader, Path: "X-Served-By", Value: "llmio"}
+	enabled := true
+
+	tests := []struct {
+		name   string
+		mp     *models.ModelWithProvider
+		stream bool
+		body   string
+		want   string
+	}{
+		{
+			name: "non-stream rename",
+			mp:   &models.ModelWithProvider{ResponseRules: []models.ResponseRule{rename, header}},
+			body: `{"usage":{"total_tokens":3}}`,
+			want: `{"usage":{"total":3}}`,
+		},
+		{
+			name:   "stream untouched by default",
+			mp:     &models.ModelWithProvider{ResponseRules: []models.ResponseRule{rename, header}},
+			stream: true,
+			body:   "data: {\"usage\":{\"total_tokens\":3}}\n\ndata: [DONE]\n\n",
+			want:   "data: {\"usage\":{\"total_tokens\":3}}\n\ndata: [DONE]\n\n",
+		},
+		{
+			name:   "stream chunks renamed",
+			mp:     &models.ModelWithProvider{ResponseRules: []models.ResponseRule{rename, header}, TransformStream: &enabled},
+			stream: true,
+			body:   "event: message\r\ndata:{\"usage\":{\"total_tokens\":3}}\r\n\r\ndata: [DONE]\n\n",
+			want:   "event: message\r\ndata: {\"usage\":{\"total\":3}}\r\n\r\ndata: [DONE]\n\n",
+		},
+		{
+			name: "no rules",
+			mp:   &models.ModelWithProvider{},
+			body: `{"usage":{"total_tokens":3}}`,
+			want: `{"usage":{"total_tokens":3}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := withStreamContext(context.Background(), &streamContext{modelWithProvider: tt.mp})
+			req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "http://upstream", nil)
+			res := &http.Response{Header: http.Header{"Content-Length": {"28"}}, Request: req}
+
+			got, err := io.ReadAll(TransformResponse(res, strings.NewReader(tt.body), tt.stream))
+			if err != nil {
+				t.Fatalf("read error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+			if len(tt.mp.ResponseRules) > 0 && res.Header.Get("X-Served-By") != "llmio" {
+				t.Errorf("header rule not applied: %v", res.Header)
+			}
+			if string(got) != tt.body && res.Header.Get("Content-Length") != "" {
+				t.Error("Content-Length should be removed after rewriting")
+			}
+		})
+	}
+}
+
+func TestValidateResponseRules(t *testing.T) {
+	tests := []struct {
+		rule    models.ResponseRule
+		wantErr bool
+	}{
+		{models.ResponseRule{Type: models.ResponseRuleRename, Path: "a", To: "b"}, false},
+		{models.ResponseRule{Type: models.ResponseRuleRename, Path: "a"}, true},
+		{models.ResponseRule{Type: models.ResponseRuleSet, Path: "a", Value: `{"x":1}`}, false},
+		{models.ResponseRule{Type: models.ResponseRuleSet, Path: "a", Value: `{x`}, true},
+		{models.ResponseRule{Type: models.ResponseRuleDelete, Path: ""}, true},
+		{models.ResponseRule{Type: "upper", Path: "a"}, true},
+	}
+	for _, tt := range tests {
+		if err := ValidateResponseRules([]models.ResponseRule{tt.rule}); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateResponseRules(%+v) error = %v, wantErr %v", tt.rule, err, tt.wantErr)
+		}
+	}
+}
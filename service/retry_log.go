@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+// defaultRetryLogWindow aggregate 模式默认的合并窗口
+const defaultRetryLogWindow = time.Minute
+
+// RecordRetryLog 按配置的方式记录单个请求内失败尝试的日志 通道关闭时返回
+func RecordRetryLog(ctx context.Context, retryLog chan models.ChatLog, config *models.RetryLog) {
+	mode := models.RetryLogModeAll
+	window := defaultRetryLogWindow
+	if config != nil {
+		if config.Mode != "" {
+			mode = config.Mode
+		}
+		if config.Window > 0 {
+			window = time.Duration(config.Window) * time.Second
+		}
+	}
+
+	switch mode {
+	case models.RetryLogModeAggregate:
+		for log := range retryLog {
+			if err := retryAggregator.save(ctx, log, window, time.Now()); err != nil {
+				slog.Error("save chat log error", "error", err)
+			}
+		}
+	case models.RetryLogModeFinal:
+		var logs []models.ChatLog
+		for log := range retryLog {
+			logs = append(logs, log)
+		}
+		if len(logs) == 0 {
+			return
+		}
+		if _, err := SaveChatLog(ctx, summarizeRetryLogs(logs)); err != nil {
+			slog.Error("save chat log error", "error", err)
+		}
+	default:
+		for log := range retryLog {
+			if _, err := SaveChatLog(ctx, log); err != nil {
+				slog.Error("save chat log error", "error", err)
+			}
+		}
+	}
+}
+
+// summarizeRetryLogs 保留最后一次失败 错误信息前附加按提供商与模型统计的失败汇总
+func summarizeRetryLogs(logs []models.ChatLog) models.ChatLog {
+	final := logs[len(logs)-1]
+	final.ErrorCount = len(logs)
+	if len(logs) == 1 {
+		return final
+	}
+
+	var order []string
+	counts := make(map[string]int)
+	for _, log := range logs {
+		target := log.ProviderName + "/" + log.ProviderModel
+		if counts[target] == 0 {
+			order = append(order, target)
+		}
+		counts[target]++
+	}
+	parts := make([]string, 0, len(order))
+	for _, target := range order {
+		parts = append(parts, fmt.Sprintf("%s x%d", target, counts[target]))
+	}
+	final.Error = fmt.Sprintf("%d failed attempts [%s], last: %s", len(logs), strings.Join(parts, ", "), final.Error)
+	return final
+}
+
+// retryLogAggregator 跨请求合并相同的重试错误 记录每类错误最近一条日志
+type retryLogAggregator struct {
+	mu      sync.Mutex
+	entries map[string]retryLogEntry
+}
+
+type retryLogEntry struct {
+	logID    uint
+	lastSeen time.Time
+}
+
+var retryAggregator = &retryLogAggregator{entries: make(map[string]retryLogEntry)}
+
+// save 窗口内已有相同错误时累加计数 否则新建日志
+func (a *retryLogAggregator) save(ctx context.Context, log models.ChatLog, window time.Duration, now time.Time) error {
+	key := strings.Join([]string{log.ProviderName, log.ProviderModel, log.Error}, "\x00")
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for k, entry := range a.entries {
+		if now.Sub(entry.lastSeen) > window {
+			delete(a.entries, k)
+		}
+	}
+
+	if entry, ok := a.entries[key]; ok {
+		result := models.DB.WithContext(ctx).Model(&models.ChatLog{}).
+			Where("id = ?", entry.logID).
+			Update("error_count", gorm.Expr("error_count + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		// 日志已被清理时重新创建
+		if result.RowsAffected > 0 {
+			a.entries[key] = retryLogEntry{logID: entry.logID, lastSeen: now}
+			return nil
+		}
+	}
+
+	log.ErrorCount = 1
+	id, err := SaveChatLog(ctx, log)
+	if err != nil {
+		return err
+	}
+	a.entries[key] = retryLogEntry{logID: id, lastSeen: now}
+	return nil
+}
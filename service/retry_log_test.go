@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupRetryLogDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get sql db: %v", err)
+	}
+	// 内存数据库每个连接独立 限制为单连接
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&models.ChatLog{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	models.DB = db
+	retryAggregator = &retryLogAggregator{entries: make(map[string]retryLogEntry)}
+	t.Cleanup(func() { models.DB = nil })
+	return db
+}
+
+func failedAttempt(provider, model, err string) models.ChatLog {
+	return models.ChatLog{Name: "gpt-4o", ProviderName: provider, ProviderModel: model, Status: "error", Error: err}
+}
+
+func TestRecordRetryLog(t *testing.T) {
+	attempts := []models.ChatLog{
+		failedAttempt("a", "gpt-4o", "status: 502"),
+		failedAttempt("a", "gpt-4o", "status: 502"),
+		failedAttempt("b", "gpt-4o-mini", "status: 429"),
+		failedAttempt("a", "gpt-4o", "status: 502"),
+	}
+
+	tests := []struct {
+		name   string
+		config *models.RetryLog
+		check  func(t *testing.T, logs []models.ChatLog)
+	}{
+		{
+			name:   "default logs every attempt",
+			config: nil,
+			check: func(t *testing.T, logs []models.ChatLog) {
+				if len(logs) != 4 {
+					t.Errorf("got %d logs, want 4", len(logs))
+				}
+			},
+		},
+		{
+			name:   "aggregate identical errors",
+			config: &models.RetryLog{Mode: models.RetryLogModeAggregate},
+			check: func(t *testing.T, logs []models.ChatLog) {
+				if len(logs) != 2 {
+					t.Fatalf("got %d logs, want 2", len(logs))
+				}
+				if logs[0].ProviderName != "a" || logs[0].ErrorCount != 3 {
+					t.Errorf("first log = %s x%d, want a x3", logs[0].ProviderName, logs[0].ErrorCount)
+				}
+				if logs[1].ProviderName != "b" || logs[1].ErrorCount != 1 {
+					t.Errorf("second log = %s x%d, want b x1", logs[1].ProviderName, logs[1].ErrorCount)
+				}
+			},
+		},
+		{
+			name:   "final failure with summary",
+			config: &models.RetryLog{Mode: models.RetryLogModeFinal},
+			check: func(t *testing.T, logs []models.ChatLog) {
+				if len(logs) != 1 {
+					t.Fatalf("got %d logs, want 1", len(logs))
+				}
+				want := "4 failed attempts [a/gpt-4o x3, b/gpt-4o-mini x1], last: status: 502"
+				if logs[0].Error != want || logs[0].ErrorCount != 4 {
+					t.Errorf("final log = %q x%d, want %q x4", logs[0].Error, logs[0].ErrorCount, want)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupRetryLogDB(t)
+			ch := make(chan models.ChatLog, len(attempts))
+			for _, attempt := range attempts {
+				ch <- attempt
+			}
+			close(ch)
+			RecordRetryLog(context.Background(), ch, tt.config)
+
+			var logs []models.ChatLog
+			if err := db.Order("id").Find(&logs).Error; err != nil {
+				t.Fatalf("failed to query logs: %v", err)
+			}
+			tt.check(t, logs)
+		})
+	}
+}
+
+func TestRetryLogAggregatorWindow(t *testing.T) {
+	db := setupRetryLogDB(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	for _, at := range []time.Time{now, now.Add(30 * time.Second), now.Add(2 * time.Minute)} {
+		if err := retryAggregator.save(ctx, failedAttempt("a", "gpt-4o", "timeout"), time.Minute, at); err != nil {
+			t.Fatalf("save() error = %v", err)
+		}
+	}
+
+	var logs []models.ChatLog
+	if err := db.Order("id").Find(&logs).Error; err != nil {
+		t.Fatalf("failed to query logs: %v", err)
+	}
+	if len(logs) != 2 || logs[0].ErrorCount != 2 || logs[1].ErrorCount != 1 {
+		t.Errorf("got %d logs, want counts [2 1]", len(logs))
+	}
+}
+
+func TestRetryLogAggregatorConcurrent(t *testing.T) {
+	db := setupRetryLogDB(t)
+	ctx := context.Background()
+
+	const requests = 20
+	var wg sync.WaitGroup
+	for range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := retryAggregator.save(ctx, failedAttempt("a", "gpt-4o", "status: 503"), time.Minute, time.Now()); err != nil {
+				t.Errorf("save() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var logs []models.ChatLog
+	if err := db.Find(&logs).Error; err != nil {
+		t.Fatalf("failed to query logs: %v", err)
+	}
+	if len(logs) != 1 || logs[0].ErrorCount != requests {
+		t.Errorf("got %d logs, want a single log counting %d errors", len(logs), requests)
+	}
+	if !strings.Contains(logs[0].Error, "503") {
+		t.Errorf("unexpected error %q", logs[0].Error)
+	}
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service/cooldown"
+)
+
+// ShadowChat 将请求副本异步发送到各影子关联 响应只用于记录日志 不会返回客户端
+// 冷却中的影子关联跳过 key 选择与冷却更新与正常请求一致
+func ShadowChat(ctx context.Context, style string, before Before, providersWithMeta ProvidersWithMeta, reqMeta models.ReqMeta, processer Processer) {
+	if len(providersWithMeta.ShadowProviders) == 0 {
+		return
+	}
+	// 客户端断开不影响影子请求
+	ctx = context.WithoutCancel(ctx)
+	cooldownManager := cooldown.NewManager(models.DB)
+	for _, mp := range providersWithMeta.ShadowProviders {
+		if cooldownManager.InCooldown(mp) {
+			continue
+		}
+		shadow := providersWithMeta.shadowOf(mp)
+		recordLogs.Add(1)
+		go func() {
+			defer recordLogs.Done()
+			start := time.Now()
+			res, logId, err := BalanceChat(ctx, start, style, before, shadow, reqMeta)
+			if err != nil {
+				slog.Warn("shadow request failed", "model", before.Model, "association", mp.ID, "error", err)
+				return
+			}
+			RecordLog(CopyStreamContext(res.Request.Context()), start, res.Body, processer, logId, before, false)
+		}()
+	}
+}
+
+// shadowOf 仅包含单个影子关联的请求配置 不重试 不绑定粘性会话
+func (p ProvidersWithMeta) shadowOf(mp *models.ModelWithProvider) ProvidersWithMeta {
+	shadow := p
+	shadow.ModelWithProviderMap = map[uint]*models.ModelWithProvider{mp.ID: mp}
+	shadow.WeightItems = map[uint]int{mp.ID: 1}
+	shadow.TierItems = map[uint]int{mp.ID: 0}
+	shadow.ShadowProviders = nil
+	shadow.MaxRetry = 1
+	shadow.IOLog = false
+	shadow.StreamFailover = false
+	shadow.StickyTTL = 0
+	shadow.Shadow = true
+	return shadow
+}
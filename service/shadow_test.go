@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+)
+
+func TestShadowChat(t *testing.T) {
+	db := setupChatDB(t)
+	ctx := context.Background()
+
+	var hits sync.Map
+	model := models.Model{Name: "gpt-4o", MaxRetry: 1, TimeOut: 10}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	cooling := time.Now().Add(time.Minute)
+	for _, p := range []struct {
+		name          string
+		shadow        bool
+		cooldownUntil *time.Time
+	}{
+		{"primary", false, nil},
+		{"candidate", true, nil},
+		{"cooling", true, &cooling},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			count, _ := hits.LoadOrStore(p.name, new(atomic.Int32))
+			count.(*atomic.Int32).Add(1)
+			fmt.Fprint(w, `{"choices":[{"message":{"content":"hi"}}],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`)
+		}))
+		t.Cleanup(server.Close)
+		provider := models.Provider{Name: p.name, Type: consts.StyleOpenAI, Config: `{"base_url":"` + server.URL + `","api_key":"sk-test"}`}
+		if err := db.Create(&provider).Error; err != nil {
+			t.Fatal(err)
+		}
+		shadow := p.shadow
+		if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o",
+			Status: &enabled, Weight: 1, Shadow: &shadow, ProviderCooldownUntil: p.cooldownUntil}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	hitCount := func(name string) int32 {
+		count, ok := hits.Load(name)
+		if !ok {
+			return 0
+		}
+		return count.(*atomic.Int32).Load()
+	}
+
+	before, err := BeforerOpenAI([]byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAI, *before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 影子关联不参与正常选择
+	if len(meta.WeightItems) != 1 || len(meta.ModelWithProviderMap) != 1 || len(meta.ShadowProviders) != 2 {
+		t.Fatalf("weight items %v, candidates %d, shadows %d", meta.WeightItems, len(meta.ModelWithProviderMap), len(meta.ShadowProviders))
+	}
+
+	ShadowChat(ctx, consts.StyleOpenAI, *before, *meta, models.ReqMeta{Header: http.Header{}}, ProcesserOpenAI)
+	recordLogs.Wait()
+
+	if hitCount("candidate") != 1 || hitCount("cooling") != 0 || hitCount("primary") != 0 {
+		t.Errorf("hits candidate=%d cooling=%d primary=%d, want 1 0 0", hitCount("candidate"), hitCount("cooling"), hitCount("primary"))
+	}
+	var log models.ChatLog
+	if err := db.Where("provider_name = ?", "candidate").First(&log).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !log.Shadow || log.TotalTokens != 5 || log.Status != "success" {
+		t.Errorf("shadow log = shadow %v tokens %d status %s", log.Shadow, log.TotalTokens, log.Status)
+	}
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+// statsSettleDelay 小时结束后延迟汇总 流式请求的用量在响应结束后才写入日志
+const statsSettleDelay = 10 * time.Minute
+
+// statsHour 时间所在的整点小时 统一使用本地时区以便与日志的 created_at 比较
+func statsHour(t time.Time) time.Time {
+	return t.Local().Truncate(time.Hour)
+}
+
+// statsAggregateSQL 按模型与提供商汇总一个时间范围内的日志
+const statsAggregateSQL = `SELECT name, provider_name, provider_model,
+	COUNT(*) AS reqs,
+	SUM(CASE WHEN status = 'success' THEN 1 ELSE 0 END) AS success,
+	SUM(CASE WHEN cached THEN 1 ELSE 0 END) AS cached,
+	COALESCE(SUM(prompt_tokens), 0) AS prompt_tokens,
+	COALESCE(SUM(completion_tokens), 0) AS completion_tokens,
+	COALESCE(SUM(total_tokens), 0) AS total_tokens,
+	COALESCE(SUM(json_extract(completion_tokens_details, '$.reasoning_tokens')), 0) AS reasoning_tokens,
+	SUM(CASE WHEN status = 'success' THEN proxy_time ELSE 0 END) AS proxy_time,
+	SUM(CASE WHEN status = 'success' THEN first_chunk_time ELSE 0 END) AS first_chunk_time,
+	SUM(CASE WHEN status = 'success' THEN chunk_time ELSE 0 END) AS chunk_time,
+	SUM(CASE WHEN status = 'success' AND first_chunk_time + chunk_time < 1000000000 THEN 1 ELSE 0 END) AS latency_under_1s,
+	SUM(CASE WHEN status = 'success' AND first_chunk_time + chunk_time >= 1000000000 AND first_chunk_time + chunk_time < 5000000000 THEN 1 ELSE 0 END) AS latency_under_5s,
+	SUM(CASE WHEN status = 'success' AND first_chunk_time + chunk_time >= 5000000000 AND first_chunk_time + chunk_time < 30000000000 THEN 1 ELSE 0 END) AS latency_under_30s,
+	SUM(CASE WHEN status = 'success' AND first_chunk_time + chunk_time >= 30000000000 THEN 1 ELSE 0 END) AS latency_over_30s
+FROM chat_logs
+WHERE deleted_at IS NULL AND created_at >= ? AND created_at < ?
+GROUP BY name, provider_name, provider_model`
+
+// RollupStats 将水位之后已结束的整点小时汇总到 StatsHourly 返回汇总的小时数
+// 每个小时先删除再写入 重复执行结果一致 每完成一个小时推进水位 中断后可继续
+func RollupStats(ctx context.Context, now time.Time) (int, error) {
+	rollup, err := LoadConfig[models.StatsRollup](ctx, models.KeyStatsRollup)
+	if err != nil {
+		return 0, err
+	}
+	end := statsHour(now.Add(-statsSettleDelay))
+	var hour time.Time
+	if rollup != nil && !rollup.Watermark.IsZero() {
+		hour = statsHour(rollup.Watermark)
+	} else {
+		// 首次运行从最早的日志开始
+		first, err := gorm.G[models.ChatLog](models.DB).Order("created_at").First(ctx)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, SaveConfig(ctx, models.KeyStatsRollup, models.StatsRollup{Watermark: end})
+		}
+		if err != nil {
+			return 0, err
+		}
+		hour = statsHour(first.CreatedAt)
+	}
+
+	hours := 0
+	for hour.Before(end) {
+		if err := ctx.Err(); err != nil {
+			return hours, err
+		}
+		// 跳过没有日志的时间段
+		next, err := gorm.G[models.ChatLog](models.DB).Where("created_at >= ?", hour).Order("created_at").First(ctx)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return hours, err
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) || !statsHour(next.CreatedAt).Before(end) {
+			hour = end
+			break
+		}
+		hour = statsHour(next.CreatedAt)
+
+		if err := rollupHour(ctx, hour); err != nil {
+			return hours, err
+		}
+		hours++
+		hour = hour.Add(time.Hour)
+		if err := SaveConfig(ctx, models.KeyStatsRollup, models.StatsRollup{Watermark: hour}); err != nil {
+			return hours, err
+		}
+	}
+	return hours, SaveConfig(ctx, models.KeyStatsRollup, models.StatsRollup{Watermark: hour})
+}
+
+// rollupHour 重新汇总指定小时
+func rollupHour(ctx context.Context, hour time.Time) error {
+	return models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var rows []models.StatsHourly
+		if err := tx.Raw(statsAggregateSQL, hour, hour.Add(time.Hour)).Scan(&rows).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("hour = ?", hour).Delete(&models.StatsHourly{}).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		for i := range rows {
+			rows[i].Hour = hour
+		}
+		return tx.CreateInBatches(rows, 100).Error
+	})
+}
+
+// BackfillStats 从指定时间起重新汇总历史日志 from 为零值时从最早的日志开始
+func BackfillStats(ctx context.Context, from time.Time, now time.Time) (int, error) {
+	watermark := time.Time{}
+	query := models.DB.WithContext(ctx)
+	if !from.IsZero() {
+		watermark = statsHour(from)
+		query = query.Where("hour >= ?", watermark)
+	} else {
+		query = query.Where("1 = 1")
+	}
+	if err := query.Delete(&models.StatsHourly{}).Error; err != nil {
+		return 0, err
+	}
+	if err := SaveConfig(ctx, models.KeyStatsRollup, models.StatsRollup{Watermark: watermark}); err != nil {
+		return 0, err
+	}
+	return RollupStats(ctx, now)
+}
+
+// RunStatsRollup 定期汇总统计 直到 ctx 结束
+func RunStatsRollup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if hours, err := RollupStats(ctx, time.Now()); err != nil {
+			slog.Error("rollup stats error", "error", err)
+		} else if hours > 0 {
+			slog.Info("rollup stats", "hours", hours)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// StatsTotals 请求数与用量汇总
+type StatsTotals struct {
+	Reqs            int64
+	Tokens          int64
+	ReasoningTokens int64
+}
+
+// ModelCalls 模型的调用次数
+type ModelCalls struct {
+	Model string
+	Calls int64
+}
+
+// statsSplit 查询 since 之后的统计时 已汇总的整点小时 [from, watermark) 读取 StatsHourly 其余读取 ChatLog
+func statsSplit(ctx context.Context, since time.Time) (rollup *gorm.DB, raw *gorm.DB, err error) {
+	config, err := LoadConfig[models.StatsRollup](ctx, models.KeyStatsRollup)
+	if err != nil {
+		return nil, nil, err
+	}
+	raw = models.DB.WithContext(ctx).Model(&models.ChatLog{})
+	if !since.IsZero() {
+		raw = raw.Where("created_at >= ?", since)
+	}
+	if config == nil || config.Watermark.IsZero() {
+		return nil, raw, nil
+	}
+	watermark := statsHour(config.Watermark)
+	from := since
+	if !from.IsZero() {
+		from = statsHour(since)
+		if from.Before(since) {
+			from = from.Add(time.Hour)
+		}
+	}
+	if !from.Before(watermark) {
+		return nil, raw, nil
+	}
+	rollup = models.DB.WithContext(ctx).Model(&models.StatsHourly{}).Where("hour < ?", watermark)
+	if from.IsZero() {
+		raw = raw.Where("created_at >= ?", watermark)
+	} else {
+		rollup = rollup.Where("hour >= ?", from)
+		raw = raw.Where("created_at < ? OR created_at >= ?", from, watermark)
+	}
+	return rollup, raw, nil
+}
+
+// QueryStatsTotals 汇总 since 之后的请求数与用量
+func QueryStatsTotals(ctx context.Context, since time.Time) (StatsTotals, error) {
+	rollup, raw, err := statsSplit(ctx, since)
+	if err != nil {
+		return StatsTotals{}, err
+	}
+	var totals StatsTotals
+	if err := raw.Select("COUNT(*) AS reqs, COALESCE(SUM(total_tokens), 0) AS tokens, " +
+		"COALESCE(SUM(json_extract(completion_tokens_details, '$.reasoning_tokens')), 0) AS reasoning_tokens").
+		Scan(&totals).Error; err != nil {
+		return StatsTotals{}, err
+	}
+	if rollup != nil {
+		var rolled StatsTotals
+		if err := rollup.Select("COALESCE(SUM(reqs), 0) AS reqs, COALESCE(SUM(total_tokens), 0) AS tokens, " +
+			"COALESCE(SUM(reasoning_tokens), 0) AS reasoning_tokens").
+			Scan(&rolled).Error; err != nil {
+			return StatsTotals{}, err
+		}
+		totals.Reqs += rolled.Reqs
+		totals.Tokens += rolled.Tokens
+		totals.ReasoningTokens += rolled.ReasoningTokens
+	}
+	return totals, nil
+}
+
+// QueryModelCalls 各模型的累计调用次数 按次数降序
+func QueryModelCalls(ctx context.Context) ([]ModelCalls, error) {
+	rollup, raw, err := statsSplit(ctx, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	var calls []ModelCalls
+	if err := raw.Select("name AS model, COUNT(*) AS calls").Group("name").Scan(&calls).Error; err != nil {
+		return nil, err
+	}
+	if rollup != nil {
+		var rolled []ModelCalls
+		if err := rollup.Select("name AS model, SUM(reqs) AS calls").Group("name").Scan(&rolled).Error; err != nil {
+			return nil, err
+		}
+		calls = append(calls, rolled...)
+	}
+	merged := make(map[string]int64, len(calls))
+	for _, c := range calls {
+		merged[c.Model] += c.Calls
+	}
+	result := make([]ModelCalls, 0, len(merged))
+	for model, n := range merged {
+		result = append(result, ModelCalls{Model: model, Calls: n})
+	}
+	slices.SortFunc(result, func(a, b ModelCalls) int {
+		if a.Calls != b.Calls {
+			return cmp.Compare(b.Calls, a.Calls)
+		}
+		return strings.Compare(a.Model, b.Model)
+	})
+	return result, nil
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupStatsDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.ChatLog{}, &models.Config{}, &models.StatsHourly{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	models.DB = db
+	t.Cleanup(func() { models.DB = nil })
+	return db
+}
+
+func TestRollupStats(t *testing.T) {
+	db := setupStatsDB(t)
+	ctx := context.Background()
+	now := time.Date(2026, 10, 17, 12, 5, 0, 0, time.Local)
+	at := func(hour, minute int) time.Time { return time.Date(2026, 10, 17, hour, minute, 0, 0, time.Local) }
+
+	logs := []models.ChatLog{
+		{Name: "gpt-4o", ProviderName: "a", ProviderModel: "gpt-4o", Status: "success", FirstChunkTime: 300 * time.Millisecond, ChunkTime: 200 * time.Millisecond,
+			Usage: models.Usage{TotalTokens: 10, CompletionTokensDetails: models.CompletionTokensDetails{ReasoningTokens: 4}}},
+		{Name: "gpt-4o", ProviderName: "a", ProviderModel: "gpt-4o", Status: "success", FirstChunkTime: time.Second, ChunkTime: 2 * time.Second,
+			Usage: models.Usage{TotalTokens: 20}},
+		{Name: "gpt-4o", ProviderName: "a", ProviderModel: "gpt-4o", Status: "error", FirstChunkTime: time.Minute},
+		{Name: "claude", ProviderName: "b", ProviderModel: "claude", Status: "success", FirstChunkTime: 40 * time.Second, Usage: models.Usage{TotalTokens: 5}},
+		// 11 点前后跨越结算延迟 12 点的日志尚未结算
+		{Name: "gpt-4o", ProviderName: "a", ProviderModel: "gpt-4o", Status: "success", Usage: models.Usage{TotalTokens: 7}},
+		{Name: "claude", ProviderName: "b", ProviderModel: "claude", Status: "success", Usage: models.Usage{TotalTokens: 1}},
+	}
+	times := []time.Time{at(6, 10), at(6, 50), at(6, 59), at(9, 0), at(11, 58), at(12, 1)}
+	for i := range logs {
+		logs[i].CreatedAt = times[i]
+		if err := db.Create(&logs[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hours, err := RollupStats(ctx, now)
+	if err != nil {
+		t.Fatalf("RollupStats() error = %v", err)
+	}
+	if hours != 2 {
+		t.Errorf("RollupStats() hours = %d, want 2 (11:00 is not settled yet)", hours)
+	}
+	var rows []models.StatsHourly
+	db.Order("hour").Find(&rows)
+	if len(rows) != 2 {
+		t.Fatalf("got %d rollup rows, want 2", len(rows))
+	}
+	first := rows[0]
+	if !first.Hour.Equal(at(6, 0)) || first.Reqs != 3 || first.Success != 2 || first.TotalTokens != 30 || first.ReasoningTokens != 4 {
+		t.Errorf("unexpected 06:00 rollup %+v", first)
+	}
+	if first.LatencyUnder1s != 1 || first.LatencyUnder5s != 1 || first.FirstChunkTime != 1300*time.Millisecond {
+		t.Errorf("errors should be excluded from latency, got %+v", first)
+	}
+	if rows[1].LatencyUnder30s != 0 || rows[1].LatencyOver30s != 1 {
+		t.Errorf("unexpected 09:00 latency buckets %+v", rows[1])
+	}
+
+	// 重复执行不会重复汇总
+	if hours, err := RollupStats(ctx, now); err != nil || hours != 0 {
+		t.Errorf("second RollupStats() = %d, %v, want 0", hours, err)
+	}
+	// 结算后继续从水位汇总
+	if hours, err := RollupStats(ctx, now.Add(time.Hour)); err != nil || hours != 1 {
+		t.Errorf("resumed RollupStats() = %d, %v, want 1", hours, err)
+	}
+
+	// 仪表盘查询结合汇总表与水位之后的日志 结果与直接统计日志一致
+	for _, since := range []time.Time{{}, at(6, 30), at(9, 0), at(12, 0)} {
+		totals, err := QueryStatsTotals(ctx, since)
+		if err != nil {
+			t.Fatalf("QueryStatsTotals() error = %v", err)
+		}
+		var want StatsTotals
+		for i, log := range logs {
+			if !times[i].Before(since) {
+				want.Reqs++
+				want.Tokens += log.TotalTokens
+				want.ReasoningTokens += log.CompletionTokensDetails.ReasoningTokens
+			}
+		}
+		if totals != want {
+			t.Errorf("QueryStatsTotals(%v) = %+v, want %+v", since.Format(time.TimeOnly), totals, want)
+		}
+	}
+	calls, err := QueryModelCalls(ctx)
+	if err != nil {
+		t.Fatalf("QueryModelCalls() error = %v", err)
+	}
+	if len(calls) != 2 || calls[0] != (ModelCalls{"gpt-4o", 4}) || calls[1] != (ModelCalls{"claude", 2}) {
+		t.Errorf("QueryModelCalls() = %+v", calls)
+	}
+
+	// 回填重新生成 结果不变
+	if _, err := BackfillStats(ctx, time.Time{}, now.Add(time.Hour)); err != nil {
+		t.Fatalf("BackfillStats() error = %v", err)
+	}
+	var count int64
+	db.Model(&models.StatsHourly{}).Count(&count)
+	if count != 3 {
+		t.Errorf("got %d rollup rows after backfill, want 3", count)
+	}
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// HeaderSessionID 客户端标识会话的请求头 模型开启粘性会话时生效
+const HeaderSessionID = "X-LLMIO-Session-ID"
+
+// stickyMaxSessions 同时保留的粘性会话数量
+const stickyMaxSessions = 10000
+
+// stickySessions 全局粘性会话绑定 仅保存在内存中 重启后重新分配
+var stickySessions = newStickyStore(stickyMaxSessions)
+
+// stickyKey 会话按 auth key 与模型隔离
+func stickyKey(authKeyID, modelID uint, sessionID string) string {
+	return fmt.Sprintf("%d|%d|%s", authKeyID, modelID, sessionID)
+}
+
+type stickyEntry struct {
+	id      uint // 绑定的关联 ID
+	expires time.Time
+}
+
+// stickyStore 会话到关联的绑定 每次成功请求后续期 已满时先清理过期项 再淘汰最早过期的绑定
+type stickyStore struct {
+	mu      sync.Mutex
+	entries map[string]stickyEntry
+	max     int
+	now     func() time.Time
+}
+
+func newStickyStore(max int) *stickyStore {
+	return &stickyStore{entries: make(map[string]stickyEntry), max: max, now: time.Now}
+}
+
+func (s *stickyStore) get(key string) (uint, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return 0, false
+	}
+	if !s.now().Before(entry.expires) {
+		delete(s.entries, key)
+		return 0, false
+	}
+	return entry.id, true
+}
+
+func (s *stickyStore) pin(key string, id uint, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if _, ok := s.entries[key]; !ok && len(s.entries) >= s.max {
+		var oldest string
+		for k, entry := range s.entries {
+			if !now.Before(entry.expires) {
+				delete(s.entries, k)
+				continue
+			}
+			if oldest == "" || entry.expires.Before(s.entries[oldest].expires) {
+				oldest = k
+			}
+		}
+		if len(s.entries) >= s.max {
+			delete(s.entries, oldest)
+		}
+	}
+	s.entries[key] = stickyEntry{id: id, expires: now.Add(ttl)}
+}
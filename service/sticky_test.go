@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+)
+
+func TestStickyStore(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := newStickyStore(2)
+	store.now = func() time.Time { return now }
+
+	store.pin("a", 1, time.Minute)
+	if id, ok := store.get("a"); !ok || id != 1 {
+		t.Fatalf("get(a) = %d, %v, want 1", id, ok)
+	}
+
+	// 续期后按新的过期时间计算
+	now = now.Add(50 * time.Second)
+	store.pin("a", 2, time.Minute)
+	now = now.Add(50 * time.Second)
+	if id, ok := store.get("a"); !ok || id != 2 {
+		t.Fatalf("get(a) after refresh = %d, %v, want 2", id, ok)
+	}
+	now = now.Add(10 * time.Second)
+	if _, ok := store.get("a"); ok {
+		t.Fatal("get(a) returned expired entry")
+	}
+
+	// 已满时淘汰最早过期的绑定
+	store.pin("b", 1, time.Minute)
+	store.pin("c", 1, 2*time.Minute)
+	store.pin("d", 1, time.Minute)
+	if _, ok := store.get("b"); ok {
+		t.Error("b should be evicted")
+	}
+	for _, key := range []string{"c", "d"} {
+		if _, ok := store.get(key); !ok {
+			t.Errorf("%s missing after eviction", key)
+		}
+	}
+}
+
+func TestBalanceChatSticky(t *testing.T) {
+	db := setupChatDB(t)
+	previous := stickySessions
+	stickySessions = newStickyStore(stickyMaxSessions)
+	t.Cleanup(func() { stickySessions = previous })
+
+	var down atomic.Value
+	down.Store("")
+	model := models.Model{Name: "gpt-4o", MaxRetry: 3, TimeOut: 10, StickyTTL: 60}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	for _, name := range []string{"a", "b", "c"} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if down.Load() == name {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			fmt.Fprintf(w, `{"choices":[{"message":{"content":"%s"}}]}`, name)
+		}))
+		t.Cleanup(server.Close)
+		provider := models.Provider{Name: name, Type: consts.StyleOpenAI, Config: `{"base_url":"` + server.URL + `","api_key":"sk-test"}`}
+		if err := db.Create(&provider).Error; err != nil {
+			t.Fatal(err)
+		}
+		mp := models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: &enabled, Weight: 1}
+		if err := db.Create(&mp).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	serve := func(session string) string {
+		t.Helper()
+		before, err := BeforerOpenAI([]byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta, err := ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAI, *before)
+		if err != nil {
+			t.Fatalf("ProvidersWithMetaBymodelsName() error = %v", err)
+		}
+		header := http.Header{}
+		header.Set(HeaderSessionID, session)
+		res, _, err := BalanceChat(ctx, time.Now(), consts.StyleOpenAI, *before, *meta, models.ReqMeta{Header: header})
+		if err != nil {
+			t.Fatalf("BalanceChat() error = %v", err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return string(body)
+	}
+	servedBy := func(body string) string {
+		for _, name := range []string{"a", "b", "c"} {
+			if strings.Contains(body, `"`+name+`"`) {
+				return name
+			}
+		}
+		t.Fatalf("unexpected body %s", body)
+		return ""
+	}
+
+	// 同一会话后续请求固定到首次成功的提供商
+	first := servedBy(serve("s1"))
+	for range 10 {
+		if got := servedBy(serve("s1")); got != first {
+			t.Fatalf("session served by %s, want pinned %s", got, first)
+		}
+	}
+
+	// 绑定的提供商失败后切换并重新绑定 恢复后仍使用新的绑定
+	down.Store(first)
+	failover := servedBy(serve("s1"))
+	if failover == first {
+		t.Fatalf("served by failed provider %s", first)
+	}
+	down.Store("")
+	for range 5 {
+		if got := servedBy(serve("s1")); got != failover {
+			t.Fatalf("session served by %s after failover, want re-pinned %s", got, failover)
+		}
+	}
+
+	var logs []models.ChatLog
+	if err := db.Where("session_id = ? AND status = ?", "s1", "success").Order("id").Find(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 17 {
+		t.Fatalf("success logs = %d, want 17", len(logs))
+	}
+	if logs[0].StickyHit || !logs[1].StickyHit || logs[11].StickyHit || !logs[12].StickyHit {
+		t.Errorf("sticky hits = %v %v %v %v, want false true false true", logs[0].StickyHit, logs[1].StickyHit, logs[11].StickyHit, logs[12].StickyHit)
+	}
+
+	// 过期后重新按权重选择
+	stickySessions.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	serve("s1")
+	var last models.ChatLog
+	if err := db.Where("session_id = ? AND status = ?", "s1", "success").Order("id DESC").First(&last).Error; err != nil {
+		t.Fatal(err)
+	}
+	if last.StickyHit {
+		t.Error("expired session still counted as sticky hit")
+	}
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/atopos31/llmio/consts"
+)
+
+// anthropicErrorTypes Anthropic 协议定义的错误类型 其余类型按 api_error 返回
+var anthropicErrorTypes = map[string]bool{
+	"invalid_request_error": true,
+	"authentication_error":  true,
+	"billing_error":         true,
+	"permission_error":      true,
+	"not_found_error":       true,
+	"request_too_large":     true,
+	"rate_limit_error":      true,
+	"api_error":             true,
+	"timeout_error":         true,
+	"overloaded_error":      true,
+}
+
+// SSE 按接口风格生成错误事件 使 SDK 在流中途出错时得到可解析的错误
+func (e StreamError) SSE(style string) []byte {
+	message := e.Message
+	if message == "" {
+		message = "stream error"
+	}
+	var code any
+	if e.Code != "" {
+		code = e.Code
+	}
+	switch style {
+	case consts.StyleOpenAIRes:
+		data, _ := json.Marshal(map[string]any{
+			"type":    "error",
+			"code":    code,
+			"message": message,
+			"param":   nil,
+		})
+		return sseEvent("error", data)
+	case consts.StyleAnthropic:
+		errType := e.Type
+		if !anthropicErrorTypes[errType] {
+			errType = "api_error"
+		}
+		data, _ := json.Marshal(map[string]any{
+			"type": "error",
+			"error": map[string]any{
+				"type":    errType,
+				"message": message,
+			},
+		})
+		return sseEvent("error", data)
+	default:
+		errType := e.Type
+		if errType == "" {
+			errType = "server_error"
+		}
+		data, _ := json.Marshal(map[string]any{
+			"error": map[string]any{
+				"message": message,
+				"type":    errType,
+				"param":   nil,
+				"code":    code,
+			},
+		})
+		return sseEvent("", data)
+	}
+}
+
+// StreamErrorFrame 将转发中途的错误转换为对应风格的 SSE 错误事件 超时归为 timeout_error
+func StreamErrorFrame(style string, err error) []byte {
+	var streamErr StreamError
+	if !errors.As(err, &streamErr) {
+		streamErr = StreamError{Message: err.Error()}
+		if errors.Is(err, ErrStreamIdleTimeout) || errors.Is(err, ErrFirstChunkTimeout) || errors.Is(err, ErrStreamStalled) {
+			streamErr.Type = "timeout_error"
+		}
+	}
+	return streamErr.SSE(style)
+}
+
+func sseEvent(event string, data []byte) []byte {
+	var frame []byte
+	if event != "" {
+		frame = append(frame, "event: "+event+"\n"...)
+	}
+	frame = append(frame, "data: "...)
+	frame = append(frame, data...)
+	return append(frame, "\n\n"...)
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+)
+
+func TestStreamErrorFrame(t *testing.T) {
+	tests := []struct {
+		name  string
+		style string
+		err   error
+		want  string
+	}{
+		{
+			name:  "openai upstream error",
+			style: consts.StyleOpenAI,
+			err:   StreamError{Message: "quota exceeded", Type: "insufficient_quota", Code: "insufficient_quota"},
+			want:  `data: {"error":{"code":"insufficient_quota","message":"quota exceeded","param":null,"type":"insufficient_quota"}}` + "\n\n",
+		},
+		{
+			name:  "openai read error",
+			style: consts.StyleOpenAI,
+			err:   errors.New("unexpected EOF"),
+			want:  `data: {"error":{"code":null,"message":"unexpected EOF","param":null,"type":"server_error"}}` + "\n\n",
+		},
+		{
+			name:  "completion uses openai format",
+			style: consts.StyleOpenAICompletion,
+			err:   fmt.Errorf("%w after 30s", ErrStreamIdleTimeout),
+			want:  `data: {"error":{"code":null,"message":"stream idle timeout after 30s","param":null,"type":"timeout_error"}}` + "\n\n",
+		},
+		{
+			name:  "responses",
+			style: consts.StyleOpenAIRes,
+			err:   StreamError{Message: "rate limited", Code: "rate_limit_exceeded"},
+			want:  "event: error\n" + `data: {"code":"rate_limit_exceeded","message":"rate limited","param":null,"type":"error"}` + "\n\n",
+		},
+		{
+			name:  "anthropic keeps protocol type",
+			style: consts.StyleAnthropic,
+			err:   StreamError{Message: "Overloaded", Type: "overloaded_error"},
+			want:  "event: error\n" + `data: {"error":{"message":"Overloaded","type":"overloaded_error"},"type":"error"}` + "\n\n",
+		},
+		{
+			name:  "anthropic maps unknown type",
+			style: consts.StyleAnthropic,
+			err:   StreamError{Message: "boom", Type: "server_error"},
+			want:  "event: error\n" + `data: {"error":{"message":"boom","type":"api_error"},"type":"error"}` + "\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := string(StreamErrorFrame(tt.style, tt.err))
+			if frame != tt.want {
+				t.Fatalf("frame = %q, want %q", frame, tt.want)
+			}
+			// 生成的事件能被同风格的流错误检测识别
+			var got error
+			for line := range strings.SplitSeq(frame, "\n") {
+				if _, err := inspectStreamLine(context.Background(), tt.style, line); err != nil {
+					got = err
+				}
+			}
+			var streamErr StreamError
+			if !errors.As(got, &streamErr) || !strings.HasPrefix(tt.err.Error(), streamErr.Message) {
+				t.Errorf("inspectStreamLine() error = %v, want message of %v", got, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/tidwall/gjson"
+)
+
+// PreCommitBufferSize 流式故障转移时首个有效内容前最多缓冲的字节数，超出后直接提交
+const PreCommitBufferSize = 64 * 1024
+
+var errStreamNoContent = errors.New("stream ended before any content")
+
+// preCommitStream 读取流式响应直到出现首个有效内容
+// 期间遇到错误事件或连接中断时返回错误，由调用方切换提供商；提交后已读数据会回放给后续处理
+func preCommitStream(ctx context.Context, res *http.Response, style string, limit int) error {
+	br := bufio.NewReader(res.Body)
+	var buffered, line []byte
+	for {
+		chunk, err := br.ReadSlice('\n')
+		buffered = append(buffered, chunk...)
+		line = append(line, chunk...)
+		if errors.Is(err, bufio.ErrBufferFull) {
+			if len(buffered) >= limit {
+				break
+			}
+			continue
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		committed, inspectErr := inspectStreamLine(ctx, style, strings.TrimSpace(string(line)))
+		if inspectErr != nil {
+			return inspectErr
+		}
+		if committed || len(buffered) >= limit {
+			break
+		}
+		if err != nil {
+			return errStreamNoContent
+		}
+		line = line[:0]
+	}
+
+	res.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buffered), br), res.Body}
+	return nil
+}
+
+// inspectStreamLine 判断 SSE 行是否为有效内容，错误事件返回 StreamError
+func inspectStreamLine(ctx context.Context, style, line string) (bool, error) {
+	switch {
+	case line == "", strings.HasPrefix(line, ":"), strings.HasPrefix(line, "event:"),
+		strings.HasPrefix(line, "id:"), strings.HasPrefix(line, "retry:"):
+		return false, nil
+	case !strings.HasPrefix(line, "data:"):
+		// 非 SSE 格式 不做干预
+		return true, nil
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if data == "[DONE]" {
+		return true, nil
+	}
+	if err := parseStreamError(ctx, data); err != nil {
+		return false, err
+	}
+
+	switch style {
+	case consts.StyleOpenAIRes:
+		switch gjson.Get(data, "type").String() {
+		case "error":
+			streamErr := StreamError{
+				Message: gjson.Get(data, "message").String(),
+				Code:    gjson.Get(data, "code").String(),
+			}
+			streamErr.resolveCategory(ctx)
+			return false, streamErr
+		case "response.failed":
+			if err := parseStreamError(ctx, gjson.Get(data, "response").Raw); err != nil {
+				return false, err
+			}
+			streamErr := StreamError{Message: "response failed"}
+			streamErr.resolveCategory(ctx)
+			return false, streamErr
+		case "response.created", "response.in_progress", "response.queued":
+			return false, nil
+		}
+		return true, nil
+	case consts.StyleAnthropic:
+		switch gjson.Get(data, "type").String() {
+		case "message_start", "ping":
+			return false, nil
+		}
+		return true, nil
+	default:
+		if gjson.Get(data, "usage").Exists() && gjson.Get(data, "usage").Type != gjson.Null {
+			return true, nil
+		}
+		content := false
+		gjson.Get(data, "choices").ForEach(func(_, choice gjson.Result) bool {
+			delta := choice.Get("delta")
+			content = choice.Get("text").String() != "" ||
+				delta.Get("content").String() != "" ||
+				delta.Get("reasoning_content").String() != "" ||
+				delta.Get("tool_calls").Exists() ||
+				choice.Get("finish_reason").String() != ""
+			return !content
+		})
+		return content, nil
+	}
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/service/cooldown"
+)
+
+func TestPreCommitStream(t *testing.T) {
+	tests := []struct {
+		name     string
+		style    string
+		body     string
+		limit    int
+		wantErr  error
+		category cooldown.Category
+	}{
+		{
+			name:  "openai content after keep-alive and role chunk",
+			style: consts.StyleOpenAI,
+			body: ": keep-alive\n\n" +
+				"data: {\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\"}}]}\n\n" +
+				"data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"}}]}\n\n" +
+				"data: [DONE]\n\n",
+		},
+		{
+			name:  "completion text chunk",
+			style: consts.StyleOpenAICompletion,
+			body:  "data: {\"choices\":[{\"index\":0,\"text\":\"def\"}]}\n\n",
+		},
+		{
+			name:     "openai error before content",
+			style:    consts.StyleOpenAI,
+			body:     ": keep-alive\n\ndata: {\"error\":{\"message\":\"overloaded\",\"type\":\"server_error\"}}\n\n",
+			wantErr:  StreamError{},
+			category: cooldown.CategoryProvider,
+		},
+		{
+			name:    "dropped before content",
+			style:   consts.StyleOpenAI,
+			body:    "data: {\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\"}}]}\n\n",
+			wantErr: errStreamNoContent,
+		},
+		{
+			name:  "anthropic content after message_start and ping",
+			style: consts.StyleAnthropic,
+			body: "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{}}\n\n" +
+				"event: ping\ndata: {\"type\":\"ping\"}\n\n" +
+				"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0}\n\n",
+		},
+		{
+			name:     "anthropic overloaded",
+			style:    consts.StyleAnthropic,
+			body:     "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n",
+			wantErr:  StreamError{},
+			category: cooldown.CategoryProvider,
+		},
+		{
+			name:     "responses error event",
+			style:    consts.StyleOpenAIRes,
+			body:     "event: response.created\ndata: {\"type\":\"response.created\"}\n\nevent: error\ndata: {\"type\":\"error\",\"code\":\"rate_limit_exceeded\",\"message\":\"slow down\"}\n\n",
+			wantErr:  StreamError{},
+			category: cooldown.CategoryKey,
+		},
+		{
+			name:  "buffer limit commits without content",
+			style: consts.StyleOpenAI,
+			body:  strings.Repeat(": keep-alive\n\n", 100),
+			limit: 64,
+		},
+		{
+			name:  "non sse body",
+			style: consts.StyleOpenAI,
+			body:  `{"choices":[]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit := tt.limit
+			if limit == 0 {
+				limit = PreCommitBufferSize
+			}
+			res := &http.Response{Body: io.NopCloser(strings.NewReader(tt.body))}
+			err := preCommitStream(context.Background(), res, tt.style, limit)
+			if tt.wantErr != nil {
+				var streamErr StreamError
+				if _, ok := tt.wantErr.(StreamError); ok {
+					if !errors.As(err, &streamErr) {
+						t.Fatalf("expected StreamError, got %v", err)
+					}
+					if streamErr.Category != tt.category {
+						t.Errorf("expected category %v, got %v", tt.category, streamErr.Category)
+					}
+					return
+				}
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// 提交后客户端应收到完整且未改动的响应
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(body) != tt.body {
+				t.Errorf("expected replayed body %q, got %q", tt.body, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+)
+
+// defaultSlowStreamWindow 慢速检测默认的滑动窗口
+const defaultSlowStreamWindow = 5 * time.Second
+
+// ErrSlowStream 流式响应持续速度低于阈值 配置开启冷却时按提供商错误处理
+var ErrSlowStream = errors.New("stream rate below threshold")
+
+type slowStreamContextKey struct{}
+
+// withSlowStream 附加慢速检测配置 未开启检测时原样返回
+func withSlowStream(ctx context.Context, config *models.SlowStream) context.Context {
+	if config == nil || config.MinTps <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, slowStreamContextKey{}, config)
+}
+
+// loadSlowStream 读取慢速检测配置 读取失败时不检查
+func loadSlowStream(ctx context.Context) *models.SlowStream {
+	config, err := LoadConfig[models.SlowStream](ctx, models.KeySlowStream)
+	if err != nil {
+		slog.Error("load slow stream config error", "error", err)
+		return nil
+	}
+	return config
+}
+
+// slowStreamCooldown 慢速流是否触发冷却
+func slowStreamCooldown(ctx context.Context) bool {
+	config, ok := ctx.Value(slowStreamContextKey{}).(*models.SlowStream)
+	return ok && config.Cooldown
+}
+
+// rateSample 单个流式事件到达的时间与估算的 token 数
+type rateSample struct {
+	at     time.Time
+	tokens int
+}
+
+// streamRate 按流式事件的到达时间计算滑动窗口内的输出速度 记录整个流中的最低值
+type streamRate struct {
+	window  time.Duration
+	minTps  float64 // 阈值
+	now     func() time.Time
+	first   time.Time
+	samples []rateSample
+	tokens  int // 窗口内的 token 数
+	lowest  float64
+	full    bool // 是否已观察到完整的窗口
+}
+
+// newStreamRate 按上下文中的配置创建速度统计 未开启检测时返回 nil
+func newStreamRate(ctx context.Context) *streamRate {
+	config, ok := ctx.Value(slowStreamContextKey{}).(*models.SlowStream)
+	if !ok {
+		return nil
+	}
+	window := defaultSlowStreamWindow
+	if config.Window > 0 {
+		window = time.Duration(config.Window) * time.Millisecond
+	}
+	return &streamRate{window: window, minTps: config.MinTps, now: time.Now, lowest: math.Inf(1)}
+}
+
+// observe 记录一个流式事件 token 数按事件中的输出文本估算
+func (r *streamRate) observe(data string) {
+	if r == nil {
+		return
+	}
+	r.add(r.now(), estimateTextTokens(streamDeltaText(data)))
+}
+
+func (r *streamRate) add(at time.Time, tokens int) {
+	if r.first.IsZero() {
+		r.first = at
+	}
+	r.samples = append(r.samples, rateSample{at: at, tokens: tokens})
+	r.tokens += tokens
+	start := at.Add(-r.window)
+	drop := 0
+	for drop < len(r.samples) && !r.samples[drop].at.After(start) {
+		r.tokens -= r.samples[drop].tokens
+		drop++
+	}
+	r.samples = r.samples[drop:]
+	// 自首个事件起不足一个窗口时速度尚不可信
+	if at.Sub(r.first) < r.window {
+		return
+	}
+	r.full = true
+	r.lowest = min(r.lowest, float64(r.tokens)/r.window.Seconds())
+}
+
+// lowestTps 整个流中滑动窗口速度的最低值 未观察到完整的窗口时返回 0
+func (r *streamRate) lowestTps() float64 {
+	if r == nil || !r.full {
+		return 0
+	}
+	scale := math.Pow10(tpsPrecision)
+	return math.Round(r.lowest*scale) / scale
+}
+
+// slow 是否存在速度低于阈值的完整窗口
+func (r *streamRate) slow() bool {
+	return r != nil && r.full && r.lowest < r.minTps
+}
+
+// streamDeltaText 提取流式事件中的输出文本 兼容 OpenAI、OpenAI Responses 与 Anthropic 格式
+func streamDeltaText(data string) string {
+	var sb strings.Builder
+	for _, path := range []string{"choices.#.delta.content", "choices.#.delta.reasoning_content", "choices.#.text"} {
+		for _, v := range gjson.Get(data, path).Array() {
+			sb.WriteString(v.String())
+		}
+	}
+	for _, path := range []string{"delta.text", "delta.thinking"} {
+		sb.WriteString(gjson.Get(data, path).String())
+	}
+	if delta := gjson.Get(data, "delta"); delta.Type == gjson.String && strings.HasSuffix(gjson.Get(data, "type").String(), ".delta") {
+		sb.WriteString(delta.String())
+	}
+	return sb.String()
+}
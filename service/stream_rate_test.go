@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+)
+
+func TestStreamRate(t *testing.T) {
+	type sample struct {
+		at     time.Duration // 距首个事件的时间
+		tokens int
+	}
+	// steady 每 100ms 到达一个事件
+	steady := func(n, tokens int) []sample {
+		samples := make([]sample, n)
+		for i := range samples {
+			samples[i] = sample{time.Duration(i) * 100 * time.Millisecond, tokens}
+		}
+		return samples
+	}
+	tests := []struct {
+		name       string
+		samples    []sample
+		wantLowest float64
+		wantSlow   bool
+	}{
+		{"fast stream", steady(20, 10), 100, false},
+		{"slow stream", steady(20, 1), 10, true},
+		{"shorter than window", steady(5, 1), 0, false},
+		{
+			name:       "stall in the middle",
+			samples:    append(steady(11, 10), sample{3 * time.Second, 10}, sample{3100 * time.Millisecond, 10}),
+			wantLowest: 10,
+			wantSlow:   true,
+		},
+	}
+	start := time.Now()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate := &streamRate{window: time.Second, minTps: 20, lowest: math.Inf(1)}
+			for _, s := range tt.samples {
+				rate.add(start.Add(s.at), s.tokens)
+			}
+			if got := rate.lowestTps(); got != tt.wantLowest {
+				t.Errorf("lowestTps() = %v, want %v", got, tt.wantLowest)
+			}
+			if got := rate.slow(); got != tt.wantSlow {
+				t.Errorf("slow() = %v, want %v", got, tt.wantSlow)
+			}
+		})
+	}
+
+	// 未开启检测时不统计
+	var disabled *streamRate
+	disabled.observe(`{"choices":[{"delta":{"content":"hi"}}]}`)
+	if newStreamRate(context.Background()) != nil || disabled.slow() || disabled.lowestTps() != 0 {
+		t.Error("disabled stream rate should not report")
+	}
+}
+
+func TestStreamDeltaText(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{`{"choices":[{"delta":{"content":"hello"}}]}`, "hello"},
+		{`{"choices":[{"delta":{"reasoning_content":"think"}}]}`, "think"},
+		{`{"choices":[{"text":"fim"}]}`, "fim"},
+		{`{"type":"content_block_delta","delta":{"type":"text_delta","text":"claude"}}`, "claude"},
+		{`{"type":"content_block_delta","delta":{"type":"thinking_delta","thinking":"hmm"}}`, "hmm"},
+		{`{"type":"response.output_text.delta","delta":"res"}`, "res"},
+		{`{"type":"message_start","message":{}}`, ""},
+	}
+	for _, tt := range tests {
+		if got := streamDeltaText(tt.data); got != tt.want {
+			t.Errorf("streamDeltaText(%s) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestProcesserSlowStream(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string // 每个事件的输出文本
+		wantSlow bool
+	}{
+		{"slow transcript", "hi", true},
+		{"fast transcript", strings.Repeat("word ", 100), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr, pw := io.Pipe()
+			go func() {
+				for range 8 {
+					fmt.Fprintf(pw, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", tt.content)
+					time.Sleep(40 * time.Millisecond)
+				}
+				fmt.Fprint(pw, "data: [DONE]\n\n")
+				pw.Close()
+			}()
+			ctx := withSlowStream(context.Background(), &models.SlowStream{MinTps: 50, Window: 100})
+			log, _, err := ProcesserOpenAI(ctx, pr, true, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if log.SlowStream != tt.wantSlow {
+				t.Errorf("SlowStream = %v, want %v (min tps %v)", log.SlowStream, tt.wantSlow, log.MinTps)
+			}
+			if log.MinTps <= 0 {
+				t.Errorf("MinTps = %v, want measured rate", log.MinTps)
+			}
+		})
+	}
+}
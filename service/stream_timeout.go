@@ -0,0 +1,200 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+// ErrStreamIdleTimeout 流式响应在空闲超时时间内未收到任何数据
+var ErrStreamIdleTimeout = errors.New("stream idle timeout")
+
+// ErrFirstChunkTimeout 流式响应在首包超时时间内未收到有效内容 心跳不计入
+var ErrFirstChunkTimeout = errors.New("stream first chunk timeout")
+
+// ErrStreamStalled 流式响应开始输出后 超过间隔上限未收到新的有效内容 心跳不计入
+var ErrStreamStalled = errors.New("stream stalled")
+
+// StatusTruncated 流式响应因超时被截断并已向客户端补发结束事件
+const StatusTruncated = "truncated"
+
+// idleTimeoutBody 超过空闲时间未读到数据时关闭上游连接，后续读取返回 ErrStreamIdleTimeout
+type idleTimeoutBody struct {
+	body     io.ReadCloser
+	timeout  time.Duration
+	timer    *time.Timer
+	timedOut atomic.Bool
+	stopOnce sync.Once
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration) *idleTimeoutBody {
+	b := &idleTimeoutBody{body: body, timeout: timeout}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.timedOut.Store(true)
+		b.body.Close()
+	})
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if b.timedOut.Load() {
+		return n, ErrStreamIdleTimeout
+	}
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	if err != nil {
+		b.stop()
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.stop()
+	return b.body.Close()
+}
+
+func (b *idleTimeoutBody) stop() {
+	b.stopOnce.Do(func() {
+		b.timer.Stop()
+	})
+}
+
+// stallPingLimit 长度不超过该值的 data 行才可能是心跳事件 更长的行直接视为有效内容
+const stallPingLimit = 64
+
+// stallBody 检测流式响应中两个有效内容之间的间隔 首个有效内容后开始计时
+// 每读到完整的 data 行重新计时 注释与 ping 事件不计入 超时关闭上游连接 后续读取返回 ErrStreamStalled
+type stallBody struct {
+	body     io.ReadCloser
+	gap      time.Duration
+	timer    *time.Timer
+	stalled  atomic.Bool
+	stopOnce sync.Once
+	line     []byte // 当前行的开头部分 最多保留 stallPingLimit 字节
+	lineLen  int
+}
+
+func newStallBody(body io.ReadCloser, gap time.Duration) *stallBody {
+	return &stallBody{body: body, gap: gap}
+}
+
+func (b *stallBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if b.stalled.Load() {
+		return n, fmt.Errorf("%w: no chunk for %s", ErrStreamStalled, b.gap)
+	}
+	for _, c := range p[:n] {
+		if c != '\n' {
+			if len(b.line) < stallPingLimit {
+				b.line = append(b.line, c)
+			}
+			b.lineLen++
+			continue
+		}
+		if isStallContent(b.line, b.lineLen) {
+			b.reset()
+		}
+		b.line = b.line[:0]
+		b.lineLen = 0
+	}
+	if err != nil {
+		b.stop()
+	}
+	return n, err
+}
+
+// isStallContent 判断完整的一行是否为有效内容
+func isStallContent(line []byte, length int) bool {
+	data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+	if !ok {
+		return false
+	}
+	if length > stallPingLimit {
+		return true
+	}
+	data = bytes.TrimSpace(data)
+	return len(data) > 0 && gjson.GetBytes(data, "type").String() != "ping"
+}
+
+// reset 重新计时 首次调用时开始计时
+func (b *stallBody) reset() {
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.gap, func() {
+			b.stalled.Store(true)
+			b.body.Close()
+		})
+		return
+	}
+	b.timer.Reset(b.gap)
+}
+
+func (b *stallBody) Close() error {
+	b.stop()
+	return b.body.Close()
+}
+
+func (b *stallBody) stop() {
+	b.stopOnce.Do(func() {
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+	})
+}
+
+// preCommitStreamWithin 在 timeout 内等待首个有效内容 超时关闭上游连接并返回 ErrFirstChunkTimeout
+func preCommitStreamWithin(ctx context.Context, res *http.Response, style string, limit int, timeout time.Duration) error {
+	if timeout <= 0 {
+		return preCommitStream(ctx, res, style, limit)
+	}
+	body := res.Body
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		body.Close()
+	})
+	err := preCommitStream(ctx, res, style, limit)
+	// 计时器已触发时连接已被关闭 即便刚读到内容也无法继续
+	if !timer.Stop() && timedOut.Load() {
+		return ErrFirstChunkTimeout
+	}
+	return err
+}
+
+// StreamTerminator 按接口风格生成结束事件，使客户端 SSE 解析器正常结束
+func StreamTerminator(style string) []byte {
+	switch style {
+	case consts.StyleOpenAIRes:
+		return []byte("event: response.incomplete\n" +
+			`data: {"type":"response.incomplete","response":{"status":"incomplete","incomplete_details":{"reason":"max_output_tokens"}}}` + "\n\n")
+	case consts.StyleAnthropic:
+		return []byte("event: message_delta\n" +
+			`data: {"type":"message_delta","delta":{"stop_reason":"max_tokens","stop_sequence":null},"usage":{"output_tokens":0}}` + "\n\n" +
+			"event: message_stop\n" +
+			`data: {"type":"message_stop"}` + "\n\n")
+	default:
+		return []byte(`data: {"object":"chat.completion.chunk","choices":[{"index":0,"delta":{},"finish_reason":"length"}]}` + "\n\n" +
+			"data: [DONE]\n\n")
+	}
+}
+
+// MarkTruncated 记录流式响应被截断
+func MarkTruncated(ctx context.Context, logId uint, cause error) error {
+	_, err := gorm.G[models.ChatLog](models.DB).Where("id = ?", logId).Updates(ctx, models.ChatLog{
+		Status: StatusTruncated,
+		Error:  cause.Error(),
+	})
+	return err
+}
@@ -0,0 +1,300 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+)
+
+func TestIdleTimeoutBodyMidStream(t *testing.T) {
+	pr, pw := io.Pipe()
+	first := "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hel\"}}]}\n\n"
+	go func() {
+		pw.Write([]byte(first))
+		// 上游在输出部分内容后停止响应
+	}()
+
+	body := newIdleTimeoutBody(pr, 50*time.Millisecond)
+	defer body.Close()
+
+	start := time.Now()
+	received, err := io.ReadAll(body)
+	if !errors.Is(err, ErrStreamIdleTimeout) {
+		t.Fatalf("expected idle timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected timeout shortly after 50ms, took %v", elapsed)
+	}
+	if string(received) != first {
+		t.Errorf("expected partial content to be kept, got %q", received)
+	}
+}
+
+func TestIdleTimeoutBodyActivityResets(t *testing.T) {
+	pr, pw := io.Pipe()
+	go func() {
+		for i := 0; i < 5; i++ {
+			time.Sleep(20 * time.Millisecond)
+			pw.Write([]byte("data: {}\n\n"))
+		}
+		pw.Close()
+	}()
+
+	body := newIdleTimeoutBody(pr, 50*time.Millisecond)
+	defer body.Close()
+	received, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Count(string(received), "data: ") != 5 {
+		t.Errorf("expected 5 events, got %q", received)
+	}
+}
+
+func TestStreamTerminator(t *testing.T) {
+	tests := []struct {
+		style     string
+		partial   string
+		processer Processer
+		last      string
+	}{
+		{
+			style:     consts.StyleOpenAI,
+			partial:   "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hel\"}}]}\n\n",
+			processer: ProcesserOpenAI,
+			last:      `"finish_reason":"length"`,
+		},
+		{
+			style:     consts.StyleOpenAIRes,
+			partial:   "event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"delta\":\"Hel\"}\n\n",
+			processer: ProcesserOpenAiRes,
+			last:      `"type":"response.incomplete"`,
+		},
+		{
+			style:     consts.StyleAnthropic,
+			partial:   "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hel\"}}\n\n",
+			processer: ProcesserAnthropic,
+			last:      `"type":"message_stop"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.style, func(t *testing.T) {
+			var stream bytes.Buffer
+			stream.WriteString(tt.partial)
+			stream.Write(StreamTerminator(tt.style))
+
+			_, output, err := tt.processer(context.Background(), &stream, true, time.Now())
+			if err != nil {
+				t.Fatalf("expected terminated stream to parse cleanly, got %v", err)
+			}
+			chunks := output.OfStringArray
+			if len(chunks) == 0 || !strings.Contains(chunks[len(chunks)-1], tt.last) {
+				t.Errorf("expected final event containing %s, got %v", tt.last, chunks)
+			}
+		})
+	}
+}
+
+func TestPreCommitStreamWithinKeepAlive(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go func() {
+		// 上游返回响应头后只发送心跳
+		for range 10 {
+			if _, err := pw.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+
+	res := &http.Response{Body: pr}
+	start := time.Now()
+	err := preCommitStreamWithin(context.Background(), res, consts.StyleOpenAI, PreCommitBufferSize, 50*time.Millisecond)
+	if !errors.Is(err, ErrFirstChunkTimeout) {
+		t.Fatalf("expected first chunk timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected timeout shortly after 50ms, took %v", elapsed)
+	}
+}
+
+func TestBalanceChatFirstChunkTimeout(t *testing.T) {
+	db := setupChatDB(t)
+
+	stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(stalled.Close)
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"ok\"}}]}\n\ndata: [DONE]\n\n")
+	}))
+	t.Cleanup(healthy.Close)
+
+	model := models.Model{Name: "gpt-4o", MaxRetry: 3, TimeOut: 30, FirstChunkTimeout: 1}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	associations := make(map[string]uint)
+	for i, upstream := range []struct {
+		name string
+		url  string
+	}{{"stalled", stalled.URL}, {"healthy", healthy.URL}} {
+		provider := models.Provider{Name: upstream.name, Type: consts.StyleOpenAI, Config: `{"base_url":"` + upstream.url + `","api_key":"sk-test"}`}
+		if err := db.Create(&provider).Error; err != nil {
+			t.Fatal(err)
+		}
+		mp := models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt-4o", Status: &enabled, Weight: 1, Tier: i}
+		if err := db.Create(&mp).Error; err != nil {
+			t.Fatal(err)
+		}
+		associations[upstream.name] = mp.ID
+	}
+
+	ctx := context.Background()
+	before, err := BeforerOpenAI([]byte(`{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAI, *before)
+	if err != nil {
+		t.Fatalf("ProvidersWithMetaBymodelsName() error = %v", err)
+	}
+	res, _, err := BalanceChat(ctx, time.Now(), consts.StyleOpenAI, *before, *meta, models.ReqMeta{Header: http.Header{}})
+	if err != nil {
+		t.Fatalf("BalanceChat() error = %v", err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), `"content":"ok"`) {
+		t.Errorf("expected failover to healthy provider, got %q", body)
+	}
+
+	// 首包超时按提供商错误处理 进入冷却
+	var mp models.ModelWithProvider
+	if err := db.First(&mp, associations["stalled"]).Error; err != nil {
+		t.Fatal(err)
+	}
+	if mp.ProviderCooldownUntil == nil || !mp.ProviderCooldownUntil.After(time.Now()) {
+		t.Errorf("expected stalled provider in cooldown, got %v", mp.ProviderCooldownUntil)
+	}
+}
+
+func TestStallBody(t *testing.T) {
+	first := "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hel\"}}]}\n\n"
+	tests := []struct {
+		name      string
+		chunks    []string // 每隔 20ms 写入一段
+		wantStall bool
+	}{
+		{"content resets", []string{first, first, first, first, first}, false},
+		{"heartbeats do not count", []string{first, ": keep-alive\n\n", "event: ping\ndata: {\"type\": \"ping\"}\n\n", ": keep-alive\n\n", ": keep-alive\n\n"}, true},
+		{"split lines count once complete", []string{first, "data: {\"choices\"", ":[]}\n\n", "data: {\"a\":1}\n", "\n"}, false},
+		{"no timer before first content", []string{": keep-alive\n\n", ": keep-alive\n\n", ": keep-alive\n\n", ": keep-alive\n\n", first}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr, pw := io.Pipe()
+			go func() {
+				for _, chunk := range tt.chunks {
+					time.Sleep(20 * time.Millisecond)
+					pw.Write([]byte(chunk))
+				}
+				pw.Close()
+			}()
+			body := newStallBody(pr, 50*time.Millisecond)
+			defer body.Close()
+			_, err := io.ReadAll(body)
+			if stalled := errors.Is(err, ErrStreamStalled); stalled != tt.wantStall {
+				t.Fatalf("stalled = %v, want %v (err %v)", stalled, tt.wantStall, err)
+			}
+		})
+	}
+}
+
+func TestBalanceChatStreamStall(t *testing.T) {
+	db := setupChatDB(t)
+	ctx := context.Background()
+
+	// 上游输出一段内容后只发送心跳
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+				w.(http.Flusher).Flush()
+			}
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	model := models.Model{Name: "gpt", MaxRetry: 1, TimeOut: 10}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	provider := models.Provider{Name: "stall", Type: consts.StyleOpenAI, Config: `{"base_url":"` + upstream.URL + `","api_key":"sk-test"}`}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt", Status: &enabled, Weight: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := BeforerOpenAI([]byte(`{"model":"gpt","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAI, *before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.StreamStallTimeout = 100 * time.Millisecond
+	start := time.Now()
+	res, logID, err := BalanceChat(ctx, start, consts.StyleOpenAI, *before, *meta, models.ReqMeta{Header: http.Header{}})
+	if err != nil {
+		t.Fatalf("BalanceChat() error = %v", err)
+	}
+	RecordLog(CopyStreamContext(res.Request.Context()), start, res.Body, ProcesserOpenAI, logID, *before, false)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("stall detected after %v, want shortly after 100ms", elapsed)
+	}
+
+	var log models.ChatLog
+	if err := db.First(&log, logID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if log.Status != "error" || !log.Stalled || !strings.Contains(log.Error, "stream stalled") {
+		t.Errorf("log = status %q stalled %v error %q, want stalled error", log.Status, log.Stalled, log.Error)
+	}
+	// 停滞计为提供商错误 关联进入冷却
+	var mp models.ModelWithProvider
+	if err := db.First(&mp).Error; err != nil {
+		t.Fatal(err)
+	}
+	if mp.ProviderCooldownStep != 1 || mp.ProviderCooldownUntil == nil {
+		t.Errorf("provider cooldown step = %d until %v, want provider cooldown", mp.ProviderCooldownStep, mp.ProviderCooldownUntil)
+	}
+}
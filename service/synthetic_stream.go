@@ -0,0 +1,109 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// nonStreamBody 将流式请求体改写为非流式请求，用于不支持流式的上游
+func nonStreamBody(raw []byte) ([]byte, error) {
+	body, err := sjson.SetBytes(raw, "stream", false)
+	if err != nil {
+		return nil, err
+	}
+	return sjson.DeleteBytes(body, "stream_options")
+}
+
+// SyntheticOpenAIStream 将非流式 chat.completion 响应转为 chat.completion.chunk 形式的 SSE 流
+// 依次输出内容块、usage 块，并以 [DONE] 结尾
+func SyntheticOpenAIStream(body []byte) ([]byte, error) {
+	// 压缩为单行，避免上游格式化的 JSON 破坏 SSE 分帧
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, body); err != nil {
+		return nil, fmt.Errorf("invalid non-stream response body: %w", err)
+	}
+	completion := gjson.ParseBytes(compacted.Bytes())
+
+	chunk := []byte(`{"object":"chat.completion.chunk","choices":[]}`)
+	var err error
+	for _, field := range []string{"id", "created", "model", "system_fingerprint"} {
+		if v := completion.Get(field); v.Exists() {
+			if chunk, err = sjson.SetRawBytes(chunk, field, []byte(v.Raw)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	usageChunk := chunk
+
+	for i, choice := range completion.Get("choices").Array() {
+		delta := []byte(choice.Get("message").Raw)
+		if len(delta) == 0 {
+			delta = []byte(`{}`)
+		}
+		// 流式 tool_calls 需要带 index
+		for j := range choice.Get("message.tool_calls").Array() {
+			if delta, err = sjson.SetBytes(delta, fmt.Sprintf("tool_calls.%d.index", j), j); err != nil {
+				return nil, err
+			}
+		}
+		streamChoice := []byte(`{}`)
+		if streamChoice, err = sjson.SetBytes(streamChoice, "index", choice.Get("index").Int()); err != nil {
+			return nil, err
+		}
+		if streamChoice, err = sjson.SetRawBytes(streamChoice, "delta", delta); err != nil {
+			return nil, err
+		}
+		finishReason := choice.Get("finish_reason").Raw
+		if finishReason == "" {
+			finishReason = "null"
+		}
+		if streamChoice, err = sjson.SetRawBytes(streamChoice, "finish_reason", []byte(finishReason)); err != nil {
+			return nil, err
+		}
+		if chunk, err = sjson.SetRawBytes(chunk, fmt.Sprintf("choices.%d", i), streamChoice); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	writeSSEData(&buf, chunk)
+	if usage := completion.Get("usage"); usage.Exists() {
+		if usageChunk, err = sjson.SetRawBytes(usageChunk, "usage", []byte(usage.Raw)); err != nil {
+			return nil, err
+		}
+		writeSSEData(&buf, usageChunk)
+	}
+	writeSSEData(&buf, []byte("[DONE]"))
+	return buf.Bytes(), nil
+}
+
+func writeSSEData(buf *bytes.Buffer, data []byte) {
+	buf.WriteString("data: ")
+	buf.Write(data)
+	buf.WriteString("\n\n")
+}
+
+// toSyntheticStream 读取完整的非流式响应并替换为合成的 SSE 响应体
+func toSyntheticStream(res *http.Response) error {
+	// 读取失败时由调用方丢弃响应体
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	stream, err := SyntheticOpenAIStream(body)
+	if err != nil {
+		return err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(stream))
+	res.ContentLength = -1
+	res.Header.Del("Content-Length")
+	res.Header.Set("Content-Type", "text/event-stream")
+	return nil
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const bufferedCompletion = `{
+	"id": "chatcmpl-1",
+	"object": "chat.completion",
+	"created": 1700000000,
+	"model": "gpt-4.1",
+	"choices": [{
+		"index": 0,
+		"message": {
+			"role": "assistant",
+			"content": "Hello there",
+			"tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{}"}}]
+		},
+		"finish_reason": "tool_calls"
+	}],
+	"usage": {"prompt_tokens": 11, "completion_tokens": 7, "total_tokens": 18}
+}`
+
+func TestSyntheticOpenAIStream(t *testing.T) {
+	stream, err := SyntheticOpenAIStream([]byte(bufferedCompletion))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	events := strings.Split(strings.TrimSuffix(string(stream), "\n\n"), "\n\n")
+	if len(events) != 3 {
+		t.Fatalf("expected 3 SSE events, got %d: %q", len(events), stream)
+	}
+	for _, event := range events {
+		if !strings.HasPrefix(event, "data: ") {
+			t.Fatalf("event without data prefix: %q", event)
+		}
+	}
+	if events[2] != "data: [DONE]" {
+		t.Errorf("expected stream to end with [DONE], got %q", events[2])
+	}
+
+	chunk := gjson.Parse(strings.TrimPrefix(events[0], "data: "))
+	if chunk.Get("object").String() != "chat.completion.chunk" {
+		t.Errorf("unexpected object: %s", chunk.Get("object").String())
+	}
+	if chunk.Get("id").String() != "chatcmpl-1" {
+		t.Errorf("unexpected id: %s", chunk.Get("id").String())
+	}
+	if chunk.Get("choices.0.delta.content").String() != "Hello there" {
+		t.Errorf("unexpected delta content: %s", chunk.Get("choices.0.delta.content").String())
+	}
+	if !chunk.Get("choices.0.delta.tool_calls.0.index").Exists() {
+		t.Error("expected tool call index in delta")
+	}
+	if chunk.Get("choices.0.finish_reason").String() != "tool_calls" {
+		t.Errorf("unexpected finish reason: %s", chunk.Get("choices.0.finish_reason").String())
+	}
+
+	usage := gjson.Parse(strings.TrimPrefix(events[1], "data: "))
+	if usage.Get("usage.total_tokens").Int() != 18 {
+		t.Errorf("expected usage chunk with total_tokens 18, got %s", usage.Raw)
+	}
+}
+
+func TestToSyntheticStreamUsage(t *testing.T) {
+	res := &http.Response{
+		Header:        http.Header{"Content-Type": {"application/json"}, "Content-Length": {"512"}},
+		Body:          io.NopCloser(bytes.NewReader([]byte(bufferedCompletion))),
+		ContentLength: 512,
+	}
+	if err := toSyntheticStream(res); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Header.Get("Content-Length") != "" || res.ContentLength != -1 {
+		t.Error("expected content length to be cleared")
+	}
+	if res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("unexpected content type: %s", res.Header.Get("Content-Type"))
+	}
+
+	log, output, err := ProcesserOpenAI(context.Background(), res.Body, true, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if log.PromptTokens != 11 || log.CompletionTokens != 7 || log.TotalTokens != 18 {
+		t.Errorf("unexpected usage: %+v", log.Usage)
+	}
+	if len(output.OfStringArray) != 2 {
+		t.Errorf("expected 2 data chunks, got %d", len(output.OfStringArray))
+	}
+}
+
+func TestNonStreamBody(t *testing.T) {
+	body, err := nonStreamBody([]byte(`{"model":"m","stream":true,"stream_options":{"include_usage":true}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gjson.GetBytes(body, "stream").Bool() {
+		t.Error("expected stream to be false")
+	}
+	if gjson.GetBytes(body, "stream_options").Exists() {
+		t.Error("expected stream_options to be removed")
+	}
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// reasoningEffortBudgets OpenAI reasoning_effort 与 Anthropic thinking.budget_tokens 的近似对应 按预算升序
+// Anthropic 要求 budget_tokens 不小于 1024
+var reasoningEffortBudgets = []struct {
+	effort string
+	budget int64
+}{
+	{"minimal", 1024},
+	{"low", 2048},
+	{"medium", 8192},
+	{"high", 16384},
+}
+
+// ThinkingBudgetForEffort 将推理强度折算为思考预算 未知的强度返回 false
+func ThinkingBudgetForEffort(effort string) (int64, bool) {
+	effort = strings.ToLower(strings.TrimSpace(effort))
+	for _, item := range reasoningEffortBudgets {
+		if item.effort == effort {
+			return item.budget, true
+		}
+	}
+	return 0, false
+}
+
+// ReasoningEffortForBudget 将思考预算折算为不低于该预算的最小推理强度 超出时为 high
+func ReasoningEffortForBudget(budget int64) string {
+	for _, item := range reasoningEffortBudgets {
+		if budget <= item.budget {
+			return item.effort
+		}
+	}
+	return reasoningEffortBudgets[len(reasoningEffortBudgets)-1].effort
+}
+
+// requestThinkingBudget 提取请求的思考预算 Anthropic 使用 thinking 配置 OpenAI 按推理强度折算
+func requestThinkingBudget(data []byte, effortPath string) int64 {
+	if effortPath == "" {
+		thinking := gjson.GetBytes(data, "thinking")
+		if thinking.Get("type").String() != "enabled" {
+			return 0
+		}
+		return thinking.Get("budget_tokens").Int()
+	}
+	budget, _ := ThinkingBudgetForEffort(gjson.GetBytes(data, effortPath).String())
+	return budget
+}
+
+// anthropicThinkingText 收集 Anthropic 响应中的思考内容 兼容非流式响应体与流式事件
+func anthropicThinkingText(b *strings.Builder, data string) {
+	if delta := gjson.Get(data, "delta"); delta.Get("type").String() == "thinking_delta" {
+		b.WriteString(delta.Get("thinking").String())
+		return
+	}
+	gjson.Get(data, "content").ForEach(func(_, block gjson.Result) bool {
+		if block.Get("type").String() == "thinking" {
+			b.WriteString(block.Get("thinking").String())
+		}
+		return true
+	})
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestThinkingBudgetMapping(t *testing.T) {
+	for _, tt := range []struct {
+		effort string
+		budget int64
+	}{
+		{"minimal", 1024},
+		{"low", 2048},
+		{"Medium", 8192},
+		{"high", 16384},
+	} {
+		budget, ok := ThinkingBudgetForEffort(tt.effort)
+		if !ok || budget != tt.budget {
+			t.Errorf("ThinkingBudgetForEffort(%q) = %d, %v, want %d", tt.effort, budget, ok, tt.budget)
+		}
+		// 折算后再折回得到相同强度
+		if got, want := ReasoningEffortForBudget(budget), strings.ToLower(tt.effort); got != want {
+			t.Errorf("ReasoningEffortForBudget(%d) = %q, want %q", budget, got, want)
+		}
+	}
+	if _, ok := ThinkingBudgetForEffort("extreme"); ok {
+		t.Error("unknown effort accepted")
+	}
+
+	for _, tt := range []struct {
+		budget int64
+		effort string
+	}{
+		{0, "minimal"},
+		{1500, "low"},
+		{2048, "low"},
+		{4096, "medium"},
+		{16384, "high"},
+		{64000, "high"},
+	} {
+		if got := ReasoningEffortForBudget(tt.budget); got != tt.effort {
+			t.Errorf("ReasoningEffortForBudget(%d) = %q, want %q", tt.budget, got, tt.effort)
+		}
+	}
+}
+
+func TestBeforeThinkingBudget(t *testing.T) {
+	tests := []struct {
+		name    string
+		beforer Beforer
+		body    string
+		want    int64
+	}{
+		{"anthropic enabled", BeforerAnthropic, `{"model":"m","messages":[],"max_tokens":16000,"thinking":{"type":"enabled","budget_tokens":10000}}`, 10000},
+		{"anthropic disabled", BeforerAnthropic, `{"model":"m","messages":[],"thinking":{"type":"disabled"}}`, 0},
+		{"anthropic absent", BeforerAnthropic, `{"model":"m","messages":[]}`, 0},
+		{"openai effort", BeforerOpenAI, `{"model":"m","messages":[],"reasoning_effort":"medium"}`, 8192},
+		{"openai unknown effort", BeforerOpenAI, `{"model":"m","messages":[],"reasoning_effort":"max"}`, 0},
+		{"responses effort", BeforerOpenAIRes, `{"model":"m","input":"hi","reasoning":{"effort":"high"}}`, 16384},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, err := tt.beforer([]byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := before.ThinkingBudget(); got != tt.want {
+				t.Errorf("ThinkingBudget() = %d, want %d", got, tt.want)
+			}
+			// 请求体原样转发 thinking 配置不会丢失
+			if string(before.raw) != tt.body {
+				t.Error("raw body modified")
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package tracing
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	instrumentationName = "github.com/atopos31/llmio"
+	defaultServiceName  = "llmio"
+	defaultTracesPath   = "/v1/traces"
+)
+
+// Tracer 返回全局 Tracer 未启用追踪时为空实现
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Init 按配置初始化 OTLP 导出 未启用时返回空的关闭函数
+func Init(ctx context.Context, cfg *models.Tracing) (func(context.Context) error, error) {
+	if cfg == nil || !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := make([]otlptracehttp.Option, 0, 2)
+	if cfg.Endpoint != "" {
+		endpoint, err := endpointURL(cfg.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+	}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	sampler := sdktrace.AlwaysSample()
+	if cfg.SampleRatio > 0 && cfg.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(cfg.SampleRatio)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+		// 调用方已采样时跟随调用方的决定
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// endpointURL 未指定路径时补全 OTLP 默认的 traces 路径
+func endpointURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = defaultTracesPath
+	}
+	return u.String(), nil
+}
+
+// AnnotateRequest 在根 span 上记录请求的模型信息
+func AnnotateRequest(ctx context.Context, style, model string, stream bool) {
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("llmio.style", style),
+		attribute.String("llmio.model", model),
+		attribute.Bool("llmio.stream", stream),
+	)
+}
+
+// StartAttempt 为一次提供商尝试创建子 span
+func StartAttempt(ctx context.Context, provider, model string, retry int) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, "provider.attempt", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("llmio.provider", provider),
+		attribute.String("llmio.provider_model", model),
+		attribute.Int("llmio.retry", retry),
+	))
+}
+
+// FirstResponseByte 记录收到首字节的事件
+func FirstResponseByte(span trace.Span, latency time.Duration) {
+	span.AddEvent("first_response_byte", trace.WithAttributes(attribute.Int64("llmio.latency_ms", latency.Milliseconds())))
+}
+
+// EndAttempt 记录尝试结果并结束 span statusCode 为 0 表示未收到上游响应
+func EndAttempt(span trace.Span, statusCode int, latency time.Duration, err error) {
+	if statusCode > 0 {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(statusCode))
+	}
+	span.SetAttributes(attribute.Int64("llmio.latency_ms", latency.Milliseconds()))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,100 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestEndpointURL(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{"http://localhost:4318", "http://localhost:4318/v1/traces"},
+		{"http://localhost:4318/", "http://localhost:4318/v1/traces"},
+		{"https://collector.example.com/otlp/v1/traces", "https://collector.example.com/otlp/v1/traces"},
+	}
+	for _, tt := range tests {
+		got, err := endpointURL(tt.endpoint)
+		if err != nil {
+			t.Fatalf("endpointURL(%q) error = %v", tt.endpoint, err)
+		}
+		if got != tt.want {
+			t.Errorf("endpointURL(%q) = %q, want %q", tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+func TestInitDisabled(t *testing.T) {
+	shutdown, err := Init(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown() error = %v", err)
+	}
+}
+
+func TestAttemptSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	ctx, root := Tracer().Start(context.Background(), "root")
+	AnnotateRequest(ctx, "openai", "gpt-4o", true)
+
+	_, failed := StartAttempt(ctx, "primary", "gpt-4o-2024", 1)
+	EndAttempt(failed, 429, 120*time.Millisecond, errors.New("rate limited"))
+
+	_, ok := StartAttempt(ctx, "backup", "gpt-4o", 2)
+	FirstResponseByte(ok, 80*time.Millisecond)
+	EndAttempt(ok, 200, 100*time.Millisecond, nil)
+	root.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+
+	attrs := func(span sdktrace.ReadOnlySpan) map[string]any {
+		m := map[string]any{}
+		for _, kv := range span.Attributes() {
+			m[string(kv.Key)] = kv.Value.AsInterface()
+		}
+		return m
+	}
+
+	first := spans[0]
+	if first.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Error("attempt span should be a child of the root span")
+	}
+	if first.Status().Code != codes.Error {
+		t.Errorf("failed attempt status = %v, want Error", first.Status().Code)
+	}
+	if got := attrs(first); got["llmio.provider"] != "primary" || got["http.response.status_code"] != int64(429) || got["llmio.latency_ms"] != int64(120) {
+		t.Errorf("failed attempt attributes = %v", got)
+	}
+
+	second := spans[1]
+	if second.Status().Code == codes.Error {
+		t.Error("successful attempt should not be marked as error")
+	}
+	if events := second.Events(); len(events) != 1 || events[0].Name != "first_response_byte" {
+		t.Errorf("successful attempt events = %v, want first_response_byte", events)
+	}
+	if got := attrs(second); got["llmio.retry"] != int64(2) || got["llmio.provider_model"] != "gpt-4o" {
+		t.Errorf("successful attempt attributes = %v", got)
+	}
+
+	if got := attrs(spans[2]); got["llmio.model"] != "gpt-4o" || got["llmio.stream"] != true {
+		t.Errorf("root span attributes = %v", got)
+	}
+}
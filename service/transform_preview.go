@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/providers"
+	"github.com/tidwall/gjson"
+)
+
+// beforers 各接口风格的请求预处理
+var beforers = map[string]Beforer{
+	consts.StyleOpenAI:           BeforerOpenAI,
+	consts.StyleOpenAICompletion: BeforerOpenAICompletion,
+	consts.StyleOpenAIRes:        BeforerOpenAIRes,
+	consts.StyleAnthropic:        BeforerAnthropic,
+}
+
+// TransformChange 请求体中的一处变化 op 为 add remove 或 replace
+type TransformChange struct {
+	Op     string          `json:"op"`
+	Path   string          `json:"path"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// TransformPreview 请求转换前后的请求体 按路径列出变化便于对比
+type TransformPreview struct {
+	Style           string            `json:"style"`
+	Method          string            `json:"method"`
+	URL             string            `json:"url"`
+	Before          json.RawMessage   `json:"before"`
+	After           json.RawMessage   `json:"after"`
+	ClampedParams   []string          `json:"clamped_params"`   // 被模型参数策略修正的参数
+	Rejected        string            `json:"rejected"`         // 参数或上下文策略拒绝的原因 此时不生成上游请求
+	SyntheticStream bool              `json:"synthetic_stream"` // 上游不支持流式 以非流式请求转发
+	Changes         []TransformChange `json:"changes"`
+
+	TruncatedMessages int `json:"truncated_messages"` // 被上下文策略丢弃的消息条数
+}
+
+// PreviewTransform 按实际转发的处理流程生成发往关联提供商的请求体 不发送请求
+// 依次经过预处理、上下文策略、模型参数策略、非流式改写与提供商构造请求 包括模型名替换与 strip_params
+func PreviewTransform(ctx context.Context, style string, raw []byte, model models.Model, mp models.ModelWithProvider, provider models.Provider) (*TransformPreview, error) {
+	beforer, ok := beforers[style]
+	if !ok {
+		return nil, fmt.Errorf("unsupported style: %s", style)
+	}
+	if !json.Valid(raw) {
+		return nil, errors.New("request body must be valid JSON")
+	}
+	before, err := beforer(raw)
+	if err != nil {
+		return nil, err
+	}
+	preview := &TransformPreview{Style: style, Before: raw, ClampedParams: []string{}, Changes: []TransformChange{}}
+
+	before.raw, preview.TruncatedMessages, err = truncateContext(style, before.raw, model.ContextPolicy)
+	if err == nil {
+		before.raw, before.clampedParams, err = clampParams(before.raw, model.ParamPolicy)
+	}
+	if err != nil {
+		var policyErr PolicyError
+		if errors.As(err, &policyErr) {
+			preview.Rejected = policyErr.Reason
+			preview.After = raw
+			return preview, nil
+		}
+		return nil, err
+	}
+	preview.ClampedParams = append(preview.ClampedParams, before.clampedParams...)
+
+	body, syntheticStream, err := upstreamBody(style, *before, &mp)
+	if err != nil {
+		return nil, err
+	}
+	preview.SyntheticStream = syntheticStream
+	chatModel, err := providers.New(provider.Type, provider.Config)
+	if err != nil {
+		return nil, err
+	}
+	req, _, err := buildProviderReq(ctx, chatModel, http.Header{}, mp.ProviderModel, body, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	after, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	preview.Method = req.Method
+	// 不返回查询参数 其中可能带有密钥
+	preview.URL = req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+	if json.Valid(after) {
+		preview.After = after
+	} else {
+		preview.After, _ = json.Marshal(string(after))
+	}
+	preview.Changes = diffJSON(raw, preview.After)
+	return preview, nil
+}
+
+// diffJSON 按叶子路径比较两个 JSON 值 路径以点分隔 数组使用下标
+func diffJSON(before, after []byte) []TransformChange {
+	beforeLeaves, afterLeaves := jsonLeaves(gjson.ParseBytes(before)), jsonLeaves(gjson.ParseBytes(after))
+	paths := make([]string, 0, len(beforeLeaves)+len(afterLeaves))
+	for path := range beforeLeaves {
+		paths = append(paths, path)
+	}
+	for path := range afterLeaves {
+		if _, ok := beforeLeaves[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+
+	changes := make([]TransformChange, 0)
+	for _, path := range paths {
+		b, inBefore := beforeLeaves[path]
+		a, inAfter := afterLeaves[path]
+		switch {
+		case !inBefore:
+			changes = append(changes, TransformChange{Op: "add", Path: path, After: json.RawMessage(a)})
+		case !inAfter:
+			changes = append(changes, TransformChange{Op: "remove", Path: path, Before: json.RawMessage(b)})
+		case b != a:
+			changes = append(changes, TransformChange{Op: "replace", Path: path, Before: json.RawMessage(b), After: json.RawMessage(a)})
+		}
+	}
+	return changes
+}
+
+// jsonLeaves 展开 JSON 的叶子值 空对象与空数组作为叶子
+func jsonLeaves(value gjson.Result) map[string]string {
+	leaves := make(map[string]string)
+	var walk func(prefix string, v gjson.Result)
+	walk = func(prefix string, v gjson.Result) {
+		empty := v.IsObject() && len(v.Map()) == 0 || v.IsArray() && len(v.Array()) == 0
+		if !v.IsObject() && !v.IsArray() || empty {
+			leaves[prefix] = string(compactJSON([]byte(v.Raw)))
+			return
+		}
+		index := 0
+		v.ForEach(func(key, child gjson.Result) bool {
+			name := key.String()
+			if v.IsArray() {
+				name = strconv.Itoa(index)
+				index++
+			}
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			walk(name, child)
+			return true
+		})
+	}
+	walk("", value)
+	return leaves
+}
@@ -0,0 +1,26 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestDiffJSON(t *testing.T) {
+	before := `{"a":1,"b":{"c":[1,2]},"d":"x","e":{}}`
+	after := `{"a":1,"b":{"c":[1,3,4]},"e":{},"f":null}`
+	want := []TransformChange{
+		{Op: "replace", Path: "b.c.1", Before: []byte(`2`), After: []byte(`3`)},
+		{Op: "add", Path: "b.c.2", After: []byte(`4`)},
+		{Op: "remove", Path: "d", Before: []byte(`"x"`)},
+		{Op: "add", Path: "f", After: []byte(`null`)},
+	}
+	got := diffJSON([]byte(before), []byte(after))
+	if len(got) != len(want) {
+		t.Fatalf("changes = %+v, want %d", got, len(want))
+	}
+	for i := range want {
+		if got[i].Op != want[i].Op || got[i].Path != want[i].Path ||
+			string(got[i].Before) != string(want[i].Before) || string(got[i].After) != string(want[i].After) {
+			t.Errorf("change %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
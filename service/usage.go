@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+)
+
+// UsageQuery 用量查询条件 时间范围为 [Start, End)
+type UsageQuery struct {
+	Start      time.Time
+	End        time.Time
+	Width      time.Duration // 分桶宽度 桶起点按 Start 对齐
+	AuthKeyIDs []uint        // 为空时不限制
+	Models     []string      // 为空时不限制
+}
+
+// UsageRow 单个时间桶内某模型与 auth key 的用量
+type UsageRow struct {
+	Bucket            int64 // 从 Start 起的桶序号
+	Name              string
+	AuthKeyID         uint
+	Reqs              int64
+	PromptTokens      int64
+	CompletionTokens  int64
+	CachedTokens      int64
+	PromptAudioTokens int64
+}
+
+// usageAggregateSQL 按时间桶 模型与 auth key 汇总成功请求的用量 影子请求不计入
+// StatsHourly 不区分 auth key 因此直接查询 ChatLog 按 created_at 索引过滤后在一次查询中分桶
+const usageAggregateSQL = `SELECT (CAST(strftime('%s', created_at) AS INTEGER) - @start) / @width AS bucket,
+	name, auth_key_id,
+	COUNT(*) AS reqs,
+	COALESCE(SUM(prompt_tokens), 0) AS prompt_tokens,
+	COALESCE(SUM(completion_tokens), 0) AS completion_tokens,
+	COALESCE(SUM(json_extract(prompt_tokens_details, '$.cached_tokens')), 0) AS cached_tokens,
+	COALESCE(SUM(json_extract(prompt_tokens_details, '$.audio_tokens')), 0) AS prompt_audio_tokens
+FROM chat_logs
+WHERE deleted_at IS NULL AND status = 'success' AND COALESCE(shadow, 0) = 0 AND created_at >= @from AND created_at < @to
+	AND (@all_keys OR auth_key_id IN @keys) AND (@all_models OR name IN @models)
+GROUP BY bucket, name, auth_key_id
+ORDER BY bucket, name, auth_key_id`
+
+// QueryUsage 按时间桶汇总成功请求的用量 失败的重试记录不计入
+func QueryUsage(ctx context.Context, query UsageQuery) ([]UsageRow, error) {
+	keys, names := query.AuthKeyIDs, query.Models
+	// IN 不接受空列表 用占位值配合 all_* 开关跳过过滤
+	if len(keys) == 0 {
+		keys = []uint{0}
+	}
+	if len(names) == 0 {
+		names = []string{""}
+	}
+	var rows []UsageRow
+	err := models.DB.WithContext(ctx).Raw(usageAggregateSQL, map[string]any{
+		"start":      query.Start.Unix(),
+		"width":      int64(query.Width / time.Second),
+		"from":       query.Start.Local(),
+		"to":         query.End.Local(),
+		"all_keys":   len(query.AuthKeyIDs) == 0,
+		"keys":       keys,
+		"all_models": len(query.Models) == 0,
+		"models":     names,
+	}).Scan(&rows).Error
+	return rows, err
+}
@@ -0,0 +1,20 @@
+package service
+
+import (
+	"context"
+
+	"github.com/atopos31/llmio/models"
+)
+
+type usageReportKey struct{}
+
+// WithUsageReport 日志处理完成后通过返回的通道传回用量 处理失败时通道直接关闭
+func WithUsageReport(ctx context.Context) (context.Context, <-chan models.Usage) {
+	ch := make(chan models.Usage, 1)
+	return context.WithValue(ctx, usageReportKey{}, ch), ch
+}
+
+func usageReportFrom(ctx context.Context) chan models.Usage {
+	ch, _ := ctx.Value(usageReportKey{}).(chan models.Usage)
+	return ch
+}
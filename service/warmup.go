@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/providers"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultWarmupInterval 未配置时的预热间隔 小于提供商客户端的空闲连接超时 连接不会被回收
+	defaultWarmupInterval = 60 * time.Second
+	// warmupTimeout 单次预热请求的超时
+	warmupTimeout = 10 * time.Second
+)
+
+// providerActivity 全局的提供商最近请求时间 真实请求与预热都会刷新
+var providerActivity = newWarmupSchedule()
+
+// warmupSchedule 按提供商记录最近一次请求的时间 空闲超过间隔的提供商需要预热
+type warmupSchedule struct {
+	mu   sync.Mutex
+	last map[uint]time.Time
+	now  func() time.Time
+}
+
+func newWarmupSchedule() *warmupSchedule {
+	return &warmupSchedule{last: make(map[uint]time.Time), now: time.Now}
+}
+
+// touch 记录提供商的一次请求
+func (s *warmupSchedule) touch(id uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last[id] = s.now()
+}
+
+// due 提供商空闲超过间隔时返回 true 并记为已请求 避免同一轮内重复预热
+func (s *warmupSchedule) due(id uint, interval time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if last, ok := s.last[id]; ok && now.Sub(last) < interval {
+		return false
+	}
+	s.last[id] = now
+	return true
+}
+
+// providerWarmup 提供商配置中的 warmup 预热设置
+type providerWarmup struct {
+	Enabled  *bool `json:"enabled"`  // 未设置时跟随全局配置
+	Interval int   `json:"interval"` // 单位秒 未设置时使用全局间隔
+}
+
+// parseProviderWarmup 解析提供商配置中的 warmup
+func parseProviderWarmup(providerConfig string) (providerWarmup, error) {
+	var config struct {
+		Warmup providerWarmup `json:"warmup"`
+	}
+	if err := json.Unmarshal([]byte(providerConfig), &config); err != nil {
+		return providerWarmup{}, errors.New("invalid warmup config")
+	}
+	if config.Warmup.Interval < 0 {
+		return providerWarmup{}, errors.New("warmup interval must not be negative")
+	}
+	return config.Warmup, nil
+}
+
+// warmupTarget 需要预热的提供商 timeouts 为真实请求使用的各响应头超时 每个超时对应独立的连接池
+type warmupTarget struct {
+	provider models.Provider
+	warmer   providers.Warmer
+	interval time.Duration
+	timeouts []time.Duration
+}
+
+// warmupTargets 查询开启预热且有启用关联的提供商 不支持预热或配置无效的提供商跳过
+func warmupTargets(ctx context.Context, config *models.Warmup) ([]warmupTarget, error) {
+	global := models.Warmup{}
+	if config != nil {
+		global = *config
+	}
+	interval := defaultWarmupInterval
+	if global.Interval > 0 {
+		interval = time.Duration(global.Interval) * time.Second
+	}
+
+	mps, err := gorm.G[models.ModelWithProvider](models.DB).Where("status = ?", true).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(mps) == 0 {
+		return nil, nil
+	}
+	modelList, err := gorm.G[models.Model](models.DB).Where("id IN ?", lo.Uniq(lo.Map(mps, func(mp models.ModelWithProvider, _ int) uint {
+		return mp.ModelID
+	}))).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	enabledModels := make(map[uint]models.Model)
+	for _, model := range modelList {
+		if model.Enabled() {
+			enabledModels[model.ID] = model
+		}
+	}
+	providerList, err := gorm.G[models.Provider](models.DB).Where("id IN ?", lo.Uniq(lo.Map(mps, func(mp models.ModelWithProvider, _ int) uint {
+		return mp.ProviderID
+	}))).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []warmupTarget
+	for _, provider := range providerList {
+		warmup, err := parseProviderWarmup(provider.Config)
+		if err != nil {
+			slog.Warn("skip provider warmup", "provider", provider.Name, "error", err)
+			continue
+		}
+		enabled := global.Enabled
+		if warmup.Enabled != nil {
+			enabled = *warmup.Enabled
+		}
+		if !enabled {
+			continue
+		}
+		chatModel, err := providers.New(provider.Type, provider.Config)
+		if err != nil {
+			slog.Warn("skip provider warmup", "provider", provider.Name, "error", err)
+			continue
+		}
+		warmer, ok := chatModel.(providers.Warmer)
+		if !ok {
+			continue
+		}
+		providerTimeOut, err := providerDefaultTimeOut(provider.Config)
+		if err != nil {
+			slog.Warn("skip provider warmup", "provider", provider.Name, "error", err)
+			continue
+		}
+		// 与真实请求一样按关联、模型与提供商计算超时 流式与非流式的客户端不同
+		var timeouts []time.Duration
+		for _, mp := range mps {
+			model, ok := enabledModels[mp.ModelID]
+			if mp.ProviderID != provider.ID || !ok {
+				continue
+			}
+			for _, stream := range []bool{false, true} {
+				timeouts = append(timeouts, attemptTimeout(model.TimeOut, providerTimeOut, &mp, stream))
+			}
+		}
+		if len(timeouts) == 0 {
+			continue
+		}
+		timeouts = lo.Uniq(timeouts)
+		slices.Sort(timeouts)
+
+		target := warmupTarget{provider: provider, warmer: warmer, interval: interval, timeouts: timeouts}
+		if warmup.Interval > 0 {
+			target.interval = time.Duration(warmup.Interval) * time.Second
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// WarmupProviders 预热空闲超过间隔的提供商 返回本轮预热的提供商数
+// 预热结果只输出日志 不写入请求日志 也不影响冷却与限流估算
+func WarmupProviders(ctx context.Context) (int, error) {
+	config, err := LoadConfig[models.Warmup](ctx, models.KeyWarmup)
+	if err != nil {
+		return 0, err
+	}
+	targets, err := warmupTargets(ctx, config)
+	if err != nil {
+		return 0, err
+	}
+	var wg sync.WaitGroup
+	warmed := 0
+	for _, target := range targets {
+		if !providerActivity.due(target.provider.ID, target.interval) {
+			continue
+		}
+		warmed++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			warmProvider(ctx, target)
+		}()
+	}
+	wg.Wait()
+	return warmed, nil
+}
+
+// warmProvider 通过真实请求使用的各个客户端发送预热请求 使对应连接池中保留可复用的连接
+func warmProvider(ctx context.Context, target warmupTarget) {
+	for _, timeout := range target.timeouts {
+		client, err := providers.GetProviderClient(timeout, target.provider.Config)
+		if err != nil {
+			slog.Warn("provider warmup error", "provider", target.provider.Name, "error", err)
+			return
+		}
+		reqCtx, cancel := context.WithTimeout(ctx, warmupTimeout)
+		req, err := target.warmer.WarmupReq(reqCtx)
+		if err != nil {
+			cancel()
+			slog.Warn("provider warmup error", "provider", target.provider.Name, "error", err)
+			return
+		}
+		start := time.Now()
+		res, err := client.Do(req)
+		if err != nil {
+			cancel()
+			slog.Warn("provider warmup error", "provider", target.provider.Name, "timeout", timeout, "error", err)
+			continue
+		}
+		discardBody(res.Body)
+		cancel()
+		slog.Info("provider warmup", "provider", target.provider.Name, "timeout", timeout, "status", res.StatusCode, "duration", time.Since(start))
+	}
+}
+
+// RunProviderWarmup 定期检查并预热空闲的提供商 直到 ctx 结束
+func RunProviderWarmup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := WarmupProviders(ctx); err != nil {
+			slog.Error("provider warmup error", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
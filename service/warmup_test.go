@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+)
+
+func TestWarmupScheduleDue(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := newWarmupSchedule()
+	s.now = func() time.Time { return now }
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		touch   bool // 本步之前是否有真实请求
+		want    bool
+	}{
+		{"never used warms immediately", 0, false, true},
+		{"within interval after warmup", 30 * time.Second, false, false},
+		{"idle for interval", 30 * time.Second, false, true},
+		{"real traffic resets interval", 40 * time.Second, true, false},
+		{"still within interval of traffic", 59 * time.Second, false, false},
+		{"idle again after traffic", time.Second, false, true},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		if step.touch {
+			s.touch(1)
+		}
+		if got := s.due(1, time.Minute); got != step.want {
+			t.Errorf("%s: due = %v, want %v", step.name, got, step.want)
+		}
+	}
+	// 各提供商独立计算
+	if !s.due(2, time.Minute) {
+		t.Error("other provider should be due")
+	}
+}
+
+func TestWarmupProviders(t *testing.T) {
+	db := setupChatDB(t)
+	ctx := context.Background()
+	providerActivity = newWarmupSchedule()
+	t.Cleanup(func() { providerActivity = newWarmupSchedule() })
+
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method != http.MethodHead || r.Header.Get("Authorization") != "" {
+			t.Errorf("warmup request = %s with authorization %q, want keyless HEAD", r.Method, r.Header.Get("Authorization"))
+		}
+		hits[r.URL.Path]++
+	}))
+	t.Cleanup(upstream.Close)
+
+	model := models.Model{Name: "gpt", MaxRetry: 1, TimeOut: 30}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	enabled, disabled := true, false
+	for _, p := range []struct {
+		name   string
+		warmup string
+		status *bool
+	}{
+		{"default", ``, &enabled},
+		{"opt-in", `,"warmup":{"enabled":true}`, &enabled},
+		{"opt-out", `,"warmup":{"enabled":false}`, &enabled},
+		{"inactive", `,"warmup":{"enabled":true}`, &disabled},
+	} {
+		provider := models.Provider{Name: p.name, Type: consts.StyleOpenAI,
+			Config: fmt.Sprintf(`{"base_url":"%s/%s","api_key":"sk-test"%s}`, upstream.URL, p.name, p.warmup)}
+		if err := db.Create(&provider).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Create(&models.ModelWithProvider{ModelID: model.ID, ProviderID: provider.ID, ProviderModel: "gpt", Status: p.status, Weight: 1}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rounds := []struct {
+		name     string
+		config   *models.Warmup
+		wantHits map[string]int // 累计的预热次数 流式与非流式超时不同 每轮各预热一次
+	}{
+		{"disabled globally warms opt-in only", nil, map[string]int{"/opt-in": 2}},
+		{"not due within interval", nil, map[string]int{"/opt-in": 2}},
+		{"enabled globally respects opt-out", &models.Warmup{Enabled: true}, map[string]int{"/opt-in": 2, "/default": 2}},
+	}
+	for _, round := range rounds {
+		if round.config != nil {
+			if err := SaveConfig(ctx, models.KeyWarmup, *round.config); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := WarmupProviders(ctx); err != nil {
+			t.Fatalf("%s: WarmupProviders() error = %v", round.name, err)
+		}
+		mu.Lock()
+		if fmt.Sprint(hits) != fmt.Sprint(round.wantHits) {
+			t.Errorf("%s: hits = %v, want %v", round.name, hits, round.wantHits)
+		}
+		mu.Unlock()
+	}
+
+	// 预热不写入请求日志
+	var count int64
+	db.Model(&models.ChatLog{}).Count(&count)
+	if count != 0 {
+		t.Errorf("chat logs = %d, want warmups kept out of request logs", count)
+	}
+}